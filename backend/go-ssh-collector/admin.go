@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理监听：/admin/* 等运维接口只在 ADMIN_LISTEN_ADDRS 指定的独立监听上提供（地址格式同LISTEN_ADDRS），
+// 以便用网络策略单独隔离。未配置时这些接口不存在，主监听上返回404。
+// ADMIN_AUTH 设置管理监听的认证方式：
+//   api   与主监听相同的API Key/JWT/客户端证书，要求admin角色（默认）
+//   token 使用 ADMIN_TOKEN 作为Bearer令牌或X-API-Key
+//   none  不认证，只应用于权限受限的Unix socket或本地回环地址
+// ADMIN_INSECURE_HTTP=true 时管理监听不使用TLS
+
+var (
+	adminListenAddrs   = splitList(getEnv("ADMIN_LISTEN_ADDRS", ""))
+	adminAuthMode      = getEnv("ADMIN_AUTH", "api")
+	adminToken         = getEnv("ADMIN_TOKEN", "")
+	adminInsecureHTTP  = os.Getenv("ADMIN_INSECURE_HTTP") == "true"
+	errAdminAuthFailed = errors.New("invalid or missing admin credentials")
+)
+
+// adminPrincipal token/none模式下管理请求的调用方
+var adminPrincipal = &Principal{Name: "admin-listener", Role: roleAdmin, Admin: true, Method: "admin_listener"}
+
+// registerAdminRoutes 只在管理监听上注册
+func registerAdminRoutes(r *gin.Engine) {
+	registerAuthRoutes(r)
+	registerRateLimitRoutes(r)
+	registerDestinationPolicyRoutes(r)
+	registerLogRoutes(r)
+	registerAuditRoutes(r)
+	registerDebugRoutes(r)
+	registerSyslogForwardRoutes(r)
+	registerSinkRoutes(r)
+	registerOutputLogRoutes(r)
+}
+
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch adminAuthMode {
+		case "none":
+			setPrincipal(c, adminPrincipal)
+		case "token":
+			credential := []byte(requestAPIKey(c))
+			if adminToken == "" || subtle.ConstantTimeCompare(credential, []byte(adminToken)) != 1 {
+				forwardAuthFailure("admin", c.ClientIP(), c.Request.Method, c.Request.URL.Path, errAdminAuthFailed.Error())
+				respondError(c, http.StatusUnauthorized, errAdminAuthFailed)
+				return
+			}
+			setPrincipal(c, adminPrincipal)
+		default:
+			// 未启用认证时没有调用方，requireAdmin拒绝访问，与原先主监听上的行为一致
+			if !authEnabled() {
+				c.Next()
+				return
+			}
+			p, err := authenticate(c.Request.TLS, requestAPIKey(c), c.Request.Method, c.Request.URL.Path)
+			if err != nil {
+				respondAuthError(c, err)
+				return
+			}
+			setPrincipal(c, p)
+		}
+		c.Next()
+	}
+}
+
+// newAdminRouter 管理监听的中间件和路由
+func newAdminRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(requestIDMiddleware(), accessLogMiddleware(), gin.CustomRecoveryWithWriter(panicLogWriter{}, recoveryHandler))
+	r.Use(adminAuthMiddleware(), auditMiddleware("admin"))
+	registerAdminRoutes(r)
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, errors.New("route not found"))
+	})
+	return r
+}
+
+// newAdminServer 未配置ADMIN_LISTEN_ADDRS时返回nil
+func newAdminServer(tlsConfig *tls.Config) (*http.Server, []net.Listener) {
+	if len(adminListenAddrs) == 0 {
+		return nil, nil
+	}
+	switch adminAuthMode {
+	case "api", "none":
+	case "token":
+		if adminToken == "" {
+			logFatal("admin.config_invalid", "ADMIN_AUTH=token requires ADMIN_TOKEN")
+		}
+	default:
+		logFatal("admin.config_invalid", "invalid ADMIN_AUTH (expected api, token or none)", "admin_auth", adminAuthMode)
+	}
+	listeners, err := openListeners(adminListenAddrs)
+	if err != nil {
+		logFatal("admin.listen_failed", "admin listener failed", "error", err)
+	}
+
+	srv := &http.Server{Handler: versionedHandler(newAdminRouter())}
+	if !adminInsecureHTTP {
+		srv.TLSConfig = tlsConfig
+	}
+	return srv, listeners
+}
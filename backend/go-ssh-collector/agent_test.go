@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startLocalAgent 在临时unix socket上提供空的内存agent，并设置SSH_AUTH_SOCK
+func startLocalAgent(t *testing.T) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
+
+func startAgentTestServer(t *testing.T, grant bool) (*testSSHServer, string) {
+	t.Helper()
+	startLocalAgent(t)
+	s := startTestSSHServer(t)
+	s.grantAgent = grant
+	s.exec = func(command string, stdout io.Writer) int {
+		io.WriteString(stdout, "ok\n")
+		return 0
+	}
+	return s, connectTestSSH(t, s)
+}
+
+func TestAgentForwardingGranted(t *testing.T) {
+	s, id := startAgentTestServer(t, true)
+
+	result, err := collector.Execute(CommandRequest{ConnectionID: id, Command: "ssh-add -l", ForwardAgent: true})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if result.AgentForwarded == nil || !*result.AgentForwarded {
+		t.Fatalf("agent_forwarded = %v, want true", result.AgentForwarded)
+	}
+	if n := atomic.LoadInt64(&s.agentRequests); n != 1 {
+		t.Fatalf("agent requests = %d, want 1", n)
+	}
+}
+
+func TestAgentForwardingDeniedStillRunsCommand(t *testing.T) {
+	_, id := startAgentTestServer(t, false)
+
+	result, err := collector.Execute(CommandRequest{ConnectionID: id, Command: "uptime", ForwardAgent: true})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if result.AgentForwarded == nil || *result.AgentForwarded {
+		t.Fatalf("agent_forwarded = %v, want false", result.AgentForwarded)
+	}
+	if result.ExitCode == nil || *result.ExitCode != 0 || result.Output != "ok\n" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestAgentForwardingNotRequested(t *testing.T) {
+	s, id := startAgentTestServer(t, true)
+
+	result, err := collector.Execute(CommandRequest{ConnectionID: id, Command: "uptime"})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if result.AgentForwarded != nil {
+		t.Fatalf("agent_forwarded = %v, want omitted", *result.AgentForwarded)
+	}
+	if n := atomic.LoadInt64(&s.agentRequests); n != 0 {
+		t.Fatalf("agent requests = %d, want 0", n)
+	}
+}
+
+func TestAgentForwardingDisabledByPolicy(t *testing.T) {
+	s, id := startAgentTestServer(t, true)
+	agentForwardingDisabled = true
+	t.Cleanup(func() { agentForwardingDisabled = false })
+
+	_, err := collector.Execute(CommandRequest{ConnectionID: id, Command: "uptime", ForwardAgent: true})
+	if !errors.Is(err, errAgentForwardingDisabled) {
+		t.Fatalf("err = %v, want errAgentForwardingDisabled", err)
+	}
+	if n := atomic.LoadInt64(&s.agentRequests); n != 0 {
+		t.Fatalf("agent requests = %d, want 0", n)
+	}
+
+	// 连接级别的forward_agent同样被拒绝
+	config := s.Config()
+	config.ForwardAgent = true
+	if _, err := collector.Connect(config); !errors.Is(err, errAgentForwardingDisabled) {
+		t.Fatalf("connect err = %v, want errAgentForwardingDisabled", err)
+	}
+}
+
+func TestAgentForwardingRequiresSSHAuthSock(t *testing.T) {
+	_, id := startAgentTestServer(t, true)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	if _, err := collector.Execute(CommandRequest{ConnectionID: id, Command: "uptime", ForwardAgent: true}); err == nil {
+		t.Fatal("expected an error without SSH_AUTH_SOCK")
+	}
+}
+
+// agent转发只作用于本连接上的exec会话：作为via_connection_id的跳板时，
+// direct-tcpip隧道不请求也不携带agent，下一跳的认证不会用到本地agent
+func TestAgentForwardingIsPerHop(t *testing.T) {
+	startLocalAgent(t)
+	s := startTestSSHServer(t)
+	s.grantAgent = true
+	s.exec = func(command string, stdout io.Writer) int { return 0 }
+	s.dial = func(address string) (net.Conn, error) { return net.Dial("tcp", address) }
+	allowLoopbackDestinations(t)
+	config := s.Config()
+	config.ForwardAgent = true
+	via, err := collector.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { collector.Disconnect(via) })
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(backend.Close)
+	hc, err := newHTTPConnection(HTTPEndpointConfig{BaseURL: backend.URL, ViaConnectionID: via})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := hc.Do(context.Background(), HTTPRequest{Path: "/"})
+	if err != nil || result.Status != http.StatusOK {
+		t.Fatalf("request via %s: %+v, %v", via, result, err)
+	}
+	if n := atomic.LoadInt64(&s.agentRequests); n != 0 {
+		t.Fatalf("agent requests after tunnelled request = %d, want 0", n)
+	}
+
+	// 同一连接上的命令仍然转发agent
+	executed, err := collector.Execute(CommandRequest{ConnectionID: via, Command: "ssh next-hop uptime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executed.AgentForwarded == nil || !*executed.AgentForwarded || atomic.LoadInt64(&s.agentRequests) != 1 {
+		t.Fatalf("agent_forwarded = %v, requests %d", executed.AgentForwarded, atomic.LoadInt64(&s.agentRequests))
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 告警规则：作用于指标提取规则或脚本emit_metric产生的序列，例如 metric=cpu_usage op=> threshold=90 for=3
+// 表示同一序列连续3次采集大于90时触发。每个序列（标签签名）单独计数，状态为 ok -> pending -> firing，
+// 条件不再满足时恢复为ok。触发和恢复以 alert_firing / alert_resolved 事件发布到事件总线，
+// 经由总线转发到webhook等sinks。静默窗口内照常计算状态，只是不发布事件。
+// 设置 ALERT_STATE_FILE 时规则、静默和各序列状态保存在该文件中，重启后继续计数；
+// 连续次数只在状态变化时写入，重启后pending序列的计数可能少于实际次数
+
+const (
+	alertOK      = "ok"
+	alertPending = "pending"
+	alertFiring  = "firing"
+)
+
+var (
+	errAlertRuleNotFound = errors.New("alert rule not found")
+	errInvalidAlertRule  = errors.New("invalid alert rule")
+	alertStateFile       = getEnv("ALERT_STATE_FILE", "")
+)
+
+type AlertSilence struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end" binding:"required"`
+	Reason string    `json:"reason,omitempty"`
+	// CreatedBy 创建静默的调用方，由服务端填写
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+func (s AlertSilence) active(now time.Time) bool {
+	return !now.Before(s.Start) && now.Before(s.End)
+}
+
+type AlertRule struct {
+	Name string `json:"name"`
+	// Metric 指标规则名或脚本指标名
+	Metric    string  `json:"metric" binding:"required"`
+	Op        string  `json:"op" binding:"required"`
+	Threshold float64 `json:"threshold"`
+	// For 连续满足条件的采集次数，默认1
+	For int `json:"for,omitempty"`
+	// Match 只作用于标签值相等的序列，可包含connection_id和host
+	Match    map[string]string `json:"match,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+	Silences []AlertSilence    `json:"silences,omitempty"`
+}
+
+func (rule *AlertRule) validate() error {
+	if rule.Metric == "" {
+		return fmt.Errorf("%w: metric is required", errInvalidAlertRule)
+	}
+	switch rule.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("%w: op must be one of > >= < <= == !=", errInvalidAlertRule)
+	}
+	if rule.For < 0 {
+		return fmt.Errorf("%w: for must not be negative", errInvalidAlertRule)
+	}
+	if rule.For == 0 {
+		rule.For = 1
+	}
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	return nil
+}
+
+func (rule *AlertRule) breached(value float64) bool {
+	switch rule.Op {
+	case ">":
+		return value > rule.Threshold
+	case ">=":
+		return value >= rule.Threshold
+	case "<":
+		return value < rule.Threshold
+	case "<=":
+		return value <= rule.Threshold
+	case "==":
+		return value == rule.Threshold
+	case "!=":
+		return value != rule.Threshold
+	}
+	return false
+}
+
+func (rule *AlertRule) matches(labels map[string]string) bool {
+	for key, value := range rule.Match {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *AlertRule) silenced(now time.Time) bool {
+	for _, silence := range rule.Silences {
+		if silence.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneSilences 删除已结束的静默，调用方需持有锁
+func (rule *AlertRule) pruneSilences(now time.Time) {
+	var kept []AlertSilence
+	for _, silence := range rule.Silences {
+		if now.Before(silence.End) {
+			kept = append(kept, silence)
+		}
+	}
+	rule.Silences = kept
+}
+
+// AlertState 一个规则下一个序列的状态
+type AlertState struct {
+	Rule        string            `json:"rule"`
+	Labels      map[string]string `json:"labels"`
+	State       string            `json:"state"`
+	Value       float64           `json:"value"`
+	Consecutive int               `json:"consecutive"`
+	// Since 进入当前状态的时间
+	Since         time.Time  `json:"since"`
+	FiredAt       *time.Time `json:"fired_at,omitempty"`
+	LastEvaluated time.Time  `json:"last_evaluated"`
+	// Silenced 最近一次状态变化发生在静默窗口内，事件未发布
+	Silenced bool `json:"silenced,omitempty"`
+}
+
+// alertSample 一次采集得到的值，来自指标规则或脚本指标
+type alertSample struct {
+	metric string
+	labels map[string]string
+	value  float64
+}
+
+type AlertRegistry struct {
+	rules  map[string]*AlertRule
+	states map[string]map[string]*AlertState // 规则名 -> 标签签名 -> 状态
+	fired  map[string]uint64
+	mutex  sync.Mutex
+}
+
+var alerts = newAlertRegistry()
+
+// alertStore ALERT_STATE_FILE 的内容
+type alertStore struct {
+	Rules  []*AlertRule  `json:"rules"`
+	States []*AlertState `json:"states"`
+}
+
+func newAlertRegistry() *AlertRegistry {
+	ar := &AlertRegistry{
+		rules:  make(map[string]*AlertRule),
+		states: make(map[string]map[string]*AlertState),
+		fired:  make(map[string]uint64),
+	}
+	if alertStateFile == "" {
+		return ar
+	}
+	data, err := os.ReadFile(alertStateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("alert.state_load_failed", "load alert state failed", "file", alertStateFile, "error", err)
+		}
+		return ar
+	}
+	var store alertStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		logError("alert.state_load_failed", "load alert state failed", "file", alertStateFile, "error", err)
+		return ar
+	}
+	for _, rule := range store.Rules {
+		if err := rule.validate(); err != nil {
+			logError("alert.rule_load_failed", "load alert rule failed", "rule", rule.Name, "error", err)
+			continue
+		}
+		ar.rules[rule.Name] = rule
+	}
+	for _, state := range store.States {
+		if ar.rules[state.Rule] == nil {
+			continue
+		}
+		if ar.states[state.Rule] == nil {
+			ar.states[state.Rule] = make(map[string]*AlertState)
+		}
+		ar.states[state.Rule][signature(state.Labels)] = state
+	}
+	return ar
+}
+
+// save 调用方需持有锁，写入失败只记录日志
+func (ar *AlertRegistry) save() {
+	if alertStateFile == "" {
+		return
+	}
+	store := alertStore{Rules: ar.listRules(), States: ar.listStates("")}
+	data, err := json.Marshal(store)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(alertStateFile), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(alertStateFile+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(alertStateFile+".tmp", alertStateFile)
+	}
+	if err != nil {
+		logError("alert.state_save_failed", "save alert state failed", "file", alertStateFile, "error", err)
+	}
+}
+
+func (ar *AlertRegistry) Put(rule AlertRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	// 替换规则时保留静默，条件可能已变化，序列重新计数
+	if old, ok := ar.rules[rule.Name]; ok && rule.Silences == nil {
+		rule.Silences = old.Silences
+	}
+	rule.pruneSilences(time.Now())
+	ar.rules[rule.Name] = &rule
+	delete(ar.states, rule.Name)
+	ar.save()
+	return nil
+}
+
+func (ar *AlertRegistry) Remove(name string) error {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	if _, ok := ar.rules[name]; !ok {
+		return errAlertRuleNotFound
+	}
+	delete(ar.rules, name)
+	delete(ar.states, name)
+	ar.save()
+	return nil
+}
+
+func (ar *AlertRegistry) Silence(name string, silence AlertSilence) (*AlertRule, error) {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	rule, ok := ar.rules[name]
+	if !ok {
+		return nil, errAlertRuleNotFound
+	}
+	now := time.Now()
+	if silence.Start.IsZero() {
+		silence.Start = now
+	}
+	if !silence.End.After(silence.Start) || !silence.End.After(now) {
+		return nil, fmt.Errorf("%w: silence must end after its start and in the future", errInvalidAlertRule)
+	}
+	rule.pruneSilences(now)
+	rule.Silences = append(rule.Silences, silence)
+	ar.save()
+	copied := *rule
+	return &copied, nil
+}
+
+func (ar *AlertRegistry) Unsilence(name string) error {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	rule, ok := ar.rules[name]
+	if !ok {
+		return errAlertRuleNotFound
+	}
+	rule.Silences = nil
+	ar.save()
+	return nil
+}
+
+func (ar *AlertRegistry) Rules() []*AlertRule {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	return ar.listRules()
+}
+
+// listRules 调用方需持有锁
+func (ar *AlertRegistry) listRules() []*AlertRule {
+	list := make([]*AlertRule, 0, len(ar.rules))
+	for _, rule := range ar.rules {
+		copied := *rule
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// States 按规则名过滤（为空表示全部），state为空表示全部状态
+func (ar *AlertRegistry) States(rule, state string) []*AlertState {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	list := []*AlertState{}
+	for _, s := range ar.listStates(rule) {
+		if state == "" || s.State == state {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// listStates 调用方需持有锁
+func (ar *AlertRegistry) listStates(rule string) []*AlertState {
+	list := []*AlertState{}
+	for name, states := range ar.states {
+		if rule != "" && name != rule {
+			continue
+		}
+		for _, state := range states {
+			copied := *state
+			list = append(list, &copied)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rule != list[j].Rule {
+			return list[i].Rule < list[j].Rule
+		}
+		return signature(list[i].Labels) < signature(list[j].Labels)
+	})
+	return list
+}
+
+// Evaluate 在指标登记完成之后调用，不能持有MetricRegistry的锁
+func (ar *AlertRegistry) Evaluate(samples []alertSample) {
+	if len(samples) == 0 {
+		return
+	}
+	var pending []LifecycleEvent
+	now := time.Now()
+	ar.mutex.Lock()
+	changed := false
+	for _, sample := range samples {
+		for name, rule := range ar.rules {
+			if rule.Metric != sample.metric || !rule.matches(sample.labels) {
+				continue
+			}
+			if event, ok := ar.evaluate(rule, sample, now); ok {
+				changed = true
+				if event.Type != "" {
+					pending = append(pending, event)
+				}
+				if event.Type == eventAlertFiring {
+					ar.fired[name]++
+				}
+			}
+		}
+	}
+	if changed {
+		ar.save()
+	}
+	ar.mutex.Unlock()
+
+	for _, event := range pending {
+		event.Namespace = collector.namespace(event.ConnectionID)
+		events.Publish(event)
+	}
+}
+
+// evaluate 更新一个序列的状态，状态变化时返回true，需要发布时同时返回事件；调用方需持有锁
+func (ar *AlertRegistry) evaluate(rule *AlertRule, sample alertSample, now time.Time) (LifecycleEvent, bool) {
+	key := signature(sample.labels)
+	if ar.states[rule.Name] == nil {
+		ar.states[rule.Name] = make(map[string]*AlertState)
+	}
+	state, ok := ar.states[rule.Name][key]
+	if !ok {
+		state = &AlertState{Rule: rule.Name, Labels: sample.labels, State: alertOK, Since: now}
+		ar.states[rule.Name][key] = state
+	}
+	state.Value, state.LastEvaluated = sample.value, now
+
+	previous := state.State
+	if rule.breached(sample.value) {
+		state.Consecutive++
+		if state.State == alertOK {
+			state.State, state.Since = alertPending, now
+		}
+		if state.State == alertPending && state.Consecutive >= rule.For {
+			state.State, state.Since = alertFiring, now
+			fired := now
+			state.FiredAt = &fired
+		}
+	} else {
+		state.Consecutive = 0
+		if state.State != alertOK {
+			state.State, state.Since = alertOK, now
+		}
+	}
+	if state.State == previous {
+		return LifecycleEvent{}, false
+	}
+
+	var eventType string
+	switch {
+	case state.State == alertFiring:
+		eventType = eventAlertFiring
+	case previous == alertFiring:
+		eventType = eventAlertResolved
+	default:
+		// ok与pending之间的变化不发布
+		return LifecycleEvent{}, true
+	}
+	state.Silenced = rule.silenced(now)
+	logInfo("alert.state_changed", "alert state changed", "rule", rule.Name, "state", state.State, "value", sample.value,
+		"connection_id", sample.labels["connection_id"], "host", sample.labels["host"], "silenced", state.Silenced)
+	if state.Silenced {
+		return LifecycleEvent{}, true
+	}
+	data := map[string]interface{}{
+		"rule":        rule.Name,
+		"metric":      rule.Metric,
+		"severity":    rule.Severity,
+		"condition":   rule.Op + " " + strconv.FormatFloat(rule.Threshold, 'g', -1, 64),
+		"for":         rule.For,
+		"value":       sample.value,
+		"labels":      sample.labels,
+		"consecutive": state.Consecutive,
+	}
+	if rule.Summary != "" {
+		data["summary"] = rule.Summary
+	}
+	if eventType == eventAlertResolved && state.FiredAt != nil {
+		data["fired_at"] = *state.FiredAt
+		data["duration_ms"] = durationMS(now.Sub(*state.FiredAt))
+	}
+	return LifecycleEvent{
+		Type:         eventType,
+		ConnectionID: sample.labels["connection_id"],
+		Host:         sample.labels["host"],
+		Data:         data,
+		Timestamp:    now,
+	}, true
+}
+
+func (ar *AlertRegistry) WriteMetrics(w io.Writer) {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	names := make([]string, 0, len(ar.rules))
+	for name := range ar.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := metricWriter{w}
+	out.family("collector_alerts", "gauge", "Series per alert rule and state.")
+	for _, name := range names {
+		counts := map[string]int{alertOK: 0, alertPending: 0, alertFiring: 0}
+		for _, state := range ar.states[name] {
+			counts[state.State]++
+		}
+		for _, state := range []string{alertOK, alertPending, alertFiring} {
+			out.sample("collector_alerts", float64(counts[state]), "rule", name, "state", state)
+		}
+	}
+	out.family("collector_alerts_fired_total", "counter", "Transitions to firing per alert rule, including silenced ones.")
+	for _, name := range names {
+		out.sample("collector_alerts_fired_total", float64(ar.fired[name]), "rule", name)
+	}
+}
+
+func registerAlertRoutes(r *gin.Engine) {
+	r.GET("/alerts", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"alerts": alerts.States(c.Query("rule"), c.Query("state"))})
+	})
+
+	r.GET("/alerts/rules", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rules": alerts.Rules()})
+	})
+
+	r.PUT("/alerts/rules/:name", func(c *gin.Context) {
+		var rule AlertRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		rule.Name = c.Param("name")
+		if err := alerts.Put(rule); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	})
+
+	r.DELETE("/alerts/rules/:name", func(c *gin.Context) {
+		if err := alerts.Remove(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "alert rule removed"})
+	})
+
+	r.POST("/alerts/rules/:name/silences", func(c *gin.Context) {
+		var silence AlertSilence
+		if err := c.ShouldBindJSON(&silence); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		silence.CreatedBy = principalName(c)
+		rule, err := alerts.Silence(c.Param("name"), silence)
+		if errors.Is(err, errAlertRuleNotFound) {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	})
+
+	r.DELETE("/alerts/rules/:name/silences", func(c *gin.Context) {
+		if err := alerts.Unsilence(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "alert silences removed"})
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API版本：路由只注册一次，/api/<版本>/ 前缀在进入gin之前剥离并记录版本，
+// 响应结构按版本选择序列化函数。未带前缀的旧路径作为v1的废弃别名继续可用
+
+const currentAPIVersion = "v1"
+
+type apiVersionKey struct{}
+
+// APIVersion 各版本对响应结构的序列化，新版本修改CommandResult时在此注册
+type APIVersion struct {
+	Name string
+	// CommandResult unmasked为true时需附带脱敏前输出
+	CommandResult func(result *CommandResult, unmasked bool) interface{}
+	ResultRecord  func(record ResultRecord) interface{}
+}
+
+var apiVersions = map[string]*APIVersion{
+	"v1": {
+		Name:          "v1",
+		CommandResult: v1CommandResult,
+		ResultRecord:  func(record ResultRecord) interface{} { return record },
+	},
+}
+
+// v1CommandResult 固定v1的响应结构，修改CommandResult的JSON字段前需为v1保留原样
+func v1CommandResult(result *CommandResult, unmasked bool) interface{} {
+	if !unmasked {
+		return result
+	}
+	return struct {
+		*CommandResult
+		UnmaskedOutput string `json:"unmasked_output"`
+	}{result, result.unmasked}
+}
+
+// 不属于版本化API的基础设施路径，不加废弃标记
+var unversionedPaths = map[string]bool{
+	"/health":       true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/metrics":      true,
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// 非空时作为旧路径的Sunset响应头（HTTP日期）
+var legacyAPISunset = getEnv("LEGACY_API_SUNSET", "")
+
+// versionedHandler 剥离版本前缀后交给gin处理，旧路径附加Deprecation头
+func versionedHandler(engine *gin.Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		if strings.HasPrefix(path, "/api/") {
+			name, rest, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+			if version, ok := apiVersions[name]; ok {
+				req.URL.Path = "/" + rest
+				if req.URL.RawPath != "" {
+					req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, "/api/"+name)
+				}
+				engine.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), apiVersionKey{}, version)))
+				return
+			}
+		}
+		if !unversionedPaths[path] {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "</api/"+currentAPIVersion+path+">; rel=\"successor-version\"")
+			if legacyAPISunset != "" {
+				w.Header().Set("Sunset", legacyAPISunset)
+			}
+		}
+		engine.ServeHTTP(w, req)
+	})
+}
+
+// requestAPIVersion 旧路径按v1处理
+func requestAPIVersion(c *gin.Context) *APIVersion {
+	if version, ok := c.Request.Context().Value(apiVersionKey{}).(*APIVersion); ok {
+		return version
+	}
+	return apiVersions["v1"]
+}
+
+// renderCommandResult 按请求的API版本输出执行结果
+func renderCommandResult(c *gin.Context, result *CommandResult, unmasked bool) {
+	c.JSON(http.StatusOK, requestAPIVersion(c).CommandResult(result, unmasked))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// assertContract testdata/contract/v1 固定v1响应的JSON结构，修改后需确认不会破坏v1客户端，
+// -update 重新生成
+func assertContract(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", "contract", "v1", name+".json")
+	if *updateFixtures {
+		var v interface{}
+		json.Unmarshal(got, &v)
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(v)
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("missing contract: %v", err)
+	}
+	assertSameJSON(t, got, expected)
+}
+
+// normalizeVolatile 把每次请求都不同的字段替换为固定值
+func normalizeVolatile(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				switch key {
+				case "id", "request_id", "timestamp", "connection_id", "host":
+					if _, ok := value.(string); ok {
+						v[key] = "<" + key + ">"
+						continue
+					}
+				}
+				walk(value)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(v)
+	out, _ := json.Marshal(v)
+	return out
+}
+
+func TestV1CommandResultShape(t *testing.T) {
+	exitCode, forwarded := 1, true
+	result := &CommandResult{
+		ID: "r1", Command: "show version", Output: "out", Stderr: "err", ExitCode: &exitCode, Truncated: true,
+		AgentForwarded: &forwarded, Parsed: []interface{}{map[string]interface{}{"VERSION": "1"}}, ParseError: "pe",
+		ParseSchema: "s", ParseSkipped: "ps", Unparsed: []string{"u"}, UnparsedCount: 1,
+		Fields: map[string]interface{}{"f": "v"}, Result: "r", TransformError: "te", ScriptResult: 1,
+		ScriptMetrics: []ScriptMetric{{Name: "m", Value: 1, Labels: map[string]string{"l": "v"}}},
+		ScriptEvents:  []ScriptEvent{{Type: "t", Message: "m", Fields: map[string]interface{}{"k": 1}}},
+		ScriptError:   "se", Error: "e", ErrorClass: "command_timeout",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), RequestID: "req", FromCache: true,
+		unmasked: "raw",
+	}
+	v1 := apiVersions["v1"]
+
+	data, _ := json.Marshal(v1.CommandResult(result, false))
+	assertContract(t, "command_result", data)
+	data, _ = json.Marshal(v1.CommandResult(result, true))
+	assertContract(t, "command_result_unmasked", data)
+
+	// 可选字段为空时省略
+	data, _ = json.Marshal(v1.CommandResult(&CommandResult{Command: "uptime", Timestamp: result.Timestamp}, false))
+	assertContract(t, "command_result_minimal", data)
+}
+
+func TestV1ExecuteContract(t *testing.T) {
+	s := startTestSSHServer(t)
+	s.exec = func(command string, stdout io.Writer) int {
+		io.WriteString(stdout, "up 3 days\n")
+		return 0
+	}
+	id := connectTestSSH(t, s)
+	handler := versionedHandler(newRouter())
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v1/execute", `{"connection_id":"`+id+`","command":"uptime"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", w.Code, w.Body.String())
+	}
+	assertContract(t, "execute", normalizeVolatile(t, w.Body.Bytes()))
+
+	// 存储的结果也属于v1契约
+	var result struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/api/v1/results/"+result.ID, nil))
+	if get.Code != http.StatusOK {
+		t.Fatalf("result: status %d: %s", get.Code, get.Body.String())
+	}
+	assertContract(t, "result_record", normalizeVolatile(t, get.Body.Bytes()))
+
+	missing := httptest.NewRecorder()
+	handler.ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/api/v1/connections/missing", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("missing connection: status %d", missing.Code)
+	}
+	assertContract(t, "error", normalizeVolatile(t, missing.Body.Bytes()))
+}
+
+func TestVersionedPathsAndLegacyAliases(t *testing.T) {
+	handler := versionedHandler(newRouter())
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/api/v1/connections")
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+		t.Fatalf("v1: status %d, headers %v", w.Code, w.Header())
+	}
+	legacy := get("/connections")
+	if legacy.Code != http.StatusOK || legacy.Header().Get("Deprecation") != "true" {
+		t.Fatalf("legacy: status %d, headers %v", legacy.Code, legacy.Header())
+	}
+	if link := legacy.Header().Get("Link"); link != `</api/v1/connections>; rel="successor-version"` {
+		t.Fatalf("Link = %q", link)
+	}
+	// 新旧路径返回相同的结构
+	assertSameJSON(t, normalizeVolatile(t, legacy.Body.Bytes()), normalizeVolatile(t, w.Body.Bytes()))
+
+	if w := get("/health"); w.Header().Get("Deprecation") != "" {
+		t.Fatalf("infrastructure path marked deprecated: %v", w.Header())
+	}
+	if w := get("/api/v9/connections"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown version: status %d", w.Code)
+	}
+
+	previous := legacyAPISunset
+	legacyAPISunset = "Sat, 01 Mar 2025 00:00:00 GMT"
+	t.Cleanup(func() { legacyAPISunset = previous })
+	if w := get("/connections"); w.Header().Get("Sunset") != legacyAPISunset {
+		t.Fatalf("Sunset = %q", w.Header().Get("Sunset"))
+	}
+}
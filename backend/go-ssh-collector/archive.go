@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
+)
+
+const (
+	defaultArchiveMaxFiles = 1000
+	manifestName           = "MANIFEST.json"
+)
+
+type ArchiveRequest struct {
+	Paths         []string `json:"paths" binding:"required,min=1"`
+	Format        string   `json:"format"`
+	MaxFiles      int      `json:"max_files"`
+	MaxTotalBytes int64    `json:"max_total_bytes"`
+}
+
+type ArchiveEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+type ArchiveManifest struct {
+	ConnectionID string         `json:"connection_id"`
+	Files        []ArchiveEntry `json:"files"`
+	Errors       []ArchiveEntry `json:"errors"`
+	Timestamp    time.Time      `json:"timestamp"`
+}
+
+type archiveFile struct {
+	path string
+	// name 归档中的条目名，相对于通配符的基础目录
+	name string
+	size int64
+	mode os.FileMode
+	mod  time.Time
+}
+
+// globBase 通配符之前的目录部分，不含通配符时为所在目录
+func globBase(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "*?[\\") {
+			if base := strings.Join(segments[:i], "/"); base != "" || !strings.HasPrefix(pattern, "/") {
+				return path.Clean(base)
+			}
+			return "/"
+		}
+	}
+	return path.Dir(pattern)
+}
+
+// archiveEntryName 清理路径并取相对于基础目录的部分，仍指向基础目录之外的条目被拒绝，
+// 避免解压时写到目标目录之外
+func archiveEntryName(base, match string) (string, error) {
+	match = path.Clean(match)
+	var name string
+	switch base {
+	case ".":
+		name = match
+	case "/":
+		name = strings.TrimPrefix(match, "/")
+	default:
+		if !strings.HasPrefix(match, base+"/") {
+			return "", fmt.Errorf("path is outside %s", base)
+		}
+		name = strings.TrimPrefix(match, base+"/")
+	}
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+		return "", errors.New("path escapes the archive root")
+	}
+	return name, nil
+}
+
+// expandArchivePaths 通过SFTP展开通配符，目录与不存在的路径记入错误列表
+func expandArchivePaths(client *sftp.Client, patterns []string) ([]archiveFile, []ArchiveEntry) {
+	var files []archiveFile
+	var errs []ArchiveEntry
+	seen := make(map[string]bool)
+	names := map[string]bool{manifestName: true}
+
+	for _, pattern := range patterns {
+		matches, err := client.Glob(pattern)
+		if err != nil {
+			errs = append(errs, ArchiveEntry{Path: pattern, Error: err.Error()})
+			continue
+		}
+		if len(matches) == 0 {
+			errs = append(errs, ArchiveEntry{Path: pattern, Error: "no such file"})
+			continue
+		}
+
+		base := globBase(path.Clean(pattern))
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+
+			name, err := archiveEntryName(base, match)
+			if err != nil {
+				errs = append(errs, ArchiveEntry{Path: match, Error: err.Error()})
+				continue
+			}
+			if names[name] {
+				errs = append(errs, ArchiveEntry{Path: match, Error: "duplicate archive entry " + name})
+				continue
+			}
+
+			info, err := client.Stat(match)
+			if err != nil {
+				errs = append(errs, ArchiveEntry{Path: match, Error: err.Error()})
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				errs = append(errs, ArchiveEntry{Path: match, Error: "not a regular file"})
+				continue
+			}
+			names[name] = true
+			files = append(files, archiveFile{path: match, name: name, size: info.Size(), mode: info.Mode(), mod: info.ModTime()})
+		}
+	}
+	return files, errs
+}
+
+// archiveWriter 屏蔽tar.gz与zip的差异
+type archiveWriter interface {
+	// WriteFile 写入一个文件，返回实际读取的字节数
+	WriteFile(file archiveFile, src io.Reader) (int64, error)
+	Close() error
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a *tarGzWriter) WriteFile(file archiveFile, src io.Reader) (int64, error) {
+	header := &tar.Header{
+		Name:    file.name,
+		Mode:    int64(file.mode.Perm()),
+		Size:    file.size,
+		ModTime: file.mod,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return 0, err
+	}
+
+	// tar头已声明大小，文件在读取期间被截断时补零保证归档结构完整
+	n, err := io.CopyN(a.tw, src, file.size)
+	if n < file.size {
+		if _, padErr := io.CopyN(a.tw, zeroReader{}, file.size-n); padErr != nil {
+			return n, padErr
+		}
+	}
+	return n, err
+}
+
+func (a *tarGzWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipWriter) WriteFile(file archiveFile, src io.Reader) (int64, error) {
+	header := &zip.FileHeader{
+		Name:     file.name,
+		Method:   zip.Deflate,
+		Modified: file.mod,
+	}
+	header.SetMode(file.mode)
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, src)
+}
+
+func (a *zipWriter) Close() error {
+	return a.zw.Close()
+}
+
+func registerArchiveRoutes(r *gin.Engine) {
+	// 打包下载多个远端文件，与单个文件下载一样不脱敏
+	r.POST("/connections/:id/files/archive", func(c *gin.Context) {
+		connectionID := c.Param("id")
+
+		var req ArchiveRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if req.Format == "" {
+			req.Format = "tar.gz"
+		}
+		if req.Format != "tar.gz" && req.Format != "zip" {
+			respondError(c, http.StatusBadRequest, errors.New("format must be tar.gz or zip"))
+			return
+		}
+		if req.MaxFiles <= 0 || req.MaxFiles > defaultArchiveMaxFiles {
+			req.MaxFiles = defaultArchiveMaxFiles
+		}
+		maxTotal := fetchLimit(req.MaxTotalBytes)
+
+		conn, err := collector.getConnection(connectionID)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		client, err := conn.SFTP()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		// 开始输出前检查文件数量与总大小上限
+		files, errs := expandArchivePaths(client, req.Paths)
+		if len(files) > req.MaxFiles {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("too many files: %d > %d", len(files), req.MaxFiles))
+			return
+		}
+		var total int64
+		for _, file := range files {
+			total += file.size
+		}
+		if total > maxTotal {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("%w: %d > %d bytes", errTransferTooLarge, total, maxTotal))
+			return
+		}
+
+		var archive archiveWriter
+		filename := "archive." + req.Format
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		if req.Format == "zip" {
+			c.Header("Content-Type", "application/zip")
+			archive = &zipWriter{zw: zip.NewWriter(c.Writer)}
+		} else {
+			c.Header("Content-Type", "application/gzip")
+			archive = newTarGzWriter(c.Writer)
+		}
+		c.Status(http.StatusOK)
+
+		manifest := ArchiveManifest{ConnectionID: connectionID, Files: []ArchiveEntry{}, Errors: errs}
+		ctx := c.Request.Context()
+		for _, file := range files {
+			if ctx.Err() != nil {
+				logWarn("archive.aborted", "archive aborted", "connection_id", connectionID, "error", ctx.Err())
+				c.Abort()
+				return
+			}
+
+			src, err := client.Open(file.path)
+			if err != nil {
+				manifest.Errors = append(manifest.Errors, ArchiveEntry{Path: file.path, Error: err.Error()})
+				continue
+			}
+			n, err := archive.WriteFile(file, src)
+			src.Close()
+			if err != nil {
+				manifest.Errors = append(manifest.Errors, ArchiveEntry{Path: file.path, Size: n, Error: err.Error()})
+			} else {
+				manifest.Files = append(manifest.Files, ArchiveEntry{Path: file.path, Size: n})
+			}
+		}
+
+		// 末尾追加清单，记录每个文件的结果
+		manifest.Timestamp = time.Now()
+		data, _ := json.MarshalIndent(manifest, "", "  ")
+		archive.WriteFile(archiveFile{path: manifestName, name: manifestName, size: int64(len(data)), mode: 0644, mod: manifest.Timestamp}, bytes.NewReader(data))
+		if err := archive.Close(); err != nil {
+			logWarn("archive.failed", "archive failed", "connection_id", connectionID, "error", err)
+			c.Abort()
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestArchiveEntryName(t *testing.T) {
+	tests := []struct {
+		pattern, match, want string
+		wantErr              bool
+	}{
+		{"/var/log/*.log", "/var/log/app.log", "app.log", false},
+		{"/var/*/app.log", "/var/log/app.log", "log/app.log", false},
+		{"/etc/hosts", "/etc/hosts", "hosts", false},
+		{"*.log", "app.log", "app.log", false},
+		{"../../etc/*", "../../etc/passwd", "passwd", false},
+		{"logs/../../secret/*.txt", "../secret/a.txt", "a.txt", false},
+		{"/*", "/etc", "etc", false},
+		{"/var/log/*.log", "/var/log/../../etc/passwd", "", true},
+		{"/var/log/*", "/var/log", "", true},
+		{"*", "../x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryName(globBase(filepath.ToSlash(filepath.Clean(tt.pattern))), tt.match)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("pattern %q match %q: got %q, %v; want %q (error %v)", tt.pattern, tt.match, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// archiveNames 读取归档中的条目名和清单
+func archiveNames(t *testing.T, format string, data []byte) ([]string, ArchiveManifest) {
+	t.Helper()
+	var names []string
+	var manifest ArchiveManifest
+	readManifest := func(name string, r io.Reader) {
+		if name == manifestName {
+			if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+				t.Fatalf("manifest: %v", err)
+			}
+		}
+	}
+	if format == "zip" {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+			rc, _ := f.Open()
+			readManifest(f.Name, rc)
+			rc.Close()
+		}
+		return names, manifest
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, manifest
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		readManifest(header.Name, tr)
+	}
+}
+
+func TestArchiveEntriesStayInsideRoot(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"logs/app.log":     "app",
+		"logs/sys.log":     "sys",
+		"secret/token.txt": "token",
+	} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0700)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0600)
+	}
+	cwd, _ := os.Getwd()
+	relative, err := filepath.Rel(cwd, filepath.Join(root, "logs"))
+	if err != nil || !strings.HasPrefix(relative, "..") {
+		t.Skipf("temp dir is not outside the working directory: %s", relative)
+	}
+
+	r := gin.New()
+	registerArchiveRoutes(r)
+	for _, format := range []string{"tar.gz", "zip"} {
+		body, _ := json.Marshal(ArchiveRequest{Format: format, Paths: []string{
+			root + "/logs/*.log",
+			// 相对路径经由 .. 指向工作目录之外
+			filepath.ToSlash(relative) + "/../secret/*.txt",
+		}})
+		req := httptest.NewRequest(http.MethodPost, "/connections/"+id+"/files/archive", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", format, w.Code, w.Body.String())
+		}
+
+		names, manifest := archiveNames(t, format, w.Body.Bytes())
+		sort.Strings(names)
+		if want := []string{manifestName, "app.log", "sys.log", "token.txt"}; strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s: entries = %v, want %v", format, names, want)
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, "/") || strings.Contains("/"+name+"/", "/../") {
+				t.Fatalf("%s: entry %q escapes the extraction directory", format, name)
+			}
+		}
+		if len(manifest.Files) != 3 || len(manifest.Errors) != 0 {
+			t.Fatalf("%s: manifest = %+v", format, manifest)
+		}
+	}
+}
+
+func TestArchiveRejectsDuplicateEntryNames(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(root, dir), 0700)
+		os.WriteFile(filepath.Join(root, dir, "app.log"), []byte(dir), 0600)
+	}
+
+	conn, _ := collector.getConnection(id)
+	client, err := conn.SFTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, errs := expandArchivePaths(client, []string{root + "/a/*.log", root + "/b/*.log"})
+	if len(files) != 1 || files[0].name != "app.log" {
+		t.Fatalf("files = %+v", files)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error, "duplicate") {
+		t.Fatalf("errors = %+v", errs)
+	}
+}
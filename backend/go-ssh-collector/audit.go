@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 审计日志：设置 AUDIT_LOG_FILE 后，所有修改类操作（REST的POST/PUT/PATCH/DELETE、gRPC的非查询方法、
+// WebSocket中执行的命令）以及被拒绝的请求逐条追加到JSON Lines文件，记录调用方、来源IP、请求ID、操作、
+// 目标连接和主机、脱敏后的命令、结果摘要（状态、退出码、耗时、输出的SHA-256）和策略决定。
+// 执行类操作先写审计记录再发出响应，写入失败时文件截断回上一条完整记录。每条记录带序号和前一条的哈希（prev_hash），
+// hash 为去掉hash字段后整条记录的SHA-256，删改或截断都会使 GET /admin/audit/verify 校验失败。
+// 文件超过 AUDIT_MAX_SIZE_MB 或首条记录早于 AUDIT_MAX_AGE_DAYS 时轮转为 <文件>.<UTC时间>，哈希链跨文件延续，
+// 轮转出的文件不会自动删除（删除最早的文件后校验同样失败）。
+// AUDIT_FSYNC=false 时不在每条记录后fsync
+
+var (
+	auditLogFile = getEnv("AUDIT_LOG_FILE", "")
+	auditMaxSize = envInt64("AUDIT_MAX_SIZE_MB", 100) << 20
+	auditMaxAge  = time.Duration(envInt64("AUDIT_MAX_AGE_DAYS", 30)) * 24 * time.Hour
+	auditFsync   = getEnv("AUDIT_FSYNC", "true") == "true"
+)
+
+// auditCaptureLimit 请求体和非执行类响应只保留开头部分用于解析连接、命令和错误码
+const auditCaptureLimit = 64 << 10
+
+var errAuditDisabled = errors.New("audit log is not enabled (set AUDIT_LOG_FILE)")
+
+type AuditEntry struct {
+	Seq          int64     `json:"seq"`
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Principal    string    `json:"principal,omitempty"`
+	Role         string    `json:"role,omitempty"`
+	AuthMethod   string    `json:"auth_method,omitempty"`
+	SourceIP     string    `json:"source_ip,omitempty"`
+	Interface    string    `json:"interface"`
+	Operation    string    `json:"operation"`
+	ConnectionID string    `json:"connection_id,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	Command      string    `json:"command,omitempty"`
+	// Status 为HTTP状态码，gRPC为gRPC状态码
+	Status       int     `json:"status"`
+	ErrorCode    string  `json:"error_code,omitempty"`
+	ExitCode     *int    `json:"exit_code,omitempty"`
+	DurationMS   float64 `json:"duration_ms"`
+	OutputSHA256 string  `json:"output_sha256,omitempty"`
+	OutputBytes  int     `json:"output_bytes,omitempty"`
+	// Decision 为 allowed 或 denied，Reason 为拒绝原因或错误信息
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// computeHash 对去掉hash字段的记录计算SHA-256，时间统一为UTC保证重新序列化后结果一致
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	e.Time = e.Time.UTC()
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// setPrincipal 调用方为空（未启用认证）时不填
+func (e *AuditEntry) setPrincipal(p *Principal) {
+	if p == nil {
+		return
+	}
+	e.Principal, e.Role, e.AuthMethod = p.Name, p.Role, p.Method
+}
+
+// setResult 记录命令结果的退出码和输出哈希
+func (e *AuditEntry) setResult(result *CommandResult) {
+	if result == nil {
+		return
+	}
+	e.ExitCode = result.ExitCode
+	e.setOutput(result.Output)
+}
+
+func (e *AuditEntry) setOutput(output string) {
+	sum := sha256.Sum256([]byte(output))
+	e.OutputSHA256, e.OutputBytes = hex.EncodeToString(sum[:]), len(output)
+}
+
+// setError 记录错误码，403按策略拒绝处理
+func (e *AuditEntry) setError(status int, err error) {
+	e.Status = status
+	if err == nil {
+		return
+	}
+	e.ErrorCode, _ = errorCode(err, status)
+	e.Reason = redact(err.Error())
+	if status == http.StatusForbidden {
+		e.Decision = "denied"
+	}
+}
+
+// auditFile 为*os.File，测试中可替换以模拟写入失败
+type auditFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+type AuditLog struct {
+	path    string
+	file    auditFile
+	size    int64
+	opened  time.Time
+	seq     int64
+	last    string
+	lastErr error
+	mutex   sync.Mutex
+}
+
+var auditLog = newAuditLog(auditLogFile)
+
+// newAuditLog 未设置文件时返回nil，已有文件时从最后一条记录接续序号和哈希
+func newAuditLog(path string) *AuditLog {
+	if path == "" {
+		return nil
+	}
+	a := &AuditLog{path: path}
+	files, err := a.files()
+	// 当前文件可能刚轮转还是空的，向前找到最后一条记录
+	for i := len(files) - 1; err == nil && i >= 0 && a.seq == 0; i-- {
+		err = readAuditFile(files[i], func(entry *AuditEntry) bool {
+			a.seq, a.last = entry.Seq, entry.Hash
+			return true
+		})
+	}
+	if err == nil {
+		err = a.open()
+	}
+	if err != nil {
+		a.lastErr = err
+		logError("audit.open_failed", "failed to open audit log", "file", path, "error", err)
+	}
+	registerReadinessCheck("audit_log", func(ctx context.Context) error {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		return a.lastErr
+	})
+	return a
+}
+
+// open 打开当前文件，年龄从文件中第一条记录算起
+func (a *AuditLog) open() error {
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size, a.opened = f, info.Size(), time.Now()
+	if a.size > 0 {
+		readAuditFile(a.path, func(entry *AuditEntry) bool {
+			a.opened = entry.Time
+			return false
+		})
+	}
+	return nil
+}
+
+// files 轮转出的文件按名称中的时间排序，当前文件在最后
+func (a *AuditLog) files() ([]string, error) {
+	rotated, err := filepath.Glob(a.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range rotated {
+		if !strings.HasSuffix(name, ".tmp") {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(a.path); err == nil {
+		files = append(files, a.path)
+	}
+	return files, nil
+}
+
+// rotate 调用方需持有锁
+func (a *AuditLog) rotate(now time.Time) error {
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	// 同一毫秒内多次轮转时加序号，避免覆盖
+	rotated := a.path + "." + now.UTC().Format("20060102T150405.000Z")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", a.path, now.UTC().Format("20060102T150405.000Z"), i)
+	}
+	if err := os.Rename(a.path, rotated); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// Record 补全序号和哈希后同步写入，写入失败时就绪检查失败
+func (a *AuditLog) Record(entry AuditEntry) error {
+	if a == nil {
+		forwardAuditEntry(entry)
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	if entry.Time.IsZero() {
+		entry.Time = now
+	}
+	entry.Time = entry.Time.UTC()
+	if entry.Decision == "" {
+		entry.Decision = "allowed"
+	}
+	entry.Command, entry.Reason = redact(entry.Command), redact(entry.Reason)
+	entry.Seq, entry.PrevHash = a.seq+1, a.last
+	entry.Hash = entry.computeHash()
+	data, _ := json.Marshal(entry)
+	data = append(data, '\n')
+
+	err := a.write(now, data)
+	a.lastErr = err
+	// 写入失败时同样转发，SIEM中仍有记录
+	forwardAuditEntry(entry)
+	if err != nil {
+		logError("audit.write_failed", "failed to write audit entry", "file", a.path, "request_id", entry.RequestID, "error", err)
+		return err
+	}
+	a.seq, a.last = entry.Seq, entry.Hash
+	return nil
+}
+
+func (a *AuditLog) write(now time.Time, data []byte) error {
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	if a.size > 0 && (a.size+int64(len(data)) > auditMaxSize || auditMaxAge > 0 && now.Sub(a.opened) > auditMaxAge) {
+		if err := a.rotate(now); err != nil {
+			return err
+		}
+	}
+	if a.size == 0 {
+		a.opened = now
+	}
+	offset := a.size
+	_, err := a.file.Write(data)
+	if err == nil && auditFsync {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		// 截断到上一条完整记录之后，避免残缺或未计入哈希链的记录留在文件中
+		if terr := a.file.Truncate(offset); terr != nil {
+			logError("audit.truncate_failed", "failed to truncate partial audit entry", "file", a.path, "offset", offset, "error", terr)
+			a.file.Close()
+			a.file = nil
+		}
+		return err
+	}
+	a.size += int64(len(data))
+	return nil
+}
+
+// readAuditFile 逐条读取，fn返回false时停止
+func readAuditFile(path string, fn func(entry *AuditEntry) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var entry AuditEntry
+			if jerr := json.Unmarshal(data, &entry); jerr != nil {
+				return fmt.Errorf("%s line %d: %v", path, line, jerr)
+			}
+			if !fn(&entry) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type AuditQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Principal string
+	Host      string
+	Operation string
+	Decision  string
+	Limit     int
+}
+
+func (q AuditQuery) matches(entry *AuditEntry) bool {
+	switch {
+	case !q.Since.IsZero() && entry.Time.Before(q.Since),
+		!q.Until.IsZero() && entry.Time.After(q.Until),
+		q.Principal != "" && entry.Principal != q.Principal,
+		q.Host != "" && entry.Host != q.Host,
+		q.Operation != "" && !strings.Contains(entry.Operation, q.Operation),
+		q.Decision != "" && entry.Decision != q.Decision:
+		return false
+	}
+	return true
+}
+
+// Query 返回最新的Limit条匹配记录，新的在前
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	a.mutex.Lock()
+	files, err := a.files()
+	a.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	for _, name := range files {
+		err := readAuditFile(name, func(entry *AuditEntry) bool {
+			if q.matches(entry) {
+				entries = append(entries, *entry)
+				if len(entries) > q.Limit {
+					entries = entries[1:]
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+type AuditVerification struct {
+	Valid   bool     `json:"valid"`
+	Entries int64    `json:"entries"`
+	Files   []string `json:"files"`
+	LastSeq int64    `json:"last_seq"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Verify 校验所有文件的哈希链，最后一条还需与内存中最近写入的哈希一致，否则说明文件尾部被截断
+func (a *AuditLog) Verify() AuditVerification {
+	a.mutex.Lock()
+	files, err := a.files()
+	headSeq, headHash := a.seq, a.last
+	a.mutex.Unlock()
+
+	result := AuditVerification{Files: files}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	// 链从seq 1开始，删除最早的文件同样视为截断
+	prev, seq := "", int64(0)
+	for _, name := range files {
+		var chainErr error
+		err := readAuditFile(name, func(entry *AuditEntry) bool {
+			switch {
+			case entry.Hash != entry.computeHash():
+				chainErr = fmt.Errorf("%s seq %d: hash mismatch", name, entry.Seq)
+			case entry.Seq != seq+1:
+				chainErr = fmt.Errorf("%s seq %d: expected seq %d", name, entry.Seq, seq+1)
+			case entry.PrevHash != prev:
+				chainErr = fmt.Errorf("%s seq %d: prev_hash does not match previous entry", name, entry.Seq)
+			default:
+				prev, seq = entry.Hash, entry.Seq
+				result.Entries++
+				return true
+			}
+			return false
+		})
+		if err == nil {
+			err = chainErr
+		}
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	result.LastSeq = seq
+	if seq != headSeq || prev != headHash {
+		result.Error = fmt.Sprintf("log ends at seq %d but seq %d was written", seq, headSeq)
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// auditResponseWriter 计算响应体哈希；hold为true时先缓存响应，写完审计记录后再发出
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	hash hash.Hash
+	size int
+	head bytes.Buffer
+	hold bool
+	held bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	w.hash.Write(data)
+	w.size += len(data)
+	if room := auditCaptureLimit - w.head.Len(); room > 0 {
+		if room > len(data) {
+			room = len(data)
+		}
+		w.head.Write(data[:room])
+	}
+	if w.hold {
+		return w.held.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 流式响应不再缓存
+func (w *auditResponseWriter) Flush() {
+	w.release()
+	w.ResponseWriter.Flush()
+}
+
+func (w *auditResponseWriter) release() {
+	if !w.hold {
+		return
+	}
+	w.hold = false
+	if w.held.Len() > 0 {
+		w.ResponseWriter.Write(w.held.Bytes())
+	}
+	w.held = bytes.Buffer{}
+}
+
+// body 可解析的响应体：缓存的完整响应，或不超过捕获上限的响应
+func (w *auditResponseWriter) body() []byte {
+	if w.hold {
+		return w.held.Bytes()
+	}
+	if w.size <= auditCaptureLimit {
+		return w.head.Bytes()
+	}
+	return nil
+}
+
+// auditSummary 请求和响应中与审计相关的字段
+type auditSummary struct {
+	ConnectionID string         `json:"connection_id"`
+	Host         string         `json:"host"`
+	Command      string         `json:"command"`
+	Commands     []string       `json:"commands"`
+	Output       *string        `json:"output"`
+	ExitCode     *int           `json:"exit_code"`
+	Code         string         `json:"code"`
+	Message      string         `json:"message"`
+	Result       *CommandResult `json:"result"`
+}
+
+// parseAuditSummary 支持JSON和YAML
+func parseAuditSummary(data []byte) auditSummary {
+	var summary auditSummary
+	if len(data) == 0 {
+		return summary
+	}
+	if json.Unmarshal(data, &summary) != nil {
+		if converted, err := yamlToJSON(data); err == nil {
+			json.Unmarshal(converted, &summary)
+		}
+	}
+	return summary
+}
+
+// prefixedBody 已读取的开头部分加上剩余的原始请求体
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// auditConnectionHost 在断开连接前取得连接的主机
+func auditConnectionHost(connectionID string) string {
+	if connectionID == "" || collector == nil {
+		return ""
+	}
+	conn, err := collector.get(connectionID)
+	if err != nil {
+		return ""
+	}
+	return conn.Info().Host
+}
+
+func auditMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// auditMiddleware 在authMiddleware（管理监听为adminAuthMiddleware）之后、rbacMiddleware之前注册，
+// 以便记录权限拒绝。iface为 rest 或 admin
+func auditMiddleware(iface string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auditLog == nil {
+			c.Next()
+			return
+		}
+		// WebSocket中的每条命令单独记录，升级请求按查询类处理
+		if !auditMutating(c.Request.Method) {
+			auditDenials(c, iface)
+			return
+		}
+		start := time.Now()
+		entry := AuditEntry{
+			RequestID: requestID(c),
+			SourceIP:  c.ClientIP(),
+			Interface: iface,
+			Operation: c.Request.Method + " " + c.Request.URL.Path,
+		}
+		// 上传接口的请求体不解析；其它请求只读取开头部分，其余部分留给处理函数流式读取
+		if c.Request.Body != nil && !uploadRoutes[c.Request.Method+" "+c.FullPath()] {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, auditCaptureLimit))
+			c.Request.Body = prefixedBody{Reader: io.MultiReader(bytes.NewReader(data), c.Request.Body), Closer: c.Request.Body}
+			if err == nil {
+				request := parseAuditSummary(data)
+				entry.ConnectionID, entry.Host, entry.Command = request.ConnectionID, request.Host, request.Command
+				if entry.Command == "" && len(request.Commands) > 0 {
+					entry.Command = strings.Join(request.Commands, "\n")
+				}
+			}
+		}
+		if strings.HasPrefix(c.FullPath(), "/connections/:id") {
+			entry.ConnectionID = c.Param("id")
+		}
+		if entry.Host == "" {
+			entry.Host = auditConnectionHost(entry.ConnectionID)
+		}
+
+		hold := c.GetHeader("Accept") != "text/event-stream" && routePermission(c.Request.Method, c.FullPath()) == permExecute
+		original := c.Writer
+		writer := &auditResponseWriter{ResponseWriter: original, hash: sha256.New(), hold: hold}
+		c.Writer = writer
+		defer func() { c.Writer = original }()
+		c.Next()
+
+		entry.setPrincipal(currentPrincipal(c))
+		entry.Status = c.Writer.Status()
+		entry.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		response := parseAuditSummary(writer.body())
+		if response.Result != nil {
+			response.Output, response.ExitCode = &response.Result.Output, response.Result.ExitCode
+		}
+		switch {
+		case response.Output != nil:
+			entry.setOutput(*response.Output)
+		case writer.size > 0:
+			entry.OutputSHA256, entry.OutputBytes = hex.EncodeToString(writer.hash.Sum(nil)), writer.size
+		}
+		entry.ExitCode = response.ExitCode
+		if entry.ConnectionID == "" {
+			entry.ConnectionID = response.ConnectionID
+		}
+		if entry.Status >= 400 {
+			entry.ErrorCode, entry.Reason = response.Code, response.Message
+		}
+		if entry.Status == http.StatusForbidden {
+			entry.Decision = "denied"
+		}
+		auditLog.Record(entry)
+		writer.release()
+	}
+}
+
+// auditDenials 查询类请求只记录被拒绝的
+func auditDenials(c *gin.Context, iface string) {
+	start := time.Now()
+	original := c.Writer
+	writer := &auditResponseWriter{ResponseWriter: original, hash: sha256.New()}
+	c.Writer = writer
+	defer func() { c.Writer = original }()
+	c.Next()
+
+	if c.Writer.Status() != http.StatusForbidden {
+		return
+	}
+	response := parseAuditSummary(writer.body())
+	entry := AuditEntry{
+		RequestID:  requestID(c),
+		SourceIP:   c.ClientIP(),
+		Interface:  iface,
+		Operation:  c.Request.Method + " " + c.Request.URL.Path,
+		Status:     http.StatusForbidden,
+		ErrorCode:  response.Code,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		Decision:   "denied",
+		Reason:     response.Message,
+	}
+	if strings.HasPrefix(c.FullPath(), "/connections/:id") {
+		entry.ConnectionID = c.Param("id")
+	}
+	entry.setPrincipal(currentPrincipal(c))
+	auditLog.Record(entry)
+}
+
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected RFC3339 or unix seconds)", value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func registerAuditRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/audit", requireAdmin)
+
+	// 参数 since、until（RFC3339或Unix秒）、principal、host、operation、decision、limit（默认100，最大1000）
+	admin.GET("", func(c *gin.Context) {
+		if auditLog == nil {
+			respondError(c, http.StatusNotFound, errAuditDisabled)
+			return
+		}
+		var q AuditQuery
+		var err error
+		if q.Since, err = parseAuditTime(c.Query("since")); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if q.Until, err = parseAuditTime(c.Query("until")); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		q.Principal, q.Host, q.Operation, q.Decision = c.Query("principal"), c.Query("host"), c.Query("operation"), c.Query("decision")
+		q.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if q.Limit <= 0 || q.Limit > 1000 {
+			q.Limit = 1000
+		}
+		entries, err := auditLog.Query(q)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "count": len(entries)})
+	})
+
+	admin.GET("/verify", func(c *gin.Context) {
+		if auditLog == nil {
+			respondError(c, http.StatusNotFound, errAuditDisabled)
+			return
+		}
+		c.JSON(http.StatusOK, auditLog.Verify())
+	})
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useAuditLog 在临时目录启用审计日志，结束时恢复
+func useAuditLog(t *testing.T) *AuditLog {
+	t.Helper()
+	previous := auditLog
+	a := newAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	auditLog = a
+	t.Cleanup(func() {
+		auditLog = previous
+		a.mutex.Lock()
+		if a.file != nil {
+			a.file.Close()
+		}
+		a.mutex.Unlock()
+		readinessChecks.mutex.Lock()
+		delete(readinessChecks.checks, "audit_log")
+		readinessChecks.mutex.Unlock()
+	})
+	return a
+}
+
+func auditEntries(t *testing.T, a *AuditLog, q AuditQuery) []AuditEntry {
+	t.Helper()
+	if q.Limit == 0 {
+		q.Limit = 1000
+	}
+	entries, err := a.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func recordAuditEntries(t *testing.T, a *AuditLog, commands ...string) {
+	t.Helper()
+	for _, command := range commands {
+		if err := a.Record(AuditEntry{Interface: "rest", Operation: "POST /execute", Command: command, Status: http.StatusOK}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func auditLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	return lines[:len(lines)-1]
+}
+
+func writeAuditLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditChainVerifies(t *testing.T) {
+	a := useAuditLog(t)
+	recordAuditEntries(t, a, "uptime", "show version", "df -h")
+
+	v := a.Verify()
+	if !v.Valid || v.Entries != 3 || v.LastSeq != 3 {
+		t.Fatalf("verify = %+v", v)
+	}
+	// 重新打开后从最后一条接续
+	reopened := newAuditLog(a.path)
+	t.Cleanup(func() { reopened.file.Close() })
+	recordAuditEntries(t, reopened, "uptime")
+	if v := reopened.Verify(); !v.Valid || v.LastSeq != 4 {
+		t.Fatalf("verify after reopen = %+v", v)
+	}
+}
+
+func TestAuditVerifyDetectsTampering(t *testing.T) {
+	cases := map[string]struct {
+		edit func(lines []string) []string
+		want string
+	}{
+		"modified entry": {
+			edit: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "show version", "reload", 1)
+				return lines
+			},
+			want: "seq 2: hash mismatch",
+		},
+		"removed entry": {
+			edit: func(lines []string) []string { return append(lines[:1:1], lines[2:]...) },
+			want: "seq 3: expected seq 2",
+		},
+		"truncated tail": {
+			edit: func(lines []string) []string { return lines[:2] },
+			want: "log ends at seq 2 but seq 3 was written",
+		},
+		"reordered": {
+			edit: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			want: "seq 3: expected seq 2",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := useAuditLog(t)
+			recordAuditEntries(t, a, "uptime", "show version", "df -h")
+			writeAuditLines(t, a.path, tc.edit(auditLines(t, a.path)))
+
+			v := a.Verify()
+			if v.Valid || !strings.Contains(v.Error, tc.want) {
+				t.Fatalf("verify = %+v, want error containing %q", v, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditVerifyAcrossRotatedFiles(t *testing.T) {
+	previous := auditMaxSize
+	auditMaxSize = 1
+	t.Cleanup(func() { auditMaxSize = previous })
+	a := useAuditLog(t)
+	recordAuditEntries(t, a, "uptime", "show version", "df -h")
+
+	files, err := a.files()
+	if err != nil || len(files) != 3 {
+		t.Fatalf("files = %v, %v", files, err)
+	}
+	if v := a.Verify(); !v.Valid || v.Entries != 3 {
+		t.Fatalf("verify = %+v", v)
+	}
+	// 删除最早轮转出的文件同样视为截断
+	os.Remove(files[0])
+	if v := a.Verify(); v.Valid || !strings.Contains(v.Error, "expected seq 1") {
+		t.Fatalf("verify after removing the oldest file = %+v", v)
+	}
+}
+
+// shortWriteFile 只写入一半数据后返回错误
+type shortWriteFile struct {
+	auditFile
+}
+
+func (f shortWriteFile) Write(data []byte) (int, error) {
+	n, _ := f.auditFile.Write(data[:len(data)/2])
+	return n, errors.New("disk full")
+}
+
+func TestAuditTruncatesFailedWrites(t *testing.T) {
+	a := useAuditLog(t)
+	recordAuditEntries(t, a, "uptime")
+	size := a.size
+
+	good := a.file
+	a.file = shortWriteFile{good}
+	if err := a.Record(AuditEntry{Interface: "rest", Operation: "POST /execute", Command: "df -h"}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if info, _ := os.Stat(a.path); info.Size() != size || a.size != size {
+		t.Fatalf("file size %d, tracked %d, want %d", info.Size(), a.size, size)
+	}
+
+	// 恢复后继续写入，链仍然完整且序号不跳跃
+	a.file = good
+	recordAuditEntries(t, a, "show version")
+	if v := a.Verify(); !v.Valid || v.LastSeq != 2 {
+		t.Fatalf("verify = %+v", v)
+	}
+}
+
+func TestAuditMiddlewareLimitsCapturedBody(t *testing.T) {
+	a := useAuditLog(t)
+	r := gin.New()
+	r.Use(auditMiddleware("rest"))
+	var received int
+	handler := func(c *gin.Context) {
+		data, _ := io.ReadAll(c.Request.Body)
+		received = len(data)
+		c.JSON(http.StatusOK, gin.H{})
+	}
+	r.POST("/execute", handler)
+	r.POST("/connections/:id/files/upload", handler)
+
+	// 超过捕获上限的请求体完整交给处理函数
+	body := `{"connection_id":"c1","command":"uptime","padding":"` + strings.Repeat("x", 2*auditCaptureLimit) + `"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || received != len(body) {
+		t.Fatalf("status %d, handler read %d of %d bytes", w.Code, received, len(body))
+	}
+
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	part, _ := form.CreateFormFile("file", "firmware.bin")
+	part.Write(bytes.Repeat([]byte{0}, 2*auditCaptureLimit))
+	form.Close()
+	size := upload.Len()
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/connections/c1/files/upload", &upload)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || received != size {
+		t.Fatalf("upload: status %d, handler read %d of %d bytes", w.Code, received, size)
+	}
+
+	// 截断的JSON无法解析命令；上传请求仍记录路径中的连接
+	entries := auditEntries(t, a, AuditQuery{})
+	if len(entries) != 2 || entries[0].ConnectionID != "c1" || entries[1].Operation != "POST /execute" {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
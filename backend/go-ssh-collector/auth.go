@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API Key认证：配置了密钥文件或管理员密钥后，除 /health 外所有请求都需要携带
+// X-API-Key 或 Authorization: Bearer <key>。密钥只保存SHA-256摘要。
+// 配置OIDC后Bearer也可以是JWT（见jwt.go），启用客户端证书后已校验的证书同样有效（见mtls.go）
+
+var errAPIKeyNotFound = errors.New("api key not found")
+
+var (
+	// 密钥文件（JSON数组），条目可写明文key，加载时计算摘要
+	apiKeysFile = getEnv("API_KEYS_FILE", "")
+	// 启动时注入的管理员密钥，用于创建其他密钥
+	apiAdminKey = getEnv("API_ADMIN_KEY", "")
+	// 未配置任何密钥时是否仍要求认证
+	authRequired = getEnv("AUTH_REQUIRED", "false") == "true"
+)
+
+// 不需要认证的路由
+var authExemptPaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true}
+
+// Principal 认证后的调用方，保存在请求上下文中，供审计和配额使用
+type Principal struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Role 为viewer/operator/admin，Admin等价于Role为admin
+	Role  string `json:"role"`
+	Admin bool   `json:"admin,omitempty"`
+	KeyID string `json:"key_id,omitempty"`
+	// Subject 为JWT的sub或客户端证书主题，Issuer 为JWT的iss，Roles 为JWT角色声明
+	Subject   string   `json:"subject,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Method    string   `json:"method"`
+	Endpoints []string `json:"allowed_endpoints,omitempty"`
+}
+
+const principalKey = "principal"
+
+// Identity 连接、任务、结果等的属主标识。名称在API Key、JWT和客户端证书中各自分配，
+// 按认证方式限定后比较，证书CN为alice的调用方不等同于名为alice的API Key
+func (p *Principal) Identity() string {
+	switch p.Method {
+	case "api_key":
+		return "apikey:" + p.Name
+	case "jwt":
+		return "jwt:" + p.Issuer + "/" + p.Subject
+	case "mtls":
+		return "cert:" + p.Name
+	}
+	return p.Method + ":" + p.Name
+}
+
+func currentPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+type principalContextKey struct{}
+
+// setPrincipal 同时写入请求context，后台任务等只拿到context的流程据此记录调用方
+func setPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), principalContextKey{}, p))
+}
+
+// contextPrincipal 取REST请求或gRPC调用context中的调用方
+func contextPrincipal(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// principalName 用于审计日志，未启用认证时为空
+func principalName(c *gin.Context) string {
+	if p := currentPrincipal(c); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// principalIdentity 记录属主时使用，未启用认证时为空
+func principalIdentity(p *Principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.Identity()
+}
+
+type APIKey struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// AllowedEndpoints 形如 "POST /execute"、"/connections*"，为空表示不限制
+	AllowedEndpoints []string `json:"allowed_endpoints,omitempty"`
+	// Role 为空时使用RBAC_DEFAULT_KEY_ROLE，Admin为true时视为admin
+	Role       string     `json:"role,omitempty"`
+	Admin      bool       `json:"admin,omitempty"`
+	Hash       string     `json:"key_hash"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (k *APIKey) role() string {
+	switch {
+	case k.Admin:
+		return roleAdmin
+	case k.Role != "":
+		return k.Role
+	}
+	return rbacDefaultKeyRole
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// allows 按方法和路径（支持结尾*前缀匹配）检查是否允许访问
+func (k *APIKey) allows(method, path string) bool {
+	if len(k.AllowedEndpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range k.AllowedEndpoints {
+		pattern := endpoint
+		if m, p, ok := strings.Cut(endpoint, " "); ok {
+			if !strings.EqualFold(m, method) {
+				continue
+			}
+			pattern = strings.TrimSpace(p)
+		}
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyStore struct {
+	keys  map[string]*APIKey // 摘要 -> 密钥
+	mutex sync.RWMutex
+}
+
+var apiKeys = newAPIKeyStore()
+
+func newAPIKeyStore() *APIKeyStore {
+	s := &APIKeyStore{keys: make(map[string]*APIKey)}
+	if apiAdminKey != "" {
+		s.keys[hashAPIKey(apiAdminKey)] = &APIKey{ID: "admin", Name: "admin", Admin: true, Hash: hashAPIKey(apiAdminKey), CreatedAt: time.Now()}
+	}
+	if apiKeysFile == "" {
+		return s
+	}
+	data, err := os.ReadFile(apiKeysFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("auth.keys_load_failed", "load api keys failed", "file", apiKeysFile, "error", err)
+		}
+		return s
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		logError("auth.keys_load_failed", "load api keys failed", "file", apiKeysFile, "error", err)
+		return s
+	}
+	for _, key := range keys {
+		if key.Key != "" {
+			key.Hash, key.Key = hashAPIKey(key.Key), ""
+		}
+		if key.Hash == "" {
+			continue
+		}
+		if key.ID == "" {
+			key.ID = key.Hash[:12]
+		}
+		s.keys[key.Hash] = key
+	}
+	return s
+}
+
+// Enabled 配置了任意密钥或显式要求认证时启用
+func (s *APIKeyStore) Enabled() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return authRequired || len(s.keys) > 0
+}
+
+// Authenticate 校验密钥并记录最近使用时间
+func (s *APIKeyStore) Authenticate(key string) (*APIKey, bool) {
+	hash := hashAPIKey(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	k, ok := s.keys[hash]
+	if !ok || k.RevokedAt != nil {
+		return nil, false
+	}
+	now := time.Now()
+	k.LastUsedAt = &now
+	copied := *k
+	return &copied, true
+}
+
+// save 持久化密钥摘要（不含启动注入的管理员密钥），调用方需持有锁
+func (s *APIKeyStore) save() error {
+	if apiKeysFile == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.ID != "admin" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(apiKeysFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(apiKeysFile+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(apiKeysFile+".tmp", apiKeysFile)
+}
+
+// Create 生成新密钥，明文只在创建时返回一次
+func (s *APIKeyStore) Create(key APIKey) (*APIKey, string, error) {
+	plain := "mpc_" + newID() + newID()
+	key.Hash = hashAPIKey(plain)
+	key.ID = key.Hash[:12]
+	key.CreatedAt = time.Now()
+	key.Key, key.RevokedAt, key.LastUsedAt = "", nil, nil
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys[key.Hash] = &key
+	if err := s.save(); err != nil {
+		delete(s.keys, key.Hash)
+		return nil, "", err
+	}
+	return &key, plain, nil
+}
+
+func (s *APIKeyStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range s.keys {
+		if key.ID == id && key.ID != "admin" {
+			if key.RevokedAt == nil {
+				now := time.Now()
+				key.RevokedAt = &now
+			}
+			return s.save()
+		}
+	}
+	return errAPIKeyNotFound
+}
+
+func (s *APIKeyStore) List() []gin.H {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(s.keys))
+	for _, key := range s.keys {
+		list = append(list, gin.H{
+			"id":                key.ID,
+			"name":              key.Name,
+			"namespace":         key.Namespace,
+			"allowed_endpoints": key.AllowedEndpoints,
+			"role":              key.role(),
+			"admin":             key.Admin,
+			"created_at":        key.CreatedAt,
+			"revoked_at":        key.RevokedAt,
+			"last_used_at":      key.LastUsedAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["id"].(string) < list[j]["id"].(string) })
+	return list
+}
+
+// requestAPIKey 从 X-API-Key 或 Authorization: Bearer/ApiKey 中取密钥
+func requestAPIKey(c *gin.Context) string {
+	if key := headerCredential(c.GetHeader("X-API-Key"), c.GetHeader("Authorization")); key != "" {
+		return key
+	}
+	return websocketCredential(c.Request)
+}
+
+// headerCredential gRPC的metadata使用相同的两个键
+func headerCredential(apiKey, authorization string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if ok && (strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "ApiKey")) {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// AuthError 认证失败，Status为对应的HTTP状态码
+type AuthError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+func authEnabled() bool {
+	return apiKeys.Enabled() || oidcEnabled() || clientCertAuthEnabled()
+}
+
+// authenticate 依次尝试客户端证书、JWT和API Key，供HTTP中间件和gRPC拦截器共用；
+// method和path用于校验API Key的allowed_endpoints
+func authenticate(state *tls.ConnectionState, credential, method, path string) (*Principal, error) {
+	if p := certPrincipal(state); p != nil {
+		return p, nil
+	}
+	if oidcEnabled() && looksLikeJWT(credential) {
+		p, err := VerifyToken(credential)
+		if err != nil {
+			code := "invalid_token"
+			var terr *TokenError
+			if errors.As(err, &terr) {
+				code = terr.Code
+			}
+			return nil, &AuthError{Status: http.StatusUnauthorized, Code: code, Message: err.Error()}
+		}
+		return p, nil
+	}
+	key, ok := apiKeys.Authenticate(credential)
+	if !ok {
+		return nil, &AuthError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "unauthorized"}
+	}
+	if !key.allows(method, path) {
+		return nil, &AuthError{Status: http.StatusForbidden, Message: "endpoint not allowed for this api key"}
+	}
+	return &Principal{
+		Name:      key.Name,
+		Namespace: key.Namespace,
+		Role:      key.role(),
+		Admin:     key.role() == roleAdmin,
+		KeyID:     key.ID,
+		Method:    "api_key",
+		Endpoints: key.AllowedEndpoints,
+	}, nil
+}
+
+// authMiddleware API Key失败时统一返回401，不区分缺失与无效；
+// JWT失败时在details.reason中带失败原因
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authExemptPaths[c.Request.URL.Path] || !authEnabled() {
+			c.Next()
+			return
+		}
+		p, err := authenticate(c.Request.TLS, requestAPIKey(c), c.Request.Method, c.Request.URL.Path)
+		if err != nil {
+			respondAuthError(c, err)
+			return
+		}
+		setPrincipal(c, p)
+		c.Next()
+	}
+}
+
+// respondAuthError JWT失败原因（token_expired、invalid_audience等）放在details.reason
+func respondAuthError(c *gin.Context, err error) {
+	var aerr *AuthError
+	errors.As(err, &aerr)
+	var details gin.H
+	if aerr.Code != "" {
+		details = gin.H{"reason": aerr.Code}
+	}
+	forwardAuthFailure("rest", c.ClientIP(), c.Request.Method, c.Request.URL.Path, firstNonEmpty(aerr.Code, aerr.Message))
+	respondErrorDetails(c, aerr.Status, aerr, details)
+}
+
+func requireAdmin(c *gin.Context) {
+	if p := currentPrincipal(c); p == nil || !p.Admin {
+		respondError(c, http.StatusForbidden, errors.New("admin api key required"))
+		return
+	}
+	c.Next()
+}
+
+func registerAuthRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/keys", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": apiKeys.List()})
+	})
+
+	admin.POST("", func(c *gin.Context) {
+		var body struct {
+			Name             string   `json:"name" binding:"required"`
+			Namespace        string   `json:"namespace"`
+			AllowedEndpoints []string `json:"allowed_endpoints"`
+			Role             string   `json:"role"`
+			Admin            bool     `json:"admin"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if body.Role != "" && !validRole(body.Role) {
+			respondError(c, http.StatusBadRequest, errors.New("role must be viewer, operator or admin"))
+			return
+		}
+		key, plain, err := apiKeys.Create(APIKey{
+			Name:             body.Name,
+			Namespace:        body.Namespace,
+			AllowedEndpoints: body.AllowedEndpoints,
+			Role:             body.Role,
+			Admin:            body.Admin,
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": key.ID, "name": key.Name, "role": key.role(), "key": plain, "created_at": key.CreatedAt})
+	})
+
+	admin.DELETE("/:id", func(c *gin.Context) {
+		if err := apiKeys.Revoke(c.Param("id")); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errAPIKeyNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 密钥文件中的明文key在加载时换成摘要，保存后文件里只有摘要
+func TestAPIKeyStoreKeepsOnlyHashes(t *testing.T) {
+	previous := apiKeysFile
+	apiKeysFile = filepath.Join(t.TempDir(), "keys.json")
+	t.Cleanup(func() { apiKeysFile = previous })
+	plain := "mpc_file_key_for_test"
+	if err := os.WriteFile(apiKeysFile, []byte(`[{"name":"ci","role":"viewer","key":"`+plain+`"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newAPIKeyStore()
+	stored, ok := store.keys[hashAPIKey(plain)]
+	if !ok {
+		t.Fatalf("key not indexed by its SHA-256 digest: %v", store.keys)
+	}
+	if stored.Key != "" || stored.Hash != hashAPIKey(plain) {
+		t.Fatalf("stored key keeps plaintext %q or wrong hash %q", stored.Key, stored.Hash)
+	}
+	key, ok := store.Authenticate(plain)
+	if !ok || key.Name != "ci" || key.LastUsedAt == nil {
+		t.Fatalf("Authenticate(plain) = %+v, %v", key, ok)
+	}
+	if _, ok := store.Authenticate(hashAPIKey(plain)); ok {
+		t.Fatal("the digest itself authenticated")
+	}
+
+	_, created, err := store.Create(APIKey{Name: "new", Role: roleOperator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(apiKeysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{plain, created} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("saved key file contains the plaintext key %q", secret)
+		}
+	}
+	if !strings.Contains(string(data), hashAPIKey(created)) {
+		t.Fatalf("saved key file lacks the digest of the created key: %s", data)
+	}
+}
+
+// 未知或已吊销的密钥与缺失密钥一样返回401
+func TestAuthRejectsUnknownAndRevokedKeys(t *testing.T) {
+	r := newRouter()
+	valid := useAPIKey(t, "valid", roleViewer)
+	revoked := useAPIKey(t, "revoked", roleViewer)
+	if err := apiKeys.Revoke(apiKeyID(t, revoked)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := apiRequest(r, valid, http.MethodGet, "/connections", ""); w.Code != http.StatusOK {
+		t.Fatalf("valid key: status %d: %s", w.Code, w.Body.String())
+	}
+	for name, key := range map[string]string{"unknown": "mpc_not_a_key", "revoked": revoked, "missing": ""} {
+		if w := apiRequest(r, key, http.MethodGet, "/connections", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s key: status %d, want 401", name, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/connections", nil)
+	req.Header.Set("Authorization", "Bearer "+valid)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bearer key: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// 健康检查路由在启用认证时也不需要密钥
+func TestAuthExemptPathsSkipAuthentication(t *testing.T) {
+	r := newRouter()
+	useAPIKey(t, "someone", roleViewer)
+	if !authEnabled() {
+		t.Fatal("auth not enabled after creating a key")
+	}
+	for path := range authExemptPaths {
+		if w := apiRequest(r, "", http.MethodGet, path, ""); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("%s without a key: status %d", path, w.Code)
+		}
+	}
+}
+
+// /admin/keys 只允许admin；创建的密钥立即可用，吊销后返回401
+func TestAdminKeyRoutesRequireAdmin(t *testing.T) {
+	r := newAdminRouter()
+	admin := useAPIKey(t, "root", roleAdmin)
+	operator := useAPIKey(t, "olive", roleOperator)
+
+	for _, key := range []string{operator, useAPIKey(t, "vera", roleViewer)} {
+		if w := apiRequest(r, key, http.MethodGet, "/admin/keys", ""); w.Code != http.StatusForbidden {
+			t.Errorf("non-admin list: status %d, want 403", w.Code)
+		}
+		if w := apiRequest(r, key, http.MethodPost, "/admin/keys", `{"name":"escalate","admin":true}`); w.Code != http.StatusForbidden {
+			t.Errorf("non-admin create: status %d, want 403", w.Code)
+		}
+	}
+	if w := apiRequest(r, "", http.MethodGet, "/admin/keys", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("no key: status %d, want 401", w.Code)
+	}
+
+	w := apiRequest(r, admin, http.MethodPost, "/admin/keys", `{"name":"created","role":"operator"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin create: status %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Key == "" {
+		t.Fatalf("create response %s: %v", w.Body.String(), err)
+	}
+	t.Cleanup(func() {
+		apiKeys.mutex.Lock()
+		delete(apiKeys.keys, hashAPIKey(created.Key))
+		apiKeys.mutex.Unlock()
+	})
+
+	w = apiRequest(r, admin, http.MethodGet, "/admin/keys", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Key) || !strings.Contains(w.Body.String(), created.ID) {
+		t.Fatalf("admin list: status %d: %s", w.Code, w.Body.String())
+	}
+	api := newRouter()
+	if w := apiRequest(api, created.Key, http.MethodGet, "/connections", ""); w.Code != http.StatusOK {
+		t.Fatalf("created key: status %d", w.Code)
+	}
+	if w := apiRequest(r, operator, http.MethodDelete, "/admin/keys/"+created.ID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("operator revoke: status %d, want 403", w.Code)
+	}
+	if w := apiRequest(r, admin, http.MethodDelete, "/admin/keys/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("admin revoke: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(api, created.Key, http.MethodGet, "/connections", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: status %d, want 401", w.Code)
+	}
+	if w := apiRequest(r, admin, http.MethodDelete, "/admin/keys/missing", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("revoke unknown: status %d, want 404", w.Code)
+	}
+}
+
+func apiKeyID(t *testing.T, plain string) string {
+	t.Helper()
+	apiKeys.mutex.RLock()
+	defer apiKeys.mutex.RUnlock()
+	key, ok := apiKeys.keys[hashAPIKey(plain)]
+	if !ok {
+		t.Fatal("key not found")
+	}
+	return key.ID
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 认证失败退避：同一 host:port+用户名 在 AUTH_BACKOFF_WINDOW_SECONDS 内连续认证失败
+// AUTH_BACKOFF_THRESHOLD 次后，AUTH_BACKOFF_LOCKOUT_SECONDS 内拒绝再次连接（423），
+// 避免错误的凭据反复重试触发设备的fail2ban而锁定服务账号。认证成功后清零。
+// 管理员可在请求中指定 force: true 跳过检查。AUTH_BACKOFF_THRESHOLD=0 关闭，
+// 设置 AUTH_BACKOFF_FILE 时状态在重启后保留
+
+var (
+	authBackoffThreshold = int(envInt64("AUTH_BACKOFF_THRESHOLD", 5))
+	authBackoffWindow    = time.Duration(envInt64("AUTH_BACKOFF_WINDOW_SECONDS", 600)) * time.Second
+	authBackoffLockout   = time.Duration(envInt64("AUTH_BACKOFF_LOCKOUT_SECONDS", 900)) * time.Second
+	authBackoffFile      = getEnv("AUTH_BACKOFF_FILE", "")
+)
+
+var errTargetLocked = errors.New("target locked after repeated authentication failures")
+
+// targetLockedError 带解锁时间，写入错误响应的details
+type targetLockedError struct {
+	target   string
+	username string
+	until    time.Time
+	failures int
+}
+
+func (e *targetLockedError) Error() string {
+	return fmt.Sprintf("%s for %s@%s until %s", errTargetLocked, e.username, e.target, e.until.Format(time.RFC3339))
+}
+
+func (e *targetLockedError) Is(target error) bool { return target == errTargetLocked }
+
+func (e *targetLockedError) details() map[string]interface{} {
+	return map[string]interface{}{
+		"target":       e.target,
+		"username":     e.username,
+		"locked_until": e.until,
+		"failures":     e.failures,
+	}
+}
+
+// isAuthFailure 只统计设备明确拒绝凭据的错误，网络错误和超时不计入
+func isAuthFailure(err error) bool {
+	return errors.Is(err, errSSHAuthFailed) || errors.Is(err, errTelnetAuthRejected) || errors.Is(err, errWinRMAuth)
+}
+
+// connectPort 与各协议Connect中的默认端口一致
+func connectPort(config SSHConfig) int {
+	if config.Port != 0 {
+		return config.Port
+	}
+	switch config.Protocol {
+	case "telnet":
+		return 23
+	case "console":
+		if config.Transport == "ssh" {
+			return 22
+		}
+		return 23
+	case "winrm":
+		if config.HTTPS {
+			return 5986
+		}
+		return 5985
+	}
+	return 22
+}
+
+type AuthFailure struct {
+	Target       string    `json:"target"`
+	Username     string    `json:"username"`
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+	LastError    string    `json:"last_error"`
+	LockedUntil  time.Time `json:"locked_until"`
+}
+
+func (f *AuthFailure) locked(now time.Time) bool {
+	return now.Before(f.LockedUntil)
+}
+
+type AuthBackoff struct {
+	entries map[string]*AuthFailure
+	mutex   sync.Mutex
+}
+
+var authBackoff = newAuthBackoff()
+
+func newAuthBackoff() *AuthBackoff {
+	ab := &AuthBackoff{entries: make(map[string]*AuthFailure)}
+	if authBackoffFile == "" {
+		return ab
+	}
+	data, err := os.ReadFile(authBackoffFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("auth_backoff.load_failed", "load auth backoff state failed", "file", authBackoffFile, "error", err)
+		}
+		return ab
+	}
+	if err := json.Unmarshal(data, &ab.entries); err != nil {
+		logError("auth_backoff.load_failed", "load auth backoff state failed", "file", authBackoffFile, "error", err)
+		ab.entries = make(map[string]*AuthFailure)
+	}
+	ab.expire(time.Now())
+	return ab
+}
+
+func authBackoffKey(target, username string) string {
+	return target + "|" + username
+}
+
+// expire 删除窗口已过且未锁定的记录，调用方需持有锁
+func (ab *AuthBackoff) expire(now time.Time) {
+	recordSweep("auth_backoff")
+	for key, entry := range ab.entries {
+		if entry == nil || !entry.locked(now) && now.Sub(entry.LastFailure) > authBackoffWindow {
+			delete(ab.entries, key)
+		}
+	}
+}
+
+// Check 目标处于锁定期时返回*targetLockedError
+func (ab *AuthBackoff) Check(target, username string) error {
+	if authBackoffThreshold <= 0 {
+		return nil
+	}
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+	entry, ok := ab.entries[authBackoffKey(target, username)]
+	if !ok || !entry.locked(time.Now()) {
+		return nil
+	}
+	return &targetLockedError{target: target, username: username, until: entry.LockedUntil, failures: entry.Failures}
+}
+
+// Failure 记录一次认证失败，达到阈值时开始锁定
+func (ab *AuthBackoff) Failure(target, username string, err error) {
+	if authBackoffThreshold <= 0 {
+		return
+	}
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	now := time.Now()
+	key := authBackoffKey(target, username)
+	entry, ok := ab.entries[key]
+	// 超出窗口或上次锁定已结束时重新计数
+	if !ok || now.Sub(entry.FirstFailure) > authBackoffWindow || !entry.LockedUntil.IsZero() && !entry.locked(now) {
+		entry = &AuthFailure{Target: target, Username: username, FirstFailure: now}
+		ab.entries[key] = entry
+	}
+	entry.Failures++
+	entry.LastFailure = now
+	entry.LastError = redact(err.Error())
+	if entry.Failures >= authBackoffThreshold && !entry.locked(now) {
+		entry.LockedUntil = now.Add(authBackoffLockout)
+		logInfo("audit", "connect target locked", "target", target, "username", username,
+			"failures", entry.Failures, "until", entry.LockedUntil.Format(time.RFC3339))
+	}
+	ab.save()
+}
+
+// Success 认证成功后清零
+func (ab *AuthBackoff) Success(target, username string) {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+	key := authBackoffKey(target, username)
+	if _, ok := ab.entries[key]; ok {
+		delete(ab.entries, key)
+		ab.save()
+	}
+}
+
+func (ab *AuthBackoff) Clear(target, username string) bool {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+	key := authBackoffKey(target, username)
+	if _, ok := ab.entries[key]; !ok {
+		return false
+	}
+	delete(ab.entries, key)
+	ab.save()
+	return true
+}
+
+func (ab *AuthBackoff) List() []AuthFailure {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+	ab.expire(time.Now())
+	list := make([]AuthFailure, 0, len(ab.entries))
+	for _, entry := range ab.entries {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastFailure.After(list[j].LastFailure) })
+	return list
+}
+
+// save 调用方需持有锁，写入失败只记录日志
+func (ab *AuthBackoff) save() {
+	if authBackoffFile == "" {
+		return
+	}
+	data, err := json.Marshal(ab.entries)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(authBackoffFile), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(authBackoffFile+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(authBackoffFile+".tmp", authBackoffFile)
+	}
+	if err != nil {
+		logError("auth_backoff.save_failed", "save auth backoff state failed", "file", authBackoffFile, "error", err)
+	}
+}
+
+func registerAuthBackoffRoutes(r *gin.Engine) {
+	// 当前的认证失败计数与锁定状态
+	r.GET("/auth-failures", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"failures":  authBackoff.List(),
+			"threshold": authBackoffThreshold,
+			"window":    int(authBackoffWindow.Seconds()),
+			"lockout":   int(authBackoffLockout.Seconds()),
+		})
+	})
+
+	// 手动解除锁定，参数 host、port、username
+	r.DELETE("/auth-failures", func(c *gin.Context) {
+		port, err := strconv.Atoi(c.Query("port"))
+		if c.Query("host") == "" || c.Query("username") == "" || err != nil {
+			respondError(c, http.StatusBadRequest, errors.New("host, port and username are required"))
+			return
+		}
+		target := net.JoinHostPort(c.Query("host"), strconv.Itoa(port))
+		if !authBackoff.Clear(target, c.Query("username")) {
+			respondError(c, http.StatusNotFound, errors.New("no auth failures recorded for target"))
+			return
+		}
+		logInfo("audit", "connect target unlocked", append(requestLogFields(c), "target", target, "username", c.Query("username"))...)
+		c.JSON(http.StatusOK, gin.H{"status": "cleared"})
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求体限制：普通接口读取不超过MAX_REQUEST_BODY字节，文件上传接口流式限制为MAX_UPLOAD_BODY。
+// 有请求体的POST/PUT只接受声明的Content-Type，默认为application/json
+
+var (
+	maxRequestBody = envInt64("MAX_REQUEST_BODY", 1<<20)
+	maxUploadBody  = envInt64("MAX_UPLOAD_BODY", 1<<30)
+)
+
+// 文件上传接口，使用multipart表单
+var uploadRoutes = map[string]bool{
+	"POST /connections/:id/files/upload":   true,
+	"POST /ftp/endpoints/:id/files/upload": true,
+}
+
+// 除JSON外还接受其它Content-Type的接口
+var routeContentTypes = map[string][]string{
+	"POST /connections/:id/files/upload":   {"multipart/form-data"},
+	"POST /ftp/endpoints/:id/files/upload": {"multipart/form-data"},
+	"PUT /templates/:name":                 {"application/json", "text/plain"},
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyTooLarge(c *gin.Context, limit int64) {
+	respondErrorDetails(c, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", limit), gin.H{"limit": limit})
+}
+
+// bodyLimitMiddleware 按Content-Length提前拒绝，未声明长度时最多读取limit+1字节
+func bodyLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody || c.FullPath() == "" {
+			c.Next()
+			return
+		}
+		route := c.Request.Method + " " + c.FullPath()
+
+		if c.Request.ContentLength != 0 {
+			allowed := routeContentTypes[route]
+			if allowed == nil {
+				allowed = []string{"application/json"}
+			}
+			if yamlRequestRoutes[route] {
+				allowed = append(allowed[:len(allowed):len(allowed)], yamlMediaTypes...)
+			}
+			mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			if !containsString(allowed, mediaType) || err != nil {
+				respondErrorDetails(c, http.StatusUnsupportedMediaType, errors.New("unsupported content type"), gin.H{"allowed_types": allowed})
+				return
+			}
+		}
+
+		limit := maxRequestBody
+		if uploadRoutes[route] {
+			limit = maxUploadBody
+		}
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			bodyTooLarge(c, limit)
+			return
+		}
+		if uploadRoutes[route] {
+			// 上传内容不缓存，超出时由读取方报错
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+			c.Next()
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if int64(len(data)) > limit {
+			bodyTooLarge(c, limit)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		c.Next()
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批量执行：每个条目针对一个连接执行一条或多条命令，条目之间并发执行，
+// 结果按输入顺序返回，单个条目失败不影响其它条目
+
+var (
+	bulkMaxConcurrency = int(envInt64("BULK_MAX_CONCURRENCY", 20))
+	bulkMaxEntries     = int(envInt64("BULK_MAX_ENTRIES", 1000))
+	// 整批的默认和最大超时（秒）
+	bulkDefaultTimeout = int(envInt64("BULK_DEFAULT_TIMEOUT", 300))
+	bulkMaxTimeout     = int(envInt64("BULK_MAX_TIMEOUT", 3600))
+)
+
+var (
+	errBatchNotFound = errors.New("batch not found")
+	errBatchExists   = errors.New("batch id already in use")
+	errBatchNotOwned = errors.New("batch not owned by caller")
+)
+
+// BulkEntry 命令选项与/execute相同，不支持unmasked
+type BulkEntry struct {
+	ConnectionID string        `json:"connection_id" binding:"required"`
+	Command      string        `json:"command"`
+	Commands     []string      `json:"commands"`
+	Shell        string        `json:"shell"`
+	Parse        *ParseOptions `json:"parse"`
+	Extract      []ExtractRule `json:"extract"`
+	Transform    string        `json:"transform"`
+	Script       string        `json:"script"`
+}
+
+type BulkRequest struct {
+	// BatchID 为空时自动生成，用于取消
+	BatchID     string      `json:"batch_id"`
+	Entries     []BulkEntry `json:"entries" binding:"required,dive"`
+	Concurrency int         `json:"concurrency"`
+	Timeout     int         `json:"timeout"`
+	// StopOnError 条目内某条命令失败后跳过该条目剩余命令
+	StopOnError bool `json:"stop_on_error"`
+	Async       bool `json:"async"`
+}
+
+type BulkEntryResult struct {
+	Index        int              `json:"index"`
+	ConnectionID string           `json:"connection_id"`
+	RequestID    string           `json:"request_id,omitempty"`
+	Results      []*CommandResult `json:"results"`
+	Error        string           `json:"error,omitempty"`
+	ErrorClass   string           `json:"error_class,omitempty"`
+}
+
+type BulkResponse struct {
+	BatchID   string            `json:"batch_id"`
+	RequestID string            `json:"request_id,omitempty"`
+	Status    string            `json:"status"`
+	Entries   []BulkEntryResult `json:"entries"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Duration  float64           `json:"duration_seconds"`
+}
+
+// bulkBatch 正在执行的批次，owner为提交者的Identity，未启用认证时为空
+type bulkBatch struct {
+	cancel context.CancelFunc
+	owner  string
+}
+
+// bulkBatches 正在执行的批次，用于按batch_id取消
+var bulkBatches = struct {
+	batches map[string]bulkBatch
+	mutex   sync.Mutex
+}{batches: make(map[string]bulkBatch)}
+
+func registerBatch(id, owner string, cancel context.CancelFunc) error {
+	bulkBatches.mutex.Lock()
+	defer bulkBatches.mutex.Unlock()
+	if _, exists := bulkBatches.batches[id]; exists {
+		return errBatchExists
+	}
+	bulkBatches.batches[id] = bulkBatch{cancel: cancel, owner: owner}
+	return nil
+}
+
+func unregisterBatch(id string) {
+	bulkBatches.mutex.Lock()
+	defer bulkBatches.mutex.Unlock()
+	delete(bulkBatches.batches, id)
+}
+
+// cancelBatch 与取消任务相同，启用连接ACL时只有提交者和admin可以取消
+func cancelBatch(id string, p *Principal) error {
+	bulkBatches.mutex.Lock()
+	defer bulkBatches.mutex.Unlock()
+	batch, ok := bulkBatches.batches[id]
+	if !ok {
+		return errBatchNotFound
+	}
+	if !jobAllowed(p, batch.owner) {
+		return errBatchNotOwned
+	}
+	batch.cancel()
+	return nil
+}
+
+func (e BulkEntry) commands() []string {
+	if e.Command != "" {
+		return append([]string{e.Command}, e.Commands...)
+	}
+	return e.Commands
+}
+
+// runEntry 顺序执行条目中的命令，ctx中带该条目的请求ID
+func runEntry(ctx context.Context, entry BulkEntry, stopOnError bool) ([]*CommandResult, error) {
+	commands := entry.commands()
+	if len(commands) == 0 {
+		return nil, errors.New("command or commands is required")
+	}
+	results := make([]*CommandResult, 0, len(commands))
+	for _, command := range commands {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result, err := collector.Execute(CommandRequest{
+			ConnectionID: entry.ConnectionID,
+			Command:      command,
+			Shell:        entry.Shell,
+			Parse:        entry.Parse,
+			Extract:      entry.Extract,
+			Transform:    entry.Transform,
+			Script:       entry.Script,
+			requestID:    requestIDFromContext(ctx),
+			trace:        ctx,
+		})
+		if err != nil {
+			// 连接不存在、命令被策略拒绝等错误直接结束该条目
+			return results, err
+		}
+		results = append(results, result)
+		if stopOnError && result.Error != "" {
+			break
+		}
+	}
+	return results, nil
+}
+
+// RunBulk 以有限并发执行所有条目；超时或取消时尚未完成的条目记为失败，
+// 已在设备上运行的命令继续执行但结果被丢弃
+func RunBulk(ctx context.Context, req BulkRequest, allowed func(connectionID string) bool) *BulkResponse {
+	started := time.Now()
+	parentID := requestIDFromContext(ctx)
+	resp := &BulkResponse{BatchID: req.BatchID, RequestID: parentID, Status: "completed", Entries: make([]BulkEntryResult, len(req.Entries))}
+	done := make([]bool, len(req.Entries))
+	var mutex sync.Mutex
+
+	finish := func(i int, results []*CommandResult, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if done[i] {
+			return
+		}
+		done[i] = true
+		entry := BulkEntryResult{Index: i, ConnectionID: req.Entries[i].ConnectionID, RequestID: childRequestID(parentID, i), Results: results}
+		if entry.Results == nil {
+			entry.Results = []*CommandResult{}
+		}
+		if err != nil {
+			entry.Error = redact(err.Error())
+			entry.ErrorClass = errorClass(err)
+		}
+		resp.Entries[i] = entry
+	}
+
+	sem := make(chan struct{}, req.Concurrency)
+	var wg sync.WaitGroup
+	for i, entry := range req.Entries {
+		if !allowed(entry.ConnectionID) {
+			finish(i, nil, errors.New("connection not shared with caller"))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, entry BulkEntry) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				finish(i, nil, ctx.Err())
+				return
+			}
+			defer func() { <-sem }()
+			results, err := runEntry(withRequestID(ctx, childRequestID(parentID, i)), entry, req.StopOnError)
+			finish(i, results, err)
+		}(i, entry)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		for i := range req.Entries {
+			finish(i, nil, ctx.Err())
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		resp.Status = "cancelled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		resp.Status = "deadline_exceeded"
+	}
+	for _, entry := range resp.Entries {
+		if entry.Error != "" {
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+	}
+	resp.Duration = time.Since(started).Seconds()
+	return resp
+}
+
+func registerBulkRoutes(r *gin.Engine) {
+	r.POST("/execute/bulk", func(c *gin.Context) {
+		var req BulkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Entries) == 0 || len(req.Entries) > bulkMaxEntries {
+			respondError(c, http.StatusBadRequest, fmt.Errorf("entries must contain 1 to %d items", bulkMaxEntries))
+			return
+		}
+		if req.Concurrency <= 0 || req.Concurrency > bulkMaxConcurrency {
+			req.Concurrency = bulkMaxConcurrency
+		}
+		if req.Timeout <= 0 {
+			req.Timeout = bulkDefaultTimeout
+		}
+		if req.Timeout > bulkMaxTimeout {
+			req.Timeout = bulkMaxTimeout
+		}
+		if req.BatchID == "" {
+			req.BatchID = newID()
+		}
+
+		// 批次不随请求取消，但沿用请求的span
+		ctx, cancel := context.WithTimeout(withSpanFrom(withRequestID(context.Background(), requestID(c)), c.Request.Context()),
+			time.Duration(req.Timeout)*time.Second)
+		p := currentPrincipal(c)
+		if err := registerBatch(req.BatchID, principalIdentity(p), cancel); err != nil {
+			cancel()
+			respondError(c, http.StatusConflict, err)
+			return
+		}
+		allowed := func(id string) bool { return connectionACLs.Allowed(p, id) }
+		run := func() *BulkResponse {
+			defer unregisterBatch(req.BatchID)
+			defer cancel()
+			return RunBulk(ctx, req, allowed)
+		}
+
+		if req.Async {
+			jobID := jobs.Submit(c.Request.Context(), "bulk_execute", "", nil, func(jobCtx context.Context) (interface{}, error) {
+				// 取消任务同样取消批次
+				stop := make(chan struct{})
+				defer close(stop)
+				go func() {
+					select {
+					case <-jobCtx.Done():
+						cancel()
+					case <-stop:
+					}
+				}()
+				return run(), nil
+			})
+			c.JSON(http.StatusAccepted, gin.H{"batch_id": req.BatchID, "job_id": jobID, "status": "submitted"})
+			return
+		}
+		// 客户端断开时取消批次
+		go func() {
+			select {
+			case <-c.Request.Context().Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		c.JSON(http.StatusOK, run())
+	})
+
+	r.POST("/execute/bulk/:batch_id/cancel", func(c *gin.Context) {
+		p := currentPrincipal(c)
+		if err := cancelBatch(c.Param("batch_id"), p); err != nil {
+			if errors.Is(err, errBatchNotOwned) {
+				denyRequest(c, p, routePermission(c.Request.Method, c.FullPath()), err.Error())
+				return
+			}
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"batch_id": c.Param("batch_id"), "status": "cancelling", "timestamp": time.Now()})
+	})
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errUnsupportedProtocol = errors.New("unsupported protocol")
+	errNotSSHConnection    = errors.New("connection is not an SSH connection")
+)
+
+// Collector 负责建立某一协议的连接，新协议在init中调用registerCollector注册
+type Collector interface {
+	Protocol() string
+	Connect(config SSHConfig) (Connection, error)
+}
+
+// Connection 统一的连接接口，命令策略等公共逻辑由ConnectionManager处理
+type Connection interface {
+	// Execute 执行命令，shell仅对支持多种shell的协议生效
+	Execute(shell, command string) (*CommandResult, error)
+	HealthCheck() error
+	Close() error
+	Info() ConnectionInfo
+}
+
+type ConnectionInfo struct {
+	Protocol   string    `json:"protocol"`
+	DeviceType string    `json:"device_type,omitempty"`
+	Host       string    `json:"host"`
+	Port       int       `json:"port"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+var collectorRegistry = make(map[string]Collector)
+
+func registerCollector(c Collector) {
+	collectorRegistry[c.Protocol()] = c
+}
+
+func registeredProtocols() []string {
+	protocols := make([]string, 0, len(collectorRegistry))
+	for name := range collectorRegistry {
+		protocols = append(protocols, name)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+// newConnectionID 随机生成、带协议前缀的连接ID，如 ssh-3f2a...，在所有协议间唯一
+func newConnectionID(protocol string) string {
+	return protocol + "-" + newID()
+}
+
+// connectionTarget 连接目标 host:port:username，只用于查找同一目标的连接，不作为ID
+func connectionTarget(info ConnectionInfo) string {
+	return net.JoinHostPort(info.Host, strconv.Itoa(info.Port)) + ":" + info.Username
+}
+
+func targetKey(info ConnectionInfo) string {
+	return info.Protocol + ":" + connectionTarget(info)
+}
+
+// 连接状态，由最近一次执行或健康检查决定
+const (
+	connStatusConnected = "connected"
+	connStatusError     = "error"
+	connStatusUnhealthy = "unhealthy"
+)
+
+// connectionMeta 连接的附加信息，用于列表的过滤和排序
+type connectionMeta struct {
+	Alias     string
+	Tags      []string
+	Namespace string
+	Status    string
+	LastUsed  time.Time
+	// Inflight 该连接上正在执行的命令数
+	Inflight int
+	// ConnectTiming 最近一次连接的耗时分解
+	ConnectTiming *ConnectTiming
+}
+
+// ConnectionManager 保存所有协议的连接
+type ConnectionManager struct {
+	connections map[string]Connection
+	meta        map[string]*connectionMeta
+	// targets 协议和连接目标到连接ID，同一目标重复连接时沿用原ID
+	targets map[string]string
+	mutex   sync.RWMutex
+
+	// inflight 正在设备上执行的命令数，用于就绪检查
+	inflight int64
+}
+
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{connections: make(map[string]Connection), meta: make(map[string]*connectionMeta), targets: make(map[string]string)}
+}
+
+func (cm *ConnectionManager) Count() int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return len(cm.connections)
+}
+
+// ConnectionStats 连接数按状态和协议汇总
+type ConnectionStats struct {
+	Total      int            `json:"total"`
+	ByStatus   map[string]int `json:"by_status"`
+	ByProtocol map[string]int `json:"by_protocol"`
+
+	byProtocolStatus map[[2]string]int
+}
+
+func (cm *ConnectionManager) Stats() ConnectionStats {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	stats := ConnectionStats{Total: len(cm.connections), ByStatus: map[string]int{}, ByProtocol: map[string]int{},
+		byProtocolStatus: map[[2]string]int{}}
+	for id, conn := range cm.connections {
+		status := connStatusConnected
+		if meta, ok := cm.meta[id]; ok && meta.Status != "" {
+			status = meta.Status
+		}
+		protocol := conn.Info().Protocol
+		stats.ByStatus[status]++
+		stats.ByProtocol[protocol]++
+		stats.byProtocolStatus[[2]string{protocol, status}]++
+	}
+	return stats
+}
+
+func (cm *ConnectionManager) Inflight() int64 {
+	return atomic.LoadInt64(&cm.inflight)
+}
+
+func (cm *ConnectionManager) Connect(config SSHConfig) (string, error) {
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = "ssh"
+	}
+	c, ok := collectorRegistry[protocol]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errUnsupportedProtocol, protocol)
+	}
+	if _, err := drivers.Lookup(config.DeviceType); err != nil {
+		return "", err
+	}
+
+	ctx, span := startSpan(config.trace, "connect", "protocol", protocol, "net.peer.name", config.Host,
+		"net.peer.port", connectPort(config), "request_id", config.requestID)
+	config.trace = ctx
+	var err error
+	defer func() { span.End(err) }()
+
+	// 连接建立前的错误信息中同样替换本次的凭据，成功后改为按连接ID登记，断开时释放
+	owner := "connect:" + newID()
+	registerSecrets(owner, configSecrets(config)...)
+	defer releaseSecrets(owner)
+
+	target := net.JoinHostPort(config.Host, strconv.Itoa(connectPort(config)))
+	if !config.Force {
+		if err = authBackoff.Check(target, config.Username); err != nil {
+			return "", err
+		}
+	}
+
+	if config.timing == nil {
+		config.timing = &ConnectTiming{}
+	}
+	timing := config.timing
+
+	start := time.Now()
+	timing.StartedAt = start
+	conn, err := c.Connect(config)
+	timing.TotalMS = durationMS(time.Since(start))
+	serviceStats.ObserveConnect(protocol, err)
+	serviceStats.ObserveConnectTiming(protocol, *timing)
+	fields := []interface{}{"request_id", config.requestID, "protocol", protocol, "host", config.Host,
+		"port", connectPort(config), "username", config.Username, "duration_ms", time.Since(start),
+		"connect_timing", timing.phases()}
+	if err != nil {
+		// 在释放本次凭据之前替换错误信息
+		err = redactError(err)
+		serviceStats.ObserveErrorClass(errorClass(err), config.Host)
+		if errorClass(err) == classHostKeyMismatch {
+			forwardHostKeyMismatch(config, err)
+		}
+		if isAuthFailure(err) {
+			authBackoff.Failure(target, config.Username, err)
+		}
+		logWarn("connection.connect_failed", "connect failed", append(fields, "error", err)...)
+		err = &connectAttemptsError{attempts: []ConnectTiming{*timing}, err: err}
+		return "", err
+	}
+	authBackoff.Success(target, config.Username)
+	// 同一目标的连接属于他人时不替换其连接
+	id := cm.targetID(conn.Info())
+	if !connectionACLs.Claim(id, config.principal) {
+		conn.Close()
+		err = fmt.Errorf("%w: %s", errConnectionOwned, id)
+		logWarn("connection.connect_denied", "target already connected by another caller", append(fields, "connection_id", id)...)
+		return "", err
+	}
+	cm.add(id, conn, connectionMeta{Alias: config.Alias, Tags: config.Tags, Namespace: config.namespace, ConnectTiming: timing})
+	registerSecrets(id, configSecrets(config)...)
+	span.SetAttributes("connection_id", id)
+	logInfo("connection.connect", "connected", append(fields, "connection_id", id)...)
+	return id, nil
+}
+
+// namespace 创建连接的调用方命名空间
+func (cm *ConnectionManager) namespace(connectionID string) string {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	if meta, ok := cm.meta[connectionID]; ok {
+		return meta.Namespace
+	}
+	return ""
+}
+
+// touch 记录最近使用时间和状态，从connected变为失败时发布connection_lost，恢复时发布connection_restored
+func (cm *ConnectionManager) touch(connectionID, status string, cause error) {
+	cm.mutex.Lock()
+	meta, ok := cm.meta[connectionID]
+	var previous, namespace string
+	if ok {
+		previous, namespace = meta.Status, meta.Namespace
+		meta.Status, meta.LastUsed = status, time.Now()
+	}
+	conn := cm.connections[connectionID]
+	cm.mutex.Unlock()
+
+	if !ok || conn == nil || previous == status {
+		return
+	}
+	data := map[string]interface{}{"status": status, "previous_status": previous}
+	if cause != nil {
+		data["error"], data["error_class"] = redact(cause.Error()), errorClass(cause)
+	}
+	switch {
+	case previous == connStatusConnected:
+		publishConnectionEvent(eventConnectionLost, connectionID, conn.Info(), namespace, data)
+	case status == connStatusConnected:
+		publishConnectionEvent(eventConnectionRestored, connectionID, conn.Info(), namespace, data)
+	}
+}
+
+func (cm *ConnectionManager) trackInflight(connectionID string, delta int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if meta, ok := cm.meta[connectionID]; ok {
+		meta.Inflight += delta
+	}
+}
+
+// Lookup 按协议和连接目标查找已有连接的ID
+func (cm *ConnectionManager) Lookup(info ConnectionInfo) (string, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	id, ok := cm.targets[targetKey(info)]
+	return id, ok
+}
+
+// targetID 同一目标已有连接时返回其ID，否则生成新ID
+func (cm *ConnectionManager) targetID(info ConnectionInfo) string {
+	if id, ok := cm.Lookup(info); ok {
+		return id
+	}
+	return newConnectionID(info.Protocol)
+}
+
+// Add 保存已建立的连接，同一目标重复连接时沿用原ID，替换并关闭旧连接
+func (cm *ConnectionManager) Add(conn Connection) string {
+	id := cm.targetID(conn.Info())
+	cm.add(id, conn, connectionMeta{})
+	return id
+}
+
+func (cm *ConnectionManager) add(id string, conn Connection, meta connectionMeta) {
+	meta.Status = connStatusConnected
+	key := targetKey(conn.Info())
+
+	cm.mutex.Lock()
+	old, exists := cm.connections[id]
+	// 同一目标并发建立的连接，后完成的替换先完成的
+	var raced Connection
+	if previous, ok := cm.targets[key]; ok && previous != id {
+		raced = cm.connections[previous]
+		delete(cm.connections, previous)
+		delete(cm.meta, previous)
+	}
+	cm.connections[id] = conn
+	cm.meta[id] = &meta
+	cm.targets[key] = id
+	cm.mutex.Unlock()
+	connStats.Connected(id)
+
+	if exists && old != conn {
+		old.Close()
+	}
+	if raced != nil {
+		raced.Close()
+	}
+	publishConnectionEvent(eventConnectionCreated, id, conn.Info(), meta.Namespace,
+		map[string]interface{}{"alias": meta.Alias, "tags": meta.Tags, "replaced": exists})
+}
+
+// ResultRecord 发送到sinks的执行结果，各协议格式一致
+type ResultRecord struct {
+	ConnectionID string                 `json:"connection_id"`
+	Protocol     string                 `json:"protocol"`
+	Host         string                 `json:"host"`
+	Result       *CommandResult         `json:"result"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
+
+	// acl 执行时连接的属主和共享列表，启用连接ACL时按它判断结果的可见性
+	acl *ConnectionACL
+}
+
+func publishResult(connectionID string, info ConnectionInfo, result *CommandResult, fields map[string]interface{}) {
+	if result.ID == "" {
+		result.ID = newID()
+	}
+	record := ResultRecord{
+		ConnectionID: connectionID,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		Result:       result,
+		Fields:       fields,
+		acl:          connectionACLs.aclSnapshot(connectionID),
+	}
+	results.Add(record)
+	resultStore.Enqueue(record, collector.namespace(connectionID))
+	metricRules.Observe(record)
+	sinks.Publish("result", record)
+}
+
+func (cm *ConnectionManager) get(connectionID string) (Connection, error) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	conn, exists := cm.connections[connectionID]
+	if !exists {
+		return nil, errConnectionNotFound
+	}
+	return conn, nil
+}
+
+func (cm *ConnectionManager) ExecuteCommand(connectionID, command string) (*CommandResult, error) {
+	return cm.ExecuteShell(connectionID, "", command)
+}
+
+// ExecuteShell 执行命令，shell仅对WinRM连接生效
+func (cm *ConnectionManager) ExecuteShell(connectionID, shell, command string) (*CommandResult, error) {
+	return cm.Execute(CommandRequest{ConnectionID: connectionID, Shell: shell, Command: command})
+}
+
+// Execute 对所有协议统一做命令策略检查后执行，并按设备驱动识别被拒绝的命令
+func (cm *ConnectionManager) Execute(req CommandRequest) (result *CommandResult, err error) {
+	connectionID, command := req.ConnectionID, req.Command
+	if err := compileExtractRules(req.Extract); err != nil {
+		return nil, err
+	}
+	if req.Unmasked && !maskAllowUnmasked {
+		publishPolicyDenied(req, ConnectionInfo{}, "", errUnmaskedDenied)
+		return nil, errUnmaskedDenied
+	}
+	conn, err := cm.get(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	namespace := cm.namespace(connectionID)
+	parent := req.trace
+	if parent == nil {
+		parent = req.ctx
+	}
+	traceCtx, span := startSpan(parent, "execute", "connection_id", connectionID, "protocol", conn.Info().Protocol,
+		"net.peer.name", conn.Info().Host, "request_id", req.requestID)
+	defer func() {
+		if result != nil && result.ExitCode != nil {
+			span.SetAttributes("exit_code", *result.ExitCode)
+		}
+		span.End(err)
+	}()
+	if err := commandPolicy.Check(command); err != nil {
+		publishPolicyDenied(req, conn.Info(), namespace, err)
+		return nil, err
+	}
+	// 缓存命中时不访问设备，命令策略仍然生效
+	if cached := resultCache.Fresh(connectionID, req); cached != nil {
+		postProcess(cached, req, connectionID, conn.Info())
+		return cached, nil
+	}
+
+	atomic.AddInt64(&cm.inflight, 1)
+	defer atomic.AddInt64(&cm.inflight, -1)
+	cm.trackInflight(connectionID, 1)
+	defer cm.trackInflight(connectionID, -1)
+
+	start := time.Now()
+	var elapsed time.Duration
+	publishCommandStarted(req, conn.Info(), namespace)
+	// 慢命令按包含流式输出和后处理在内的总耗时判断
+	defer func() {
+		if result != nil {
+			result.timing.PostProcess = time.Since(start) - elapsed
+		}
+		observeSlowCommand(req, conn.Info(), time.Since(start), result, err)
+		publishCommandFinished(req, conn.Info(), namespace, time.Since(start), result, err)
+		if errorClass(err) == classPolicyDenied {
+			publishPolicyDenied(req, conn.Info(), namespace, err)
+		}
+	}()
+	sshConn, isSSH := conn.(*SSHConnection)
+	switch {
+	case req.ForwardAgent && !isSSH:
+		return nil, fmt.Errorf("%w: agent forwarding requires ssh", errNotSSHConnection)
+	case isSSH:
+		// 与SSHConnection.Execute相同，只是带上取消和追踪的ctx
+		ctx := req.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx = withSpanFrom(ctx, traceCtx)
+		forwardAgent := req.ForwardAgent || sshConn.Config.ForwardAgent
+		if req.stream == nil {
+			result, err = sshConn.execute(ctx, command, forwardAgent, nil)
+			break
+		}
+		stream := newLineMasker(conn.Info().DeviceType, req.stream)
+		result, err = sshConn.execute(ctx, command, forwardAgent, stream)
+		stream.Flush()
+	default:
+		result, err = conn.Execute(req.Shell, command)
+	}
+	elapsed = time.Since(start)
+	if result != nil && result.ErrorClass == "" {
+		result.ErrorClass = resultErrorClass(result, err)
+	}
+	serviceStats.ObserveCommand(conn.Info(), elapsed, result, err)
+	switch {
+	case err != nil:
+		serviceStats.ObserveErrorClass(errorClass(err), conn.Info().Host)
+	case result.ErrorClass != "":
+		serviceStats.ObserveErrorClass(result.ErrorClass, conn.Info().Host)
+	}
+	connStats.ObserveCommand(connectionID, command, elapsed, result, err)
+	// 命令内容可能带有sudo密码等，不写入日志
+	fields := []interface{}{"request_id", req.requestID, "connection_id", connectionID, "protocol", conn.Info().Protocol,
+		"host", conn.Info().Host, "duration_ms", elapsed}
+	if err != nil {
+		logWarn("command.execute_failed", "command failed", append(fields, "error", err)...)
+	} else {
+		logInfo("command.execute", "command executed", append(fields, "output_bytes", len(result.Output)+len(result.Stderr))...)
+	}
+	if err != nil {
+		cm.touch(connectionID, connStatusError, err)
+		return nil, err
+	}
+	cm.touch(connectionID, connStatusConnected, nil)
+	result.RequestID = req.requestID
+	if driver, err := drivers.Lookup(conn.Info().DeviceType); err == nil && result.Error == "" {
+		if line := driver.Rejected(result.Output); line != "" {
+			result.Error = "command rejected: " + line
+		}
+	}
+	// 存储和返回的命令（如sudo -S、enable）以及错误信息中不保留凭据
+	result.Command, result.Error = redact(result.Command), redact(result.Error)
+	// 先脱敏再解析，parsed、fields以及存储和sinks中的结果均不含敏感信息
+	raw := maskResult(result, conn.Info().DeviceType)
+	logCommandOutput(req, conn.Info(), namespace, result)
+	if req.Unmasked {
+		result.unmasked = raw
+	}
+	postProcess(result, req, connectionID, conn.Info())
+	publishResult(connectionID, conn.Info(), result, result.Fields)
+	if req.Shell == "" {
+		resultCache.Store(connectionID, conn.Info(), result, connectionACLs.aclSnapshot(connectionID))
+	}
+	return result, nil
+}
+
+// postProcess 对脱敏后的输出按请求解析、提取、转换并执行脚本，缓存命中时同样处理
+func postProcess(result *CommandResult, req CommandRequest, connectionID string, info ConnectionInfo) {
+	if req.Parse != nil {
+		applyParse(result, req.Parse, info.DeviceType)
+	} else if parseAutoJSON {
+		autoParseJSON(result)
+	}
+	if len(req.Extract) > 0 {
+		result.Fields = applyExtract(req.Extract, result.Output)
+	}
+	if req.Transform != "" {
+		applyTransform(result, req.Transform)
+	}
+	if req.Script != "" {
+		applyScript(result, req.Script, connectionID, info)
+	}
+}
+
+func (cm *ConnectionManager) HealthCheck(connectionID string) error {
+	conn, err := cm.get(connectionID)
+	if err != nil {
+		return err
+	}
+	err = conn.HealthCheck()
+	serviceStats.ObserveHealthCheck(conn.Info().Protocol, err)
+	connStats.ObserveKeepalive(connectionID, err)
+	if err != nil {
+		cm.touch(connectionID, connStatusUnhealthy, err)
+		return err
+	}
+	cm.touch(connectionID, connStatusConnected, nil)
+	return nil
+}
+
+func (cm *ConnectionManager) Disconnect(connectionID string) error {
+	cm.mutex.Lock()
+	conn, exists := cm.connections[connectionID]
+	var namespace string
+	if meta, ok := cm.meta[connectionID]; ok {
+		namespace = meta.Namespace
+	}
+	delete(cm.connections, connectionID)
+	delete(cm.meta, connectionID)
+	if exists && cm.targets[targetKey(conn.Info())] == connectionID {
+		delete(cm.targets, targetKey(conn.Info()))
+	}
+	cm.mutex.Unlock()
+
+	if !exists {
+		return errConnectionNotFound
+	}
+	publishConnectionEvent(eventConnectionClosed, connectionID, conn.Info(), namespace, nil)
+	releaseSecrets(connectionID)
+	connStats.Remove(connectionID)
+	logInfo("connection.disconnect", "disconnected", "connection_id", connectionID, "protocol", conn.Info().Protocol, "host", conn.Info().Host)
+	return conn.Close()
+}
+
+func (cm *ConnectionManager) ListConnections() map[string]interface{} {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	connections := make(map[string]interface{})
+	for id, conn := range cm.connections {
+		connections[id] = conn.Info()
+	}
+	return connections
+}
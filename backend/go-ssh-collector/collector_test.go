@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 连接ID随机生成并带协议前缀，同一目标重复连接时沿用原ID，断开后重连得到新ID
+func TestConnectionIDsAreOpaque(t *testing.T) {
+	a, b := startTestSSHServer(t), startTestSSHServer(t)
+	idA := connectTestSSH(t, a)
+	if !strings.HasPrefix(idA, "ssh-") || len(idA) != len("ssh-")+32 || strings.Contains(idA, "127.0.0.1") {
+		t.Fatalf("connection id = %q", idA)
+	}
+	if again := connectTestSSH(t, a); again != idA {
+		t.Fatalf("reconnecting the same target: id %q, want %q", again, idA)
+	}
+	idB := connectTestSSH(t, b)
+	if idB == idA {
+		t.Fatalf("two targets share id %q", idA)
+	}
+
+	conn, err := collector.get(idA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := collector.Lookup(conn.Info()); !ok || id != idA {
+		t.Fatalf("Lookup = %q, %v", id, ok)
+	}
+	if err := collector.Disconnect(idA); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := collector.Lookup(conn.Info()); ok {
+		t.Fatal("target still mapped after disconnect")
+	}
+	if id := connectTestSSH(t, a); id == idA {
+		t.Fatalf("reconnect after disconnect reused id %q", id)
+	}
+}
+
+func TestConnectionsLookupByTarget(t *testing.T) {
+	a, b := startTestSSHServer(t), startTestSSHServer(t)
+	idA := connectTestSSH(t, a)
+	connectTestSSH(t, b)
+	target := fmt.Sprintf("127.0.0.1:%d:%s", a.Port(), testSSHUser)
+
+	w := apiRequest(newRouter(), "", http.MethodGet, "/connections?target="+target, "")
+	var page ConnectionPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if len(page.Connections) != 1 || page.Connections[0].ID != idA || page.Connections[0].Target != target {
+		t.Fatalf("connections = %+v", page.Connections)
+	}
+	// 连接ID不能当作目标查找
+	if _, err := collector.get(target); err == nil {
+		t.Fatal("target accepted as a connection id")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应压缩：客户端 Accept-Encoding 包含gzip时压缩响应。
+// 响应体小于 COMPRESSION_MIN_SIZE 字节、Content-Type 匹配 COMPRESSION_SKIP_TYPES（前缀匹配）、
+// 已设置Content-Encoding、Range请求和WebSocket升级不压缩。
+// SSE等流式响应在每次Flush时同时刷新gzip缓冲，事件不会被攒在压缩器中。
+// COMPRESSION_LEVEL 为1-9，COMPRESSION_ENABLED=false 关闭
+
+var (
+	compressionEnabled = getEnv("COMPRESSION_ENABLED", "true") == "true"
+	compressionLevel   = int(envInt64("COMPRESSION_LEVEL", gzip.DefaultCompression))
+	compressionMinSize = int(envInt64("COMPRESSION_MIN_SIZE", 1024))
+	compressionSkip    = splitList(getEnv("COMPRESSION_SKIP_TYPES",
+		"image/,video/,audio/,application/gzip,application/x-gzip,application/zip,application/zstd,application/x-xz,application/x-bzip2,application/octet-stream"))
+)
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, err := gzip.NewWriterLevel(nil, compressionLevel)
+	if err != nil {
+		w = gzip.NewWriter(nil)
+	}
+	return w
+}}
+
+// acceptsGzip 忽略q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				q, _ = strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64)
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, prefix := range compressionSkip {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// gzipResponseWriter 先缓存不超过最小长度的数据，确定压缩与否后再写出
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide 根据响应头决定是否压缩，并写出已缓存的数据
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	if compress && header.Get("Content-Encoding") == "" && compressibleType(header.Get("Content-Type")) &&
+		w.Status() != http.StatusPartialContent {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	data := w.buf.Bytes()
+	w.buf = bytes.Buffer{}
+	if w.gz != nil {
+		_, err := w.gz.Write(data)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(data)
+	return err
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if w.buf.Len()+len(data) < compressionMinSize {
+			return w.buf.Write(data)
+		}
+		w.buf.Write(data)
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 流式响应不等待最小长度，直接开始压缩
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish 响应结束时调用，未达到最小长度的响应原样写出
+func (w *gzipResponseWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// compressionMiddleware 在请求ID、日志和Recovery之后、其它中间件之前注册，内层看到的都是未压缩的数据
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !compressionEnabled || c.Request.Method == http.MethodHead || c.GetHeader("Range") != "" ||
+			c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+		original := c.Writer
+		writer := &gzipResponseWriter{ResponseWriter: original}
+		c.Writer = writer
+		defer func() {
+			writer.finish()
+			c.Writer = original
+		}()
+		c.Next()
+	}
+}
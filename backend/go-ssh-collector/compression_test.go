@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"GZIP":                true,
+		"deflate, gzip;q=0.5": true,
+		"gzip;q=0":            false,
+		"gzip; q=0, br":       false,
+		"*":                   true,
+		"identity":            false,
+		"br;q=1.0, *;q=0.1":   true,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func compressionRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(compressionMiddleware())
+	large := strings.Repeat("interface GigabitEthernet0/1 is up\n", 100)
+	r.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, large) })
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(large)) })
+	r.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "br")
+		c.Data(http.StatusOK, "text/plain", []byte(large))
+	})
+	r.GET("/partial", func(c *gin.Context) { c.Data(http.StatusPartialContent, "text/plain", []byte(large)) })
+	return r
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	r := compressionRouter()
+	cases := []struct {
+		name, path, accept, rangeHeader string
+		gzipped                         bool
+	}{
+		{"large", "/large", "gzip", "", true},
+		{"no accept-encoding", "/large", "", "", false},
+		{"q=0", "/large", "gzip;q=0", "", false},
+		{"below min size", "/small", "gzip", "", false},
+		{"skipped type", "/image", "gzip", "", false},
+		{"already encoded", "/encoded", "gzip", "", false},
+		{"partial content", "/partial", "gzip", "", false},
+		{"range request", "/large", "gzip", "bytes=0-10", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tc.gzipped {
+				t.Fatalf("gzipped = %v, want %v (Content-Encoding %q)", got, tc.gzipped, w.Header().Get("Content-Encoding"))
+			}
+			if tc.rangeHeader == "" && !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+				t.Fatalf("Vary = %q", w.Header().Get("Vary"))
+			}
+			body := w.Body.Bytes()
+			if tc.gzipped {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+				if w.Header().Get("Content-Length") != "" {
+					t.Fatalf("Content-Length kept on a gzipped response")
+				}
+			}
+			if tc.path == "/small" && string(body) != "ok" || tc.path == "/large" && !strings.HasPrefix(string(body), "interface GigabitEthernet0/1") {
+				t.Fatalf("body = %.40q", body)
+			}
+		})
+	}
+}
+
+// 压缩的SSE事件在Flush时就到达客户端，不等处理函数返回
+func TestCompressionFlushesSSEEvents(t *testing.T) {
+	delivered := make(chan struct{})
+	r := gin.New()
+	r.Use(compressionMiddleware())
+	r.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.SSEvent("status", "first")
+		c.Writer.Flush()
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+		}
+		c.SSEvent("status", "second")
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(zr)
+	var events []string
+	for lines.Scan() {
+		if data, ok := strings.CutPrefix(lines.Text(), "data:"); ok {
+			events = append(events, data)
+			if len(events) == 1 {
+				// 处理函数还在等待，第一个事件已经解压出来
+				close(delivered)
+			}
+		}
+	}
+	if len(events) != 2 || events[0] != "first" || events[1] != "second" {
+		t.Fatalf("events = %q", events)
+	}
+}
+
+// 小响应低于最小长度时只多一次缓冲拷贝，与不经过中间件的耗时相近
+func BenchmarkCompressionSmallResponse(b *testing.B) {
+	body := []byte(`{"connection_id":"ssh-1","status":"connected"}`)
+	for _, bench := range []struct {
+		name       string
+		middleware bool
+	}{{"plain", false}, {"gzip", true}} {
+		b.Run(bench.name, func(b *testing.B) {
+			r := gin.New()
+			if bench.middleware {
+				r.Use(compressionMiddleware())
+			}
+			r.GET("/status", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", body) })
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Header().Get("Content-Encoding") != "" {
+					b.Fatal("small response was compressed")
+				}
+			}
+		})
+	}
+}
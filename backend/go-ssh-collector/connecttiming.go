@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// 连接耗时分解：每次连接分别记录DNS解析、TCP建立、SSH握手和认证（Telnet为登录）的耗时，
+// 在 /connect 响应和 GET /connections/:id 中以 connect_timing 返回，并按协议和阶段计入
+// collector_connect_phase_duration_seconds。连接失败时错误响应的 details.connect_attempts
+// 列出每次尝试的分解和失败所在的阶段，用于区分DNS、设备认证和采集服务本身的慢
+
+const (
+	phaseDNS       = "dns"
+	phaseTCP       = "tcp"
+	phaseHandshake = "handshake"
+	phaseAuth      = "auth"
+)
+
+// ConnectTiming 单位毫秒，未到达的阶段省略；host为IP时没有DNS阶段
+type ConnectTiming struct {
+	DNSMS       float64   `json:"dns_ms,omitempty"`
+	TCPMS       float64   `json:"tcp_ms,omitempty"`
+	HandshakeMS float64   `json:"handshake_ms,omitempty"`
+	AuthMS      float64   `json:"auth_ms,omitempty"`
+	TotalMS     float64   `json:"total_ms"`
+	FailedPhase string    `json:"failed_phase,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// observe timing为nil时忽略，协议实现不需要判断
+func (t *ConnectTiming) observe(phase string, elapsed time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	ms := durationMS(elapsed)
+	switch phase {
+	case phaseDNS:
+		t.DNSMS = ms
+	case phaseTCP:
+		t.TCPMS = ms
+	case phaseHandshake:
+		t.HandshakeMS = ms
+	case phaseAuth:
+		t.AuthMS = ms
+	}
+	if err != nil {
+		t.FailedPhase = phase
+	}
+}
+
+// phases 已到达的阶段及其耗时
+func (t ConnectTiming) phases() map[string]float64 {
+	phases := map[string]float64{}
+	for phase, ms := range map[string]float64{phaseDNS: t.DNSMS, phaseTCP: t.TCPMS, phaseHandshake: t.HandshakeMS, phaseAuth: t.AuthMS} {
+		if ms > 0 || phase == t.FailedPhase {
+			phases[phase] = ms
+		}
+	}
+	return phases
+}
+
+// dialTimed 与 safeDialer(timeout).Dial 相同，但分开计时DNS解析和TCP建立，超时覆盖两个阶段
+func dialTimed(timing *ConnectTiming, host string, port int, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		start := time.Now()
+		resolved, err := net.DefaultResolver.LookupHost(ctx, host)
+		timing.observe(phaseDNS, time.Since(start), err)
+		if err != nil {
+			return nil, err
+		}
+		addrs = resolved
+	}
+
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	for _, addr := range addrs {
+		// 目标地址策略在Control中按解析后的地址检查
+		conn, err = safeDialer(timeout).DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	timing.observe(phaseTCP, time.Since(start), err)
+	return conn, err
+}
+
+// connectAttemptsError 连接失败的错误，附带每次尝试的耗时分解
+type connectAttemptsError struct {
+	attempts []ConnectTiming
+	err      error
+}
+
+func (e *connectAttemptsError) Error() string { return e.err.Error() }
+func (e *connectAttemptsError) Unwrap() error { return e.err }
+
+func (e *connectAttemptsError) details() map[string]interface{} {
+	return map[string]interface{}{"connect_attempts": e.attempts}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 连接列表的分页、排序和过滤，page与cursor二选一，cursor由上一页的next_cursor给出
+
+const (
+	connectionPageSizeDefault = 50
+	connectionPageSizeMax     = 500
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// 可排序字段，前缀 "-" 表示降序
+var connectionSortFields = map[string]bool{"created_at": true, "last_used": true, "host": true, "alias": true}
+
+type ConnectionQuery struct {
+	Offset    int
+	PageSize  int
+	Sort      string
+	Desc      bool
+	Host      string
+	Target    string // host:port:username，精确匹配
+	Protocol  string
+	Status    string
+	Tag       string
+	Namespace string
+}
+
+// ConnectionSummary 列表中的一条连接
+type ConnectionSummary struct {
+	ID string `json:"id"`
+	ConnectionInfo
+	// Target host:port:username，可用 ?target= 查找，不是连接ID
+	Target    string     `json:"target"`
+	Alias     string     `json:"alias,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Status    string     `json:"status"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	// ConnectTiming 最近一次连接的耗时分解
+	ConnectTiming *ConnectTiming `json:"connect_timing,omitempty"`
+}
+
+type ConnectionPage struct {
+	Connections []ConnectionSummary `json:"connections"`
+	Total       int                 `json:"total"`
+	Page        int                 `json:"page"`
+	PageSize    int                 `json:"page_size"`
+	NextCursor  string              `json:"next_cursor,omitempty"`
+	Timestamp   time.Time           `json:"timestamp"`
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), "offset:"))
+	if err != nil || offset < 0 || !strings.HasPrefix(string(data), "offset:") {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// parseConnectionQuery query按参数名取值，REST传入c.Query，gRPC由请求消息构造
+func parseConnectionQuery(query func(key string) string) (ConnectionQuery, error) {
+	q := ConnectionQuery{
+		PageSize:  connectionPageSizeDefault,
+		Sort:      "created_at",
+		Host:      strings.ToLower(query("host")),
+		Target:    query("target"),
+		Protocol:  query("protocol"),
+		Status:    query("status"),
+		Tag:       query("tag"),
+		Namespace: query("namespace"),
+	}
+	if v := query("page_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return q, fmt.Errorf("invalid page_size: %s", v)
+		}
+		if size > connectionPageSizeMax {
+			size = connectionPageSizeMax
+		}
+		q.PageSize = size
+	}
+	if v := query("sort"); v != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		if !connectionSortFields[q.Sort] {
+			return q, fmt.Errorf("invalid sort field: %s", q.Sort)
+		}
+	}
+	if cursor := query("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Offset = offset
+	} else if v := query("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page <= 0 {
+			return q, fmt.Errorf("invalid page: %s", v)
+		}
+		q.Offset = (page - 1) * q.PageSize
+	}
+	return q, nil
+}
+
+func (q ConnectionQuery) matches(info ConnectionInfo, meta *connectionMeta) bool {
+	if q.Host != "" && !strings.Contains(strings.ToLower(info.Host), q.Host) {
+		return false
+	}
+	if q.Target != "" && connectionTarget(info) != q.Target {
+		return false
+	}
+	if q.Protocol != "" && info.Protocol != q.Protocol {
+		return false
+	}
+	if q.Status != "" && meta.Status != q.Status {
+		return false
+	}
+	if q.Namespace != "" && meta.Namespace != q.Namespace {
+		return false
+	}
+	if q.Tag != "" {
+		found := false
+		for _, tag := range meta.Tags {
+			if tag == q.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryConnections 在读锁下过滤和排序，只为当前页生成完整条目；visible用于ACL过滤
+func (cm *ConnectionManager) QueryConnections(q ConnectionQuery, visible func(id string) bool) ConnectionPage {
+	type entry struct {
+		id   string
+		info ConnectionInfo
+		meta *connectionMeta
+	}
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	entries := make([]entry, 0)
+	for id, conn := range cm.connections {
+		meta := cm.meta[id]
+		if meta == nil {
+			meta = &connectionMeta{Status: connStatusConnected}
+		}
+		info := conn.Info()
+		if !q.matches(info, meta) || !visible(id) {
+			continue
+		}
+		entries = append(entries, entry{id, info, meta})
+	}
+
+	less := func(a, b entry) bool {
+		switch q.Sort {
+		case "last_used":
+			if !a.meta.LastUsed.Equal(b.meta.LastUsed) {
+				return a.meta.LastUsed.Before(b.meta.LastUsed)
+			}
+		case "host":
+			if a.info.Host != b.info.Host {
+				return a.info.Host < b.info.Host
+			}
+		case "alias":
+			if a.meta.Alias != b.meta.Alias {
+				return a.meta.Alias < b.meta.Alias
+			}
+		default:
+			if !a.info.CreatedAt.Equal(b.info.CreatedAt) {
+				return a.info.CreatedAt.Before(b.info.CreatedAt)
+			}
+		}
+		return a.id < b.id
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if q.Desc {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+
+	page := ConnectionPage{
+		Connections: []ConnectionSummary{},
+		Total:       len(entries),
+		Page:        q.Offset/q.PageSize + 1,
+		PageSize:    q.PageSize,
+	}
+	if q.Offset >= len(entries) {
+		return page
+	}
+	end := q.Offset + q.PageSize
+	if end > len(entries) {
+		end = len(entries)
+	} else if end < len(entries) {
+		page.NextCursor = encodeCursor(end)
+	}
+	for _, e := range entries[q.Offset:end] {
+		page.Connections = append(page.Connections, connectionSummary(e.id, e.info, e.meta))
+	}
+	return page
+}
+
+// Summary 单个连接，GET /connections/:id
+func (cm *ConnectionManager) Summary(connectionID string) (ConnectionSummary, error) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	conn, ok := cm.connections[connectionID]
+	if !ok {
+		return ConnectionSummary{}, errConnectionNotFound
+	}
+	meta := cm.meta[connectionID]
+	if meta == nil {
+		meta = &connectionMeta{Status: connStatusConnected}
+	}
+	return connectionSummary(connectionID, conn.Info(), meta), nil
+}
+
+// connectionSummary 调用方需持有读锁
+func connectionSummary(id string, info ConnectionInfo, meta *connectionMeta) ConnectionSummary {
+	summary := ConnectionSummary{
+		ID:             id,
+		ConnectionInfo: info,
+		Target:         connectionTarget(info),
+		Alias:          meta.Alias,
+		Tags:           append([]string(nil), meta.Tags...),
+		Namespace:      meta.Namespace,
+		Status:         meta.Status,
+	}
+	if !meta.LastUsed.IsZero() {
+		lastUsed := meta.LastUsed
+		summary.LastUsed = &lastUsed
+	}
+	if meta.ConnectTiming != nil {
+		timing := *meta.ConnectTiming
+		summary.ConnectTiming = &timing
+	}
+	return summary
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 连接统计：GET /connections/:id/stats 返回滑动窗口内命令耗时的 p50/p90/p99、按错误类别的成功/失败次数、
+// 收发字节数、重连次数、keepalive失败次数和会话建立耗时，用于区分设备慢还是采集服务慢。
+// 耗时保存在每个连接固定大小的环形缓冲中（CONN_STATS_SAMPLES），只统计 CONN_STATS_WINDOW_SECONDS 内的样本。
+// 同一连接ID重新连接时统计保留并累加重连次数；?since_reconnect=true 只返回最近一次连接之后的数据
+
+var (
+	connStatsSamples = int(envInt64("CONN_STATS_SAMPLES", 256))
+	connStatsWindow  = time.Duration(envInt64("CONN_STATS_WINDOW_SECONDS", 900)) * time.Second
+)
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// latencyRing 最近N个耗时样本，写满后覆盖最旧的
+type latencyRing struct {
+	samples []latencySample
+	next    int
+}
+
+func (r *latencyRing) add(d time.Duration) {
+	sample := latencySample{at: time.Now(), duration: d}
+	if len(r.samples) < connStatsSamples {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// since 返回since之后且在统计窗口内的耗时，已排序
+func (r *latencyRing) since(since time.Time) []time.Duration {
+	if cutoff := time.Now().Add(-connStatsWindow); connStatsWindow > 0 && cutoff.After(since) {
+		since = cutoff
+	}
+	var durations []time.Duration
+	for _, sample := range r.samples {
+		if !sample.at.Before(since) {
+			durations = append(durations, sample.duration)
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations
+}
+
+// LatencySummary 单位毫秒
+type LatencySummary struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+func summarizeLatency(sorted []time.Duration) LatencySummary {
+	summary := LatencySummary{Samples: len(sorted)}
+	if len(sorted) == 0 {
+		return summary
+	}
+	// 最近秩法，样本少时p99即最大值
+	rank := func(q float64) float64 {
+		i := int(q*float64(len(sorted))+0.999999) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(sorted[i].Microseconds()) / 1000
+	}
+	summary.P50, summary.P90, summary.P99 = rank(0.5), rank(0.9), rank(0.99)
+	summary.Max = float64(sorted[len(sorted)-1].Microseconds()) / 1000
+	return summary
+}
+
+// connCounters 连接的累计计数
+type connCounters struct {
+	Commands          int64            `json:"commands"`
+	Successes         int64            `json:"successes"`
+	Failures          map[string]int64 `json:"failures"`
+	BytesSent         int64            `json:"bytes_sent"`
+	BytesReceived     int64            `json:"bytes_received"`
+	KeepaliveChecks   int64            `json:"keepalive_checks"`
+	KeepaliveFailures int64            `json:"keepalive_failures"`
+}
+
+func (c connCounters) clone() connCounters {
+	c.Failures = copyFailures(c.Failures)
+	return c
+}
+
+func copyFailures(failures map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(failures))
+	for class, n := range failures {
+		copied[class] = n
+	}
+	return copied
+}
+
+// minus 返回相对重连时基准值的增量
+func (c connCounters) minus(base connCounters) connCounters {
+	diff := connCounters{
+		Commands:          c.Commands - base.Commands,
+		Successes:         c.Successes - base.Successes,
+		Failures:          map[string]int64{},
+		BytesSent:         c.BytesSent - base.BytesSent,
+		BytesReceived:     c.BytesReceived - base.BytesReceived,
+		KeepaliveChecks:   c.KeepaliveChecks - base.KeepaliveChecks,
+		KeepaliveFailures: c.KeepaliveFailures - base.KeepaliveFailures,
+	}
+	for class, n := range c.Failures {
+		if n -= base.Failures[class]; n > 0 {
+			diff.Failures[class] = n
+		}
+	}
+	return diff
+}
+
+type connStatsEntry struct {
+	created     time.Time
+	connectedAt time.Time
+	reconnects  int
+	counters    connCounters
+	baseline    connCounters
+	commands    latencyRing
+	sessions    latencyRing
+}
+
+// ConnectionStatsReport GET /connections/:id/stats 的响应
+type ConnectionStatsReport struct {
+	ConnectionID   string    `json:"connection_id"`
+	Since          time.Time `json:"since"`
+	ConnectedAt    time.Time `json:"connected_at"`
+	Reconnects     int       `json:"reconnects"`
+	SinceReconnect bool      `json:"since_reconnect"`
+	WindowSeconds  int64     `json:"window_seconds"`
+	connCounters
+	CommandLatency     LatencySummary `json:"command_latency_ms"`
+	SessionOpenLatency LatencySummary `json:"session_open_latency_ms"`
+	Timestamp          time.Time      `json:"timestamp"`
+}
+
+// ConnStatsRegistry 按连接ID保存统计，连接断开时删除
+type ConnStatsRegistry struct {
+	entries map[string]*connStatsEntry
+	mutex   sync.Mutex
+}
+
+var connStats = &ConnStatsRegistry{entries: make(map[string]*connStatsEntry)}
+
+// Connected 在连接建立时调用，同一ID已有统计时记为一次重连
+func (r *ConnStatsRegistry) Connected(connectionID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	entry, ok := r.entries[connectionID]
+	if !ok {
+		r.entries[connectionID] = &connStatsEntry{created: now, connectedAt: now, counters: connCounters{Failures: map[string]int64{}}}
+		return
+	}
+	entry.reconnects++
+	entry.connectedAt = now
+	entry.baseline = entry.counters.clone()
+}
+
+func (r *ConnStatsRegistry) Remove(connectionID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.entries, connectionID)
+}
+
+// ObserveCommand 在每次执行后调用；err为执行错误，结果中的错误（超时、非0退出等）同样计为失败，
+// 失败按error_class分类
+func (r *ConnStatsRegistry) ObserveCommand(connectionID, command string, elapsed time.Duration, result *CommandResult, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry, ok := r.entries[connectionID]
+	if !ok {
+		return
+	}
+	counters := &entry.counters
+	counters.Commands++
+	counters.BytesSent += int64(len(command))
+	entry.commands.add(elapsed)
+	switch {
+	case err != nil:
+		counters.Failures[failureClass(errorClass(err))]++
+	case result.Error != "":
+		counters.Failures[failureClass(result.ErrorClass)]++
+	default:
+		counters.Successes++
+	}
+	if result != nil {
+		counters.BytesReceived += int64(len(result.Output) + len(result.Stderr))
+	}
+}
+
+func (r *ConnStatsRegistry) ObserveKeepalive(connectionID string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entry, ok := r.entries[connectionID]; ok {
+		entry.counters.KeepaliveChecks++
+		if err != nil {
+			entry.counters.KeepaliveFailures++
+		}
+	}
+}
+
+// ObserveSessionOpen 记录SSH会话建立耗时
+func (r *ConnStatsRegistry) ObserveSessionOpen(connectionID string, elapsed time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entry, ok := r.entries[connectionID]; ok {
+		entry.sessions.add(elapsed)
+	}
+}
+
+func (r *ConnStatsRegistry) Report(connectionID string, sinceReconnect bool) (ConnectionStatsReport, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry, ok := r.entries[connectionID]
+	if !ok {
+		return ConnectionStatsReport{}, errConnectionNotFound
+	}
+	report := ConnectionStatsReport{
+		ConnectionID:   connectionID,
+		Since:          entry.created,
+		ConnectedAt:    entry.connectedAt,
+		Reconnects:     entry.reconnects,
+		SinceReconnect: sinceReconnect,
+		WindowSeconds:  int64(connStatsWindow / time.Second),
+		connCounters:   entry.counters.clone(),
+		Timestamp:      time.Now(),
+	}
+	if sinceReconnect {
+		report.Since = entry.connectedAt
+		report.connCounters = entry.counters.minus(entry.baseline)
+	}
+	report.CommandLatency = summarizeLatency(entry.commands.since(report.Since))
+	report.SessionOpenLatency = summarizeLatency(entry.sessions.since(report.Since))
+	return report, nil
+}
+
+func registerConnStatsRoutes(r *gin.Engine) {
+	r.GET("/connections/:id/stats", func(c *gin.Context) {
+		report, err := connStats.Report(c.Param("id"), c.Query("since_reconnect") == "true")
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
+
+func failureClass(class string) string {
+	if class == "" {
+		return classOther
+	}
+	return class
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// RFC2217 客户端到服务端的子命令
+const (
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+
+	comPortControlNoFlow   = 1
+	comPortControlXonXoff  = 2
+	comPortControlHardware = 3
+	comPortControlBreakOn  = 5
+	comPortControlBreakOff = 6
+)
+
+const (
+	consoleDefaultBreak = 500 * time.Millisecond
+	consoleMaxBreak     = 5 * time.Second
+)
+
+var (
+	errConsoleBusy = errors.New("console line busy")
+	errNotConsole  = errors.New("connection is not a console connection")
+
+	comPortParity      = map[string]byte{"none": 1, "odd": 2, "even": 3, "mark": 4, "space": 5}
+	comPortFlowControl = map[string]byte{"none": comPortControlNoFlow, "xonxoff": comPortControlXonXoff, "rtscts": comPortControlHardware}
+
+	consoleLineRegistry = &ConsoleLines{lines: make(map[string]*consoleLine)}
+)
+
+// consoleLine 串口不能复用，同一线路同时只允许一个会话，其余请求排队等待
+type consoleLine struct {
+	sem     chan struct{}
+	waiting int32
+	holder  string
+	since   time.Time
+}
+
+type ConsoleLines struct {
+	lines map[string]*consoleLine
+	mutex sync.Mutex
+}
+
+func (cl *ConsoleLines) line(key string) *consoleLine {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	line, ok := cl.lines[key]
+	if !ok {
+		line = &consoleLine{sem: make(chan struct{}, 1)}
+		cl.lines[key] = line
+	}
+	return line
+}
+
+// Acquire 在超时内等待线路空闲，返回释放函数
+func (cl *ConsoleLines) Acquire(key, holder string, timeout time.Duration) (func(), error) {
+	line := cl.line(key)
+	atomic.AddInt32(&line.waiting, 1)
+	defer atomic.AddInt32(&line.waiting, -1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case line.sem <- struct{}{}:
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: %s", errConsoleBusy, key)
+	}
+
+	cl.mutex.Lock()
+	line.holder, line.since = holder, time.Now()
+	cl.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cl.mutex.Lock()
+			line.holder = ""
+			cl.mutex.Unlock()
+			<-line.sem
+		})
+	}, nil
+}
+
+func (cl *ConsoleLines) List() []gin.H {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	list := make([]gin.H, 0, len(cl.lines))
+	for key, line := range cl.lines {
+		item := gin.H{
+			"line":    key,
+			"in_use":  line.holder != "",
+			"waiting": atomic.LoadInt32(&line.waiting),
+		}
+		if line.holder != "" {
+			item["holder"] = line.holder
+			item["since"] = line.since
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// ConsoleConnection 复用Telnet的交互引擎，支持RFC2217串口参数与break信号
+type ConsoleConnection struct {
+	*TelnetConnection
+
+	line      string
+	release   func()
+	sendBreak func(time.Duration) error
+	closeFn   func() error
+}
+
+type consoleCollector struct{}
+
+func init() {
+	registerCollector(consoleCollector{})
+}
+
+func (consoleCollector) Protocol() string { return "console" }
+
+func (consoleCollector) Connect(config SSHConfig) (Connection, error) {
+	if config.Transport == "" {
+		config.Transport = "rfc2217"
+	}
+	if config.Port == 0 {
+		config.Port = 23
+		if config.Transport == "ssh" {
+			config.Port = 22
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+
+	driver, err := drivers.Lookup(config.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := compilePrompt(firstNonEmpty(config.Prompt, driver.Prompt), defaultPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	// SSH前置的控制台通常以用户名区分端口
+	line := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	if config.Transport == "ssh" {
+		line += ":" + config.Username
+	}
+	release, err := consoleLineRegistry.Acquire(line, config.Username, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &ConsoleConnection{line: line, release: release}
+	switch config.Transport {
+	case "rfc2217":
+		err = cc.dialRFC2217(config, prompt, timeout)
+	case "ssh":
+		err = cc.dialSSH(config, prompt, timeout)
+	default:
+		err = fmt.Errorf("unsupported console transport: %s", config.Transport)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// 串口控制台在收到回车前不会输出登录提示
+	if err := cc.writeLine(""); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	if err := cc.start(config, driver); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (cc *ConsoleConnection) dialRFC2217(config SSHConfig, prompt *regexp.Regexp, timeout time.Duration) error {
+	address := net.JoinHostPort(config.Host, fmt.Sprint(config.Port))
+	conn, err := safeDialer(timeout).Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	cc.TelnetConnection = newTelnetConnection(config, conn, prompt, timeout)
+	cc.comPort = true
+	cc.closeFn = conn.Close
+	cc.sendBreak = func(d time.Duration) error {
+		if err := cc.comPortCommand(comPortSetControl, []byte{comPortControlBreakOn}); err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return cc.comPortCommand(comPortSetControl, []byte{comPortControlBreakOff})
+	}
+
+	conn.Write([]byte{telnetIAC, telnetWILL, telnetOptComPort})
+	if err := cc.configurePort(config); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// configurePort 下发串口参数，未设置的项保持终端服务器当前配置
+func (cc *ConsoleConnection) configurePort(config SSHConfig) error {
+	if config.BaudRate > 0 {
+		baud := make([]byte, 4)
+		binary.BigEndian.PutUint32(baud, uint32(config.BaudRate))
+		if err := cc.comPortCommand(comPortSetBaudRate, baud); err != nil {
+			return err
+		}
+	}
+	if config.DataBits > 0 {
+		if config.DataBits < 5 || config.DataBits > 8 {
+			return fmt.Errorf("invalid data bits: %d", config.DataBits)
+		}
+		if err := cc.comPortCommand(comPortSetDataSize, []byte{byte(config.DataBits)}); err != nil {
+			return err
+		}
+	}
+	if config.Parity != "" {
+		parity, ok := comPortParity[config.Parity]
+		if !ok {
+			return fmt.Errorf("invalid parity: %s", config.Parity)
+		}
+		if err := cc.comPortCommand(comPortSetParity, []byte{parity}); err != nil {
+			return err
+		}
+	}
+	if config.StopBits > 0 {
+		if config.StopBits > 2 {
+			return fmt.Errorf("invalid stop bits: %d", config.StopBits)
+		}
+		if err := cc.comPortCommand(comPortSetStopSize, []byte{byte(config.StopBits)}); err != nil {
+			return err
+		}
+	}
+	if config.FlowControl != "" {
+		flow, ok := comPortFlowControl[config.FlowControl]
+		if !ok {
+			return fmt.Errorf("invalid flow control: %s", config.FlowControl)
+		}
+		if err := cc.comPortCommand(comPortSetControl, []byte{flow}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comPortCommand 发送 IAC SB COM-PORT-OPTION <cmd> <value> IAC SE
+func (cc *ConsoleConnection) comPortCommand(cmd byte, value []byte) error {
+	frame := []byte{telnetIAC, telnetSB, telnetOptComPort, cmd}
+	for _, b := range value {
+		frame = append(frame, b)
+		if b == telnetIAC {
+			frame = append(frame, telnetIAC)
+		}
+	}
+	frame = append(frame, telnetIAC, telnetSE)
+
+	cc.conn.SetWriteDeadline(time.Now().Add(cc.timeout))
+	defer cc.conn.SetWriteDeadline(time.Time{})
+	_, err := cc.conn.Write(frame)
+	return err
+}
+
+func (cc *ConsoleConnection) dialSSH(config SSHConfig, prompt *regexp.Regexp, timeout time.Duration) error {
+	sshConfig := config
+	sshConfig.Protocol = "ssh"
+	conn, err := sshCollector{}.Connect(sshConfig)
+	if err != nil {
+		return err
+	}
+	client := conn.(*SSHConnection).Client
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to create session: %w", withErrorClass(classSessionOpenFailed, err))
+	}
+	stream, err := newSessionConn(session, client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	if err := session.RequestPty("vt100", 24, 200, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to request pty: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to start shell: %v", err)
+	}
+	// 会话关闭或连接断开时Wait返回
+	release := trackSession(sessionShell)
+	go func() {
+		session.Wait()
+		release()
+	}()
+
+	cc.TelnetConnection = newTelnetConnection(config, stream, prompt, timeout)
+	cc.raw = true
+	cc.closeFn = client.Close
+	// RFC 4335 break扩展
+	cc.sendBreak = func(d time.Duration) error {
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(d.Milliseconds()))
+		_, err := session.SendRequest("break", true, payload)
+		return err
+	}
+	return nil
+}
+
+func (cc *ConsoleConnection) Info() ConnectionInfo {
+	info := cc.TelnetConnection.Info()
+	info.Protocol = "console"
+	return info
+}
+
+// Break 发送串口break信号，常用于进入ROMMON或引导菜单
+func (cc *ConsoleConnection) Break(d time.Duration) error {
+	if d <= 0 {
+		d = consoleDefaultBreak
+	}
+	if d > consoleMaxBreak {
+		d = consoleMaxBreak
+	}
+	return cc.sendBreak(d)
+}
+
+func (cc *ConsoleConnection) Close() error {
+	var err error
+	if cc.closeFn != nil {
+		err = cc.closeFn()
+	}
+	cc.release()
+	return err
+}
+
+// sessionConn 将SSH会话的输入输出适配为带读超时的net.Conn
+type sessionConn struct {
+	stdin  io.WriteCloser
+	chunks chan []byte
+	client *ssh.Client
+
+	pending  []byte
+	deadline time.Time
+	mutex    sync.Mutex
+}
+
+func newSessionConn(session *ssh.Session, client *ssh.Client) (*sessionConn, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &sessionConn{stdin: stdin, chunks: make(chan []byte, 16), client: client}
+	go func() {
+		defer close(sc.chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := stdout.Read(buf)
+			if n > 0 {
+				chunk := make([]byte, n)
+				copy(chunk, buf[:n])
+				sc.chunks <- chunk
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return sc, nil
+}
+
+func (sc *sessionConn) Read(p []byte) (int, error) {
+	if len(sc.pending) == 0 {
+		sc.mutex.Lock()
+		deadline := sc.deadline
+		sc.mutex.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case chunk, ok := <-sc.chunks:
+			if !ok {
+				return 0, io.EOF
+			}
+			sc.pending = chunk
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, sc.pending)
+	sc.pending = sc.pending[n:]
+	return n, nil
+}
+
+func (sc *sessionConn) Write(p []byte) (int, error) { return sc.stdin.Write(p) }
+func (sc *sessionConn) Close() error                { return sc.stdin.Close() }
+func (sc *sessionConn) LocalAddr() net.Addr         { return sc.client.LocalAddr() }
+func (sc *sessionConn) RemoteAddr() net.Addr        { return sc.client.RemoteAddr() }
+
+func (sc *sessionConn) SetDeadline(t time.Time) error {
+	return sc.SetReadDeadline(t)
+}
+
+func (sc *sessionConn) SetReadDeadline(t time.Time) error {
+	sc.mutex.Lock()
+	sc.deadline = t
+	sc.mutex.Unlock()
+	return nil
+}
+
+func (sc *sessionConn) SetWriteDeadline(time.Time) error { return nil }
+
+func registerConsoleRoutes(r *gin.Engine) {
+	r.GET("/console/lines", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"lines": consoleLineRegistry.List()})
+	})
+
+	// 发送break信号，duration单位为毫秒
+	r.POST("/console/break", func(c *gin.Context) {
+		var req struct {
+			ConnectionID string `json:"connection_id" binding:"required"`
+			Duration     int    `json:"duration"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		conn, err := collector.get(req.ConnectionID)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		console, ok := conn.(*ConsoleConnection)
+		if !ok {
+			respondError(c, http.StatusBadRequest, errNotConsole)
+			return
+		}
+		if err := console.Break(time.Duration(req.Duration) * time.Millisecond); err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "break sent", "timestamp": time.Now()})
+	})
+}
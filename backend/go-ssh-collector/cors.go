@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORS配置：默认不发送任何CORS响应头，即只允许同源访问。
+// 需要浏览器跨域调用时通过CORS_ALLOWED_ORIGINS显式列出来源，"*"表示允许所有来源
+
+var (
+	corsAllowedOrigins   = splitList(getEnv("CORS_ALLOWED_ORIGINS", ""))
+	corsAllowedMethods   = splitList(getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"))
+	corsAllowedHeaders   = splitList(getEnv("CORS_ALLOWED_HEADERS", "Origin,Content-Type,Accept,Authorization,X-API-Key,X-Request-ID,Idempotency-Key"))
+	corsAllowCredentials = getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true"
+	corsMaxAge           = time.Duration(envInt64("CORS_MAX_AGE", 600)) * time.Second
+)
+
+// corsMiddleware 未配置来源时返回nil，调用方不注册CORS中间件
+func corsMiddleware() gin.HandlerFunc {
+	if len(corsAllowedOrigins) == 0 {
+		return nil
+	}
+	config := cors.Config{
+		AllowMethods:     corsAllowedMethods,
+		AllowHeaders:     corsAllowedHeaders,
+		AllowCredentials: corsAllowCredentials,
+		ExposeHeaders:    []string{requestIDHeader, idempotencyReplayedHeader},
+		MaxAge:           corsMaxAge,
+	}
+	if len(corsAllowedOrigins) == 1 && corsAllowedOrigins[0] == "*" {
+		// 允许所有来源时不能同时携带凭据
+		config.AllowAllOrigins, config.AllowCredentials = true, false
+	} else {
+		config.AllowOrigins = corsAllowedOrigins
+	}
+	return cors.New(config)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func setCORSOrigins(t *testing.T, origins ...string) {
+	t.Helper()
+	previous, credentials := corsAllowedOrigins, corsAllowCredentials
+	corsAllowedOrigins, corsAllowCredentials = origins, true
+	t.Cleanup(func() { corsAllowedOrigins, corsAllowCredentials = previous, credentials })
+}
+
+func corsRequest(r http.Handler, method, origin string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/connections", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSDisabledByDefault(t *testing.T) {
+	setCORSOrigins(t)
+	w := corsRequest(newRouter(), http.MethodGet, "https://ui.example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSPreflightAllowedOrigin(t *testing.T) {
+	setCORSOrigins(t, "https://ui.example.com")
+	// 预检请求不带凭据，需在认证之前应答
+	useAPIKey(t, "cors", roleViewer)
+	r := newRouter()
+
+	w := corsRequest(r, http.MethodOptions, "https://ui.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight status %d: %s", w.Code, w.Body.String())
+	}
+	headers := w.Header()
+	if headers.Get("Access-Control-Allow-Origin") != "https://ui.example.com" || headers.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("headers = %v", headers)
+	}
+	if headers.Get("Access-Control-Allow-Headers") == "" || headers.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("headers = %v", headers)
+	}
+
+	// 实际请求仍需认证，但带CORS头以便浏览器读取错误
+	w = corsRequest(r, http.MethodGet, "https://ui.example.com")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") != "https://ui.example.com" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Fatalf("request ID header not exposed: %v", w.Header())
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	setCORSOrigins(t, "https://ui.example.com")
+	r := newRouter()
+	for _, method := range []string{http.MethodOptions, http.MethodGet} {
+		w := corsRequest(r, method, "https://evil.example.com")
+		if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: status %d, headers %v", method, w.Code, w.Header())
+		}
+	}
+}
+
+func TestCORSWildcardDropsCredentials(t *testing.T) {
+	setCORSOrigins(t, "*")
+	w := corsRequest(newRouter(), http.MethodOptions, "https://any.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("credentials allowed with a wildcard origin: %v", w.Header())
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 凭据引用格式:
+//
+//	env:NAME     读取环境变量
+//	file:/path   读取文件内容（去掉末尾换行）
+//	cred:name    读取CREDENTIALS_FILE中的命名凭据（JSON: {"name": "secret"}）
+var (
+	namedCredentials     map[string]string
+	namedCredentialsOnce sync.Once
+)
+
+func loadNamedCredentials() {
+	namedCredentials = make(map[string]string)
+
+	file := os.Getenv("CREDENTIALS_FILE")
+	if file == "" {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		logError("credentials.load_failed", "failed to read CREDENTIALS_FILE", "file", file, "error", err)
+		return
+	}
+	if err := json.Unmarshal(data, &namedCredentials); err != nil {
+		logError("credentials.load_failed", "failed to parse CREDENTIALS_FILE", "file", file, "error", err)
+	}
+}
+
+// resolveCredential 按引用解析凭据，错误信息中不包含凭据内容
+func resolveCredential(ref string) (string, error) {
+	scheme, name, found := strings.Cut(ref, ":")
+	if !found || name == "" {
+		return "", fmt.Errorf("invalid credential reference: %s", ref)
+	}
+
+	switch scheme {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("credential env %s not set", name)
+		}
+		return value, nil
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credential file %s", name)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "cred":
+		namedCredentialsOnce.Do(loadNamedCredentials)
+		value, ok := namedCredentials[name]
+		if !ok {
+			return "", fmt.Errorf("credential %s not found", name)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("unsupported credential backend: %s", scheme)
+}
+
+// credentialValue 优先使用引用，未提供引用时使用内联值。
+// 解析出的凭据按引用登记到已知凭据中，错误信息和输出里出现时会被替换
+func credentialValue(inline, ref string) (string, error) {
+	if ref == "" {
+		return inline, nil
+	}
+	value, err := resolveCredential(ref)
+	if err == nil {
+		registerSecrets("credential:"+ref, value)
+	}
+	return value, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSV输出：?format=csv 或 Accept: text/csv，delimiter / quote=all / bom=true 可调
+
+var errNotTabular = errors.New("parsed data is not tabular (expected an array of objects)")
+
+type csvOptions struct {
+	delimiter rune
+	quoteAll  bool
+	bom       bool
+}
+
+// wantsCSV 显式的format参数优先于Accept头
+func wantsCSV(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
+
+func parseCSVOptions(c *gin.Context) (csvOptions, error) {
+	opts := csvOptions{delimiter: ',', quoteAll: c.Query("quote") == "all", bom: c.Query("bom") == "true"}
+	switch delimiter := c.Query("delimiter"); delimiter {
+	case "":
+	case "tab", `\t`:
+		opts.delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return opts, fmt.Errorf("invalid delimiter %q", delimiter)
+		}
+		opts.delimiter = r
+	}
+	return opts, nil
+}
+
+func quoteCSVField(field string) string {
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+func (opts csvOptions) write(w io.Writer, header []string, rows [][]string) error {
+	if opts.bom {
+		// Excel依赖BOM识别UTF-8
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	if opts.quoteAll {
+		for _, row := range append([][]string{header}, rows...) {
+			quoted := make([]string, len(row))
+			for i, field := range row {
+				quoted[i] = quoteCSVField(field)
+			}
+			if _, err := io.WriteString(w, strings.Join(quoted, string(opts.delimiter))+"\r\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma = opts.delimiter
+	writer.UseCRLF = true
+	writer.Write(header)
+	writer.WriteAll(rows)
+	return writer.Error()
+}
+
+// csvCell 标量直接输出，嵌套结构输出为JSON
+func csvCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// tabularRows 将parsed中的记录列表展开为表头和行，列为所有记录键的并集
+func tabularRows(parsed interface{}) ([]string, [][]string, error) {
+	document, err := normalizeDocument(parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+	list, ok := document.([]interface{})
+	if !ok {
+		return nil, nil, errNotTabular
+	}
+	records := make([]map[string]interface{}, 0, len(list))
+	columns := make(map[string]bool)
+	for _, item := range list {
+		record, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, nil, errNotTabular
+		}
+		for key := range record {
+			columns[key] = true
+		}
+		records = append(records, record)
+	}
+	header := make([]string, 0, len(columns))
+	for column := range columns {
+		header = append(header, column)
+	}
+	sort.Strings(header)
+
+	rows := make([][]string, len(records))
+	for i, record := range records {
+		rows[i] = make([]string, len(header))
+		for j, column := range header {
+			rows[i][j] = csvCell(record[column])
+		}
+	}
+	return header, rows, nil
+}
+
+func respondCSV(c *gin.Context, filename string, header []string, rows [][]string) {
+	opts, err := parseCSVOptions(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+	c.Status(http.StatusOK)
+	opts.write(c.Writer, header, rows)
+}
+
+// respondResultCSV 命令结果的parsed为记录列表时输出CSV，否则返回406
+func respondResultCSV(c *gin.Context, result *CommandResult) {
+	if result.Parsed == nil {
+		respondError(c, http.StatusNotAcceptable, errors.New("result has no parsed data; request a parse mode to export CSV"))
+		return
+	}
+	header, rows, err := tabularRows(result.Parsed)
+	if err != nil {
+		respondError(c, http.StatusNotAcceptable, err)
+		return
+	}
+	filename := result.ID
+	if filename == "" {
+		filename = "result"
+	}
+	respondCSV(c, filename, header, rows)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+var (
+	dbMaxRows = int(envInt64("DB_MAX_ROWS", 10000))
+	// 允许执行的语句关键字，默认只读查询
+	dbStatementAllowlist = splitList(getEnv("DB_STATEMENT_ALLOWLIST", "SELECT,SHOW"))
+
+	errDBSourceNotFound  = errors.New("db source not found")
+	errStatementNotAllow = errors.New("statement not allowed")
+)
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// 直连的数据库连接同样经过目标地址校验
+func directDBDial(ctx context.Context, network, address string) (net.Conn, error) {
+	return safeDialer(10*time.Second).DialContext(ctx, network, address)
+}
+
+// pqDialer 实现pq.Dialer接口
+type pqDialer struct{ dial dialFunc }
+
+func (d pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dial(context.Background(), network, address)
+}
+
+func (d pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.dial(ctx, network, address)
+}
+
+func (d pqDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.dial(ctx, network, address)
+}
+
+// dbTunnel 经SSH连接拨号，SSH连接关闭或被重连替换时丢弃池中的空闲连接
+type dbTunnel struct {
+	via     string
+	db      *sql.DB
+	maxIdle int
+	last    *SSHConnection
+	mutex   sync.Mutex
+}
+
+func (t *dbTunnel) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := collector.getConnection(t.via)
+	if err != nil {
+		return nil, fmt.Errorf("ssh tunnel %s: %v", t.via, err)
+	}
+
+	t.mutex.Lock()
+	if t.last != conn {
+		t.last = conn
+		conn.OnClose(t.reset)
+	}
+	t.mutex.Unlock()
+
+	channel, err := tunnelDial(conn.Client, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("ssh tunnel %s: %w", t.via, err)
+	}
+	return channel, nil
+}
+
+func (t *dbTunnel) reset() {
+	t.mutex.Lock()
+	db, maxIdle := t.db, t.maxIdle
+	t.mutex.Unlock()
+
+	if db != nil {
+		db.SetMaxIdleConns(0)
+		db.SetMaxIdleConns(maxIdle)
+	}
+}
+
+type DBConfig struct {
+	Name string `json:"name" binding:"required"`
+	// Driver: mysql / postgres
+	Driver      string            `json:"driver" binding:"required"`
+	Host        string            `json:"host" binding:"required"`
+	Port        int               `json:"port"`
+	Username    string            `json:"username" binding:"required"`
+	Password    string            `json:"password"`
+	PasswordRef string            `json:"password_ref"`
+	Database    string            `json:"database"`
+	Params      map[string]string `json:"params"`
+	MaxOpenConn int               `json:"max_open_conns"`
+	// ViaConnectionID 经该SSH连接访问数据库
+	ViaConnectionID string `json:"via_connection_id"`
+
+	// principal 注册数据源的调用方，用于检查via连接的ACL
+	principal *Principal
+}
+
+type DBSource struct {
+	Config    DBConfig
+	CreatedAt time.Time
+
+	db *sql.DB
+}
+
+// open 根据驱动构造连接池，密码不出现在日志与接口返回中
+func (config DBConfig) open(password string, dial dialFunc) (*sql.DB, error) {
+	switch config.Driver {
+	case "mysql":
+		port := config.Port
+		if port == 0 {
+			port = 3306
+		}
+		cfg := mysql.NewConfig()
+		cfg.User = config.Username
+		cfg.Passwd = password
+		// 每个连接池注册独立的网络名以绑定各自的拨号函数
+		cfg.Net = "collector-" + newID()
+		mysql.RegisterDialContext(cfg.Net, func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		})
+		cfg.Addr = net.JoinHostPort(config.Host, strconv.Itoa(port))
+		cfg.DBName = config.Database
+		cfg.ParseTime = true
+		cfg.Params = config.Params
+		connector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(connector), nil
+	case "postgres":
+		port := config.Port
+		if port == 0 {
+			port = 5432
+		}
+		query := url.Values{}
+		for k, v := range config.Params {
+			query.Set(k, v)
+		}
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(config.Username, password),
+			Host:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+			Path:     "/" + config.Database,
+			RawQuery: query.Encode(),
+		}
+		connector, err := pq.NewConnector(u.String())
+		if err != nil {
+			return nil, err
+		}
+		connector.Dialer(pqDialer{dial: dial})
+		return sql.OpenDB(connector), nil
+	}
+	return nil, fmt.Errorf("unsupported driver: %s", config.Driver)
+}
+
+type DBRegistry struct {
+	sources map[string]*DBSource
+	mutex   sync.RWMutex
+}
+
+var dbSources = &DBRegistry{sources: make(map[string]*DBSource)}
+
+func (r *DBRegistry) Add(config DBConfig) error {
+	password, err := credentialValue(config.Password, config.PasswordRef)
+	if err != nil {
+		return err
+	}
+	maxOpen := config.MaxOpenConn
+	if maxOpen <= 0 {
+		maxOpen = 5
+	}
+
+	dial := dialFunc(directDBDial)
+	var tunnel *dbTunnel
+	if config.ViaConnectionID != "" {
+		if _, err := collector.getConnection(config.ViaConnectionID); err != nil {
+			return fmt.Errorf("via_connection_id: %w", err)
+		}
+		if !connectionACLs.Allowed(config.principal, config.ViaConnectionID) {
+			return errViaNotShared
+		}
+		tunnel = &dbTunnel{via: config.ViaConnectionID, maxIdle: maxOpen}
+		dial = tunnel.dial
+	}
+
+	db, err := config.open(password, dial)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	if tunnel != nil {
+		tunnel.mutex.Lock()
+		tunnel.db = db
+		tunnel.mutex.Unlock()
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxOpen)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	r.mutex.Lock()
+	old, exists := r.sources[config.Name]
+	r.sources[config.Name] = &DBSource{Config: config, CreatedAt: time.Now(), db: db}
+	r.mutex.Unlock()
+
+	if exists {
+		old.db.Close()
+	}
+	return nil
+}
+
+func (r *DBRegistry) Get(name string) (*DBSource, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	source, ok := r.sources[name]
+	if !ok {
+		return nil, errDBSourceNotFound
+	}
+	return source, nil
+}
+
+func (r *DBRegistry) Remove(name string) error {
+	r.mutex.Lock()
+	source, ok := r.sources[name]
+	delete(r.sources, name)
+	r.mutex.Unlock()
+
+	if !ok {
+		return errDBSourceNotFound
+	}
+	return source.db.Close()
+}
+
+// checkStatement 只允许单条语句，且首个关键字在白名单中
+func checkStatement(query string) error {
+	trimmed := strings.TrimSpace(query)
+	trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, ";"))
+	if strings.Contains(trimmed, ";") {
+		return fmt.Errorf("%w: multiple statements", errStatementNotAllow)
+	}
+	fields := strings.Fields(trimmed)
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: empty statement", errStatementNotAllow)
+	}
+	keyword := strings.ToUpper(fields[0])
+	for _, allowed := range dbStatementAllowlist {
+		if keyword == strings.ToUpper(allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errStatementNotAllow, keyword)
+}
+
+type DBQueryRequest struct {
+	Source  string        `json:"source" binding:"required"`
+	Query   string        `json:"query" binding:"required"`
+	Args    []interface{} `json:"args"`
+	MaxRows int           `json:"max_rows"`
+	Timeout int           `json:"timeout"`
+}
+
+type DBColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable *bool  `json:"nullable,omitempty"`
+}
+
+type DBQueryResult struct {
+	Source    string          `json:"source"`
+	Columns   []DBColumn      `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated"`
+	Duration  float64         `json:"duration"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// convertDBValue 将驱动返回的值转换为JSON友好的类型
+func convertDBValue(value interface{}, dbType string) interface{} {
+	b, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	s := string(b)
+	switch dbType {
+	case "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8":
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v
+		}
+	case "UNSIGNED BIGINT", "UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT":
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return v
+		}
+	case "FLOAT", "DOUBLE", "FLOAT4", "FLOAT8":
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	case "BOOL":
+		if v, err := strconv.ParseBool(s); err == nil {
+			return v
+		}
+	}
+	// DECIMAL/NUMERIC保留字符串避免精度丢失
+	return s
+}
+
+// Query 在只读事务中执行查询，超过行数上限时截断
+func (r *DBRegistry) Query(ctx context.Context, req DBQueryRequest) (*DBQueryResult, error) {
+	if err := checkStatement(req.Query); err != nil {
+		return nil, err
+	}
+	source, err := r.Get(req.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRows := req.MaxRows
+	if maxRows <= 0 || maxRows > dbMaxRows {
+		maxRows = dbMaxRows
+	}
+	timeout := 30 * time.Second
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	tx, err := source.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, req.Query, req.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result := &DBQueryResult{Source: req.Source, Rows: [][]interface{}{}}
+	for _, ct := range columnTypes {
+		column := DBColumn{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+		if nullable, ok := ct.Nullable(); ok {
+			column.Nullable = &nullable
+		}
+		result.Columns = append(result.Columns, column)
+	}
+
+	for rows.Next() {
+		if len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columnTypes))
+		pointers := make([]interface{}, len(columnTypes))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		for i, v := range values {
+			values[i] = convertDBValue(v, result.Columns[i].Type)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+
+	result.RowCount = len(result.Rows)
+	result.Duration = time.Since(start).Seconds()
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+// writeDBResultCSV 以CSV输出查询结果，NULL输出为空字段
+func writeDBResultCSV(c *gin.Context, result *DBQueryResult) {
+	header := make([]string, len(result.Columns))
+	for i, column := range result.Columns {
+		header[i] = column.Name
+	}
+	rows := make([][]string, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			switch value := v.(type) {
+			case nil:
+			case time.Time:
+				record[i] = value.Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		rows = append(rows, record)
+	}
+	respondCSV(c, result.Source, header, rows)
+}
+
+func registerDBRoutes(r *gin.Engine) {
+	// 注册数据源
+	r.POST("/db/sources", func(c *gin.Context) {
+		var config DBConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		p := currentPrincipal(c)
+		config.principal = p
+		if err := dbSources.Add(config); errors.Is(err, errViaNotShared) {
+			denyRequest(c, p, permConfigure, err.Error())
+			return
+		} else if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, errConnectionNotFound) || errors.Is(err, errNotSSHConnection) {
+				status = http.StatusBadRequest
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"source":    config.Name,
+			"status":    "connected",
+			"timestamp": time.Now(),
+		})
+	})
+
+	r.GET("/db/sources", func(c *gin.Context) {
+		dbSources.mutex.RLock()
+		list := make([]gin.H, 0, len(dbSources.sources))
+		for name, source := range dbSources.sources {
+			stats := source.db.Stats()
+			list = append(list, gin.H{
+				"name":              name,
+				"driver":            source.Config.Driver,
+				"host":              source.Config.Host,
+				"database":          source.Config.Database,
+				"via_connection_id": source.Config.ViaConnectionID,
+				"open_connections":  stats.OpenConnections,
+				"in_use":            stats.InUse,
+				"created_at":        source.CreatedAt,
+			})
+		}
+		dbSources.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"sources": list})
+	})
+
+	r.DELETE("/db/sources/:name", func(c *gin.Context) {
+		if err := dbSources.Remove(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+
+	// 执行只读查询，?format=csv 时以CSV返回
+	r.POST("/db/query", func(c *gin.Context) {
+		var req DBQueryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result, err := dbSources.Query(c.Request.Context(), req)
+		if err != nil {
+			status := http.StatusBadGateway
+			switch {
+			case errors.Is(err, errStatementNotAllow):
+				status = http.StatusForbidden
+			case errors.Is(err, errDBSourceNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, context.DeadlineExceeded):
+				status = http.StatusGatewayTimeout
+			}
+			respondError(c, status, err)
+			return
+		}
+
+		if wantsCSV(c) {
+			writeDBResultCSV(c, result)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// serveFakePostgres 最小的PostgreSQL协议服务端：免密认证，简单查询协议，SELECT返回一行answer=42
+func serveFakePostgres(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// 启动消息没有类型字节
+	var length int32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(length)-4); err != nil {
+		return
+	}
+	send := func(kind byte, body []byte) {
+		header := make([]byte, 5)
+		header[0] = kind
+		binary.BigEndian.PutUint32(header[1:], uint32(len(body)+4))
+		conn.Write(append(header, body...))
+	}
+	send('R', []byte{0, 0, 0, 0})
+	send('S', []byte("server_version\x0014.0\x00"))
+	send('Z', []byte("I"))
+
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return
+		}
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch kind {
+		case 'X':
+			return
+		case 'Q':
+			query := strings.TrimSpace(strings.TrimRight(string(body), "\x00"))
+			status := "T"
+			switch {
+			case query == "" || query == ";":
+				send('I', nil)
+				status = "I"
+			case strings.HasPrefix(strings.ToUpper(query), "SELECT"):
+				// 一列int4
+				var field []byte
+				field = append(field, "answer\x00"...)
+				field = binary.BigEndian.AppendUint32(field, 0)
+				field = binary.BigEndian.AppendUint16(field, 0)
+				field = binary.BigEndian.AppendUint32(field, 23)
+				field = binary.BigEndian.AppendUint16(field, 4)
+				field = binary.BigEndian.AppendUint32(field, 0xffffffff)
+				field = binary.BigEndian.AppendUint16(field, 0)
+				send('T', append([]byte{0, 1}, field...))
+				row := binary.BigEndian.AppendUint16(nil, 1)
+				row = binary.BigEndian.AppendUint32(row, 2)
+				send('D', append(row, "42"...))
+				send('C', []byte("SELECT 1\x00"))
+			default:
+				keyword := strings.ToUpper(strings.Fields(query)[0])
+				send('C', []byte(keyword+"\x00"))
+				if keyword == "ROLLBACK" || keyword == "COMMIT" {
+					status = "I"
+				}
+			}
+			send('Z', []byte(status))
+		default:
+			send('E', []byte("SERROR\x00Munsupported message\x00\x00"))
+			send('Z', []byte("I"))
+		}
+	}
+}
+
+// startTunnelledPostgres 数据库只能经测试SSH服务器的direct-tcpip访问，返回连接ID和拨号计数
+func startTunnelledPostgres(t *testing.T) (*testSSHServer, string, *int64) {
+	t.Helper()
+	s := startTestSSHServer(t)
+	var dials int64
+	s.dial = func(address string) (net.Conn, error) {
+		if address != "db.internal:5432" {
+			return nil, errors.New("no route to " + address)
+		}
+		atomic.AddInt64(&dials, 1)
+		client, server := net.Pipe()
+		go serveFakePostgres(server)
+		return client, nil
+	}
+	id := connectTestSSH(t, s)
+	// db.internal 只有SSH服务器一端能解析，需在remote_hosts中列出
+	useDestinationPolicy(t, &DestinationPolicy{
+		Deny: append([]string{}, defaultDenyCIDRs...), Allow: []string{"127.0.0.1/32"}, RemoteHosts: []string{"*.internal"},
+	})
+	return s, id, &dials
+}
+
+func addTunnelledSource(t *testing.T, name, via string) {
+	t.Helper()
+	config := DBConfig{
+		Name: name, Driver: "postgres", Host: "db.internal", Username: "app", Password: "pw",
+		Database: "inventory", Params: map[string]string{"sslmode": "disable"}, ViaConnectionID: via,
+	}
+	if err := dbSources.Add(config); err != nil {
+		t.Fatalf("add source: %v", err)
+	}
+	t.Cleanup(func() { dbSources.Remove(name) })
+}
+
+func TestDBQueryThroughSSHTunnel(t *testing.T) {
+	_, id, dials := startTunnelledPostgres(t)
+	addTunnelledSource(t, "tunnelled", id)
+
+	result, err := dbSources.Query(context.Background(), DBQueryRequest{Source: "tunnelled", Query: "SELECT 42 AS answer"})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(result.Columns) != 1 || result.Columns[0].Name != "answer" || result.Columns[0].Type != "INT4" {
+		t.Fatalf("columns = %+v", result.Columns)
+	}
+	if result.RowCount != 1 || result.Rows[0][0] != int64(42) {
+		t.Fatalf("rows = %v", result.Rows)
+	}
+	if atomic.LoadInt64(dials) == 0 {
+		t.Fatal("database was not reached through the SSH connection")
+	}
+}
+
+func TestDBSourceRequiresViaConnection(t *testing.T) {
+	err := dbSources.Add(DBConfig{Name: "missing-via", Driver: "postgres", Host: "db.internal", Username: "app", ViaConnectionID: "no-such-connection"})
+	if !errors.Is(err, errConnectionNotFound) {
+		t.Fatalf("err = %v, want errConnectionNotFound", err)
+	}
+}
+
+func TestDBTunnelDropsPoolWhenConnectionReplaced(t *testing.T) {
+	s, id, dials := startTunnelledPostgres(t)
+	addTunnelledSource(t, "replaced", id)
+
+	query := func() {
+		t.Helper()
+		if _, err := dbSources.Query(context.Background(), DBQueryRequest{Source: "replaced", Query: "SELECT 1"}); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+	}
+	query()
+	query()
+	before := atomic.LoadInt64(dials)
+	if before != 1 {
+		t.Fatalf("pooled connection not reused: %d dials", before)
+	}
+
+	// 同一目标重连会替换并关闭旧SSH连接，池中经旧连接的空闲连接随之丢弃
+	if replaced, err := collector.Connect(s.Config()); err != nil || replaced != id {
+		t.Fatalf("reconnect: %v %v", replaced, err)
+	}
+	query()
+	if after := atomic.LoadInt64(dials); after != before+1 {
+		t.Fatalf("dials after replace = %d, want %d", after, before+1)
+	}
+}
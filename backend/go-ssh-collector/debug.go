@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调试接口：DEBUG_ENDPOINTS=true 时在管理监听上提供 /debug/pprof/*（net/http/pprof）、
+// /debug/goroutines（按创建位置汇总的goroutine数）和 /debug/connections（连接、执行中的命令、
+// keepalive和各队列的内部状态），用于排查卡住的会话和goroutine泄漏。
+// 只在管理监听上注册并要求admin，主监听上始终404。
+// net/http/pprof 会注册到http.DefaultServeMux，但两个监听都不使用它
+
+var debugEndpointsEnabled = os.Getenv("DEBUG_ENDPOINTS") == "true"
+
+// 通过Lookup提供的profile，其余由pprof.Index列出
+var pprofProfiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+// GoroutineSite 同一创建位置的goroutine
+type GoroutineSite struct {
+	CreatedBy string         `json:"created_by"`
+	Location  string         `json:"location,omitempty"`
+	Count     int            `json:"count"`
+	States    map[string]int `json:"states"`
+	// Example 其中一个goroutine当前所在的函数
+	Example string `json:"example"`
+}
+
+// goroutineSites 解析debug=2格式的goroutine栈，按 "created by" 行分组，没有该行的（如main）按最底层函数
+func goroutineSites() (int, []GoroutineSite) {
+	var buf bytes.Buffer
+	runtimepprof.Lookup("goroutine").WriteTo(&buf, 2)
+
+	sites := map[string]*GoroutineSite{}
+	total := 0
+	for _, block := range strings.Split(buf.String(), "\n\n") {
+		scanner := bufio.NewScanner(strings.NewReader(block))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "goroutine ") {
+			continue
+		}
+		state := ""
+		if header := scanner.Text(); strings.Contains(header, "[") {
+			state = header[strings.Index(header, "[")+1 : strings.LastIndex(header, "]")]
+			// "IO wait, 5 minutes" 中的时长不参与分组
+			state, _, _ = strings.Cut(state, ",")
+		}
+		var frames []string
+		createdBy, location := "", ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "created by "):
+				createdBy = strings.TrimPrefix(line, "created by ")
+				// Go 1.21起带 "in goroutine N"
+				if i := strings.Index(createdBy, " in goroutine "); i >= 0 {
+					createdBy = createdBy[:i]
+				}
+				if scanner.Scan() {
+					location = strings.TrimSpace(scanner.Text())
+					if i := strings.LastIndex(location, " +0x"); i >= 0 {
+						location = location[:i]
+					}
+				}
+			case !strings.HasPrefix(line, "\t"):
+				frames = append(frames, line)
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		if createdBy == "" {
+			createdBy = frames[len(frames)-1]
+		}
+		key := createdBy + " " + location
+		site, ok := sites[key]
+		if !ok {
+			site = &GoroutineSite{CreatedBy: createdBy, Location: location, States: map[string]int{}, Example: frames[0]}
+			sites[key] = site
+		}
+		site.Count++
+		site.States[state]++
+		total++
+	}
+
+	list := make([]GoroutineSite, 0, len(sites))
+	for _, site := range sites {
+		list = append(list, *site)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].CreatedBy < list[j].CreatedBy
+	})
+	return total, list
+}
+
+// debugConnections 连接和队列的内部状态
+func debugConnections() gin.H {
+	collector.mutex.RLock()
+	connections := make([]gin.H, 0, len(collector.connections))
+	for id, conn := range collector.connections {
+		entry := gin.H{"id": id, "protocol": conn.Info().Protocol, "host": conn.Info().Host}
+		if meta, ok := collector.meta[id]; ok {
+			entry["status"], entry["inflight"] = meta.Status, meta.Inflight
+			if !meta.LastUsed.IsZero() {
+				entry["last_used"] = meta.LastUsed
+			}
+		}
+		connections = append(connections, entry)
+	}
+	collector.mutex.RUnlock()
+
+	for _, entry := range connections {
+		if stats, err := connStats.Report(entry["id"].(string), false); err == nil {
+			entry["connected_at"], entry["reconnects"] = stats.ConnectedAt, stats.Reconnects
+			entry["keepalive_checks"], entry["keepalive_failures"] = stats.KeepaliveChecks, stats.KeepaliveFailures
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i]["id"].(string) < connections[j]["id"].(string) })
+
+	return gin.H{
+		"connections": connections,
+		"inflight":    collector.Inflight(),
+		"jobs":        jobs.Stats(),
+		"sinks":       sinks.Stats(),
+		"forwards":    len(forwards.List(nil)),
+		"tracing":     traceExporter.stats(),
+		"goroutines":  runtime.NumGoroutine(),
+		"timestamp":   time.Now(),
+	}
+}
+
+// registerDebugRoutes 只在管理监听上注册
+func registerDebugRoutes(r *gin.Engine) {
+	if !debugEndpointsEnabled {
+		return
+	}
+	debug := r.Group("/debug", requireAdmin)
+
+	debug.GET("/pprof/", gin.WrapF(pprof.Index))
+	debug.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	for _, name := range pprofProfiles {
+		debug.GET("/pprof/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+
+	debug.GET("/goroutines", func(c *gin.Context) {
+		total, sites := goroutineSites()
+		if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit < len(sites) {
+			sites = sites[:limit]
+		}
+		c.JSON(http.StatusOK, gin.H{"total": total, "sites": sites, "timestamp": time.Now()})
+	})
+
+	debug.GET("/connections", func(c *gin.Context) {
+		c.JSON(http.StatusOK, debugConnections())
+	})
+}
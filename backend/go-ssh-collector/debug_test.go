@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func enableDebugEndpoints(t *testing.T) {
+	t.Helper()
+	previous := debugEndpointsEnabled
+	debugEndpointsEnabled = true
+	t.Cleanup(func() { debugEndpointsEnabled = previous })
+}
+
+// 调试接口只在管理监听上存在，且要求admin角色
+func TestDebugEndpointsOnlyOnAdminListener(t *testing.T) {
+	enableDebugEndpoints(t)
+	admin := useAPIKey(t, "debug-admin", roleAdmin)
+	viewer := useAPIKey(t, "debug-viewer", roleViewer)
+	mainListener, adminListener := newRouter(), newAdminRouter()
+
+	paths := []string{"/debug/pprof/", "/debug/pprof/heap?debug=1", "/debug/goroutines?limit=5", "/debug/connections"}
+	for _, path := range paths {
+		if w := apiRequest(mainListener, admin, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("main listener %s: status %d, want 404", path, w.Code)
+		}
+		if w := apiRequest(adminListener, admin, http.MethodGet, path, ""); w.Code != http.StatusOK {
+			t.Errorf("admin listener %s: status %d: %s", path, w.Code, w.Body.String())
+		}
+		if w := apiRequest(adminListener, viewer, http.MethodGet, path, ""); w.Code != http.StatusForbidden {
+			t.Errorf("viewer %s: status %d, want 403", path, w.Code)
+		}
+		if w := apiRequest(adminListener, "", http.MethodGet, path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s: status %d, want 401", path, w.Code)
+		}
+	}
+
+	var goroutines struct {
+		Total int             `json:"total"`
+		Sites []GoroutineSite `json:"sites"`
+	}
+	w := apiRequest(adminListener, admin, http.MethodGet, "/debug/goroutines?limit=1", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &goroutines); err != nil || goroutines.Total == 0 || len(goroutines.Sites) != 1 {
+		t.Fatalf("goroutines = %+v, %v", goroutines, err)
+	}
+}
+
+func TestDebugEndpointsDisabledByDefault(t *testing.T) {
+	previous := debugEndpointsEnabled
+	debugEndpointsEnabled = false
+	t.Cleanup(func() { debugEndpointsEnabled = previous })
+	admin := useAPIKey(t, "debug-admin", roleAdmin)
+
+	w := apiRequest(newAdminRouter(), admin, http.MethodGet, "/debug/pprof/", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "route not found") {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// 调试快照不含连接凭据
+func TestDebugConnectionsOmitSecrets(t *testing.T) {
+	enableDebugEndpoints(t)
+	admin := useAPIKey(t, "debug-admin", roleAdmin)
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+
+	w := apiRequest(newAdminRouter(), admin, http.MethodGet, "/debug/connections", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), id) {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), testSSHPassword) {
+		t.Fatalf("debug snapshot leaked the password: %s", w.Body.String())
+	}
+}
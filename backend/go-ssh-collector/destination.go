@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+// 目标地址策略：所有对外连接（SSH/Telnet/串口服务器、HTTP/Redfish/WinRM、URL拉取、Webhook、
+// 端口探测、ping、端口转发、SNMP/MQTT/FTP/gNMI/Modbus/IPMI、数据库）都在DNS解析之后按实际IP校验。
+// deny与allow按最长前缀匹配，同样长度时deny优先；都不匹配时由default_deny决定。
+// 初始策略来自 DESTINATION_DENY_CIDRS / DESTINATION_ALLOW_CIDRS / DESTINATION_DEFAULT_DENY，
+// 设置 DESTINATION_POLICY_FILE 时以文件为准，收到SIGHUP或调用 PUT /admin/destination-policy 时重新加载。
+// 文件和接口提供的策略同样合并内置的defaultDenyCIDRs，只有显式设置 override_default_deny 时才去掉（记录告警）。
+// 主机名在本地解析失败时拒绝；经SSH隧道的连接由远端解析主机名，只有匹配 remote_hosts
+// （DESTINATION_REMOTE_HOSTS，支持 *.example.com）的主机名允许在本地无法解析时交给远端。
+// 隧道的回环目标是设备自身，反向转发到本机回环由 REVERSE_FORWARD_ALLOWED_TARGETS 控制，二者不受策略限制。
+// 拒绝记录写入审计日志
+
+// 默认禁止访问的目标网段：回环、链路本地（含云元数据地址）、未指定地址（连接 [::] 会到达本机回环）
+// 和AWS的IPv6元数据地址。IPv4映射地址（::ffff:a.b.c.d）按IPv4匹配
+var defaultDenyCIDRs = []string{
+	"0.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::/128",
+	"::1/128",
+	"fe80::/10",
+	"fd00:ec2::254/128",
+}
+
+var destinationPolicyFile = getEnv("DESTINATION_POLICY_FILE", "")
+
+var errDestinationDenied = errors.New("destination denied by policy")
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// DestinationPolicy 的JSON格式与 DESTINATION_POLICY_FILE 相同
+type DestinationPolicy struct {
+	Deny        []string `json:"deny"`
+	Allow       []string `json:"allow"`
+	DefaultDeny bool     `json:"default_deny"`
+	// RemoteHosts 允许由隧道远端解析的主机名
+	RemoteHosts []string `json:"remote_hosts,omitempty"`
+	// OverrideDefaultDeny 为true时不合并defaultDenyCIDRs
+	OverrideDefaultDeny bool `json:"override_default_deny,omitempty"`
+
+	deny  []*net.IPNet
+	allow []*net.IPNet
+}
+
+// compile 与parseCIDRs不同，无效的CIDR直接报错，避免策略被静默放宽；单个IP按/32或/128处理
+func (p *DestinationPolicy) compile() error {
+	parse := func(entries []string) ([]*net.IPNet, error) {
+		var networks []*net.IPNet
+		for _, entry := range entries {
+			if !strings.Contains(entry, "/") {
+				ip := net.ParseIP(entry)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid cidr %q", entry)
+				}
+				bits := 128
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %q", entry)
+			}
+			networks = append(networks, network)
+		}
+		return networks, nil
+	}
+	var err error
+	if p.deny, err = parse(p.Deny); err != nil {
+		return err
+	}
+	if p.allow, err = parse(p.Allow); err != nil {
+		return err
+	}
+	for i, pattern := range p.RemoteHosts {
+		p.RemoteHosts[i] = strings.ToLower(pattern)
+		if _, err := path.Match(p.RemoteHosts[i], ""); err != nil {
+			return fmt.Errorf("invalid remote host pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// mergeDefaultDenies 把缺少的defaultDenyCIDRs补到deny中，source用于告警日志
+func (p *DestinationPolicy) mergeDefaultDenies(source string) {
+	if p.OverrideDefaultDeny {
+		logWarn("destination.default_deny_overridden", "built-in destination denies removed by policy", "source", source)
+		return
+	}
+	present := make(map[string]bool, len(p.Deny))
+	for _, cidr := range p.Deny {
+		present[cidr] = true
+	}
+	for _, cidr := range defaultDenyCIDRs {
+		if !present[cidr] {
+			p.Deny = append(p.Deny, cidr)
+		}
+	}
+}
+
+// remoteHost 主机名是否允许交给隧道远端解析
+func (p *DestinationPolicy) remoteHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range p.RemoteHosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// longestMatch 返回包含ip的最长前缀网段
+func longestMatch(networks []*net.IPNet, ip net.IP) (*net.IPNet, int) {
+	var best *net.IPNet
+	bestOnes := -1
+	for _, network := range networks {
+		if !network.Contains(ip) {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > bestOnes {
+			best, bestOnes = network, ones
+		}
+	}
+	return best, bestOnes
+}
+
+// Check 返回nil或*destinationError
+func (p *DestinationPolicy) Check(ip net.IP) error {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	deny, denyOnes := longestMatch(p.deny, ip)
+	allow, allowOnes := longestMatch(p.allow, ip)
+	switch {
+	case deny != nil && denyOnes >= allowOnes:
+		return &destinationError{ip: ip, rule: "deny " + deny.String()}
+	case allow != nil:
+		return nil
+	case p.DefaultDeny:
+		return &destinationError{ip: ip, rule: "default deny"}
+	}
+	return nil
+}
+
+// destinationError 标记被目标地址策略拒绝的连接，rule为匹配到的规则
+type destinationError struct {
+	ip   net.IP
+	host string
+	rule string
+}
+
+func (e *destinationError) Error() string {
+	destination := e.host
+	if e.ip != nil {
+		destination = e.ip.String()
+	}
+	return fmt.Sprintf("destination %s denied by policy (%s)", destination, e.rule)
+}
+
+func (e *destinationError) Is(target error) bool { return target == errDestinationDenied }
+
+// details 写入错误响应
+func (e *destinationError) details() map[string]interface{} {
+	destination := e.host
+	if e.ip != nil {
+		destination = e.ip.String()
+	}
+	return map[string]interface{}{"destination": destination, "rule": e.rule}
+}
+
+var destinationPolicy = struct {
+	policy *DestinationPolicy
+	mutex  sync.RWMutex
+}{policy: loadDestinationPolicy()}
+
+// denyAllPolicy 配置有误时使用，避免意外放开
+func denyAllPolicy() *DestinationPolicy {
+	cidrs := []string{"0.0.0.0/0", "::/0"}
+	return &DestinationPolicy{Deny: cidrs, deny: parseCIDRs(cidrs)}
+}
+
+func envDestinationPolicy() *DestinationPolicy {
+	policy := &DestinationPolicy{
+		Deny:        append(append([]string{}, defaultDenyCIDRs...), splitList(os.Getenv("DESTINATION_DENY_CIDRS"))...),
+		Allow:       splitList(os.Getenv("DESTINATION_ALLOW_CIDRS")),
+		DefaultDeny: os.Getenv("DESTINATION_DEFAULT_DENY") == "true",
+		RemoteHosts: splitList(os.Getenv("DESTINATION_REMOTE_HOSTS")),
+	}
+	if err := policy.compile(); err != nil {
+		logError("destination.policy_invalid", "invalid destination policy, denying all destinations", "error", err)
+		return denyAllPolicy()
+	}
+	return policy
+}
+
+func readDestinationPolicyFile() (*DestinationPolicy, error) {
+	data, err := os.ReadFile(destinationPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	var policy DestinationPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	policy.mergeDefaultDenies(destinationPolicyFile)
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func loadDestinationPolicy() *DestinationPolicy {
+	if destinationPolicyFile == "" {
+		return envDestinationPolicy()
+	}
+	policy, err := readDestinationPolicyFile()
+	if os.IsNotExist(err) {
+		return envDestinationPolicy()
+	}
+	if err != nil {
+		logError("destination.policy_invalid", "failed to load DESTINATION_POLICY_FILE, denying all destinations", "file", destinationPolicyFile, "error", err)
+		return denyAllPolicy()
+	}
+	return policy
+}
+
+func currentDestinationPolicy() *DestinationPolicy {
+	destinationPolicy.mutex.RLock()
+	defer destinationPolicy.mutex.RUnlock()
+	return destinationPolicy.policy
+}
+
+// setDestinationPolicy 替换策略，配置了文件时同时写入
+func setDestinationPolicy(policy *DestinationPolicy) error {
+	policy.mergeDefaultDenies("api")
+	if err := policy.compile(); err != nil {
+		return err
+	}
+	destinationPolicy.mutex.Lock()
+	defer destinationPolicy.mutex.Unlock()
+	if destinationPolicyFile != "" {
+		data, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(destinationPolicyFile+".tmp", data, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(destinationPolicyFile+".tmp", destinationPolicyFile); err != nil {
+			return err
+		}
+	}
+	destinationPolicy.policy = policy
+	return nil
+}
+
+// watchDestinationPolicy 收到SIGHUP时重新读取策略文件，读取失败时保留原策略
+func watchDestinationPolicy() {
+	if destinationPolicyFile == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			policy, err := readDestinationPolicyFile()
+			if err != nil {
+				logWarn("destination.policy_reload_failed", "destination policy reload failed, keeping previous policy", "file", destinationPolicyFile, "error", err)
+				continue
+			}
+			destinationPolicy.mutex.Lock()
+			destinationPolicy.policy = policy
+			destinationPolicy.mutex.Unlock()
+			logInfo("destination.policy_reloaded", "destination policy reloaded", "file", destinationPolicyFile)
+		}
+	}()
+}
+
+// auditDestinationDenied 拒绝记录写入审计日志（未启用时转发到审计sink）
+func auditDestinationDenied(derr *destinationError) {
+	details := derr.details()
+	auditLog.Record(AuditEntry{
+		Interface: "policy",
+		Operation: "destination_check",
+		Host:      details["destination"].(string),
+		Status:    http.StatusForbidden,
+		ErrorCode: CodeDestinationDenied,
+		Decision:  "denied",
+		Reason:    derr.rule,
+	})
+}
+
+// checkDestination 校验解析后的目标地址是否允许访问，拒绝时写入审计日志
+func checkDestination(ip net.IP) error {
+	err := currentDestinationPolicy().Check(ip)
+	if derr, ok := err.(*destinationError); ok {
+		auditDestinationDenied(derr)
+	}
+	return err
+}
+
+// resolveDestination 本地解析主机名并校验所有地址，无法解析时拒绝；
+// remote为true时匹配remote_hosts的主机名交给远端解析，返回nil IP
+func resolveDestination(ctx context.Context, host string, remote bool) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, checkDestination(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		if remote && currentDestinationPolicy().remoteHost(host) {
+			return nil, nil
+		}
+		derr := &destinationError{host: host, rule: "unresolvable host"}
+		auditDestinationDenied(derr)
+		return nil, derr
+	}
+	for _, addr := range addrs {
+		if err := checkDestination(addr.IP); err != nil {
+			return nil, err
+		}
+	}
+	return addrs[0].IP, nil
+}
+
+// checkDestinationHost 用于自行建立连接的外部工具，返回校验过的IP，调用方应连接该IP而不是主机名
+func checkDestinationHost(ctx context.Context, host string) (net.IP, error) {
+	return resolveDestination(ctx, host, false)
+}
+
+// checkTunnelAddress 校验经SSH连接建立通道的目标host:port；
+// 回环地址指向SSH设备自身（如本机exporter或数据库），不按本地策略拒绝
+func checkTunnelAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = resolveDestination(ctx, host, true)
+	return err
+}
+
+// tunnelDial 经SSH连接建立通道前先校验目标
+func tunnelDial(client *ssh.Client, network, address string) (net.Conn, error) {
+	if err := checkTunnelAddress(address); err != nil {
+		return nil, err
+	}
+	return client.Dial(network, address)
+}
+
+// destinationControl 在建立连接前检查实际连接的IP，防止通过DNS解析绕过策略
+func destinationControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid destination address: %s", address)
+	}
+	return checkDestination(ip)
+}
+
+func safeDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: destinationControl}
+}
+
+func registerDestinationPolicyRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/destination-policy", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentDestinationPolicy())
+	})
+
+	admin.PUT("", func(c *gin.Context) {
+		var policy DestinationPolicy
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := setDestinationPolicy(&policy); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		logInfo("audit", "destination policy replaced", requestLogFields(c)...)
+		c.JSON(http.StatusOK, currentDestinationPolicy())
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDestinationPolicyKeepsDefaultDenies(t *testing.T) {
+	useDestinationPolicy(t, currentDestinationPolicy())
+	file := filepath.Join(t.TempDir(), "policy.json")
+	previousFile := destinationPolicyFile
+	destinationPolicyFile = file
+	t.Cleanup(func() { destinationPolicyFile = previousFile })
+
+	metadata := net.ParseIP("169.254.169.254")
+	if err := setDestinationPolicy(&DestinationPolicy{Deny: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := currentDestinationPolicy().Check(metadata); err == nil {
+		t.Fatal("replacing the policy dropped the built-in denies")
+	}
+
+	// 文件中省略的内置网段在读取时补回
+	os.WriteFile(file, []byte(`{"deny":[],"allow":["10.0.0.0/8"]}`), 0600)
+	policy, err := readDestinationPolicyFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Check(net.ParseIP("127.0.0.1")); err == nil {
+		t.Fatal("policy file dropped the built-in denies")
+	}
+
+	// 只有显式覆盖时才去掉
+	if err := setDestinationPolicy(&DestinationPolicy{OverrideDefaultDeny: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := currentDestinationPolicy().Check(metadata); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+}
+
+func TestDefaultDeniesCoverIPv6Forms(t *testing.T) {
+	policy := &DestinationPolicy{Deny: append([]string{}, defaultDenyCIDRs...)}
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		ip     string
+		denied bool
+	}{
+		{"::", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"fd00:ec2::254", true},
+		{"fe80::1", true},
+		{"127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"2001:db8::1", false},
+		{"::ffff:192.0.2.1", false},
+		{"fd00:ec2::253", false},
+	} {
+		if err := policy.Check(net.ParseIP(tc.ip)); (err != nil) != tc.denied {
+			t.Errorf("Check(%s) = %v, want denied %v", tc.ip, err, tc.denied)
+		}
+	}
+
+	// 拨号到 [::] 在连接前被拒绝，不会到达本机回环
+	useDestinationPolicy(t, policy)
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	for _, host := range []string{"::", "::ffff:127.0.0.1", "::1"} {
+		conn, err := safeDialer(time.Second).DialContext(context.Background(), "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+		}
+		if !errors.Is(err, errDestinationDenied) {
+			t.Errorf("dial [%s]: err = %v, want denied", host, err)
+		}
+	}
+}
+
+func TestUnresolvableDestinationsFailClosed(t *testing.T) {
+	useDestinationPolicy(t, &DestinationPolicy{Deny: append([]string{}, defaultDenyCIDRs...), RemoteHosts: []string{"*.tunnel.invalid"}})
+	ctx := context.Background()
+
+	if ip, err := checkDestinationHost(ctx, "missing.invalid"); !errors.Is(err, errDestinationDenied) || ip != nil {
+		t.Fatalf("checkDestinationHost = %v, %v, want denied", ip, err)
+	}
+	if err := checkTunnelAddress("missing.invalid:22"); !errors.Is(err, errDestinationDenied) {
+		t.Fatalf("tunnel to an unlisted unresolvable host: %v", err)
+	}
+	// remote_hosts中的主机名交给远端解析，但直接连接仍然拒绝
+	if err := checkTunnelAddress("db.tunnel.invalid:5432"); err != nil {
+		t.Fatalf("tunnel to a remote host: %v", err)
+	}
+	if _, err := checkDestinationHost(ctx, "db.tunnel.invalid"); !errors.Is(err, errDestinationDenied) {
+		t.Fatalf("direct connection to a remote host: %v", err)
+	}
+	if ip, err := checkDestinationHost(ctx, "192.0.2.1"); err != nil || !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("literal IP = %v, %v", ip, err)
+	}
+}
+
+func TestDestinationDenialsAreAudited(t *testing.T) {
+	a := useAuditLog(t)
+	useDestinationPolicy(t, &DestinationPolicy{Deny: append([]string{}, defaultDenyCIDRs...)})
+
+	if err := checkDestination(net.ParseIP("169.254.169.254")); err == nil {
+		t.Fatal("metadata address allowed")
+	}
+	checkDestinationHost(context.Background(), "missing.invalid")
+	if err := checkDestination(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := auditEntries(t, a, AuditQuery{Operation: "destination_check"})
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Decision != "denied" || entry.ErrorCode != CodeDestinationDenied || entry.Interface != "policy" {
+			t.Errorf("entry = %+v", entry)
+		}
+	}
+	if entries[1].Host != "169.254.169.254" || entries[1].Reason != "deny 169.254.0.0/16" || entries[0].Host != "missing.invalid" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if v := a.Verify(); !v.Valid {
+		t.Fatalf("verify: %+v", v)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 结果对比：输出按行做Myers diff生成unified diff，双方都有parsed时按键逐项对比
+
+type DiffOptions struct {
+	IgnoreWhitespace bool `json:"ignore_whitespace"`
+	// IgnorePatterns 匹配的行不参与对比（时间戳、计数器等）
+	IgnorePatterns []string `json:"ignore_patterns"`
+	// Context unified diff上下文行数，默认3
+	Context *int `json:"context"`
+}
+
+// ParsedChange 结构化对比的单项差异，Path形如 interfaces[0].oper_status
+type ParsedChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added / removed / changed
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+type DiffResult struct {
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	Diff          string         `json:"diff"`
+	Added         int            `json:"added"`
+	Removed       int            `json:"removed"`
+	Changed       bool           `json:"changed"`
+	ParsedChanges []ParsedChange `json:"parsed_changes,omitempty"`
+}
+
+type diffLine struct {
+	index int // 原始行号（从0开始）
+	text  string
+	key   string
+}
+
+type diffOp struct {
+	kind byte // ' ' / '-' / '+'
+	a, b int  // 在过滤后行列表中的位置
+}
+
+func diffInput(text string, ignore []*regexp.Regexp, ignoreWhitespace bool) []diffLine {
+	var lines []diffLine
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+Lines:
+	for i, line := range strings.Split(text, "\n") {
+		for _, re := range ignore {
+			if re.MatchString(line) {
+				continue Lines
+			}
+		}
+		key := line
+		if ignoreWhitespace {
+			key = strings.Join(strings.Fields(line), " ")
+		}
+		lines = append(lines, diffLine{index: i, text: line, key: key})
+	}
+	return lines
+}
+
+// myersDiff 返回把a变为b的编辑序列，trace只保存每轮用到的对角线范围
+func myersDiff(a, b []diffLine) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x].key == b[y].key {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] 下标0对应对角线 -d-1
+		get := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', x, prevY})
+			} else {
+				ops = append(ops, diffOp{'-', prevX, y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff 按上下文行数把编辑序列分组为hunk
+func unifiedDiff(a, b []diffLine, ops []diffOp, context int, fromName, toName string) string {
+	var out strings.Builder
+	lineNo := func(lines []diffLine, i int) int {
+		if i < len(lines) {
+			return lines[i].index + 1
+		}
+		if len(lines) > 0 {
+			return lines[len(lines)-1].index + 2
+		}
+		return 1
+	}
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// 扩展hunk直到连续相同行超过2*context
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		end, equal := start, 0
+		for end < len(ops) {
+			if ops[end].kind == ' ' {
+				equal++
+				if equal > 2*context {
+					break
+				}
+			} else {
+				equal = 0
+			}
+			end++
+		}
+		end -= equal
+		if equal > context {
+			end += context
+		} else {
+			end += equal
+		}
+
+		var body strings.Builder
+		aStart, bStart := lineNo(a, ops[from].a), lineNo(b, ops[from].b)
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:end] {
+			switch op.kind {
+			case ' ':
+				body.WriteString(" " + a[op.a].text + "\n")
+				aCount++
+				bCount++
+			case '-':
+				body.WriteString("-" + a[op.a].text + "\n")
+				aCount++
+			case '+':
+				body.WriteString("+" + b[op.b].text + "\n")
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		out.WriteString(body.String())
+		start = end
+	}
+	return out.String()
+}
+
+// normalizeDocument 解析器输出为Go结构体，统一转换为JSON通用结构后对比
+func normalizeDocument(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	err = json.Unmarshal(raw, &document)
+	return document, err
+}
+
+func diffParsed(path string, a, b interface{}, changes *[]ParsedChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			old, inA := av[key]
+			value, inB := bv[key]
+			switch {
+			case !inA:
+				*changes = append(*changes, ParsedChange{Path: child, Op: "added", New: value})
+			case !inB:
+				*changes = append(*changes, ParsedChange{Path: child, Op: "removed", Old: old})
+			default:
+				diffParsed(child, old, value, changes)
+			}
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			child := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(av):
+				*changes = append(*changes, ParsedChange{Path: child, Op: "added", New: bv[i]})
+			case i >= len(bv):
+				*changes = append(*changes, ParsedChange{Path: child, Op: "removed", Old: av[i]})
+			default:
+				diffParsed(child, av[i], bv[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, ParsedChange{Path: path, Op: "changed", Old: a, New: b})
+	}
+}
+
+// DiffResults 对比两次命令结果
+func DiffResults(from, to *CommandResult, fromName, toName string, opts DiffOptions) (*DiffResult, error) {
+	var ignore []*regexp.Regexp
+	for _, pattern := range opts.IgnorePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %v", pattern, err)
+		}
+		ignore = append(ignore, re)
+	}
+	context := 3
+	if opts.Context != nil && *opts.Context >= 0 {
+		context = *opts.Context
+	}
+
+	a := diffInput(from.Output, ignore, opts.IgnoreWhitespace)
+	b := diffInput(to.Output, ignore, opts.IgnoreWhitespace)
+	ops := myersDiff(a, b)
+	result := &DiffResult{From: fromName, To: toName, Diff: unifiedDiff(a, b, ops, context, fromName, toName)}
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			result.Added++
+		case '-':
+			result.Removed++
+		}
+	}
+
+	if from.Parsed != nil && to.Parsed != nil {
+		a, err := normalizeDocument(from.Parsed)
+		if err != nil {
+			return nil, err
+		}
+		b, err := normalizeDocument(to.Parsed)
+		if err != nil {
+			return nil, err
+		}
+		diffParsed("", a, b, &result.ParsedChanges)
+	}
+	result.Changed = result.Added > 0 || result.Removed > 0 || len(result.ParsedChanges) > 0
+	return result, nil
+}
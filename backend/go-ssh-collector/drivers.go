@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errUnknownDeviceType = errors.New("unknown device type")
+	errBuiltinDriver     = errors.New("builtin driver cannot be modified")
+)
+
+// DeviceDriver 描述某类设备的交互特性，通过SSHConfig.device_type选择
+type DeviceDriver struct {
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	Prompt         string `json:"prompt"`
+	LoginPrompt    string `json:"login_prompt"`
+	PasswordPrompt string `json:"password_prompt"`
+	PagingCommand  string `json:"paging_command"`
+	// 提权命令及其密码提示符，连接时提供enable_password才会执行
+	EnableCommand string `json:"enable_command"`
+	EnablePrompt  string `json:"enable_prompt"`
+	// 命令被设备拒绝时的输出特征
+	ErrorPatterns []string `json:"error_patterns"`
+	Builtin       bool     `json:"builtin"`
+
+	errorRegexps []*regexp.Regexp
+}
+
+const (
+	ciscoPrompt  = `[\w.\-@()/:]+[>#]\s*$`
+	huaweiPrompt = `[<\[][\w.\-@()/:~]+[>\]]\s*$`
+)
+
+var builtinDrivers = []DeviceDriver{
+	{
+		Name:        "generic",
+		Description: "Generic device, prompt detection only",
+		Prompt:      defaultPrompt,
+	},
+	{
+		Name:          "cisco_ios",
+		Description:   "Cisco IOS / IOS-XE",
+		Prompt:        ciscoPrompt,
+		PagingCommand: "terminal length 0",
+		EnableCommand: "enable",
+		EnablePrompt:  `(?i)password:\s*$`,
+		ErrorPatterns: []string{`% Invalid input detected`, `% Incomplete command`, `% Ambiguous command`, `% Unknown command`},
+	},
+	{
+		Name:          "cisco_nxos",
+		Description:   "Cisco NX-OS",
+		Prompt:        ciscoPrompt,
+		PagingCommand: "terminal length 0",
+		ErrorPatterns: []string{`% Invalid command`, `% Incomplete command`, `Syntax error while parsing`},
+	},
+	{
+		Name:          "juniper_junos",
+		Description:   "Juniper Junos",
+		Prompt:        `[\w.\-@()/:]+[>#%]\s*$`,
+		PagingCommand: "set cli screen-length 0",
+		ErrorPatterns: []string{`unknown command\.`, `syntax error`, `(?m)^error:`},
+	},
+	{
+		Name:          "huawei_vrp",
+		Description:   "Huawei VRP",
+		Prompt:        huaweiPrompt,
+		PagingCommand: "screen-length 0 temporary",
+		EnableCommand: "super",
+		EnablePrompt:  `(?i)password:\s*$`,
+		ErrorPatterns: []string{`Error: Unrecognized command`, `Error: Incomplete command`, `Error: Wrong parameter`, `Error: Too many parameters`},
+	},
+	{
+		Name:          "hp_comware",
+		Description:   "HP / H3C Comware",
+		Prompt:        huaweiPrompt,
+		PagingCommand: "screen-length disable",
+		EnableCommand: "super",
+		EnablePrompt:  `(?i)password:\s*$`,
+		ErrorPatterns: []string{`% Unrecognized command`, `% Incomplete command`, `% Wrong parameter`, `% Too many parameters`},
+	},
+	{
+		Name:          "linux",
+		Description:   "Linux / Unix shell",
+		Prompt:        `[$#]\s*$`,
+		EnableCommand: "sudo -s",
+		EnablePrompt:  `(?i)(\[sudo\] )?password( for [^:]+)?:\s*$`,
+		ErrorPatterns: []string{`(?m): command not found$`},
+	},
+}
+
+// compile 校验并预编译驱动中的正则
+func (d *DeviceDriver) compile() error {
+	for _, pattern := range []string{d.Prompt, d.LoginPrompt, d.PasswordPrompt, d.EnablePrompt} {
+		if pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	compiled, err := compilePatterns(d.ErrorPatterns)
+	if err != nil {
+		return err
+	}
+	d.errorRegexps = compiled
+	return nil
+}
+
+// Rejected 返回输出中命中拒绝特征的行，未命中时返回空
+func (d *DeviceDriver) Rejected(output string) string {
+	for _, re := range d.errorRegexps {
+		if loc := re.FindStringIndex(output); loc != nil {
+			start := strings.LastIndex(output[:loc[0]], "\n") + 1
+			end := strings.Index(output[loc[0]:], "\n")
+			if end < 0 {
+				return strings.TrimSpace(output[start:])
+			}
+			return strings.TrimSpace(output[start : loc[0]+end])
+		}
+	}
+	return ""
+}
+
+func (d *DeviceDriver) capabilities() []string {
+	var caps []string
+	if d.PagingCommand != "" {
+		caps = append(caps, "paging_disable")
+	}
+	if d.EnableCommand != "" {
+		caps = append(caps, "enable")
+	}
+	if len(d.ErrorPatterns) > 0 {
+		caps = append(caps, "error_detection")
+	}
+	return caps
+}
+
+type DriverRegistry struct {
+	drivers map[string]*DeviceDriver
+	mutex   sync.RWMutex
+}
+
+var drivers = newDriverRegistry()
+
+func newDriverRegistry() *DriverRegistry {
+	registry := &DriverRegistry{drivers: make(map[string]*DeviceDriver)}
+	for i := range builtinDrivers {
+		driver := builtinDrivers[i]
+		driver.Builtin = true
+		if err := driver.compile(); err != nil {
+			panic(fmt.Sprintf("builtin driver %s: %v", driver.Name, err))
+		}
+		registry.drivers[driver.Name] = &driver
+	}
+	return registry
+}
+
+// Lookup 返回设备类型对应的驱动，为空时使用generic
+func (r *DriverRegistry) Lookup(deviceType string) (*DeviceDriver, error) {
+	if deviceType == "" {
+		deviceType = "generic"
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	driver, ok := r.drivers[deviceType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownDeviceType, deviceType)
+	}
+	return driver, nil
+}
+
+func (r *DriverRegistry) Define(driver DeviceDriver) error {
+	driver.Builtin = false
+	if err := driver.compile(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if existing, ok := r.drivers[driver.Name]; ok && existing.Builtin {
+		return errBuiltinDriver
+	}
+	r.drivers[driver.Name] = &driver
+	return nil
+}
+
+func (r *DriverRegistry) Remove(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	driver, ok := r.drivers[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownDeviceType, name)
+	}
+	if driver.Builtin {
+		return errBuiltinDriver
+	}
+	delete(r.drivers, name)
+	return nil
+}
+
+func (r *DriverRegistry) List() []gin.H {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(r.drivers))
+	for _, driver := range r.drivers {
+		list = append(list, gin.H{
+			"name":           driver.Name,
+			"description":    driver.Description,
+			"builtin":        driver.Builtin,
+			"capabilities":   driver.capabilities(),
+			"prompt":         driver.Prompt,
+			"paging_command": driver.PagingCommand,
+			"enable_command": driver.EnableCommand,
+			"error_patterns": driver.ErrorPatterns,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["name"].(string) < list[j]["name"].(string) })
+	return list
+}
+
+func registerDriverRoutes(r *gin.Engine) {
+	r.GET("/drivers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"drivers": drivers.List()})
+	})
+
+	// 定义自定义驱动，同名自定义驱动会被覆盖
+	r.POST("/drivers", func(c *gin.Context) {
+		var driver DeviceDriver
+		if err := c.ShouldBindJSON(&driver); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := drivers.Define(driver); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errBuiltinDriver) {
+				status = http.StatusConflict
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"driver": driver.Name, "status": "defined"})
+	})
+
+	r.DELETE("/drivers/:name", func(c *gin.Context) {
+		if err := drivers.Remove(c.Param("name")); err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, errBuiltinDriver) {
+				status = http.StatusConflict
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+}
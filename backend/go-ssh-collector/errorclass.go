@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 错误分类：连接和执行失败按原因归类，错误响应、WebSocket错误、批量条目和CommandResult中
+// 以 error_class 字段返回，并按类别和host计入 collector_errors_total，调用方无需匹配错误信息。
+// 类别在失败处按包装的错误类型确定（见errorClass），无法从类型判断时由失败处用withErrorClass指定
+
+const (
+	classDNSFailure        = "dns_failure"
+	classTCPTimeout        = "tcp_timeout"
+	classTCPRefused        = "tcp_refused"
+	classAuthFailed        = "auth_failed"
+	classHostKeyMismatch   = "host_key_mismatch"
+	classSessionOpenFailed = "session_open_failed"
+	classCommandTimeout    = "command_timeout"
+	classConnectionLost    = "connection_lost"
+	classPolicyDenied      = "policy_denied"
+	classOutputTruncated   = "output_truncated"
+
+	// 指标中未归类的失败
+	classOther = "other"
+)
+
+// errorClasses 哨兵错误到类别的映射，按errors.Is匹配
+var errorClasses = []struct {
+	err   error
+	class string
+}{
+	{errCommandTimeout, classCommandTimeout},
+	{errWinRMOperationTimeout, classCommandTimeout},
+	{context.DeadlineExceeded, classCommandTimeout},
+	{errSSHAuthFailed, classAuthFailed},
+	{errTelnetAuthRejected, classAuthFailed},
+	{errWinRMAuth, classAuthFailed},
+	{errCommandDenied, classPolicyDenied},
+	{errAgentForwardingDisabled, classPolicyDenied},
+	{errUnmaskedDenied, classPolicyDenied},
+	{errForwardDenied, classPolicyDenied},
+	{errStatementNotAllow, classPolicyDenied},
+	{errDestinationDenied, classPolicyDenied},
+}
+
+// classifiedError 在失败处指定类别，如会话建立失败或x/crypto/ssh只返回文本的握手错误
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// withErrorClass 失败处指定的类别优先于按类型推断的类别，如会话建立时的EOF
+func withErrorClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// errorClass 返回错误的类别，无法归类时为空
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	for _, entry := range errorClasses {
+		if errors.Is(err, entry.err) {
+			return entry.class
+		}
+	}
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+		return classHostKeyMismatch
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return classDNSFailure
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return classTCPRefused
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return classTCPTimeout
+	}
+	var exitMissing *ssh.ExitMissingError
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.As(err, &exitMissing) {
+		return classConnectionLost
+	}
+	return ""
+}
+
+// resultErrorClass 执行结果的类别：结果中的错误优先，其次为输出截断
+func resultErrorClass(result *CommandResult, err error) string {
+	if class := errorClass(err); class != "" || err != nil {
+		return class
+	}
+	if result != nil && result.Truncated {
+		return classOutputTruncated
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// timeoutError 模拟net包中的超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClass(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"unclassified", errors.New("something odd"), ""},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "r1.invalid"}}, classDNSFailure},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, classTCPRefused},
+		{"dial timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, classTCPTimeout},
+		{"host key mismatch", &knownhosts.KeyError{Want: []knownhosts.KnownKey{{Key: hostKey}}}, classHostKeyMismatch},
+		// 未知主机（Want为空）不是密钥不匹配
+		{"unknown host key", &knownhosts.KeyError{}, ""},
+		{"eof", fmt.Errorf("read: %w", io.EOF), classConnectionLost},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, classConnectionLost},
+		{"exit missing", &ssh.ExitMissingError{}, classConnectionLost},
+		{"command timeout", fmt.Errorf("%w after 5s", errCommandTimeout), classCommandTimeout},
+		{"context deadline", context.DeadlineExceeded, classCommandTimeout},
+		{"auth", fmt.Errorf("%w: password", errSSHAuthFailed), classAuthFailed},
+		{"policy", fmt.Errorf("%w: reload", errCommandDenied), classPolicyDenied},
+		{"destination", &destinationError{host: "169.254.169.254", rule: "deny 169.254.0.0/16"}, classPolicyDenied},
+		// 失败处指定的类别优先于按类型推断的类别
+		{"explicit class", withErrorClass(classSessionOpenFailed, io.EOF), classSessionOpenFailed},
+		{"explicit class wrapped", fmt.Errorf("execute: %w", withErrorClass(classHostKeyMismatch, errors.New("ssh: handshake failed"))), classHostKeyMismatch},
+		{"redacted", redactError(fmt.Errorf("%w: password=x1y2", errSSHAuthFailed)), classAuthFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := errorClass(tc.err); got != tc.want {
+				t.Fatalf("errorClass(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+	if withErrorClass(classAuthFailed, nil) != nil {
+		t.Fatal("withErrorClass(nil) != nil")
+	}
+}
+
+func TestResultErrorClass(t *testing.T) {
+	truncated := &CommandResult{Truncated: true}
+	if got := resultErrorClass(truncated, nil); got != classOutputTruncated {
+		t.Fatalf("truncated = %q", got)
+	}
+	if got := resultErrorClass(truncated, errCommandTimeout); got != classCommandTimeout {
+		t.Fatalf("truncated with error = %q", got)
+	}
+	// 未归类的错误不因截断被记为output_truncated
+	if got := resultErrorClass(truncated, errors.New("odd")); got != "" {
+		t.Fatalf("unclassified error = %q", got)
+	}
+	if got := resultErrorClass(&CommandResult{}, nil); got != "" {
+		t.Fatalf("success = %q", got)
+	}
+}
+
+// 真实连接失败的类别出现在错误响应、连接统计和指标中
+func TestConnectFailuresAreClassified(t *testing.T) {
+	stats := useServiceMetrics(t)
+	server := startTestSSHServer(t)
+	allowLoopbackDestinations(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	r := newRouter()
+	cases := []struct {
+		name, body, class string
+	}{
+		{"refused", `{"host":"127.0.0.1","port":` + strconv.Itoa(closedPort) + `,"username":"u","password":"p1p2p3","timeout":2}`, classTCPRefused},
+		{"auth", `{"host":"127.0.0.1","port":` + strconv.Itoa(server.Port()) + `,"username":"` + testSSHUser + `","password":"wrong-password","timeout":5,"force":true}`, classAuthFailed},
+		{"destination", `{"host":"169.254.169.254","username":"u","password":"p1p2p3","timeout":2}`, classPolicyDenied},
+	}
+	for _, tc := range cases {
+		w := apiRequest(r, "", http.MethodPost, "/connect", tc.body)
+		var body APIError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.ErrorClass != tc.class {
+			t.Errorf("%s: status %d, error_class %q, want %q: %s", tc.name, w.Code, body.ErrorClass, tc.class, w.Body.String())
+		}
+	}
+
+	for _, key := range [][2]string{{classTCPRefused, "127.0.0.1"}, {classAuthFailed, "127.0.0.1"}} {
+		if n := testutil.ToFloat64(stats.errorClasses.WithLabelValues(key[0], key[1])); n != 1 {
+			t.Errorf("collector_errors_total%v = %v, want 1", key, n)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统一错误响应：
+//   {"code":"CONNECTION_NOT_FOUND","message":"connection not found","details":{...},"request_id":"...","error":"connection not found"}
+// code 为稳定的机器可读值，客户端应按code判断；message 可能随版本调整。
+// error 与message相同，保留给只读取error字段的旧客户端。
+// 5xx错误的原始内容（SSH/网络错误、panic）只写入日志，API_DEBUG_ERRORS=true 时放入 details.cause
+
+// 错误码，新增后需加入errorCodeEnum
+const (
+	// 按HTTP状态的通用错误码
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeAuthFailed           = "AUTH_FAILED"
+	CodePermissionDenied     = "PERMISSION_DENIED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeNotAcceptable        = "NOT_ACCEPTABLE"
+	CodeConflict             = "CONFLICT"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRangeNotSatisfiable  = "RANGE_NOT_SATISFIABLE"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeInternalError        = "INTERNAL_ERROR"
+	CodeUpstreamError        = "UPSTREAM_ERROR"
+	CodeUnavailable          = "UNAVAILABLE"
+	CodeUpstreamTimeout      = "UPSTREAM_TIMEOUT"
+
+	// 业务错误码
+	CodeConnectionNotFound    = "CONNECTION_NOT_FOUND"
+	CodeJobNotFound           = "JOB_NOT_FOUND"
+	CodeResultNotFound        = "RESULT_NOT_FOUND"
+	CodeCommandTimeout        = "COMMAND_TIMEOUT"
+	CodeCommandFailed         = "COMMAND_FAILED"
+	CodeConnectFailed         = "CONNECT_FAILED"
+	CodeConnectionUnhealthy   = "CONNECTION_UNHEALTHY"
+	CodePolicyDenied          = "POLICY_DENIED"
+	CodeUnsupportedProtocol   = "UNSUPPORTED_PROTOCOL"
+	CodeUnknownDeviceType     = "UNKNOWN_DEVICE_TYPE"
+	CodeWrongConnectionType   = "WRONG_CONNECTION_TYPE"
+	CodeConsoleBusy           = "CONSOLE_BUSY"
+	CodeTransferTooLarge      = "TRANSFER_TOO_LARGE"
+	CodeTunnelFailed          = "TUNNEL_FAILED"
+	CodeDeviceAuthFailed      = "DEVICE_AUTH_FAILED"
+	CodeBuiltinReadOnly       = "BUILTIN_READ_ONLY"
+	CodeVersionConflict       = "VERSION_CONFLICT"
+	CodeChecksumMismatch      = "CHECKSUM_MISMATCH"
+	CodeClientCertRevoked     = "CLIENT_CERT_REVOKED"
+	CodeFeatureDisabled       = "FEATURE_DISABLED"
+	CodeRemoteToolUnavailable = "REMOTE_TOOL_UNAVAILABLE"
+	CodeIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	CodeDestinationDenied     = "DESTINATION_DENIED"
+	CodeTargetLocked          = "TARGET_LOCKED"
+)
+
+// errorCodeEnum 文档中列出的全部错误码
+var errorCodeEnum = []string{
+	CodeInvalidRequest,
+	CodeAuthFailed,
+	CodePermissionDenied,
+	CodeNotFound,
+	CodeNotAcceptable,
+	CodeConflict,
+	CodePayloadTooLarge,
+	CodeUnsupportedMediaType,
+	CodeRangeNotSatisfiable,
+	CodeRateLimited,
+	CodeInternalError,
+	CodeUpstreamError,
+	CodeUnavailable,
+	CodeUpstreamTimeout,
+	CodeConnectionNotFound,
+	CodeJobNotFound,
+	CodeResultNotFound,
+	CodeCommandTimeout,
+	CodeCommandFailed,
+	CodeConnectFailed,
+	CodeConnectionUnhealthy,
+	CodePolicyDenied,
+	CodeUnsupportedProtocol,
+	CodeUnknownDeviceType,
+	CodeWrongConnectionType,
+	CodeConsoleBusy,
+	CodeTransferTooLarge,
+	CodeTunnelFailed,
+	CodeDeviceAuthFailed,
+	CodeBuiltinReadOnly,
+	CodeVersionConflict,
+	CodeChecksumMismatch,
+	CodeClientCertRevoked,
+	CodeFeatureDisabled,
+	CodeRemoteToolUnavailable,
+	CodeIdempotencyKeyReused,
+	CodeDestinationDenied,
+	CodeTargetLocked,
+}
+
+// 为true时5xx响应在details.cause中带原始错误
+var apiDebugErrors = os.Getenv("API_DEBUG_ERRORS") == "true"
+
+// errorCodes 内部错误到错误码的映射，按errors.Is匹配，靠前的优先
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errDestinationDenied, CodeDestinationDenied},
+	{errConnectionNotFound, CodeConnectionNotFound},
+	{errJobNotFound, CodeJobNotFound},
+	{errResultNotFound, CodeResultNotFound},
+	{errCommandTimeout, CodeCommandTimeout},
+	{errWinRMOperationTimeout, CodeCommandTimeout},
+	{errCommandDenied, CodePolicyDenied},
+	{errAgentForwardingDisabled, CodePolicyDenied},
+	{errUnmaskedDenied, CodePolicyDenied},
+	{errForwardDenied, CodePolicyDenied},
+	{errStatementNotAllow, CodePolicyDenied},
+	{errUnsupportedProtocol, CodeUnsupportedProtocol},
+	{errUnknownDeviceType, CodeUnknownDeviceType},
+	{errNotSSHConnection, CodeWrongConnectionType},
+	{errNotConsole, CodeWrongConnectionType},
+	{errConsoleBusy, CodeConsoleBusy},
+	{errTransferTooLarge, CodeTransferTooLarge},
+	{errNetconfReplyTooLarge, CodeTransferTooLarge},
+	{errTunnelFailed, CodeTunnelFailed},
+	{errWinRMAuth, CodeDeviceAuthFailed},
+	{errSSHAuthFailed, CodeDeviceAuthFailed},
+	{errTelnetAuthRejected, CodeDeviceAuthFailed},
+	{errTargetLocked, CodeTargetLocked},
+	{errBuiltinDriver, CodeBuiltinReadOnly},
+	{errBuiltinGrokPattern, CodeBuiltinReadOnly},
+	{errBuiltinMaskRule, CodeBuiltinReadOnly},
+	{errBuiltinParser, CodeBuiltinReadOnly},
+	{errBuiltinTemplate, CodeBuiltinReadOnly},
+	{errParserVersionConflict, CodeVersionConflict},
+	{errChecksumMismatch, CodeChecksumMismatch},
+	{errClientCertRevoked, CodeClientCertRevoked},
+	{errPluginsDisabled, CodeFeatureDisabled},
+	{errRemoteToolUnavailable, CodeRemoteToolUnavailable},
+	{errRangeNotSatisfiable, CodeRangeNotSatisfiable},
+	{errIdempotencyKeyReused, CodeIdempotencyKeyReused},
+	{context.DeadlineExceeded, CodeUpstreamTimeout},
+}
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:                   CodeInvalidRequest,
+	http.StatusUnauthorized:                 CodeAuthFailed,
+	http.StatusForbidden:                    CodePermissionDenied,
+	http.StatusNotFound:                     CodeNotFound,
+	http.StatusNotAcceptable:                CodeNotAcceptable,
+	http.StatusConflict:                     CodeConflict,
+	http.StatusRequestEntityTooLarge:        CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:         CodeUnsupportedMediaType,
+	http.StatusRequestedRangeNotSatisfiable: CodeRangeNotSatisfiable,
+	http.StatusTooManyRequests:              CodeRateLimited,
+	http.StatusInternalServerError:          CodeInternalError,
+	http.StatusBadGateway:                   CodeUpstreamError,
+	http.StatusServiceUnavailable:           CodeUnavailable,
+	http.StatusGatewayTimeout:               CodeUpstreamTimeout,
+}
+
+// codeMessages 隐藏原始错误时使用的说明
+var codeMessages = map[string]string{
+	CodeInternalError:       "internal error",
+	CodeUpstreamError:       "upstream request failed",
+	CodeUnavailable:         "service unavailable",
+	CodeUpstreamTimeout:     "upstream request timed out",
+	CodeCommandFailed:       "command execution failed",
+	CodeConnectFailed:       "failed to connect to device",
+	CodeConnectionUnhealthy: "connection unhealthy",
+}
+
+// APIError 错误响应体
+type APIError struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	// ErrorClass 连接或执行失败的类别（见errorclass.go）
+	ErrorClass string `json:"error_class,omitempty"`
+	// Deprecated: 与Message相同
+	Error string `json:"error"`
+}
+
+// codedError 为没有对应哨兵错误的失败指定错误码，如连接或执行失败
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+func withErrorCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// errorCode 依次按哨兵错误、withErrorCode和HTTP状态确定错误码；sentinel为匹配到的哨兵错误
+func errorCode(err error, status int) (code string, sentinel error) {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code, entry.err
+		}
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code, nil
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code, nil
+	}
+	if status >= 500 {
+		return CodeInternalError, nil
+	}
+	return CodeInvalidRequest, nil
+}
+
+// newAPIError 4xx使用原始错误信息；5xx只返回哨兵错误或错误码的说明，原始错误写入日志
+func newAPIError(status int, err error, details map[string]interface{}, requestID string) APIError {
+	code, sentinel := errorCode(err, status)
+	message := redact(err.Error())
+	if status >= 500 {
+		logError("error", "request failed", "request_id", requestID, "status", status, "code", code, "cause", message)
+		switch {
+		case sentinel != nil:
+			message = sentinel.Error()
+		case codeMessages[code] != "":
+			message = codeMessages[code]
+		default:
+			message = codeMessages[CodeInternalError]
+		}
+		if apiDebugErrors {
+			if details == nil {
+				details = map[string]interface{}{}
+			}
+			details["cause"] = redact(err.Error())
+		}
+	}
+	return APIError{Code: code, Message: message, Details: details, RequestID: requestID, ErrorClass: errorClass(err), Error: message}
+}
+
+// respondError 写入错误响应并终止后续处理，所有错误响应都应经过这里
+func respondError(c *gin.Context, status int, err error) {
+	respondErrorDetails(c, status, err, nil)
+}
+
+func respondErrorDetails(c *gin.Context, status int, err error, details map[string]interface{}) {
+	status, details = errorDetails(status, err, details)
+	c.AbortWithStatusJSON(status, newAPIError(status, err, details, requestID(c)))
+}
+
+// errorDetails 被目标地址策略拒绝（403，带匹配的规则）和目标被锁定（423，带解锁时间）的错误
+// 无论在哪一步返回都使用固定的状态码，并把错误自身的信息加入details
+func errorDetails(status int, err error, details map[string]interface{}) (int, map[string]interface{}) {
+	var extra map[string]interface{}
+	var destErr *destinationError
+	var lockedErr *targetLockedError
+	switch {
+	case err == nil:
+		return status, details
+	case errors.As(err, &destErr):
+		status, extra = http.StatusForbidden, destErr.details()
+	case errors.As(err, &lockedErr):
+		status, extra = http.StatusLocked, lockedErr.details()
+	}
+	// 连接失败时附带各次尝试的耗时分解，可与上面的错误同时出现
+	var attemptsErr *connectAttemptsError
+	if errors.As(err, &attemptsErr) {
+		if extra == nil {
+			extra = map[string]interface{}{}
+		}
+		for key, value := range attemptsErr.details() {
+			extra[key] = value
+		}
+	}
+	if extra == nil {
+		return status, details
+	}
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	for key, value := range extra {
+		details[key] = value
+	}
+	return status, details
+}
+
+// recoveryHandler panic时返回INTERNAL_ERROR，堆栈由gin写入日志
+func recoveryHandler(c *gin.Context, recovered interface{}) {
+	respondError(c, http.StatusInternalServerError, fmt.Errorf("panic: %v", recovered))
+}
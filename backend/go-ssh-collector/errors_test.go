@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 已发布的错误码不能改名或删除，只能新增
+var stableErrorCodes = []string{
+	"INVALID_REQUEST", "AUTH_FAILED", "PERMISSION_DENIED", "NOT_FOUND", "NOT_ACCEPTABLE", "CONFLICT",
+	"PAYLOAD_TOO_LARGE", "UNSUPPORTED_MEDIA_TYPE", "RANGE_NOT_SATISFIABLE", "RATE_LIMITED", "INTERNAL_ERROR",
+	"UPSTREAM_ERROR", "UNAVAILABLE", "UPSTREAM_TIMEOUT", "CONNECTION_NOT_FOUND", "JOB_NOT_FOUND",
+	"RESULT_NOT_FOUND", "COMMAND_TIMEOUT", "COMMAND_FAILED", "CONNECT_FAILED", "CONNECTION_UNHEALTHY",
+	"POLICY_DENIED", "UNSUPPORTED_PROTOCOL", "UNKNOWN_DEVICE_TYPE", "WRONG_CONNECTION_TYPE", "CONSOLE_BUSY",
+	"TRANSFER_TOO_LARGE", "TUNNEL_FAILED", "DEVICE_AUTH_FAILED", "BUILTIN_READ_ONLY", "VERSION_CONFLICT",
+	"CHECKSUM_MISMATCH", "CLIENT_CERT_REVOKED", "FEATURE_DISABLED", "REMOTE_TOOL_UNAVAILABLE",
+	"IDEMPOTENCY_KEY_REUSED", "DESTINATION_DENIED", "TARGET_LOCKED",
+}
+
+func TestErrorCodeEnumIsStable(t *testing.T) {
+	documented := make(map[string]bool)
+	for _, code := range errorCodeEnum {
+		if documented[code] {
+			t.Errorf("duplicate code %s", code)
+		}
+		documented[code] = true
+	}
+	for _, code := range stableErrorCodes {
+		if !documented[code] {
+			t.Errorf("published code %s is no longer documented", code)
+		}
+	}
+	for _, entry := range errorCodes {
+		if !documented[entry.code] {
+			t.Errorf("%v maps to undocumented code %s", entry.err, entry.code)
+		}
+	}
+	for status, code := range statusCodes {
+		if !documented[code] {
+			t.Errorf("status %d maps to undocumented code %s", status, code)
+		}
+	}
+}
+
+func TestErrorCodeMapping(t *testing.T) {
+	// 包装后的哨兵错误仍映射到自身的错误码，优先于withErrorCode
+	for _, entry := range errorCodes {
+		err := withErrorCode(CodeCommandFailed, fmt.Errorf("context: %w", entry.err))
+		if code, _ := errorCode(err, http.StatusInternalServerError); code != entry.code {
+			t.Errorf("%v: code %s, want %s", entry.err, code, entry.code)
+		}
+	}
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{withErrorCode(CodeConnectFailed, errors.New("dial tcp: refused")), http.StatusInternalServerError, CodeConnectFailed},
+		{errors.New("bad json"), http.StatusBadRequest, CodeInvalidRequest},
+		{errors.New("nope"), http.StatusForbidden, CodePermissionDenied},
+		{errors.New("slow down"), http.StatusTooManyRequests, CodeRateLimited},
+		{errors.New("boom"), http.StatusInternalServerError, CodeInternalError},
+		{errors.New("teapot"), http.StatusTeapot, CodeInvalidRequest},
+		{errors.New("weird"), 599, CodeInternalError},
+	}
+	for _, tc := range cases {
+		if code, _ := errorCode(tc.err, tc.status); code != tc.code {
+			t.Errorf("%v/%d: code %s, want %s", tc.err, tc.status, code, tc.code)
+		}
+	}
+}
+
+func TestAPIErrorHidesInternalDetails(t *testing.T) {
+	raw := errors.New("ssh: handshake failed: read tcp 10.0.0.1:22: connection reset")
+
+	e := newAPIError(http.StatusInternalServerError, withErrorCode(CodeCommandFailed, raw), nil, "req-1")
+	if e.Code != CodeCommandFailed || e.Message != "command execution failed" || e.Error != e.Message || e.Details != nil {
+		t.Fatalf("5xx error = %+v", e)
+	}
+	e = newAPIError(http.StatusInternalServerError, fmt.Errorf("lookup: %w", errConnectionNotFound), nil, "req-1")
+	if e.Message != errConnectionNotFound.Error() {
+		t.Fatalf("sentinel message = %q", e.Message)
+	}
+
+	apiDebugErrors = true
+	t.Cleanup(func() { apiDebugErrors = false })
+	e = newAPIError(http.StatusInternalServerError, raw, nil, "req-1")
+	if e.Message != "internal error" || e.Details["cause"] != raw.Error() {
+		t.Fatalf("debug error = %+v", e)
+	}
+
+	// 4xx返回原始信息
+	e = newAPIError(http.StatusBadRequest, errors.New("port out of range"), nil, "")
+	if e.Code != CodeInvalidRequest || e.Message != "port out of range" {
+		t.Fatalf("4xx error = %+v", e)
+	}
+}
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	var e APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("invalid error body %q: %v", w.Body.String(), err)
+	}
+	if e.Code == "" || e.Message == "" || e.Error != e.Message || e.RequestID != w.Header().Get(requestIDHeader) {
+		t.Fatalf("malformed envelope: %s", w.Body.String())
+	}
+	return e
+}
+
+func TestHandlerErrorResponses(t *testing.T) {
+	r := newRouter()
+	r.GET("/test/panic", func(c *gin.Context) { panic("secret stack detail") })
+
+	cases := []struct {
+		name, method, path, body string
+		status                   int
+		code                     string
+	}{
+		{"unknown route", http.MethodGet, "/no/such/route", "", http.StatusNotFound, CodeNotFound},
+		{"invalid body", http.MethodPost, "/execute", "{", http.StatusBadRequest, CodeInvalidRequest},
+		{"missing connection", http.MethodPost, "/execute", `{"connection_id":"missing","command":"uptime"}`, http.StatusNotFound, CodeConnectionNotFound},
+		{"missing job", http.MethodGet, "/jobs/missing", "", http.StatusNotFound, CodeJobNotFound},
+		{"unsupported protocol", http.MethodPost, "/connect", `{"host":"h","username":"u","password":"p","protocol":"gopher"}`, http.StatusBadRequest, CodeUnsupportedProtocol},
+		{"panic", http.MethodGet, "/test/panic", "", http.StatusInternalServerError, CodeInternalError},
+	}
+	for _, tc := range cases {
+		w := apiRequest(r, "", tc.method, tc.path, tc.body)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, w.Code, tc.status, w.Body.String())
+			continue
+		}
+		if e := decodeAPIError(t, w); e.Code != tc.code {
+			t.Errorf("%s: code %s, want %s", tc.name, e.Code, tc.code)
+		}
+		if strings.Contains(w.Body.String(), "secret stack detail") {
+			t.Errorf("%s: internal detail leaked: %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestRateLimitedErrorResponse(t *testing.T) {
+	previous := rateLimiter.Config()
+	t.Cleanup(func() { rateLimiter.SetConfig(previous) })
+	if err := rateLimiter.SetConfig(RateLimitConfig{Routes: map[string]RateLimit{"GET /connections": {Rate: 0.001, Burst: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	r := newRouter()
+	apiRequest(r, "", http.MethodGet, "/connections", "")
+	w := apiRequest(r, "", http.MethodGet, "/connections", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	e := decodeAPIError(t, w)
+	if e.Code != CodeRateLimited || e.Details["scope"] != "route" || e.Details["retry_after"] == nil {
+		t.Fatalf("error = %+v", e)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 生命周期事件：连接建立/中断/恢复/关闭、命令开始/结束（只含摘要）、任务状态变化、策略拒绝和告警触发/恢复
+// 统一发布到内部事件总线。GET /events 以SSE推送，可按 namespace、connection_id、type（逗号分隔）过滤。
+// 总线对每个订阅者使用有界队列（EVENT_SUBSCRIBER_BUFFER），队列满时丢弃该订阅者的事件并计数，
+// 慢的客户端不会阻塞发布方；SSE客户端在丢弃后收到一条 dropped 事件。
+// 总线同时是sinks（webhook等）的事件来源，EVENT_SINK_TYPES 限制转发的类型，为none时不转发
+
+const (
+	eventConnectionCreated  = "connection_created"
+	eventConnectionLost     = "connection_lost"
+	eventConnectionRestored = "connection_restored"
+	eventConnectionClosed   = "connection_closed"
+	eventCommandStarted     = "command_started"
+	eventCommandFinished    = "command_finished"
+	eventJobStateChanged    = "job_state_changed"
+	eventPolicyDenied       = "policy_denied"
+	eventAlertFiring        = "alert_firing"
+	eventAlertResolved      = "alert_resolved"
+)
+
+var (
+	eventSubscriberBuffer = int(envInt64("EVENT_SUBSCRIBER_BUFFER", 256))
+	eventSinkTypes        = splitList(getEnv("EVENT_SINK_TYPES", ""))
+)
+
+type LifecycleEvent struct {
+	// ID 进程内递增，用于发现丢失的事件
+	ID           uint64      `json:"id"`
+	Type         string      `json:"type"`
+	ConnectionID string      `json:"connection_id,omitempty"`
+	Namespace    string      `json:"namespace,omitempty"`
+	Protocol     string      `json:"protocol,omitempty"`
+	Host         string      `json:"host,omitempty"`
+	RequestID    string      `json:"request_id,omitempty"`
+	Principal    string      `json:"principal,omitempty"`
+	Data         interface{} `json:"data,omitempty"`
+	Timestamp    time.Time   `json:"timestamp"`
+}
+
+type eventSubscriber struct {
+	ch      chan LifecycleEvent
+	dropped int64
+}
+
+// EventBus 发布时不阻塞，订阅者队列满时丢弃
+type EventBus struct {
+	seq         uint64
+	subscribers map[*eventSubscriber]struct{}
+	published   map[string]uint64
+	dropped     uint64
+	mutex       sync.Mutex
+}
+
+var events = &EventBus{subscribers: make(map[*eventSubscriber]struct{}), published: make(map[string]uint64)}
+
+func (b *EventBus) Publish(event LifecycleEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.seq++
+	event.ID = b.seq
+	b.published[event.Type]++
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+			b.dropped++
+		}
+	}
+}
+
+func (b *EventBus) Subscribe(buffer int) *eventSubscriber {
+	sub := &eventSubscriber{ch: make(chan LifecycleEvent, buffer)}
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+	return sub
+}
+
+func (b *EventBus) Unsubscribe(sub *eventSubscriber) {
+	b.mutex.Lock()
+	delete(b.subscribers, sub)
+	b.mutex.Unlock()
+}
+
+// Stats 各类型已发布的事件数、丢弃总数和订阅者数
+func (b *EventBus) Stats() (map[string]uint64, uint64, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	published := make(map[string]uint64, len(b.published))
+	for eventType, n := range b.published {
+		published[eventType] = n
+	}
+	return published, b.dropped, len(b.subscribers)
+}
+
+// publishConnectionEvent 不能在持有ConnectionManager锁时调用
+func publishConnectionEvent(eventType, connectionID string, info ConnectionInfo, namespace string, data map[string]interface{}) {
+	events.Publish(LifecycleEvent{
+		Type:         eventType,
+		ConnectionID: connectionID,
+		Namespace:    namespace,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		Data:         data,
+	})
+}
+
+// forwardEventsToSinks sinks的分发本身也是有界队列，这里的订阅者只在sinks分发变慢时丢弃
+func forwardEventsToSinks() {
+	if containsString(eventSinkTypes, "none") {
+		return
+	}
+	sub := events.Subscribe(eventSubscriberBuffer)
+	for event := range sub.ch {
+		if len(eventSinkTypes) == 0 || containsString(eventSinkTypes, event.Type) {
+			sinks.Publish("event", event)
+		}
+	}
+}
+
+func init() {
+	go forwardEventsToSinks()
+}
+
+// eventFilter GET /events 的查询参数
+type eventFilter struct {
+	namespace    string
+	connectionID string
+	types        []string
+}
+
+func (f eventFilter) match(p *Principal, event LifecycleEvent) bool {
+	switch {
+	case f.namespace != "" && event.Namespace != f.namespace:
+		return false
+	case f.connectionID != "" && event.ConnectionID != f.connectionID:
+		return false
+	case len(f.types) > 0 && !containsString(f.types, event.Type):
+		return false
+	case event.ConnectionID != "" && !connectionACLs.Allowed(p, event.ConnectionID):
+		return false
+	}
+	// 与连接无关的拒绝事件只推送给管理员和被拒绝的调用方
+	return event.Principal == "" || p == nil || p.Can(permAdmin) || p.Identity() == event.Principal
+}
+
+func registerEventRoutes(r *gin.Engine) {
+	r.GET("/events", func(c *gin.Context) {
+		filter := eventFilter{
+			namespace:    c.Query("namespace"),
+			connectionID: c.Query("connection_id"),
+			types:        splitList(c.Query("type")),
+		}
+		p := currentPrincipal(c)
+		sub := events.Subscribe(eventSubscriberBuffer)
+		defer events.Unsubscribe(sub)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Writer.Flush()
+
+		var reported int64
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case event := <-sub.ch:
+				if dropped := atomic.LoadInt64(&sub.dropped); dropped > reported {
+					c.SSEvent("dropped", gin.H{"dropped": dropped - reported, "total_dropped": dropped})
+					reported = dropped
+				}
+				if filter.match(p, event) {
+					c.SSEvent(event.Type, event)
+				}
+				return true
+			}
+		})
+	})
+}
+
+// publishCommandStarted 只含命令摘要，命令经过脱敏
+func publishCommandStarted(req CommandRequest, info ConnectionInfo, namespace string) {
+	events.Publish(LifecycleEvent{
+		Type:         eventCommandStarted,
+		ConnectionID: req.ConnectionID,
+		Namespace:    namespace,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		RequestID:    req.requestID,
+		Data:         map[string]interface{}{"command": redact(req.Command), "stream": req.stream != nil},
+	})
+}
+
+// publishCommandFinished 不含输出，只有耗时、退出码、错误类别和输出字节数
+func publishCommandFinished(req CommandRequest, info ConnectionInfo, namespace string, elapsed time.Duration, result *CommandResult, err error) {
+	data := map[string]interface{}{"command": redact(req.Command), "duration_ms": durationMS(elapsed), "success": err == nil}
+	if result != nil {
+		data["success"] = err == nil && result.Error == ""
+		data["result_id"], data["output_bytes"], data["truncated"] = result.ID, len(result.Output)+len(result.Stderr), result.Truncated
+		if result.ExitCode != nil {
+			data["exit_code"] = *result.ExitCode
+		}
+		if result.ErrorClass != "" {
+			data["error_class"] = result.ErrorClass
+		}
+	}
+	if err != nil {
+		data["error"], data["error_class"] = redact(err.Error()), errorClass(err)
+	}
+	events.Publish(LifecycleEvent{
+		Type:         eventCommandFinished,
+		ConnectionID: req.ConnectionID,
+		Namespace:    namespace,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		RequestID:    req.requestID,
+		Data:         data,
+	})
+}
+
+func publishPolicyDenied(req CommandRequest, info ConnectionInfo, namespace string, err error) {
+	events.Publish(LifecycleEvent{
+		Type:         eventPolicyDenied,
+		ConnectionID: req.ConnectionID,
+		Namespace:    namespace,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		RequestID:    req.requestID,
+		Data:         map[string]interface{}{"command": redact(req.Command), "reason": redact(err.Error())},
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 命令执行策略对所有协议（SSH、Telnet、WinRM）一致生效
+var (
+	commandTimeout   = time.Duration(envInt64("COMMAND_TIMEOUT", 300)) * time.Second
+	maxCommandOutput = envInt64("COMMAND_MAX_OUTPUT", 10<<20)
+
+	errCommandDenied  = errors.New("command denied by policy")
+	errCommandTimeout = errors.New("command timed out")
+)
+
+// CommandPolicy 从COMMAND_POLICY_FILE加载，格式: {"allow": ["^show "], "deny": ["reload"]}
+// allow为空时允许所有未被deny匹配的命令
+type CommandPolicy struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func loadCommandPolicy() *CommandPolicy {
+	policy := &CommandPolicy{}
+	file := os.Getenv("COMMAND_POLICY_FILE")
+	if file == "" {
+		return policy
+	}
+
+	var raw struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, &raw)
+	}
+	if err == nil {
+		policy.allow, err = compilePatterns(raw.Allow)
+	}
+	if err == nil {
+		policy.deny, err = compilePatterns(raw.Deny)
+	}
+	if err != nil {
+		// 策略文件有误时拒绝所有命令，避免意外放开
+		logError("command_policy.invalid", "failed to load COMMAND_POLICY_FILE, denying all commands", "error", err)
+		return &CommandPolicy{deny: []*regexp.Regexp{regexp.MustCompile("")}}
+	}
+	return policy
+}
+
+func (p *CommandPolicy) Check(command string) error {
+	for _, re := range p.deny {
+		if re.MatchString(command) {
+			return errCommandDenied
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, re := range p.allow {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	return errCommandDenied
+}
+
+var commandPolicy = loadCommandPolicy()
+
+// cappedBuffer 超过上限后丢弃后续输出并标记截断，stdout/stderr可并发写入
+type cappedBuffer struct {
+	data      []byte
+	limit     int64
+	truncated bool
+	mutex     sync.Mutex
+}
+
+func newCappedBuffer(limit int64) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	remaining := b.limit - int64(len(b.data))
+	if b.limit > 0 && int64(len(p)) > remaining {
+		if remaining > 0 {
+			b.data = append(b.data, p[:remaining]...)
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return string(b.data)
+}
+
+func (b *cappedBuffer) Truncated() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.truncated
+}
+
+// waitCommand 等待命令结束，超时或ctx取消后调用kill终止
+func waitCommand(ctx context.Context, wait func() error, kill func(), timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- wait() }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		kill()
+		return errCommandTimeout
+	case <-ctx.Done():
+		kill()
+		return ctx.Err()
+	}
+}
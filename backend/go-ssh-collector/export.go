@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmespath/go-jmespath"
+)
+
+// 结果导出：GET /results/export 使用与 GET /results 相同的过滤条件，以NDJSON（默认）或CSV流式输出，
+// 逐行读取数据库游标，不在内存中保留整个结果集。columns 把parsed中的值展开为列，
+// 格式为 名称=JMESPath 或直接写JMESPath（列名即表达式），多个以逗号分隔。
+// 同步导出最多 RESULT_EXPORT_MAX_ROWS 行，超出时截断并在 X-Export-Truncated 尾部头中标记。
+// async=true 时作为任务执行，最多 RESULT_EXPORT_ASYNC_MAX_ROWS 行，文件写入 RESULT_EXPORT_DIR，
+// 任务结果中给出下载地址；元数据记录导出参数，便于重现。产物保留 RESULT_EXPORT_TTL 秒
+
+var (
+	resultExportMaxRows      = int(envInt64("RESULT_EXPORT_MAX_ROWS", 100000))
+	resultExportAsyncMaxRows = int(envInt64("RESULT_EXPORT_ASYNC_MAX_ROWS", 5000000))
+	resultExportDir          = getEnv("RESULT_EXPORT_DIR", filepath.Join(os.TempDir(), "collector-exports"))
+	resultExportTTL          = time.Duration(envInt64("RESULT_EXPORT_TTL", 86400)) * time.Second
+
+	errExportNotFound = errors.New("export not found")
+)
+
+const (
+	exportNDJSON = "ndjson"
+	exportCSV    = "csv"
+)
+
+// exportColumn 从parsed展开的一列
+type exportColumn struct {
+	name       string
+	expression *jmespath.JMESPath
+}
+
+func parseExportColumns(spec string) ([]exportColumn, error) {
+	var columns []exportColumn
+	for _, item := range splitList(spec) {
+		name, expression, found := strings.Cut(item, "=")
+		if !found {
+			expression = name
+		}
+		compiled, err := transforms.Compile(strings.TrimSpace(expression))
+		if err != nil {
+			return nil, fmt.Errorf("invalid column %s: %v", item, err)
+		}
+		columns = append(columns, exportColumn{strings.TrimSpace(name), compiled})
+	}
+	return columns, nil
+}
+
+// ExportArtifact 异步导出的产物，Params为导出时的查询参数
+type ExportArtifact struct {
+	ID          string            `json:"id"`
+	Format      string            `json:"format"`
+	Params      map[string]string `json:"params"`
+	Rows        int               `json:"rows"`
+	Bytes       int64             `json:"bytes"`
+	SHA256      string            `json:"sha256"`
+	Truncated   bool              `json:"truncated"`
+	Principal   string            `json:"principal,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	DownloadURL string            `json:"download_url"`
+}
+
+// exportRequest 一次导出的全部参数，同步和异步共用
+type exportRequest struct {
+	query   ResultQuery
+	fields  []string
+	columns []exportColumn
+	format  string
+	csv     csvOptions
+	params  map[string]string
+}
+
+func parseExportRequest(c *gin.Context) (exportRequest, error) {
+	req := exportRequest{format: exportNDJSON, params: map[string]string{}}
+	for key, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			req.params[key] = values[0]
+		}
+	}
+	switch format := c.DefaultQuery("format", exportNDJSON); format {
+	case exportNDJSON, exportCSV:
+		req.format = format
+	default:
+		return req, fmt.Errorf("format must be ndjson or csv")
+	}
+	var err error
+	if req.csv, err = parseCSVOptions(c); err != nil {
+		return req, err
+	}
+	if req.columns, err = parseExportColumns(c.Query("columns")); err != nil {
+		return req, err
+	}
+	if req.query, err = parseResultQuery(c.Query); err != nil {
+		return req, err
+	}
+	req.fields = req.query.Columns
+	// 展开列需要parsed，未选择时额外查询，输出时省略
+	if len(req.columns) > 0 && !containsString(req.query.Columns, "parsed") {
+		req.query.Columns = append(append([]string(nil), req.query.Columns...), "parsed")
+	}
+	// 与 GET /results 相同，按每条结果保存的属主过滤
+	req.query.Viewer = resultViewer(currentPrincipal(c))
+	return req, nil
+}
+
+// exportCell CSV中的时间使用RFC3339
+func exportCell(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return csvCell(value)
+}
+
+// exportRowWriter 逐行写出，header只写一次
+type exportRowWriter struct {
+	w      io.Writer
+	req    exportRequest
+	csv    *csv.Writer
+	header []string
+}
+
+func newExportRowWriter(w io.Writer, req exportRequest) (*exportRowWriter, error) {
+	ew := &exportRowWriter{w: w, req: req}
+	if req.format != exportCSV {
+		return ew, nil
+	}
+	ew.header = append([]string(nil), req.fields...)
+	for _, column := range req.columns {
+		ew.header = append(ew.header, column.name)
+	}
+	if !req.csv.quoteAll {
+		ew.csv = csv.NewWriter(w)
+		ew.csv.Comma = req.csv.delimiter
+		ew.csv.UseCRLF = true
+	}
+	if req.csv.bom {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return nil, err
+		}
+	}
+	return ew, ew.writeCSV(ew.header)
+}
+
+func (ew *exportRowWriter) writeCSV(record []string) error {
+	if ew.csv != nil {
+		return ew.csv.Write(record)
+	}
+	quoted := make([]string, len(record))
+	for i, field := range record {
+		quoted[i] = quoteCSVField(field)
+	}
+	_, err := io.WriteString(ew.w, strings.Join(quoted, string(ew.req.csv.delimiter))+"\r\n")
+	return err
+}
+
+func (ew *exportRowWriter) Write(row map[string]interface{}) error {
+	expanded := make(map[string]interface{}, len(ew.req.columns))
+	if len(ew.req.columns) > 0 {
+		var document interface{}
+		if parsed, ok := row["parsed"].(json.RawMessage); ok {
+			json.Unmarshal(parsed, &document)
+		}
+		for _, column := range ew.req.columns {
+			value, _ := column.expression.Search(document)
+			expanded[column.name] = value
+		}
+	}
+	if ew.req.format == exportCSV {
+		record := make([]string, 0, len(ew.header))
+		for _, name := range ew.req.fields {
+			record = append(record, exportCell(row[name]))
+		}
+		for _, column := range ew.req.columns {
+			record = append(record, exportCell(expanded[column.name]))
+		}
+		return ew.writeCSV(record)
+	}
+	out := make(map[string]interface{}, len(ew.req.fields)+1)
+	for _, name := range ew.req.fields {
+		if value, ok := row[name]; ok {
+			out[name] = value
+		}
+	}
+	if len(expanded) > 0 {
+		out["columns"] = expanded
+	}
+	line, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = ew.w.Write(append(line, '\n'))
+	return err
+}
+
+func (ew *exportRowWriter) Flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		return ew.csv.Error()
+	}
+	return nil
+}
+
+// Export 按游标逐行写出，最多maxRows行，返回写出的行数和是否截断
+func (s *ResultStore) Export(ctx context.Context, w io.Writer, req exportRequest, maxRows int, flush func()) (int, bool, error) {
+	q := req.query
+	q.Offset, q.PageSize = 0, maxRows
+	query, args := q.sql(s.driver)
+	rows, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+	ew, err := newExportRowWriter(w, req)
+	if err != nil {
+		return 0, false, err
+	}
+	written, scanned, truncated := 0, 0, false
+	for rows.Next() {
+		if scanned++; scanned > maxRows {
+			truncated = true
+			break
+		}
+		row, err := scanResultRow(rows, q.Columns)
+		if err != nil {
+			return written, false, err
+		}
+		if err := ew.Write(row); err != nil {
+			return written, false, err
+		}
+		if written++; flush != nil && written%1000 == 0 {
+			ew.Flush()
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return written, false, err
+	}
+	return written, truncated, ew.Flush()
+}
+
+func exportContentType(format string) string {
+	if format == exportCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+func exportPath(id, suffix string) string {
+	return filepath.Join(resultExportDir, id+suffix)
+}
+
+// runAsyncExport 先写临时文件，完成后改名，元数据最后写入
+func runAsyncExport(ctx context.Context, id string, req exportRequest, artifact *ExportArtifact) (*ExportArtifact, error) {
+	cleanupExports(time.Now())
+	if err := os.MkdirAll(resultExportDir, 0700); err != nil {
+		return nil, err
+	}
+	data := exportPath(id, "."+req.format)
+	file, err := os.OpenFile(data+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	var size int64
+	rows, truncated, err := resultStore.Export(ctx, countingWriter{io.MultiWriter(file, hash), &size}, req, resultExportAsyncMaxRows, nil)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(data+".tmp", data)
+	}
+	if err != nil {
+		os.Remove(data + ".tmp")
+		return nil, err
+	}
+	artifact.Rows, artifact.Truncated, artifact.Bytes = rows, truncated, size
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	metadata, err := json.Marshal(artifact)
+	if err == nil {
+		err = os.WriteFile(exportPath(id, ".json"), metadata, 0600)
+	}
+	if err != nil {
+		os.Remove(data)
+		return nil, err
+	}
+	return artifact, nil
+}
+
+func loadExportArtifact(id string) (*ExportArtifact, error) {
+	if !templateNamePattern.MatchString(id) {
+		return nil, errExportNotFound
+	}
+	data, err := os.ReadFile(exportPath(id, ".json"))
+	if err != nil {
+		return nil, errExportNotFound
+	}
+	var artifact ExportArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return nil, err
+	}
+	if time.Now().After(artifact.ExpiresAt) {
+		return nil, errExportNotFound
+	}
+	return &artifact, nil
+}
+
+// cleanupExports 删除过期的产物，每次异步导出开始时调用
+func cleanupExports(now time.Time) {
+	matches, _ := filepath.Glob(exportPath("*", ".json"))
+	for _, path := range matches {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		data, err := os.ReadFile(path)
+		var artifact ExportArtifact
+		if err != nil || json.Unmarshal(data, &artifact) != nil || now.After(artifact.ExpiresAt) {
+			os.Remove(exportPath(id, "."+exportNDJSON))
+			os.Remove(exportPath(id, "."+exportCSV))
+			os.Remove(path)
+		}
+	}
+}
+
+func registerExportRoutes(r *gin.Engine) {
+	r.GET("/results/export", func(c *gin.Context) {
+		if resultStore == nil {
+			respondError(c, http.StatusServiceUnavailable, errResultStoreDisabled)
+			return
+		}
+		req, err := parseExportRequest(c)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if c.Query("async") == "true" {
+			id := newID()
+			now := time.Now()
+			artifact := &ExportArtifact{
+				ID:          id,
+				Format:      req.format,
+				Params:      req.params,
+				Principal:   principalIdentity(currentPrincipal(c)),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(resultExportTTL),
+				DownloadURL: "/results/exports/" + id,
+			}
+			jobID := jobs.Submit(c.Request.Context(), "result_export", "", nil, func(ctx context.Context) (interface{}, error) {
+				return runAsyncExport(ctx, id, req, artifact)
+			})
+			c.JSON(http.StatusAccepted, gin.H{
+				"job_id":       jobID,
+				"export_id":    id,
+				"download_url": artifact.DownloadURL,
+				"status":       JobPending,
+				"timestamp":    now,
+			})
+			return
+		}
+
+		c.Header("Content-Type", exportContentType(req.format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="results-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), req.format))
+		c.Header("Trailer", "X-Export-Rows, X-Export-Truncated")
+		c.Status(http.StatusOK)
+		rows, truncated, err := resultStore.Export(c.Request.Context(), c.Writer, req, resultExportMaxRows, c.Writer.Flush)
+		if err != nil {
+			// 已开始输出，只能记录并中断
+			logWarn("result_export", "result export failed", append(requestLogFields(c), "rows", rows, "error", redact(err.Error()))...)
+			return
+		}
+		c.Writer.Header().Set("X-Export-Rows", strconv.Itoa(rows))
+		c.Writer.Header().Set("X-Export-Truncated", strconv.FormatBool(truncated))
+	})
+
+	r.GET("/results/exports/:id", func(c *gin.Context) {
+		artifact, err := loadExportArtifact(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if p := currentPrincipal(c); p != nil && !p.Can(permAdmin) && artifact.Principal != p.Identity() {
+			denyRequest(c, p, permRead, "export belongs to another caller")
+			return
+		}
+		c.Header("X-Export-Rows", strconv.Itoa(artifact.Rows))
+		c.Header("X-Export-Truncated", strconv.FormatBool(artifact.Truncated))
+		c.Header("X-Export-SHA256", artifact.SHA256)
+		c.Header("Content-Type", exportContentType(artifact.Format))
+		c.FileAttachment(exportPath(artifact.ID, "."+artifact.Format), "results-"+artifact.ID+"."+artifact.Format)
+	})
+
+	r.GET("/results/exports/:id/metadata", func(c *gin.Context) {
+		artifact, err := loadExportArtifact(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if p := currentPrincipal(c); p != nil && !p.Can(permAdmin) && artifact.Principal != p.Identity() {
+			denyRequest(c, p, permRead, "export belongs to another caller")
+			return
+		}
+		c.JSON(http.StatusOK, artifact)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 未配置MAX_TRANSFER_BYTES时URL拉取的默认上限
+const defaultFetchMaxBytes = 4 << 30
+
+type FetchRequest struct {
+	URL       string `json:"url" binding:"required"`
+	DestPath  string `json:"dest_path" binding:"required"`
+	SHA256    string `json:"sha256"`
+	MaxBytes  int64  `json:"max_bytes"`
+	RateLimit int64  `json:"rate_limit"`
+}
+
+type FetchResult struct {
+	URL       string    `json:"url"`
+	DestPath  string    `json:"dest_path"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Duration  float64   `json:"duration"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           safeDialer(30 * time.Second).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+	},
+}
+
+func fetchLimit(requested int64) int64 {
+	limit := maxTransferBytes
+	if limit <= 0 {
+		limit = defaultFetchMaxBytes
+	}
+	if requested > 0 && requested < limit {
+		return requested
+	}
+	return limit
+}
+
+// Fetch 从URL下载文件并直接流式写入目标设备，不在本地落盘
+func (cm *ConnectionManager) Fetch(ctx context.Context, connectionID string, req FetchRequest, progress *TransferProgress) (*FetchResult, error) {
+	conn, err := cm.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := conn.SFTP()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := fetchLimit(req.MaxBytes)
+	start := time.Now()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	resp, err := fetchClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch url: unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w: %d > %d bytes", errTransferTooLarge, resp.ContentLength, limit)
+	}
+	if progress != nil && resp.ContentLength > 0 {
+		progress.SetTotal(resp.ContentLength)
+	}
+
+	file, err := client.OpenFile(req.DestPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote file: %v", err)
+	}
+
+	// 多读1字节用于判断是否超出上限
+	h := sha256.New()
+	written, err := copyStream(ctx, file, io.TeeReader(io.LimitReader(resp.Body, limit+1), h), copyOptions{
+		RateLimit: effectiveRate(req.RateLimit),
+		Progress:  progress,
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > limit {
+		err = fmt.Errorf("%w: more than %d bytes", errTransferTooLarge, limit)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if err == nil && req.SHA256 != "" && !strings.EqualFold(sum, req.SHA256) {
+		err = fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, req.SHA256, sum)
+	}
+	if err != nil {
+		client.Remove(req.DestPath)
+		return nil, err
+	}
+
+	return &FetchResult{
+		URL:       req.URL,
+		DestPath:  req.DestPath,
+		Size:      written,
+		SHA256:    sum,
+		Duration:  time.Since(start).Seconds(),
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func registerFetchRoutes(r *gin.Engine) {
+	// 从URL拉取文件到目标设备（异步任务）
+	r.POST("/connections/:id/files/fetch", func(c *gin.Context) {
+		connectionID := c.Param("id")
+
+		var req FetchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			respondError(c, http.StatusBadRequest, errors.New("url must be http or https"))
+			return
+		}
+		if _, err := collector.getConnection(connectionID); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+
+		progress := &TransferProgress{}
+		jobID := jobs.Submit(c.Request.Context(), "fetch", connectionID, progress, func(ctx context.Context) (interface{}, error) {
+			return collector.Fetch(ctx, connectionID, req, progress)
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":    jobID,
+			"status":    JobPending,
+			"timestamp": time.Now(),
+		})
+	})
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// errRemoteFileOpen SFTP打开远端文件失败，不存在或无权限之外的原因按上游错误处理
+var errRemoteFileOpen = errors.New("failed to open remote file")
+
+type ChecksumResult struct {
+	Path      string    `json:"path"`
+	Algorithm string    `json:"algorithm"`
+	Checksum  string    `json:"checksum"`
+	Method    string    `json:"method"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// 各校验算法对应的远端命令
+var checksumCommands = map[string]string{
+	"sha256": "sha256sum",
+	"sha1":   "sha1sum",
+	"md5":    "md5sum",
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+}
+
+// shellQuote 用单引号包裹参数，供远端shell执行
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// attachmentHeader 下载的Content-Disposition，文件名中的引号、分号和非ASCII字符按RFC 2183/2231编码
+func attachmentHeader(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+// getConnection 返回SSH连接，SFTP等功能仅支持SSH协议
+func (cm *ConnectionManager) getConnection(connectionID string) (*SSHConnection, error) {
+	conn, err := cm.get(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, ok := conn.(*SSHConnection)
+	if !ok {
+		return nil, errNotSSHConnection
+	}
+	return sshConn, nil
+}
+
+// SFTP 返回连接上复用的SFTP客户端，首次调用时创建
+func (conn *SSHConnection) SFTP() (*sftp.Client, error) {
+	conn.sftpMutex.Lock()
+	defer conn.sftpMutex.Unlock()
+
+	if conn.sftpClient != nil {
+		return conn.sftpClient, nil
+	}
+
+	client, err := sftp.NewClient(conn.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sftp: %v", err)
+	}
+	conn.sftpClient = client
+	return client, nil
+}
+
+func (conn *SSHConnection) closeSFTP() {
+	conn.sftpMutex.Lock()
+	defer conn.sftpMutex.Unlock()
+
+	if conn.sftpClient != nil {
+		conn.sftpClient.Close()
+		conn.sftpClient = nil
+	}
+}
+
+// Checksum 计算远端文件校验值，优先在远端执行 sha256sum/md5sum，
+// 命令不可用时通过SFTP读取文件在本地计算
+func (cm *ConnectionManager) Checksum(connectionID, remotePath, algo string) (*ChecksumResult, error) {
+	conn, err := cm.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if algo == "" {
+		algo = "sha256"
+	}
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ChecksumResult{
+		Path:      remotePath,
+		Algorithm: algo,
+	}
+
+	command := checksumCommands[algo] + " -- " + shellQuote(remotePath)
+	sum, err := remoteChecksum(conn, command, h.Size()*2)
+	if err == nil {
+		result.Checksum = sum
+		result.Method = "remote"
+		result.Timestamp = time.Now()
+		return result, nil
+	}
+	if !errors.Is(err, errRemoteToolUnavailable) {
+		return nil, err
+	}
+
+	// 回退：通过SFTP流式读取并在本地计算
+	client, err := conn.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, fmt.Errorf("failed to read remote file: %v", err)
+	}
+
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	result.Method = "sftp"
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+var errRemoteToolUnavailable = errors.New("remote checksum tool unavailable")
+
+func remoteChecksum(conn *SSHConnection, command string, hexLen int) (string, error) {
+	session, err := conn.Client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", withErrorClass(classSessionOpenFailed, err))
+	}
+	defer session.Close()
+	defer trackSession(sessionChecksum)()
+
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() != 127 {
+			return "", fmt.Errorf("checksum command failed: %s", strings.TrimSpace(string(output)))
+		}
+		return "", errRemoteToolUnavailable
+	}
+
+	// 输出格式: "<hash>  <path>"，非预期输出（如网络设备的报错）视为工具不可用
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 || len(fields[0]) != hexLen {
+		return "", errRemoteToolUnavailable
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", errRemoteToolUnavailable
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// verifyChecksum 校验远端文件的sha256是否与期望值一致
+func (cm *ConnectionManager) verifyChecksum(connectionID, remotePath, expected string) (*ChecksumResult, error) {
+	result, err := cm.Checksum(connectionID, remotePath, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(result.Checksum, expected) {
+		return result, fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, expected, result.Checksum)
+	}
+	return result, nil
+}
+
+type UploadResult struct {
+	Path           string          `json:"path"`
+	Size           int64           `json:"size"`
+	SHA256         string          `json:"sha256"`
+	ResumedFrom    int64           `json:"resumed_from,omitempty"`
+	PrefixVerified bool            `json:"prefix_verified,omitempty"`
+	Verified       *ChecksumResult `json:"verified,omitempty"`
+	Timestamp      time.Time       `json:"timestamp"`
+}
+
+type UploadOptions struct {
+	ExpectedSHA256 string
+	// Resume 从远端已有文件的末尾续传，src需为完整的源文件
+	Resume       bool
+	VerifyPrefix bool
+	RateLimit    int64
+	Progress     *TransferProgress
+}
+
+// remotePrefixChecksum 计算远端文件前n字节的sha256
+func remotePrefixChecksum(conn *SSHConnection, remotePath string, n int64) (string, error) {
+	// 管道的退出码只反映sha256sum，head失败时会得到空输入的摘要；
+	// 不依赖pipefail（dash等不支持），经fd4单独带回head的退出码
+	script := fmt.Sprintf(`exec 3>&1; rc=$( { { head -c %d -- %s; echo $? >&4; } | sha256sum >&3; } 4>&1 ) || exit; [ "$rc" = 0 ] || exit "$rc"`,
+		n, shellQuote(remotePath))
+	command := "sh -c " + shellQuote(script)
+	sum, err := remoteChecksum(conn, command, sha256.Size*2)
+	if !errors.Is(err, errRemoteToolUnavailable) {
+		return sum, err
+	}
+
+	client, err := conn.SFTP()
+	if err != nil {
+		return "", err
+	}
+	file, err := client.Open(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open remote file: %v", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, file, n); err != nil {
+		return "", fmt.Errorf("failed to read remote file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (cm *ConnectionManager) Upload(ctx context.Context, connectionID, remotePath string, src io.ReadSeeker, size int64, opts UploadOptions) (result *UploadResult, err error) {
+	conn, err := cm.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := startSpan(ctx, "sftp.upload", "connection_id", connectionID, "net.peer.name", conn.Info().Host,
+		"sftp.path", remotePath, "sftp.size", size)
+	defer func() {
+		if result != nil {
+			span.SetAttributes("sftp.bytes", result.Size-result.ResumedFrom, "sftp.resumed_from", result.ResumedFrom)
+		}
+		span.End(err)
+	}()
+	client, err := conn.SFTP()
+	if err != nil {
+		return nil, err
+	}
+
+	result = &UploadResult{Path: remotePath}
+	h := sha256.New()
+
+	// 续传：以远端已有大小为偏移，源文件的对应前缀只参与哈希计算
+	var offset int64
+	if opts.Resume {
+		if info, err := client.Stat(remotePath); err == nil && info.Size() > 0 && info.Size() <= size {
+			offset = info.Size()
+		}
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(h, src, offset); err != nil {
+			return nil, fmt.Errorf("failed to read source: %v", err)
+		}
+		if opts.VerifyPrefix {
+			remoteSum, err := remotePrefixChecksum(conn, remotePath, offset)
+			if err != nil {
+				return nil, err
+			}
+			if remoteSum == hex.EncodeToString(h.Sum(nil)) {
+				result.PrefixVerified = true
+			} else {
+				// 已传输部分与源文件不一致，从头重传
+				offset = 0
+				h.Reset()
+				if _, err := src.Seek(0, io.SeekStart); err != nil {
+					return nil, fmt.Errorf("failed to rewind source: %v", err)
+				}
+			}
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY
+	}
+	file, err := client.OpenFile(remotePath, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote file: %v", err)
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek remote file: %v", err)
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress.SetTotal(size)
+		opts.Progress.SetResumedFrom(offset)
+		opts.Progress.Add(offset)
+	}
+
+	written, err := copyStream(ctx, file, io.TeeReader(src, h), copyOptions{
+		RateLimit: effectiveRate(opts.RateLimit),
+		Progress:  opts.Progress,
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write remote file: %v", err)
+	}
+
+	result.Size = offset + written
+	result.ResumedFrom = offset
+	result.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	// 上传后校验落盘内容，不一致时删除远端文件
+	if opts.ExpectedSHA256 != "" {
+		verified, err := cm.verifyChecksum(connectionID, remotePath, opts.ExpectedSHA256)
+		if err != nil {
+			client.Remove(remotePath)
+			return nil, err
+		}
+		result.Verified = verified
+	}
+
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange 解析单个 "bytes=start-end" 区间，返回闭区间的起止偏移
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, errRangeNotSatisfiable
+	}
+
+	var start, end int64
+	switch {
+	case startStr == "":
+		// 后缀区间: bytes=-N
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+	default:
+		n, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || n < 0 || n >= size {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		start, end = n, size-1
+		if endStr != "" {
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < start {
+				return 0, 0, errRangeNotSatisfiable
+			}
+			if n < end {
+				end = n
+			}
+		}
+	}
+	return start, end, nil
+}
+
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errChecksumMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errConnectionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotSSHConnection), errors.Is(err, errSyncOutsideRoot):
+		return http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, errRemoteFileOpen):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+func registerFileRoutes(r *gin.Engine) {
+	// 远端文件校验值
+	r.GET("/connections/:id/files/checksum", func(c *gin.Context) {
+		remotePath := c.Query("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+
+		result, err := collector.Checksum(c.Param("id"), remotePath, c.DefaultQuery("algo", "sha256"))
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	// 下载文件，按字节原样传输，不应用脱敏规则
+	r.GET("/connections/:id/files/download", func(c *gin.Context) {
+		connectionID := c.Param("id")
+		remotePath := c.Query("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+
+		if expected := c.Query("expected_sha256"); expected != "" {
+			if _, err := collector.verifyChecksum(connectionID, remotePath, expected); err != nil {
+				respondError(c, fileErrorStatus(err), err)
+				return
+			}
+			c.Header("X-Checksum-Sha256", strings.ToLower(expected))
+		}
+
+		conn, err := collector.getConnection(connectionID)
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+		client, err := conn.SFTP()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		file, err := client.Open(remotePath)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", errRemoteFileOpen, err)
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, fmt.Errorf("failed to stat remote file: %w", err))
+			return
+		}
+
+		rateLimit, _ := strconv.ParseInt(c.Query("rate_limit"), 10, 64)
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("Content-Disposition", attachmentHeader(path.Base(remotePath)))
+
+		status := http.StatusOK
+		start, length := int64(0), info.Size()
+
+		// 断点续传：按Range定位到远端文件偏移
+		if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+			rangeStart, rangeEnd, err := parseRange(rangeHeader, info.Size())
+			if err != nil {
+				c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+				respondError(c, http.StatusRequestedRangeNotSatisfiable, err)
+				return
+			}
+			if _, err := file.Seek(rangeStart, io.SeekStart); err != nil {
+				respondError(c, http.StatusInternalServerError, fmt.Errorf("failed to seek remote file: %w", err))
+				return
+			}
+
+			status = http.StatusPartialContent
+			start, length = rangeStart, rangeEnd-rangeStart+1
+			c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart, rangeEnd, info.Size()))
+		}
+
+		if maxTransferBytes > 0 && length > maxTransferBytes {
+			respondError(c, http.StatusRequestEntityTooLarge, errTransferTooLarge)
+			return
+		}
+
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Length", strconv.FormatInt(length, 10))
+		c.Status(status)
+
+		// 客户端断开时请求上下文被取消，远端读取出错时直接中止响应
+		ctx, span := startSpan(c.Request.Context(), "sftp.download", "connection_id", connectionID,
+			"net.peer.name", conn.Info().Host, "sftp.path", remotePath, "sftp.offset", start)
+		written, err := copyStream(ctx, c.Writer, io.LimitReader(file, length), copyOptions{
+			RateLimit: effectiveRate(rateLimit),
+		})
+		span.SetAttributes("sftp.bytes", written)
+		span.End(err)
+		if err != nil {
+			logWarn("sftp.download_aborted", "download aborted", "connection_id", connectionID, "path", remotePath, "offset", start+written, "error", err)
+			c.Abort()
+		}
+	})
+
+	// 读取远端文件并按parse选项解析（配置文件等），与命令输出一样先脱敏再解析
+	r.POST("/connections/:id/files/parse", func(c *gin.Context) {
+		var req struct {
+			Path  string        `json:"path" binding:"required"`
+			Parse *ParseOptions `json:"parse" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		conn, err := collector.getConnection(c.Param("id"))
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+		client, err := conn.SFTP()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		file, err := client.Open(req.Path)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", errRemoteFileOpen, err)
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxCommandOutput+1))
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, fmt.Errorf("failed to read remote file: %w", err))
+			return
+		}
+		if int64(len(data)) > maxCommandOutput {
+			respondError(c, http.StatusRequestEntityTooLarge, errTransferTooLarge)
+			return
+		}
+
+		response := gin.H{"path": req.Path, "timestamp": time.Now()}
+		out, err := parseOutput(masker.Mask(conn.Info().DeviceType, string(data)), req.Parse)
+		if err != nil {
+			response["parse_error"] = err.Error()
+		} else {
+			response["parsed"] = out.Parsed
+			if len(out.Unparsed) > 0 {
+				response["unparsed"] = out.Unparsed
+			}
+			if out.UnparsedCount > 0 {
+				response["unparsed_count"] = out.UnparsedCount
+			}
+			if out.Schema != "" {
+				response["parse_schema"] = out.Schema
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	})
+
+	// 上传文件 (multipart: file, path, expected_sha256, resume, verify_prefix, async)
+	r.POST("/connections/:id/files/upload", func(c *gin.Context) {
+		connectionID := c.Param("id")
+		remotePath := c.PostForm("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+		header, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		src, err := header.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		defer src.Close()
+
+		opts := UploadOptions{
+			ExpectedSHA256: c.PostForm("expected_sha256"),
+			Resume:         c.PostForm("resume") == "true",
+			VerifyPrefix:   c.PostForm("verify_prefix") == "true",
+		}
+		opts.RateLimit, _ = strconv.ParseInt(c.PostForm("rate_limit"), 10, 64)
+
+		// 异步任务：请求结束后multipart临时文件会被清理，先落到本地临时文件
+		if c.PostForm("async") == "true" {
+			staged, err := os.CreateTemp("", "upload-*")
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, err)
+				return
+			}
+			spooled, err := io.Copy(staged, src)
+			spoolAdd(spooled)
+			if err != nil {
+				staged.Close()
+				os.Remove(staged.Name())
+				spoolAdd(-spooled)
+				respondError(c, http.StatusInternalServerError, err)
+				return
+			}
+
+			opts.Progress = &TransferProgress{}
+			jobID := jobs.Submit(c.Request.Context(), "upload", connectionID, opts.Progress, func(ctx context.Context) (interface{}, error) {
+				defer spoolAdd(-spooled)
+				defer os.Remove(staged.Name())
+				defer staged.Close()
+
+				if _, err := staged.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return collector.Upload(ctx, connectionID, remotePath, staged, header.Size, opts)
+			})
+
+			c.JSON(http.StatusAccepted, gin.H{
+				"job_id":    jobID,
+				"status":    JobPending,
+				"timestamp": time.Now(),
+			})
+			return
+		}
+
+		result, err := collector.Upload(c.Request.Context(), connectionID, remotePath, src, header.Size, opts)
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// interruptingReader 读到after字节后执行interrupt并返回错误，模拟传输中途断线
+type interruptingReader struct {
+	r         io.ReadSeeker
+	after     int64
+	read      int64
+	interrupt func()
+}
+
+func (r *interruptingReader) Read(p []byte) (int, error) {
+	if r.read >= r.after {
+		r.interrupt()
+		return 0, errors.New("link dropped")
+	}
+	if remaining := r.after - r.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *interruptingReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func TestUploadResumesAfterInterruption(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "bundle.tar")
+	payload := testPayload(3*transferBufferSize + 1234)
+
+	// 第一次上传在传输到一半时断开SSH连接
+	src := &interruptingReader{r: bytes.NewReader(payload), after: int64(len(payload) / 2), interrupt: server.DropConnections}
+	if _, err := collector.Upload(context.Background(), id, remotePath, src, int64(len(payload)), UploadOptions{}); err == nil {
+		t.Fatal("interrupted upload succeeded")
+	}
+	partial, err := os.ReadFile(remotePath)
+	if err != nil {
+		t.Fatalf("partial file: %v", err)
+	}
+	if len(partial) == 0 || len(partial) >= len(payload) || !bytes.Equal(partial, payload[:len(partial)]) {
+		t.Fatalf("partial file has %d bytes, want a prefix of %d", len(partial), len(payload))
+	}
+
+	// 重连后续传，只发送剩余部分
+	id = connectTestSSH(t, server)
+	progress := &TransferProgress{}
+	result, err := collector.Upload(context.Background(), id, remotePath, bytes.NewReader(payload), int64(len(payload)),
+		UploadOptions{Resume: true, VerifyPrefix: true, Progress: progress})
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if result.ResumedFrom != int64(len(partial)) || !result.PrefixVerified {
+		t.Fatalf("resumed_from = %d prefix_verified = %v, want %d true", result.ResumedFrom, result.PrefixVerified, len(partial))
+	}
+	sum := sha256.Sum256(payload)
+	if result.SHA256 != hex.EncodeToString(sum[:]) || result.Size != int64(len(payload)) {
+		t.Fatalf("result = %+v", result)
+	}
+	got, _ := os.ReadFile(remotePath)
+	if !bytes.Equal(got, payload) {
+		t.Fatal("remote file differs from source after resume")
+	}
+	snapshot := progress.Snapshot()
+	if snapshot["bytes_done"] != int64(len(payload)) || snapshot["bytes_transferred"] != int64(len(payload)-len(partial)) {
+		t.Fatalf("progress = %v", snapshot)
+	}
+}
+
+func TestUploadResumeRestartsWhenPrefixDiffers(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "bundle.tar")
+	payload := testPayload(2 * transferBufferSize)
+	if err := os.WriteFile(remotePath, bytes.Repeat([]byte{'x'}, 1000), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := collector.Upload(context.Background(), id, remotePath, bytes.NewReader(payload), int64(len(payload)),
+		UploadOptions{Resume: true, VerifyPrefix: true})
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if result.ResumedFrom != 0 || result.PrefixVerified {
+		t.Fatalf("resumed over a mismatched prefix: %+v", result)
+	}
+	if got, _ := os.ReadFile(remotePath); !bytes.Equal(got, payload) {
+		t.Fatal("remote file differs from source")
+	}
+}
+
+// 远端head失败时前缀校验报错，而不是返回空输入的摘要
+func TestRemotePrefixChecksumFailsWhenHeadFails(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.exec = func(command string, stdout io.Writer) int {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdout, cmd.Stderr = stdout, stdout
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return exitErr.ExitCode()
+			}
+			return 127
+		}
+		return 0
+	}
+	id := connectTestSSH(t, server)
+	sshConn, err := collector.getConnection(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	remotePath := filepath.Join(dir, "it's a bundle.tar")
+	payload := testPayload(4096)
+	if err := os.WriteFile(remotePath, payload, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := remotePrefixChecksum(sshConn, remotePath, 1000)
+	want := sha256.Sum256(payload[:1000])
+	if err != nil || sum != hex.EncodeToString(want[:]) {
+		t.Fatalf("prefix checksum = %q, %v; want %x", sum, err, want)
+	}
+
+	if sum, err := remotePrefixChecksum(sshConn, filepath.Join(dir, "missing.tar"), 1000); err == nil {
+		t.Fatalf("missing file: checksum %q, want an error", sum)
+	}
+}
+
+// 下载中断后客户端用Range从已收到的偏移继续
+func TestDownloadRangeContinuesAtOffset(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "capture.pcap")
+	payload := testPayload(5*transferBufferSize + 17)
+	if err := os.WriteFile(remotePath, payload, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	registerFileRoutes(r)
+
+	offset := int64(len(payload)) / 3
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path="+remotePath, nil)
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if want := "bytes " + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(int64(len(payload))-1, 10) + "/" + strconv.FormatInt(int64(len(payload)), 10); w.Header().Get("Content-Range") != want {
+		t.Fatalf("Content-Range = %q, want %q", w.Header().Get("Content-Range"), want)
+	}
+	if !bytes.Equal(w.Body.Bytes(), payload[offset:]) {
+		t.Fatalf("body has %d bytes, want the %d bytes after the offset", w.Body.Len(), len(payload)-int(offset))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path="+remotePath, nil)
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(int64(len(payload)), 10)+"-")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("range past the end: status = %d", w.Code)
+	}
+}
+
+// 文件名中的引号、分号和非ASCII字符不会破坏Content-Disposition
+func TestAttachmentHeaderEscapesFilename(t *testing.T) {
+	for _, name := range []string{"show run.txt", `core"; filename="evil.sh`, "配置备份.cfg", `back\slash.log`} {
+		header := attachmentHeader(name)
+		disposition, params, err := mime.ParseMediaType(header)
+		if err != nil || disposition != "attachment" || params["filename"] != name || len(params) != 1 {
+			t.Errorf("%q: Content-Disposition %q parsed as %q %v, %v", name, header, disposition, params, err)
+		}
+	}
+}
+
+// 打开远端文件失败时按原因区分状态码，而不是一律404
+func TestRemoteFileOpenStatus(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	r := gin.New()
+	registerFileRoutes(r)
+
+	missing := filepath.Join(t.TempDir(), "missing.cfg")
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path="+missing, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("download missing file: status = %d, want 404: %s", w.Code, w.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodPost, "/connections/"+id+"/files/parse",
+		strings.NewReader(`{"path":"`+missing+`","parse":{"mode":"json"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("parse missing file: status = %d, want 404: %s", w.Code, w.Body.String())
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{os.ErrPermission, http.StatusForbidden},
+		{os.ErrNotExist, http.StatusNotFound},
+		{io.ErrUnexpectedEOF, http.StatusBadGateway},
+	} {
+		if got := fileErrorStatus(fmt.Errorf("%w: %w", errRemoteFileOpen, tc.err)); got != tc.want {
+			t.Errorf("open error %v: status = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+// notSSHConnection 非SSH协议的连接，文件接口应拒绝
+type notSSHConnection struct{}
+
+func (notSSHConnection) Execute(shell, command string) (*CommandResult, error) { return nil, nil }
+func (notSSHConnection) HealthCheck() error                                    { return nil }
+func (notSSHConnection) Close() error                                          { return nil }
+func (notSSHConnection) Info() ConnectionInfo {
+	return ConnectionInfo{Protocol: "telnet", Host: "files-test.invalid", Port: 23}
+}
+
+// 非SSH连接上的下载返回400，与校验和上传接口一致
+func TestDownloadRejectsNonSSHConnection(t *testing.T) {
+	id := "telnet-files-test"
+	collector.add(id, notSSHConnection{}, connectionMeta{})
+	t.Cleanup(func() { collector.Disconnect(id) })
+	r := gin.New()
+	registerFileRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path=/etc/hosts", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/connections/missing/files/download?path=/etc/hosts", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown connection: status = %d, want 404", w.Code)
+	}
+}
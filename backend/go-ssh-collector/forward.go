@@ -0,0 +1,536 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errForwardNotFound = errors.New("forward not found")
+	errForwardDenied   = errors.New("forward destination not allowed")
+
+	// 本地转发监听地址，默认只监听回环
+	forwardBindAddr = getEnv("FORWARD_BIND_ADDR", "127.0.0.1")
+	// 本地转发允许的远端主机，默认只允许设备自身的回环地址
+	forwardAllowedHosts = newHostAllowlist(splitList(getEnv("FORWARD_ALLOWED_HOSTS", "localhost,127.0.0.1,::1")))
+	// 反向转发允许连接的本地服务
+	reverseForwardAllowedTargets = newHostAllowlist(splitList(getEnv("REVERSE_FORWARD_ALLOWED_TARGETS", "localhost,127.0.0.1,::1")))
+)
+
+const (
+	reverseForwardMinBackoff = time.Second
+	reverseForwardMaxBackoff = time.Minute
+)
+
+// hostAllowlist 支持主机名、IP、CIDR与"*"
+type hostAllowlist struct {
+	any      bool
+	hosts    map[string]bool
+	networks []*net.IPNet
+}
+
+func newHostAllowlist(entries []string) *hostAllowlist {
+	a := &hostAllowlist{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		switch {
+		case entry == "*":
+			a.any = true
+		case strings.Contains(entry, "/"):
+			a.networks = append(a.networks, parseCIDRs([]string{entry})...)
+		default:
+			a.hosts[strings.ToLower(entry)] = true
+		}
+	}
+	return a
+}
+
+func (a *hostAllowlist) Allowed(host string) bool {
+	if a.any || a.hosts[strings.ToLower(host)] {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range a.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Forward 一条端口转发，Type: local / reverse / socks
+type Forward struct {
+	ID           string
+	Type         string
+	ConnectionID string
+	ListenAddr   string
+	Target       string
+	CreatedAt    time.Time
+
+	bytesIn     int64
+	bytesOut    int64
+	activeConns int64
+	totalConns  int64
+	lastActive  int64
+	lastError   atomic.Value
+
+	conn *SSHConnection
+	// listener 在反向转发重建时会被替换
+	listener  net.Listener
+	mutex     sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	// onClose 在转发关闭时调用，用于释放额外资源
+	onClose func()
+}
+
+func newForward(forwardType, connectionID string, conn *SSHConnection, target string) *Forward {
+	return &Forward{
+		ID:           newID(),
+		Type:         forwardType,
+		ConnectionID: connectionID,
+		Target:       target,
+		CreatedAt:    time.Now(),
+		conn:         conn,
+		done:         make(chan struct{}),
+	}
+}
+
+func (f *Forward) Close() {
+	f.closeOnce.Do(func() {
+		f.mutex.Lock()
+		close(f.done)
+		if f.listener != nil {
+			f.listener.Close()
+		}
+		f.mutex.Unlock()
+		if f.onClose != nil {
+			f.onClose()
+		}
+	})
+}
+
+// setListener 替换监听，转发已关闭时返回false
+func (f *Forward) setListener(listener net.Listener) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed() {
+		return false
+	}
+	f.listener = listener
+	f.ListenAddr = listener.Addr().String()
+	return true
+}
+
+func (f *Forward) currentListener() net.Listener {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.listener
+}
+
+func (f *Forward) closed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *Forward) touch() {
+	atomic.StoreInt64(&f.lastActive, time.Now().UnixNano())
+}
+
+func (f *Forward) lastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&f.lastActive))
+}
+
+func (f *Forward) setError(err error) {
+	f.lastError.Store(redact(err.Error()))
+}
+
+// countingWriter 实时累计转发字节数
+type countingWriter struct {
+	w       io.Writer
+	counter *int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddInt64(cw.counter, int64(n))
+	return n, err
+}
+
+// proxy 双向转发，任一方向结束后关闭两端；in为客户端侧连接
+func (f *Forward) proxy(in, out net.Conn) {
+	atomic.AddInt64(&f.activeConns, 1)
+	atomic.AddInt64(&f.totalConns, 1)
+	defer atomic.AddInt64(&f.activeConns, -1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(countingWriter{out, &f.bytesOut}, in)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(countingWriter{in, &f.bytesIn}, out)
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+	case <-f.done:
+	}
+	in.Close()
+	out.Close()
+}
+
+func (f *Forward) view() gin.H {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	view := gin.H{
+		"forward_id":    f.ID,
+		"type":          f.Type,
+		"connection_id": f.ConnectionID,
+		"listen_addr":   f.ListenAddr,
+		"target":        f.Target,
+		"bytes_in":      atomic.LoadInt64(&f.bytesIn),
+		"bytes_out":     atomic.LoadInt64(&f.bytesOut),
+		"active_conns":  atomic.LoadInt64(&f.activeConns),
+		"total_conns":   atomic.LoadInt64(&f.totalConns),
+		"created_at":    f.CreatedAt,
+	}
+	if last := atomic.LoadInt64(&f.lastActive); last > 0 {
+		view["last_active"] = time.Unix(0, last)
+	}
+	if err, ok := f.lastError.Load().(string); ok {
+		view["last_error"] = err
+	}
+	return view
+}
+
+type ForwardManager struct {
+	forwards map[string]*Forward
+	mutex    sync.RWMutex
+}
+
+var forwards = &ForwardManager{forwards: make(map[string]*Forward)}
+
+func (fm *ForwardManager) add(f *Forward) {
+	fm.mutex.Lock()
+	fm.forwards[f.ID] = f
+	fm.mutex.Unlock()
+}
+
+func (fm *ForwardManager) Remove(id string) error {
+	fm.mutex.Lock()
+	f, ok := fm.forwards[id]
+	delete(fm.forwards, id)
+	fm.mutex.Unlock()
+
+	if !ok {
+		return errForwardNotFound
+	}
+	f.Close()
+	return nil
+}
+
+// CloseConnection 关闭父连接上的所有转发
+func (fm *ForwardManager) CloseConnection(conn *SSHConnection) {
+	fm.mutex.Lock()
+	var closing []*Forward
+	for id, f := range fm.forwards {
+		if f.conn == conn {
+			closing = append(closing, f)
+			delete(fm.forwards, id)
+		}
+	}
+	fm.mutex.Unlock()
+
+	for _, f := range closing {
+		f.Close()
+	}
+}
+
+func (fm *ForwardManager) CloseAll() {
+	fm.mutex.Lock()
+	closing := fm.forwards
+	fm.forwards = make(map[string]*Forward)
+	fm.mutex.Unlock()
+
+	for _, f := range closing {
+		f.Close()
+	}
+}
+
+func (fm *ForwardManager) Get(id string) (*Forward, bool) {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+	f, ok := fm.forwards[id]
+	return f, ok
+}
+
+// List 只返回p有权访问的连接上的转发，p为nil时返回全部
+func (fm *ForwardManager) List(p *Principal) []gin.H {
+	fm.mutex.RLock()
+	defer fm.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(fm.forwards))
+	for _, f := range fm.forwards {
+		if connectionACLs.Allowed(p, f.ConnectionID) {
+			list = append(list, f.view())
+		}
+	}
+	return list
+}
+
+// LocalForward 在本地监听并经SSH连接转发到remote
+func (fm *ForwardManager) LocalForward(connectionID string, localPort int, remoteHost string, remotePort int) (*Forward, error) {
+	if !forwardAllowedHosts.Allowed(remoteHost) {
+		return nil, fmt.Errorf("%w: %s", errForwardDenied, remoteHost)
+	}
+	if remotePort < 1 || remotePort > 65535 || localPort < 0 || localPort > 65535 {
+		return nil, errors.New("invalid port")
+	}
+	target := net.JoinHostPort(remoteHost, strconv.Itoa(remotePort))
+	if err := checkTunnelAddress(target); err != nil {
+		return nil, err
+	}
+	conn, err := collector.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(forwardBindAddr, strconv.Itoa(localPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+
+	f := newForward("local", connectionID, conn, target)
+	f.setListener(listener)
+	fm.add(f)
+
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				if !f.closed() {
+					logWarn("forward.accept_failed", "forward accept failed", "forward_id", f.ID, "error", err)
+					fm.Remove(f.ID)
+				}
+				return
+			}
+			go func() {
+				remote, err := tunnelDial(conn.Client, "tcp", f.Target)
+				if err != nil {
+					f.setError(err)
+					local.Close()
+					return
+				}
+				f.proxy(local, remote)
+			}()
+		}
+	}()
+	return f, nil
+}
+
+// ReverseForward 请求远端监听remoteBind，并将进入的连接转发到本地localTarget
+func (fm *ForwardManager) ReverseForward(connectionID, remoteBind, localTarget string) (*Forward, error) {
+	host, _, err := net.SplitHostPort(localTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local_target: %v", err)
+	}
+	if !reverseForwardAllowedTargets.Allowed(host) {
+		return nil, fmt.Errorf("%w: %s", errForwardDenied, localTarget)
+	}
+	if err := checkTunnelAddress(localTarget); err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(remoteBind); err != nil {
+		return nil, fmt.Errorf("invalid remote_bind: %v", err)
+	}
+	conn, err := collector.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := conn.Client.Listen("tcp", remoteBind)
+	if err != nil {
+		return nil, fmt.Errorf("remote listen failed: %v", err)
+	}
+
+	f := newForward("reverse", connectionID, conn, localTarget)
+	f.setListener(listener)
+	fm.add(f)
+
+	go fm.serveReverse(f, remoteBind)
+	return f, nil
+}
+
+// serveReverse 远端监听失效（如sshd重启）而连接仍可用时重新建立监听
+func (fm *ForwardManager) serveReverse(f *Forward, remoteBind string) {
+	backoff := reverseForwardMinBackoff
+	for {
+		listener := f.currentListener()
+		for {
+			remote, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			backoff = reverseForwardMinBackoff
+			go func() {
+				local, err := reverseForwardDial(f.Target)
+				if err != nil {
+					f.setError(err)
+					remote.Close()
+					return
+				}
+				f.proxy(remote, local)
+			}()
+		}
+
+		for {
+			if f.closed() {
+				return
+			}
+			err := f.conn.HealthCheck()
+			connStats.ObserveKeepalive(f.ConnectionID, err)
+			if err != nil {
+				// 父连接已断开，转发随之结束
+				logWarn("forward.connection_lost", "reverse forward connection lost", "forward_id", f.ID, "connection_id", f.ConnectionID, "error", err)
+				fm.Remove(f.ID)
+				return
+			}
+			select {
+			case <-f.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > reverseForwardMaxBackoff {
+				backoff = reverseForwardMaxBackoff
+			}
+
+			listener, err := f.conn.Client.Listen("tcp", remoteBind)
+			if err != nil {
+				f.setError(err)
+				logWarn("forward.relisten_failed", "reverse forward re-listen failed", "forward_id", f.ID, "bind", remoteBind, "error", err)
+				continue
+			}
+			if !f.setListener(listener) {
+				listener.Close()
+				return
+			}
+			break
+		}
+	}
+}
+
+// reverseForwardDial 本机回环上的服务由REVERSE_FORWARD_ALLOWED_TARGETS控制，其它目标按目标地址策略校验
+func reverseForwardDial(target string) (net.Conn, error) {
+	dialer := safeDialer(10 * time.Second)
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		host, _, _ := net.SplitHostPort(address)
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return destinationControl(network, address, c)
+	}
+	return dialer.Dial("tcp", target)
+}
+
+func forwardErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errForwardDenied):
+		return http.StatusForbidden
+	case errors.Is(err, errConnectionNotFound), errors.Is(err, errForwardNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func registerForwardRoutes(r *gin.Engine) {
+	// 本地端口转发，local_port为0时自动分配
+	r.POST("/connections/:id/forward", func(c *gin.Context) {
+		var req struct {
+			LocalPort  int    `json:"local_port"`
+			RemoteHost string `json:"remote_host" binding:"required"`
+			RemotePort int    `json:"remote_port" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		f, err := forwards.LocalForward(c.Param("id"), req.LocalPort, req.RemoteHost, req.RemotePort)
+		if err != nil {
+			respondError(c, forwardErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"forward_id":    f.ID,
+			"bound_address": f.ListenAddr,
+			"target":        f.Target,
+			"timestamp":     time.Now(),
+		})
+	})
+
+	// 反向端口转发，remote_bind如 "127.0.0.1:9000"
+	r.POST("/connections/:id/reverse_forward", func(c *gin.Context) {
+		var req struct {
+			RemoteBind  string `json:"remote_bind" binding:"required"`
+			LocalTarget string `json:"local_target" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		f, err := forwards.ReverseForward(c.Param("id"), req.RemoteBind, req.LocalTarget)
+		if err != nil {
+			respondError(c, forwardErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"forward_id":    f.ID,
+			"bound_address": f.ListenAddr,
+			"target":        f.Target,
+			"timestamp":     time.Now(),
+		})
+	})
+
+	r.GET("/forwards", func(c *gin.Context) {
+		list := forwards.List(currentPrincipal(c))
+		c.JSON(http.StatusOK, gin.H{"forwards": list, "count": len(list)})
+	})
+
+	r.DELETE("/forwards/:id", func(c *gin.Context) {
+		f, ok := forwards.Get(c.Param("id"))
+		if !ok {
+			respondError(c, http.StatusNotFound, errForwardNotFound)
+			return
+		}
+		if p := currentPrincipal(c); !connectionACLs.Allowed(p, f.ConnectionID) {
+			denyRequest(c, p, permExecute, "connection not shared with caller")
+			return
+		}
+		if err := forwards.Remove(f.ID); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "closed"})
+	})
+}
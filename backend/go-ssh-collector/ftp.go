@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlaffaye/ftp"
+)
+
+var errFTPEndpointNotFound = errors.New("ftp endpoint not found")
+
+type FTPConfig struct {
+	Host        string `json:"host" binding:"required"`
+	Port        int    `json:"port"`
+	Username    string `json:"username" binding:"required"`
+	Password    string `json:"password"`
+	PasswordRef string `json:"password_ref"`
+	// TLS 启用显式FTPS (AUTH TLS)
+	TLS        bool `json:"tls"`
+	SkipVerify bool `json:"skip_verify"`
+	// DisableEPSV 部分老设备只支持PASV
+	DisableEPSV bool `json:"disable_epsv"`
+	Timeout     int  `json:"timeout"`
+}
+
+// FTPEndpoint 注册的FTP端点；FTP控制连接不能并发传输，每次操作建立独立连接
+type FTPEndpoint struct {
+	ID        string
+	Config    FTPConfig
+	CreatedAt time.Time
+
+	password string
+}
+
+type FTPEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    uint64    `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	IsLink  bool      `json:"is_link,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (e *FTPEndpoint) dial(ctx context.Context) (*ftp.ServerConn, error) {
+	timeout := time.Duration(e.Config.Timeout) * time.Second
+	options := []ftp.DialOption{
+		ftp.DialWithContext(ctx),
+		ftp.DialWithDialer(*safeDialer(timeout)),
+		ftp.DialWithDisabledEPSV(e.Config.DisableEPSV),
+	}
+	if e.Config.TLS {
+		options = append(options, ftp.DialWithExplicitTLS(&tls.Config{
+			ServerName:         e.Config.Host,
+			InsecureSkipVerify: e.Config.SkipVerify,
+		}))
+	}
+
+	address := net.JoinHostPort(e.Config.Host, strconv.Itoa(e.Config.Port))
+	conn, err := ftp.Dial(address, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if err := conn.Login(e.Config.Username, e.password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("login failed: %v", err)
+	}
+	return conn, nil
+}
+
+// List 列出目录；服务端支持MLSD时优先使用，否则解析UNIX/MS-DOS格式的LIST
+func (e *FTPEndpoint) List(ctx context.Context, dir string) ([]FTPEntry, error) {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Quit()
+
+	entries, err := conn.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", dir, err)
+	}
+
+	result := []FTPEntry{}
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		result = append(result, FTPEntry{
+			Name:    entry.Name,
+			Path:    path.Join(dir, entry.Name),
+			Size:    entry.Size,
+			IsDir:   entry.Type == ftp.EntryTypeFolder,
+			IsLink:  entry.Type == ftp.EntryTypeLink,
+			Target:  entry.Target,
+			ModTime: entry.Time,
+		})
+	}
+	return result, nil
+}
+
+func (e *FTPEndpoint) Delete(ctx context.Context, remotePath string) error {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+
+	if err := conn.Delete(remotePath); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", remotePath, err)
+	}
+	return nil
+}
+
+// Upload 流式上传并计算SHA256，失败或校验不符时删除远端文件
+func (e *FTPEndpoint) Upload(ctx context.Context, remotePath string, src io.Reader, size int64, opts UploadOptions) (*UploadResult, error) {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Quit()
+
+	if opts.Progress != nil {
+		opts.Progress.SetTotal(size)
+	}
+
+	h := sha256.New()
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := copyStream(ctx, writer, io.TeeReader(src, h), copyOptions{
+			RateLimit: effectiveRate(opts.RateLimit),
+			Progress:  opts.Progress,
+		})
+		writer.CloseWithError(err)
+	}()
+
+	if err := conn.Stor(remotePath, reader); err != nil {
+		reader.CloseWithError(err)
+		conn.Delete(remotePath)
+		return nil, fmt.Errorf("failed to upload %s: %v", remotePath, err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if opts.ExpectedSHA256 != "" && sum != strings.ToLower(opts.ExpectedSHA256) {
+		conn.Delete(remotePath)
+		return nil, fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, opts.ExpectedSHA256, sum)
+	}
+
+	return &UploadResult{
+		Path:      remotePath,
+		Size:      size,
+		SHA256:    sum,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+type FTPRegistry struct {
+	endpoints map[string]*FTPEndpoint
+	mutex     sync.RWMutex
+}
+
+var ftpEndpoints = &FTPRegistry{endpoints: make(map[string]*FTPEndpoint)}
+
+func (r *FTPRegistry) Get(id string) (*FTPEndpoint, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	endpoint, ok := r.endpoints[id]
+	if !ok {
+		return nil, errFTPEndpointNotFound
+	}
+	return endpoint, nil
+}
+
+func registerFTPRoutes(r *gin.Engine) {
+	// 注册FTP端点，注册时验证登录
+	r.POST("/ftp/endpoints", func(c *gin.Context) {
+		var config FTPConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if config.Port == 0 {
+			config.Port = 21
+		}
+		if config.Timeout == 0 {
+			config.Timeout = 30
+		}
+		password, err := credentialValue(config.Password, config.PasswordRef)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		endpoint := &FTPEndpoint{
+			ID:        fmt.Sprintf("ftp:%s:%d:%s", config.Host, config.Port, config.Username),
+			Config:    config,
+			CreatedAt: time.Now(),
+			password:  password,
+		}
+		conn, err := endpoint.dial(c.Request.Context())
+		if err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		conn.Quit()
+
+		ftpEndpoints.mutex.Lock()
+		ftpEndpoints.endpoints[endpoint.ID] = endpoint
+		ftpEndpoints.mutex.Unlock()
+
+		c.JSON(http.StatusOK, gin.H{
+			"endpoint_id": endpoint.ID,
+			"status":      "connected",
+			"timestamp":   time.Now(),
+		})
+	})
+
+	r.GET("/ftp/endpoints", func(c *gin.Context) {
+		ftpEndpoints.mutex.RLock()
+		list := make([]gin.H, 0, len(ftpEndpoints.endpoints))
+		for id, endpoint := range ftpEndpoints.endpoints {
+			list = append(list, gin.H{
+				"id":         id,
+				"host":       endpoint.Config.Host,
+				"port":       endpoint.Config.Port,
+				"username":   endpoint.Config.Username,
+				"tls":        endpoint.Config.TLS,
+				"created_at": endpoint.CreatedAt,
+			})
+		}
+		ftpEndpoints.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"endpoints": list})
+	})
+
+	r.DELETE("/ftp/endpoints/:id", func(c *gin.Context) {
+		ftpEndpoints.mutex.Lock()
+		_, ok := ftpEndpoints.endpoints[c.Param("id")]
+		delete(ftpEndpoints.endpoints, c.Param("id"))
+		ftpEndpoints.mutex.Unlock()
+
+		if !ok {
+			respondError(c, http.StatusNotFound, errFTPEndpointNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+
+	// 列出目录
+	r.GET("/ftp/endpoints/:id/files", func(c *gin.Context) {
+		endpoint, err := ftpEndpoints.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		dir := c.DefaultQuery("path", "/")
+
+		entries, err := endpoint.List(c.Request.Context(), dir)
+		if err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"path":      dir,
+			"entries":   entries,
+			"timestamp": time.Now(),
+		})
+	})
+
+	// 删除文件
+	r.DELETE("/ftp/endpoints/:id/files", func(c *gin.Context) {
+		endpoint, err := ftpEndpoints.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		remotePath := c.Query("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+
+		if err := endpoint.Delete(c.Request.Context(), remotePath); err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"path":      remotePath,
+			"status":    "deleted",
+			"timestamp": time.Now(),
+		})
+	})
+
+	// 下载文件，支持Range续传
+	r.GET("/ftp/endpoints/:id/files/download", func(c *gin.Context) {
+		endpoint, err := ftpEndpoints.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		remotePath := c.Query("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+
+		conn, err := endpoint.dial(c.Request.Context())
+		if err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		defer conn.Quit()
+
+		size, err := conn.FileSize(remotePath)
+		if err != nil {
+			respondError(c, http.StatusNotFound, fmt.Errorf("failed to stat remote file: %w", err))
+			return
+		}
+
+		status := http.StatusOK
+		start, length := int64(0), size
+		if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+			rangeStart, rangeEnd, err := parseRange(rangeHeader, size)
+			if err != nil {
+				c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+				respondError(c, http.StatusRequestedRangeNotSatisfiable, err)
+				return
+			}
+			status = http.StatusPartialContent
+			start, length = rangeStart, rangeEnd-rangeStart+1
+			c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart, rangeEnd, size))
+		}
+		if maxTransferBytes > 0 && length > maxTransferBytes {
+			respondError(c, http.StatusRequestEntityTooLarge, errTransferTooLarge)
+			return
+		}
+
+		resp, err := conn.RetrFrom(remotePath, uint64(start))
+		if err != nil {
+			respondError(c, http.StatusBadGateway, fmt.Errorf("failed to open remote file: %w", err))
+			return
+		}
+		defer resp.Close()
+
+		rateLimit, _ := strconv.ParseInt(c.Query("rate_limit"), 10, 64)
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, path.Base(remotePath)))
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Length", strconv.FormatInt(length, 10))
+		c.Status(status)
+
+		written, err := copyStream(c.Request.Context(), c.Writer, io.LimitReader(resp, length), copyOptions{
+			RateLimit: effectiveRate(rateLimit),
+		})
+		if err != nil {
+			logWarn("ftp.download_aborted", "ftp download aborted", "endpoint_id", endpoint.ID, "path", remotePath, "offset", start+written, "error", err)
+			c.Abort()
+		}
+	})
+
+	// 上传文件 (multipart: file, path, expected_sha256, rate_limit, async)
+	r.POST("/ftp/endpoints/:id/files/upload", func(c *gin.Context) {
+		endpoint, err := ftpEndpoints.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		remotePath := c.PostForm("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+		header, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		src, err := header.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		defer src.Close()
+
+		opts := UploadOptions{ExpectedSHA256: c.PostForm("expected_sha256")}
+		opts.RateLimit, _ = strconv.ParseInt(c.PostForm("rate_limit"), 10, 64)
+
+		if c.PostForm("async") == "true" {
+			staged, err := os.CreateTemp("", "ftp-upload-*")
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, err)
+				return
+			}
+			if _, err := io.Copy(staged, src); err != nil {
+				staged.Close()
+				os.Remove(staged.Name())
+				respondError(c, http.StatusInternalServerError, err)
+				return
+			}
+
+			opts.Progress = &TransferProgress{}
+			jobID := jobs.Submit(c.Request.Context(), "ftp_upload", endpoint.ID, opts.Progress, func(ctx context.Context) (interface{}, error) {
+				defer os.Remove(staged.Name())
+				defer staged.Close()
+
+				if _, err := staged.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return endpoint.Upload(ctx, remotePath, staged, header.Size, opts)
+			})
+
+			c.JSON(http.StatusAccepted, gin.H{
+				"job_id":    jobID,
+				"status":    JobPending,
+				"timestamp": time.Now(),
+			})
+			return
+		}
+
+		result, err := endpoint.Upload(c.Request.Context(), remotePath, src, header.Size, opts)
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	gnmiMinBackoff = time.Second
+	gnmiMaxBackoff = time.Minute
+)
+
+type GNMITargetConfig struct {
+	Address     string `json:"address" binding:"required"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PasswordRef string `json:"password_ref"`
+	// Insecure 为true时使用明文gRPC，SkipVerify仅跳过证书校验
+	Insecure   bool   `json:"insecure"`
+	SkipVerify bool   `json:"skip_verify"`
+	ServerName string `json:"server_name"`
+	Timeout    int    `json:"timeout"`
+}
+
+type GNMITarget struct {
+	ID        string
+	Config    GNMITargetConfig
+	CreatedAt time.Time
+
+	conn     *grpc.ClientConn
+	client   gnmi.GNMIClient
+	password string
+}
+
+// ctx 附加gNMI用户名密码元数据
+func (t *GNMITarget) ctx(parent context.Context) context.Context {
+	if t.Config.Username == "" {
+		return parent
+	}
+	return metadata.AppendToOutgoingContext(parent, "username", t.Config.Username, "password", t.password)
+}
+
+func (t *GNMITarget) timeout() time.Duration {
+	if t.Config.Timeout > 0 {
+		return time.Duration(t.Config.Timeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+type GNMIManager struct {
+	targets       map[string]*GNMITarget
+	subscriptions map[string]*GNMISubscription
+	mutex         sync.RWMutex
+}
+
+func NewGNMIManager() *GNMIManager {
+	return &GNMIManager{
+		targets:       make(map[string]*GNMITarget),
+		subscriptions: make(map[string]*GNMISubscription),
+	}
+}
+
+func (m *GNMIManager) AddTarget(config GNMITargetConfig) (*GNMITarget, error) {
+	password, err := credentialValue(config.Password, config.PasswordRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds credentials.TransportCredentials
+	if config.Insecure {
+		creds = insecure.NewCredentials()
+	} else {
+		creds = credentials.NewTLS(&tls.Config{
+			ServerName:         config.ServerName,
+			InsecureSkipVerify: config.SkipVerify,
+		})
+	}
+	// 不阻塞等待连接，首次RPC时建立，目标重启后由grpc自动重连
+	conn, err := grpc.Dial(config.Address, grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return safeDialer(30*time.Second).DialContext(ctx, "tcp", addr)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+
+	target := &GNMITarget{
+		ID:        fmt.Sprintf("gnmi:%s:%s", config.Address, config.Username),
+		Config:    config,
+		CreatedAt: time.Now(),
+		conn:      conn,
+		client:    gnmi.NewGNMIClient(conn),
+		password:  password,
+	}
+
+	m.mutex.Lock()
+	if old, ok := m.targets[target.ID]; ok {
+		old.conn.Close()
+	}
+	m.targets[target.ID] = target
+	m.mutex.Unlock()
+	return target, nil
+}
+
+func (m *GNMIManager) Target(id string) (*GNMITarget, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	target, ok := m.targets[id]
+	if !ok {
+		return nil, fmt.Errorf("gnmi target not found")
+	}
+	return target, nil
+}
+
+func (m *GNMIManager) RemoveTarget(id string) error {
+	m.mutex.Lock()
+	target, ok := m.targets[id]
+	delete(m.targets, id)
+	var subs []*GNMISubscription
+	for subID, sub := range m.subscriptions {
+		if sub.TargetID == id {
+			subs = append(subs, sub)
+			delete(m.subscriptions, subID)
+		}
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("gnmi target not found")
+	}
+	for _, sub := range subs {
+		sub.cancel()
+	}
+	return target.conn.Close()
+}
+
+// parseGNMIPath 解析 origin:/a/b[key=value]/c 形式的路径，键值中的 ] \ 可用反斜杠转义
+func parseGNMIPath(s string) (*gnmi.Path, error) {
+	path := &gnmi.Path{}
+	if i := strings.Index(s, ":/"); i > 0 && !strings.ContainsAny(s[:i], "/[") {
+		path.Origin = s[:i]
+		s = s[i+1:]
+	}
+	s = strings.TrimPrefix(s, "/")
+	if s == "" {
+		return path, nil
+	}
+
+	var elem *gnmi.PathElem
+	var name strings.Builder
+	flush := func() error {
+		if elem == nil {
+			if name.Len() == 0 {
+				return fmt.Errorf("empty path element")
+			}
+			elem = &gnmi.PathElem{Name: name.String()}
+		}
+		path.Elem = append(path.Elem, elem)
+		elem = nil
+		name.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '/':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case '[':
+			if elem == nil {
+				if name.Len() == 0 {
+					return nil, fmt.Errorf("key without element name")
+				}
+				elem = &gnmi.PathElem{Name: name.String(), Key: map[string]string{}}
+			}
+			eq := strings.IndexByte(s[i:], '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("malformed key in path")
+			}
+			key := s[i+1 : i+eq]
+			var value strings.Builder
+			j := i + eq + 1
+			for ; j < len(s) && s[j] != ']'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				value.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated key in path")
+			}
+			elem.Key[key] = value.String()
+			i = j
+		default:
+			if elem != nil {
+				return nil, fmt.Errorf("unexpected character after key")
+			}
+			name.WriteByte(s[i])
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// gnmiPathString 将前缀与路径拼接为字符串形式
+func gnmiPathString(prefix, path *gnmi.Path) string {
+	var sb strings.Builder
+	origin := ""
+	for _, p := range []*gnmi.Path{prefix, path} {
+		if p == nil {
+			continue
+		}
+		if p.Origin != "" {
+			origin = p.Origin
+		}
+		for _, elem := range p.Elem {
+			sb.WriteString("/")
+			sb.WriteString(elem.Name)
+			for k, v := range elem.Key {
+				v = strings.NewReplacer(`\`, `\\`, `]`, `\]`).Replace(v)
+				sb.WriteString("[" + k + "=" + v + "]")
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteString("/")
+	}
+	if origin != "" {
+		return origin + ":" + sb.String()
+	}
+	return sb.String()
+}
+
+func gnmiValue(v *gnmi.TypedValue) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch val := v.Value.(type) {
+	case *gnmi.TypedValue_JsonIetfVal:
+		var out interface{}
+		if err := json.Unmarshal(val.JsonIetfVal, &out); err == nil {
+			return out
+		}
+		return string(val.JsonIetfVal)
+	case *gnmi.TypedValue_JsonVal:
+		var out interface{}
+		if err := json.Unmarshal(val.JsonVal, &out); err == nil {
+			return out
+		}
+		return string(val.JsonVal)
+	case *gnmi.TypedValue_StringVal:
+		return val.StringVal
+	case *gnmi.TypedValue_IntVal:
+		return val.IntVal
+	case *gnmi.TypedValue_UintVal:
+		return val.UintVal
+	case *gnmi.TypedValue_BoolVal:
+		return val.BoolVal
+	case *gnmi.TypedValue_DoubleVal:
+		return val.DoubleVal
+	case *gnmi.TypedValue_FloatVal:
+		return val.FloatVal
+	case *gnmi.TypedValue_BytesVal:
+		return val.BytesVal
+	case *gnmi.TypedValue_AsciiVal:
+		return val.AsciiVal
+	case *gnmi.TypedValue_LeaflistVal:
+		list := []interface{}{}
+		for _, elem := range val.LeaflistVal.Element {
+			list = append(list, gnmiValue(elem))
+		}
+		return list
+	}
+	return v.String()
+}
+
+type GNMIUpdate struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type GNMINotification struct {
+	TargetID  string       `json:"target_id"`
+	Timestamp time.Time    `json:"timestamp"`
+	Prefix    string       `json:"prefix,omitempty"`
+	Updates   []GNMIUpdate `json:"updates"`
+	Deletes   []string     `json:"deletes,omitempty"`
+}
+
+func convertNotification(targetID string, n *gnmi.Notification) GNMINotification {
+	result := GNMINotification{
+		TargetID:  targetID,
+		Timestamp: time.Unix(0, n.Timestamp),
+		Updates:   []GNMIUpdate{},
+	}
+	if n.Prefix != nil {
+		result.Prefix = gnmiPathString(n.Prefix, nil)
+	}
+	for _, u := range n.Update {
+		result.Updates = append(result.Updates, GNMIUpdate{
+			Path:  gnmiPathString(n.Prefix, u.Path),
+			Value: gnmiValue(u.Val),
+		})
+	}
+	for _, d := range n.Delete {
+		result.Deletes = append(result.Deletes, gnmiPathString(n.Prefix, d))
+	}
+	return result
+}
+
+var gnmiEncodings = map[string]gnmi.Encoding{
+	"":          gnmi.Encoding_JSON_IETF,
+	"json_ietf": gnmi.Encoding_JSON_IETF,
+	"json":      gnmi.Encoding_JSON,
+	"proto":     gnmi.Encoding_PROTO,
+	"ascii":     gnmi.Encoding_ASCII,
+}
+
+var gnmiDataTypes = map[string]gnmi.GetRequest_DataType{
+	"":            gnmi.GetRequest_ALL,
+	"all":         gnmi.GetRequest_ALL,
+	"config":      gnmi.GetRequest_CONFIG,
+	"state":       gnmi.GetRequest_STATE,
+	"operational": gnmi.GetRequest_OPERATIONAL,
+}
+
+type GNMIGetRequest struct {
+	TargetID string   `json:"target_id" binding:"required"`
+	Paths    []string `json:"paths" binding:"required"`
+	Encoding string   `json:"encoding"`
+	Type     string   `json:"type"`
+}
+
+func (m *GNMIManager) Get(req GNMIGetRequest) ([]GNMINotification, error) {
+	target, err := m.Target(req.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	encoding, ok := gnmiEncodings[req.Encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding: %s", req.Encoding)
+	}
+	dataType, ok := gnmiDataTypes[req.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data type: %s", req.Type)
+	}
+
+	getReq := &gnmi.GetRequest{Type: dataType, Encoding: encoding}
+	for _, p := range req.Paths {
+		path, err := parseGNMIPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", p, err)
+		}
+		getReq.Path = append(getReq.Path, path)
+	}
+
+	ctx, cancel := context.WithTimeout(target.ctx(context.Background()), target.timeout())
+	defer cancel()
+
+	resp, err := target.client.Get(ctx, getReq)
+	if err != nil {
+		return nil, err
+	}
+	notifications := []GNMINotification{}
+	for _, n := range resp.Notification {
+		notifications = append(notifications, convertNotification(target.ID, n))
+	}
+	return notifications, nil
+}
+
+type GNMISubscriptionPath struct {
+	Path string `json:"path" binding:"required"`
+	// Mode: sample / on_change
+	Mode string `json:"mode"`
+	// SampleInterval 采样间隔（秒）
+	SampleInterval int `json:"sample_interval"`
+}
+
+type GNMISubscribeRequest struct {
+	TargetID string                 `json:"target_id" binding:"required"`
+	Paths    []GNMISubscriptionPath `json:"paths" binding:"required"`
+	Encoding string                 `json:"encoding"`
+}
+
+// GNMISubscription 长期维护的订阅，流断开后按指数退避重连
+type GNMISubscription struct {
+	ID        string
+	TargetID  string
+	Request   GNMISubscribeRequest
+	CreatedAt time.Time
+
+	cancel      context.CancelFunc
+	mutex       sync.Mutex
+	connected   bool
+	reconnects  int
+	updates     int64
+	lastError   string
+	subscribers map[chan GNMINotification]struct{}
+}
+
+func (s *GNMISubscription) view() gin.H {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return gin.H{
+		"id":         s.ID,
+		"target_id":  s.TargetID,
+		"paths":      s.Request.Paths,
+		"connected":  s.connected,
+		"reconnects": s.reconnects,
+		"updates":    s.updates,
+		"last_error": s.lastError,
+		"created_at": s.CreatedAt,
+	}
+}
+
+func (s *GNMISubscription) Subscribe() chan GNMINotification {
+	ch := make(chan GNMINotification, 64)
+	s.mutex.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mutex.Unlock()
+	return ch
+}
+
+func (s *GNMISubscription) Unsubscribe(ch chan GNMINotification) {
+	s.mutex.Lock()
+	delete(s.subscribers, ch)
+	s.mutex.Unlock()
+}
+
+func (s *GNMISubscription) publish(n GNMINotification) {
+	s.mutex.Lock()
+	s.updates++
+	for ch := range s.subscribers {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+	s.mutex.Unlock()
+	sinks.Publish("gnmi", n)
+}
+
+func buildSubscribeRequest(req GNMISubscribeRequest) (*gnmi.SubscribeRequest, error) {
+	encoding, ok := gnmiEncodings[req.Encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding: %s", req.Encoding)
+	}
+
+	list := &gnmi.SubscriptionList{Mode: gnmi.SubscriptionList_STREAM, Encoding: encoding}
+	for _, p := range req.Paths {
+		path, err := parseGNMIPath(p.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", p.Path, err)
+		}
+		sub := &gnmi.Subscription{Path: path}
+		switch p.Mode {
+		case "", "sample":
+			sub.Mode = gnmi.SubscriptionMode_SAMPLE
+			interval := p.SampleInterval
+			if interval <= 0 {
+				interval = 10
+			}
+			sub.SampleInterval = uint64(time.Duration(interval) * time.Second)
+		case "on_change":
+			sub.Mode = gnmi.SubscriptionMode_ON_CHANGE
+		default:
+			return nil, fmt.Errorf("unsupported subscription mode: %s", p.Mode)
+		}
+		list.Subscription = append(list.Subscription, sub)
+	}
+	return &gnmi.SubscribeRequest{Request: &gnmi.SubscribeRequest_Subscribe{Subscribe: list}}, nil
+}
+
+func (m *GNMIManager) Subscribe(req GNMISubscribeRequest) (*GNMISubscription, error) {
+	target, err := m.Target(req.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	subReq, err := buildSubscribeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &GNMISubscription{
+		ID:          newID(),
+		TargetID:    target.ID,
+		Request:     req,
+		CreatedAt:   time.Now(),
+		cancel:      cancel,
+		subscribers: make(map[chan GNMINotification]struct{}),
+	}
+
+	m.mutex.Lock()
+	m.subscriptions[sub.ID] = sub
+	m.mutex.Unlock()
+
+	go sub.run(ctx, target, subReq)
+	return sub, nil
+}
+
+func (s *GNMISubscription) run(ctx context.Context, target *GNMITarget, req *gnmi.SubscribeRequest) {
+	backoff := gnmiMinBackoff
+	for {
+		err := s.stream(ctx, target, req, &backoff)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.connected = false
+		s.reconnects++
+		if err != nil {
+			s.lastError = err.Error()
+		}
+		s.mutex.Unlock()
+		logWarn("gnmi.subscription_lost", "gnmi subscription lost, retrying", "subscription_id", s.ID, "target_id", s.TargetID, "retry_in_ms", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > gnmiMaxBackoff {
+			backoff = gnmiMaxBackoff
+		}
+	}
+}
+
+// stream 运行一次订阅流，收到数据后重置退避时间
+func (s *GNMISubscription) stream(ctx context.Context, target *GNMITarget, req *gnmi.SubscribeRequest, backoff *time.Duration) error {
+	client, err := target.client.Subscribe(target.ctx(ctx))
+	if err != nil {
+		return err
+	}
+	if err := client.Send(req); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := client.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("stream closed by target")
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mutex.Lock()
+		s.connected = true
+		s.mutex.Unlock()
+		*backoff = gnmiMinBackoff
+
+		if update, ok := resp.Response.(*gnmi.SubscribeResponse_Update); ok {
+			s.publish(convertNotification(target.ID, update.Update))
+		}
+	}
+}
+
+func (m *GNMIManager) Subscription(id string) (*GNMISubscription, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sub, ok := m.subscriptions[id]
+	return sub, ok
+}
+
+func (m *GNMIManager) Unsubscribe(id string) bool {
+	m.mutex.Lock()
+	sub, ok := m.subscriptions[id]
+	delete(m.subscriptions, id)
+	m.mutex.Unlock()
+
+	if ok {
+		sub.cancel()
+	}
+	return ok
+}
+
+var gnmiManager = NewGNMIManager()
+
+func registerGNMIRoutes(r *gin.Engine) {
+	// 注册gNMI目标
+	r.POST("/gnmi/targets", func(c *gin.Context) {
+		var config GNMITargetConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		target, err := gnmiManager.AddTarget(config)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"target_id": target.ID,
+			"message":   "gNMI target registered",
+		})
+	})
+
+	r.GET("/gnmi/targets", func(c *gin.Context) {
+		gnmiManager.mutex.RLock()
+		targets := make([]gin.H, 0, len(gnmiManager.targets))
+		for id, target := range gnmiManager.targets {
+			targets = append(targets, gin.H{
+				"id":         id,
+				"address":    target.Config.Address,
+				"username":   target.Config.Username,
+				"state":      target.conn.GetState().String(),
+				"created_at": target.CreatedAt,
+			})
+		}
+		gnmiManager.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"targets": targets})
+	})
+
+	r.DELETE("/gnmi/targets/:id", func(c *gin.Context) {
+		if err := gnmiManager.RemoveTarget(c.Param("id")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "gNMI target removed"})
+	})
+
+	r.POST("/gnmi/get", func(c *gin.Context) {
+		var req GNMIGetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		notifications, err := gnmiManager.Get(req)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"target_id":     req.TargetID,
+			"notifications": notifications,
+			"timestamp":     time.Now(),
+		})
+	})
+
+	r.POST("/gnmi/subscriptions", func(c *gin.Context) {
+		var req GNMISubscribeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		sub, err := gnmiManager.Subscribe(req)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, sub.view())
+	})
+
+	r.GET("/gnmi/subscriptions", func(c *gin.Context) {
+		gnmiManager.mutex.RLock()
+		subs := make([]*GNMISubscription, 0, len(gnmiManager.subscriptions))
+		for _, sub := range gnmiManager.subscriptions {
+			subs = append(subs, sub)
+		}
+		gnmiManager.mutex.RUnlock()
+
+		views := make([]gin.H, 0, len(subs))
+		for _, sub := range subs {
+			views = append(views, sub.view())
+		}
+		c.JSON(http.StatusOK, gin.H{"subscriptions": views})
+	})
+
+	r.DELETE("/gnmi/subscriptions/:id", func(c *gin.Context) {
+		if !gnmiManager.Unsubscribe(c.Param("id")) {
+			respondError(c, http.StatusNotFound, errors.New("subscription not found"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "subscription cancelled"})
+	})
+
+	// 以SSE推送订阅更新
+	r.GET("/gnmi/subscriptions/:id/events", func(c *gin.Context) {
+		sub, ok := gnmiManager.Subscription(c.Param("id"))
+		if !ok {
+			respondError(c, http.StatusNotFound, errors.New("subscription not found"))
+			return
+		}
+
+		ch := sub.Subscribe()
+		defer sub.Unsubscribe(ch)
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case n := <-ch:
+				c.SSEvent("update", n)
+				return true
+			}
+		})
+	})
+}
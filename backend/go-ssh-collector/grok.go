@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Grok模式解析，语法与logstash一致: %{PATTERN:field:type}。
+// 标准库中的环视、原子分组在RE2中不可用，内置模式已改写为等价的RE2写法
+
+var (
+	errGrokPatternNotFound = errors.New("grok pattern not found")
+	errBuiltinGrokPattern  = errors.New("builtin grok pattern cannot be removed")
+	errGrokPatternInUse    = errors.New("grok pattern is referenced by other patterns")
+)
+
+// 启动时加载该目录下logstash格式的模式文件（每行 NAME regex）
+var grokPatternsDir = getEnv("GROK_PATTERNS_DIR", "")
+
+var builtinGrokPatterns = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"EMAILLOCALPART":    `[a-zA-Z][a-zA-Z0-9_.+=:-]+`,
+	"EMAILADDRESS":      `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":               `[+-]?[0-9]+`,
+	"BASE10NUM":         `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"BASE16NUM":         `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":            `[1-9][0-9]*`,
+	"NONNEGINT":         `[0-9]+`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":                `%{QUOTEDSTRING}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"CISCOMAC":          `(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"WINDOWSMAC":        `(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2}`,
+	"COMMONMAC":         `(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2}`,
+	"MAC":               `%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":              `(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|[0-9A-Fa-f]{1,4}:(?::[0-9A-Fa-f]{1,4}){1,6}|(?:[0-9A-Fa-f]{1,4}:){1,2}(?::[0-9A-Fa-f]{1,4}){1,5}|(?:[0-9A-Fa-f]{1,4}:){1,3}(?::[0-9A-Fa-f]{1,4}){1,4}|(?:[0-9A-Fa-f]{1,4}:){1,4}(?::[0-9A-Fa-f]{1,4}){1,3}|(?:[0-9A-Fa-f]{1,4}:){1,5}(?::[0-9A-Fa-f]{1,4}){1,2}|(?:[0-9A-Fa-f]{1,4}:){1,6}:[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,7}:|:(?:(?::[0-9A-Fa-f]{1,4}){1,7}|:)`,
+	"IP":                `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*(?:\.?|\b)`,
+	"IPORHOST":          `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"UNIXPATH":          `(?:/[\w%!$@:.,+~-]*)+`,
+	"WINPATH":           `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"PATH":              `%{UNIXPATH}|%{WINPATH}`,
+	"URIPROTO":          `[A-Za-z][A-Za-z0-9+\-.]+`,
+	"URIHOST":           `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":               `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHNUM2":         `0[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":         `%{DATE}[- ]%{TIME}`,
+	"TZ":                `[APMCE][SD]T|UTC`,
+	"DATESTAMP_RFC822":  `%{DAY} %{MONTH} %{MONTHDAY} %{YEAR} %{TIME} %{TZ}`,
+	"DATESTAMP_RFC2822": `%{DAY}, %{MONTHDAY} %{MONTH} %{YEAR} %{TIME} %{ISO8601_TIMEZONE}`,
+	"DATESTAMP_OTHER":   `%{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{TZ} %{YEAR}`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":              `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":        `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":        `%{IPORHOST}`,
+	"SYSLOGFACILITY":    `<%{NONNEGINT:facility}.%{NONNEGINT:priority}>`,
+	"SYSLOGBASE":        `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"SYSLOGBASE2":       `(?:%{SYSLOGTIMESTAMP:timestamp}|%{TIMESTAMP_ISO8601:timestamp8601}) (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource}(?: %{SYSLOGPROG}:|)`,
+	"SYSLOGLINE":        `%{SYSLOGBASE2} %{GREEDYDATA:message}`,
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn?(?:ing)?|WARN?(?:ING)?|[Ee]rr?(?:or)?|ERR?(?:OR)?|[Cc]rit?(?:ical)?|CRIT?(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?`,
+}
+
+var grokName = regexp.MustCompile(`^\w+$`)
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(int|float|string))?\}`)
+
+const grokMaxDepth = 32
+
+type grokField struct {
+	name  string
+	kind  string
+	group string
+	index int
+}
+
+// GrokPattern 编译后的grok表达式
+type GrokPattern struct {
+	re     *regexp.Regexp
+	fields []grokField
+}
+
+// Match 匹配单行，返回命名捕获，按 :int / :float 转换类型
+func (g *GrokPattern) Match(line string) (map[string]interface{}, bool) {
+	m := g.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	record := make(map[string]interface{}, len(g.fields))
+	for _, field := range g.fields {
+		value := m[field.index]
+		// 同名字段出现在多个分支时保留已匹配的值
+		if _, ok := record[field.name]; ok && value == "" {
+			continue
+		}
+		switch field.kind {
+		case "int":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				record[field.name] = n
+				continue
+			}
+			record[field.name] = nil
+		case "float":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				record[field.name] = f
+				continue
+			}
+			record[field.name] = nil
+		default:
+			record[field.name] = value
+		}
+	}
+	return record, true
+}
+
+// GrokLibrary 内置模式只读，自定义模式可覆盖同名内置模式；编译结果按表达式缓存
+type GrokLibrary struct {
+	custom map[string]string
+	cache  map[string]*GrokPattern
+	mutex  sync.RWMutex
+}
+
+var grok = newGrokLibrary()
+
+func newGrokLibrary() *GrokLibrary {
+	library := &GrokLibrary{custom: make(map[string]string), cache: make(map[string]*GrokPattern)}
+	if grokPatternsDir != "" {
+		library.loadDir(grokPatternsDir)
+	}
+	return library
+}
+
+func (l *GrokLibrary) loadDir(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, pattern, ok := strings.Cut(line, " ")
+			if ok {
+				l.custom[name] = strings.TrimSpace(pattern)
+			}
+		}
+		f.Close()
+	}
+	// 与保存时一致，逐个校验，无法编译的模式不加载
+	for name := range l.custom {
+		if _, err := l.compile("%{"+name+"}", l.custom); err != nil {
+			logWarn("grok.pattern_invalid", "invalid grok pattern", "pattern", name, "error", err)
+			delete(l.custom, name)
+		}
+	}
+}
+
+func (l *GrokLibrary) lookup(name string, custom map[string]string) (string, bool) {
+	if pattern, ok := custom[name]; ok {
+		return pattern, true
+	}
+	pattern, ok := builtinGrokPatterns[name]
+	return pattern, ok
+}
+
+// compile 展开表达式中的%{...}引用并编译，custom为使用的自定义模式集合
+func (l *GrokLibrary) compile(expr string, custom map[string]string) (*GrokPattern, error) {
+	g := &GrokPattern{}
+	var expand func(string, int) (string, error)
+	expand = func(s string, depth int) (string, error) {
+		if depth > grokMaxDepth {
+			return "", errors.New("grok pattern recursion too deep")
+		}
+		var expandErr error
+		result := grokReference.ReplaceAllStringFunc(s, func(ref string) string {
+			if expandErr != nil {
+				return ""
+			}
+			m := grokReference.FindStringSubmatch(ref)
+			pattern, ok := l.lookup(m[1], custom)
+			if !ok {
+				expandErr = fmt.Errorf("%w: %s", errGrokPatternNotFound, m[1])
+				return ""
+			}
+			// 先登记外层字段，保证分组编号与出现顺序一致
+			group := ""
+			if m[2] != "" {
+				group = fmt.Sprintf("g%d", len(g.fields))
+				g.fields = append(g.fields, grokField{name: m[2], kind: m[3], group: group})
+			}
+			inner, err := expand(pattern, depth+1)
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			if group != "" {
+				return "(?P<" + group + ">" + inner + ")"
+			}
+			return "(?:" + inner + ")"
+		})
+		return result, expandErr
+	}
+
+	expanded, err := expand(expr, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grok pattern: %v", err)
+	}
+	g.re = re
+	for i := range g.fields {
+		g.fields[i].index = re.SubexpIndex(g.fields[i].group)
+	}
+	return g, nil
+}
+
+// Compile 编译表达式，结果缓存至模式库变更
+func (l *GrokLibrary) Compile(expr string) (*GrokPattern, error) {
+	l.mutex.RLock()
+	g, ok := l.cache[expr]
+	l.mutex.RUnlock()
+	if ok {
+		return g, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if g, ok := l.cache[expr]; ok {
+		return g, nil
+	}
+	g, err := l.compile(expr, l.custom)
+	if err != nil {
+		return nil, err
+	}
+	l.cache[expr] = g
+	return g, nil
+}
+
+// validate 校验全部自定义模式在变更后仍可编译
+func (l *GrokLibrary) validate(custom map[string]string) error {
+	for name := range custom {
+		if _, err := l.compile("%{"+name+"}", custom); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Put 保存自定义模式，编译失败时在保存时返回错误
+func (l *GrokLibrary) Put(name, pattern string) error {
+	if !grokName.MatchString(name) {
+		return errors.New("invalid grok pattern name")
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	custom := make(map[string]string, len(l.custom)+1)
+	for k, v := range l.custom {
+		custom[k] = v
+	}
+	custom[name] = pattern
+	if err := l.validate(custom); err != nil {
+		return err
+	}
+	l.custom = custom
+	l.cache = make(map[string]*GrokPattern)
+	return nil
+}
+
+func (l *GrokLibrary) Remove(name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.custom[name]; !ok {
+		if _, builtin := builtinGrokPatterns[name]; builtin {
+			return errBuiltinGrokPattern
+		}
+		return fmt.Errorf("%w: %s", errGrokPatternNotFound, name)
+	}
+	custom := make(map[string]string, len(l.custom))
+	for k, v := range l.custom {
+		if k != name {
+			custom[k] = v
+		}
+	}
+	if err := l.validate(custom); err != nil {
+		return fmt.Errorf("%w: %v", errGrokPatternInUse, err)
+	}
+	l.custom = custom
+	l.cache = make(map[string]*GrokPattern)
+	return nil
+}
+
+func (l *GrokLibrary) List() []gin.H {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(builtinGrokPatterns)+len(l.custom))
+	for name, pattern := range builtinGrokPatterns {
+		if _, overridden := l.custom[name]; !overridden {
+			list = append(list, gin.H{"name": name, "pattern": pattern, "builtin": true})
+		}
+	}
+	for name, pattern := range l.custom {
+		list = append(list, gin.H{"name": name, "pattern": pattern, "builtin": false})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["name"].(string) < list[j]["name"].(string) })
+	return list
+}
+
+// ParseGrok 逐行匹配，每个匹配行输出一条记录，返回未匹配的行
+func ParseGrok(text, expr string) ([]map[string]interface{}, []string, error) {
+	g, err := grok.Compile(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := []map[string]interface{}{}
+	var unmatched []string
+	for _, line := range outputLines(text) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if record, ok := g.Match(line); ok {
+			records = append(records, record)
+		} else {
+			unmatched = append(unmatched, line)
+		}
+	}
+	return records, unmatched, nil
+}
+
+func grokErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBuiltinGrokPattern):
+		return http.StatusForbidden
+	case errors.Is(err, errGrokPatternInUse):
+		return http.StatusConflict
+	case errors.Is(err, errGrokPatternNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func registerGrokRoutes(r *gin.Engine) {
+	r.GET("/grok/patterns", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"patterns": grok.List()})
+	})
+
+	r.PUT("/grok/patterns/:name", func(c *gin.Context) {
+		var req struct {
+			Pattern string `json:"pattern" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := grok.Put(c.Param("name"), req.Pattern); err != nil {
+			// 引用了不存在的模式属于请求错误
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"pattern": c.Param("name"), "status": "saved"})
+	})
+
+	r.DELETE("/grok/patterns/:name", func(c *gin.Context) {
+		if err := grok.Remove(c.Param("name")); err != nil {
+			respondError(c, grokErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+
+	// 用样例文本调试表达式
+	r.POST("/grok/parse", func(c *gin.Context) {
+		var req struct {
+			Pattern string `json:"pattern" binding:"required"`
+			Text    string `json:"text"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		records, unmatched, err := ParseGrok(req.Text, req.Pattern)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"parsed": records, "unmatched": unmatched, "unmatched_count": len(unmatched)})
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useGrokLibrary 测试期间使用只含内置模式的新模式库
+func useGrokLibrary(t *testing.T) *GrokLibrary {
+	t.Helper()
+	previous := grok
+	grok = newGrokLibrary()
+	t.Cleanup(func() { grok = previous })
+	return grok
+}
+
+// testdata/grok下的日志(.raw)按表达式解析，期望记录和未匹配行为.json，-update 重新生成
+func TestGrokFixtures(t *testing.T) {
+	useGrokLibrary(t)
+	cases := []struct {
+		name, pattern string
+	}{
+		{"syslog", "%{SYSLOGLINE}"},
+		{"apache_combined", "%{COMBINEDAPACHELOG}"},
+		{"cisco_logging", `\*%{SYSLOGTIMESTAMP:timestamp}: %%{WORD:facility}-%{INT:severity:int}-%{WORD:mnemonic}: %{GREEDYDATA:message}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata", "grok", tc.name+".raw"))
+			if err != nil {
+				t.Fatalf("missing fixture: %v", err)
+			}
+			out, err := parseOutput(string(raw), &ParseOptions{Mode: "grok", Pattern: tc.pattern, IncludeUnmatched: true})
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if out.UnparsedCount != len(out.Unparsed) {
+				t.Fatalf("unparsed_count %d with %d lines", out.UnparsedCount, len(out.Unparsed))
+			}
+			got, err := json.MarshalIndent(map[string]interface{}{"parsed": out.Parsed, "unmatched": out.Unparsed}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := filepath.Join("testdata", "grok", tc.name+".json")
+			if *updateFixtures {
+				if err := os.WriteFile(expectedFile, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("missing expected output: %v", err)
+			}
+			assertSameJSON(t, got, expected)
+		})
+	}
+}
+
+func TestGrokFieldTypes(t *testing.T) {
+	useGrokLibrary(t)
+	cases := []struct {
+		pattern, line string
+		want          map[string]interface{}
+	}{
+		{"%{INT:n:int} %{NUMBER:f:float} %{WORD:w}", "42 1.5 up", map[string]interface{}{"n": int64(42), "f": 1.5, "w": "up"}},
+		{"%{NOTSPACE:n:int}", "4x", map[string]interface{}{"n": nil}},
+		{"%{NOTSPACE:f:float}", "n/a", map[string]interface{}{"f": nil}},
+		// 同名字段在多个分支中保留已匹配的值
+		{"(?:%{INT:v:int}|%{WORD:v})", "down", map[string]interface{}{"v": "down"}},
+		{"%{IP:addr}", "2001:db8::1", map[string]interface{}{"addr": "2001:db8::1"}},
+	}
+	for _, tc := range cases {
+		g, err := grok.Compile(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		record, ok := g.Match(tc.line)
+		if !ok {
+			t.Errorf("%s did not match %q", tc.pattern, tc.line)
+			continue
+		}
+		for name, want := range tc.want {
+			if got := record[name]; got != want {
+				t.Errorf("%s on %q: %s = %#v, want %#v", tc.pattern, tc.line, name, got, want)
+			}
+		}
+	}
+}
+
+// 表达式和自定义模式的编译错误在保存时返回
+func TestGrokCompileErrors(t *testing.T) {
+	l := useGrokLibrary(t)
+	if _, err := l.Compile("%{NO_SUCH_PATTERN}"); !errors.Is(err, errGrokPatternNotFound) {
+		t.Errorf("missing pattern: err = %v, want errGrokPatternNotFound", err)
+	}
+	if _, err := l.Compile("(unclosed %{WORD}"); err == nil {
+		t.Error("invalid regexp: expected an error")
+	}
+	for _, tc := range []struct{ name, pattern string }{
+		{"bad-name", `\d+`},
+		{"BROKEN", `(\d+`},
+		{"DANGLING", `%{MISSING}`},
+		{"LOOP", `%{LOOP}`},
+	} {
+		if err := l.Put(tc.name, tc.pattern); err == nil {
+			t.Errorf("Put(%s, %q): expected an error", tc.name, tc.pattern)
+		}
+	}
+	if len(l.custom) != 0 {
+		t.Fatalf("invalid patterns were saved: %v", l.custom)
+	}
+
+	r := newRouter()
+	if w := apiRequest(r, "", http.MethodPut, "/grok/patterns/BROKEN", `{"pattern":"(\\d+"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid pattern: status %d", w.Code)
+	}
+	if w := apiRequest(r, "", http.MethodPost, "/grok/parse", `{"pattern":"%{MISSING}","text":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /grok/parse with a missing pattern: status %d", w.Code)
+	}
+}
+
+// 自定义模式变更后清空编译缓存，被引用的模式不能删除
+func TestGrokCustomPatterns(t *testing.T) {
+	l := useGrokLibrary(t)
+	if err := l.Put("IFNAME", `(?:Gi|Te)\d+/\d+/\d+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put("IFEVENT", `%{IFNAME:interface} is %{WORD:state}`); err != nil {
+		t.Fatal(err)
+	}
+	records, unmatched, err := ParseGrok("Gi1/0/1 is down\nVlan10 is up\n", "%{IFEVENT}")
+	if err != nil || len(records) != 1 || records[0]["interface"] != "Gi1/0/1" || len(unmatched) != 1 {
+		t.Fatalf("records %v, unmatched %v, err %v", records, unmatched, err)
+	}
+
+	// 修改被引用的模式后重新编译
+	if err := l.Put("IFNAME", `(?:Gi|Te)\d+/\d+/\d+|Vlan\d+`); err != nil {
+		t.Fatal(err)
+	}
+	if records, _, _ := ParseGrok("Vlan10 is up\n", "%{IFEVENT}"); len(records) != 1 {
+		t.Fatalf("cache not invalidated: %v", records)
+	}
+
+	if err := l.Remove("IFNAME"); !errors.Is(err, errGrokPatternInUse) {
+		t.Errorf("remove referenced pattern: err = %v", err)
+	}
+	if err := l.Remove("WORD"); !errors.Is(err, errBuiltinGrokPattern) {
+		t.Errorf("remove builtin: err = %v", err)
+	}
+	if err := l.Remove("MISSING"); !errors.Is(err, errGrokPatternNotFound) {
+		t.Errorf("remove missing: err = %v", err)
+	}
+	if err := l.Remove("IFEVENT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Compile("%{IFEVENT}"); !errors.Is(err, errGrokPatternNotFound) {
+		t.Errorf("removed pattern still compiles: %v", err)
+	}
+
+	r := newRouter()
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/grok/patterns/IFNAME", http.StatusOK},
+		{"/grok/patterns/WORD", http.StatusForbidden},
+		{"/grok/patterns/IFNAME", http.StatusNotFound},
+	} {
+		if w := apiRequest(r, "", http.MethodDelete, tc.path, ""); w.Code != tc.code {
+			t.Errorf("DELETE %s: status %d, want %d", tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+// 未匹配行总是计数，include_unmatched 时才返回内容
+func TestGrokParseUnmatched(t *testing.T) {
+	useGrokLibrary(t)
+	text := "GET 200\nnoise\n\nPOST 500\n"
+	for _, include := range []bool{false, true} {
+		out, err := parseOutput(text, &ParseOptions{Mode: "grok", Pattern: "%{WORD:method} %{INT:status:int}", IncludeUnmatched: include})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records := out.Parsed.([]map[string]interface{}); len(records) != 2 || records[1]["status"] != int64(500) {
+			t.Fatalf("parsed = %v", out.Parsed)
+		}
+		if out.UnparsedCount != 1 || (len(out.Unparsed) == 1) != include {
+			t.Errorf("include %v: unparsed %q, count %d", include, out.Unparsed, out.UnparsedCount)
+		}
+	}
+	if _, err := parseOutput(text, &ParseOptions{Mode: "grok"}); err == nil {
+		t.Error("grok mode without a pattern: expected an error")
+	}
+}
+
+// 目录中无法编译的模式在加载时丢弃
+func TestGrokLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	content := "# site patterns\nSITE dc[0-9]+\nRACK %{SITE}-r%{INT}\n\nBROKEN (unclosed\nDANGLING %{NOPE}\n"
+	if err := os.WriteFile(filepath.Join(dir, "site"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := &GrokLibrary{custom: make(map[string]string), cache: make(map[string]*GrokPattern)}
+	l.loadDir(dir)
+	if len(l.custom) != 2 || l.custom["RACK"] != "%{SITE}-r%{INT}" {
+		t.Fatalf("loaded = %v", l.custom)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+type GRPCProbeRequest struct {
+	Target string `json:"target" binding:"required"`
+	// Service 为空时检查服务整体状态
+	Service string `json:"service"`
+	// Deadline 单位为毫秒，覆盖建立连接与RPC
+	Deadline   int  `json:"deadline"`
+	Reflection bool `json:"reflection"`
+
+	// TLS 为false时使用明文，提供客户端证书时为mTLS
+	TLS           bool   `json:"tls"`
+	SkipVerify    bool   `json:"skip_verify"`
+	ServerName    string `json:"server_name"`
+	CACert        string `json:"ca_cert"`
+	CACertRef     string `json:"ca_cert_ref"`
+	ClientCert    string `json:"client_cert"`
+	ClientCertRef string `json:"client_cert_ref"`
+	ClientKey     string `json:"client_key"`
+	ClientKeyRef  string `json:"client_key_ref"`
+}
+
+type GRPCProbeResult struct {
+	Target string `json:"target"`
+	// Status: serving / not_serving / service_unknown / unknown / connect_error / deadline_exceeded / denied / rpc_error
+	Status          string    `json:"status"`
+	Code            string    `json:"code,omitempty"`
+	ConnectLatency  float64   `json:"connect_latency_ms"`
+	Latency         float64   `json:"latency_ms"`
+	Services        []string  `json:"services,omitempty"`
+	ReflectionError string    `json:"reflection_error,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+func grpcTransportCredentials(req GRPCProbeRequest) (credentials.TransportCredentials, error) {
+	if !req.TLS {
+		return insecure.NewCredentials(), nil
+	}
+	config := &tls.Config{InsecureSkipVerify: req.SkipVerify, ServerName: req.ServerName}
+
+	ca, err := credentialValue(req.CACert, req.CACertRef)
+	if err != nil {
+		return nil, err
+	}
+	if ca != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("invalid ca_cert")
+		}
+		config.RootCAs = pool
+	}
+
+	cert, err := credentialValue(req.ClientCert, req.ClientCertRef)
+	if err != nil {
+		return nil, err
+	}
+	key, err := credentialValue(req.ClientKey, req.ClientKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// grpcErrorStatus 区分建连失败、超时与其它RPC错误
+func grpcErrorStatus(err error) string {
+	var destErr *destinationError
+	switch {
+	case errors.As(err, &destErr):
+		return "denied"
+	case errors.Is(err, context.DeadlineExceeded), status.Code(err) == codes.DeadlineExceeded:
+		return "deadline_exceeded"
+	case status.Code(err) == codes.Unavailable:
+		return "connect_error"
+	}
+	return "rpc_error"
+}
+
+func listGRPCServices(ctx context.Context, conn *grpc.ClientConn) ([]string, error) {
+	stream, err := grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	err = stream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, errors.New(e.ErrorMessage)
+	}
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.Name)
+	}
+	return services, nil
+}
+
+func ProbeGRPC(req GRPCProbeRequest) GRPCProbeResult {
+	result := GRPCProbeResult{Target: req.Target}
+	defer func() { result.Timestamp = time.Now() }()
+
+	deadline := 5 * time.Second
+	if req.Deadline > 0 {
+		deadline = time.Duration(req.Deadline) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	creds, err := grpcTransportCredentials(req)
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	conn, err := grpc.DialContext(ctx, req.Target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return safeDialer(deadline).DialContext(ctx, "tcp", addr)
+		}),
+		grpc.WithBlock(),
+		grpc.WithReturnConnectionError(),
+		grpc.FailOnNonTempDialError(true),
+	)
+	result.ConnectLatency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		// 建连阶段的失败（包括超时）都归为connect_error，deadline_exceeded仅用于RPC阶段
+		result.Status = "connect_error"
+		var destErr *destinationError
+		if errors.As(err, &destErr) {
+			result.Status = "denied"
+		}
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	start = time.Now()
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: req.Service})
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Code = status.Code(err).String()
+		result.Error = err.Error()
+		result.Status = grpcErrorStatus(err)
+		if status.Code(err) == codes.NotFound {
+			result.Status = "service_unknown"
+		}
+	} else {
+		switch resp.Status {
+		case grpc_health_v1.HealthCheckResponse_SERVING:
+			result.Status = "serving"
+		case grpc_health_v1.HealthCheckResponse_NOT_SERVING:
+			result.Status = "not_serving"
+		case grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN:
+			result.Status = "service_unknown"
+		default:
+			result.Status = "unknown"
+		}
+	}
+
+	if req.Reflection {
+		services, err := listGRPCServices(ctx, conn)
+		if err != nil {
+			result.ReflectionError = err.Error()
+		}
+		result.Services = services
+	}
+	return result
+}
+
+func registerGRPCProbeRoutes(r *gin.Engine) {
+	r.POST("/probe/grpc", func(c *gin.Context) {
+		var req GRPCProbeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result := ProbeGRPC(req)
+		if result.Status == "error" {
+			respondError(c, http.StatusBadRequest, errors.New(result.Error))
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
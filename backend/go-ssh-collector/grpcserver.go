@@ -0,0 +1,559 @@
+package main
+
+//go:generate protoc -I proto --go_out=collectorpb --go_opt=paths=source_relative --go-grpc_out=collectorpb --go-grpc_opt=paths=source_relative collector.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "go-ssh-collector/collectorpb"
+)
+
+// gRPC接口（proto/collector.proto），监听独立端口，与REST共用连接管理和任务系统。
+// 每个RPC映射到对应的REST路由，认证、RBAC、连接ACL和限流都按该路由处理
+
+// 为空时不启动gRPC服务
+var grpcPort = getEnv("GRPC_PORT", "")
+
+type grpcRoute struct {
+	Method string
+	Path   string
+}
+
+var grpcRoutes = map[string]grpcRoute{
+	pb.Collector_Connect_FullMethodName:         {http.MethodPost, "/connect"},
+	pb.Collector_Execute_FullMethodName:         {http.MethodPost, "/execute"},
+	pb.Collector_ExecuteStream_FullMethodName:   {http.MethodPost, "/execute"},
+	pb.Collector_Disconnect_FullMethodName:      {http.MethodPost, "/disconnect"},
+	pb.Collector_ListConnections_FullMethodName: {http.MethodGet, "/connections"},
+	pb.Collector_GetJob_FullMethodName:          {http.MethodGet, "/jobs/:id"},
+	pb.Collector_ListJobs_FullMethodName:        {http.MethodGet, "/jobs"},
+	pb.Collector_CancelJob_FullMethodName:       {http.MethodPost, "/jobs/:id/cancel"},
+	pb.Collector_WatchJobs_FullMethodName:       {http.MethodGet, "/jobs/events"},
+}
+
+// grpcError 与REST处理函数的状态码映射保持一致
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, errConnectionNotFound), errors.Is(err, errJobNotFound):
+		code = codes.NotFound
+	case errors.Is(err, errUnsupportedProtocol), errors.Is(err, errUnknownDeviceType),
+		errors.Is(err, errNotSSHConnection), errors.Is(err, errInvalidExtract), errors.Is(err, errInvalidCursor):
+		code = codes.InvalidArgument
+	case errors.Is(err, errConsoleBusy), errors.Is(err, errTargetLocked), errors.Is(err, errJobFinished):
+		code = codes.FailedPrecondition
+	case errors.Is(err, errCommandDenied), errors.Is(err, errAgentForwardingDisabled), errors.Is(err, errUnmaskedDenied),
+		errors.Is(err, errConnectionOwned), errors.Is(err, errJobNotOwned):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, redact(err.Error()))
+}
+
+// toProto 经JSON把REST使用的结构转换为消息，proto字段名与JSON字段名一致
+func toProto(v interface{}, m proto.Message) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+}
+
+func fromProto(m proto.Message, v interface{}) error {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	// 与REST的binding:"required"等校验保持一致
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// grpcMetrics 按方法和状态码统计请求数与耗时
+type grpcMetrics struct {
+	requests map[[2]string]uint64
+	seconds  map[string]float64
+	mutex    sync.Mutex
+}
+
+var grpcStats = &grpcMetrics{requests: make(map[[2]string]uint64), seconds: make(map[string]float64)}
+
+func (m *grpcMetrics) Observe(method string, err error, elapsed time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[[2]string{method, status.Code(err).String()}]++
+	m.seconds[method] += elapsed.Seconds()
+}
+
+func (m *grpcMetrics) WriteMetrics(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := metricWriter{w}
+	out.pairCounters("collector_grpc_requests_total", "gRPC requests by method and status code.", [2]string{"method", "code"}, m.requests)
+	methods := make([]string, 0, len(m.seconds))
+	for method := range m.seconds {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	out.family("collector_grpc_request_seconds_total", "counter", "Total time spent handling gRPC requests.")
+	for _, method := range methods {
+		out.sample("collector_grpc_request_seconds_total", m.seconds[method], "method", method)
+	}
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func grpcRateLimited(ctx context.Context, scope string, wait time.Duration) error {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfter)))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded (scope %s), retry after %ds", scope, retryAfter)
+}
+
+// authorizeGRPC 依次执行限流、认证和RBAC，与HTTP中间件的顺序相同
+func authorizeGRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	route, ok := grpcRoutes[fullMethod]
+	if !ok {
+		return ctx, status.Error(codes.Unimplemented, "unknown method")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := firstMetadata(md, "x-request-id")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	ctx = withRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	credential := headerCredential(firstMetadata(md, "x-api-key"), firstMetadata(md, "authorization"))
+	var state *tls.ConnectionState
+	ip := ""
+	if pr, ok := peer.FromContext(ctx); ok {
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+		ip, _, _ = net.SplitHostPort(pr.Addr.String())
+	}
+
+	reserved, scope, wait := rateLimiter.Reserve(route.Method+" "+route.Path, ip)
+	if scope != "" {
+		return ctx, grpcRateLimited(ctx, scope, wait)
+	}
+	if !authEnabled() {
+		return ctx, nil
+	}
+	p, err := authenticate(state, credential, route.Method, route.Path)
+	if err != nil {
+		var aerr *AuthError
+		errors.As(err, &aerr)
+		forwardAuthFailure("grpc", ip, "grpc", fullMethod, firstNonEmpty(aerr.Code, aerr.Message))
+		if aerr.Status == http.StatusForbidden {
+			return ctx, status.Error(codes.PermissionDenied, aerr.Message)
+		}
+		return ctx, status.Error(codes.Unauthenticated, aerr.Message)
+	}
+	if scope, wait := rateLimiter.AllowKey(reserved, rateLimitIdentity(p)); scope != "" {
+		return ctx, grpcRateLimited(ctx, scope, wait)
+	}
+	permission := routePermission(route.Method, route.Path)
+	if !p.Can(permission) {
+		auditDenied(ctx, p, "grpc", fullMethod, permission, "permission denied")
+		// 带上调用方，供审计日志记录
+		return context.WithValue(ctx, principalContextKey{}, p), status.Error(codes.PermissionDenied, "permission denied")
+	}
+	return context.WithValue(ctx, principalContextKey{}, p), nil
+}
+
+// checkConnectionACL 请求消息中带connection_id时检查连接ACL
+func checkConnectionACL(ctx context.Context, fullMethod string, req interface{}) error {
+	p := contextPrincipal(ctx)
+	msg, ok := req.(interface{ GetConnectionId() string })
+	if !ok || msg.GetConnectionId() == "" || connectionACLs.Allowed(p, msg.GetConnectionId()) {
+		return nil
+	}
+	route := grpcRoutes[fullMethod]
+	auditDenied(ctx, p, "grpc", fullMethod, routePermission(route.Method, route.Path), "connection not shared with caller")
+	return status.Error(codes.PermissionDenied, "connection not shared with caller")
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	started := time.Now()
+	defer func() { grpcStats.Observe(info.FullMethod, err, time.Since(started)) }()
+
+	defer func() { auditGRPC(ctx, info.FullMethod, req, resp, err, started) }()
+	ctx, span := startGRPCSpan(ctx, info.FullMethod)
+	defer func() { span.End(err) }()
+
+	if ctx, err = authorizeGRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	span.SetAttributes("request_id", requestIDFromContext(ctx))
+	if err = checkConnectionACL(ctx, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// startGRPCSpan 沿用metadata中的traceparent和baggage创建server span，并在响应头中返回
+func startGRPCSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = tracePropagator.Extract(ctx, metadataCarrier(md.Copy()))
+	ctx, span := startSpanKind(ctx, fullMethod, spanKindServer, "rpc.system", "grpc", "rpc.method", fullMethod)
+	header := metadata.MD{}
+	propagation.TraceContext{}.Inject(ctx, metadataCarrier(header))
+	grpc.SetHeader(ctx, header)
+	return ctx, span
+}
+
+// auditGRPC 写入审计日志：非查询方法全部记录，查询方法只记录权限拒绝
+func auditGRPC(ctx context.Context, fullMethod string, req, resp interface{}, err error, started time.Time) {
+	code := status.Code(err)
+	if auditLog == nil || grpcRoutes[fullMethod].Method == http.MethodGet && code != codes.PermissionDenied {
+		return
+	}
+	entry := AuditEntry{
+		RequestID:  requestIDFromContext(ctx),
+		Interface:  "grpc",
+		Operation:  fullMethod,
+		Status:     int(code),
+		DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	entry.setPrincipal(contextPrincipal(ctx))
+	if pr, ok := peer.FromContext(ctx); ok {
+		entry.SourceIP, _, _ = net.SplitHostPort(pr.Addr.String())
+	}
+	if msg, ok := req.(interface{ GetConnectionId() string }); ok {
+		entry.ConnectionID = msg.GetConnectionId()
+		entry.Host = auditConnectionHost(entry.ConnectionID)
+	}
+	if msg, ok := req.(interface{ GetHost() string }); ok {
+		entry.Host = msg.GetHost()
+	}
+	if msg, ok := req.(interface{ GetCommand() string }); ok {
+		entry.Command = msg.GetCommand()
+	}
+	switch r := resp.(type) {
+	case *pb.ConnectResponse:
+		entry.ConnectionID = r.GetConnectionId()
+	case *pb.CommandResult:
+		if r.ExitCode != nil {
+			exitCode := int(*r.ExitCode)
+			entry.ExitCode = &exitCode
+		}
+		entry.setOutput(r.GetOutput())
+	}
+	if err != nil {
+		entry.Reason = status.Convert(err).Message()
+		if code == codes.PermissionDenied {
+			entry.Decision = "denied"
+		}
+	}
+	auditLog.Record(entry)
+}
+
+// authorizedStream 替换上下文中的调用方，并在收到请求消息时检查连接ACL
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	fullMethod string
+	// request 最近收到的请求消息，用于审计
+	request interface{}
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.request = m
+	return checkConnectionACL(s.ctx, s.fullMethod, m)
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	started := time.Now()
+	defer func() { grpcStats.Observe(info.FullMethod, err, time.Since(started)) }()
+
+	ctx, span := startGRPCSpan(ss.Context(), info.FullMethod)
+	defer func() { span.End(err) }()
+
+	ctx, err = authorizeGRPC(ctx, info.FullMethod)
+	if err != nil {
+		auditGRPC(ctx, info.FullMethod, nil, nil, err, started)
+		return err
+	}
+	stream := &authorizedStream{ServerStream: ss, ctx: ctx, fullMethod: info.FullMethod}
+	err = handler(srv, stream)
+	auditGRPC(ctx, info.FullMethod, stream.request, nil, err, started)
+	return err
+}
+
+type collectorServer struct {
+	pb.UnimplementedCollectorServer
+}
+
+func (s *collectorServer) Connect(ctx context.Context, req *pb.ConnectRequest) (*pb.ConnectResponse, error) {
+	var config SSHConfig
+	if err := fromProto(req, &config); err != nil {
+		return nil, err
+	}
+	config.requestID = requestIDFromContext(ctx)
+	config.trace = ctx
+	if p := contextPrincipal(ctx); p != nil {
+		config.namespace, config.principal = p.Namespace, p
+	}
+	connectionID, err := collector.Connect(config)
+	if errors.Is(err, errConnectionOwned) {
+		auditDenied(ctx, config.principal, "grpc", pb.Collector_Connect_FullMethodName, permExecute, err.Error())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ConnectResponse{}
+	err = toProto(map[string]interface{}{"connection_id": connectionID, "status": "connected", "timestamp": time.Now()}, resp)
+	return resp, err
+}
+
+// commandRequest 转换请求并检查unmasked权限，与 POST /execute 相同
+func (s *collectorServer) commandRequest(ctx context.Context, req *pb.ExecuteRequest) (CommandRequest, error) {
+	var cmd CommandRequest
+	if err := fromProto(req, &cmd); err != nil {
+		return cmd, err
+	}
+	cmd.requestID = requestIDFromContext(ctx)
+	cmd.trace = ctx
+	if p := contextPrincipal(ctx); cmd.Unmasked && p != nil && !p.Can(permAdmin) {
+		auditDenied(ctx, p, "grpc", pb.Collector_Execute_FullMethodName, permAdmin, "unmasked output requires admin")
+		return cmd, status.Error(codes.PermissionDenied, "unmasked output requires admin")
+	}
+	return cmd, nil
+}
+
+func commandResultProto(result *CommandResult, unmasked bool) (*pb.CommandResult, error) {
+	resp := &pb.CommandResult{}
+	err := toProto(v1CommandResult(result, unmasked), resp)
+	return resp, err
+}
+
+func (s *collectorServer) Execute(ctx context.Context, req *pb.ExecuteRequest) (*pb.CommandResult, error) {
+	cmd, err := s.commandRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	cmd.ctx = ctx
+	result, err := collector.Execute(cmd)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return commandResultProto(result, cmd.Unmasked)
+}
+
+func (s *collectorServer) ExecuteStream(req *pb.ExecuteRequest, stream pb.Collector_ExecuteStreamServer) error {
+	cmd, err := s.commandRequest(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+	// 客户端断开时终止SSH命令，其它协议执行到结束后丢弃结果
+	cmd.ctx = stream.Context()
+	var sendErr error
+	cmd.stream = func(chunk string) {
+		if sendErr == nil {
+			sendErr = stream.Send(&pb.ExecuteChunk{Payload: &pb.ExecuteChunk_Output{Output: chunk}})
+		}
+	}
+	result, err := collector.Execute(cmd)
+	if err != nil {
+		return grpcError(err)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	resp, err := commandResultProto(result, cmd.Unmasked)
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.ExecuteChunk{Payload: &pb.ExecuteChunk_Result{Result: resp}})
+}
+
+func (s *collectorServer) Disconnect(ctx context.Context, req *pb.DisconnectRequest) (*pb.DisconnectResponse, error) {
+	if err := collector.Disconnect(req.ConnectionId); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	connectionACLs.Remove(req.ConnectionId)
+	resp := &pb.DisconnectResponse{}
+	err := toProto(map[string]interface{}{"status": "disconnected", "timestamp": time.Now()}, resp)
+	return resp, err
+}
+
+func (s *collectorServer) ListConnections(ctx context.Context, req *pb.ListConnectionsRequest) (*pb.ListConnectionsResponse, error) {
+	values := url.Values{}
+	if req.Page > 0 {
+		values.Set("page", strconv.Itoa(int(req.Page)))
+	}
+	if req.PageSize > 0 {
+		values.Set("page_size", strconv.Itoa(int(req.PageSize)))
+	}
+	for key, value := range map[string]string{"sort": req.Sort, "cursor": req.Cursor, "host": req.Host,
+		"protocol": req.Protocol, "status": req.Status, "tag": req.Tag, "namespace": req.Namespace} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	q, err := parseConnectionQuery(values.Get)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	p := contextPrincipal(ctx)
+	page := collector.QueryConnections(q, func(id string) bool { return connectionACLs.Allowed(p, id) })
+	resp := &pb.ListConnectionsResponse{}
+	err = toProto(page, resp)
+	return resp, err
+}
+
+// authorizeGRPCJob 与REST的authorizeJob相同，任务不属于调用方时拒绝并审计
+func authorizeGRPCJob(ctx context.Context, fullMethod, id string) error {
+	owner, err := jobs.Owner(id)
+	if err != nil {
+		return grpcError(err)
+	}
+	if p := contextPrincipal(ctx); !jobAllowed(p, owner) {
+		route := grpcRoutes[fullMethod]
+		auditDenied(ctx, p, "grpc", fullMethod, routePermission(route.Method, route.Path), errJobNotOwned.Error())
+		return grpcError(errJobNotOwned)
+	}
+	return nil
+}
+
+func (s *collectorServer) GetJob(ctx context.Context, req *pb.GetJobRequest) (*pb.Job, error) {
+	if err := authorizeGRPCJob(ctx, pb.Collector_GetJob_FullMethodName, req.Id); err != nil {
+		return nil, err
+	}
+	job, err := jobs.Get(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.Job{}
+	err = toProto(job, resp)
+	return resp, err
+}
+
+func (s *collectorServer) ListJobs(ctx context.Context, req *pb.ListJobsRequest) (*pb.ListJobsResponse, error) {
+	resp := &pb.ListJobsResponse{}
+	err := toProto(map[string]interface{}{"jobs": jobs.List(contextPrincipal(ctx))}, resp)
+	return resp, err
+}
+
+func (s *collectorServer) CancelJob(ctx context.Context, req *pb.CancelJobRequest) (*pb.CancelJobResponse, error) {
+	if err := authorizeGRPCJob(ctx, pb.Collector_CancelJob_FullMethodName, req.Id); err != nil {
+		return nil, err
+	}
+	if err := jobs.Cancel(req.Id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.CancelJobResponse{Status: "cancelling"}, nil
+}
+
+func (s *collectorServer) WatchJobs(req *pb.WatchJobsRequest, stream pb.Collector_WatchJobsServer) error {
+	p := contextPrincipal(stream.Context())
+	events := jobs.Subscribe()
+	defer jobs.Unsubscribe(events)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if (req.JobId != "" && event.JobID != req.JobId) || !event.Allowed(p) {
+				continue
+			}
+			msg := &pb.JobEvent{}
+			if err := toProto(event, msg); err != nil {
+				return err
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startGRPCServer 使用与HTTPS相同的TLS配置（含客户端证书校验），未配置GRPC_PORT时返回nil
+func startGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	if grpcPort == "" {
+		return nil
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterCollectorServer(srv, &collectorServer{})
+
+	listener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		logFatal("grpc.listen_failed", "grpc listen failed", "port", grpcPort, "error", err)
+	}
+	go func() {
+		logInfo("grpc.start", "starting gRPC server", "port", grpcPort)
+		if err := srv.Serve(listener); err != nil {
+			logError("grpc.serve_failed", "grpc server stopped", "error", err)
+		}
+	}()
+	return srv
+}
+
+// stopGRPCServer 等待进行中的调用结束，超时后强制关闭（WatchJobs等长连接流）
+func stopGRPCServer(ctx context.Context, srv *grpc.Server) {
+	if srv == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
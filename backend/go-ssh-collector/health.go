@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 探针：/healthz 只反映进程是否存活，不访问设备和外部依赖；
+// /readyz 检查是否可以接收新请求，各检查并发执行且有独立超时。
+// 设备响应慢不会导致存活检查失败。
+// /health 为汇总信息（运行时间、构建信息、连接与任务统计、后台清理时间），只读取内存中的计数
+
+var (
+	// 心跳超过该时间未更新视为调度停滞
+	livenessStall = time.Duration(envInt64("LIVENESS_STALL_SECONDS", 10)) * time.Second
+	// 正在执行的命令数达到该值时不再就绪，0表示不限制
+	readyMaxInflight = envInt64("READY_MAX_INFLIGHT", 0)
+	// 单个就绪检查的超时（毫秒）
+	readyCheckTimeout = time.Duration(envInt64("READY_CHECK_TIMEOUT_MS", 2000)) * time.Millisecond
+)
+
+var (
+	errStartupPending = errors.New("startup not complete")
+	errCheckTimeout   = errors.New("check timed out")
+)
+
+// heartbeat 由后台goroutine每秒更新
+var heartbeat int64
+
+var processStartedAt = time.Now()
+
+// buildInfo 启动时读取一次
+var buildInfo = readBuildInfo()
+
+func readBuildInfo() gin.H {
+	info := gin.H{"go_version": runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info["module"] = bi.Main.Path
+	info["module_version"] = bi.Main.Version
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info["revision"] = setting.Value
+		case "vcs.time":
+			info["build_time"] = setting.Value
+		case "vcs.modified":
+			info["modified"] = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// sweeps 各后台清理的运行次数和最近一次运行的时间
+var sweeps = struct {
+	times map[string]time.Time
+	runs  map[string]uint64
+	mutex sync.Mutex
+}{times: make(map[string]time.Time), runs: make(map[string]uint64)}
+
+func recordSweep(name string) {
+	sweeps.mutex.Lock()
+	defer sweeps.mutex.Unlock()
+	sweeps.times[name] = time.Now()
+	sweeps.runs[name]++
+}
+
+func sweepStats() (map[string]uint64, map[string]time.Time) {
+	sweeps.mutex.Lock()
+	defer sweeps.mutex.Unlock()
+	runs := make(map[string]uint64, len(sweeps.runs))
+	times := make(map[string]time.Time, len(sweeps.times))
+	for name, t := range sweeps.times {
+		runs[name], times[name] = sweeps.runs[name], t
+	}
+	return runs, times
+}
+
+func lastSweeps() map[string]time.Time {
+	_, times := sweepStats()
+	return times
+}
+
+var startupComplete int32
+
+func init() {
+	atomic.StoreInt64(&heartbeat, time.Now().UnixNano())
+	go func() {
+		for range time.Tick(time.Second) {
+			atomic.StoreInt64(&heartbeat, time.Now().UnixNano())
+		}
+	}()
+
+	registerReadinessCheck("startup", func(ctx context.Context) error {
+		if atomic.LoadInt32(&startupComplete) == 0 {
+			return errStartupPending
+		}
+		return nil
+	})
+	registerReadinessCheck("inflight_commands", func(ctx context.Context) error {
+		if n := collector.Inflight(); readyMaxInflight > 0 && n >= readyMaxInflight {
+			return fmt.Errorf("%d commands in flight (limit %d)", n, readyMaxInflight)
+		}
+		return nil
+	})
+	if apiKeysFile != "" {
+		registerReadinessCheck("api_keys_store", func(ctx context.Context) error {
+			_, err := os.Stat(filepath.Dir(apiKeysFile))
+			return err
+		})
+	}
+}
+
+// markStartupComplete 在所有路由和后台监听启动后调用
+func markStartupComplete() {
+	atomic.StoreInt32(&startupComplete, 1)
+}
+
+var readinessChecks = struct {
+	checks map[string]func(ctx context.Context) error
+	mutex  sync.RWMutex
+}{checks: make(map[string]func(ctx context.Context) error)}
+
+// registerReadinessCheck 供持久化、队列等组件注册就绪条件
+func registerReadinessCheck(name string, check func(ctx context.Context) error) {
+	readinessChecks.mutex.Lock()
+	defer readinessChecks.mutex.Unlock()
+	readinessChecks.checks[name] = check
+}
+
+// runReadinessChecks 返回各检查的结果，超时的检查按失败处理
+func runReadinessChecks(ctx context.Context) map[string]error {
+	readinessChecks.mutex.RLock()
+	names := make([]string, 0, len(readinessChecks.checks))
+	checks := make([]func(ctx context.Context) error, 0, len(readinessChecks.checks))
+	for name, check := range readinessChecks.checks {
+		names = append(names, name)
+		checks = append(checks, check)
+	}
+	readinessChecks.mutex.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- check(ctx) }()
+			select {
+			case errs[i] = <-done:
+			case <-ctx.Done():
+				errs[i] = errCheckTimeout
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	results := make(map[string]error, len(names))
+	for i, name := range names {
+		results[name] = errs[i]
+	}
+	return results
+}
+
+// healthSummary 只读取内存中的状态，不访问网络
+func healthSummary() gin.H {
+	connections := collector.Stats()
+	jobStats := jobs.Stats()
+	inflight := collector.Inflight()
+	commands := gin.H{"inflight": inflight, "limit": readyMaxInflight}
+	if readyMaxInflight > 0 {
+		commands["utilization"] = float64(inflight) / float64(readyMaxInflight)
+	}
+	return gin.H{
+		"status":             "healthy",
+		"timestamp":          time.Now(),
+		"service":            "go-ssh-collector",
+		"started_at":         processStartedAt,
+		"uptime_seconds":     time.Since(processStartedAt).Seconds(),
+		"version":            currentVersion(),
+		"build":              buildInfo,
+		"active_connections": connections.Total,
+		"connections":        connections,
+		"commands":           commands,
+		"jobs":               gin.H{"queued": jobStats[JobPending], "running": jobStats[JobRunning], "by_status": jobStats},
+		"goroutines":         runtime.NumGoroutine(),
+		"saturation":         saturationSummary(),
+		"result_store":       resultStore.Stats(),
+		"result_cache":       resultCache.Stats(),
+		"last_sweeps":        lastSweeps(),
+	}
+}
+
+func registerHealthRoutes(r *gin.Engine) {
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, healthSummary())
+	})
+
+	r.GET("/healthz", func(c *gin.Context) {
+		if stall := time.Since(time.Unix(0, atomic.LoadInt64(&heartbeat))); stall > livenessStall {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "stalled", "stalled_seconds": stall.Seconds()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "alive", "version": version})
+	})
+
+	r.GET("/readyz", func(c *gin.Context) {
+		status := http.StatusOK
+		checks := gin.H{}
+		for name, err := range runReadinessChecks(c.Request.Context()) {
+			if err != nil {
+				status = http.StatusServiceUnavailable
+				checks[name] = redact(err.Error())
+			} else {
+				checks[name] = "ok"
+			}
+		}
+		state := "ready"
+		if status != http.StatusOK {
+			state = "not_ready"
+		}
+		c.JSON(status, gin.H{"status": state, "checks": checks})
+	})
+}
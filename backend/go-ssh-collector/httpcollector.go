@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const httpMaxRetries = 5
+
+var (
+	errUnexpectedStatus = errors.New("unexpected status")
+	errTunnelFailed     = errors.New("ssh tunnel failed")
+)
+
+type HTTPEndpointConfig struct {
+	BaseURL string `json:"base_url" binding:"required"`
+	// AuthMethod: none(默认) / basic / bearer / header
+	AuthMethod  string            `json:"auth_method"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	PasswordRef string            `json:"password_ref"`
+	Token       string            `json:"token"`
+	TokenRef    string            `json:"token_ref"`
+	HeaderName  string            `json:"header_name"`
+	Headers     map[string]string `json:"headers"`
+	Timeout     int               `json:"timeout"`
+	SkipVerify  bool              `json:"skip_verify"`
+	CACert      string            `json:"ca_cert"`
+	ServerName  string            `json:"server_name"`
+	// ViaConnectionID 经该SSH连接的direct-tcpip通道访问端点
+	ViaConnectionID string `json:"via_connection_id"`
+
+	// principal 注册端点的调用方，用于检查via连接的ACL
+	principal *Principal
+}
+
+// HTTPConnection 以连接的形式注册到ConnectionManager，/execute 的命令格式为 "METHOD /path"
+type HTTPConnection struct {
+	Config    HTTPEndpointConfig
+	CreatedAt time.Time
+
+	baseURL *url.URL
+	client  *http.Client
+	secret  string
+
+	viaConn  *SSHConnection
+	viaMutex sync.Mutex
+}
+
+// tunnelError 标记经SSH隧道建立连接时的失败，与端点自身的HTTP错误区分
+type tunnelError struct{ err error }
+
+func (e *tunnelError) Error() string { return e.err.Error() }
+func (e *tunnelError) Unwrap() error { return e.err }
+
+// dialVia 通过SSH连接建立通道；父连接变化或断开时丢弃已缓存的空闲连接
+func (hc *HTTPConnection) dialVia(transport *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := collector.getConnection(hc.Config.ViaConnectionID)
+		if err != nil {
+			return nil, &tunnelError{err: err}
+		}
+
+		hc.viaMutex.Lock()
+		if hc.viaConn != conn {
+			hc.viaConn = conn
+			conn.OnClose(transport.CloseIdleConnections)
+		}
+		hc.viaMutex.Unlock()
+
+		channel, err := tunnelDial(conn.Client, network, addr)
+		if err != nil {
+			return nil, &tunnelError{err: err}
+		}
+		return channel, nil
+	}
+}
+
+type HTTPRequest struct {
+	ConnectionID   string            `json:"connection_id" binding:"required"`
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	Query          map[string]string `json:"query"`
+	Headers        map[string]string `json:"headers"`
+	Body           json.RawMessage   `json:"body"`
+	RawBody        string            `json:"raw_body"`
+	ExpectedStatus []int             `json:"expected_status"`
+	// Extract 字段名到JSONPath的映射，如 {"cpu": "$.data.cpu[0].usage"}
+	Extract map[string]string `json:"extract"`
+	Retries int               `json:"retries"`
+	Async   bool              `json:"async"`
+}
+
+type HTTPResult struct {
+	ConnectionID string                 `json:"connection_id"`
+	Method       string                 `json:"method"`
+	URL          string                 `json:"url"`
+	Status       int                    `json:"status"`
+	Headers      map[string]string      `json:"headers"`
+	Body         interface{}            `json:"body"`
+	Truncated    bool                   `json:"truncated,omitempty"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
+	FieldErrors  map[string]string      `json:"field_errors,omitempty"`
+	Latency      float64                `json:"latency_ms"`
+	Attempts     int                    `json:"attempts"`
+	Error        string                 `json:"error,omitempty"`
+	Timestamp    time.Time              `json:"timestamp"`
+}
+
+func newHTTPConnection(config HTTPEndpointConfig) (*HTTPConnection, error) {
+	base, err := url.Parse(config.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid base_url: %s", config.BaseURL)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+
+	var secret string
+	switch config.AuthMethod {
+	case "", "none":
+	case "basic":
+		secret, err = credentialValue(config.Password, config.PasswordRef)
+	case "bearer", "header":
+		secret, err = credentialValue(config.Token, config.TokenRef)
+		if config.AuthMethod == "header" && config.HeaderName == "" {
+			err = errors.New("header_name is required for header auth")
+		}
+	default:
+		err = fmt.Errorf("unsupported auth method: %s", config.AuthMethod)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.SkipVerify, ServerName: config.ServerName}
+	if config.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(config.CACert)) {
+			return nil, errors.New("invalid ca_cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+
+	transport := &http.Transport{
+		DialContext:     safeDialer(timeout).DialContext,
+		TLSClientConfig: tlsConfig,
+	}
+	hc := &HTTPConnection{
+		Config:    config,
+		CreatedAt: time.Now(),
+		baseURL:   base,
+		secret:    secret,
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+	}
+	if config.ViaConnectionID != "" {
+		if _, err := collector.getConnection(config.ViaConnectionID); err != nil {
+			return nil, fmt.Errorf("via_connection_id: %w", err)
+		}
+		if !connectionACLs.Allowed(config.principal, config.ViaConnectionID) {
+			return nil, errViaNotShared
+		}
+		transport.DialContext = hc.dialVia(transport)
+	}
+	return hc, nil
+}
+
+func (hc *HTTPConnection) Info() ConnectionInfo {
+	port, _ := strconv.Atoi(hc.baseURL.Port())
+	if port == 0 {
+		port = 80
+		if hc.baseURL.Scheme == "https" {
+			port = 443
+		}
+	}
+	return ConnectionInfo{
+		Protocol:  "http",
+		Host:      hc.baseURL.Hostname(),
+		Port:      port,
+		Username:  hc.Config.Username,
+		CreatedAt: hc.CreatedAt,
+	}
+}
+
+func (hc *HTTPConnection) resolve(path string, query map[string]string) string {
+	u := *hc.baseURL
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + strings.TrimLeft(path, "/")
+	if i := strings.Index(path, "?"); i >= 0 {
+		u.Path = strings.TrimRight(hc.baseURL.Path, "/") + "/" + strings.TrimLeft(path[:i], "/")
+		u.RawQuery = path[i+1:]
+	}
+	if len(query) > 0 {
+		values := u.Query()
+		for k, v := range query {
+			values.Set(k, v)
+		}
+		u.RawQuery = values.Encode()
+	}
+	return u.String()
+}
+
+func (hc *HTTPConnection) authorize(req *http.Request) {
+	for k, v := range hc.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	switch hc.Config.AuthMethod {
+	case "basic":
+		req.SetBasicAuth(hc.Config.Username, hc.secret)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+hc.secret)
+	case "header":
+		req.Header.Set(hc.Config.HeaderName, hc.secret)
+	}
+}
+
+// retryable 网络错误与5xx/429响应可重试
+func retryable(status int, err error) bool {
+	if err != nil {
+		var destErr *destinationError
+		return !errors.As(err, &destErr) && !errors.Is(err, context.Canceled)
+	}
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// Do 执行请求，按需重试并提取字段
+func (hc *HTTPConnection) Do(ctx context.Context, req HTTPRequest) (*HTTPResult, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	body := []byte(req.RawBody)
+	if len(req.Body) > 0 {
+		body = req.Body
+	}
+	retries := req.Retries
+	if retries > httpMaxRetries {
+		retries = httpMaxRetries
+	}
+
+	result := &HTTPResult{
+		ConnectionID: req.ConnectionID,
+		Method:       method,
+		URL:          hc.resolve(req.Path, req.Query),
+	}
+
+	var resp *http.Response
+	var err error
+	backoff := 500 * time.Millisecond
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		result.Attempts = attempt + 1
+		var httpReq *http.Request
+		httpReq, err = http.NewRequestWithContext(ctx, method, result.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		hc.authorize(httpReq)
+		if id := requestIDFromContext(ctx); id != "" {
+			httpReq.Header.Set(requestIDHeader, id)
+		}
+		if len(req.Body) > 0 {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range req.Headers {
+			httpReq.Header.Set(k, v)
+		}
+
+		resp, err = hc.client.Do(httpReq)
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		if attempt >= retries || !retryable(status, err) {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	result.Timestamp = time.Now()
+	if err != nil {
+		var tunnelErr *tunnelError
+		if errors.As(err, &tunnelErr) {
+			return nil, fmt.Errorf("%w: %v", errTunnelFailed, tunnelErr.err)
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.Headers = make(map[string]string, len(resp.Header))
+	for k := range resp.Header {
+		result.Headers[k] = resp.Header.Get(k)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCommandOutput+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if maxCommandOutput > 0 && int64(len(data)) > maxCommandOutput {
+		data = data[:maxCommandOutput]
+		result.Truncated = true
+	}
+
+	// JSON响应解析为结构化数据，其余按文本返回
+	var doc interface{}
+	if !result.Truncated && json.Unmarshal(data, &doc) == nil {
+		result.Body = doc
+		for name, path := range req.Extract {
+			value, err := jsonPathLookup(doc, path)
+			if err != nil {
+				if result.FieldErrors == nil {
+					result.FieldErrors = make(map[string]string)
+				}
+				result.FieldErrors[name] = err.Error()
+				continue
+			}
+			if result.Fields == nil {
+				result.Fields = make(map[string]interface{})
+			}
+			result.Fields[name] = value
+		}
+	} else {
+		result.Body = string(data)
+		if len(req.Extract) > 0 {
+			result.FieldErrors = map[string]string{"*": "response body is not JSON"}
+		}
+	}
+
+	if !statusExpected(result.Status, req.ExpectedStatus) {
+		result.Error = fmt.Sprintf("%v: %d", errUnexpectedStatus, result.Status)
+	}
+	return result, nil
+}
+
+func statusExpected(status int, expected []int) bool {
+	if len(expected) == 0 {
+		return status < 400
+	}
+	for _, s := range expected {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute 供 /execute 使用，command 形如 "GET /api/status"，省略方法时为GET
+func (hc *HTTPConnection) Execute(shell, command string) (*CommandResult, error) {
+	method, path := http.MethodGet, strings.TrimSpace(command)
+	if fields := strings.Fields(command); len(fields) == 2 {
+		method, path = fields[0], fields[1]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	result, err := hc.Do(ctx, HTTPRequest{Method: method, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return result.commandResult(command), nil
+}
+
+// commandResult 转换为与SSH一致的结果格式，退出码为HTTP状态码
+func (r *HTTPResult) commandResult(command string) *CommandResult {
+	output, ok := r.Body.(string)
+	if !ok {
+		data, _ := json.Marshal(r.Body)
+		output = string(data)
+	}
+	status := r.Status
+	return &CommandResult{
+		Command:   command,
+		Output:    output,
+		ExitCode:  &status,
+		Truncated: r.Truncated,
+		Error:     r.Error,
+		Timestamp: r.Timestamp,
+	}
+}
+
+// HealthCheck 请求基础URL，只要能收到响应即认为可用
+func (hc *HTTPConnection) HealthCheck() error {
+	req, err := http.NewRequest(http.MethodHead, hc.baseURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	hc.authorize(req)
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (hc *HTTPConnection) Close() error {
+	hc.client.CloseIdleConnections()
+	return nil
+}
+
+// jsonPathLookup 支持JSONPath子集: $.a.b、$.a[0]、$['a']、$.a[*].b
+func jsonPathLookup(doc interface{}, path string) (interface{}, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonpath must start with $: %s", path)
+	}
+	tokens, err := jsonPathTokens(path[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	current := []interface{}{doc}
+	wildcard := false
+	for _, token := range tokens {
+		var next []interface{}
+		for _, node := range current {
+			switch {
+			case token == "*":
+				wildcard = true
+				switch v := node.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					for _, item := range v {
+						next = append(next, item)
+					}
+				}
+			case strings.HasPrefix(token, "#"):
+				index, _ := strconv.Atoi(token[1:])
+				if arr, ok := node.([]interface{}); ok {
+					if index < 0 {
+						index += len(arr)
+					}
+					if index >= 0 && index < len(arr) {
+						next = append(next, arr[index])
+					}
+				}
+			default:
+				if obj, ok := node.(map[string]interface{}); ok {
+					if v, exists := obj[token]; exists {
+						next = append(next, v)
+					}
+				}
+			}
+		}
+		current = next
+	}
+
+	if wildcard {
+		return current, nil
+	}
+	if len(current) == 0 {
+		return nil, fmt.Errorf("no match for %s", path)
+	}
+	return current[0], nil
+}
+
+// jsonPathTokens 将路径拆分为键名、"#下标"与"*"
+func jsonPathTokens(path string) ([]string, error) {
+	var tokens []string
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, errors.New("empty key in jsonpath")
+			}
+			tokens = append(tokens, path[:end])
+			path = path[end:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, errors.New("unterminated [ in jsonpath")
+			}
+			inner := strings.TrimSpace(path[1:end])
+			path = path[end+1:]
+			switch {
+			case inner == "*":
+				tokens = append(tokens, "*")
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"'):
+				tokens = append(tokens, inner[1:len(inner)-1])
+			default:
+				if _, err := strconv.Atoi(inner); err != nil {
+					return nil, fmt.Errorf("invalid index %q in jsonpath", inner)
+				}
+				tokens = append(tokens, "#"+inner)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in jsonpath", path[0])
+		}
+	}
+	return tokens, nil
+}
+
+func httpConnection(connectionID string) (*HTTPConnection, error) {
+	conn, err := collector.get(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	hc, ok := conn.(*HTTPConnection)
+	if !ok {
+		return nil, errors.New("connection is not an HTTP endpoint")
+	}
+	return hc, nil
+}
+
+// httpRequestError 区分隧道失败与端点连接失败
+func httpRequestError(c *gin.Context, err error) {
+	errorType := "upstream"
+	var tunnelErr *tunnelError
+	if errors.Is(err, errTunnelFailed) || errors.As(err, &tunnelErr) {
+		errorType = "tunnel"
+	}
+	respondErrorDetails(c, http.StatusBadGateway, err, gin.H{"error_type": errorType})
+}
+
+func registerHTTPRoutes(r *gin.Engine) {
+	// 注册HTTP端点，返回的connection_id可用于 /execute 与 /http/request
+	r.POST("/http/endpoints", func(c *gin.Context) {
+		var config HTTPEndpointConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		p := currentPrincipal(c)
+		config.principal = p
+		hc, err := newHTTPConnection(config)
+		if errors.Is(err, errViaNotShared) {
+			denyRequest(c, p, permExecute, err.Error())
+			return
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+		if err := hc.HealthCheck(); err != nil {
+			httpRequestError(c, withErrorCode(CodeConnectFailed, fmt.Errorf("failed to connect: %w", err)))
+			return
+		}
+
+		connectionID := collector.targetID(hc.Info())
+		if !connectionACLs.Claim(connectionID, p) {
+			hc.Close()
+			denyRequest(c, p, permExecute, fmt.Sprintf("%v: %s", errConnectionOwned, connectionID))
+			return
+		}
+		collector.add(connectionID, hc, connectionMeta{})
+		c.JSON(http.StatusOK, gin.H{
+			"connection_id": connectionID,
+			"status":        "connected",
+			"timestamp":     time.Now(),
+		})
+	})
+
+	// 执行请求，async为true时作为任务在后台执行
+	r.POST("/http/request", func(c *gin.Context) {
+		var req HTTPRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		hc, err := httpConnection(req.ConnectionID)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+
+		run := func(ctx context.Context) (*HTTPResult, error) {
+			result, err := hc.Do(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			publishResult(req.ConnectionID, hc.Info(), result.commandResult(result.Method+" "+req.Path), result.Fields)
+			return result, nil
+		}
+
+		if req.Async {
+			jobID := jobs.Submit(c.Request.Context(), "http_request", req.ConnectionID, nil, func(ctx context.Context) (interface{}, error) {
+				return run(ctx)
+			})
+			c.JSON(http.StatusAccepted, gin.H{"job_id": jobID, "status": JobPending, "timestamp": time.Now()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), commandTimeout)
+		defer cancel()
+		result, err := run(ctx)
+		if err != nil {
+			httpRequestError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
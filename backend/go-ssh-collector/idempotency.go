@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 幂等键：建立连接和提交执行/任务的接口支持Idempotency-Key请求头。
+// 同一调用方使用相同的键和请求体重试时返回首次的响应（带 Idempotent-Replayed: true），
+// 请求体不同时返回409；并发的重复请求等待首次请求完成后共享其响应。
+// 5xx、429和超过IDEMPOTENCY_MAX_BODY_BYTES的响应不缓存，之后的重试会重新执行；
+// 超过上限的响应不保留副本，等待中的重复请求收到409，需要重试。
+// 启用结果存储（RESULT_STORE）时响应经后台队列写入idempotency_keys表，其它副本和重启后也能重放；
+// 同时到达不同副本的重复请求仍可能各执行一次
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotencyPruneInterval 清理结果存储中过期键的最小间隔
+const idempotencyPruneInterval = time.Minute
+
+var (
+	idempotencyTTL          = time.Duration(envInt64("IDEMPOTENCY_TTL_SECONDS", 86400)) * time.Second
+	idempotencyMaxEntries   = int(envInt64("IDEMPOTENCY_MAX_ENTRIES", 10000))
+	idempotencyMaxBodyBytes = envInt64("IDEMPOTENCY_MAX_BODY_BYTES", 1<<20)
+)
+
+// 支持幂等键的接口：建立连接和提交执行；提交任务的是/execute/bulk、/http/request（async）和files/fetch。
+// 文件上传（files/upload、FTP上传）的请求体是文件内容，计算指纹需要整个读入内存，不支持；
+// 异步导出是GET请求，脚本只有无副作用的/scripts/test，都不需要幂等键
+var idempotentRoutes = map[string]bool{
+	"POST /connect":                     true,
+	"POST /http/endpoints":              true,
+	"POST /execute":                     true,
+	"POST /execute/bulk":                true,
+	"POST /http/request":                true,
+	"POST /connections/:id/files/fetch": true,
+}
+
+var (
+	errIdempotencyKeyReused      = errors.New("idempotency key was used with a different request")
+	errIdempotentResponseTooLong = errors.New("the original response exceeded IDEMPOTENCY_MAX_BODY_BYTES and cannot be replayed; retry the request")
+)
+
+type idempotentResponse struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+
+	// done 在首次请求完成后关闭，从结果存储加载的记录为nil
+	done chan struct{}
+	// overflow 响应超过IDEMPOTENCY_MAX_BODY_BYTES，没有保留Body
+	overflow bool
+}
+
+type IdempotencyCache struct {
+	entries map[string]*idempotentResponse
+	mutex   sync.Mutex
+}
+
+var idempotency = newIdempotencyCache()
+
+func newIdempotencyCache() *IdempotencyCache {
+	return &IdempotencyCache{entries: make(map[string]*idempotentResponse)}
+}
+
+// begin 返回已有记录及其完成信号（已完成时为nil），没有时登记新的进行中记录并返回nil。
+// 本地没有的键在结果存储中查找，查询期间不持有锁
+func (ic *IdempotencyCache) begin(ctx context.Context, key, fingerprint string) (*idempotentResponse, <-chan struct{}) {
+	ic.mutex.Lock()
+	entry, done := ic.get(key, time.Now())
+	ic.mutex.Unlock()
+	if entry != nil {
+		return entry, done
+	}
+	stored, err := resultStore.loadIdempotent(ctx, key)
+	if err != nil {
+		logWarn("idempotency.load_failed", "load idempotency key failed", "error", redact(err.Error()))
+	}
+
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	now := time.Now()
+	if entry, done := ic.get(key, now); entry != nil {
+		return entry, done
+	}
+	ic.evict(now)
+	if stored != nil {
+		ic.entries[key] = stored
+		return stored, nil
+	}
+	ic.entries[key] = &idempotentResponse{
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idempotencyTTL),
+		done:        make(chan struct{}),
+	}
+	return nil, nil
+}
+
+// get 返回未过期的记录及其完成信号，调用方需持有锁
+func (ic *IdempotencyCache) get(key string, now time.Time) (*idempotentResponse, <-chan struct{}) {
+	entry, ok := ic.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if entry.done == nil && now.After(entry.ExpiresAt) {
+		delete(ic.entries, key)
+		return nil, nil
+	}
+	return entry, entry.done
+}
+
+// evict 删除过期记录，仍超出上限时删除最早完成的记录，调用方需持有锁
+func (ic *IdempotencyCache) evict(now time.Time) {
+	if len(ic.entries) < idempotencyMaxEntries {
+		return
+	}
+	var oldestKey string
+	var oldest *idempotentResponse
+	for key, entry := range ic.entries {
+		if entry.done != nil {
+			continue
+		}
+		if now.After(entry.ExpiresAt) {
+			delete(ic.entries, key)
+			continue
+		}
+		if oldest == nil || entry.CreatedAt.Before(oldest.CreatedAt) {
+			oldestKey, oldest = key, entry
+		}
+	}
+	if len(ic.entries) >= idempotencyMaxEntries && oldest != nil {
+		delete(ic.entries, oldestKey)
+	}
+}
+
+// finish 记录首次请求的响应并唤醒等待者，不可缓存的响应在唤醒后删除，
+// 可缓存的响应放入结果存储的写入队列
+func (ic *IdempotencyCache) finish(key string, status int, contentType string, body []byte, overflow bool) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+
+	entry, ok := ic.entries[key]
+	if !ok || entry.done == nil {
+		return
+	}
+	entry.Status, entry.ContentType, entry.Body, entry.overflow = status, contentType, body, overflow
+	close(entry.done)
+	entry.done = nil
+	if overflow || status >= 500 || status == http.StatusTooManyRequests {
+		delete(ic.entries, key)
+		return
+	}
+	resultStore.enqueueIdempotent(key, entry)
+}
+
+// loadIdempotent 读取未过期的已完成响应，存储未启用或没有记录时返回nil
+func (s *ResultStore) loadIdempotent(ctx context.Context, key string) (*idempotentResponse, error) {
+	if s == nil {
+		return nil, nil
+	}
+	entry := &idempotentResponse{}
+	err := s.reader.QueryRowContext(ctx, `SELECT fingerprint, status, content_type, body, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1 AND expires_at > $2`, key, time.Now().UTC()).
+		Scan(&entry.Fingerprint, &entry.Status, &entry.ContentType, &entry.Body, &entry.CreatedAt, &entry.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// storedIdempotent 等待写入的幂等键，entry完成后不再修改
+type storedIdempotent struct {
+	key   string
+	entry *idempotentResponse
+}
+
+// enqueueIdempotent 与Enqueue相同，队列满时丢弃并计数，不阻塞请求
+func (s *ResultStore) enqueueIdempotent(key string, entry *idempotentResponse) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.idempotent <- storedIdempotent{key, entry}:
+	default:
+		atomic.AddUint64(&s.idempotentDropped, 1)
+	}
+}
+
+// runIdempotent 逐条写入幂等键，每隔idempotencyPruneInterval删除过期的键
+func (s *ResultStore) runIdempotent() {
+	var pruned time.Time
+	for item := range s.idempotent {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.saveIdempotent(ctx, item.key, item.entry); err != nil {
+			atomic.AddUint64(&s.idempotentFailed, 1)
+			logWarn("idempotency.save_failed", "save idempotency key failed", "error", redact(err.Error()))
+		}
+		if now := time.Now(); now.Sub(pruned) >= idempotencyPruneInterval {
+			pruned = now
+			if err := s.pruneIdempotent(ctx, now); err != nil {
+				logWarn("idempotency.prune_failed", "prune idempotency keys failed", "error", redact(err.Error()))
+			}
+		}
+		cancel()
+	}
+}
+
+func (s *ResultStore) saveIdempotent(ctx context.Context, key string, entry *idempotentResponse) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO idempotency_keys
+		(key, fingerprint, status, content_type, body, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (key) DO UPDATE SET fingerprint = excluded.fingerprint, status = excluded.status,
+			content_type = excluded.content_type, body = excluded.body,
+			created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		key, entry.Fingerprint, entry.Status, entry.ContentType, entry.Body, entry.CreatedAt.UTC(), entry.ExpiresAt.UTC())
+	return err
+}
+
+func (s *ResultStore) pruneIdempotent(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now.UTC())
+	return err
+}
+
+// recordingWriter 在写出响应的同时保留一份副本，超过limit后丢弃副本并标记overflow
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+// fits 副本还能容纳n字节时返回true
+func (w *recordingWriter) fits(n int) bool {
+	if !w.overflow && int64(w.body.Len()+n) > w.limit {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+	}
+	return !w.overflow
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.fits(len(data)) {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	if w.fits(len(s)) {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// replayIdempotentResponse 重放首次的响应，没有保留副本时返回409
+func replayIdempotentResponse(c *gin.Context, entry *idempotentResponse) {
+	if entry.overflow {
+		respondError(c, http.StatusConflict, errIdempotentResponseTooLong)
+		return
+	}
+	c.Header(idempotencyReplayedHeader, "true")
+	c.Data(entry.Status, entry.ContentType, entry.Body)
+	c.Abort()
+}
+
+// idempotencyMiddleware 在认证和RBAC之后注册，键按调用方隔离，被拒绝的请求不占用键
+func idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" || !idempotentRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			respondError(c, http.StatusBadRequest, errors.New("Idempotency-Key must not exceed 255 characters"))
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			data, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+			body = data
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		sum := sha256.New()
+		sum.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		// 结果存储中只保存键的摘要
+		ownerKey := sha256.Sum256([]byte(principalIdentity(currentPrincipal(c)) + "\x00" + key))
+		cacheKey := hex.EncodeToString(ownerKey[:])
+
+		if entry, done := idempotency.begin(c.Request.Context(), cacheKey, fingerprint); entry != nil {
+			if entry.Fingerprint != fingerprint {
+				respondError(c, http.StatusConflict, errIdempotencyKeyReused)
+				return
+			}
+			if done != nil {
+				select {
+				case <-done:
+				case <-c.Request.Context().Done():
+					c.Abort()
+					return
+				}
+			}
+			replayIdempotentResponse(c, entry)
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer, limit: idempotencyMaxBodyBytes}
+		c.Writer = writer
+		completed := false
+		defer func() {
+			// panic时尚未写出响应，按500释放键
+			if !completed {
+				idempotency.finish(cacheKey, http.StatusInternalServerError, "", nil, false)
+			}
+		}()
+		c.Next()
+		completed = true
+		idempotency.finish(cacheKey, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes(), writer.overflow)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func useIdempotencyCache(t *testing.T) *IdempotencyCache {
+	t.Helper()
+	previous := idempotency
+	idempotency = newIdempotencyCache()
+	t.Cleanup(func() { idempotency = previous })
+	return idempotency
+}
+
+// idempotentRouter 只注册幂等中间件和一个计数的/execute处理函数
+func idempotentRouter(handler gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(idempotencyMiddleware())
+	r.POST("/execute", handler)
+	return r
+}
+
+func idempotentRequest(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyCoalescesConcurrentDuplicates(t *testing.T) {
+	useIdempotencyCache(t)
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	r := idempotentRouter(func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		c.JSON(http.StatusOK, gin.H{"call": atomic.LoadInt32(&calls)})
+	})
+
+	first := make(chan *httptest.ResponseRecorder, 1)
+	go func() { first <- idempotentRequest(r, "k1", `{"command":"uptime"}`) }()
+	<-started
+
+	// 首次请求仍在执行，重复请求等待其完成
+	const duplicates = 5
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, duplicates)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = idempotentRequest(r, "k1", `{"command":"uptime"}`)
+		}(i)
+	}
+	// 请求体不同时立即返回409，不等待首次请求
+	if w := idempotentRequest(r, "k1", `{"command":"reboot"}`); w.Code != http.StatusConflict {
+		t.Fatalf("different body while in flight: status %d", w.Code)
+	}
+	close(release)
+	wg.Wait()
+
+	original := <-first
+	if original.Code != http.StatusOK || original.Header().Get(idempotencyReplayedHeader) != "" {
+		t.Fatalf("first: status %d, headers %v", original.Code, original.Header())
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusOK || w.Header().Get(idempotencyReplayedHeader) != "true" || w.Body.String() != original.Body.String() {
+			t.Errorf("duplicate %d: status %d, headers %v, body %s", i, w.Code, w.Header(), w.Body.String())
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	useIdempotencyCache(t)
+	var calls int32
+	r := idempotentRouter(func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	if w := idempotentRequest(r, "k1", `{"command":"uptime"}`); w.Code != http.StatusOK {
+		t.Fatalf("first: status %d", w.Code)
+	}
+	w := idempotentRequest(r, "k1", `{"command":"reboot"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409: %s", w.Code, w.Body.String())
+	}
+	if apiErr := decodeAPIError(t, w); apiErr.Code != "IDEMPOTENCY_KEY_REUSED" {
+		t.Fatalf("code = %q", apiErr.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyDoesNotCacheServerErrors(t *testing.T) {
+	useIdempotencyCache(t)
+	var calls int32
+	r := idempotentRouter(func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	if w := idempotentRequest(r, "k1", "{}"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first: status %d", w.Code)
+	}
+	if w := idempotentRequest(r, "k1", "{}"); w.Code != http.StatusOK || w.Header().Get(idempotencyReplayedHeader) != "" {
+		t.Fatalf("retry: status %d, headers %v", w.Code, w.Header())
+	}
+}
+
+// useIdempotentWriter 在后台消费幂等键写入队列，结束时停止
+func useIdempotentWriter(t *testing.T, store *ResultStore) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.runIdempotent()
+	}()
+	t.Cleanup(func() {
+		close(store.idempotent)
+		<-done
+	})
+}
+
+func storedIdempotencyKeys(t *testing.T, store *ResultStore) int {
+	t.Helper()
+	var rows int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// 响应经后台队列保存在结果存储中，新的缓存（重启或其它副本）仍重放响应并校验请求体
+func TestIdempotencyPersistsInResultStore(t *testing.T) {
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	useIdempotentWriter(t, store)
+	useIdempotencyCache(t)
+	var calls int32
+	r := idempotentRouter(func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	if w := idempotentRequest(r, "k1", `{"command":"uptime"}`); w.Code != http.StatusCreated {
+		t.Fatalf("first: status %d", w.Code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); storedIdempotencyKeys(t, store) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("idempotency key was not saved")
+		}
+	}
+	useIdempotencyCache(t)
+	w := idempotentRequest(r, "k1", `{"command":"uptime"}`)
+	if w.Code != http.StatusCreated || w.Header().Get(idempotencyReplayedHeader) != "true" || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("after restart: status %d, headers %v, body %s", w.Code, w.Header(), w.Body.String())
+	}
+	useIdempotencyCache(t)
+	if w := idempotentRequest(r, "k1", `{"command":"reboot"}`); w.Code != http.StatusConflict {
+		t.Fatalf("different body after restart: status %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+
+	// 过期的键不再重放，清理时删除
+	if _, err := store.db.Exec(`UPDATE idempotency_keys SET expires_at = $1`, time.Now().Add(-time.Minute).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	useIdempotencyCache(t)
+	if w := idempotentRequest(r, "k1", `{"command":"uptime"}`); w.Header().Get(idempotencyReplayedHeader) != "" {
+		t.Fatal("expired key replayed")
+	}
+	if err := store.pruneIdempotent(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var rows int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys WHERE expires_at <= $1`, time.Now().UTC()).Scan(&rows); err != nil || rows != 0 {
+		t.Fatalf("expired rows after prune: %d, %v", rows, err)
+	}
+}
+
+// 结果存储变慢时请求不等待写入，队列满时丢弃
+func TestIdempotencySaveDoesNotBlockRequests(t *testing.T) {
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	store.idempotent = make(chan storedIdempotent, 1)
+	useIdempotencyCache(t)
+	r := idempotentRouter(func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	// 没有消费者，第二个键起队列已满
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, key := range []string{"k1", "k2", "k3"} {
+			idempotentRequest(r, key, "{}")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests blocked on the result store")
+	}
+	if n := atomic.LoadUint64(&store.idempotentDropped); n != 2 {
+		t.Fatalf("dropped %d idempotency keys, want 2", n)
+	}
+}
+
+// 超过IDEMPOTENCY_MAX_BODY_BYTES的响应照常返回但不保存，之后的重试重新执行
+func TestIdempotencySkipsOversizedResponses(t *testing.T) {
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	useIdempotentWriter(t, store)
+	useIdempotencyCache(t)
+	previous := idempotencyMaxBodyBytes
+	idempotencyMaxBodyBytes = 64
+	t.Cleanup(func() { idempotencyMaxBodyBytes = previous })
+	var calls int32
+	r := idempotentRouter(func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		// 分两次写出，第二次超过上限
+		c.Writer.WriteString(strings.Repeat("x", 50))
+		c.Writer.WriteString(strings.Repeat("y", 50))
+	})
+
+	for i := 0; i < 2; i++ {
+		w := idempotentRequest(r, "big", "{}")
+		if w.Code != http.StatusOK || w.Body.Len() != 100 || w.Header().Get(idempotencyReplayedHeader) != "" {
+			t.Fatalf("request %d: status %d, %d bytes, headers %v", i, w.Code, w.Body.Len(), w.Header())
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("handler ran %d times, want 2", n)
+	}
+	if rows := storedIdempotencyKeys(t, store); rows != 0 {
+		t.Fatalf("oversized response stored: %d rows", rows)
+	}
+}
+
+// 首次响应没有保留副本时，等待中的重复请求收到409而不是空的响应
+func TestIdempotencyOversizedResponseNotReplayedToWaiters(t *testing.T) {
+	cache := useIdempotencyCache(t)
+	ctx := context.Background()
+	if entry, _ := cache.begin(ctx, "big", "fp"); entry != nil {
+		t.Fatal("new key already registered")
+	}
+	waiting, done := cache.begin(ctx, "big", "fp")
+	if waiting == nil || done == nil {
+		t.Fatal("duplicate did not wait for the first request")
+	}
+	cache.finish("big", http.StatusOK, "text/plain", nil, true)
+	<-done
+	if !waiting.overflow {
+		t.Fatal("waiter not told that the response was not kept")
+	}
+	if entry, _ := cache.begin(ctx, "big", "fp"); entry != nil {
+		t.Fatal("oversized response kept in the cache")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/execute", nil)
+	replayIdempotentResponse(c, waiting)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("waiter: status %d, want 409: %s", w.Code, w.Body.String())
+	}
+}
+
+// 副本超过上限后丢弃，不再继续缓存
+func TestRecordingWriterStopsAtLimit(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	w := &recordingWriter{ResponseWriter: c.Writer, limit: 8}
+	w.Write([]byte("12345"))
+	if w.overflow || w.body.String() != "12345" {
+		t.Fatalf("under the limit: overflow %v, body %q", w.overflow, w.body.String())
+	}
+	w.WriteString("6789")
+	w.Write([]byte("0"))
+	if !w.overflow || w.body.Len() != 0 || w.Size() != 10 {
+		t.Fatalf("over the limit: overflow %v, kept %d bytes, wrote %d", w.overflow, w.body.Len(), w.Size())
+	}
+}
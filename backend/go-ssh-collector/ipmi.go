@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ipmitoolPath = getEnv("IPMITOOL_PATH", "ipmitool")
+
+type IPMITarget struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PasswordRef string `json:"password_ref"`
+	// Interface: lanplus(默认) / lan
+	Interface string `json:"interface"`
+	Timeout   int    `json:"timeout"`
+}
+
+type IPMIRequest struct {
+	IPMITarget
+	// BMCID 引用已注册的BMC，设置后忽略请求中的目标参数
+	BMCID string `json:"bmc_id"`
+}
+
+// IPMIError 带错误分类；解析失败时附带ipmitool原始输出
+type IPMIError struct {
+	Class     string
+	Err       error
+	RawOutput string
+}
+
+func (e *IPMIError) Error() string { return e.Err.Error() }
+func (e *IPMIError) Unwrap() error { return e.Err }
+
+type IPMISensor struct {
+	Name       string             `json:"name"`
+	Value      *float64           `json:"value"`
+	RawValue   string             `json:"raw_value,omitempty"`
+	Unit       string             `json:"unit"`
+	State      string             `json:"state"`
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+}
+
+type IPMISELEntry struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Sensor    string `json:"sensor"`
+	Event     string `json:"event"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// 阈值列顺序与 ipmitool sensor list 输出一致
+var ipmiThresholdColumns = []string{
+	"lower_non_recoverable",
+	"lower_critical",
+	"lower_non_critical",
+	"upper_non_critical",
+	"upper_critical",
+	"upper_non_recoverable",
+}
+
+var ipmiStates = map[string]string{
+	"ok": "ok",
+	"nc": "non_critical",
+	"cr": "critical",
+	"nr": "non_recoverable",
+	"ns": "not_available",
+	"na": "not_available",
+}
+
+func parseIPMIFloat(s string) (*float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "na" {
+		return nil, true
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &v, true
+}
+
+// parseSensorList 解析 `ipmitool sensor list` 的竖线分隔输出
+func parseSensorList(output string) ([]IPMISensor, error) {
+	sensors := []IPMISensor{}
+	for i, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		cols := strings.Split(line, "|")
+		if len(cols) < 4 {
+			return nil, fmt.Errorf("unexpected sensor line %d: %q", i+1, line)
+		}
+		for j := range cols {
+			cols[j] = strings.TrimSpace(cols[j])
+		}
+
+		sensor := IPMISensor{
+			Name:  cols[0],
+			Unit:  cols[2],
+			State: cols[3],
+		}
+		if state, ok := ipmiStates[cols[3]]; ok {
+			sensor.State = state
+		}
+		value, ok := parseIPMIFloat(cols[1])
+		if ok {
+			sensor.Value = value
+		} else {
+			// 离散传感器的值为十六进制状态位，例如 0x0180
+			sensor.RawValue = cols[1]
+		}
+		for j, name := range ipmiThresholdColumns {
+			if 4+j >= len(cols) {
+				break
+			}
+			if v, ok := parseIPMIFloat(cols[4+j]); ok && v != nil {
+				if sensor.Thresholds == nil {
+					sensor.Thresholds = map[string]float64{}
+				}
+				sensor.Thresholds[name] = *v
+			}
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors, nil
+}
+
+// parseSELList 解析 `ipmitool sel elist` 输出: id | date | time | sensor | event | direction
+func parseSELList(output string) ([]IPMISELEntry, error) {
+	entries := []IPMISELEntry{}
+	for i, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "SEL has no entries") {
+			continue
+		}
+		cols := strings.Split(line, "|")
+		if len(cols) < 5 {
+			return nil, fmt.Errorf("unexpected sel line %d: %q", i+1, line)
+		}
+		for j := range cols {
+			cols[j] = strings.TrimSpace(cols[j])
+		}
+
+		entry := IPMISELEntry{
+			ID:        cols[0],
+			Timestamp: cols[1] + " " + cols[2],
+			Sensor:    cols[3],
+			Event:     cols[4],
+		}
+		if len(cols) > 5 {
+			entry.Direction = strings.ToLower(cols[5])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// classifyIPMIError 根据ipmitool的错误输出区分超时与认证失败
+func classifyIPMIError(ctx context.Context, err error, stderr string) *IPMIError {
+	class := "tool_error"
+	lower := strings.ToLower(stderr)
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		class = "tool_unavailable"
+	case ctx.Err() == context.DeadlineExceeded,
+		strings.Contains(lower, "timeout"),
+		strings.Contains(lower, "no response"):
+		class = "timeout"
+	case strings.Contains(lower, "rakp"),
+		strings.Contains(lower, "unauthorized"),
+		strings.Contains(lower, "invalid user"),
+		strings.Contains(lower, "password"),
+		strings.Contains(lower, "insufficient privilege"):
+		class = "auth_failed"
+	}
+
+	msg := strings.TrimSpace(stderr)
+	if msg == "" {
+		msg = err.Error()
+	}
+	return &IPMIError{Class: class, Err: fmt.Errorf("ipmitool failed: %s", msg), RawOutput: stderr}
+}
+
+// runIPMITool 执行ipmitool，密码通过环境变量传递避免出现在进程列表中
+func runIPMITool(target IPMITarget, args ...string) (string, error) {
+	if target.Host == "" || target.Username == "" {
+		return "", fmt.Errorf("host and username are required")
+	}
+	password, err := credentialValue(target.Password, target.PasswordRef)
+	if err != nil {
+		return "", err
+	}
+
+	iface := target.Interface
+	if iface == "" {
+		iface = "lanplus"
+	}
+	if iface != "lanplus" && iface != "lan" {
+		return "", fmt.Errorf("unsupported interface: %s", iface)
+	}
+	port := target.Port
+	if port == 0 {
+		port = 623
+	}
+	timeout := 30 * time.Second
+	if target.Timeout > 0 {
+		timeout = time.Duration(target.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// ipmitool自行连接，先在本地解析并校验，再把IP传给它
+	ip, err := checkDestinationHost(ctx, target.Host)
+	if err != nil {
+		return "", err
+	}
+	host := ip.String()
+
+	cmdArgs := append([]string{
+		"-I", iface,
+		"-H", host,
+		"-p", strconv.Itoa(port),
+		"-U", target.Username,
+		"-E",
+	}, args...)
+	cmd := exec.CommandContext(ctx, ipmitoolPath, cmdArgs...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+password)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", classifyIPMIError(ctx, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+type IPMIRegistry struct {
+	targets map[string]IPMITarget
+	mutex   sync.RWMutex
+}
+
+var ipmiBMCs = &IPMIRegistry{targets: make(map[string]IPMITarget)}
+
+func (r *IPMIRegistry) resolve(req IPMIRequest) (IPMITarget, error) {
+	if req.BMCID == "" {
+		return req.IPMITarget, nil
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	target, ok := r.targets[req.BMCID]
+	if !ok {
+		return IPMITarget{}, errBMCNotFound
+	}
+	return target, nil
+}
+
+// respondIPMIError details中带error_class和ipmitool原始输出
+func respondIPMIError(c *gin.Context, err error) {
+	var ipmiErr *IPMIError
+	if !errors.As(err, &ipmiErr) {
+		if errors.Is(err, errBMCNotFound) {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	details := gin.H{"error_class": ipmiErr.Class}
+	if ipmiErr.RawOutput != "" {
+		details["raw_output"] = ipmiErr.RawOutput
+	}
+	switch ipmiErr.Class {
+	case "timeout":
+		respondErrorDetails(c, http.StatusGatewayTimeout, err, details)
+	case "auth_failed":
+		respondErrorDetails(c, http.StatusUnauthorized, withErrorCode(CodeDeviceAuthFailed, err), details)
+	default:
+		respondErrorDetails(c, http.StatusBadGateway, err, details)
+	}
+}
+
+func registerIPMIRoutes(r *gin.Engine) {
+	// 注册BMC，后续请求通过bmc_id引用
+	r.POST("/ipmi/bmcs", func(c *gin.Context) {
+		var target IPMITarget
+		if err := c.ShouldBindJSON(&target); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if target.Host == "" || target.Username == "" {
+			respondError(c, http.StatusBadRequest, errors.New("host and username are required"))
+			return
+		}
+
+		id := fmt.Sprintf("ipmi:%s:%s", target.Host, target.Username)
+		ipmiBMCs.mutex.Lock()
+		ipmiBMCs.targets[id] = target
+		ipmiBMCs.mutex.Unlock()
+
+		c.JSON(http.StatusOK, gin.H{
+			"bmc_id":  id,
+			"message": "BMC registered",
+		})
+	})
+
+	r.DELETE("/ipmi/bmcs/:id", func(c *gin.Context) {
+		ipmiBMCs.mutex.Lock()
+		_, ok := ipmiBMCs.targets[c.Param("id")]
+		delete(ipmiBMCs.targets, c.Param("id"))
+		ipmiBMCs.mutex.Unlock()
+
+		if !ok {
+			respondError(c, http.StatusNotFound, errBMCNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "BMC removed"})
+	})
+
+	// 传感器读数
+	r.POST("/ipmi/sensors", func(c *gin.Context) {
+		var req IPMIRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		target, err := ipmiBMCs.resolve(req)
+		if err != nil {
+			respondIPMIError(c, err)
+			return
+		}
+
+		output, err := runIPMITool(target, "sensor", "list")
+		if err != nil {
+			respondIPMIError(c, err)
+			return
+		}
+		sensors, err := parseSensorList(output)
+		if err != nil {
+			respondIPMIError(c, &IPMIError{Class: "parse_error", Err: err, RawOutput: output})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"host":      target.Host,
+			"sensors":   sensors,
+			"timestamp": time.Now(),
+		})
+	})
+
+	// 系统事件日志
+	r.POST("/ipmi/sel", func(c *gin.Context) {
+		var req IPMIRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		target, err := ipmiBMCs.resolve(req)
+		if err != nil {
+			respondIPMIError(c, err)
+			return
+		}
+
+		output, err := runIPMITool(target, "sel", "elist")
+		if err != nil {
+			respondIPMIError(c, err)
+			return
+		}
+		entries, err := parseSELList(output)
+		if err != nil {
+			respondIPMIError(c, &IPMIError{Class: "parse_error", Err: err, RawOutput: output})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"host":      target.Host,
+			"entries":   entries,
+			"timestamp": time.Now(),
+		})
+	})
+}
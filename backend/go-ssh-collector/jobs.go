@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// 进度事件的最小推送间隔
+const progressEventInterval = time.Second
+
+// 已结束的任务保留 JOB_RETENTION 秒，最多保留 JOB_MAX_FINISHED 个，超出时先删除最早结束的
+var (
+	jobRetention   = time.Duration(envInt64("JOB_RETENTION", 3600)) * time.Second
+	jobMaxFinished = int(envInt64("JOB_MAX_FINISHED", 1000))
+)
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobFinished = errors.New("job already finished")
+	errJobNotOwned = errors.New("job not owned by caller")
+)
+
+// TransferProgress 记录传输进度，可被多个goroutine并发读取。
+// 续传时resumedFrom为之前已完成的字节数，不计入本次吞吐量
+type TransferProgress struct {
+	done        int64
+	total       int64
+	resumedFrom int64
+	startedAt   int64
+	finishedAt  int64
+}
+
+func (p *TransferProgress) Add(n int64)      { atomic.AddInt64(&p.done, n) }
+func (p *TransferProgress) SetTotal(n int64) { atomic.StoreInt64(&p.total, n) }
+
+func (p *TransferProgress) SetResumedFrom(n int64) {
+	atomic.StoreInt64(&p.resumedFrom, n)
+}
+
+func (p *TransferProgress) start() { atomic.StoreInt64(&p.startedAt, time.Now().UnixNano()) }
+func (p *TransferProgress) stop()  { atomic.StoreInt64(&p.finishedAt, time.Now().UnixNano()) }
+
+func (p *TransferProgress) Snapshot() gin.H {
+	done := atomic.LoadInt64(&p.done)
+	total := atomic.LoadInt64(&p.total)
+	resumedFrom := atomic.LoadInt64(&p.resumedFrom)
+
+	snapshot := gin.H{
+		"bytes_done":        done,
+		"bytes_total":       total,
+		"bytes_transferred": done - resumedFrom,
+		"resumed_from":      resumedFrom,
+	}
+	if total > 0 {
+		snapshot["percent"] = float64(done) * 100 / float64(total)
+	}
+
+	startedAt := atomic.LoadInt64(&p.startedAt)
+	if startedAt == 0 {
+		return snapshot
+	}
+	finishedAt := atomic.LoadInt64(&p.finishedAt)
+	end := time.Now()
+	if finishedAt != 0 {
+		end = time.Unix(0, finishedAt)
+	}
+	elapsed := end.Sub(time.Unix(0, startedAt)).Seconds()
+	snapshot["elapsed_seconds"] = elapsed
+
+	if elapsed > 0 {
+		throughput := float64(done-resumedFrom) / elapsed
+		snapshot["throughput_bps"] = throughput
+		if finishedAt == 0 && total > done && throughput > 0 {
+			snapshot["eta_seconds"] = float64(total-done) / throughput
+		}
+	}
+	return snapshot
+}
+
+type Job struct {
+	ID           string
+	RequestID    string
+	Type         string
+	ConnectionID string
+	// Owner 提交任务的调用方标识（Principal.Identity），未启用认证时为空
+	Owner      string
+	Status     string
+	Result     interface{}
+	Error      string
+	ErrorClass string
+	Progress   *TransferProgress
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	cancel context.CancelFunc
+}
+
+type JobEvent struct {
+	Type      string    `json:"type"`
+	JobID     string    `json:"job_id"`
+	Job       gin.H     `json:"job"`
+	Timestamp time.Time `json:"timestamp"`
+
+	owner string
+}
+
+type JobManager struct {
+	jobs  map[string]*Job
+	mutex sync.RWMutex
+
+	retention   time.Duration
+	maxFinished int
+
+	subscribers map[chan JobEvent]struct{}
+	subMutex    sync.Mutex
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs:        make(map[string]*Job),
+		retention:   jobRetention,
+		maxFinished: jobMaxFinished,
+		subscribers: make(map[chan JobEvent]struct{}),
+	}
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Submit 创建任务并在后台执行fn，返回任务ID。取消任务时ctx被取消，
+// ctx中带提交请求（parent）的ID，任务的span是提交请求span的子span
+func (jm *JobManager) Submit(parent context.Context, jobType, connectionID string, progress *TransferProgress, fn func(ctx context.Context) (interface{}, error)) string {
+	requestID := requestIDFromContext(parent)
+	owner := principalIdentity(contextPrincipal(parent))
+	ctx, cancel := context.WithCancel(withSpanFrom(withRequestID(context.Background(), requestID), parent))
+	job := &Job{
+		ID:           newID(),
+		RequestID:    requestID,
+		Type:         jobType,
+		ConnectionID: connectionID,
+		Status:       JobPending,
+		Owner:        owner,
+		Progress:     progress,
+		CreatedAt:    time.Now(),
+		cancel:       cancel,
+	}
+
+	jm.mutex.Lock()
+	jm.jobs[job.ID] = job
+	jm.mutex.Unlock()
+
+	ctx, span := startSpan(ctx, "job "+jobType, "job.id", job.ID, "job.type", jobType, "connection_id", connectionID)
+	go func() {
+		defer cancel()
+
+		if progress != nil {
+			progress.start()
+			go jm.reportProgress(ctx, job)
+		}
+		jm.setStatus(job, JobRunning, nil, nil)
+
+		started := time.Now()
+		result, err := fn(ctx)
+		if progress != nil {
+			progress.stop()
+		}
+		observeSlowJob(job, time.Since(started), err)
+
+		// fn已成功返回时即使随后被取消也记为完成
+		switch {
+		case err == nil:
+			jm.setStatus(job, JobCompleted, result, nil)
+			span.SetAttributes("job.status", JobCompleted)
+			span.End(nil)
+		case errors.Is(ctx.Err(), context.Canceled):
+			jm.setStatus(job, JobCancelled, nil, ctx.Err())
+			span.SetAttributes("job.status", JobCancelled)
+			span.End(ctx.Err())
+		default:
+			jm.setStatus(job, JobFailed, nil, err)
+			span.SetAttributes("job.status", JobFailed)
+			span.End(err)
+		}
+	}()
+
+	return job.ID
+}
+
+// reportProgress 按固定间隔推送进度事件，避免每次写入都产生事件
+func (jm *JobManager) reportProgress(ctx context.Context, job *Job) {
+	ticker := time.NewTicker(progressEventInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			jm.mutex.RLock()
+			view := job.view()
+			jm.mutex.RUnlock()
+			jm.publish(JobEvent{Type: "job_progress", JobID: job.ID, Job: view, Timestamp: time.Now(), owner: job.Owner})
+		}
+	}
+}
+
+func (jm *JobManager) setStatus(job *Job, status string, result interface{}, err error) {
+	jm.mutex.Lock()
+	job.Status = status
+	switch status {
+	case JobRunning:
+		job.StartedAt = time.Now()
+	case JobCompleted, JobFailed, JobCancelled:
+		job.FinishedAt = time.Now()
+		job.Result = result
+		if err != nil {
+			job.Error = redact(err.Error())
+			job.ErrorClass = errorClass(err)
+		}
+	}
+	view := job.view()
+	if job.finished() {
+		jm.prune(job.FinishedAt)
+	}
+	jm.mutex.Unlock()
+
+	jm.publish(JobEvent{Type: "job_state_changed", JobID: job.ID, Job: view, Timestamp: time.Now(), owner: job.Owner})
+	events.Publish(LifecycleEvent{Type: eventJobStateChanged, ConnectionID: job.ConnectionID,
+		Namespace: collector.namespace(job.ConnectionID), RequestID: job.RequestID, Data: view})
+}
+
+// Stats 各状态的任务数
+func (jm *JobManager) Stats() map[string]int {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+	stats := map[string]int{JobPending: 0, JobRunning: 0, JobCompleted: 0, JobFailed: 0, JobCancelled: 0}
+	for _, job := range jm.jobs {
+		stats[job.Status]++
+	}
+	return stats
+}
+
+func (job *Job) finished() bool {
+	return job.Status == JobCompleted || job.Status == JobFailed || job.Status == JobCancelled
+}
+
+// prune 删除超过保留时间的已结束任务，并限制已结束任务的数量，调用方需持有写锁
+func (jm *JobManager) prune(now time.Time) {
+	var finished []*Job
+	for id, job := range jm.jobs {
+		if !job.finished() {
+			continue
+		}
+		if jm.retention > 0 && now.Sub(job.FinishedAt) > jm.retention {
+			delete(jm.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if jm.maxFinished > 0 && len(finished) > jm.maxFinished {
+		sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(finished[j].FinishedAt) })
+		for _, job := range finished[:len(finished)-jm.maxFinished] {
+			delete(jm.jobs, job.ID)
+		}
+	}
+	recordSweep("finished_jobs")
+}
+
+// Cancel 取消任务，传输会在下一个缓冲区边界停止并保留已传输字节数；已结束的任务返回errJobFinished
+func (jm *JobManager) Cancel(id string) error {
+	jm.mutex.RLock()
+	job, exists := jm.jobs[id]
+	finished := exists && job.finished()
+	jm.mutex.RUnlock()
+
+	if !exists {
+		return errJobNotFound
+	}
+	if finished {
+		return errJobFinished
+	}
+	job.cancel()
+	return nil
+}
+
+func (jm *JobManager) Subscribe() chan JobEvent {
+	ch := make(chan JobEvent, 64)
+	jm.subMutex.Lock()
+	jm.subscribers[ch] = struct{}{}
+	jm.subMutex.Unlock()
+	return ch
+}
+
+func (jm *JobManager) Unsubscribe(ch chan JobEvent) {
+	jm.subMutex.Lock()
+	delete(jm.subscribers, ch)
+	jm.subMutex.Unlock()
+}
+
+// publish 非阻塞地分发事件，订阅者消费过慢时丢弃
+func (jm *JobManager) publish(event JobEvent) {
+	jm.subMutex.Lock()
+	defer jm.subMutex.Unlock()
+
+	for ch := range jm.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (jm *JobManager) Get(id string) (gin.H, error) {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+
+	job, exists := jm.jobs[id]
+	if !exists {
+		return nil, errJobNotFound
+	}
+	return job.view(), nil
+}
+
+// Owner 任务提交者，用于访问检查
+func (jm *JobManager) Owner(id string) (string, error) {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+
+	job, exists := jm.jobs[id]
+	if !exists {
+		return "", errJobNotFound
+	}
+	return job.Owner, nil
+}
+
+// List 只返回p可见的任务，p为nil时返回全部
+func (jm *JobManager) List(p *Principal) []gin.H {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+
+	jobs := make([]gin.H, 0, len(jm.jobs))
+	for _, job := range jm.jobs {
+		if jobAllowed(p, job.Owner) {
+			jobs = append(jobs, job.view())
+		}
+	}
+	return jobs
+}
+
+// Allowed 任务事件是否对p可见
+func (event JobEvent) Allowed(p *Principal) bool {
+	return jobAllowed(p, event.owner)
+}
+
+// view 需在持有JobManager锁时调用
+func (job *Job) view() gin.H {
+	view := gin.H{
+		"id":            job.ID,
+		"type":          job.Type,
+		"connection_id": job.ConnectionID,
+		"status":        job.Status,
+		"created_at":    job.CreatedAt,
+	}
+	if job.RequestID != "" {
+		view["request_id"] = job.RequestID
+	}
+	if !job.StartedAt.IsZero() {
+		view["started_at"] = job.StartedAt
+	}
+	if !job.FinishedAt.IsZero() {
+		view["finished_at"] = job.FinishedAt
+	}
+	if job.Progress != nil {
+		view["progress"] = job.Progress.Snapshot()
+	}
+	if job.Result != nil {
+		view["result"] = job.Result
+	}
+	if job.Error != "" {
+		view["error"] = job.Error
+	}
+	if job.ErrorClass != "" {
+		view["error_class"] = job.ErrorClass
+	}
+	return view
+}
+
+var jobs = NewJobManager()
+
+// authorizeJob 任务不存在返回404，不属于调用方时返回403并审计
+func authorizeJob(c *gin.Context) bool {
+	owner, err := jobs.Owner(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return false
+	}
+	if p := currentPrincipal(c); !jobAllowed(p, owner) {
+		denyRequest(c, p, routePermission(c.Request.Method, c.FullPath()), errJobNotOwned.Error())
+		return false
+	}
+	return true
+}
+
+func registerJobRoutes(r *gin.Engine) {
+	// 列出任务
+	r.GET("/jobs", func(c *gin.Context) {
+		list := jobs.List(currentPrincipal(c))
+
+		c.JSON(http.StatusOK, gin.H{
+			"jobs":      list,
+			"count":     len(list),
+			"timestamp": time.Now(),
+		})
+	})
+
+	// 任务事件流（SSE），可按job_id过滤
+	r.GET("/jobs/events", func(c *gin.Context) {
+		jobID := c.Query("job_id")
+		p := currentPrincipal(c)
+		events := jobs.Subscribe()
+		defer jobs.Unsubscribe(events)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Writer.Flush()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event := <-events:
+				if (jobID != "" && event.JobID != jobID) || !event.Allowed(p) {
+					continue
+				}
+				c.SSEvent(event.Type, event)
+				c.Writer.Flush()
+			}
+		}
+	})
+
+	// 查询任务
+	r.GET("/jobs/:id", func(c *gin.Context) {
+		if !authorizeJob(c) {
+			return
+		}
+		job, err := jobs.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	})
+
+	// 取消任务
+	r.POST("/jobs/:id/cancel", func(c *gin.Context) {
+		if !authorizeJob(c) {
+			return
+		}
+		if err := jobs.Cancel(c.Param("id")); err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, errJobFinished) {
+				status = http.StatusConflict
+			}
+			respondError(c, status, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "cancelling",
+			"timestamp": time.Now(),
+		})
+	})
+}
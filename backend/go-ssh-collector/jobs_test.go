@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, jm *JobManager, id string, statuses ...string) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := jm.Get(id)
+		if err != nil {
+			t.Fatalf("get job %s: %v", id, err)
+		}
+		for _, status := range statuses {
+			if job["status"] == status {
+				return job
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach %v", id, statuses)
+	return nil
+}
+
+func TestJobCancelRunning(t *testing.T) {
+	jm := NewJobManager()
+	started := make(chan struct{})
+	id := jm.Submit(context.Background(), "test", "", nil, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	if err := jm.Cancel(id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	waitJob(t, jm, id, JobCancelled)
+}
+
+// 取消与正常结束同时发生时，已成功返回的任务不能被改记为取消
+func TestJobCancelRacingCompletionKeepsCompleted(t *testing.T) {
+	jm := NewJobManager()
+	release := make(chan struct{})
+	id := jm.Submit(context.Background(), "test", "", nil, func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "done", nil
+	})
+	waitJob(t, jm, id, JobRunning)
+	if err := jm.Cancel(id); err != nil {
+		t.Fatalf("cancel running job: %v", err)
+	}
+	close(release)
+	job := waitJob(t, jm, id, JobCompleted, JobCancelled)
+	if job["status"] != JobCompleted || job["result"] != "done" {
+		t.Fatalf("job = %v, want completed with result", job)
+	}
+	if err := jm.Cancel(id); !errors.Is(err, errJobFinished) {
+		t.Fatalf("cancel finished job: err = %v, want errJobFinished", err)
+	}
+	if job := waitJob(t, jm, id, JobCompleted); job["status"] != JobCompleted {
+		t.Fatalf("status changed after cancel: %v", job)
+	}
+}
+
+func TestJobCancelUnknown(t *testing.T) {
+	if err := NewJobManager().Cancel("missing"); !errors.Is(err, errJobNotFound) {
+		t.Fatalf("err = %v, want errJobNotFound", err)
+	}
+}
+
+func TestJobPruneKeepsNewestFinished(t *testing.T) {
+	jm := NewJobManager()
+	jm.maxFinished = 3
+	block := make(chan struct{})
+	running := jm.Submit(context.Background(), "test", "", nil, func(ctx context.Context) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	defer close(block)
+	waitJob(t, jm, running, JobRunning)
+	var ids []string
+	for i := 0; i < 6; i++ {
+		id := jm.Submit(context.Background(), "test", "", nil, func(ctx context.Context) (interface{}, error) { return "ok", nil })
+		waitJob(t, jm, id, JobCompleted)
+		ids = append(ids, id)
+	}
+
+	if got := len(jm.List(nil)); got != 4 {
+		t.Fatalf("jobs kept = %d, want 3 finished + 1 running", got)
+	}
+	if _, err := jm.Get(running); err != nil {
+		t.Fatalf("running job was pruned: %v", err)
+	}
+	for i, id := range ids {
+		_, err := jm.Get(id)
+		if kept := err == nil; kept != (i >= 3) {
+			t.Errorf("job %d kept = %v", i, kept)
+		}
+	}
+}
+
+func TestJobPruneExpired(t *testing.T) {
+	jm := NewJobManager()
+	jm.retention = time.Hour
+	now := time.Now()
+	jm.jobs["old"] = &Job{ID: "old", Status: JobFailed, FinishedAt: now.Add(-2 * time.Hour)}
+	jm.jobs["recent"] = &Job{ID: "recent", Status: JobCompleted, FinishedAt: now.Add(-time.Minute)}
+	jm.jobs["pending"] = &Job{ID: "pending", Status: JobPending, CreatedAt: now.Add(-3 * time.Hour)}
+
+	jm.mutex.Lock()
+	jm.prune(now)
+	jm.mutex.Unlock()
+
+	if _, err := jm.Get("old"); err == nil {
+		t.Error("expired job was kept")
+	}
+	for _, id := range []string{"recent", "pending"} {
+		if _, err := jm.Get(id); err != nil {
+			t.Errorf("%s was pruned", id)
+		}
+	}
+}
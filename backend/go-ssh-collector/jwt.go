@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// OIDC/JWT认证：配置OIDC_ISSUER后接受平台签发的Bearer令牌，与API Key并存，
+// 任一通过即可。签名公钥从JWKS获取并缓存，遇到未知kid时提前刷新
+
+var (
+	oidcIssuer = getEnv("OIDC_ISSUER", "")
+	// 为空时通过 issuer/.well-known/openid-configuration 发现
+	oidcJWKSURL  = getEnv("OIDC_JWKS_URL", "")
+	oidcAudience = getEnv("OIDC_AUDIENCE", "")
+	// 声明映射，支持点号路径（如 realm_access.roles）
+	oidcNameClaim      = getEnv("OIDC_NAME_CLAIM", "sub")
+	oidcNamespaceClaim = getEnv("OIDC_NAMESPACE_CLAIM", "namespace")
+	oidcRolesClaim     = getEnv("OIDC_ROLES_CLAIM", "roles")
+	// 具有该角色的令牌视为admin，其它角色映射见RBAC_ROLE_MAP
+	oidcAdminRole = getEnv("OIDC_ADMIN_ROLE", "admin")
+	// JWKS缓存时间与两次强制刷新的最小间隔（秒）
+	oidcJWKSRefresh    = time.Duration(envInt64("OIDC_JWKS_REFRESH", 3600)) * time.Second
+	oidcJWKSMinRefresh = time.Duration(envInt64("OIDC_JWKS_MIN_REFRESH", 60)) * time.Second
+	// 校验exp/nbf/iat时允许的时钟偏差（秒）
+	oidcLeeway = time.Duration(envInt64("OIDC_LEEWAY", 30)) * time.Second
+)
+
+var errUnknownSigningKey = errors.New("unknown signing key")
+
+// TokenError 令牌校验失败，Code供调用方区分过期、受众错误等情况
+type TokenError struct {
+	Code string
+	Err  error
+}
+
+func (e *TokenError) Error() string { return e.Err.Error() }
+func (e *TokenError) Unwrap() error { return e.Err }
+
+func tokenError(code, format string, args ...interface{}) *TokenError {
+	return &TokenError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64Int(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// publicKey 把JWK转换为RSA或ECDSA公钥
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWKSCache 缓存签名公钥，过期或遇到未知kid时重新拉取。拉取在锁外进行，
+// 并发的刷新合并为一次请求，IdP响应慢时已缓存的kid不受影响
+type JWKSCache struct {
+	url       string
+	client    *http.Client
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	mutex     sync.Mutex
+	fetches   singleflight.Group
+}
+
+var oidcKeys = &JWKSCache{client: &http.Client{Timeout: 10 * time.Second}}
+
+func oidcEnabled() bool {
+	return oidcIssuer != ""
+}
+
+// jwksURL 优先使用显式配置，否则从发现文档读取
+func (j *JWKSCache) jwksURL() (string, error) {
+	if oidcJWKSURL != "" {
+		return oidcJWKSURL, nil
+	}
+	j.mutex.Lock()
+	url := j.url
+	j.mutex.Unlock()
+	if url != "" {
+		return url, nil
+	}
+	resp, err := j.client.Get(strings.TrimSuffix(oidcIssuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("openid discovery: %s", resp.Status)
+	}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("openid discovery: missing jwks_uri")
+	}
+	j.mutex.Lock()
+	j.url = doc.JWKSURI
+	j.mutex.Unlock()
+	return doc.JWKSURI, nil
+}
+
+// fetch 拉取JWKS，不持有锁
+func (j *JWKSCache) fetch() (map[string]interface{}, error) {
+	url, err := j.jwksURL()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// refresh 重新拉取并在锁内替换公钥集合，并发调用共享同一次拉取
+func (j *JWKSCache) refresh() error {
+	_, err, _ := j.fetches.Do("jwks", func() (interface{}, error) {
+		keys, err := j.fetch()
+		if err != nil {
+			return nil, err
+		}
+		j.mutex.Lock()
+		j.keys, j.fetchedAt = keys, time.Now()
+		j.mutex.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+// snapshot 公钥集合整体替换、不会原地修改，取出后可在锁外读取
+func (j *JWKSCache) snapshot() (map[string]interface{}, time.Time) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.keys, j.fetchedAt
+}
+
+// Key 按kid取公钥；缓存过期时刷新，未知kid在最小间隔外强制刷新一次
+func (j *JWKSCache) Key(kid string) (interface{}, error) {
+	keys, fetchedAt := j.snapshot()
+	if keys == nil || time.Since(fetchedAt) > oidcJWKSRefresh {
+		if err := j.refresh(); err != nil && keys == nil {
+			return nil, err
+		}
+		keys, fetchedAt = j.snapshot()
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(fetchedAt) > oidcJWKSMinRefresh {
+		if err := j.refresh(); err != nil {
+			return nil, err
+		}
+		keys, _ = j.snapshot()
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+	}
+	// 令牌未带kid且只有一把公钥时直接使用
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, errUnknownSigningKey
+}
+
+// 声明由VerifyToken按时钟偏差自行校验
+var jwtParser = jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{
+	"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512",
+}))
+
+// claimValue 按点号路径读取声明
+func claimValue(claims jwt.MapClaims, path string) interface{} {
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
+
+// claimStrings 接受字符串数组或空格分隔的字符串
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// VerifyToken 校验签名、issuer、audience和有效期，返回令牌对应的调用方
+func VerifyToken(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwtParser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return oidcKeys.Key(kid)
+	})
+	if err != nil {
+		var verr *jwt.ValidationError
+		if !errors.As(err, &verr) {
+			return nil, tokenError("invalid_token", "%v", err)
+		}
+		switch {
+		case errors.Is(verr.Inner, errUnknownSigningKey):
+			return nil, tokenError("unknown_signing_key", "%v", verr.Inner)
+		case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0, verr.Errors&jwt.ValidationErrorUnverifiable != 0:
+			return nil, tokenError("invalid_signature", "%v", err)
+		case verr.Errors&jwt.ValidationErrorMalformed != 0:
+			return nil, tokenError("malformed_token", "%v", err)
+		}
+		return nil, tokenError("invalid_token", "%v", err)
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-oidcLeeway).Unix(), true) {
+		return nil, tokenError("token_expired", "token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(oidcLeeway).Unix(), false) {
+		return nil, tokenError("token_not_yet_valid", "token is not valid yet")
+	}
+	if !claims.VerifyIssuer(oidcIssuer, true) {
+		return nil, tokenError("invalid_issuer", "token issuer %v is not trusted", claims["iss"])
+	}
+	if oidcAudience != "" && !claims.VerifyAudience(oidcAudience, true) {
+		return nil, tokenError("invalid_audience", "token audience does not include %s", oidcAudience)
+	}
+
+	p := &Principal{Method: "jwt"}
+	p.Subject, _ = claims["sub"].(string)
+	p.Issuer, _ = claims["iss"].(string)
+	// 连接、任务和结果的归属按iss/sub比较，没有sub的令牌会与其它匿名调用方共享归属
+	if p.Subject == "" {
+		return nil, tokenError("no_subject", "token carries no sub")
+	}
+	p.Name, _ = claimValue(claims, oidcNameClaim).(string)
+	if p.Name == "" {
+		p.Name = p.Subject
+	}
+	p.Namespace, _ = claimValue(claims, oidcNamespaceClaim).(string)
+	p.Roles = claimStrings(claimValue(claims, oidcRolesClaim))
+	p.Role = resolveRole(p.Roles)
+	if p.Role == "" {
+		return nil, tokenError("no_role", "token carries no recognised role")
+	}
+	p.Admin = p.Role == roleAdmin
+	return p, nil
+}
+
+// looksLikeJWT 三段点号分隔的Bearer凭据按JWT处理
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testIdP 提供JWKS的测试IdP，keys可在测试中途更换以模拟密钥轮换
+type testIdP struct {
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int64
+	// block 不为nil时JWKS请求等待其关闭，模拟响应慢的IdP
+	block chan struct{}
+}
+
+const testIssuer = "https://idp.test"
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// useTestIdP 把OIDC配置指向测试IdP，结束时恢复
+func useTestIdP(t *testing.T, keys map[string]*rsa.PrivateKey) *testIdP {
+	t.Helper()
+	idp := &testIdP{keys: keys}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&idp.fetches, 1)
+		idp.mutex.Lock()
+		block := idp.block
+		set := []jsonWebKey{}
+		for kid, key := range idp.keys {
+			set = append(set, jsonWebKey{
+				Kid: kid, Kty: "RSA", Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		idp.mutex.Unlock()
+		if block != nil {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": set})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer, url, audience, keyCache, minRefresh := oidcIssuer, oidcJWKSURL, oidcAudience, oidcKeys, oidcJWKSMinRefresh
+	oidcIssuer, oidcJWKSURL, oidcAudience = testIssuer, server.URL, "collector"
+	oidcKeys = &JWKSCache{client: server.Client()}
+	t.Cleanup(func() {
+		oidcIssuer, oidcJWKSURL, oidcAudience, oidcKeys, oidcJWKSMinRefresh = issuer, url, audience, keyCache, minRefresh
+	})
+	return idp
+}
+
+func (idp *testIdP) setKeys(keys map[string]*rsa.PrivateKey) {
+	idp.mutex.Lock()
+	idp.keys = keys
+	idp.mutex.Unlock()
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   "collector",
+		"sub":   "alice",
+		"roles": []string{"operator"},
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyTokenFailureCodes(t *testing.T) {
+	key := newTestKey(t)
+	useTestIdP(t, map[string]*rsa.PrivateKey{"k1": key})
+
+	p, err := VerifyToken(signToken(t, key, "k1", validClaims()))
+	if err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if p.Name != "alice" || p.Subject != "alice" || p.Identity() != "jwt:"+testIssuer+"/alice" || p.Role != roleOperator || p.Method != "jwt" {
+		t.Fatalf("principal = %+v", p)
+	}
+
+	cases := []struct {
+		name  string
+		claim func(jwt.MapClaims)
+		code  string
+	}{
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "token_expired"},
+		{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, "token_expired"},
+		{"not yet valid", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Hour).Unix() }, "token_not_yet_valid"},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "another-service" }, "invalid_audience"},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.test" }, "invalid_issuer"},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, "no_subject"},
+		{"empty subject", func(c jwt.MapClaims) { c["sub"] = "" }, "no_subject"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			tc.claim(claims)
+			_, err := VerifyToken(signToken(t, key, "k1", claims))
+			var terr *TokenError
+			if !errors.As(err, &terr) || terr.Code != tc.code {
+				t.Fatalf("err = %v, want code %s", err, tc.code)
+			}
+		})
+	}
+
+	// 签名不匹配
+	_, err = VerifyToken(signToken(t, newTestKey(t), "k1", validClaims()))
+	var terr *TokenError
+	if !errors.As(err, &terr) || terr.Code != "invalid_signature" {
+		t.Fatalf("forged token: err = %v, want invalid_signature", err)
+	}
+}
+
+// 名称声明缺失时回退到sub，没有sub时拒绝
+func TestVerifyTokenNameClaimFallsBackToSubject(t *testing.T) {
+	key := newTestKey(t)
+	useTestIdP(t, map[string]*rsa.PrivateKey{"k1": key})
+	previous := oidcNameClaim
+	oidcNameClaim = "preferred_username"
+	t.Cleanup(func() { oidcNameClaim = previous })
+
+	claims := validClaims()
+	claims["preferred_username"] = "alice.smith"
+	if p, err := VerifyToken(signToken(t, key, "k1", claims)); err != nil || p.Name != "alice.smith" {
+		t.Fatalf("name claim: %+v, %v", p, err)
+	}
+	if p, err := VerifyToken(signToken(t, key, "k1", validClaims())); err != nil || p.Name != "alice" {
+		t.Fatalf("sub fallback: %+v, %v", p, err)
+	}
+	// 归属按iss/sub比较，只有名称没有sub的令牌也拒绝
+	claims = validClaims()
+	claims["preferred_username"] = "alice.smith"
+	delete(claims, "sub")
+	var terr *TokenError
+	if _, err := VerifyToken(signToken(t, key, "k1", claims)); !errors.As(err, &terr) || terr.Code != "no_subject" {
+		t.Fatalf("token without sub: err = %v, want no_subject", err)
+	}
+}
+
+// 轮换后的新kid触发一次刷新，最小间隔内不再重复拉取
+func TestJWKSRefreshesOnUnknownKid(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	idp := useTestIdP(t, map[string]*rsa.PrivateKey{"old": oldKey})
+
+	if _, err := VerifyToken(signToken(t, oldKey, "old", validClaims())); err != nil {
+		t.Fatal(err)
+	}
+	idp.setKeys(map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey})
+
+	// 最小刷新间隔内未知kid直接拒绝
+	var terr *TokenError
+	if _, err := VerifyToken(signToken(t, newKey, "new", validClaims())); !errors.As(err, &terr) || terr.Code != "unknown_signing_key" {
+		t.Fatalf("within min refresh: err = %v, want unknown_signing_key", err)
+	}
+	if n := atomic.LoadInt64(&idp.fetches); n != 1 {
+		t.Fatalf("JWKS fetched %d times within the min refresh interval", n)
+	}
+
+	oidcJWKSMinRefresh = 0
+	if _, err := VerifyToken(signToken(t, newKey, "new", validClaims())); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if n := atomic.LoadInt64(&idp.fetches); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+	if _, err := VerifyToken(signToken(t, newKey, "missing", validClaims())); !errors.As(err, &terr) || terr.Code != "unknown_signing_key" {
+		t.Fatalf("kid absent after refresh: err = %v, want unknown_signing_key", err)
+	}
+}
+
+// JWKS刷新进行中时已缓存的kid仍可校验，并发的刷新只拉取一次
+func TestJWKSRefreshDoesNotBlockCachedKeys(t *testing.T) {
+	key := newTestKey(t)
+	idp := useTestIdP(t, map[string]*rsa.PrivateKey{"k1": key})
+	if _, err := VerifyToken(signToken(t, key, "k1", validClaims())); err != nil {
+		t.Fatal(err)
+	}
+	oidcJWKSMinRefresh = 0
+
+	block := make(chan struct{})
+	idp.mutex.Lock()
+	idp.block = block
+	idp.mutex.Unlock()
+	var release sync.Once
+	unblock := func() { release.Do(func() { close(block) }) }
+	t.Cleanup(unblock)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			VerifyToken(signToken(t, key, "unknown", validClaims()))
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&idp.fetches) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := VerifyToken(signToken(t, key, "k1", validClaims()))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("cached kid during refresh: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cached kid blocked behind a slow JWKS refresh")
+	}
+	if n := atomic.LoadInt64(&idp.fetches); n != 2 {
+		t.Errorf("concurrent refreshes fetched JWKS %d times, want 2", n)
+	}
+	unblock()
+	wg.Wait()
+}
+
+// REST请求中JWT失败原因放在details.reason
+func TestJWTAuthFailureReason(t *testing.T) {
+	key := newTestKey(t)
+	useTestIdP(t, map[string]*rsa.PrivateKey{"k1": key})
+	r := newRouter()
+
+	send := func(token string) (int, APIError) {
+		req := httptest.NewRequest(http.MethodGet, "/connections", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp APIError
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	if code, _ := send(signToken(t, key, "k1", validClaims())); code != http.StatusOK {
+		t.Fatalf("valid token: status %d", code)
+	}
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	code, resp := send(signToken(t, key, "k1", claims))
+	if code != http.StatusUnauthorized || resp.Details["reason"] != "token_expired" {
+		t.Fatalf("expired token: status %d, details %v", code, resp.Details)
+	}
+	claims = validClaims()
+	delete(claims, "sub")
+	code, resp = send(signToken(t, key, "k1", claims))
+	if code != http.StatusUnauthorized || resp.Details["reason"] != "no_subject" {
+		t.Fatalf("nameless token: status %d, details %v", code, resp.Details)
+	}
+}
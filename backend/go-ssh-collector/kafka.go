@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Kafka输出：设置 KAFKA_BROKERS（逗号分隔）后，结果、事件和trap分别写入 KAFKA_TOPIC_RESULTS、
+// KAFKA_TOPIC_EVENTS、KAFKA_TOPIC_TRAPS，其他类型写入 KAFKA_TOPIC_OTHER（为空时不发送）。
+// 消息key由 KAFKA_KEY 选择：connection_id（默认，没有连接ID时用host）、host 或 none，同一key进入同一分区。
+// 消息体由 SINK_PAYLOAD 决定，与其他sink相同；versioned 时schema和版本号也写入消息头。
+// 发送在后台按批进行，缓冲（KAFKA_BUFFER）满时丢弃并计数；连续 KAFKA_CIRCUIT_FAILURES 批失败后断路
+// KAFKA_CIRCUIT_COOLDOWN 秒，期间新消息直接丢弃，采集不会因Kafka不可用而阻塞。
+// broker地址由运维配置，不经过目标地址策略
+
+const (
+	kafkaKeyConnection = "connection_id"
+	kafkaKeyHost       = "host"
+	kafkaKeyNone       = "none"
+)
+
+var (
+	kafkaBrokers         = getEnv("KAFKA_BROKERS", "")
+	kafkaKey             = getEnv("KAFKA_KEY", kafkaKeyConnection)
+	kafkaBuffer          = int(envInt64("KAFKA_BUFFER", 10000))
+	kafkaBatchSize       = int(envInt64("KAFKA_BATCH_SIZE", 100))
+	kafkaMaxAttempts     = int(envInt64("KAFKA_MAX_ATTEMPTS", 3))
+	kafkaCircuitFailures = envInt64("KAFKA_CIRCUIT_FAILURES", 5)
+	kafkaCircuitCooldown = time.Duration(envInt64("KAFKA_CIRCUIT_COOLDOWN", 30)) * time.Second
+)
+
+// kafkaMessage 缓冲中的一条消息，enqueued用于计算缓冲延迟
+type kafkaMessage struct {
+	msg      SinkMessage
+	enqueued time.Time
+}
+
+// KafkaSink 实现Sink；Publish只写入缓冲，由run按批发送
+type KafkaSink struct {
+	brokers []string
+	topics  map[string]string
+	other   string
+	writer  *kafka.Writer
+	queue   chan kafkaMessage
+
+	delivered     int64
+	failed        int64
+	droppedFull   int64
+	droppedOpen   int64
+	skipped       int64
+	consecutive   int64
+	circuitOpened int64
+	// openUntil 断路结束时间（UnixNano），0表示未断路
+	openUntil int64
+	// oldest 正在发送的批次中最早入队的时间（UnixNano），用于报告缓冲延迟
+	oldest      int64
+	lastError   atomic.Value
+	deliveredAt atomic.Value
+}
+
+// kafkaSink 未启用时为nil
+var kafkaSink = newKafkaSinkFromEnv()
+
+func newKafkaSinkFromEnv() *KafkaSink {
+	if kafkaBrokers == "" {
+		return nil
+	}
+	sink, err := newKafkaSink(splitList(kafkaBrokers))
+	if err != nil {
+		logError("kafka.disabled", "kafka sink disabled", "error", err)
+		return nil
+	}
+	go sink.run()
+	logInfo("kafka.start", "publishing results and events to kafka", "brokers", strings.Join(sink.brokers, ","))
+	return sink
+}
+
+func newKafkaSink(brokers []string) (*KafkaSink, error) {
+	if kafkaKey != kafkaKeyConnection && kafkaKey != kafkaKeyHost && kafkaKey != kafkaKeyNone {
+		return nil, fmt.Errorf("KAFKA_KEY must be connection_id, host or none")
+	}
+	transport, err := kafkaTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSink{
+		brokers: brokers,
+		topics: map[string]string{
+			"result": getEnv("KAFKA_TOPIC_RESULTS", "collector.results"),
+			"event":  getEnv("KAFKA_TOPIC_EVENTS", "collector.events"),
+			"trap":   getEnv("KAFKA_TOPIC_TRAPS", "collector.traps"),
+		},
+		other: getEnv("KAFKA_TOPIC_OTHER", ""),
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			MaxAttempts:  kafkaMaxAttempts,
+			BatchSize:    kafkaBatchSize,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: 10 * time.Second,
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		},
+		queue: make(chan kafkaMessage, kafkaBuffer),
+	}, nil
+}
+
+// kafkaTransport KAFKA_TLS=true 启用TLS（CA为 KAFKA_TLS_CA），
+// KAFKA_SASL_MECHANISM 为 plain、scram-sha-256 或 scram-sha-512
+func kafkaTransport() (*kafka.Transport, error) {
+	transport := &kafka.Transport{DialTimeout: 10 * time.Second, ClientID: "go-ssh-collector"}
+	if getEnv("KAFKA_TLS", "false") == "true" {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: getEnv("KAFKA_TLS_SKIP_VERIFY", "false") == "true"}
+		if caFile := getEnv("KAFKA_TLS_CA", ""); caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLS.RootCAs = x509.NewCertPool()
+			if !transport.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", caFile)
+			}
+		}
+	}
+	mechanism := getEnv("KAFKA_SASL_MECHANISM", "")
+	if mechanism == "" {
+		return transport, nil
+	}
+	username := getEnv("KAFKA_SASL_USERNAME", "")
+	password, err := credentialValue(getEnv("KAFKA_SASL_PASSWORD", ""), getEnv("KAFKA_SASL_PASSWORD_REF", ""))
+	if err != nil {
+		return nil, err
+	}
+	var sm sasl.Mechanism
+	switch strings.ToLower(mechanism) {
+	case "plain":
+		sm = plain.Mechanism{Username: username, Password: password}
+	case "scram-sha-256":
+		sm, err = scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		sm, err = scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported KAFKA_SASL_MECHANISM: %s", mechanism)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = sm
+	return transport, nil
+}
+
+func (k *KafkaSink) Name() string { return "kafka" }
+
+// Publish 不阻塞：断路期间或缓冲满时丢弃并计数，总是返回nil以免计入分发器的失败
+func (k *KafkaSink) Publish(msg SinkMessage) error {
+	if k.topic(msg.Kind) == "" {
+		atomic.AddInt64(&k.skipped, 1)
+		return nil
+	}
+	if k.circuitOpen(time.Now()) {
+		atomic.AddInt64(&k.droppedOpen, 1)
+		return nil
+	}
+	select {
+	case k.queue <- kafkaMessage{msg: msg, enqueued: time.Now()}:
+	default:
+		atomic.AddInt64(&k.droppedFull, 1)
+	}
+	return nil
+}
+
+func (k *KafkaSink) topic(kind string) string {
+	if topic, ok := k.topics[kind]; ok {
+		return topic
+	}
+	return k.other
+}
+
+func (k *KafkaSink) circuitOpen(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&k.openUntil)
+}
+
+// messageKey 按KAFKA_KEY取连接ID或主机，保证同一设备的消息有序
+func messageKey(payload interface{}) []byte {
+	attrs := attributesOf(payload)
+	var key string
+	switch kafkaKey {
+	case kafkaKeyConnection:
+		key = firstNonEmpty(attrs.connectionID, attrs.host)
+	case kafkaKeyHost:
+		key = attrs.host
+	}
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+func (k *KafkaSink) encode(m kafkaMessage) (kafka.Message, error) {
+	msg := m.msg
+	message := kafka.Message{
+		Topic: k.topic(msg.Kind),
+		Key:   messageKey(msg.Payload),
+		Time:  msg.Timestamp,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "kind", Value: []byte(msg.Kind)},
+		},
+	}
+	if sinkPayload == sinkPayloadVersioned {
+		schema, version := sinkSchema(msg.Kind)
+		message.Headers = append(message.Headers,
+			kafka.Header{Key: "schema", Value: []byte(schema)},
+			kafka.Header{Key: "schema-version", Value: []byte(fmt.Sprint(version))})
+	}
+	var err error
+	message.Value, err = msg.Body()
+	return message, err
+}
+
+// run 取出一批发送；断路期间等待冷却结束后用下一批试探
+func (k *KafkaSink) run() {
+	batch := make([]kafkaMessage, 0, kafkaBatchSize)
+	for first := range k.queue {
+		batch = append(batch[:0], first)
+	fill:
+		for len(batch) < kafkaBatchSize {
+			select {
+			case m := <-k.queue:
+				batch = append(batch, m)
+			default:
+				break fill
+			}
+		}
+		atomic.StoreInt64(&k.oldest, first.enqueued.UnixNano())
+		if wait := time.Until(time.Unix(0, atomic.LoadInt64(&k.openUntil))); wait > 0 {
+			time.Sleep(wait)
+		}
+		k.send(batch)
+		atomic.StoreInt64(&k.oldest, 0)
+	}
+}
+
+func (k *KafkaSink) send(batch []kafkaMessage) {
+	messages := make([]kafka.Message, 0, len(batch))
+	for _, m := range batch {
+		message, err := k.encode(m)
+		if err != nil {
+			atomic.AddInt64(&k.failed, 1)
+			k.lastError.Store(err.Error())
+			continue
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := k.writer.WriteMessages(ctx, messages...)
+	failed := int64(len(messages))
+	var writeErrors kafka.WriteErrors
+	if err == nil {
+		failed = 0
+	} else if errors.As(err, &writeErrors) {
+		failed = int64(writeErrors.Count())
+	}
+	atomic.AddInt64(&k.delivered, int64(len(messages))-failed)
+	if failed == 0 {
+		atomic.StoreInt64(&k.consecutive, 0)
+		k.deliveredAt.Store(time.Now())
+		return
+	}
+	atomic.AddInt64(&k.failed, failed)
+	k.lastError.Store(err.Error())
+	if n := atomic.AddInt64(&k.consecutive, 1); n >= kafkaCircuitFailures {
+		if !k.circuitOpen(time.Now()) {
+			atomic.AddInt64(&k.circuitOpened, 1)
+			logWarn("kafka.circuit_open", "kafka sink circuit open", "failed_batches", n, "cooldown_ms", kafkaCircuitCooldown, "error", err)
+		}
+		atomic.StoreInt64(&k.openUntil, time.Now().Add(kafkaCircuitCooldown).UnixNano())
+	} else if n == 1 {
+		logWarn("kafka.delivery_failed", "kafka sink delivery failed", "error", err)
+	}
+}
+
+// Stats 由 GET /admin/sinks 报告；lag_ms 为正在发送的批次中最早一条在缓冲中等待的时间
+func (k *KafkaSink) Stats() gin.H {
+	now := time.Now()
+	state := "closed"
+	if k.circuitOpen(now) {
+		state = "open"
+	} else if atomic.LoadInt64(&k.consecutive) >= kafkaCircuitFailures {
+		state = "half_open"
+	}
+	topics := gin.H{"other": k.other}
+	for kind, topic := range k.topics {
+		topics[kind] = topic
+	}
+	stats := gin.H{
+		"brokers":         k.brokers,
+		"topics":          topics,
+		"payload":         sinkPayload,
+		"key":             kafkaKey,
+		"healthy":         state == "closed" && atomic.LoadInt64(&k.consecutive) == 0,
+		"circuit":         state,
+		"circuit_opened":  atomic.LoadInt64(&k.circuitOpened),
+		"buffered":        len(k.queue),
+		"buffer_capacity": cap(k.queue),
+		"delivered":       atomic.LoadInt64(&k.delivered),
+		"failed":          atomic.LoadInt64(&k.failed),
+		"dropped_full":    atomic.LoadInt64(&k.droppedFull),
+		"dropped_circuit": atomic.LoadInt64(&k.droppedOpen),
+		"skipped":         atomic.LoadInt64(&k.skipped),
+		"lag_ms":          int64(0),
+	}
+	if oldest := atomic.LoadInt64(&k.oldest); oldest > 0 {
+		stats["lag_ms"] = durationMS(now.Sub(time.Unix(0, oldest)))
+	}
+	if lastError, ok := k.lastError.Load().(string); ok {
+		stats["last_error"] = lastError
+	}
+	if deliveredAt, ok := k.deliveredAt.Load().(time.Time); ok {
+		stats["last_delivered_at"] = deliveredAt
+	}
+	return stats
+}
+
+func (k *KafkaSink) WriteMetrics(w io.Writer) {
+	out := metricWriter{w}
+	out.family("collector_kafka_messages_total", "counter", "Messages handled by the kafka sink by result.")
+	for _, counter := range []struct {
+		result string
+		value  *int64
+	}{
+		{"delivered", &k.delivered},
+		{"failed", &k.failed},
+		{"dropped_full", &k.droppedFull},
+		{"dropped_circuit", &k.droppedOpen},
+		{"skipped", &k.skipped},
+	} {
+		out.sample("collector_kafka_messages_total", float64(atomic.LoadInt64(counter.value)), "result", counter.result)
+	}
+	out.family("collector_kafka_buffer", "gauge", "Messages waiting in the kafka sink buffer.")
+	out.sample("collector_kafka_buffer", float64(len(k.queue)))
+	open := 0
+	if k.circuitOpen(time.Now()) {
+		open = 1
+	}
+	out.family("collector_kafka_circuit_open", "gauge", "Whether the kafka sink circuit is open and dropping messages.")
+	out.sample("collector_kafka_circuit_open", float64(open))
+}
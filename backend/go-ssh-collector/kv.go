@@ -0,0 +1,77 @@
+package main
+
+import "strings"
+
+// addKeyValue 重复的键合并为数组
+func addKeyValue(values map[string]interface{}, key, value string) {
+	switch existing := values[key].(type) {
+	case nil:
+		values[key] = value
+	case string:
+		values[key] = []string{existing, value}
+	case []string:
+		values[key] = append(existing, value)
+	default:
+		// 与section同名的键保留section
+	}
+}
+
+// splitKeyValue 按分隔符拆分一行，未指定分隔符时取首个:或=
+func splitKeyValue(line, separator string) (string, string, bool) {
+	if separator != "" {
+		return strings.Cut(line, separator)
+	}
+	i := strings.IndexAny(line, ":=")
+	if i < 0 {
+		return "", "", false
+	}
+	return line[:i], line[i+1:], true
+}
+
+// ParseKeyValue 解析key: value / key=value形式的文本，ini模式下[section]生成嵌套map，
+// 无法识别的行放入unparsed
+func ParseKeyValue(text string, opts *ParseOptions, ini bool) (map[string]interface{}, []string) {
+	trim := opts.Trim == nil || *opts.Trim
+	root := make(map[string]interface{})
+	current := root
+	var unparsed []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if ini {
+			if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+				continue
+			}
+			if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+				name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+				if opts.LowercaseKeys {
+					name = strings.ToLower(name)
+				}
+				section, ok := root[name].(map[string]interface{})
+				if !ok {
+					section = make(map[string]interface{})
+					root[name] = section
+				}
+				current = section
+				continue
+			}
+		}
+
+		key, value, ok := splitKeyValue(line, opts.Separator)
+		if trim {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		}
+		if !ok || strings.TrimSpace(key) == "" {
+			unparsed = append(unparsed, line)
+			continue
+		}
+		if opts.LowercaseKeys {
+			key = strings.ToLower(key)
+		}
+		addKeyValue(current, key, value)
+	}
+	return root, unparsed
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Linux常用命令的内置解析器，大小统一为字节，百分比为浮点数
+
+func init() {
+	registerParser(&BuiltinParser{
+		Name:           "df",
+		Description:    "Filesystem usage",
+		Command:        "df -h / df -k / df -P / df -T",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*df(\s+-[hkPTla]+|\s+-B1)*(\s+[^-\s]\S*)*\s*$`),
+		Parse:          parseDF,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "free",
+		Description:    "Memory and swap usage, plain numbers are KiB",
+		Command:        "free / free -h",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*free(\s+-[hltw]+)*\s*$`),
+		Parse:          parseFree,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "uptime",
+		Description:    "Uptime, users and load averages",
+		Command:        "uptime",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*uptime\s*$`),
+		Parse:          parseUptime,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "ip_addr",
+		Description:    "Interfaces and addresses",
+		Command:        "ip addr",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*ip\s+(-[46]\s+)?(a|addr|address)(\s+show(\s+(dev\s+)?\S+)?)?\s*$`),
+		Parse:          parseIPAddr,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "ip_route",
+		Description:    "Routing table",
+		Command:        "ip route",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*ip\s+(-[46]\s+)?(r|ro|route)(\s+show(\s+.*)?)?\s*$`),
+		Parse:          parseIPRoute,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "ss",
+		Description:    "Sockets with owning processes",
+		Command:        "ss -tlnp",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*ss(\s+-\w+)+\s*$`),
+		Parse:          parseSS,
+	})
+	registerParser(&BuiltinParser{
+		Name:           "meminfo",
+		Description:    "/proc/meminfo, kB values converted to bytes",
+		Command:        "cat /proc/meminfo",
+		Platform:       "linux",
+		SchemaVersion:  1,
+		CommandPattern: regexp.MustCompile(`^\s*cat\s+/proc/meminfo\s*$`),
+		Parse:          parseMeminfo,
+	})
+}
+
+func outputLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// DFEntry df/v1
+type DFEntry struct {
+	Filesystem     string  `json:"filesystem"`
+	Type           string  `json:"type,omitempty"`
+	SizeBytes      int64   `json:"size_bytes"`
+	UsedBytes      int64   `json:"used_bytes"`
+	AvailableBytes int64   `json:"available_bytes"`
+	UsePercent     float64 `json:"use_percent"`
+	MountPoint     string  `json:"mount_point"`
+}
+
+var dfBlockUnits = map[string]int64{"1k": 1024, "1024": 1024, "1b": 1, "512": 512, "1m": 1 << 20}
+
+func parseDF(text string) (interface{}, error) {
+	rows, err := ParseTable(text, 0, `^Filesystem\s`)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]DFEntry, 0, len(rows))
+	for _, row := range rows {
+		// 无单位的数值按表头的块大小换算（-k/-P/-B1），-h输出自带单位
+		unit := int64(1024)
+		for key := range row {
+			if strings.HasSuffix(key, "_blocks") {
+				u, ok := dfBlockUnits[strings.TrimSuffix(key, "_blocks")]
+				if !ok {
+					return nil, fmt.Errorf("unsupported block size column %s", key)
+				}
+				unit = u
+			}
+		}
+
+		entry := DFEntry{Filesystem: row["filesystem"], Type: row["type"], MountPoint: row["mounted_on"]}
+		for key, value := range row {
+			var err error
+			switch {
+			case key == "size" || strings.HasSuffix(key, "_blocks"):
+				entry.SizeBytes, err = parseSize(value, unit)
+			case key == "used":
+				entry.UsedBytes, err = parseSize(value, unit)
+			case key == "avail" || key == "available":
+				entry.AvailableBytes, err = parseSize(value, unit)
+			case key == "use_percent" || key == "capacity":
+				entry.UsePercent, err = parsePercent(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Filesystem, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// FreeMemory free/v1，不同procps版本的列有差异，缺失的列不输出
+type FreeMemory struct {
+	TotalBytes     int64  `json:"total_bytes"`
+	UsedBytes      int64  `json:"used_bytes"`
+	FreeBytes      int64  `json:"free_bytes"`
+	SharedBytes    *int64 `json:"shared_bytes,omitempty"`
+	BuffersBytes   *int64 `json:"buffers_bytes,omitempty"`
+	CachedBytes    *int64 `json:"cached_bytes,omitempty"`
+	BuffCacheBytes *int64 `json:"buff_cache_bytes,omitempty"`
+	AvailableBytes *int64 `json:"available_bytes,omitempty"`
+}
+
+type FreeResult struct {
+	Memory FreeMemory  `json:"memory"`
+	Swap   *FreeMemory `json:"swap,omitempty"`
+}
+
+func parseFree(text string) (interface{}, error) {
+	var header []string
+	var result FreeResult
+	found := false
+
+	for _, line := range outputLines(text) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		label, rest, ok := strings.Cut(line, ":")
+		if !ok || header == nil {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "total" {
+				header = fields
+			}
+			continue
+		}
+
+		var target *FreeMemory
+		switch strings.TrimSpace(label) {
+		case "Mem":
+			target = &result.Memory
+			found = true
+		case "Swap":
+			result.Swap = &FreeMemory{}
+			target = result.Swap
+		default:
+			// 旧版的 -/+ buffers/cache 行可由其他字段推算
+			continue
+		}
+
+		for i, value := range strings.Fields(rest) {
+			if i >= len(header) {
+				break
+			}
+			bytes, err := parseSize(value, 1024)
+			if err != nil {
+				return nil, err
+			}
+			switch header[i] {
+			case "total":
+				target.TotalBytes = bytes
+			case "used":
+				target.UsedBytes = bytes
+			case "free":
+				target.FreeBytes = bytes
+			case "shared":
+				target.SharedBytes = &bytes
+			case "buffers":
+				target.BuffersBytes = &bytes
+			case "cached":
+				target.CachedBytes = &bytes
+			case "buff/cache":
+				target.BuffCacheBytes = &bytes
+			case "available":
+				target.AvailableBytes = &bytes
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("Mem line not found")
+	}
+	return &result, nil
+}
+
+// Uptime uptime/v1
+type Uptime struct {
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	Users         *int    `json:"users,omitempty"`
+	Load1         float64 `json:"load1"`
+	Load5         float64 `json:"load5"`
+	Load15        float64 `json:"load15"`
+}
+
+var (
+	uptimeLine     = regexp.MustCompile(`up\s+(.*?),\s+(?:(\d+)\s+users?,\s+)?load averages?:\s+([\d.]+),?\s+([\d.]+),?\s+([\d.]+)`)
+	uptimeDuration = regexp.MustCompile(`^(\d+)\s*(days?|mins?|hrs?|secs?)$`)
+	uptimeClock    = regexp.MustCompile(`^(\d+):(\d+)$`)
+)
+
+func parseUptime(text string) (interface{}, error) {
+	m := uptimeLine.FindStringSubmatch(text)
+	if m == nil {
+		return nil, errors.New("unrecognized uptime output")
+	}
+
+	result := &Uptime{}
+	for _, part := range strings.Split(m[1], ",") {
+		part = strings.TrimSpace(part)
+		if c := uptimeClock.FindStringSubmatch(part); c != nil {
+			hours, _ := strconv.ParseInt(c[1], 10, 64)
+			minutes, _ := strconv.ParseInt(c[2], 10, 64)
+			result.UptimeSeconds += hours*3600 + minutes*60
+			continue
+		}
+		d := uptimeDuration.FindStringSubmatch(part)
+		if d == nil {
+			return nil, fmt.Errorf("unrecognized uptime %q", part)
+		}
+		n, _ := strconv.ParseInt(d[1], 10, 64)
+		switch d[2][0] {
+		case 'd':
+			result.UptimeSeconds += n * 86400
+		case 'h':
+			result.UptimeSeconds += n * 3600
+		case 'm':
+			result.UptimeSeconds += n * 60
+		case 's':
+			result.UptimeSeconds += n
+		}
+	}
+	if m[2] != "" {
+		users, _ := strconv.Atoi(m[2])
+		result.Users = &users
+	}
+	result.Load1, _ = strconv.ParseFloat(m[3], 64)
+	result.Load5, _ = strconv.ParseFloat(m[4], 64)
+	result.Load15, _ = strconv.ParseFloat(m[5], 64)
+	return result, nil
+}
+
+// IPInterface ip_addr/v1
+type IPInterface struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	// Link 为 eth0@if5 中@之后的部分
+	Link      string      `json:"link,omitempty"`
+	Flags     []string    `json:"flags"`
+	MTU       int         `json:"mtu"`
+	State     string      `json:"state,omitempty"`
+	LinkType  string      `json:"link_type,omitempty"`
+	MAC       string      `json:"mac,omitempty"`
+	Addresses []IPAddress `json:"addresses"`
+}
+
+type IPAddress struct {
+	Family       string `json:"family"`
+	Address      string `json:"address"`
+	PrefixLength int    `json:"prefix_length"`
+	Peer         string `json:"peer,omitempty"`
+	Broadcast    string `json:"broadcast,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	Label        string `json:"label,omitempty"`
+}
+
+var (
+	ipAddrHeader = regexp.MustCompile(`^(\d+):\s+([^:\s]+):\s+<([^>]*)>(.*)$`)
+	ipAddrLink   = regexp.MustCompile(`^\s+link/(\S+)(?:\s+(\S+))?`)
+	ipAddrInet   = regexp.MustCompile(`^\s+(inet6?)\s+(\S+)(.*)$`)
+)
+
+func splitPrefix(address, family string) (string, int) {
+	if addr, prefix, ok := strings.Cut(address, "/"); ok {
+		n, _ := strconv.Atoi(prefix)
+		return addr, n
+	}
+	if family == "inet6" {
+		return address, 128
+	}
+	return address, 32
+}
+
+func parseIPAddr(text string) (interface{}, error) {
+	var interfaces []*IPInterface
+	var current *IPInterface
+
+	for _, line := range outputLines(text) {
+		if m := ipAddrHeader.FindStringSubmatch(line); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			name, link, _ := strings.Cut(m[2], "@")
+			current = &IPInterface{Index: index, Name: name, Link: link, Flags: strings.Split(m[3], ","), Addresses: []IPAddress{}}
+			fields := strings.Fields(m[4])
+			for i := 0; i+1 < len(fields); i++ {
+				switch fields[i] {
+				case "mtu":
+					current.MTU, _ = strconv.Atoi(fields[i+1])
+				case "state":
+					current.State = fields[i+1]
+				}
+			}
+			interfaces = append(interfaces, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := ipAddrLink.FindStringSubmatch(line); m != nil {
+			current.LinkType, current.MAC = m[1], m[2]
+			continue
+		}
+		if m := ipAddrInet.FindStringSubmatch(line); m != nil {
+			addr := IPAddress{Family: m[1]}
+			addr.Address, addr.PrefixLength = splitPrefix(m[2], m[1])
+			fields := strings.Fields(m[3])
+			for i := 0; i < len(fields); i++ {
+				switch fields[i] {
+				case "peer":
+					if i+1 < len(fields) {
+						i++
+						addr.Peer, addr.PrefixLength = splitPrefix(fields[i], m[1])
+					}
+				case "brd":
+					if i+1 < len(fields) {
+						i++
+						addr.Broadcast = fields[i]
+					}
+				case "scope":
+					if i+1 < len(fields) {
+						i++
+						addr.Scope = fields[i]
+					}
+				default:
+					// IPv4地址行末尾为标签，如 eth0 / eth0:1
+					if m[1] == "inet" && i == len(fields)-1 && strings.HasPrefix(fields[i], current.Name) {
+						addr.Label = fields[i]
+					}
+				}
+			}
+			current.Addresses = append(current.Addresses, addr)
+		}
+	}
+	if interfaces == nil {
+		return nil, errors.New("no interfaces found")
+	}
+	return interfaces, nil
+}
+
+// Route ip_route/v1
+type Route struct {
+	Type        string         `json:"type,omitempty"`
+	Destination string         `json:"destination"`
+	Gateway     string         `json:"gateway,omitempty"`
+	Device      string         `json:"device,omitempty"`
+	Protocol    string         `json:"protocol,omitempty"`
+	Scope       string         `json:"scope,omitempty"`
+	Source      string         `json:"source,omitempty"`
+	Metric      *int           `json:"metric,omitempty"`
+	Table       string         `json:"table,omitempty"`
+	Flags       []string       `json:"flags,omitempty"`
+	Nexthops    []RouteNexthop `json:"nexthops,omitempty"`
+}
+
+type RouteNexthop struct {
+	Gateway string `json:"gateway,omitempty"`
+	Device  string `json:"device,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+var routeTypes = map[string]bool{
+	"unicast": true, "local": true, "broadcast": true, "multicast": true, "throw": true,
+	"unreachable": true, "prohibit": true, "blackhole": true, "nat": true, "anycast": true,
+}
+
+func parseIPRoute(text string) (interface{}, error) {
+	routes := []*Route{}
+	for _, line := range outputLines(text) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// 多路径路由的下一跳行
+		if fields[0] == "nexthop" && (line[0] == ' ' || line[0] == '\t') && len(routes) > 0 {
+			var hop RouteNexthop
+			for i := 1; i+1 < len(fields); i += 2 {
+				switch fields[i] {
+				case "via":
+					hop.Gateway = fields[i+1]
+				case "dev":
+					hop.Device = fields[i+1]
+				case "weight":
+					hop.Weight, _ = strconv.Atoi(fields[i+1])
+				}
+			}
+			last := routes[len(routes)-1]
+			last.Nexthops = append(last.Nexthops, hop)
+			continue
+		}
+
+		route := &Route{}
+		if routeTypes[fields[0]] && len(fields) > 1 {
+			route.Type, fields = fields[0], fields[1:]
+		}
+		route.Destination, fields = fields[0], fields[1:]
+		for i := 0; i < len(fields); i++ {
+			key := fields[i]
+			if i+1 >= len(fields) {
+				route.Flags = append(route.Flags, key)
+				break
+			}
+			switch key {
+			case "via":
+				route.Gateway = fields[i+1]
+			case "dev":
+				route.Device = fields[i+1]
+			case "proto":
+				route.Protocol = fields[i+1]
+			case "scope":
+				route.Scope = fields[i+1]
+			case "src":
+				route.Source = fields[i+1]
+			case "table":
+				route.Table = fields[i+1]
+			case "metric":
+				metric, err := strconv.Atoi(fields[i+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid metric in %q", line)
+				}
+				route.Metric = &metric
+			case "pref", "mtu", "expires", "weight", "realm", "advmss", "hoplimit", "initcwnd", "initrwnd":
+			default:
+				// linkdown / onlink / dead 等单独的标志
+				route.Flags = append(route.Flags, key)
+				continue
+			}
+			i++
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Socket ss/v1，端口为*时为0
+type Socket struct {
+	Netid          string          `json:"netid,omitempty"`
+	State          string          `json:"state"`
+	RecvQ          int             `json:"recv_q"`
+	SendQ          int             `json:"send_q"`
+	LocalAddress   string          `json:"local_address"`
+	LocalPort      int             `json:"local_port"`
+	LocalInterface string          `json:"local_interface,omitempty"`
+	PeerAddress    string          `json:"peer_address"`
+	PeerPort       int             `json:"peer_port"`
+	Processes      []SocketProcess `json:"processes,omitempty"`
+}
+
+type SocketProcess struct {
+	Name string `json:"name"`
+	PID  int    `json:"pid"`
+	FD   int    `json:"fd"`
+}
+
+// 新版 users:(("sshd",pid=1,fd=3))，旧版 users:(("sshd",1,3))
+var ssProcess = regexp.MustCompile(`\("([^"]*)",(?:pid=)?(\d+),(?:fd=)?(\d+)\)`)
+
+// splitSocketAddress 拆分 [::]:22 / *:22 / :::22 / 127.0.0.53%lo:53
+func splitSocketAddress(s string) (addr, iface string, port int) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, "", 0
+	}
+	addr = strings.Trim(s[:i], "[]")
+	port, _ = strconv.Atoi(s[i+1:])
+	if a, zone, ok := strings.Cut(addr, "%"); ok {
+		addr, iface = a, zone
+	}
+	return addr, iface, port
+}
+
+func parseSS(text string) (interface{}, error) {
+	sockets := []Socket{}
+	hasNetid, headerFound := false, false
+
+	for _, line := range outputLines(text) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !headerFound {
+			if fields[0] == "State" || fields[0] == "Netid" {
+				headerFound, hasNetid = true, fields[0] == "Netid"
+			}
+			continue
+		}
+
+		var socket Socket
+		if hasNetid {
+			socket.Netid, fields = fields[0], fields[1:]
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("unrecognized socket line %q", line)
+		}
+		socket.State = fields[0]
+		socket.RecvQ, _ = strconv.Atoi(fields[1])
+		socket.SendQ, _ = strconv.Atoi(fields[2])
+		socket.LocalAddress, socket.LocalInterface, socket.LocalPort = splitSocketAddress(fields[3])
+		socket.PeerAddress, _, socket.PeerPort = splitSocketAddress(fields[4])
+		for _, m := range ssProcess.FindAllStringSubmatch(strings.Join(fields[5:], " "), -1) {
+			pid, _ := strconv.Atoi(m[2])
+			fd, _ := strconv.Atoi(m[3])
+			socket.Processes = append(socket.Processes, SocketProcess{Name: m[1], PID: pid, FD: fd})
+		}
+		sockets = append(sockets, socket)
+	}
+	if !headerFound {
+		return nil, errors.New("ss header not found")
+	}
+	return sockets, nil
+}
+
+// parseMeminfo meminfo/v1: 键保持原样，带kB单位的值转换为字节，其余为计数
+func parseMeminfo(text string) (interface{}, error) {
+	values := make(map[string]int64)
+	for _, line := range outputLines(text) {
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		if len(fields) > 1 && strings.EqualFold(fields[1], "kB") {
+			value *= 1024
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no meminfo fields found")
+	}
+	return values, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 监听地址：-listen 参数或 LISTEN_ADDRS 环境变量，逗号分隔，可同时监听多个地址，例如
+//   LISTEN_ADDRS=127.0.0.1:8022,unix:///run/collector/api.sock
+// 支持 host:port、tcp://host:port 和 unix:///path。未配置时监听 :$PORT（默认8022）。
+// Unix socket的权限由UNIX_SOCKET_MODE（八进制，默认0660）设置，启动时删除无人监听的残留socket文件。
+// 通过Unix socket访问时没有客户端IP，按IP的限流对所有socket请求共用一个桶
+
+var listenFlag = flag.String("listen", "", "comma-separated listen addresses (host:port, tcp://host:port, unix:///path); overrides LISTEN_ADDRS")
+
+var unixSocketMode = os.FileMode(parseFileMode(getEnv("UNIX_SOCKET_MODE", "0660")))
+
+func parseFileMode(s string) uint32 {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		logWarn("listener.socket_mode_invalid", "invalid UNIX_SOCKET_MODE, using 0660", "mode", s)
+		return 0660
+	}
+	return uint32(mode)
+}
+
+// listenAddrs 按 -listen、LISTEN_ADDRS、PORT 的顺序确定监听地址
+func listenAddrs() []string {
+	if *listenFlag != "" {
+		return splitList(*listenFlag)
+	}
+	if addrs := splitList(getEnv("LISTEN_ADDRS", "")); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{":" + getEnv("PORT", "8022")}
+}
+
+// parseListenAddr 返回net.Listen使用的network和address
+func parseListenAddr(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		path := strings.TrimPrefix(addr, "unix://")
+		if path == "" {
+			return "", "", fmt.Errorf("listen address %q: missing socket path", addr)
+		}
+		return "unix", path, nil
+	case strings.HasPrefix(addr, "tcp://"):
+		addr = strings.TrimPrefix(addr, "tcp://")
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("listen address %q: unsupported scheme", addr)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("listen address %q: %v", addr, err)
+	}
+	return "tcp", addr, nil
+}
+
+// removeStaleSocket 删除上次异常退出留下的socket文件，仍有进程监听时报错
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// openListeners 任一地址失败时关闭已打开的监听并返回错误
+func openListeners(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for _, addr := range addrs {
+		network, address, err := parseListenAddr(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if network == "unix" {
+			if err := removeStaleSocket(address); err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if network == "unix" {
+			if err := os.Chmod(address, unixSocketMode); err != nil {
+				ln.Close()
+				closeAll()
+				return nil, err
+			}
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// tcpListenPort 第一个TCP监听的端口，用于HTTP到HTTPS的跳转；只监听Unix socket时返回空
+func tcpListenPort(listeners []net.Listener) string {
+	for _, ln := range listeners {
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+			return strconv.Itoa(addr.Port)
+		}
+	}
+	return ""
+}
+
+// serveListeners 在每个监听上启动srv，配置了TLSConfig时使用HTTPS，Serve的返回值写入errs
+func serveListeners(srv *http.Server, listeners []net.Listener, name string, errs chan<- error) {
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if srv.TLSConfig != nil {
+				logInfo("listener.start", "starting "+name, "network", ln.Addr().Network(), "address", ln.Addr(), "tls", true)
+				errs <- srv.ServeTLS(ln, "", "")
+			} else {
+				logInfo("listener.start", "starting "+name, "network", ln.Addr().Network(), "address", ln.Addr(), "tls", false)
+				errs <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+}
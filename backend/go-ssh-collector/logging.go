@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 结构化日志：基于log/slog，每条日志包含 time、level、msg、event 以及键值字段。
+// LOG_FORMAT=json 时每行一个JSON对象，console（默认）时为 key=value 格式。
+// LOG_LEVEL 为 debug/info/warn/error，运行时可通过 PUT /admin/log-level 调整。
+// 调用处通过 logInfo/logWarn/logError 指定事件名和字段；标准库log的输出（第三方库）记为 event=log。
+// 密码、私钥、令牌等字段的值一律替换为 ***，消息和字段值经redact处理（见redact.go）
+
+var levelNames = map[slog.Level]string{
+	slog.LevelDebug: "debug",
+	slog.LevelInfo:  "info",
+	slog.LevelWarn:  "warn",
+	slog.LevelError: "error",
+}
+
+var errInvalidLogLevel = errors.New("invalid log level (expected debug, info, warn or error)")
+
+var (
+	logFormat           = getEnv("LOG_FORMAT", "console")
+	logLevel            = newLogLevel(getEnv("LOG_LEVEL", "info"))
+	logOutput io.Writer = os.Stderr
+	logMutex  sync.Mutex
+	logger    = slog.New(newLogHandler())
+)
+
+func parseLogLevel(name string) (slog.Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, errInvalidLogLevel
+}
+
+func newLogLevel(name string) *slog.LevelVar {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	var v slog.LevelVar
+	v.Set(level)
+	return &v
+}
+
+func currentLogLevel() string {
+	return levelNames[logLevel.Level()]
+}
+
+// logSink 每次写入时取当前的logOutput，测试中可替换
+type logSink struct{}
+
+func (logSink) Write(p []byte) (int, error) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	return logOutput.Write(p)
+}
+
+func newLogHandler() slog.Handler {
+	options := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactLogAttr}
+	if logFormat == "json" {
+		return eventHandler{slog.NewJSONHandler(logSink{}, options)}
+	}
+	return eventHandler{slog.NewTextHandler(logSink{}, options)}
+}
+
+// redactLogAttr 脱敏消息和字段值，耗时按毫秒输出
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey:
+			if logFormat == "json" {
+				return slog.String(a.Key, a.Value.Time().UTC().Format(time.RFC3339Nano))
+			}
+			return slog.String(a.Key, a.Value.Time().Format("2006/01/02 15:04:05.000"))
+		case slog.LevelKey:
+			if level, ok := a.Value.Any().(slog.Level); ok {
+				return slog.String(a.Key, levelNames[level])
+			}
+			return a
+		case slog.MessageKey:
+			return slog.String(a.Key, redact(a.Value.String()))
+		}
+	}
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(a.Value.String()))
+	case slog.KindDuration:
+		return slog.Float64(a.Key, float64(a.Value.Duration().Microseconds())/1000)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redact(v.String()))
+		}
+	}
+	return a
+}
+
+// eventHandler 没有event字段的记录（经标准库log写入）补上 event=log
+type eventHandler struct {
+	slog.Handler
+}
+
+func (h eventHandler) Handle(ctx context.Context, r slog.Record) error {
+	hasEvent := false
+	r.Attrs(func(a slog.Attr) bool {
+		hasEvent = a.Key == "event"
+		return !hasEvent
+	})
+	if !hasEvent {
+		r.AddAttrs(slog.String("event", "log"))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h eventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return eventHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h eventHandler) WithGroup(name string) slog.Handler {
+	return eventHandler{h.Handler.WithGroup(name)}
+}
+
+// logEvent 写入一条日志，kv为交替的键和值
+func logEvent(level slog.Level, event, msg string, kv ...interface{}) {
+	logger.Log(context.Background(), level, msg, append([]interface{}{"event", event}, kv...)...)
+}
+
+func logDebug(event, msg string, kv ...interface{}) { logEvent(slog.LevelDebug, event, msg, kv...) }
+func logInfo(event, msg string, kv ...interface{})  { logEvent(slog.LevelInfo, event, msg, kv...) }
+func logWarn(event, msg string, kv ...interface{})  { logEvent(slog.LevelWarn, event, msg, kv...) }
+func logError(event, msg string, kv ...interface{}) { logEvent(slog.LevelError, event, msg, kv...) }
+
+// logFatal 记录错误后退出
+func logFatal(event, msg string, kv ...interface{}) {
+	logError(event, msg, kv...)
+	os.Exit(1)
+}
+
+// requestLogFields 请求相关的字段：请求ID、调用方、路由
+func requestLogFields(c *gin.Context) []interface{} {
+	kv := []interface{}{"request_id", requestID(c)}
+	if span := spanFromContext(c.Request.Context()); span != nil {
+		kv = append(kv, "trace_id", span.Context().TraceID().String())
+	}
+	if p := currentPrincipal(c); p != nil {
+		kv = append(kv, "principal", p.Name)
+	}
+	if route := c.FullPath(); route != "" {
+		kv = append(kv, "route", route)
+	}
+	if id := c.Param("id"); id != "" && strings.HasPrefix(c.FullPath(), "/connections/") {
+		kv = append(kv, "connection_id", id)
+	}
+	return kv
+}
+
+// panicLogWriter 接收gin恢复中间件输出的panic和调用栈
+type panicLogWriter struct{}
+
+func (panicLogWriter) Write(p []byte) (int, error) {
+	logError("http.panic", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// setupLogging 在main开始时调用，标准库log的输出同样经slog写出
+func setupLogging() {
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+}
+
+// accessLogMiddleware 替代gin的访问日志，需在requestIDMiddleware之后注册
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		kv := append(requestLogFields(c),
+			"method", c.Request.Method,
+			"path", path,
+			"status", status,
+			"latency_ms", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		)
+		if len(c.Errors) > 0 {
+			kv = append(kv, "errors", c.Errors.String())
+		}
+		logEvent(level, "http.request", "", kv...)
+	}
+}
+
+func registerLogRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/log-level", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"level": currentLogLevel(), "format": logFormat})
+	})
+
+	admin.PUT("", func(c *gin.Context) {
+		var req struct {
+			Level string `json:"level" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		logLevel.Set(level)
+		logInfo("audit", "log level changed", append(requestLogFields(c), "level", levelNames[level])...)
+		c.JSON(http.StatusOK, gin.H{"level": currentLogLevel(), "format": logFormat})
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs 在测试期间把日志写入缓冲区，format为json或console
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previousOutput, previousFormat, previousLogger, previousDefault := logOutput, logFormat, logger, slog.Default()
+	logMutex.Lock()
+	logOutput = &buf
+	logMutex.Unlock()
+	logFormat = format
+	logger = slog.New(newLogHandler())
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		logMutex.Lock()
+		logOutput = previousOutput
+		logMutex.Unlock()
+		logFormat, logger = previousFormat, previousLogger
+		slog.SetDefault(previousDefault)
+	})
+	return &buf
+}
+
+// 所有写日志的途径输出中都不能出现凭据原文
+func TestLogsDoNotLeakSecrets(t *testing.T) {
+	secrets := []string{"hunter2", "field-pass", "key-123", "tok-abc", "known-s3cr3t", "legacy-pw", "panic-pw", "bearer-xyz"}
+	registerSecrets("logging-test", "known-s3cr3t")
+	t.Cleanup(func() { releaseSecrets("logging-test") })
+
+	for _, format := range []string{"json", "console"} {
+		t.Run(format, func(t *testing.T) {
+			buf := captureLogs(t, format)
+			logInfo("test.secrets", "connect with password=hunter2",
+				"password", "field-pass",
+				"api_key", "key-123",
+				"error", errors.New(`request failed: {"token":"tok-abc"}`),
+				"output", "Password: known-s3cr3t",
+				"header", fmt.Errorf("Authorization: Bearer bearer-xyz"),
+				"latency_ms", 1500*time.Microsecond,
+			)
+			log.Printf("legacy enable_password=legacy-pw")
+			panicLogWriter{}.Write([]byte("panic recovered: password=panic-pw\n"))
+
+			output := buf.String()
+			for _, secret := range secrets {
+				if strings.Contains(output, secret) {
+					t.Errorf("secret %q leaked:\n%s", secret, output)
+				}
+			}
+			for _, event := range []string{"test.secrets", "event=log", "http.panic"} {
+				if format == "json" {
+					event = strings.Replace(event, "event=log", `"event":"log"`, 1)
+				}
+				if !strings.Contains(output, event) {
+					t.Errorf("missing %q:\n%s", event, output)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONLogFields(t *testing.T) {
+	buf := captureLogs(t, "json")
+	logWarn("connection.connect_failed", "connect failed", "host", "r1", "latency_ms", 1500*time.Microsecond, "attempt", 2)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{"level": "warn", "event": "connection.connect_failed", "msg": "connect failed", "host": "r1", "latency_ms": 1.5, "attempt": float64(2)}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("time: %v", err)
+	}
+}
+
+func TestLogLevelFilters(t *testing.T) {
+	buf := captureLogs(t, "console")
+	previous := logLevel.Level()
+	logLevel.Set(slog.LevelWarn)
+	t.Cleanup(func() { logLevel.Set(previous) })
+
+	logInfo("test.info", "hidden")
+	logWarn("test.warn", "shown")
+	if output := buf.String(); strings.Contains(output, "test.info") || !strings.Contains(output, "test.warn") {
+		t.Fatalf("output = %q", output)
+	}
+	if level, err := parseLogLevel("DEBUG"); err != nil || level != slog.LevelDebug {
+		t.Fatalf("parseLogLevel = %v, %v", level, err)
+	}
+	if _, err := parseLogLevel("verbose"); !errors.Is(err, errInvalidLogLevel) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// 日志统一经logInfo/logWarn/logError写出，带事件名和字段；标准库log只留给第三方库
+func TestNoStandardLogCalls(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "logging.go" {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range file.Imports {
+			if spec.Path.Value == `"log"` {
+				t.Errorf("%s imports the standard log package", name)
+			}
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if ident, ok := sel.X.(*ast.Ident); ok && ident.Name == "log" {
+					t.Errorf("%s: log.%s", fset.Position(sel.Pos()), sel.Sel.Name)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// 每条日志都带可读的msg，不能只有事件名
+func TestLogCallsHaveMessages(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.Ident); ok {
+				switch fn.Name {
+				case "logDebug", "logInfo", "logWarn", "logError", "logFatal":
+					if lit, ok := call.Args[1].(*ast.BasicLit); ok && lit.Value == `""` {
+						t.Errorf("%s: %s without a message", fset.Position(call.Pos()), fn.Name)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
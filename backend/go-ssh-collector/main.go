@@ -1,209 +1,229 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"flag"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/ssh"
 )
 
-type SSHConnection struct {
-	Client    *ssh.Client
-	Config    SSHConfig
-	CreatedAt time.Time
-}
-
 type SSHConfig struct {
 	Host     string `json:"host" binding:"required"`
 	Port     int    `json:"port"`
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Timeout  int    `json:"timeout"`
+	// Protocol 为空时使用SSH，其它取值对应已注册的协议（telnet、winrm等）
+	Protocol string `json:"protocol"`
+
+	// DeviceType 选择设备驱动（见GET /drivers），为空时使用generic
+	DeviceType     string `json:"device_type"`
+	EnablePassword string `json:"enable_password"`
+
+	// Alias、Tags 用于在连接列表中过滤和排序
+	Alias string   `json:"alias"`
+	Tags  []string `json:"tags"`
+
+	// 交互式会话（Telnet）的提示符与分页设置，为空时使用驱动的默认值
+	LoginPrompt    string `json:"login_prompt"`
+	PasswordPrompt string `json:"password_prompt"`
+	Prompt         string `json:"prompt"`
+	PagingCommand  string `json:"paging_command"`
+
+	// 串口控制台设置（protocol为console），Transport: rfc2217(默认) / ssh
+	// Parity: none/odd/even/mark/space，FlowControl: none/xonxoff/rtscts
+	Transport   string `json:"transport"`
+	BaudRate    int    `json:"baud_rate"`
+	DataBits    int    `json:"data_bits"`
+	Parity      string `json:"parity"`
+	StopBits    int    `json:"stop_bits"`
+	FlowControl string `json:"flow_control"`
+
+	// ForwardAgent 在该连接的所有会话上转发本地SSH agent（SSH_AUTH_SOCK）。
+	// 只对本跳生效：该连接作为via_connection_id跳板时，隧道不携带agent
+	ForwardAgent bool `json:"forward_agent"`
+
+	// WinRM设置，AuthMethod: basic(默认) / ntlm
+	HTTPS      bool   `json:"https"`
+	SkipVerify bool   `json:"skip_verify"`
+	AuthMethod string `json:"auth_method"`
+
+	// Force 跳过认证失败退避的锁定检查，仅管理员可用，见authbackoff.go
+	Force bool `json:"force"`
+
+	requestID string
+	// namespace 创建连接的调用方命名空间
+	namespace string
+	// principal 创建连接的调用方，记录为连接属主
+	principal *Principal
+	// trace 只用于携带链路追踪的父span，不会取消连接
+	trace context.Context
+	// timing 由协议实现填写各阶段耗时
+	timing *ConnectTiming
 }
 
 type CommandRequest struct {
 	ConnectionID string `json:"connection_id" binding:"required"`
 	Command      string `json:"command" binding:"required"`
+	// Shell 仅用于WinRM连接: cmd(默认) / powershell
+	Shell string `json:"shell"`
+	// ForwardAgent 仅对本次执行请求agent转发（SSH），远端命令可用它再跳一层
+	ForwardAgent bool `json:"forward_agent"`
+	// Parse 对输出做结构化解析，解析失败不影响命令结果
+	Parse *ParseOptions `json:"parse"`
+	// Extract 内联正则提取规则，结果写入fields
+	Extract []ExtractRule `json:"extract"`
+	// Transform 对parsed求值的JMESPath表达式，结果写入result
+	Transform string `json:"transform"`
+	// Script Starlark后处理脚本，需定义 process(result)
+	Script string `json:"script"`
+	// Unmasked 在响应中附带脱敏前的输出，需MASK_ALLOW_UNMASKED
+	Unmasked bool `json:"unmasked"`
+	// Cache 存在足够新的缓存结果时直接返回，不访问设备（需启用结果缓存）
+	Cache *CommandCacheOptions `json:"cache"`
+
+	// stream 非空时SSH连接的输出按行脱敏后实时回调（gRPC ExecuteStream、WebSocket）
+	stream func(chunk string)
+	// ctx 取消时终止SSH连接上正在执行的命令，其它协议只丢弃结果
+	ctx context.Context
+	// requestID 写入CommandResult，用于关联日志
+	requestID string
+	// trace 只用于携带链路追踪的父span，与ctx不同，请求结束时不会终止命令
+	trace context.Context
 }
 
 type CommandResult struct {
-	Command   string    `json:"command"`
-	Output    string    `json:"output"`
-	Error     string    `json:"error,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
-type SSHCollector struct {
-	connections map[string]*SSHConnection
-	mutex       sync.RWMutex
-}
-
-func NewSSHCollector() *SSHCollector {
-	return &SSHCollector{
-		connections: make(map[string]*SSHConnection),
-	}
-}
-
-func (sc *SSHCollector) Connect(config SSHConfig) (string, error) {
-	// 设置默认值
-	if config.Port == 0 {
-		config.Port = 22
-	}
-	if config.Timeout == 0 {
-		config.Timeout = 30
-	}
-
-	// SSH客户端配置
-	sshConfig := &ssh.ClientConfig{
-		User: config.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(config.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Duration(config.Timeout) * time.Second,
-	}
-
-	// 建立连接
-	address := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	client, err := ssh.Dial("tcp", address, sshConfig)
-	if err != nil {
-		return "", fmt.Errorf("failed to connect: %v", err)
-	}
-
-	// 生成连接ID
-	connectionID := fmt.Sprintf("%s:%d:%s", config.Host, config.Port, config.Username)
-
-	// 存储连接
-	sc.mutex.Lock()
-	sc.connections[connectionID] = &SSHConnection{
-		Client:    client,
-		Config:    config,
-		CreatedAt: time.Now(),
-	}
-	sc.mutex.Unlock()
-
-	return connectionID, nil
-}
-
-func (sc *SSHCollector) ExecuteCommand(connectionID, command string) (*CommandResult, error) {
-	sc.mutex.RLock()
-	conn, exists := sc.connections[connectionID]
-	sc.mutex.RUnlock()
-
-	if !exists {
-		return nil, fmt.Errorf("connection not found")
-	}
-
-	// 创建会话
-	session, err := conn.Client.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %v", err)
-	}
-	defer session.Close()
-
-	// 执行命令
-	output, err := session.CombinedOutput(command)
-
-	result := &CommandResult{
-		Command:   command,
-		Output:    string(output),
-		Timestamp: time.Now(),
-	}
-
-	if err != nil {
-		result.Error = err.Error()
-	}
-
-	return result, nil
+	// ID 用于按ID取回结果和diff
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	Output  string `json:"output"`
+	// Stderr 仅在协议区分标准错误时返回（WinRM），SSH的输出已合并到Output
+	Stderr    string `json:"stderr,omitempty"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	// AgentForwarded 请求了agent转发时记录远端是否同意
+	AgentForwarded *bool `json:"agent_forwarded,omitempty"`
+	// Parsed 为按parse选项解析出的记录，ParseError记录解析失败原因
+	Parsed      interface{} `json:"parsed,omitempty"`
+	ParseError  string      `json:"parse_error,omitempty"`
+	ParseSchema string      `json:"parse_schema,omitempty"`
+	// ParseSkipped 为parse.auto未找到解析器时的原因
+	ParseSkipped string `json:"parse_skipped,omitempty"`
+	// Unparsed 为kv/grok模式下未能识别的行
+	Unparsed      []string `json:"unparsed,omitempty"`
+	UnparsedCount int      `json:"unparsed_count,omitempty"`
+	// Fields 为extract规则提取的值，all_matches时为数组
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Result 为transform表达式的求值结果，TransformError记录表达式错误
+	Result         interface{} `json:"result,omitempty"`
+	TransformError string      `json:"transform_error,omitempty"`
+	// ScriptResult 为script的返回值，ScriptError记录脚本错误
+	ScriptResult  interface{}    `json:"script_result,omitempty"`
+	ScriptMetrics []ScriptMetric `json:"script_metrics,omitempty"`
+	ScriptEvents  []ScriptEvent  `json:"script_events,omitempty"`
+	ScriptError   string         `json:"script_error,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	// ErrorClass 失败或输出截断的类别（见errorclass.go）
+	ErrorClass string    `json:"error_class,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	// RequestID 产生该结果的请求ID
+	RequestID string `json:"request_id,omitempty"`
+	// FromCache 结果取自缓存，Timestamp为原始执行时间
+	FromCache bool `json:"from_cache,omitempty"`
+
+	// unmasked 脱敏前的输出，仅在请求unmasked时返回给调用方，不进入存储和sinks
+	unmasked string
+	// timing 各阶段耗时，用于慢命令日志
+	timing commandTiming
 }
 
-func (sc *SSHCollector) Disconnect(connectionID string) error {
-	sc.mutex.Lock()
-	defer sc.mutex.Unlock()
-
-	conn, exists := sc.connections[connectionID]
-	if !exists {
-		return fmt.Errorf("connection not found")
-	}
-
-	err := conn.Client.Close()
-	delete(sc.connections, connectionID)
+var errConnectionNotFound = errors.New("connection not found")
 
-	return err
-}
+var collector *ConnectionManager
 
-func (sc *SSHCollector) ListConnections() map[string]interface{} {
-	sc.mutex.RLock()
-	defer sc.mutex.RUnlock()
-
-	connections := make(map[string]interface{})
-	for id, conn := range sc.connections {
-		connections[id] = map[string]interface{}{
-			"host":       conn.Config.Host,
-			"port":       conn.Config.Port,
-			"username":   conn.Config.Username,
-			"created_at": conn.CreatedAt,
-		}
+// executeErrorStatus 执行失败对应的HTTP状态码，WebSocket消息中使用同样的取值
+func executeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errConnectionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errCommandDenied), errors.Is(err, errAgentForwardingDisabled), errors.Is(err, errUnmaskedDenied):
+		return http.StatusForbidden
+	case errors.Is(err, errNotSSHConnection), errors.Is(err, errInvalidExtract):
+		return http.StatusBadRequest
 	}
-
-	return connections
+	return http.StatusInternalServerError
 }
 
-var collector *SSHCollector
+// newRouter 注册中间件和全部REST路由，监听、后台任务等由main启动
+func newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(requestIDMiddleware(), tracingMiddleware(), metricsMiddleware(), accessLogMiddleware(), gin.CustomRecoveryWithWriter(panicLogWriter{}, recoveryHandler), compressionMiddleware())
 
-func main() {
-	collector = NewSSHCollector()
-
-	// 设置Gin模式
-	if os.Getenv("GIN_MODE") == "" {
-		gin.SetMode(gin.ReleaseMode)
+	// CORS配置，见cors.go
+	if m := corsMiddleware(); m != nil {
+		r.Use(m)
 	}
-
-	r := gin.Default()
-
-	// CORS配置
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"*"}
-	r.Use(cors.New(config))
-
-	// 健康检查
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":             "healthy",
-			"timestamp":          time.Now(),
-			"service":            "go-ssh-collector",
-			"active_connections": len(collector.connections),
-		})
-	})
+	r.Use(rateLimitMiddleware())
+	r.Use(bodyLimitMiddleware())
+	r.Use(authMiddleware())
+	r.Use(keyRateLimitMiddleware())
+	r.Use(auditMiddleware("rest"))
+	// YAML请求体在RBAC之前转换为JSON，连接ACL才能读取其中的connection_id与via_connection_id
+	r.Use(yamlMiddleware())
+	r.Use(rbacMiddleware())
+	r.Use(idempotencyMiddleware())
 
 	// 建立连接
 	r.POST("/connect", func(c *gin.Context) {
 		var config SSHConfig
 		if err := c.ShouldBindJSON(&config); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if p := currentPrincipal(c); config.Force && p != nil && !p.Can(permAdmin) {
+			denyRequest(c, p, permAdmin, "force requires admin")
 			return
 		}
 
+		config.requestID = requestID(c)
+		config.trace = c.Request.Context()
+		if p := currentPrincipal(c); p != nil {
+			config.namespace, config.principal = p.Namespace, p
+		}
+		timing := &ConnectTiming{}
+		config.timing = timing
 		connectionID, err := collector.Connect(config)
+		if errors.Is(err, errConnectionOwned) {
+			denyRequest(c, config.principal, permExecute, err.Error())
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, errUnsupportedProtocol), errors.Is(err, errUnknownDeviceType):
+				status = http.StatusBadRequest
+			case errors.Is(err, errConsoleBusy):
+				status = http.StatusConflict
+			case errors.Is(err, errAgentForwardingDisabled):
+				status = http.StatusForbidden
+			}
+			respondError(c, status, withErrorCode(CodeConnectFailed, err))
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"connection_id": connectionID,
-			"status":        "connected",
-			"timestamp":     time.Now(),
+			"connection_id":  connectionID,
+			"status":         "connected",
+			"connect_timing": timing,
+			"timestamp":      time.Now(),
 		})
 	})
 
@@ -211,17 +231,31 @@ func main() {
 	r.POST("/execute", func(c *gin.Context) {
 		var req CommandRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		// 查看脱敏前输出需要admin权限
+		if p := currentPrincipal(c); req.Unmasked && p != nil && !p.Can(permAdmin) {
+			denyRequest(c, p, permAdmin, "unmasked output requires admin")
 			return
 		}
 
-		result, err := collector.ExecuteCommand(req.ConnectionID, req.Command)
+		req.requestID = requestID(c)
+		req.trace = c.Request.Context()
+		if cacheBypassed(c) {
+			req.Cache = nil
+		}
+		result, err := collector.Execute(req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, executeErrorStatus(err), withErrorCode(CodeCommandFailed, err))
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		if wantsCSV(c) {
+			respondResultCSV(c, result)
+			return
+		}
+		renderCommandResult(c, result, req.Unmasked)
 	})
 
 	// 断开连接
@@ -231,15 +265,16 @@ func main() {
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondError(c, http.StatusBadRequest, err)
 			return
 		}
 
 		err := collector.Disconnect(req.ConnectionID)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			respondError(c, http.StatusNotFound, err)
 			return
 		}
+		connectionACLs.Remove(req.ConnectionID)
 
 		c.JSON(http.StatusOK, gin.H{
 			"status":    "disconnected",
@@ -249,21 +284,198 @@ func main() {
 
 	// 列出连接
 	r.GET("/connections", func(c *gin.Context) {
-		connections := collector.ListConnections()
+		// 旧的map格式保留一个版本
+		if c.Query("format") == "map" {
+			connections := collector.ListConnections()
+			for id := range connections {
+				if !connectionACLs.Allowed(currentPrincipal(c), id) {
+					delete(connections, id)
+				}
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"active_connections": connections,
+				"count":              len(connections),
+				"timestamp":          time.Now(),
+			})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"active_connections": connections,
-			"count":              len(connections),
-			"timestamp":          time.Now(),
-		})
+		query, err := parseConnectionQuery(c.Query)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		p := currentPrincipal(c)
+		page := collector.QueryConnections(query, func(id string) bool { return connectionACLs.Allowed(p, id) })
+		page.Timestamp = time.Now()
+		c.JSON(http.StatusOK, page)
 	})
 
-	// 启动服务器
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8022"
+	// 单个连接，含最近一次连接的耗时分解
+	r.GET("/connections/:id", func(c *gin.Context) {
+		summary, err := collector.Summary(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	})
+
+	// 连接健康检查
+	r.GET("/connections/:id/health", func(c *gin.Context) {
+		if err := collector.HealthCheck(c.Param("id")); err != nil {
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondErrorDetails(c, status, withErrorCode(CodeConnectionUnhealthy, err), gin.H{"status": "unhealthy"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "healthy", "timestamp": time.Now()})
+	})
+
+	// 已注册的协议
+	r.GET("/protocols", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"protocols": registeredProtocols()})
+	})
+
+	registerDriverRoutes(r)
+	registerAuthBackoffRoutes(r)
+	registerConnStatsRoutes(r)
+	registerSlowLogRoutes(r)
+	registerEventRoutes(r)
+	registerConsoleRoutes(r)
+	registerHTTPRoutes(r)
+	registerScrapeRoutes(r)
+	registerForwardRoutes(r)
+	registerSOCKSRoutes(r)
+
+	// 文件传输
+	registerFileRoutes(r)
+	registerFetchRoutes(r)
+	registerArchiveRoutes(r)
+	registerTailRoutes(r)
+	registerSyncRoutes(r)
+
+	// 异步任务
+	registerJobRoutes(r)
+
+	// SNMP采集
+	registerSNMPRoutes(r)
+	registerTrapRoutes(r)
+	registerNetconfRoutes(r)
+	registerGNMIRoutes(r)
+	registerRedfishRoutes(r)
+	registerIPMIRoutes(r)
+	registerModbusRoutes(r)
+	registerMQTTRoutes(r)
+	registerSyslogRoutes(r)
+	registerProbeRoutes(r)
+	registerGRPCProbeRoutes(r)
+	registerFTPRoutes(r)
+	registerDBRoutes(r)
+	registerTemplateRoutes(r)
+	registerParserRoutes(r)
+	registerParserStoreRoutes(r)
+	registerGrokRoutes(r)
+	registerTransformRoutes(r)
+	registerResultRoutes(r)
+	registerResultQueryRoutes(r)
+	registerExportRoutes(r)
+	registerResultCacheRoutes(r)
+	registerMetricRuleRoutes(r)
+	registerAlertRoutes(r)
+	registerNotificationRoutes(r)
+	registerScriptRoutes(r)
+	registerMaskingRoutes(r)
+	registerRBACRoutes(r)
+	registerBulkRoutes(r)
+	registerWebSocketRoutes(r)
+	registerHealthRoutes(r)
+	registerVersionRoutes(r)
+	registerOpenAPIRoutes(r)
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, errors.New("route not found"))
+	})
+	return r
+}
+
+func main() {
+	flag.Parse()
+	setupLogging()
+	setupTracing()
+	collector = NewConnectionManager()
+	startSaturationSampler()
+	startResultStore()
+	startResultCache()
+
+	// 设置Gin模式
+	if os.Getenv("GIN_MODE") == "" {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
-	log.Printf("Starting Go SSH Collector on port %s", port)
-	log.Fatal(r.Run(":" + port))
-}
\ No newline at end of file
+	r := newRouter()
+	startTrapListener()
+	startSyslogListener()
+	startSyslogForwarder()
+	watchDestinationPolicy()
+	logInfo("startup", "Go SSH Collector", "version", currentVersion())
+
+	// 启动服务器
+	tlsConfig, reloader, err := serverTLSConfig()
+	if err != nil {
+		logFatal("tls.config_invalid", "invalid TLS configuration", "error", err)
+	}
+	listeners, err := openListeners(listenAddrs())
+	if err != nil {
+		logFatal("listener.open_failed", "open listeners failed", "error", err)
+	}
+	srv := &http.Server{Handler: versionedHandler(r), TLSConfig: tlsConfig}
+	adminSrv, adminListeners := newAdminServer(tlsConfig)
+	grpcServer := startGRPCServer(tlsConfig)
+	markStartupComplete()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		// 收到退出信号时关闭端口转发并优雅停止
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		forwards.CloseAll()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		go stopGRPCServer(ctx, grpcServer)
+		var wg sync.WaitGroup
+		for _, s := range []*http.Server{srv, adminSrv} {
+			if s == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(s *http.Server) {
+				defer wg.Done()
+				s.Shutdown(ctx)
+			}(s)
+		}
+		wg.Wait()
+		reloader.Stop()
+		flushTraces(ctx)
+	}()
+
+	if tlsConfig != nil {
+		if port := tcpListenPort(listeners); port != "" {
+			startRedirectListener(port)
+		}
+	}
+	serveErrs := make(chan error, len(listeners)+len(adminListeners))
+	serveListeners(srv, listeners, "Go SSH Collector", serveErrs)
+	if adminSrv != nil {
+		serveListeners(adminSrv, adminListeners, "admin API", serveErrs)
+	}
+	for i := 0; i < cap(serveErrs); i++ {
+		if err := <-serveErrs; err != http.ErrServerClosed {
+			logFatal("listener.serve_failed", "server stopped", "error", err)
+		}
+	}
+	// Serve在Shutdown开始时即返回，等待进行中的请求处理完毕
+	<-shutdownDone
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	collector = NewConnectionManager()
+	// 测试请求都来自同一个客户端IP，默认的路由级限制只在限流测试中按需设置
+	rateLimiter.SetConfig(RateLimitConfig{})
+	os.Exit(m.Run())
+}
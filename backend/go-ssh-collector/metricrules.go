@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmespath/go-jmespath"
+)
+
+// 指标提取规则：从命令输出或parsed中提取数值，以gauge形式发布到 /metrics
+
+var (
+	errMetricRuleNotFound = errors.New("metric rule not found")
+	errInvalidMetricRule  = errors.New("invalid metric rule")
+	metricLabelName       = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+var (
+	// 启动时加载的规则文件（JSON数组），示例见 metric_rules.example.json
+	metricRulesFile = getEnv("METRIC_RULES_FILE", "")
+	// 超过该时间未更新的序列不再输出，规则可单独设置
+	metricDefaultTTL = time.Duration(envInt64("METRIC_STALENESS_TTL", 600)) * time.Second
+)
+
+// MetricRule Source为regex时Value和Labels引用Regex的命名分组；
+// 为parsed时Items选出记录列表，Value和Labels为作用于每条记录的JMESPath表达式
+type MetricRule struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Source string            `json:"source"`
+	Regex  string            `json:"regex,omitempty"`
+	Items  string            `json:"items,omitempty"`
+	Value  string            `json:"value" binding:"required"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// ConnectionID / Command 限定规则作用的连接和命令（正则），为空表示全部
+	ConnectionID string `json:"connection_id,omitempty"`
+	Command      string `json:"command,omitempty"`
+	TTLSeconds   int    `json:"ttl_seconds,omitempty"`
+
+	re        *regexp.Regexp
+	commandRe *regexp.Regexp
+	items     *jmespath.JMESPath
+	value     *jmespath.JMESPath
+	labels    map[string]*jmespath.JMESPath
+}
+
+func (rule *MetricRule) compile() error {
+	if !metricPrefixRegexp.MatchString(rule.Name) {
+		return fmt.Errorf("%w: invalid metric name %q", errInvalidMetricRule, rule.Name)
+	}
+	for label := range rule.Labels {
+		if !metricLabelName.MatchString(label) || label == "connection_id" || label == "host" {
+			return fmt.Errorf("%w: invalid label name %q", errInvalidMetricRule, label)
+		}
+	}
+	if rule.Command != "" {
+		re, err := regexp.Compile(rule.Command)
+		if err != nil {
+			return fmt.Errorf("%w: command: %v", errInvalidMetricRule, err)
+		}
+		rule.commandRe = re
+	}
+
+	switch rule.Source {
+	case "regex":
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("%w: regex: %v", errInvalidMetricRule, err)
+		}
+		groups := make(map[string]bool)
+		for _, name := range re.SubexpNames() {
+			groups[name] = name != ""
+		}
+		if !groups[rule.Value] {
+			return fmt.Errorf("%w: value group %q not in regex", errInvalidMetricRule, rule.Value)
+		}
+		for label, group := range rule.Labels {
+			if !groups[group] {
+				return fmt.Errorf("%w: label %s group %q not in regex", errInvalidMetricRule, label, group)
+			}
+		}
+		rule.re = re
+	case "parsed":
+		items := rule.Items
+		if items == "" {
+			items = "@"
+		}
+		var err error
+		if rule.items, err = transforms.Compile(items); err != nil {
+			return fmt.Errorf("%w: items: %v", errInvalidMetricRule, err)
+		}
+		if rule.value, err = transforms.Compile(rule.Value); err != nil {
+			return fmt.Errorf("%w: value: %v", errInvalidMetricRule, err)
+		}
+		rule.labels = make(map[string]*jmespath.JMESPath, len(rule.Labels))
+		for label, expression := range rule.Labels {
+			if rule.labels[label], err = transforms.Compile(expression); err != nil {
+				return fmt.Errorf("%w: label %s: %v", errInvalidMetricRule, label, err)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: source must be regex or parsed", errInvalidMetricRule)
+	}
+	return nil
+}
+
+func (rule *MetricRule) ttl() time.Duration {
+	if rule.TTLSeconds > 0 {
+		return time.Duration(rule.TTLSeconds) * time.Second
+	}
+	return metricDefaultTTL
+}
+
+func (rule *MetricRule) applies(record ResultRecord) bool {
+	if rule.ConnectionID != "" && rule.ConnectionID != record.ConnectionID {
+		return false
+	}
+	return rule.commandRe == nil || rule.commandRe.MatchString(record.Result.Command)
+}
+
+type metricSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// metricValue 数值、数字字符串和布尔值可作为指标值
+func metricValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("non-numeric value %v", value)
+}
+
+func labelValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// extract 返回提取出的样本及无法转换为数值的次数
+func (rule *MetricRule) extract(record ResultRecord) ([]metricSample, int) {
+	var samples []metricSample
+	errorCount := 0
+	if rule.re != nil {
+		names := rule.re.SubexpNames()
+		for _, m := range rule.re.FindAllStringSubmatch(record.Result.Output, -1) {
+			groups := make(map[string]string, len(names))
+			for i, name := range names {
+				if name != "" {
+					groups[name] = m[i]
+				}
+			}
+			value, err := metricValue(groups[rule.Value])
+			if err != nil {
+				errorCount++
+				continue
+			}
+			labels := make(map[string]string, len(rule.Labels))
+			for label, group := range rule.Labels {
+				labels[label] = groups[group]
+			}
+			samples = append(samples, metricSample{labels, value})
+		}
+		return samples, errorCount
+	}
+
+	if record.Result.Parsed == nil {
+		return nil, 0
+	}
+	document, err := normalizeDocument(record.Result.Parsed)
+	if err != nil {
+		return nil, 1
+	}
+	selected, err := rule.items.Search(document)
+	if err != nil {
+		return nil, 1
+	}
+	items, ok := selected.([]interface{})
+	if !ok {
+		items = []interface{}{selected}
+	}
+	for _, item := range items {
+		raw, err := rule.value.Search(item)
+		if err != nil || raw == nil {
+			errorCount++
+			continue
+		}
+		value, err := metricValue(raw)
+		if err != nil {
+			errorCount++
+			continue
+		}
+		labels := make(map[string]string, len(rule.labels))
+		for label, expression := range rule.labels {
+			v, _ := expression.Search(item)
+			labels[label] = labelValue(v)
+		}
+		samples = append(samples, metricSample{labels, value})
+	}
+	return samples, errorCount
+}
+
+type metricSeries struct {
+	labels  map[string]string
+	value   float64
+	updated time.Time
+}
+
+type MetricRegistry struct {
+	rules  map[string]*MetricRule
+	series map[string]map[string]*metricSeries // 规则名 -> 标签签名 -> 序列
+	errors map[string]int64
+	// script 脚本emit_metric产生的序列，按指标名分组
+	script map[string]map[string]*metricSeries
+	mutex  sync.RWMutex
+}
+
+var metricRules = &MetricRegistry{
+	rules:  make(map[string]*MetricRule),
+	series: make(map[string]map[string]*metricSeries),
+	errors: make(map[string]int64),
+	script: make(map[string]map[string]*metricSeries),
+}
+
+func init() {
+	if metricRulesFile == "" {
+		return
+	}
+	data, err := os.ReadFile(metricRulesFile)
+	if err != nil {
+		logError("metric_rules.load_failed", "load metric rules failed", "file", metricRulesFile, "error", err)
+		return
+	}
+	var rules []MetricRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		logError("metric_rules.load_failed", "load metric rules failed", "file", metricRulesFile, "error", err)
+		return
+	}
+	for _, rule := range rules {
+		if err := metricRules.Put(rule); err != nil {
+			logError("metric_rules.load_failed", "load metric rule failed", "rule", rule.Name, "error", err)
+		}
+	}
+}
+
+func signature(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=\"%s\",", key, labelValueEscaper.Replace(labels[key]))
+	}
+	return b.String()
+}
+
+func (mr *MetricRegistry) Put(rule MetricRule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mr.rules[rule.Name] = &rule
+	// 规则变更后旧序列的标签可能已不一致
+	delete(mr.series, rule.Name)
+	return nil
+}
+
+func (mr *MetricRegistry) Remove(name string) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if _, ok := mr.rules[name]; !ok {
+		return errMetricRuleNotFound
+	}
+	delete(mr.rules, name)
+	delete(mr.series, name)
+	delete(mr.errors, name)
+	return nil
+}
+
+func (mr *MetricRegistry) List() []*MetricRule {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	list := make([]*MetricRule, 0, len(mr.rules))
+	for _, rule := range mr.rules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Observe 对每条执行结果应用匹配的规则，登记后再计算告警
+func (mr *MetricRegistry) Observe(record ResultRecord) {
+	alerts.Evaluate(mr.observe(record))
+}
+
+func (mr *MetricRegistry) observe(record ResultRecord) []alertSample {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	var observed []alertSample
+	now := time.Now()
+	for name, rule := range mr.rules {
+		if !rule.applies(record) {
+			continue
+		}
+		samples, errorCount := rule.extract(record)
+		mr.errors[name] += int64(errorCount)
+		if mr.series[name] == nil {
+			mr.series[name] = make(map[string]*metricSeries)
+		}
+		for _, sample := range samples {
+			sample.labels["connection_id"] = record.ConnectionID
+			sample.labels["host"] = record.Host
+			mr.series[name][signature(sample.labels)] = &metricSeries{sample.labels, sample.value, now}
+			observed = append(observed, alertSample{name, sample.labels, sample.value})
+		}
+	}
+	return observed
+}
+
+// ObserveScript 记录脚本产生的指标，与规则同名的指标被忽略
+func (mr *MetricRegistry) ObserveScript(metric ScriptMetric, connectionID, host string) {
+	if sample, ok := mr.observeScript(metric, connectionID, host); ok {
+		alerts.Evaluate([]alertSample{sample})
+	}
+}
+
+func (mr *MetricRegistry) observeScript(metric ScriptMetric, connectionID, host string) (alertSample, bool) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if _, ok := mr.rules[metric.Name]; ok {
+		return alertSample{}, false
+	}
+	labels := make(map[string]string, len(metric.Labels)+2)
+	for key, value := range metric.Labels {
+		labels[key] = value
+	}
+	labels["connection_id"] = connectionID
+	labels["host"] = host
+	if mr.script[metric.Name] == nil {
+		mr.script[metric.Name] = make(map[string]*metricSeries)
+	}
+	mr.script[metric.Name][signature(labels)] = &metricSeries{labels, metric.Value, time.Now()}
+	return alertSample{metric.Name, labels, metric.Value}, true
+}
+
+// writeSeries 输出一个gauge，过期序列直接删除，目标下线后自然消失
+func writeSeries(w io.Writer, name, help string, series map[string]*metricSeries, ttl time.Duration, now time.Time) {
+	keys := make([]string, 0, len(series))
+	for key, s := range series {
+		if now.Sub(s.updated) > ttl {
+			delete(series, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	out := metricWriter{w}
+	out.family(name, "gauge", help)
+	for _, key := range keys {
+		// key为signature已转义的标签
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.TrimSuffix(key, ","), formatMetricValue(series[key].value))
+	}
+}
+
+func (mr *MetricRegistry) WriteMetrics(w io.Writer) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	now := time.Now()
+	names := make([]string, 0, len(mr.rules))
+	for name := range mr.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rule := mr.rules[name]
+		writeSeries(w, name, rule.Help, mr.series[name], rule.ttl(), now)
+	}
+	scriptNames := make([]string, 0, len(mr.script))
+	for name := range mr.script {
+		scriptNames = append(scriptNames, name)
+	}
+	sort.Strings(scriptNames)
+	for _, name := range scriptNames {
+		writeSeries(w, name, "", mr.script[name], metricDefaultTTL, now)
+	}
+
+	out := metricWriter{w}
+	out.family("collector_metric_extraction_errors_total", "counter", "Values that metric rules failed to convert to numbers.")
+	for _, name := range names {
+		out.sample("collector_metric_extraction_errors_total", float64(mr.errors[name]), "rule", name)
+	}
+}
+
+func registerMetricRuleRoutes(r *gin.Engine) {
+	r.GET("/metrics/rules", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rules": metricRules.List()})
+	})
+
+	r.PUT("/metrics/rules/:name", func(c *gin.Context) {
+		var rule MetricRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		rule.Name = c.Param("name")
+		if err := metricRules.Put(rule); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	})
+
+	r.DELETE("/metrics/rules/:name", func(c *gin.Context) {
+		if err := metricRules.Remove(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "metric rule removed"})
+	})
+}
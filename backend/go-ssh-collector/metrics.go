@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// 服务自身的指标注册在client_golang的注册表中，与抓取结果等其余模块的指标一起经promhttp在 /metrics 上输出。
+// 计数在ConnectionManager和HTTP中间件中直接累加，不依赖日志。
+// 命令耗时按设备host分组，METRICS_HOST_LABEL 为 host（默认）/ hash（sha256前8位）/ none，
+// 超过 METRICS_MAX_HOSTS 个不同host后，新的host计入 "other"
+
+var (
+	metricsHostLabel = getEnv("METRICS_HOST_LABEL", "host")
+	metricsMaxHosts  = int(envInt64("METRICS_MAX_HOSTS", 200))
+)
+
+var (
+	commandDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+	httpDurationBuckets    = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	connectPhaseBuckets    = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// metricWriter 以Prometheus文本格式输出指标，供尚未接入注册表的模块使用，输出在 /metrics 上解析后合并。
+// 标签值按文本格式只转义反斜杠、双引号和换行，整数值不使用科学计数法
+type metricWriter struct {
+	w io.Writer
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// formatLabels labels为交替的标签名和值，输出含花括号，无标签时为空
+func formatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case value == math.Trunc(value) && math.Abs(value) < 1e15:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// family 输出HELP和TYPE行，kind为counter、gauge或histogram
+func (m metricWriter) family(name, kind, help string) {
+	if help != "" {
+		fmt.Fprintf(m.w, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+	}
+	fmt.Fprintf(m.w, "# TYPE %s %s\n", name, kind)
+}
+
+func (m metricWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(m.w, "%s%s %s\n", name, formatLabels(labels...), formatMetricValue(value))
+}
+
+func (m metricWriter) pairCounters(name, help string, labels [2]string, values map[[2]string]uint64) {
+	keys := make([][2]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sortedPairs(keys)
+	m.family(name, "counter", help)
+	for _, key := range keys {
+		m.sample(name, float64(values[key]), labels[0], key[0], labels[1], key[1])
+	}
+}
+
+func sortedPairs(keys [][2]string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+}
+
+type serviceMetrics struct {
+	registry *prometheus.Registry
+
+	connectAttempts  *prometheus.CounterVec
+	connectFailures  *prometheus.CounterVec
+	commands         *prometheus.CounterVec
+	commandFailures  *prometheus.CounterVec
+	commandDurations *prometheus.HistogramVec
+	connectPhases    *prometheus.HistogramVec
+	outputBytes      *prometheus.CounterVec
+	healthChecks     *prometheus.CounterVec
+	slowOperations   *prometheus.CounterVec
+	errorClasses     *prometheus.CounterVec
+	httpRequests     *prometheus.CounterVec
+	httpDurations    *prometheus.HistogramVec
+
+	// hosts 已出现的host标签值，受mutex保护
+	hosts map[string]bool
+	mutex sync.Mutex
+}
+
+var serviceStats = newServiceMetrics()
+
+func newServiceMetrics() *serviceMetrics {
+	m := &serviceMetrics{
+		registry: prometheus.NewRegistry(),
+		connectAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_connect_attempts_total", Help: "Device connect attempts by protocol.",
+		}, []string{"protocol"}),
+		connectFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_connect_failures_total", Help: "Failed device connects by protocol and error class.",
+		}, []string{"protocol", "class"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_commands_total", Help: "Commands executed by protocol.",
+		}, []string{"protocol"}),
+		commandFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_command_failures_total", Help: "Failed commands by protocol and error class.",
+		}, []string{"protocol", "class"}),
+		commandDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "collector_command_duration_seconds", Help: "Command execution time by protocol and device host.",
+			Buckets: commandDurationBuckets,
+		}, []string{"protocol", "host"}),
+		connectPhases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "collector_connect_phase_duration_seconds", Help: "Connect time by protocol and phase (dns, tcp, handshake, auth).",
+			Buckets: connectPhaseBuckets,
+		}, []string{"protocol", "phase"}),
+		outputBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_command_output_bytes_total", Help: "Bytes of command output received from devices.",
+		}, []string{"protocol"}),
+		healthChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_health_checks_total", Help: "Connection keepalive health checks by result.",
+		}, []string{"protocol", "result"}),
+		slowOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_slow_operations_total", Help: "Commands and jobs slower than the slowlog threshold.",
+		}, []string{"kind", "protocol"}),
+		errorClasses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_errors_total", Help: "Connect and command failures by error class and device host.",
+		}, []string{"class", "host"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "collector_http_requests_total", Help: "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "collector_http_request_duration_seconds", Help: "HTTP request latency by method and route.",
+			Buckets: httpDurationBuckets,
+		}, []string{"method", "route"}),
+		hosts: make(map[string]bool),
+	}
+	m.registry.MustRegister(m.connectAttempts, m.connectFailures, m.commands, m.commandFailures,
+		m.commandDurations, m.connectPhases, m.outputBytes, m.healthChecks, m.slowOperations,
+		m.errorClasses, m.httpRequests, m.httpDurations, serviceStateCollector{})
+	return m
+}
+
+// metricErrorClass 错误分类与API错误码相同
+func metricErrorClass(err error) string {
+	code, _ := errorCode(err, http.StatusBadGateway)
+	return code
+}
+
+// hostLabel 按METRICS_HOST_LABEL处理host并限制不同取值的数量，调用方需持有锁
+func (m *serviceMetrics) hostLabel(host string) string {
+	switch metricsHostLabel {
+	case "none":
+		return ""
+	case "hash":
+		sum := sha256.Sum256([]byte(host))
+		host = hex.EncodeToString(sum[:4])
+	}
+	if m.hosts[host] {
+		return host
+	}
+	if len(m.hosts) >= metricsMaxHosts {
+		return "other"
+	}
+	m.hosts[host] = true
+	return host
+}
+
+func (m *serviceMetrics) host(host string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.hostLabel(host)
+}
+
+func (m *serviceMetrics) ObserveConnect(protocol string, err error) {
+	m.connectAttempts.WithLabelValues(protocol).Inc()
+	if err != nil {
+		m.connectFailures.WithLabelValues(protocol, metricErrorClass(err)).Inc()
+	}
+}
+
+// ObserveConnectTiming 只记录已到达的阶段，失败的阶段按失败前的耗时记录
+func (m *serviceMetrics) ObserveConnectTiming(protocol string, timing ConnectTiming) {
+	for phase, ms := range timing.phases() {
+		m.connectPhases.WithLabelValues(protocol, phase).Observe(ms / 1000)
+	}
+}
+
+func (m *serviceMetrics) ObserveCommand(info ConnectionInfo, elapsed time.Duration, result *CommandResult, err error) {
+	m.commands.WithLabelValues(info.Protocol).Inc()
+	if err != nil {
+		m.commandFailures.WithLabelValues(info.Protocol, metricErrorClass(err)).Inc()
+	}
+	m.commandDurations.WithLabelValues(info.Protocol, m.host(info.Host)).Observe(elapsed.Seconds())
+	if result != nil {
+		m.outputBytes.WithLabelValues(info.Protocol).Add(float64(len(result.Output) + len(result.Stderr)))
+	}
+}
+
+func (m *serviceMetrics) ObserveHealthCheck(protocol string, err error) {
+	state := "ok"
+	if err != nil {
+		state = "failed"
+	}
+	m.healthChecks.WithLabelValues(protocol, state).Inc()
+}
+
+// ObserveErrorClass 连接或执行失败按类别和host计数，未归类的计入other
+func (m *serviceMetrics) ObserveErrorClass(class, host string) {
+	if class == "" {
+		class = classOther
+	}
+	m.errorClasses.WithLabelValues(class, m.host(host)).Inc()
+}
+
+// ObserveSlow kind为command或job
+func (m *serviceMetrics) ObserveSlow(kind, protocol string) {
+	m.slowOperations.WithLabelValues(kind, protocol).Inc()
+}
+
+func (m *serviceMetrics) ObserveHTTP(method, route string, status int, elapsed time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDurations.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// metricsMiddleware route为gin注册的路由模板，未匹配的请求记为unmatched，避免路径参数造成高基数
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		serviceStats.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+var (
+	activeConnectionsDesc = prometheus.NewDesc("collector_active_connections",
+		"Open device connections by protocol and status.", []string{"protocol", "status"}, nil)
+	inflightCommandsDesc = prometheus.NewDesc("collector_inflight_commands",
+		"Commands currently executing on devices.", nil, nil)
+	inflightUtilizationDesc = prometheus.NewDesc("collector_inflight_commands_utilization",
+		"Inflight commands as a fraction of READY_MAX_INFLIGHT.", nil, nil)
+	jobsDesc = prometheus.NewDesc("collector_jobs",
+		"Jobs by status; pending jobs are the queue depth.", []string{"status"}, nil)
+	sweepRunsDesc = prometheus.NewDesc("collector_sweep_runs_total",
+		"Background sweeper runs.", []string{"sweep"}, nil)
+	sweepLastRunDesc = prometheus.NewDesc("collector_sweep_last_run_timestamp_seconds",
+		"Unix time of the last sweeper run.", []string{"sweep"}, nil)
+	eventsPublishedDesc = prometheus.NewDesc("collector_events_published_total",
+		"Lifecycle events published by type.", []string{"type"}, nil)
+	eventsDroppedDesc = prometheus.NewDesc("collector_events_dropped_total",
+		"Lifecycle events dropped because a subscriber queue was full.", nil, nil)
+	eventSubscribersDesc = prometheus.NewDesc("collector_event_subscribers",
+		"Current lifecycle event subscribers, including the sink forwarder.", nil, nil)
+	syslogSentDesc = prometheus.NewDesc("collector_syslog_forward_sent_total",
+		"Audit and security events sent to the syslog forwarder endpoint.", nil, nil)
+	syslogDroppedDesc = prometheus.NewDesc("collector_syslog_forward_dropped_total",
+		"Events dropped because the syslog queue was full or the endpoint unreachable.", nil, nil)
+)
+
+// serviceStateCollector 当前状态在抓取时读取，不预先声明序列
+type serviceStateCollector struct{}
+
+func (serviceStateCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (serviceStateCollector) Collect(ch chan<- prometheus.Metric) {
+	connections := collector.Stats()
+	for key, n := range connections.byProtocolStatus {
+		ch <- prometheus.MustNewConstMetric(activeConnectionsDesc, prometheus.GaugeValue, float64(n), key[0], key[1])
+	}
+
+	ch <- prometheus.MustNewConstMetric(inflightCommandsDesc, prometheus.GaugeValue, float64(collector.Inflight()))
+	if readyMaxInflight > 0 {
+		ch <- prometheus.MustNewConstMetric(inflightUtilizationDesc, prometheus.GaugeValue, float64(collector.Inflight())/float64(readyMaxInflight))
+	}
+
+	for status, n := range jobs.Stats() {
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(n), status)
+	}
+
+	runs, last := sweepStats()
+	for name, n := range runs {
+		ch <- prometheus.MustNewConstMetric(sweepRunsDesc, prometheus.CounterValue, float64(n), name)
+		ch <- prometheus.MustNewConstMetric(sweepLastRunDesc, prometheus.GaugeValue, float64(last[name].Unix()), name)
+	}
+
+	published, dropped, subscribers := events.Stats()
+	for eventType, n := range published {
+		ch <- prometheus.MustNewConstMetric(eventsPublishedDesc, prometheus.CounterValue, float64(n), eventType)
+	}
+	ch <- prometheus.MustNewConstMetric(eventsDroppedDesc, prometheus.CounterValue, float64(dropped))
+	ch <- prometheus.MustNewConstMetric(eventSubscribersDesc, prometheus.GaugeValue, float64(subscribers))
+
+	if syslogForwarder != nil {
+		ch <- prometheus.MustNewConstMetric(syslogSentDesc, prometheus.CounterValue, float64(atomic.LoadInt64(&syslogForwarder.sent)))
+		ch <- prometheus.MustNewConstMetric(syslogDroppedDesc, prometheus.CounterValue, float64(atomic.LoadInt64(&syslogForwarder.dropped)))
+	}
+}
+
+// textGatherer 解析仍以文本格式输出的指标，与注册表中的指标一起经promhttp输出
+type textGatherer func(w io.Writer)
+
+func (write textGatherer) Gather() ([]*dto.MetricFamily, error) {
+	var buf bytes.Buffer
+	write(&buf)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		list = append(list, family)
+	}
+	return list, nil
+}
+
+// metricsHandler 服务自身的指标来自client_golang注册表，其余模块和抓取结果按来源分别解析，
+// 某个来源无法解析时仍输出其余指标。压缩由compressionMiddleware处理
+func metricsHandler() http.Handler {
+	gatherers := prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return serviceStats.registry.Gather() }),
+	}
+	for _, write := range []func(io.Writer){
+		scrapes.WriteMetrics,
+		metricRules.WriteMetrics,
+		alerts.WriteMetrics,
+		notifier.WriteMetrics,
+		resultStore.WriteMetrics,
+		resultCache.WriteMetrics,
+		sinks.WriteMetrics,
+		rateLimiter.WriteMetrics,
+		grpcStats.WriteMetrics,
+		writeBuildInfoMetric,
+		writeSaturationMetrics,
+	} {
+		gatherers = append(gatherers, textGatherer(write))
+	}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
+		ErrorHandling:      promhttp.ContinueOnError,
+		ErrorLog:           metricsErrorLog{},
+		DisableCompression: true,
+	})
+}
+
+type metricsErrorLog struct{}
+
+func (metricsErrorLog) Println(v ...interface{}) {
+	logWarn("metrics.gather_failed", "failed to gather metrics", "error", fmt.Sprint(v...))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useServiceMetrics 测试期间使用新的计数，避免受其他测试影响
+func useServiceMetrics(t *testing.T) *serviceMetrics {
+	t.Helper()
+	previous := serviceStats
+	serviceStats = newServiceMetrics()
+	t.Cleanup(func() { serviceStats = previous })
+	return serviceStats
+}
+
+var (
+	metricSampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})? (\S+)$`)
+	metricLabelPair  = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\\n]|\\[\\"n])*)"(,|$)`)
+)
+
+// parseMetrics 按Prometheus文本格式校验输出，返回“名称{标签}”到值的映射。
+// 每个样本都要属于已声明TYPE的指标族，同一序列不能重复
+func parseMetrics(t *testing.T, data []byte) map[string]float64 {
+	t.Helper()
+	series := map[string]float64{}
+	types := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 || types[fields[2]] != "" {
+				t.Fatalf("bad or repeated TYPE line %q", line)
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		m := metricSampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed sample %q", line)
+		}
+		for labels := m[3]; labels != ""; {
+			pair := metricLabelPair.FindString(labels)
+			if pair == "" {
+				t.Fatalf("malformed labels in %q", line)
+			}
+			labels = labels[len(pair):]
+		}
+		family := m[1]
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(family, suffix); types[base] == "histogram" {
+				family = base
+			}
+		}
+		if types[family] == "" {
+			t.Fatalf("sample %q has no TYPE", line)
+		}
+		value, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			t.Fatalf("bad value in %q", line)
+		}
+		key := m[1] + m[2]
+		if _, ok := series[key]; ok {
+			t.Fatalf("duplicate series %s", key)
+		}
+		series[key] = value
+	}
+	return series
+}
+
+func TestMetricWriterFormat(t *testing.T) {
+	var buf bytes.Buffer
+	out := metricWriter{&buf}
+	out.family("test_total", "counter", "Help with a \\ and\na newline.")
+	out.sample("test_total", 1e6, "path", `C:\dir "x"`+"\n")
+	out.sample("test_total", 0.25)
+
+	want := `# HELP test_total Help with a \\ and\na newline.
+# TYPE test_total counter
+test_total{path="C:\\dir \"x\"\n"} 1000000
+test_total 0.25
+`
+	if buf.String() != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	parseMetrics(t, buf.Bytes())
+}
+
+func TestServiceMetricsSeries(t *testing.T) {
+	useServiceMetrics(t)
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	if _, err := collector.ExecuteCommand(id, "uptime"); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if _, err := collector.ExecuteCommand("missing", "uptime"); err == nil {
+		t.Fatal("execute on a missing connection succeeded")
+	}
+
+	r := newRouter()
+	apiRequest(r, "", http.MethodGet, "/connections/"+id, "")
+	w := apiRequest(r, "", http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	series := parseMetrics(t, w.Body.Bytes())
+
+	want := map[string]float64{
+		`collector_connect_attempts_total{protocol="ssh"}`:                                     1,
+		`collector_commands_total{protocol="ssh"}`:                                             1,
+		`collector_command_duration_seconds_count{host="127.0.0.1",protocol="ssh"}`:            1,
+		`collector_command_duration_seconds_bucket{host="127.0.0.1",protocol="ssh",le="+Inf"}`: 1,
+		`collector_http_requests_total{code="200",method="GET",route="/connections/:id"}`:      1,
+		`collector_http_request_duration_seconds_count{method="GET",route="/connections/:id"}`: 1,
+		`collector_active_connections{protocol="ssh",status="connected"}`:                      1,
+		`collector_command_output_bytes_total{protocol="ssh"}`:                                 float64(len("sh: uptime: command not found\n")),
+	}
+	for key, value := range want {
+		if got, ok := series[key]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", key, got, ok, value)
+		}
+	}
+	// 直方图的桶是累计的
+	previous := -1.0
+	for _, bound := range commandDurationBuckets {
+		key := `collector_command_duration_seconds_bucket{host="127.0.0.1",protocol="ssh",le="` + formatMetricValue(bound) + `"}`
+		if series[key] < previous {
+			t.Fatalf("bucket %s decreased", key)
+		}
+		previous = series[key]
+	}
+}
+
+// 抓取结果与服务指标合并输出；某个来源无法解析时只丢弃该来源
+func TestMetricsMergeTextSources(t *testing.T) {
+	useServiceMetrics(t).ObserveConnect("ssh", nil)
+	publish := func(prefix, output string) {
+		scrapes.mutex.Lock()
+		scrapes.scrapes[prefix] = &Scrape{ID: prefix, Config: ScrapeConfig{ConnectionID: "ssh-test", Prefix: prefix}, output: []byte(output)}
+		scrapes.mutex.Unlock()
+		t.Cleanup(func() {
+			scrapes.mutex.Lock()
+			delete(scrapes.scrapes, prefix)
+			scrapes.mutex.Unlock()
+		})
+	}
+	publish("edge", "# TYPE edge_node_load1 gauge\nedge_node_load1 0.5\n")
+
+	r := newRouter()
+	w := apiRequest(r, "", http.MethodGet, "/metrics", "")
+	series := parseMetrics(t, w.Body.Bytes())
+	if w.Code != http.StatusOK || series["edge_node_load1"] != 0.5 || series[`collector_connect_attempts_total{protocol="ssh"}`] != 1 {
+		t.Fatalf("status %d:\n%s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", w.Header().Get("Content-Type"))
+	}
+
+	publish("broken", "broken_metric{ 1\n")
+	series = parseMetrics(t, apiRequest(r, "", http.MethodGet, "/metrics", "").Body.Bytes())
+	if _, ok := series[`collector_connect_attempts_total{protocol="ssh"}`]; !ok {
+		t.Fatal("service metrics dropped because of a malformed scrape")
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	modbusReadCoils          = 0x01
+	modbusReadDiscreteInputs = 0x02
+	modbusReadHolding        = 0x03
+	modbusReadInput          = 0x04
+	// 单次读保持/输入寄存器的协议上限
+	modbusMaxRegisters = 125
+)
+
+var modbusTables = map[string]byte{
+	"":         modbusReadHolding,
+	"holding":  modbusReadHolding,
+	"input":    modbusReadInput,
+	"coil":     modbusReadCoils,
+	"discrete": modbusReadDiscreteInputs,
+}
+
+// 各数据类型占用的寄存器数，string按count指定
+var modbusTypeWidths = map[string]int{
+	"":        1,
+	"uint16":  1,
+	"int16":   1,
+	"uint32":  2,
+	"int32":   2,
+	"float32": 2,
+	"uint64":  4,
+	"int64":   4,
+	"float64": 4,
+	"string":  0,
+}
+
+var modbusExceptions = map[byte]string{
+	0x01: "illegal_function",
+	0x02: "illegal_data_address",
+	0x03: "illegal_data_value",
+	0x04: "server_device_failure",
+	0x05: "acknowledge",
+	0x06: "server_device_busy",
+	0x08: "memory_parity_error",
+	0x0A: "gateway_path_unavailable",
+	0x0B: "gateway_target_failed",
+}
+
+// ModbusError 设备返回的异常响应
+type ModbusError struct {
+	Code  byte
+	Class string
+}
+
+func (e *ModbusError) Error() string {
+	return fmt.Sprintf("modbus exception %d (%s)", e.Code, e.Class)
+}
+
+type ModbusRegister struct {
+	Name    string `json:"name" binding:"required"`
+	Table   string `json:"table"`
+	Address uint16 `json:"address"`
+	// Count 仅string类型需要，表示寄存器数量
+	Count int    `json:"count"`
+	Type  string `json:"type"`
+	// Scale 非零时数值乘以该系数
+	Scale float64 `json:"scale"`
+	// ByteOrder/WordOrder: big(默认) / little
+	ByteOrder string `json:"byte_order"`
+	WordOrder string `json:"word_order"`
+}
+
+type ModbusMap struct {
+	Name      string           `json:"name" binding:"required"`
+	Registers []ModbusRegister `json:"registers" binding:"required"`
+}
+
+func (reg ModbusRegister) width() (int, error) {
+	width, ok := modbusTypeWidths[reg.Type]
+	if !ok {
+		return 0, fmt.Errorf("register %s: unsupported type %s", reg.Name, reg.Type)
+	}
+	if reg.Type == "string" {
+		width = reg.Count
+	}
+	if reg.Count > 0 && reg.Type != "string" && reg.Count != width {
+		return 0, fmt.Errorf("register %s: count %d does not match type %s", reg.Name, reg.Count, reg.Type)
+	}
+	if width < 1 || width > modbusMaxRegisters {
+		return 0, fmt.Errorf("register %s: invalid register count", reg.Name)
+	}
+	return width, nil
+}
+
+func (m ModbusMap) validate() error {
+	seen := map[string]bool{}
+	for _, reg := range m.Registers {
+		if seen[reg.Name] {
+			return fmt.Errorf("duplicate register name: %s", reg.Name)
+		}
+		seen[reg.Name] = true
+		if _, ok := modbusTables[reg.Table]; !ok {
+			return fmt.Errorf("register %s: unsupported table %s", reg.Name, reg.Table)
+		}
+		if reg.Table == "coil" || reg.Table == "discrete" {
+			continue
+		}
+		if _, err := reg.width(); err != nil {
+			return err
+		}
+		for _, order := range []string{reg.ByteOrder, reg.WordOrder} {
+			if order != "" && order != "big" && order != "little" {
+				return fmt.Errorf("register %s: invalid order %s", reg.Name, order)
+			}
+		}
+	}
+	return nil
+}
+
+type ModbusDeviceConfig struct {
+	Host    string `json:"host" binding:"required"`
+	Port    int    `json:"port"`
+	UnitID  byte   `json:"unit_id"`
+	Timeout int    `json:"timeout"`
+}
+
+// ModbusDevice 复用TCP连接；Modbus为严格的请求/响应协议，同一设备的请求串行执行
+type ModbusDevice struct {
+	ID        string
+	Config    ModbusDeviceConfig
+	CreatedAt time.Time
+
+	conn    net.Conn
+	txID    uint16
+	timeout time.Duration
+	mutex   sync.Mutex
+}
+
+func (d *ModbusDevice) connect() error {
+	address := net.JoinHostPort(d.Config.Host, strconv.Itoa(d.Config.Port))
+	conn, err := safeDialer(d.timeout).Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *ModbusDevice) close() {
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+// roundTrip 发送一个PDU并返回响应PDU数据，调用方需持有mutex
+func (d *ModbusDevice) roundTrip(function byte, data []byte) ([]byte, error) {
+	if d.conn == nil {
+		if err := d.connect(); err != nil {
+			return nil, err
+		}
+	}
+	d.conn.SetDeadline(time.Now().Add(d.timeout))
+
+	d.txID++
+	frame := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint16(frame[0:], d.txID)
+	binary.BigEndian.PutUint16(frame[2:], 0)
+	binary.BigEndian.PutUint16(frame[4:], uint16(2+len(data)))
+	frame[6] = d.Config.UnitID
+	frame[7] = function
+	copy(frame[8:], data)
+	if _, err := d.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(d.conn, header); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 260 {
+			return nil, fmt.Errorf("invalid modbus frame length %d", length)
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(d.conn, pdu); err != nil {
+			return nil, err
+		}
+		// 丢弃之前超时请求的迟到响应
+		if binary.BigEndian.Uint16(header[0:]) != d.txID {
+			continue
+		}
+
+		if pdu[0] == function|0x80 {
+			code := byte(0)
+			if len(pdu) > 1 {
+				code = pdu[1]
+			}
+			class, ok := modbusExceptions[code]
+			if !ok {
+				class = "unknown_exception"
+			}
+			return nil, &ModbusError{Code: code, Class: class}
+		}
+		if pdu[0] != function {
+			return nil, fmt.Errorf("unexpected function code %d", pdu[0])
+		}
+		return pdu[1:], nil
+	}
+}
+
+// Read 读取连续的寄存器或线圈，连接断开时重连并重试一次
+func (d *ModbusDevice) Read(function byte, address uint16, count int) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	req := make([]byte, 4)
+	binary.BigEndian.PutUint16(req[0:], address)
+	binary.BigEndian.PutUint16(req[2:], uint16(count))
+
+	var resp []byte
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err = d.roundTrip(function, req)
+		if err == nil {
+			break
+		}
+		var modbusErr *ModbusError
+		if errors.As(err, &modbusErr) {
+			return nil, err
+		}
+		// 网络错误（断管、EOF、超时）后连接状态不可信，重新建立
+		d.close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 1 || int(resp[0]) != len(resp)-1 {
+		return nil, fmt.Errorf("malformed modbus response")
+	}
+	return resp[1:], nil
+}
+
+func (d *ModbusDevice) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.close()
+}
+
+// orderRegisters 按字节序与字序将寄存器数据整理为大端字节
+func orderRegisters(raw []byte, byteOrder, wordOrder string) []byte {
+	data := make([]byte, len(raw))
+	copy(data, raw)
+	if byteOrder == "little" {
+		for i := 0; i+1 < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+	if wordOrder == "little" {
+		for i, j := 0, len(data)-2; i < j; i, j = i+2, j-2 {
+			data[i], data[i+1], data[j], data[j+1] = data[j], data[j+1], data[i], data[i+1]
+		}
+	}
+	return data
+}
+
+func decodeRegister(reg ModbusRegister, raw []byte) interface{} {
+	data := orderRegisters(raw, reg.ByteOrder, reg.WordOrder)
+
+	var value float64
+	switch reg.Type {
+	case "string":
+		end := len(data)
+		for end > 0 && (data[end-1] == 0 || data[end-1] == ' ') {
+			end--
+		}
+		return string(data[:end])
+	case "", "uint16":
+		value = float64(binary.BigEndian.Uint16(data))
+	case "int16":
+		value = float64(int16(binary.BigEndian.Uint16(data)))
+	case "uint32":
+		value = float64(binary.BigEndian.Uint32(data))
+	case "int32":
+		value = float64(int32(binary.BigEndian.Uint32(data)))
+	case "float32":
+		value = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case "uint64":
+		value = float64(binary.BigEndian.Uint64(data))
+	case "int64":
+		value = float64(int64(binary.BigEndian.Uint64(data)))
+	case "float64":
+		value = math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	if reg.Scale != 0 {
+		value *= reg.Scale
+	}
+	return value
+}
+
+type ModbusRegistry struct {
+	devices map[string]*ModbusDevice
+	maps    map[string]ModbusMap
+	mutex   sync.RWMutex
+}
+
+var modbus = &ModbusRegistry{
+	devices: make(map[string]*ModbusDevice),
+	maps:    make(map[string]ModbusMap),
+}
+
+func (r *ModbusRegistry) lookup(deviceID, mapName string) (*ModbusDevice, ModbusMap, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	device, ok := r.devices[deviceID]
+	if !ok {
+		return nil, ModbusMap{}, fmt.Errorf("modbus device not found")
+	}
+	m, ok := r.maps[mapName]
+	if !ok {
+		return nil, ModbusMap{}, fmt.Errorf("register map not found: %s", mapName)
+	}
+	return device, m, nil
+}
+
+type ModbusReadRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+	Map      string `json:"map" binding:"required"`
+	// Names 为空时读取映射中的全部寄存器
+	Names []string `json:"names"`
+}
+
+type ModbusReadResult struct {
+	DeviceID  string                 `json:"device_id"`
+	Map       string                 `json:"map"`
+	Values    map[string]interface{} `json:"values"`
+	Errors    map[string]gin.H       `json:"errors,omitempty"`
+	Duration  float64                `json:"duration"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+func modbusErrorInfo(err error) gin.H {
+	info := gin.H{"error": err.Error()}
+	var modbusErr *ModbusError
+	if errors.As(err, &modbusErr) {
+		info["exception_code"] = modbusErr.Code
+		info["error_class"] = modbusErr.Class
+	}
+	return info
+}
+
+// ReadMap 读取映射中的寄存器；单个寄存器的异常不影响其他寄存器
+func (r *ModbusRegistry) ReadMap(req ModbusReadRequest) (*ModbusReadResult, error) {
+	device, m, err := r.lookup(req.DeviceID, req.Map)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range req.Names {
+		wanted[name] = true
+	}
+
+	start := time.Now()
+	result := &ModbusReadResult{
+		DeviceID: device.ID,
+		Map:      m.Name,
+		Values:   map[string]interface{}{},
+		Errors:   map[string]gin.H{},
+	}
+	for _, reg := range m.Registers {
+		if len(wanted) > 0 && !wanted[reg.Name] {
+			continue
+		}
+
+		function := modbusTables[reg.Table]
+		if function == modbusReadCoils || function == modbusReadDiscreteInputs {
+			data, err := device.Read(function, reg.Address, 1)
+			if err != nil {
+				result.Errors[reg.Name] = modbusErrorInfo(err)
+				continue
+			}
+			result.Values[reg.Name] = len(data) > 0 && data[0]&1 == 1
+			continue
+		}
+
+		width, _ := reg.width()
+		data, err := device.Read(function, reg.Address, width)
+		if err != nil {
+			result.Errors[reg.Name] = modbusErrorInfo(err)
+			continue
+		}
+		if len(data) != width*2 {
+			result.Errors[reg.Name] = gin.H{"error": "short register response"}
+			continue
+		}
+		result.Values[reg.Name] = decodeRegister(reg, data)
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+func registerModbusRoutes(r *gin.Engine) {
+	// 注册设备
+	r.POST("/modbus/devices", func(c *gin.Context) {
+		var config ModbusDeviceConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if config.Port == 0 {
+			config.Port = 502
+		}
+		timeout := 5 * time.Second
+		if config.Timeout > 0 {
+			timeout = time.Duration(config.Timeout) * time.Second
+		}
+
+		device := &ModbusDevice{
+			ID:        fmt.Sprintf("modbus:%s:%d:%d", config.Host, config.Port, config.UnitID),
+			Config:    config,
+			CreatedAt: time.Now(),
+			timeout:   timeout,
+		}
+
+		modbus.mutex.Lock()
+		if old, ok := modbus.devices[device.ID]; ok {
+			old.Close()
+		}
+		modbus.devices[device.ID] = device
+		modbus.mutex.Unlock()
+
+		c.JSON(http.StatusOK, gin.H{
+			"device_id": device.ID,
+			"message":   "Modbus device registered",
+		})
+	})
+
+	r.DELETE("/modbus/devices/:id", func(c *gin.Context) {
+		modbus.mutex.Lock()
+		device, ok := modbus.devices[c.Param("id")]
+		delete(modbus.devices, c.Param("id"))
+		modbus.mutex.Unlock()
+
+		if !ok {
+			respondError(c, http.StatusNotFound, errors.New("modbus device not found"))
+			return
+		}
+		device.Close()
+		c.JSON(http.StatusOK, gin.H{"message": "Modbus device removed"})
+	})
+
+	// 定义命名寄存器映射
+	r.POST("/modbus/maps", func(c *gin.Context) {
+		var m ModbusMap
+		if err := c.ShouldBindJSON(&m); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := m.validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		modbus.mutex.Lock()
+		modbus.maps[m.Name] = m
+		modbus.mutex.Unlock()
+
+		c.JSON(http.StatusOK, gin.H{
+			"map":       m.Name,
+			"registers": len(m.Registers),
+		})
+	})
+
+	r.GET("/modbus/maps", func(c *gin.Context) {
+		modbus.mutex.RLock()
+		maps := make([]ModbusMap, 0, len(modbus.maps))
+		for _, m := range modbus.maps {
+			maps = append(maps, m)
+		}
+		modbus.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"maps": maps})
+	})
+
+	r.POST("/modbus/read", func(c *gin.Context) {
+		var req ModbusReadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result, err := modbus.ReadMap(req)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
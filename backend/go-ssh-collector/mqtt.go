@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gin-gonic/gin"
+)
+
+type MQTTBrokerConfig struct {
+	Name        string `json:"name" binding:"required"`
+	URL         string `json:"url" binding:"required"`
+	ClientID    string `json:"client_id"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PasswordRef string `json:"password_ref"`
+	SkipVerify  bool   `json:"skip_verify"`
+	// CleanSession 默认false，让broker在重连后恢复会话与QoS1/2消息
+	CleanSession bool `json:"clean_session"`
+}
+
+type MQTTBroker struct {
+	Config    MQTTBrokerConfig
+	CreatedAt time.Time
+
+	client    mqtt.Client
+	connected int32
+}
+
+type MQTTSubscriptionRequest struct {
+	Broker    string `json:"broker" binding:"required"`
+	Topic     string `json:"topic" binding:"required"`
+	QoS       byte   `json:"qos"`
+	ParseJSON bool   `json:"parse_json"`
+}
+
+type MQTTSubscription struct {
+	ID        string
+	Request   MQTTSubscriptionRequest
+	CreatedAt time.Time
+
+	messages      int64
+	parseErrors   int64
+	lastMessageAt atomic.Value
+}
+
+func (s *MQTTSubscription) view() gin.H {
+	view := gin.H{
+		"id":           s.ID,
+		"broker":       s.Request.Broker,
+		"topic":        s.Request.Topic,
+		"qos":          s.Request.QoS,
+		"parse_json":   s.Request.ParseJSON,
+		"messages":     atomic.LoadInt64(&s.messages),
+		"parse_errors": atomic.LoadInt64(&s.parseErrors),
+		"created_at":   s.CreatedAt,
+	}
+	if t, ok := s.lastMessageAt.Load().(time.Time); ok {
+		view["last_message_at"] = t
+	}
+	return view
+}
+
+type MQTTMessage struct {
+	Broker     string      `json:"broker"`
+	Topic      string      `json:"topic"`
+	QoS        byte        `json:"qos"`
+	Retained   bool        `json:"retained"`
+	Payload    interface{} `json:"payload"`
+	ReceivedAt time.Time   `json:"received_at"`
+}
+
+type MQTTManager struct {
+	brokers       map[string]*MQTTBroker
+	subscriptions map[string]*MQTTSubscription
+	mutex         sync.RWMutex
+}
+
+var mqttManager = &MQTTManager{
+	brokers:       make(map[string]*MQTTBroker),
+	subscriptions: make(map[string]*MQTTSubscription),
+}
+
+// handler 将消息转发到结果输出，按需解析JSON
+func (s *MQTTSubscription) handler() mqtt.MessageHandler {
+	return func(_ mqtt.Client, msg mqtt.Message) {
+		atomic.AddInt64(&s.messages, 1)
+		s.lastMessageAt.Store(time.Now())
+
+		var payload interface{} = string(msg.Payload())
+		if s.Request.ParseJSON {
+			var parsed interface{}
+			if err := json.Unmarshal(msg.Payload(), &parsed); err != nil {
+				atomic.AddInt64(&s.parseErrors, 1)
+			} else {
+				payload = parsed
+			}
+		}
+
+		sinks.Publish("mqtt", MQTTMessage{
+			Broker:     s.Request.Broker,
+			Topic:      msg.Topic(),
+			QoS:        msg.Qos(),
+			Retained:   msg.Retained(),
+			Payload:    payload,
+			ReceivedAt: time.Now(),
+		})
+	}
+}
+
+// brokerSubscriptions 返回某个broker上的全部订阅
+func (m *MQTTManager) brokerSubscriptions(broker string) []*MQTTSubscription {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var subs []*MQTTSubscription
+	for _, sub := range m.subscriptions {
+		if sub.Request.Broker == broker {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (m *MQTTManager) AddBroker(config MQTTBrokerConfig) error {
+	password, err := credentialValue(config.Password, config.PasswordRef)
+	if err != nil {
+		return err
+	}
+	if config.ClientID == "" {
+		config.ClientID = "go-ssh-collector-" + newID()[:8]
+	}
+
+	broker := &MQTTBroker{Config: config, CreatedAt: time.Now()}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.URL).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(password).
+		SetCleanSession(config.CleanSession).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetTLSConfig(&tls.Config{InsecureSkipVerify: config.SkipVerify}).
+		SetDialer(safeDialer(30 * time.Second)).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			atomic.StoreInt32(&broker.connected, 0)
+			logWarn("mqtt.connection_lost", "mqtt broker connection lost", "broker", config.Name, "error", err)
+		}).
+		// 每次(重)连接后重新订阅；broker未保留会话时订阅不会丢失
+		SetOnConnectHandler(func(client mqtt.Client) {
+			atomic.StoreInt32(&broker.connected, 1)
+			for _, sub := range m.brokerSubscriptions(config.Name) {
+				token := client.Subscribe(sub.Request.Topic, sub.Request.QoS, sub.handler())
+				if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+					logWarn("mqtt.resubscribe_failed", "mqtt resubscribe failed", "broker", config.Name, "topic", sub.Request.Topic, "error", token.Error())
+				}
+			}
+		})
+	broker.client = mqtt.NewClient(opts)
+
+	token := broker.client.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		broker.client.Disconnect(0)
+		return fmt.Errorf("timeout connecting to broker")
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to broker: %w", err)
+	}
+
+	m.mutex.Lock()
+	old, exists := m.brokers[config.Name]
+	m.brokers[config.Name] = broker
+	m.mutex.Unlock()
+
+	// 替换broker时已有订阅由OnConnect在新连接上恢复
+	if exists {
+		old.client.Disconnect(250)
+	}
+	return nil
+}
+
+func (m *MQTTManager) RemoveBroker(name string) error {
+	m.mutex.Lock()
+	broker, ok := m.brokers[name]
+	delete(m.brokers, name)
+	for id, sub := range m.subscriptions {
+		if sub.Request.Broker == name {
+			delete(m.subscriptions, id)
+		}
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("mqtt broker not found")
+	}
+	broker.client.Disconnect(250)
+	return nil
+}
+
+func (m *MQTTManager) Subscribe(req MQTTSubscriptionRequest) (*MQTTSubscription, int, error) {
+	if req.QoS > 2 {
+		return nil, http.StatusBadRequest, fmt.Errorf("qos must be 0, 1 or 2")
+	}
+
+	m.mutex.Lock()
+	broker, ok := m.brokers[req.Broker]
+	if !ok {
+		m.mutex.Unlock()
+		return nil, http.StatusNotFound, fmt.Errorf("mqtt broker not found")
+	}
+	// paho按主题过滤器分发消息，同一broker上的相同过滤器只能有一个订阅
+	for _, sub := range m.subscriptions {
+		if sub.Request.Broker == req.Broker && sub.Request.Topic == req.Topic {
+			m.mutex.Unlock()
+			return nil, http.StatusConflict, fmt.Errorf("topic already subscribed: %s", req.Topic)
+		}
+	}
+	sub := &MQTTSubscription{ID: newID(), Request: req, CreatedAt: time.Now()}
+	m.subscriptions[sub.ID] = sub
+	m.mutex.Unlock()
+
+	token := broker.client.Subscribe(req.Topic, req.QoS, sub.handler())
+	if !token.WaitTimeout(10 * time.Second) {
+		// 未连接时订阅会在重连后由OnConnect补上
+		return sub, http.StatusOK, nil
+	}
+	if err := token.Error(); err != nil {
+		m.mutex.Lock()
+		delete(m.subscriptions, sub.ID)
+		m.mutex.Unlock()
+		return nil, http.StatusBadGateway, fmt.Errorf("subscribe failed: %v", err)
+	}
+	return sub, http.StatusOK, nil
+}
+
+func (m *MQTTManager) Unsubscribe(id string) bool {
+	m.mutex.Lock()
+	sub, ok := m.subscriptions[id]
+	delete(m.subscriptions, id)
+	var broker *MQTTBroker
+	if ok {
+		broker = m.brokers[sub.Request.Broker]
+	}
+	m.mutex.Unlock()
+
+	if broker != nil {
+		broker.client.Unsubscribe(sub.Request.Topic)
+	}
+	return ok
+}
+
+func registerMQTTRoutes(r *gin.Engine) {
+	r.POST("/mqtt/brokers", func(c *gin.Context) {
+		var config MQTTBrokerConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := mqttManager.AddBroker(config); err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"broker":  config.Name,
+			"message": "MQTT broker connected",
+		})
+	})
+
+	r.GET("/mqtt/brokers", func(c *gin.Context) {
+		mqttManager.mutex.RLock()
+		brokers := make([]gin.H, 0, len(mqttManager.brokers))
+		for name, broker := range mqttManager.brokers {
+			brokers = append(brokers, gin.H{
+				"name":       name,
+				"url":        broker.Config.URL,
+				"client_id":  broker.Config.ClientID,
+				"connected":  atomic.LoadInt32(&broker.connected) == 1,
+				"created_at": broker.CreatedAt,
+			})
+		}
+		mqttManager.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"brokers": brokers})
+	})
+
+	r.DELETE("/mqtt/brokers/:name", func(c *gin.Context) {
+		if err := mqttManager.RemoveBroker(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "MQTT broker removed"})
+	})
+
+	r.POST("/mqtt/subscriptions", func(c *gin.Context) {
+		var req MQTTSubscriptionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		sub, status, err := mqttManager.Subscribe(req)
+		if err != nil {
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, sub.view())
+	})
+
+	r.GET("/mqtt/subscriptions", func(c *gin.Context) {
+		mqttManager.mutex.RLock()
+		subs := make([]gin.H, 0, len(mqttManager.subscriptions))
+		for _, sub := range mqttManager.subscriptions {
+			subs = append(subs, sub.view())
+		}
+		mqttManager.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"subscriptions": subs})
+	})
+
+	r.GET("/mqtt/subscriptions/:id", func(c *gin.Context) {
+		mqttManager.mutex.RLock()
+		sub, ok := mqttManager.subscriptions[c.Param("id")]
+		mqttManager.mutex.RUnlock()
+
+		if !ok {
+			respondError(c, http.StatusNotFound, errors.New("subscription not found"))
+			return
+		}
+		c.JSON(http.StatusOK, sub.view())
+	})
+
+	r.DELETE("/mqtt/subscriptions/:id", func(c *gin.Context) {
+		if !mqttManager.Unsubscribe(c.Param("id")) {
+			respondError(c, http.StatusNotFound, errors.New("subscription not found"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "subscription removed"})
+	})
+}
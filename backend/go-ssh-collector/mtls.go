@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 客户端证书认证：配置TLS_CLIENT_CA_FILE后校验客户端证书，证书字段映射为调用方身份。
+// TLS_CLIENT_AUTH=require 时握手阶段即要求证书；optional 时证书与API Key/JWT任一即可
+
+var (
+	tlsClientCAFile = getEnv("TLS_CLIENT_CA_FILE", "")
+	tlsClientAuth   = getEnv("TLS_CLIENT_AUTH", "optional")
+	// 身份与命名空间取自证书字段：cn、ou、san（首个DNS/URI/邮箱）或 oid:<点分OID>
+	tlsClientIdentity  = getEnv("TLS_CLIENT_IDENTITY", "cn")
+	tlsClientNamespace = getEnv("TLS_CLIENT_NAMESPACE", "")
+	tlsClientRole      = getEnv("TLS_CLIENT_ROLE", roleOperator)
+)
+
+var errClientCertRevoked = errors.New("client certificate revoked")
+
+// clientCertRevoked 吊销检查钩子（CRL/OCSP），返回非nil时拒绝握手，默认不检查
+var clientCertRevoked func(cert *x509.Certificate, chain []*x509.Certificate) error
+
+func clientCertAuthEnabled() bool {
+	return tlsClientCAFile != ""
+}
+
+// configureClientAuth 在服务端TLS配置上启用客户端证书校验
+func configureClientAuth(config *tls.Config) error {
+	if !clientCertAuthEnabled() {
+		return nil
+	}
+	data, err := os.ReadFile(tlsClientCAFile)
+	if err != nil {
+		return fmt.Errorf("load client ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("load client ca bundle: no certificates in %s", tlsClientCAFile)
+	}
+	config.ClientCAs = pool
+	switch tlsClientAuth {
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("unsupported TLS_CLIENT_AUTH %q (use require or optional)", tlsClientAuth)
+	}
+	if _, err := certField(&x509.Certificate{}, tlsClientIdentity); err != nil {
+		return err
+	}
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if clientCertRevoked == nil || len(cs.VerifiedChains) == 0 {
+			return nil
+		}
+		chain := cs.VerifiedChains[0]
+		if err := clientCertRevoked(chain[0], chain); err != nil {
+			return fmt.Errorf("%w: %v", errClientCertRevoked, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// certField 按映射规则读取证书字段
+func certField(cert *x509.Certificate, field string) (string, error) {
+	switch field {
+	case "", "none":
+		return "", nil
+	case "cn":
+		return cert.Subject.CommonName, nil
+	case "ou":
+		if len(cert.Subject.OrganizationalUnit) > 0 {
+			return cert.Subject.OrganizationalUnit[0], nil
+		}
+		return "", nil
+	case "san":
+		switch {
+		case len(cert.DNSNames) > 0:
+			return cert.DNSNames[0], nil
+		case len(cert.URIs) > 0:
+			return cert.URIs[0].String(), nil
+		case len(cert.EmailAddresses) > 0:
+			return cert.EmailAddresses[0], nil
+		}
+		return "", nil
+	}
+	if !strings.HasPrefix(field, "oid:") {
+		return "", fmt.Errorf("unsupported certificate field %q (use cn, ou, san or oid:<oid>)", field)
+	}
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(strings.TrimPrefix(field, "oid:"), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return "", fmt.Errorf("invalid oid in %q", field)
+		}
+		oid = append(oid, n)
+	}
+	// 先查主题属性，再查自定义扩展（按UTF8String等字符串类型解码）
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oid) {
+			return fmt.Sprint(name.Value), nil
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			var value string
+			if _, err := asn1.Unmarshal(ext.Value, &value); err == nil {
+				return value, nil
+			}
+			return string(ext.Value), nil
+		}
+	}
+	return "", nil
+}
+
+// certPrincipal 由已校验的客户端证书生成调用方，无证书时返回nil
+func certPrincipal(state *tls.ConnectionState) *Principal {
+	if !clientCertAuthEnabled() || state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	name, _ := certField(cert, tlsClientIdentity)
+	if name == "" {
+		return nil
+	}
+	namespace, _ := certField(cert, tlsClientNamespace)
+	return &Principal{
+		Name:      name,
+		Namespace: namespace,
+		Role:      tlsClientRole,
+		Admin:     tlsClientRole == roleAdmin,
+		Subject:   cert.Subject.String(),
+		KeyID:     fmt.Sprintf("%x", cert.SerialNumber),
+		Method:    "mtls",
+	}
+}
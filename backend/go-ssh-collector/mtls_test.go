@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useClientCA 写入CA文件并设置客户端证书相关配置，结束时恢复
+func useClientCA(t *testing.T, ca *testCert, mode, identity, namespace, role string) {
+	t.Helper()
+	file, auth, ident, ns, r := tlsClientCAFile, tlsClientAuth, tlsClientIdentity, tlsClientNamespace, tlsClientRole
+	t.Cleanup(func() {
+		tlsClientCAFile, tlsClientAuth, tlsClientIdentity, tlsClientNamespace, tlsClientRole = file, auth, ident, ns, r
+	})
+	tlsClientCAFile = filepath.Join(t.TempDir(), "client-ca.pem")
+	if err := os.WriteFile(tlsClientCAFile, ca.certPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tlsClientAuth, tlsClientIdentity, tlsClientNamespace, tlsClientRole = mode, identity, namespace, role
+}
+
+func newClientCert(t *testing.T, ca *testCert, template *x509.Certificate) *testCert {
+	t.Helper()
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	return issueTestCert(t, template, ca)
+}
+
+var (
+	oidTeam        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1}
+	oidTenantExt   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 2}
+	tenantExtValue = func() []byte { v, _ := asn1.Marshal("tenant-a"); return v }()
+)
+
+// 证书字段按TLS_CLIENT_IDENTITY/TLS_CLIENT_NAMESPACE映射为调用方
+func TestCertPrincipalFieldMapping(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	spiffe, _ := url.Parse("spiffe://example.org/collector/agent")
+	cert := newClientCert(t, ca, &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "agent-01",
+			OrganizationalUnit: []string{"netops", "ignored"},
+			ExtraNames:         []pkix.AttributeTypeAndValue{{Type: oidTeam, Value: "blue"}},
+		},
+		DNSNames:        []string{"agent-01.example.org"},
+		URIs:            []*url.URL{spiffe},
+		EmailAddresses:  []string{"agent@example.org"},
+		ExtraExtensions: []pkix.Extension{{Id: oidTenantExt, Value: tenantExtValue}},
+	})
+	uriOnly := newClientCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "uri"}, URIs: []*url.URL{spiffe}})
+	emailOnly := newClientCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "mail"}, EmailAddresses: []string{"ops@example.org"}})
+	bare := newClientCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "bare"}})
+
+	cases := []struct {
+		name                string
+		cert                *testCert
+		identity, namespace string
+		role                string
+		wantName, wantNS    string
+		wantNil             bool
+	}{
+		{name: "common name", cert: cert, identity: "cn", role: roleOperator, wantName: "agent-01"},
+		{name: "organizational unit", cert: cert, identity: "ou", role: roleViewer, wantName: "netops"},
+		{name: "dns san first", cert: cert, identity: "san", role: roleOperator, wantName: "agent-01.example.org"},
+		{name: "uri san", cert: uriOnly, identity: "san", role: roleOperator, wantName: spiffe.String()},
+		{name: "email san", cert: emailOnly, identity: "san", role: roleOperator, wantName: "ops@example.org"},
+		{name: "subject oid", cert: cert, identity: "oid:1.3.6.1.4.1.55555.1", role: roleOperator, wantName: "blue"},
+		{name: "extension oid", cert: cert, identity: "oid:1.3.6.1.4.1.55555.2", role: roleOperator, wantName: "tenant-a"},
+		{name: "namespace from ou", cert: cert, identity: "cn", namespace: "ou", role: roleOperator, wantName: "agent-01", wantNS: "netops"},
+		{name: "namespace from oid", cert: cert, identity: "cn", namespace: "oid:1.3.6.1.4.1.55555.2", role: roleAdmin, wantName: "agent-01", wantNS: "tenant-a"},
+		{name: "empty identity field", cert: bare, identity: "ou", role: roleOperator, wantNil: true},
+		{name: "missing san", cert: bare, identity: "san", role: roleOperator, wantNil: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useClientCA(t, ca, "optional", tc.identity, tc.namespace, tc.role)
+			state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert.cert, ca.cert}}}
+			p := certPrincipal(state)
+			if tc.wantNil {
+				if p != nil {
+					t.Fatalf("principal = %+v, want none", p)
+				}
+				return
+			}
+			if p == nil {
+				t.Fatal("no principal")
+			}
+			if p.Name != tc.wantName || p.Namespace != tc.wantNS || p.Role != tc.role || p.Admin != (tc.role == roleAdmin) || p.Method != "mtls" {
+				t.Fatalf("principal = %+v, want name %q namespace %q role %s", p, tc.wantName, tc.wantNS, tc.role)
+			}
+			if p.Subject != tc.cert.cert.Subject.String() || p.KeyID == "" {
+				t.Fatalf("subject %q key id %q", p.Subject, p.KeyID)
+			}
+		})
+	}
+
+	// 未校验的证书链、未启用客户端证书时不产生调用方
+	useClientCA(t, ca, "optional", "cn", "", roleOperator)
+	if p := certPrincipal(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}); p != nil {
+		t.Fatalf("unverified peer certificate mapped to %+v", p)
+	}
+	if p := certPrincipal(nil); p != nil {
+		t.Fatalf("nil state mapped to %+v", p)
+	}
+	tlsClientCAFile = ""
+	if p := certPrincipal(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.cert}}}); p != nil {
+		t.Fatalf("client cert auth disabled but mapped to %+v", p)
+	}
+}
+
+func TestConfigureClientAuthRejectsInvalidSettings(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	cases := []struct{ name, mode, identity string }{
+		{"unknown mode", "sometimes", "cn"},
+		{"unknown field", "require", "serial"},
+		{"bad oid", "require", "oid:1.x.3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useClientCA(t, ca, tc.mode, tc.identity, "", roleOperator)
+			if err := configureClientAuth(&tls.Config{}); err == nil {
+				t.Fatal("invalid setting accepted")
+			}
+		})
+	}
+	t.Run("empty bundle", func(t *testing.T) {
+		useClientCA(t, ca, "require", "cn", "", roleOperator)
+		os.WriteFile(tlsClientCAFile, []byte("not a certificate"), 0600)
+		if err := configureClientAuth(&tls.Config{}); err == nil {
+			t.Fatal("bundle without certificates accepted")
+		}
+	})
+}
+
+// 握手校验客户端证书：其它CA签发的证书被拒绝，require模式下没有证书也被拒绝
+func TestClientCertHandshake(t *testing.T) {
+	ca, rogue := newTestCA(t, "clients"), newTestCA(t, "rogue")
+	trusted := newClientCert(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-01"}})
+	untrusted := newClientCert(t, rogue, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-01"}})
+	server := newServerCert(t, nil)
+
+	cases := []struct {
+		name     string
+		mode     string
+		client   *testCert
+		wantErr  bool
+		wantName string
+	}{
+		{name: "require trusted", mode: "require", client: trusted, wantName: "agent-01"},
+		{name: "require untrusted", mode: "require", client: untrusted, wantErr: true},
+		{name: "require without certificate", mode: "require", wantErr: true},
+		{name: "optional trusted", mode: "optional", client: trusted, wantName: "agent-01"},
+		{name: "optional untrusted", mode: "optional", client: untrusted, wantErr: true},
+		{name: "optional without certificate", mode: "optional"},
+		{name: "self-signed client", mode: "optional", client: newClientCert(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-01"}}), wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useClientCA(t, ca, tc.mode, "cn", "", roleOperator)
+			config := &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate(t)}}
+			if err := configureClientAuth(config); err != nil {
+				t.Fatal(err)
+			}
+			state, err := clientHandshake(t, config, tc.client)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("handshake succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			p := certPrincipal(state)
+			switch {
+			case tc.wantName == "" && p != nil:
+				t.Fatalf("principal %+v without a client certificate", p)
+			case tc.wantName != "" && (p == nil || p.Name != tc.wantName):
+				t.Fatalf("principal = %+v, want %s", p, tc.wantName)
+			}
+		})
+	}
+
+	t.Run("revoked", func(t *testing.T) {
+		useClientCA(t, ca, "require", "cn", "", roleOperator)
+		clientCertRevoked = func(cert *x509.Certificate, chain []*x509.Certificate) error {
+			return errors.New("serial on CRL")
+		}
+		t.Cleanup(func() { clientCertRevoked = nil })
+		config := &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate(t)}}
+		if err := configureClientAuth(config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := clientHandshake(t, config, trusted); err == nil {
+			t.Fatal("revoked certificate accepted")
+		}
+	})
+}
+
+// clientHandshake 用client证书（可为nil）与config握手，返回服务端看到的连接状态
+func clientHandshake(t *testing.T, config *tls.Config, client *testCert) (*tls.ConnectionState, error) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	deadline := time.Now().Add(5 * time.Second)
+	clientConn.SetDeadline(deadline)
+	serverConn.SetDeadline(deadline)
+
+	// 总是出示证书，不按服务端列出的可接受CA筛选，以便验证服务端自己的校验
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if client != nil {
+		cert := client.tlsCertificate(t)
+		clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	}
+	go func() {
+		defer clientConn.Close()
+		conn := tls.Client(clientConn, clientConfig)
+		if conn.Handshake() == nil {
+			// TLS 1.3的客户端在服务端校验证书前就完成握手，读一次以收到服务端的告警
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn := tls.Server(serverConn, config)
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
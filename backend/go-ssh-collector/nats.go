@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS输出：设置 NATS_URL（多个服务器以逗号分隔）后，结果、事件和trap发布到由模板生成的subject，
+// 模板为 NATS_SUBJECT_RESULTS、NATS_SUBJECT_EVENTS、NATS_SUBJECT_TRAPS，其他类型使用 NATS_SUBJECT_OTHER（为空时不发送）。
+// 模板可使用 {namespace} {protocol} {host} {connection_id} {kind}，启动时校验；值中的 . * > 和空白替换为 _，空值为 _。
+// 认证使用 NATS_CREDS_FILE，或 NATS_USER 与 NATS_PASSWORD(_REF)，或 NATS_TOKEN(_REF)；
+// NATS_TLS=true 启用TLS，NATS_TLS_CA、NATS_TLS_CERT/NATS_TLS_KEY 为CA和客户端证书。
+// NATS_JETSTREAM=true 时发布到JetStream并等待确认（NATS_ACK_TIMEOUT 秒），未确认的计为失败。
+// 连接断开后自动重连，期间消息留在缓冲（NATS_BUFFER）中，缓冲满时丢弃并计数。
+// 服务器地址由运维配置，不经过目标地址策略
+
+var (
+	natsURL        = getEnv("NATS_URL", "")
+	natsBuffer     = int(envInt64("NATS_BUFFER", 10000))
+	natsBatchSize  = int(envInt64("NATS_BATCH_SIZE", 100))
+	natsJetStream  = getEnv("NATS_JETSTREAM", "false") == "true"
+	natsAckTimeout = time.Duration(envInt64("NATS_ACK_TIMEOUT", 5)) * time.Second
+)
+
+var (
+	natsSubjectPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+	natsSubjectLiteral     = regexp.MustCompile(`^[A-Za-z0-9_\-]*$`)
+	natsSubjectFields      = []string{"namespace", "protocol", "host", "connection_id", "kind"}
+)
+
+// natsSubject 编译后的subject模板
+type natsSubject struct {
+	template string
+}
+
+// parseNATSSubject 每段由字面量和占位符组成，不允许通配符和空段
+func parseNATSSubject(template string) (*natsSubject, error) {
+	if template == "" {
+		return nil, nil
+	}
+	for _, token := range strings.Split(template, ".") {
+		for _, placeholder := range natsSubjectPlaceholder.FindAllString(token, -1) {
+			if !containsString(natsSubjectFields, strings.Trim(placeholder, "{}")) {
+				return nil, fmt.Errorf("invalid subject %q: unknown placeholder %s", template, placeholder)
+			}
+		}
+		literal := natsSubjectPlaceholder.ReplaceAllString(token, "")
+		if token == "" || !natsSubjectLiteral.MatchString(literal) {
+			return nil, fmt.Errorf("invalid subject %q: token %q must be non-empty and contain only letters, digits, _ and -", template, token)
+		}
+	}
+	return &natsSubject{template: template}, nil
+}
+
+// subjectToken 把值转换为单个subject段
+func subjectToken(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '.' || r == '*' || r == '>' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "_"
+	}
+	return value
+}
+
+func (s *natsSubject) render(kind string, attrs sinkAttributes) string {
+	return strings.NewReplacer(
+		"{namespace}", subjectToken(attrs.namespace),
+		"{protocol}", subjectToken(attrs.protocol),
+		"{host}", subjectToken(attrs.host),
+		"{connection_id}", subjectToken(attrs.connectionID),
+		"{kind}", subjectToken(kind),
+	).Replace(s.template)
+}
+
+// natsMessage 缓冲中的一条消息
+type natsMessage struct {
+	msg      SinkMessage
+	enqueued time.Time
+}
+
+// NATSSink 实现Sink；Publish只写入缓冲，由run按批发布
+type NATSSink struct {
+	conn     *nats.Conn
+	js       nats.JetStreamContext
+	subjects map[string]*natsSubject
+	other    *natsSubject
+	queue    chan natsMessage
+
+	delivered   int64
+	failed      int64
+	dropped     int64
+	skipped     int64
+	reconnects  int64
+	disconnects int64
+	// oldest 正在发布的批次中最早入队的时间（UnixNano）
+	oldest      int64
+	lastError   atomic.Value
+	deliveredAt atomic.Value
+}
+
+// natsSink 未启用时为nil
+var natsSink = newNATSSinkFromEnv()
+
+func newNATSSinkFromEnv() *NATSSink {
+	if natsURL == "" {
+		return nil
+	}
+	sink, err := newNATSSink(natsURL)
+	if err != nil {
+		logError("nats.disabled", "nats sink disabled", "error", err)
+		return nil
+	}
+	go sink.run()
+	servers := splitList(natsURL)
+	for i, server := range servers {
+		if u, err := url.Parse(server); err == nil {
+			servers[i] = u.Redacted()
+		}
+	}
+	logInfo("nats.start", "publishing results and events to nats", "servers", strings.Join(servers, ","))
+	return sink
+}
+
+func newNATSSink(servers string) (*NATSSink, error) {
+	sink := &NATSSink{subjects: map[string]*natsSubject{}, queue: make(chan natsMessage, natsBuffer)}
+	for _, config := range []struct{ kind, env, template string }{
+		{"result", "NATS_SUBJECT_RESULTS", "collector.results.{namespace}.{host}"},
+		{"event", "NATS_SUBJECT_EVENTS", "collector.events.{namespace}.{host}"},
+		{"trap", "NATS_SUBJECT_TRAPS", "collector.traps.{host}"},
+	} {
+		subject, err := parseNATSSubject(getEnv(config.env, config.template))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config.env, err)
+		}
+		if subject != nil {
+			sink.subjects[config.kind] = subject
+		}
+	}
+	var err error
+	if sink.other, err = parseNATSSubject(getEnv("NATS_SUBJECT_OTHER", "")); err != nil {
+		return nil, fmt.Errorf("NATS_SUBJECT_OTHER: %w", err)
+	}
+
+	options, err := natsOptions(sink)
+	if err != nil {
+		return nil, err
+	}
+	if sink.conn, err = nats.Connect(servers, options...); err != nil {
+		return nil, err
+	}
+	if natsJetStream {
+		if sink.js, err = sink.conn.JetStream(nats.PublishAsyncMaxPending(natsBatchSize)); err != nil {
+			sink.conn.Close()
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// natsOptions 启动时连不上也会在后台重试，不影响采集服务启动
+func natsOptions(sink *NATSSink) ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.Name("go-ssh-collector"),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			atomic.AddInt64(&sink.disconnects, 1)
+			if err != nil {
+				sink.lastError.Store(err.Error())
+				logWarn("nats.disconnected", "nats sink disconnected", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			atomic.AddInt64(&sink.reconnects, 1)
+			logInfo("nats.reconnected", "nats sink reconnected", "server", nc.ConnectedUrlRedacted())
+		}),
+	}
+	if creds := getEnv("NATS_CREDS_FILE", ""); creds != "" {
+		options = append(options, nats.UserCredentials(creds))
+	}
+	if user := getEnv("NATS_USER", ""); user != "" {
+		password, err := credentialValue(getEnv("NATS_PASSWORD", ""), getEnv("NATS_PASSWORD_REF", ""))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, nats.UserInfo(user, password))
+	}
+	token, err := credentialValue(getEnv("NATS_TOKEN", ""), getEnv("NATS_TOKEN_REF", ""))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		options = append(options, nats.Token(token))
+	}
+	if getEnv("NATS_TLS", "false") == "true" {
+		options = append(options, nats.Secure(&tls.Config{MinVersion: tls.VersionTLS12}))
+		if ca := getEnv("NATS_TLS_CA", ""); ca != "" {
+			options = append(options, nats.RootCAs(ca))
+		}
+		if cert := getEnv("NATS_TLS_CERT", ""); cert != "" {
+			options = append(options, nats.ClientCert(cert, getEnv("NATS_TLS_KEY", "")))
+		}
+	}
+	return options, nil
+}
+
+func (n *NATSSink) Name() string { return "nats" }
+
+func (n *NATSSink) subject(kind string) *natsSubject {
+	if subject, ok := n.subjects[kind]; ok {
+		return subject
+	}
+	return n.other
+}
+
+// Publish 不阻塞，缓冲满时丢弃并计数
+func (n *NATSSink) Publish(msg SinkMessage) error {
+	if n.subject(msg.Kind) == nil {
+		atomic.AddInt64(&n.skipped, 1)
+		return nil
+	}
+	select {
+	case n.queue <- natsMessage{msg: msg, enqueued: time.Now()}:
+	default:
+		atomic.AddInt64(&n.dropped, 1)
+	}
+	return nil
+}
+
+func (n *NATSSink) encode(m natsMessage) (*nats.Msg, error) {
+	body, err := m.msg.Body()
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(n.subject(m.msg.Kind).render(m.msg.Kind, attributesOf(m.msg.Payload)))
+	msg.Data = body
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Header.Set("Kind", m.msg.Kind)
+	if sinkPayload == sinkPayloadVersioned {
+		schema, version := sinkSchema(m.msg.Kind)
+		msg.Header.Set("Schema", schema)
+		msg.Header.Set("Schema-Version", fmt.Sprint(version))
+	}
+	return msg, nil
+}
+
+// run 取出一批发布；断开期间等待重连，消息留在缓冲中
+func (n *NATSSink) run() {
+	batch := make([]natsMessage, 0, natsBatchSize)
+	for first := range n.queue {
+		batch = append(batch[:0], first)
+	fill:
+		for len(batch) < natsBatchSize {
+			select {
+			case m := <-n.queue:
+				batch = append(batch, m)
+			default:
+				break fill
+			}
+		}
+		atomic.StoreInt64(&n.oldest, first.enqueued.UnixNano())
+		for !n.conn.IsConnected() && !n.conn.IsClosed() {
+			time.Sleep(500 * time.Millisecond)
+		}
+		n.send(batch)
+		atomic.StoreInt64(&n.oldest, 0)
+	}
+}
+
+func (n *NATSSink) send(batch []natsMessage) {
+	var futures []nats.PubAckFuture
+	var delivered int64
+	for _, m := range batch {
+		msg, err := n.encode(m)
+		if err == nil {
+			if n.js != nil {
+				var future nats.PubAckFuture
+				if future, err = n.js.PublishMsgAsync(msg); err == nil {
+					futures = append(futures, future)
+				}
+			} else if err = n.conn.PublishMsg(msg); err == nil {
+				delivered++
+			}
+		}
+		if err != nil {
+			n.fail(err)
+		}
+	}
+	// 整批共用一个确认超时，超时后未确认的全部计为失败
+	timeout := time.NewTimer(natsAckTimeout)
+	defer timeout.Stop()
+	expired := false
+	for _, future := range futures {
+		if !expired {
+			select {
+			case <-future.Ok():
+				delivered++
+				continue
+			case err := <-future.Err():
+				n.fail(err)
+				continue
+			case <-timeout.C:
+				expired = true
+			}
+		}
+		select {
+		case <-future.Ok():
+			delivered++
+		default:
+			n.fail(fmt.Errorf("jetstream ack timeout after %s", natsAckTimeout))
+		}
+	}
+	if delivered > 0 {
+		atomic.AddInt64(&n.delivered, delivered)
+		n.deliveredAt.Store(time.Now())
+	}
+}
+
+func (n *NATSSink) fail(err error) {
+	if atomic.AddInt64(&n.failed, 1) == 1 {
+		logWarn("nats.publish_failed", "nats sink publish failed", "error", err)
+	}
+	n.lastError.Store(err.Error())
+}
+
+// Stats 由 GET /admin/sinks 报告；lag_ms 为正在发布的批次中最早一条在缓冲中等待的时间
+func (n *NATSSink) Stats() gin.H {
+	subjects := gin.H{}
+	for kind, subject := range n.subjects {
+		subjects[kind] = subject.template
+	}
+	if n.other != nil {
+		subjects["other"] = n.other.template
+	}
+	stats := gin.H{
+		"server":          n.conn.ConnectedUrlRedacted(),
+		"status":          strings.ToLower(n.conn.Status().String()),
+		"healthy":         n.conn.IsConnected(),
+		"jetstream":       n.js != nil,
+		"subjects":        subjects,
+		"payload":         sinkPayload,
+		"buffered":        len(n.queue),
+		"buffer_capacity": cap(n.queue),
+		"delivered":       atomic.LoadInt64(&n.delivered),
+		"failed":          atomic.LoadInt64(&n.failed),
+		"dropped":         atomic.LoadInt64(&n.dropped),
+		"skipped":         atomic.LoadInt64(&n.skipped),
+		"disconnects":     atomic.LoadInt64(&n.disconnects),
+		"reconnects":      atomic.LoadInt64(&n.reconnects),
+		"lag_ms":          int64(0),
+	}
+	if oldest := atomic.LoadInt64(&n.oldest); oldest > 0 {
+		stats["lag_ms"] = durationMS(time.Since(time.Unix(0, oldest)))
+	}
+	if lastError, ok := n.lastError.Load().(string); ok {
+		stats["last_error"] = lastError
+	}
+	if deliveredAt, ok := n.deliveredAt.Load().(time.Time); ok {
+		stats["last_delivered_at"] = deliveredAt
+	}
+	return stats
+}
+
+func (n *NATSSink) WriteMetrics(w io.Writer) {
+	out := metricWriter{w}
+	out.family("collector_nats_messages_total", "counter", "Messages handled by the nats sink by result.")
+	out.sample("collector_nats_messages_total", float64(atomic.LoadInt64(&n.delivered)), "result", "delivered")
+	out.sample("collector_nats_messages_total", float64(atomic.LoadInt64(&n.failed)), "result", "failed")
+	out.sample("collector_nats_messages_total", float64(atomic.LoadInt64(&n.dropped)), "result", "dropped")
+	out.sample("collector_nats_messages_total", float64(atomic.LoadInt64(&n.skipped)), "result", "skipped")
+	out.family("collector_nats_buffer", "gauge", "Messages waiting in the nats sink buffer.")
+	out.sample("collector_nats_buffer", float64(len(n.queue)))
+	connected := 0
+	if n.conn.IsConnected() {
+		connected = 1
+	}
+	out.family("collector_nats_connected", "gauge", "Whether the nats sink is connected.")
+	out.sample("collector_nats_connected", float64(connected))
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	netconfBase10       = "urn:ietf:params:netconf:base:1.0"
+	netconfBase11       = "urn:ietf:params:netconf:base:1.1"
+	netconfNamespace    = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	netconfEOM          = "]]>]]>"
+	netconfMaxReplySize = 64 << 20
+)
+
+var errNetconfReplyTooLarge = errors.New("netconf reply exceeds size limit")
+
+// NetconfSession 基于SSH netconf子系统的会话，RPC串行执行
+type NetconfSession struct {
+	SessionID    string
+	Capabilities []string
+	CreatedAt    time.Time
+
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	chunked bool
+	msgID   int
+	mutex   sync.Mutex
+}
+
+type NetconfError struct {
+	Type     string `json:"type"`
+	Tag      string `json:"tag"`
+	Severity string `json:"severity"`
+	AppTag   string `json:"app_tag,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Info     string `json:"info,omitempty"`
+}
+
+type NetconfResult struct {
+	ConnectionID string         `json:"connection_id"`
+	MessageID    string         `json:"message_id"`
+	Reply        string         `json:"reply"`
+	Data         interface{}    `json:"data,omitempty"`
+	OK           bool           `json:"ok"`
+	Errors       []NetconfError `json:"errors,omitempty"`
+	Duration     float64        `json:"duration"`
+	Timestamp    time.Time      `json:"timestamp"`
+}
+
+func openNetconf(client *ssh.Client) (*NetconfSession, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", withErrorClass(classSessionOpenFailed, err))
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("netconf"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start netconf subsystem: %v", err)
+	}
+	// 会话关闭或连接断开时Wait返回
+	release := trackSession(sessionNetconf)
+	go func() {
+		session.Wait()
+		release()
+	}()
+
+	nc := &NetconfSession{
+		CreatedAt: time.Now(),
+		session:   session,
+		stdin:     stdin,
+		stdout:    bufio.NewReader(stdout),
+	}
+	if err := nc.hello(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// hello 交换能力集，双方都支持base:1.1时切换为分块帧
+func (nc *NetconfSession) hello() error {
+	hello := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<hello xmlns="` + netconfNamespace + `"><capabilities>` +
+		`<capability>` + netconfBase10 + `</capability>` +
+		`<capability>` + netconfBase11 + `</capability>` +
+		`</capabilities></hello>` + netconfEOM
+	if _, err := io.WriteString(nc.stdin, hello); err != nil {
+		return fmt.Errorf("failed to send hello: %v", err)
+	}
+
+	reply, err := nc.readEOM()
+	if err != nil {
+		return fmt.Errorf("failed to read hello: %v", err)
+	}
+
+	var serverHello struct {
+		Capabilities []string `xml:"capabilities>capability"`
+		SessionID    string   `xml:"session-id"`
+	}
+	if err := xml.Unmarshal(reply, &serverHello); err != nil {
+		return fmt.Errorf("invalid hello: %v", err)
+	}
+
+	nc.SessionID = serverHello.SessionID
+	for _, capability := range serverHello.Capabilities {
+		capability = strings.TrimSpace(capability)
+		nc.Capabilities = append(nc.Capabilities, capability)
+		if capability == netconfBase11 {
+			nc.chunked = true
+		}
+	}
+	return nil
+}
+
+// readEOM 读取以 ]]>]]> 结尾的1.0帧
+func (nc *NetconfSession) readEOM() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := nc.stdout.ReadBytes('>')
+		buf.Write(line)
+		if buf.Len() > netconfMaxReplySize {
+			return nil, errNetconfReplyTooLarge
+		}
+		if bytes.HasSuffix(buf.Bytes(), []byte(netconfEOM)) {
+			return bytes.TrimSuffix(buf.Bytes(), []byte(netconfEOM)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readChunked 读取1.1分块帧: \n#<len>\n<data>...\n##\n
+func (nc *NetconfSession) readChunked() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		header, err := nc.stdout.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if header == "##" {
+			return buf.Bytes(), nil
+		}
+		if !strings.HasPrefix(header, "#") {
+			return nil, fmt.Errorf("invalid chunk header: %q", header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid chunk size: %q", header)
+		}
+		if buf.Len()+size > netconfMaxReplySize {
+			return nil, errNetconfReplyTooLarge
+		}
+		if _, err := io.CopyN(&buf, nc.stdout, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (nc *NetconfSession) send(message string) error {
+	if nc.chunked {
+		message = fmt.Sprintf("\n#%d\n%s\n##\n", len(message), message)
+	} else {
+		message += netconfEOM
+	}
+	_, err := io.WriteString(nc.stdin, message)
+	return err
+}
+
+// RPC 发送一个RPC并返回原始回复与消息ID
+func (nc *NetconfSession) RPC(body string) (string, []byte, error) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.msgID++
+	msgID := strconv.Itoa(nc.msgID)
+	rpc := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<rpc message-id="` + msgID + `" xmlns="` + netconfNamespace + `">` + body + `</rpc>`
+	if err := nc.send(rpc); err != nil {
+		return msgID, nil, fmt.Errorf("failed to send rpc: %v", err)
+	}
+
+	var reply []byte
+	var err error
+	if nc.chunked {
+		reply, err = nc.readChunked()
+	} else {
+		reply, err = nc.readEOM()
+	}
+	if err != nil {
+		return msgID, nil, fmt.Errorf("failed to read rpc-reply: %v", err)
+	}
+	return msgID, reply, nil
+}
+
+func (nc *NetconfSession) Close() error {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.send(`<rpc message-id="close" xmlns="` + netconfNamespace + `"><close-session/></rpc>`)
+	return nc.session.Close()
+}
+
+// parseRPCReply 解析rpc-reply中的ok与rpc-error
+func parseRPCReply(reply []byte) (bool, []NetconfError, error) {
+	var parsed struct {
+		OK     *struct{} `xml:"ok"`
+		Errors []struct {
+			Type     string `xml:"error-type"`
+			Tag      string `xml:"error-tag"`
+			Severity string `xml:"error-severity"`
+			AppTag   string `xml:"error-app-tag"`
+			Path     string `xml:"error-path"`
+			Message  string `xml:"error-message"`
+			Info     struct {
+				Inner string `xml:",innerxml"`
+			} `xml:"error-info"`
+		} `xml:"rpc-error"`
+	}
+	if err := xml.Unmarshal(reply, &parsed); err != nil {
+		return false, nil, fmt.Errorf("invalid rpc-reply: %v", err)
+	}
+
+	var errs []NetconfError
+	for _, e := range parsed.Errors {
+		errs = append(errs, NetconfError{
+			Type:     strings.TrimSpace(e.Type),
+			Tag:      strings.TrimSpace(e.Tag),
+			Severity: strings.TrimSpace(e.Severity),
+			AppTag:   strings.TrimSpace(e.AppTag),
+			Path:     strings.TrimSpace(e.Path),
+			Message:  strings.TrimSpace(e.Message),
+			Info:     strings.TrimSpace(e.Info.Inner),
+		})
+	}
+	return parsed.OK != nil, errs, nil
+}
+
+// xmlToJSON 将XML转换为通用结构：属性以@前缀保存，文本保存为#text，重复元素合并为数组
+func xmlToJSON(data []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	type frame struct {
+		name     string
+		value    map[string]interface{}
+		text     strings.Builder
+		hasChild bool
+	}
+	root := &frame{value: map[string]interface{}{}}
+	stack := []*frame{root}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			f := &frame{name: t.Name.Local, value: map[string]interface{}{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				f.value["@"+attr.Name.Local] = attr.Value
+			}
+			stack[len(stack)-1].hasChild = true
+			stack = append(stack, f)
+		case xml.CharData:
+			stack[len(stack)-1].text.Write(t)
+		case xml.EndElement:
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+
+			var value interface{} = f.value
+			text := strings.TrimSpace(f.text.String())
+			if len(f.value) == 0 {
+				// 叶子节点直接使用文本值
+				value = text
+			} else if text != "" {
+				f.value["#text"] = text
+			}
+
+			if existing, ok := parent.value[f.name]; ok {
+				if list, ok := existing.([]interface{}); ok {
+					parent.value[f.name] = append(list, value)
+				} else {
+					parent.value[f.name] = []interface{}{existing, value}
+				}
+			} else {
+				parent.value[f.name] = value
+			}
+		}
+	}
+	return root.value, nil
+}
+
+// Netconf 返回连接上复用的NETCONF会话
+func (conn *SSHConnection) Netconf() (*NetconfSession, error) {
+	conn.netconfMutex.Lock()
+	defer conn.netconfMutex.Unlock()
+
+	if conn.netconf != nil {
+		return conn.netconf, nil
+	}
+	nc, err := openNetconf(conn.Client)
+	if err != nil {
+		return nil, err
+	}
+	conn.netconf = nc
+	return nc, nil
+}
+
+func (conn *SSHConnection) closeNetconf() {
+	conn.netconfMutex.Lock()
+	defer conn.netconfMutex.Unlock()
+
+	if conn.netconf != nil {
+		conn.netconf.Close()
+		conn.netconf = nil
+	}
+}
+
+func (cm *ConnectionManager) NetconfRPC(connectionID, body string, toJSON bool) (*NetconfResult, error) {
+	conn, err := cm.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := conn.Netconf()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	msgID, reply, err := nc.RPC(body)
+	if err != nil {
+		// 会话已损坏，下次请求时重新建立
+		conn.closeNetconf()
+		return nil, err
+	}
+
+	ok, errs, err := parseRPCReply(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &NetconfResult{
+		ConnectionID: connectionID,
+		MessageID:    msgID,
+		Reply:        string(reply),
+		OK:           ok,
+		Errors:       errs,
+		Duration:     time.Since(start).Seconds(),
+		Timestamp:    time.Now(),
+	}
+	if toJSON {
+		data, err := xmlToJSON(reply)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert reply: %v", err)
+		}
+		result.Data = data
+	}
+	return result, nil
+}
+
+type NetconfRequest struct {
+	ConnectionID string `json:"connection_id" binding:"required"`
+	// Format 为"json"时额外返回XML转换后的结构
+	Format string `json:"format"`
+
+	RPC              string `json:"rpc"`
+	Filter           string `json:"filter"`
+	Source           string `json:"source"`
+	Target           string `json:"target"`
+	Config           string `json:"config"`
+	DefaultOperation string `json:"default_operation"`
+}
+
+var netconfDatastores = map[string]bool{"running": true, "candidate": true, "startup": true}
+
+func datastoreElement(name, def string) (string, error) {
+	if name == "" {
+		name = def
+	}
+	if !netconfDatastores[name] {
+		return "", fmt.Errorf("invalid datastore: %s", name)
+	}
+	return "<" + name + "/>", nil
+}
+
+func subtreeFilter(filter string) string {
+	if filter == "" {
+		return ""
+	}
+	return `<filter type="subtree">` + filter + `</filter>`
+}
+
+// buildNetconfRPC 根据操作类型构造RPC内容
+func buildNetconfRPC(op string, req NetconfRequest) (string, error) {
+	switch op {
+	case "rpc":
+		if req.RPC == "" {
+			return "", fmt.Errorf("rpc is required")
+		}
+		return req.RPC, nil
+	case "get":
+		return "<get>" + subtreeFilter(req.Filter) + "</get>", nil
+	case "get-config":
+		source, err := datastoreElement(req.Source, "running")
+		if err != nil {
+			return "", err
+		}
+		return "<get-config><source>" + source + "</source>" + subtreeFilter(req.Filter) + "</get-config>", nil
+	case "edit-config":
+		if req.Config == "" {
+			return "", fmt.Errorf("config is required")
+		}
+		target, err := datastoreElement(req.Target, "running")
+		if err != nil {
+			return "", err
+		}
+		body := "<edit-config><target>" + target + "</target>"
+		if req.DefaultOperation != "" {
+			body += "<default-operation>" + req.DefaultOperation + "</default-operation>"
+		}
+		return body + "<config>" + req.Config + "</config></edit-config>", nil
+	}
+	return "", fmt.Errorf("unsupported operation: %s", op)
+}
+
+func registerNetconfRoutes(r *gin.Engine) {
+	for _, op := range []string{"rpc", "get", "get-config", "edit-config"} {
+		op := op
+		r.POST("/netconf/"+op, func(c *gin.Context) {
+			var req NetconfRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+			body, err := buildNetconfRPC(op, req)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+
+			result, err := collector.NetconfRPC(req.ConnectionID, body, req.Format == "json")
+			if err != nil {
+				respondError(c, fileErrorStatus(err), err)
+				return
+			}
+
+			c.JSON(http.StatusOK, result)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 网络设备show命令的内置解析器：基于内置TextFSM模板解析，再规范化为跨厂商一致的结构，
+// 仪表盘无需区分厂商
+
+// NetVersion version/v1
+type NetVersion struct {
+	Vendor        string `json:"vendor"`
+	OS            string `json:"os"`
+	Version       string `json:"version"`
+	Model         string `json:"model,omitempty"`
+	Hostname      string `json:"hostname,omitempty"`
+	Serial        string `json:"serial,omitempty"`
+	Uptime        string `json:"uptime,omitempty"`
+	UptimeSeconds *int64 `json:"uptime_seconds,omitempty"`
+}
+
+// NetInterface interfaces/v1，状态统一为up/down，速率单位为bit/s，MAC为冒号分隔小写
+type NetInterface struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	AdminStatus  string   `json:"admin_status"`
+	OperStatus   string   `json:"oper_status"`
+	MAC          string   `json:"mac,omitempty"`
+	MTU          *int     `json:"mtu,omitempty"`
+	SpeedBps     *int64   `json:"speed_bps,omitempty"`
+	Duplex       string   `json:"duplex,omitempty"`
+	IPAddresses  []string `json:"ip_addresses,omitempty"`
+	InputErrors  *int64   `json:"input_errors,omitempty"`
+	OutputErrors *int64   `json:"output_errors,omitempty"`
+}
+
+// NetInventoryItem inventory/v1
+type NetInventoryItem struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	PartNumber  string `json:"part_number,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+}
+
+func init() {
+	type netParser struct {
+		platform, name, command, spec, template, schema string
+		vendor, os                                      string
+	}
+	for _, p := range []netParser{
+		{"cisco_ios", "show_version", "show version", "sh:ow ver:sion", "cisco_ios_show_version", "version", "cisco", "ios"},
+		{"cisco_ios", "show_interfaces", "show interfaces", "sh:ow int:erfaces", "cisco_ios_show_interfaces", "interfaces", "", ""},
+		{"cisco_ios", "show_ip_interface_brief", "show ip interface brief", "sh:ow ip int:erface br:ief", "cisco_ios_show_ip_interface_brief", "interfaces", "", ""},
+		{"cisco_ios", "show_inventory", "show inventory", "sh:ow inv:entory", "cisco_ios_show_inventory", "inventory", "", ""},
+		{"juniper_junos", "show_version", "show version", "sh:ow ver:sion", "juniper_junos_show_version", "version", "juniper", "junos"},
+		{"juniper_junos", "show_interfaces", "show interfaces", "sh:ow int:erfaces", "juniper_junos_show_interfaces", "interfaces", "", ""},
+		{"juniper_junos", "show_interfaces_terse", "show interfaces terse", "sh:ow int:erfaces te:rse", "juniper_junos_show_interfaces_terse", "interfaces", "", ""},
+		{"huawei_vrp", "display_version", "display version", "dis:play ver:sion", "huawei_vrp_display_version", "version", "huawei", "vrp"},
+		{"huawei_vrp", "display_interface", "display interface", "dis:play int:erface", "huawei_vrp_display_interface", "interfaces", "", ""},
+		{"huawei_vrp", "display_interface_brief", "display interface brief", "dis:play int:erface br:ief", "huawei_vrp_display_interface_brief", "interfaces", "", ""},
+		{"huawei_vrp", "display_ip_interface_brief", "display ip interface brief", "dis:play ip int:erface br:ief", "huawei_vrp_display_ip_interface_brief", "interfaces", "", ""},
+	} {
+		p := p
+		parse := func(text string) (interface{}, error) {
+			template, err := templates.Get(p.template)
+			if err != nil {
+				return nil, err
+			}
+			records, err := template.fsm.Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			switch p.schema {
+			case "version":
+				return normalizeVersion(records, p.vendor, p.os)
+			case "interfaces":
+				return normalizeInterfaces(records), nil
+			}
+			return normalizeInventory(records), nil
+		}
+		registerParser(&BuiltinParser{
+			Name:           p.platform + "." + p.name,
+			Description:    fmt.Sprintf("%s %s", p.platform, p.command),
+			Command:        p.command,
+			Platform:       p.platform,
+			Schema:         p.schema,
+			SchemaVersion:  1,
+			CommandPattern: commandPattern(p.spec),
+			Parse:          parse,
+		})
+	}
+
+	registerParser(&BuiltinParser{
+		Name:           "juniper_junos.show_chassis_hardware",
+		Description:    "juniper_junos show chassis hardware",
+		Command:        "show chassis hardware",
+		Platform:       "juniper_junos",
+		Schema:         "inventory",
+		SchemaVersion:  1,
+		CommandPattern: commandPattern("sh:ow cha:ssis har:dware"),
+		Parse:          parseJunosChassisHardware,
+	})
+}
+
+// recordValue 按别名顺序取模板记录中的第一个非空值，List值取首个元素
+func recordValue(record map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		switch value := record[name].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case []string:
+			if len(value) > 0 {
+				return value[0]
+			}
+		}
+	}
+	return ""
+}
+
+func recordList(record map[string]interface{}, names ...string) []string {
+	for _, name := range names {
+		switch value := record[name].(type) {
+		case string:
+			if value != "" && value != "unassigned" {
+				return []string{value}
+			}
+		case []string:
+			if len(value) > 0 {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+var statusSuffix = regexp.MustCompile(`\s*\(.*\)$`)
+
+// cleanStatus 统一状态写法: UP / up(s) / *down / ^down
+func cleanStatus(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = statusSuffix.ReplaceAllString(s, "")
+	return strings.TrimLeft(s, "*^")
+}
+
+// adminStatus 由显式管理状态，或Cisco/华为合并在物理状态中的 administratively down / *down 推导
+func adminStatus(record map[string]interface{}) string {
+	if admin := recordValue(record, "ADMIN_STATUS"); admin != "" {
+		switch strings.ToLower(admin) {
+		case "enabled", "up":
+			return "up"
+		}
+		return "down"
+	}
+	link := strings.ToLower(recordValue(record, "LINK_STATUS", "STATUS", "PHY"))
+	if link == "" {
+		return ""
+	}
+	if strings.Contains(link, "administratively") || strings.HasPrefix(link, "*") {
+		return "down"
+	}
+	return "up"
+}
+
+func operStatus(record map[string]interface{}) string {
+	oper := recordValue(record, "OPER_STATUS", "PROTO", "PROTOCOL")
+	if oper == "" {
+		oper = recordValue(record, "LINK_STATUS", "STATUS", "PHY")
+	}
+	status := cleanStatus(oper)
+	if strings.Contains(status, "down") {
+		return "down"
+	}
+	return status
+}
+
+var speedPattern = regexp.MustCompile(`^([\d.]+)\s*([kmgt])?`)
+
+// parseSpeed 解析 1000Mb/s / 10Gbps / 1000000 Kbit / 1G，无单位按Mbit/s
+func parseSpeed(s string) *int64 {
+	m := speedPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return nil
+	}
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return nil
+	}
+	mult := map[string]float64{"k": 1e3, "": 1e6, "m": 1e6, "g": 1e9, "t": 1e12}[m[2]]
+	bps := int64(value * mult)
+	return &bps
+}
+
+var macHex = regexp.MustCompile(`[^0-9a-f]`)
+
+// normalizeMAC 0011.2233.4455 / 0011-2233-4455 / 00:11:22:33:44:55 统一为冒号格式
+func normalizeMAC(s string) string {
+	hex := macHex.ReplaceAllString(strings.ToLower(s), "")
+	if len(hex) != 12 {
+		return strings.ToLower(s)
+	}
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = hex[2*i : 2*i+2]
+	}
+	return strings.Join(parts, ":")
+}
+
+func optionalInt64(s string) *int64 {
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+func normalizeInterfaces(records []map[string]interface{}) []NetInterface {
+	interfaces := make([]NetInterface, 0, len(records))
+	for _, record := range records {
+		iface := NetInterface{
+			Name:         recordValue(record, "INTERFACE", "INTF"),
+			Description:  recordValue(record, "DESCRIPTION"),
+			AdminStatus:  adminStatus(record),
+			OperStatus:   operStatus(record),
+			MAC:          normalizeMAC(recordValue(record, "MAC_ADDRESS")),
+			IPAddresses:  recordList(record, "IP_ADDRESS", "IPADDR"),
+			InputErrors:  optionalInt64(recordValue(record, "INPUT_ERRORS", "IN_ERRORS")),
+			OutputErrors: optionalInt64(recordValue(record, "OUTPUT_ERRORS", "OUT_ERRORS")),
+		}
+		if mtu, err := strconv.Atoi(recordValue(record, "MTU")); err == nil {
+			iface.MTU = &mtu
+		}
+		// 协商速率优先，其次为配置带宽
+		iface.SpeedBps = parseSpeed(recordValue(record, "SPEED"))
+		if iface.SpeedBps == nil {
+			iface.SpeedBps = parseSpeed(recordValue(record, "BANDWIDTH"))
+		}
+		if duplex := strings.ToLower(recordValue(record, "DUPLEX")); duplex != "" {
+			iface.Duplex = strings.TrimSuffix(duplex, "-duplex")
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+var uptimeUnit = regexp.MustCompile(`(\d+)\s+(year|week|day|hour|minute|second)s?`)
+
+// parseDeviceUptime 解析 1 week, 2 days, 3 hours, 4 minutes
+func parseDeviceUptime(s string) *int64 {
+	units := map[string]int64{"year": 365 * 86400, "week": 7 * 86400, "day": 86400, "hour": 3600, "minute": 60, "second": 1}
+	matches := uptimeUnit.FindAllStringSubmatch(s, -1)
+	if matches == nil {
+		return nil
+	}
+	var seconds int64
+	for _, m := range matches {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		seconds += n * units[m[2]]
+	}
+	return &seconds
+}
+
+func normalizeVersion(records []map[string]interface{}, vendor, os string) (*NetVersion, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no version information found")
+	}
+	record := records[0]
+	version := &NetVersion{
+		Vendor:   vendor,
+		OS:       os,
+		Version:  recordValue(record, "VERSION"),
+		Model:    recordValue(record, "MODEL", "HARDWARE"),
+		Hostname: recordValue(record, "HOSTNAME"),
+		Serial:   recordValue(record, "SERIAL"),
+		Uptime:   recordValue(record, "UPTIME"),
+	}
+	if version.Uptime != "" {
+		version.UptimeSeconds = parseDeviceUptime(version.Uptime)
+	}
+	return version, nil
+}
+
+func normalizeInventory(records []map[string]interface{}) []NetInventoryItem {
+	items := make([]NetInventoryItem, 0, len(records))
+	for _, record := range records {
+		items = append(items, NetInventoryItem{
+			Name:        recordValue(record, "NAME"),
+			Description: recordValue(record, "DESCR"),
+			PartNumber:  recordValue(record, "PID"),
+			Version:     recordValue(record, "VID"),
+			Serial:      recordValue(record, "SN"),
+		})
+	}
+	return items
+}
+
+// parseJunosChassisHardware show chassis hardware 为定宽表格，子部件以缩进表示
+func parseJunosChassisHardware(text string) (interface{}, error) {
+	rows, err := ParseTable(text, 0, `^Item\s+Version`)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]NetInventoryItem, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, NetInventoryItem{
+			Name:        row["item"],
+			Description: row["description"],
+			PartNumber:  row["part_number"],
+			Version:     row["version"],
+			Serial:      row["serial_number"],
+		})
+	}
+	return items, nil
+}
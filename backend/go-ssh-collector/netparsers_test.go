@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 网络设备解析器的输入取自testdata/textfsm下对应模板的设备输出（非模板解析器在testdata/netparsers下），
+// 规范化结果为testdata/netparsers/<解析器>.json，-update 重新生成
+func TestNetParserFixtures(t *testing.T) {
+	cases := []struct {
+		parser, deviceType, command, input string
+	}{
+		{"cisco_ios.show_version", "cisco_ios", "show version", "textfsm/cisco_ios_show_version"},
+		{"cisco_ios.show_interfaces", "cisco_ios", "show interfaces", "textfsm/cisco_ios_show_interfaces"},
+		{"cisco_ios.show_ip_interface_brief", "cisco_ios", "sh ip int br", "textfsm/cisco_ios_show_ip_interface_brief"},
+		{"cisco_ios.show_inventory", "cisco_ios", "show inventory", "textfsm/cisco_ios_show_inventory"},
+		{"juniper_junos.show_version", "juniper_junos", "show version | no-more", "textfsm/juniper_junos_show_version"},
+		{"juniper_junos.show_interfaces", "juniper_junos", "show interfaces", "textfsm/juniper_junos_show_interfaces"},
+		{"juniper_junos.show_interfaces_terse", "juniper_junos", "show interfaces terse", "textfsm/juniper_junos_show_interfaces_terse"},
+		{"juniper_junos.show_chassis_hardware", "juniper_junos", "show chassis hardware", "netparsers/juniper_junos.show_chassis_hardware"},
+		{"huawei_vrp.display_version", "huawei_vrp", "display version", "textfsm/huawei_vrp_display_version"},
+		{"huawei_vrp.display_interface", "huawei_vrp", "dis int", "textfsm/huawei_vrp_display_interface"},
+		{"huawei_vrp.display_interface_brief", "huawei_vrp", "display interface brief", "textfsm/huawei_vrp_display_interface_brief"},
+		{"huawei_vrp.display_ip_interface_brief", "huawei_vrp", "display ip interface brief", "textfsm/huawei_vrp_display_ip_interface_brief"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.parser, func(t *testing.T) {
+			if p := parsers.Match(tc.deviceType, tc.command); p == nil || p.Name != tc.parser {
+				t.Fatalf("%s %q selected %v", tc.deviceType, tc.command, p)
+			}
+			raw, err := os.ReadFile(filepath.Join("testdata", tc.input+".raw"))
+			if err != nil {
+				t.Fatalf("missing fixture: %v", err)
+			}
+			result := &CommandResult{Command: tc.command, Output: string(raw)}
+			applyParse(result, &ParseOptions{Auto: true}, tc.deviceType)
+			if result.ParseError != "" || result.ParseSkipped != "" {
+				t.Fatalf("parse: %s%s", result.ParseError, result.ParseSkipped)
+			}
+			got, err := json.MarshalIndent(result.Parsed, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := filepath.Join("testdata", "netparsers", tc.parser+".json")
+			if *updateFixtures {
+				if err := os.WriteFile(expectedFile, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("missing expected output: %v", err)
+			}
+			assertSameJSON(t, got, expected)
+		})
+	}
+}
+
+// 不同厂商的接口输出规范化为同一结构：状态为up/down，速率为bit/s整数
+func TestNetParserInterfacesAreNormalized(t *testing.T) {
+	for _, tc := range []struct{ parser, input string }{
+		{"cisco_ios.show_interfaces", "cisco_ios_show_interfaces"},
+		{"juniper_junos.show_interfaces", "juniper_junos_show_interfaces"},
+		{"huawei_vrp.display_interface", "huawei_vrp_display_interface"},
+		{"cisco_ios.show_ip_interface_brief", "cisco_ios_show_ip_interface_brief"},
+		{"juniper_junos.show_interfaces_terse", "juniper_junos_show_interfaces_terse"},
+	} {
+		p, err := parsers.Lookup(tc.parser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.schema() != "interfaces/v1" {
+			t.Errorf("%s schema = %s", tc.parser, p.schema())
+		}
+		raw, err := os.ReadFile(filepath.Join("testdata", "textfsm", tc.input+".raw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := p.Parse(string(raw))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.parser, err)
+		}
+		interfaces := value.([]NetInterface)
+		if len(interfaces) == 0 {
+			t.Fatalf("%s: no interfaces", tc.parser)
+		}
+		for _, iface := range interfaces {
+			if iface.Name == "" || (iface.AdminStatus != "up" && iface.AdminStatus != "down" && iface.AdminStatus != "") {
+				t.Errorf("%s: %+v", tc.parser, iface)
+			}
+			if iface.SpeedBps != nil && *iface.SpeedBps < 1000 {
+				t.Errorf("%s %s: speed %d is not in bit/s", tc.parser, iface.Name, *iface.SpeedBps)
+			}
+			if iface.MAC != "" && (len(iface.MAC) != 17 || strings.Count(iface.MAC, ":") != 5) {
+				t.Errorf("%s %s: mac %q", tc.parser, iface.Name, iface.MAC)
+			}
+		}
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	for input, want := range map[string]int64{
+		"1000Mb/s":     1e9,
+		"10Gbps":       10e9,
+		"1000000 Kbit": 1e9,
+		"1G":           1e9,
+		"100":          100e6,
+		"2.5 Gbps":     2.5e9,
+		"1.2Tbps":      1.2e12,
+	} {
+		if got := parseSpeed(input); got == nil || *got != want {
+			t.Errorf("parseSpeed(%q) = %v, want %d", input, got, want)
+		}
+	}
+	for _, input := range []string{"", "auto", "Unlimited"} {
+		if got := parseSpeed(input); got != nil {
+			t.Errorf("parseSpeed(%q) = %d, want nil", input, *got)
+		}
+	}
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	for input, want := range map[string]string{
+		"0011.2233.44AA":    "00:11:22:33:44:aa",
+		"0011-2233-4455":    "00:11:22:33:44:55",
+		"00:11:22:33:44:55": "00:11:22:33:44:55",
+		"Unknown":           "unknown",
+		"":                  "",
+	} {
+		if got := normalizeMAC(input); got != want {
+			t.Errorf("normalizeMAC(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestInterfaceStatus(t *testing.T) {
+	cases := []struct {
+		record      map[string]interface{}
+		admin, oper string
+	}{
+		{map[string]interface{}{"LINK_STATUS": "administratively down", "PROTOCOL_STATUS": "down"}, "down", "down"},
+		{map[string]interface{}{"LINK_STATUS": "up", "PROTOCOL": "up"}, "up", "up"},
+		{map[string]interface{}{"STATUS": "up", "PROTO": "down"}, "up", "down"},
+		{map[string]interface{}{"PHY": "*down", "PROTOCOL": "down"}, "down", "down"},
+		{map[string]interface{}{"PHY": "up", "PROTOCOL": "up(s)"}, "up", "up"},
+		{map[string]interface{}{"ADMIN_STATUS": "Enabled", "OPER_STATUS": "Down"}, "up", "down"},
+		{map[string]interface{}{"ADMIN_STATUS": "Disabled", "LINK_STATUS": "Down"}, "down", "down"},
+		{map[string]interface{}{}, "", ""},
+	}
+	for _, tc := range cases {
+		if admin, oper := adminStatus(tc.record), operStatus(tc.record); admin != tc.admin || oper != tc.oper {
+			t.Errorf("%v: admin %q oper %q, want %q %q", tc.record, admin, oper, tc.admin, tc.oper)
+		}
+	}
+}
+
+func TestParseDeviceUptime(t *testing.T) {
+	for input, want := range map[string]int64{
+		"1 week, 2 days, 3 hours, 4 minutes": 7*86400 + 2*86400 + 3*3600 + 4*60,
+		"2 years, 10 weeks, 1 day":           2*365*86400 + 10*7*86400 + 86400,
+		"0 day, 5 hours, 1 minute":           5*3600 + 60,
+	} {
+		if got := parseDeviceUptime(input); got == nil || *got != want {
+			t.Errorf("parseDeviceUptime(%q) = %v, want %d", input, got, want)
+		}
+	}
+	if got := parseDeviceUptime("unknown"); got != nil {
+		t.Errorf("parseDeviceUptime(unknown) = %d", *got)
+	}
+}
+
+// 没有匹配的解析器时跳过解析并说明原因
+func TestNetParserAutoSkipsUnknownCommands(t *testing.T) {
+	for _, tc := range []struct{ deviceType, command string }{
+		{"cisco_ios", "show running-config"},
+		{"juniper_junos", "show versions"},
+		{"huawei_vrp", "show version"},
+		{"", "show version"},
+	} {
+		result := &CommandResult{Command: tc.command, Output: "text"}
+		applyParse(result, &ParseOptions{Auto: true}, tc.deviceType)
+		if result.Parsed != nil || !strings.Contains(result.ParseSkipped, "no builtin parser") {
+			t.Errorf("%s %q: parsed %v, skipped %q", tc.deviceType, tc.command, result.Parsed, result.ParseSkipped)
+		}
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 通知：从事件总线接收告警触发/恢复、任务失败等事件，按渠道的路由规则（事件类型、命名空间、严重级别）
+// 发送到通用webhook、Slack兼容webhook或SMTP邮件。每个渠道有独立的有界队列和发送goroutine，
+// 失败时按 1s、2s、4s…重试 NOTIFY_MAX_RETRIES 次；令牌桶限流，超过速率的通知直接丢弃并计数，避免告警风暴。
+// 渠道中的webhook地址和SMTP密码可用 url_ref / password_ref 引用凭据（env:、file:、cred:），发送时解析。
+// 启动时从 NOTIFY_CHANNELS_FILE（JSON数组）加载，运行时通过 PUT /notifications/channels/:name 修改
+
+const (
+	channelWebhook = "webhook"
+	channelSlack   = "slack"
+	channelEmail   = "email"
+)
+
+// 派生的通知类型：任务进入failed状态、命令执行失败，避免订阅所有状态变化
+const (
+	notifyJobFailed     = "job_failed"
+	notifyCommandFailed = "command_failed"
+)
+
+var (
+	errChannelNotFound   = errors.New("notification channel not found")
+	errInvalidChannel    = errors.New("invalid notification channel")
+	notifyChannelsFile   = getEnv("NOTIFY_CHANNELS_FILE", "")
+	notifyQueueSize      = int(envInt64("NOTIFY_QUEUE_SIZE", 100))
+	notifyMaxRetries     = int(envInt64("NOTIFY_MAX_RETRIES", 3))
+	notifyDefaultLimit   = parseRateLimit(getEnv("NOTIFY_RATE_LIMIT", "0.2:10"))
+	notifyDefaultTypes   = []string{eventAlertFiring, eventAlertResolved, notifyJobFailed}
+	notifySeverityLevels = map[string]int{"info": 0, "warning": 1, "error": 2, "critical": 3}
+)
+
+const defaultNotifyTemplate = `[{{.Severity}}] {{.Type}}{{if .Namespace}} namespace={{.Namespace}}{{end}}{{if .Host}} host={{.Host}}{{end}}: {{.Summary}}`
+
+const defaultNotifySubject = `[collector] {{.Severity}} {{.Type}}{{if .Host}} {{.Host}}{{end}}`
+
+// NotificationRoute 各字段为空表示不限制，MinSeverity为最低严重级别
+type NotificationRoute struct {
+	Types       []string `json:"types,omitempty"`
+	Namespaces  []string `json:"namespaces,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+}
+
+func (route NotificationRoute) match(n *Notification) bool {
+	switch {
+	case len(route.Types) > 0 && !containsString(route.Types, n.Type):
+		return false
+	case len(route.Namespaces) > 0 && !containsString(route.Namespaces, n.Namespace):
+		return false
+	}
+	return notifySeverityLevels[n.Severity] >= notifySeverityLevels[route.MinSeverity]
+}
+
+type NotificationChannel struct {
+	Name string `json:"name"`
+	Type string `json:"type" binding:"required"`
+	// URL webhook和slack的地址，包含令牌时应使用URLRef
+	URL    string `json:"url,omitempty"`
+	URLRef string `json:"url_ref,omitempty"`
+	// Template text/template模板，字段见Notification；webhook未设置时发送Notification的JSON
+	Template string `json:"template,omitempty"`
+	// Routes 任一规则匹配即发送，为空时只发送告警触发/恢复和任务失败
+	Routes    []NotificationRoute `json:"routes,omitempty"`
+	RateLimit *RateLimit          `json:"rate_limit,omitempty"`
+
+	SMTPAddr    string   `json:"smtp_addr,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	PasswordRef string   `json:"password_ref,omitempty"`
+	Subject     string   `json:"subject,omitempty"`
+
+	body    *template.Template
+	subject *template.Template
+}
+
+func (ch *NotificationChannel) compile() error {
+	switch ch.Type {
+	case channelWebhook, channelSlack:
+		if ch.URL == "" && ch.URLRef == "" {
+			return fmt.Errorf("%w: url or url_ref is required", errInvalidChannel)
+		}
+	case channelEmail:
+		if ch.SMTPAddr == "" || ch.From == "" || len(ch.To) == 0 {
+			return fmt.Errorf("%w: smtp_addr, from and to are required", errInvalidChannel)
+		}
+		if _, _, err := net.SplitHostPort(ch.SMTPAddr); err != nil {
+			return fmt.Errorf("%w: smtp_addr: %v", errInvalidChannel, err)
+		}
+	default:
+		return fmt.Errorf("%w: type must be webhook, slack or email", errInvalidChannel)
+	}
+	if ch.RateLimit != nil {
+		if err := ch.RateLimit.validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidChannel, err)
+		}
+	}
+	for _, route := range ch.Routes {
+		if _, ok := notifySeverityLevels[route.MinSeverity]; route.MinSeverity != "" && !ok {
+			return fmt.Errorf("%w: min_severity must be info, warning, error or critical", errInvalidChannel)
+		}
+	}
+
+	source := ch.Template
+	if source == "" && ch.Type != channelWebhook {
+		source = defaultNotifyTemplate
+	}
+	var err error
+	if source != "" {
+		if ch.body, err = template.New(ch.Name).Option("missingkey=zero").Parse(source); err != nil {
+			return fmt.Errorf("%w: template: %v", errInvalidChannel, err)
+		}
+	}
+	if ch.Type == channelEmail {
+		subject := ch.Subject
+		if subject == "" {
+			subject = defaultNotifySubject
+		}
+		if ch.subject, err = template.New(ch.Name + "-subject").Option("missingkey=zero").Parse(subject); err != nil {
+			return fmt.Errorf("%w: subject: %v", errInvalidChannel, err)
+		}
+	}
+	return nil
+}
+
+func (ch *NotificationChannel) routed(n *Notification) bool {
+	if len(ch.Routes) == 0 {
+		return containsString(notifyDefaultTypes, n.Type)
+	}
+	for _, route := range ch.Routes {
+		if route.match(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *NotificationChannel) limit() RateLimit {
+	if ch.RateLimit != nil {
+		return *ch.RateLimit
+	}
+	return notifyDefaultLimit
+}
+
+// public 列表中不返回内联的webhook地址
+func (ch *NotificationChannel) public() NotificationChannel {
+	copied := *ch
+	if copied.URL != "" {
+		copied.URL = "<redacted>"
+	}
+	return copied
+}
+
+// Notification 模板可用的字段
+type Notification struct {
+	Type         string         `json:"type"`
+	Severity     string         `json:"severity"`
+	Summary      string         `json:"summary"`
+	Namespace    string         `json:"namespace,omitempty"`
+	ConnectionID string         `json:"connection_id,omitempty"`
+	Host         string         `json:"host,omitempty"`
+	Event        LifecycleEvent `json:"event"`
+}
+
+// newNotification 不需要通知的事件返回nil
+func newNotification(event LifecycleEvent) *Notification {
+	n := &Notification{Type: event.Type, Severity: "info", Namespace: event.Namespace,
+		ConnectionID: event.ConnectionID, Host: event.Host, Event: event}
+	data, _ := event.Data.(map[string]interface{})
+	switch event.Type {
+	case eventAlertFiring, eventAlertResolved:
+		n.Severity, _ = data["severity"].(string)
+		if event.Type == eventAlertResolved {
+			n.Severity = "info"
+		}
+		n.Summary, _ = data["summary"].(string)
+		if n.Summary == "" {
+			n.Summary = fmt.Sprintf("%s %v (%s)", data["rule"], data["value"], data["condition"])
+		}
+	case eventJobStateChanged:
+		view, _ := event.Data.(gin.H)
+		if view["status"] != JobFailed {
+			return nil
+		}
+		n.Type, n.Severity = notifyJobFailed, "error"
+		n.Summary = fmt.Sprintf("job %v (%v) failed: %v", view["id"], view["type"], view["error"])
+	case eventCommandFinished:
+		if success, _ := data["success"].(bool); success {
+			return nil
+		}
+		n.Type, n.Severity = notifyCommandFailed, "warning"
+		n.Summary = fmt.Sprintf("command %v failed", data["command"])
+		if class, ok := data["error_class"]; ok {
+			n.Summary += fmt.Sprintf(" (%v)", class)
+		}
+	case eventConnectionLost:
+		n.Severity = "warning"
+		n.Summary, _ = data["error"].(string)
+		n.Summary = firstNonEmpty(n.Summary, "connection lost")
+	case eventPolicyDenied:
+		n.Severity = "warning"
+		n.Summary, _ = data["reason"].(string)
+	default:
+		n.Summary = event.Type
+	}
+	if _, ok := notifySeverityLevels[n.Severity]; !ok {
+		n.Severity = "warning"
+	}
+	return n
+}
+
+// ChannelStatus 渠道的投递统计
+type ChannelStatus struct {
+	Queued      int        `json:"queued"`
+	Sent        uint64     `json:"sent"`
+	Failed      uint64     `json:"failed"`
+	Retried     uint64     `json:"retried"`
+	Dropped     uint64     `json:"dropped"`
+	RateLimited uint64     `json:"rate_limited"`
+	LastSentAt  *time.Time `json:"last_sent_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// channelWorker 替换或删除渠道时关闭queue，正在发送的通知仍会完成
+type channelWorker struct {
+	channel *NotificationChannel
+	queue   chan *Notification
+	bucket  tokenBucket
+	status  ChannelStatus
+}
+
+type Notifier struct {
+	workers map[string]*channelWorker
+	client  *http.Client
+	mutex   sync.Mutex
+}
+
+var notifier = newNotifier()
+
+func newNotifier() *Notifier {
+	n := &Notifier{
+		workers: make(map[string]*channelWorker),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: safeDialer(10 * time.Second).DialContext},
+		},
+	}
+	if notifyChannelsFile == "" {
+		return n
+	}
+	data, err := os.ReadFile(notifyChannelsFile)
+	if err != nil {
+		logError("notify.channels_load_failed", "load notification channels failed", "file", notifyChannelsFile, "error", err)
+		return n
+	}
+	var channels []NotificationChannel
+	if err := json.Unmarshal(data, &channels); err != nil {
+		logError("notify.channels_load_failed", "load notification channels failed", "file", notifyChannelsFile, "error", err)
+		return n
+	}
+	for _, ch := range channels {
+		if err := n.Put(ch); err != nil {
+			logError("notify.channels_load_failed", "load notification channel failed", "channel", ch.Name, "error", err)
+		}
+	}
+	return n
+}
+
+func (n *Notifier) Put(ch NotificationChannel) error {
+	if err := ch.compile(); err != nil {
+		return err
+	}
+	worker := &channelWorker{channel: &ch, queue: make(chan *Notification, notifyQueueSize)}
+	worker.bucket.tokens, worker.bucket.last = ch.limit().burst(), time.Now()
+	n.mutex.Lock()
+	if old, ok := n.workers[ch.Name]; ok {
+		close(old.queue)
+		worker.status = old.status
+		worker.status.Queued = 0
+	}
+	n.workers[ch.Name] = worker
+	n.mutex.Unlock()
+	go n.run(worker)
+	return nil
+}
+
+func (n *Notifier) Remove(name string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	worker, ok := n.workers[name]
+	if !ok {
+		return errChannelNotFound
+	}
+	close(worker.queue)
+	delete(n.workers, name)
+	return nil
+}
+
+func (n *Notifier) Channels() []NotificationChannel {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	list := make([]NotificationChannel, 0, len(n.workers))
+	for _, worker := range n.workers {
+		list = append(list, worker.channel.public())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (n *Notifier) Status() map[string]ChannelStatus {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	status := make(map[string]ChannelStatus, len(n.workers))
+	for name, worker := range n.workers {
+		s := worker.status
+		s.Queued = len(worker.queue)
+		status[name] = s
+	}
+	return status
+}
+
+// Notify 按路由放入各渠道的队列，限流或队列满时丢弃
+func (n *Notifier) Notify(notification *Notification) {
+	now := time.Now()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, worker := range n.workers {
+		if !worker.channel.routed(notification) {
+			continue
+		}
+		n.enqueue(worker, notification, now)
+	}
+}
+
+// enqueue 调用方需持有锁
+func (n *Notifier) enqueue(worker *channelWorker, notification *Notification, now time.Time) bool {
+	if limit := worker.channel.limit(); limit.enabled() {
+		if ok, _ := worker.bucket.take(limit, now); !ok {
+			worker.status.RateLimited++
+			return false
+		}
+	}
+	select {
+	case worker.queue <- notification:
+		return true
+	default:
+		worker.status.Dropped++
+		return false
+	}
+}
+
+// Test 向指定渠道发送一条测试通知，不经过路由规则
+func (n *Notifier) Test(name string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	worker, ok := n.workers[name]
+	if !ok {
+		return errChannelNotFound
+	}
+	test := &Notification{Type: "test", Severity: "info", Summary: "test notification",
+		Event: LifecycleEvent{Type: "test", Timestamp: time.Now()}}
+	if !n.enqueue(worker, test, time.Now()) {
+		return fmt.Errorf("notification channel %s is rate limited or its queue is full", name)
+	}
+	return nil
+}
+
+func (n *Notifier) run(worker *channelWorker) {
+	for notification := range worker.queue {
+		var err error
+		for attempt := 0; attempt <= notifyMaxRetries; attempt++ {
+			if attempt > 0 {
+				n.record(worker, func(s *ChannelStatus) { s.Retried++ })
+				time.Sleep(time.Duration(1<<(attempt-1)) * time.Second)
+			}
+			if err = n.send(worker.channel, notification); err == nil {
+				break
+			}
+		}
+		now := time.Now()
+		if err != nil {
+			message := redact(err.Error())
+			n.record(worker, func(s *ChannelStatus) { s.Failed++; s.LastError, s.LastErrorAt = message, &now })
+			logWarn("notify.delivery_failed", "notification delivery failed", "channel", worker.channel.Name,
+				"type", notification.Type, "error", message)
+			continue
+		}
+		n.record(worker, func(s *ChannelStatus) { s.Sent++; s.LastSentAt = &now })
+	}
+}
+
+func (n *Notifier) record(worker *channelWorker, update func(*ChannelStatus)) {
+	n.mutex.Lock()
+	update(&worker.status)
+	n.mutex.Unlock()
+}
+
+func (n *Notifier) send(ch *NotificationChannel, notification *Notification) error {
+	var body bytes.Buffer
+	if ch.body != nil {
+		if err := ch.body.Execute(&body, notification); err != nil {
+			return fmt.Errorf("render template: %w", err)
+		}
+	}
+	switch ch.Type {
+	case channelWebhook:
+		if ch.body == nil {
+			if err := json.NewEncoder(&body).Encode(notification); err != nil {
+				return err
+			}
+		}
+		return n.post(ch, body.Bytes())
+	case channelSlack:
+		payload, err := json.Marshal(map[string]string{"text": body.String()})
+		if err != nil {
+			return err
+		}
+		return n.post(ch, payload)
+	case channelEmail:
+		var subject bytes.Buffer
+		if err := ch.subject.Execute(&subject, notification); err != nil {
+			return fmt.Errorf("render subject: %w", err)
+		}
+		return sendMail(ch, strings.TrimSpace(subject.String()), body.String())
+	}
+	return errInvalidChannel
+}
+
+func (n *Notifier) post(ch *NotificationChannel, payload []byte) error {
+	url, err := credentialValue(ch.URL, ch.URLRef)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		// url.Error包含完整地址，可能带有令牌
+		return errors.Unwrap(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendMail 经过目标地址策略拨号，服务器支持时使用STARTTLS
+func sendMail(ch *NotificationChannel, subject, body string) error {
+	host, _, _ := net.SplitHostPort(ch.SMTPAddr)
+	conn, err := safeDialer(10*time.Second).Dial("tcp", ch.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if ch.Username != "" {
+		password, err := credentialValue("", ch.PasswordRef)
+		if err != nil {
+			return err
+		}
+		if err := client.Auth(smtp.PlainAuth("", ch.Username, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(ch.From); err != nil {
+		return err
+	}
+	for _, to := range ch.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		ch.From, strings.Join(ch.To, ", "), subject, time.Now().Format(time.RFC1123Z), body)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// forwardEventsToNotifier 总线订阅者队列满时丢弃，通知不会阻塞事件发布
+func forwardEventsToNotifier(sub *eventSubscriber) {
+	for event := range sub.ch {
+		if notification := newNotification(event); notification != nil {
+			notifier.Notify(notification)
+		}
+	}
+}
+
+func init() {
+	// 在init中订阅，启动后最早的事件也能收到
+	go forwardEventsToNotifier(events.Subscribe(eventSubscriberBuffer))
+}
+
+func (n *Notifier) WriteMetrics(w io.Writer) {
+	status := n.Status()
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := metricWriter{w}
+	out.family("collector_notifications_total", "counter", "Notifications per channel and outcome.")
+	for _, name := range names {
+		s := status[name]
+		for _, outcome := range []struct {
+			name  string
+			value uint64
+		}{{"sent", s.Sent}, {"failed", s.Failed}, {"retried", s.Retried}, {"dropped", s.Dropped}, {"rate_limited", s.RateLimited}} {
+			out.sample("collector_notifications_total", float64(outcome.value), "channel", name, "result", outcome.name)
+		}
+	}
+	out.family("collector_notification_queue", "gauge", "Notifications waiting per channel.")
+	for _, name := range names {
+		out.sample("collector_notification_queue", float64(status[name].Queued), "channel", name)
+	}
+}
+
+func registerNotificationRoutes(r *gin.Engine) {
+	r.GET("/notifications/channels", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"channels": notifier.Channels()})
+	})
+
+	r.PUT("/notifications/channels/:name", func(c *gin.Context) {
+		var ch NotificationChannel
+		if err := c.ShouldBindJSON(&ch); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		ch.Name = c.Param("name")
+		if err := notifier.Put(ch); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		logInfo("audit", "notification channel changed", append(requestLogFields(c), "channel", ch.Name, "type", ch.Type)...)
+		c.JSON(http.StatusOK, ch.public())
+	})
+
+	r.DELETE("/notifications/channels/:name", func(c *gin.Context) {
+		if err := notifier.Remove(c.Param("name")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "notification channel removed"})
+	})
+
+	r.POST("/notifications/channels/:name/test", func(c *gin.Context) {
+		err := notifier.Test(c.Param("name"))
+		if errors.Is(err, errChannelNotFound) {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusTooManyRequests, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "test notification queued"})
+	})
+
+	r.GET("/notifications/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"channels": notifier.Status()})
+	})
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI文档：路径来自已注册的gin路由，请求/响应结构由Go类型反射生成，
+// 因此新增路由或修改结构体后文档自动更新。apiOperations只补充摘要和类型
+
+const openapiVersion = "3.0.3"
+
+// apiOperation Request/Response 为零值示例，用于生成schema；
+// RequestExample/ResponseExample 写入文档，测试中按生成的schema校验
+type apiOperation struct {
+	Summary         string
+	Request         interface{}
+	Response        interface{}
+	RequestExample  interface{}
+	ResponseExample interface{}
+}
+
+var apiOperations = map[string]apiOperation{
+	"GET /health":                         {Summary: "Service health"},
+	"GET /version":                        {Summary: "Version, commit, build date, protocols and enabled features", Response: VersionInfo{}},
+	"GET /healthz":                        {Summary: "Liveness probe"},
+	"GET /readyz":                         {Summary: "Readiness probe"},
+	"POST /connect":                       {Summary: "Open a device connection", Request: SSHConfig{}, RequestExample: exampleConnect},
+	"GET /auth-failures":                  {Summary: "List authentication failure backoff state"},
+	"DELETE /auth-failures":               {Summary: "Clear the authentication failure lockout of a target"},
+	"POST /execute":                       {Summary: "Execute a command on a connection", Request: CommandRequest{}, Response: CommandResult{}, RequestExample: exampleExecute, ResponseExample: exampleCommandResult},
+	"POST /execute/bulk":                  {Summary: "Execute different commands on many connections", Request: BulkRequest{}, Response: BulkResponse{}, RequestExample: exampleBulk},
+	"GET /execute/ws":                     {Summary: "WebSocket channel for multiplexed executes (see ws.go for the message format)"},
+	"POST /disconnect":                    {Summary: "Close a connection"},
+	"GET /connections":                    {Summary: "List active connections"},
+	"GET /connections/:id/health":         {Summary: "Check a connection"},
+	"GET /connections/:id/stats":          {Summary: "Per-connection latency, failure and traffic statistics", Response: ConnectionStatsReport{}},
+	"GET /connections/:id/acl":            {Summary: "Show connection owner and sharing", Response: ConnectionACL{}},
+	"PUT /connections/:id/acl":            {Summary: "Replace connection sharing", Response: ConnectionACL{}},
+	"POST /connections/:id/files/archive": {Summary: "Archive remote files", Request: ArchiveRequest{}},
+	"POST /connections/:id/files/fetch":   {Summary: "Fetch a URL onto the device", Request: FetchRequest{}},
+	"POST /connections/:id/files/sync":    {Summary: "Synchronise a directory", Request: SyncRequest{}},
+	"GET /results/:id":                    {Summary: "Retrieve a stored result", Response: ResultRecord{}},
+	"POST /results/diff":                  {Summary: "Diff two results", Response: DiffResult{}},
+	"POST /transform/test":                {Summary: "Evaluate a JMESPath expression"},
+	"POST /scripts/test":                  {Summary: "Run a post-processing script"},
+	"GET /masking/rules":                  {Summary: "List masking rules"},
+	"PUT /masking/rules/:name":            {Summary: "Create or replace a masking rule", Request: MaskRule{}, Response: MaskRule{}, RequestExample: exampleMaskRule},
+	"GET /metrics/rules":                  {Summary: "List metric extraction rules"},
+	"PUT /metrics/rules/:name":            {Summary: "Create or replace a metric rule", Request: MetricRule{}},
+	"PUT /parsers/:name":                  {Summary: "Create or update a custom parser", Request: CustomParserConfig{}},
+	"POST /drivers":                       {Summary: "Register a device driver", Request: DeviceDriver{}},
+	"POST /db/sources":                    {Summary: "Register a database source", Request: DBConfig{}},
+	"POST /db/query":                      {Summary: "Run a database query", Request: DBQueryRequest{}, RequestExample: exampleDBQuery},
+	"POST /ftp/endpoints":                 {Summary: "Register an FTP endpoint", Request: FTPConfig{}},
+	"POST /gnmi/targets":                  {Summary: "Register a gNMI target", Request: GNMITargetConfig{}},
+	"POST /gnmi/get":                      {Summary: "gNMI Get", Request: GNMIGetRequest{}},
+	"POST /gnmi/subscriptions":            {Summary: "Start a gNMI subscription", Request: GNMISubscribeRequest{}},
+	"POST /probe/grpc":                    {Summary: "gRPC health probe", Request: GRPCProbeRequest{}},
+	"POST /probe/ping":                    {Summary: "ICMP ping probe", Request: PingRequest{}},
+	"POST /probe/port":                    {Summary: "TCP port probe", Request: PortCheckRequest{}},
+	"POST /http/endpoints":                {Summary: "Register an HTTP endpoint", Request: HTTPEndpointConfig{}},
+	"POST /http/request":                  {Summary: "Send an HTTP request", Request: HTTPRequest{}},
+	"POST /ipmi/bmcs":                     {Summary: "Register an IPMI BMC", Request: IPMITarget{}},
+	"POST /ipmi/sensors":                  {Summary: "Read IPMI sensors", Request: IPMIRequest{}},
+	"POST /ipmi/sel":                      {Summary: "Read the IPMI event log", Request: IPMIRequest{}},
+	"POST /modbus/devices":                {Summary: "Register a Modbus device", Request: ModbusDeviceConfig{}},
+	"POST /modbus/maps":                   {Summary: "Register a Modbus register map", Request: ModbusMap{}},
+	"POST /modbus/read":                   {Summary: "Read Modbus registers", Request: ModbusReadRequest{}},
+	"POST /mqtt/brokers":                  {Summary: "Register an MQTT broker", Request: MQTTBrokerConfig{}},
+	"POST /mqtt/subscriptions":            {Summary: "Subscribe to MQTT topics", Request: MQTTSubscriptionRequest{}},
+	"POST /netconf/rpc":                   {Summary: "NETCONF rpc", Request: NetconfRequest{}},
+	"POST /netconf/get":                   {Summary: "NETCONF get", Request: NetconfRequest{}},
+	"POST /netconf/get-config":            {Summary: "NETCONF get-config", Request: NetconfRequest{}},
+	"POST /netconf/edit-config":           {Summary: "NETCONF edit-config", Request: NetconfRequest{}},
+	"POST /redfish/bmcs":                  {Summary: "Register a Redfish BMC", Request: BMCConfig{}},
+	"POST /scrapes":                       {Summary: "Schedule a tunnelled scrape", Request: ScrapeConfig{}},
+	"POST /snmp/get":                      {Summary: "SNMP get", Request: SNMPRequest{}},
+	"POST /snmp/walk":                     {Summary: "SNMP walk", Request: SNMPRequest{}},
+	"GET /admin/ratelimits":               {Summary: "Show rate limits", Response: RateLimitConfig{}},
+	"PUT /admin/ratelimits":               {Summary: "Replace rate limits", Request: RateLimitConfig{}, Response: RateLimitConfig{}, RequestExample: exampleRateLimits, ResponseExample: exampleRateLimits},
+	"GET /admin/destination-policy":       {Summary: "Show destination policy", Response: DestinationPolicy{}},
+	"PUT /admin/destination-policy":       {Summary: "Replace destination policy", Request: DestinationPolicy{}, Response: DestinationPolicy{}, RequestExample: exampleDestinationPolicy},
+	"GET /admin/log-level":                {Summary: "Show log level and format"},
+	"PUT /admin/log-level":                {Summary: "Change the log level at runtime"},
+	"GET /admin/audit":                    {Summary: "Query the audit log (since, until, principal, host, operation, decision, limit)"},
+	"GET /admin/audit/verify":             {Summary: "Verify the audit log hash chain"},
+	"GET /debug/goroutines":               {Summary: "Goroutine counts by creation site (DEBUG_ENDPOINTS=true)"},
+	"GET /debug/connections":              {Summary: "Internal connection, queue and keepalive state (DEBUG_ENDPOINTS=true)"},
+	"GET /whoami":                         {Summary: "Show the authenticated caller"},
+
+	"GET /slowlog":                              {Summary: "List commands and jobs slower than the threshold (connection_id, kind, limit)"},
+	"PUT /slowlog/threshold":                    {Summary: "Change the global slowlog threshold", Request: thresholdRequest{}},
+	"PUT /connections/:id/slowlog-threshold":    {Summary: "Override the slowlog threshold of a connection", Request: thresholdRequest{}},
+	"DELETE /connections/:id/slowlog-threshold": {Summary: "Remove the slowlog threshold override of a connection"},
+
+	"GET /connections/:id":          {Summary: "Show a connection with the timing breakdown of its last connect", Response: ConnectionSummary{}},
+	"GET /admin/log-command-output": {Summary: "Show command output logging mode, byte cap and namespace overrides"},
+	"PUT /admin/log-command-output": {Summary: "Change command output logging (off, truncated, full)", Request: OutputLogConfig{}},
+	"GET /admin/syslog-forward":     {Summary: "Show syslog forwarder config and delivery counters"},
+	"PUT /admin/syslog-forward":     {Summary: "Replace syslog forwarder facility, severities and event types", Request: SyslogForwardConfig{}},
+	"GET /events":                   {Summary: "Stream lifecycle events as Server-Sent Events (namespace, connection_id, type)"},
+
+	"GET /alerts":                         {Summary: "List alert series and their state (rule, state)"},
+	"GET /alerts/rules":                   {Summary: "List alert rules"},
+	"PUT /alerts/rules/:name":             {Summary: "Create or replace an alert rule on an extracted metric", Request: AlertRule{}},
+	"DELETE /alerts/rules/:name":          {Summary: "Remove an alert rule and its series state"},
+	"POST /alerts/rules/:name/silences":   {Summary: "Add a silence window to an alert rule", Request: AlertSilence{}},
+	"DELETE /alerts/rules/:name/silences": {Summary: "Remove all silence windows of an alert rule"},
+
+	"GET /notifications/channels":             {Summary: "List notification channels (inline webhook URLs are redacted)"},
+	"PUT /notifications/channels/:name":       {Summary: "Create or replace a webhook, Slack or email notification channel", Request: NotificationChannel{}},
+	"DELETE /notifications/channels/:name":    {Summary: "Remove a notification channel"},
+	"POST /notifications/channels/:name/test": {Summary: "Queue a test notification on a channel"},
+	"GET /notifications/status":               {Summary: "Show delivery counters and the last error per notification channel"},
+
+	"GET /results": {Summary: "Query stored results (connection_id, host, command, command_hash, exit_code, error_class, namespace, since, until, sort, fields, page, cursor)", Response: ResultPage{}},
+
+	"GET /results/export":               {Summary: "Stream stored results as ndjson or csv (query filters, format, columns, max rows RESULT_EXPORT_MAX_ROWS, async=true for a job)"},
+	"GET /results/exports/:id":          {Summary: "Download an async export artifact"},
+	"GET /results/exports/:id/metadata": {Summary: "Get export artifact metadata including its parameters", Response: ExportArtifact{}},
+
+	"GET /admin/sinks": {Summary: "Show sink health, buffer lag and delivery counters"},
+
+	"GET /results/latest":   {Summary: "Latest result for a connection and command (connection_id, command or command_hash), read through the result cache"},
+	"DELETE /results/cache": {Summary: "Drop cached results for a connection (connection_id)"},
+}
+
+// 文档示例，JSON字段名与请求体一致
+var (
+	exampleConnect = map[string]interface{}{
+		"host": "10.0.0.1", "port": 22, "username": "admin", "password": "secret",
+		"device_type": "cisco_ios", "alias": "core-sw1", "tags": []string{"dc1", "core"},
+	}
+	exampleExecute = map[string]interface{}{
+		"connection_id": "ssh-0d6f3a9c1b2e4f5a8c7d6e5f4a3b2c1d", "command": "show version",
+		"parse": map[string]interface{}{"auto": true},
+	}
+	exampleCommandResult = map[string]interface{}{
+		"id": "5f0c9e1b2a7d4c3e8f6a1b2c3d4e5f60", "command": "show version", "output": "Cisco IOS Software, Version 15.2(4)E7\n",
+		"exit_code": 0, "parsed": []interface{}{map[string]interface{}{"VERSION": "15.2(4)E7"}},
+		"timestamp": "2024-05-01T12:00:00Z", "request_id": "8b3c2f0e-6c1d-4a57-9c7e-0f1e2d3c4b5a",
+	}
+	exampleBulk = map[string]interface{}{
+		"entries": []interface{}{
+			map[string]interface{}{"connection_id": "ssh-0d6f3a9c1b2e4f5a8c7d6e5f4a3b2c1d", "command": "show clock"},
+			map[string]interface{}{"connection_id": "ssh-7e1a2b3c4d5e6f708192a3b4c5d6e7f8", "commands": []string{"show clock", "show users"}},
+		},
+		"concurrency": 10, "timeout": 60, "async": true,
+	}
+	exampleMaskRule   = map[string]interface{}{"pattern": `snmp-server community \S+`, "replacement": "snmp-server community ***", "device_types": []string{"cisco_ios"}}
+	exampleDBQuery    = map[string]interface{}{"source": "inventory", "query": "SELECT name FROM devices WHERE site = $1", "args": []interface{}{"dc1"}, "max_rows": 100}
+	exampleRateLimits = map[string]interface{}{
+		"global": map[string]interface{}{"rate": 100, "burst": 200}, "per_ip": map[string]interface{}{"rate": 10, "burst": 20},
+		"per_key": map[string]interface{}{"rate": 20, "burst": 40}, "routes": map[string]interface{}{"POST /connect": map[string]interface{}{"rate": 2, "burst": 5}},
+	}
+	exampleDestinationPolicy = map[string]interface{}{"deny": []string{"169.254.0.0/16"}, "allow": []string{"10.0.0.0/8"}, "default_deny": true}
+)
+
+// schemaBuilder 把Go类型转换为JSON Schema，命名结构体放入components复用
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schema(t.Elem())
+		if _, ref := s["$ref"]; !ref {
+			s["nullable"] = true
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = map[string]interface{}{} // 占位，防止递归类型死循环
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// interface{} 等任意值
+	return map[string]interface{}{}
+}
+
+// object 按json标签生成属性，binding:"required" 的字段列为必填，匿名嵌入的结构体展开
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			ft := field.Type
+			if field.Anonymous && name == "" {
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft)
+				}
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = b.schema(ft)
+			if strings.Contains(field.Tag.Get("binding"), "required") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// openapiPath 把 :id、*path 转换为 {id}、{path}
+func openapiPath(path string) (string, []interface{}) {
+	var params []interface{}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			name := part[1:]
+			parts[i] = "{" + name + "}"
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func jsonContentExample(schema, example interface{}) map[string]interface{} {
+	content := jsonContent(schema)
+	if example != nil {
+		content["application/json"].(map[string]interface{})["example"] = example
+	}
+	return content
+}
+
+// buildOpenAPI 根据已注册的路由生成文档
+func buildOpenAPI(routes gin.RoutesInfo) map[string]interface{} {
+	b := &schemaBuilder{components: make(map[string]interface{})}
+	b.components["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"code": map[string]interface{}{"type": "string", "enum": errorCodeEnum,
+				"description": "Stable machine-readable error code"},
+			"message": map[string]interface{}{"type": "string", "description": "Human-readable description, may change between releases"},
+			"details": map[string]interface{}{"type": "object", "additionalProperties": true,
+				"description": "Code-specific context such as retry_after or error_class"},
+			"request_id": map[string]interface{}{"type": "string"},
+			"error":      map[string]interface{}{"type": "string", "deprecated": true, "description": "Same as message"},
+		},
+		"required": []string{"code", "message", "error"},
+	}
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		op := apiOperations[key]
+		path, params := openapiPath(route.Path)
+
+		tag := strings.SplitN(strings.TrimPrefix(route.Path, "/"), "/", 2)[0]
+		summary := op.Summary
+		if summary == "" {
+			summary = key
+		}
+		response := map[string]interface{}{"type": "object"}
+		if op.Response != nil {
+			response = b.schema(reflect.TypeOf(op.Response))
+		}
+		operation := map[string]interface{}{
+			"summary":      summary,
+			"operationId":  strings.ToLower(route.Method) + strings.NewReplacer("/", "_", ":", "", "*", "", "-", "_").Replace(route.Path),
+			"tags":         []string{tag},
+			"x-permission": routePermission(route.Method, route.Path),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "OK", "content": jsonContentExample(response, op.ResponseExample)},
+				"400": errorResponse("Invalid request"),
+				"401": errorResponse("Missing or invalid credentials"),
+				"403": errorResponse("Permission denied"),
+				"429": errorResponse("Rate limit exceeded"),
+			},
+		}
+		if authExemptPaths[route.Path] {
+			operation["security"] = []interface{}{}
+		}
+		if idempotentRoutes[key] {
+			params = append(params, map[string]interface{}{
+				"name": idempotencyKeyHeader, "in": "header", "required": false, "schema": map[string]interface{}{"type": "string"},
+				"description": "Replays the first response for retries with the same key and body; a different body returns 409",
+			})
+			operation["responses"].(map[string]interface{})["409"] = errorResponse("Idempotency key reused with a different request")
+		}
+		if key == "POST /connect" {
+			operation["responses"].(map[string]interface{})["423"] = errorResponse("Target locked after repeated authentication failures")
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContentExample(b.schema(reflect.TypeOf(op.Request)), op.RequestExample),
+			}
+		}
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": openapiVersion,
+		"info": map[string]interface{}{
+			"title":       "go-ssh-collector",
+			"version":     "1.0",
+			"description": "Multi-protocol device collector API. Clients may also authenticate with a TLS client certificate when mutual TLS is enabled.",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "/api/" + currentAPIVersion},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API key or OIDC JWT"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+}
+
+// Swagger UI从CDN加载，页面本身受认证保护
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-ssh-collector API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func registerOpenAPIRoutes(r *gin.Engine) {
+	var (
+		once sync.Once
+		spec map[string]interface{}
+	)
+	r.GET("/openapi.json", func(c *gin.Context) {
+		// 路由在启动后不再变化，首次请求时生成
+		once.Do(func() {
+			routes := r.Routes()
+			sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+			spec = buildOpenAPI(routes)
+		})
+		c.JSON(http.StatusOK, spec)
+	})
+
+	r.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+	})
+}
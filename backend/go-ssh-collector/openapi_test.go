@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateSchema 覆盖buildOpenAPI生成的schema子集，示例中出现schema之外的属性也视为错误
+func validateSchema(spec map[string]interface{}, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		components := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		resolved, ok := components[name].(map[string]interface{})
+		if !ok {
+			return []string{path + ": unresolved " + ref}
+		}
+		return validateSchema(spec, resolved, value, path)
+	}
+	if value == nil {
+		if schema["nullable"] == true || schema["type"] == nil {
+			return nil
+		}
+		return []string{path + ": null"}
+	}
+	var errs []string
+	fail := func(format string, args ...interface{}) []string {
+		return append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("expected object, got %T", value)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					errs = fail("missing required %q", name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range object {
+			switch {
+			case properties[name] != nil:
+				errs = append(errs, validateSchema(spec, properties[name].(map[string]interface{}), v, path+"."+name)...)
+			case additional != nil:
+				errs = append(errs, validateSchema(spec, additional, v, path+"."+name)...)
+			case schema["additionalProperties"] == true || len(properties) == 0:
+			default:
+				errs = fail("unknown property %q", name)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("expected array, got %T", value)
+		}
+		for i, item := range items {
+			errs = append(errs, validateSchema(spec, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fail("expected string, got %T", value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				errs = fail("invalid date-time %q", s)
+			}
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == s
+			}
+			if !found {
+				errs = fail("%q not in enum", s)
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return fail("expected integer, got %v", value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fail("expected number, got %T", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("expected boolean, got %T", value)
+		}
+	}
+	return errs
+}
+
+func decodeJSON(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return v
+}
+
+// jsonValue 经JSON往返，使Go字面量与解码后的文档类型一致
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+func servedOpenAPI(t *testing.T, r http.Handler) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("openapi.json: status %d", w.Code)
+	}
+	return decodeJSON(t, w.Body.Bytes())
+}
+
+// adminRouteSpec 管理监听上的路由不在主监听的文档中，单独生成，包括调试接口
+func adminRouteSpec(t *testing.T) (gin.RoutesInfo, map[string]interface{}) {
+	t.Helper()
+	debugEndpointsEnabled = true
+	t.Cleanup(func() { debugEndpointsEnabled = false })
+	r := gin.New()
+	registerAdminRoutes(r)
+	data, _ := json.Marshal(buildOpenAPI(r.Routes()))
+	return r.Routes(), decodeJSON(t, data)
+}
+
+func TestOpenAPIOperationsMatchRegisteredRoutes(t *testing.T) {
+	r := newRouter()
+	adminRoutes, _ := adminRouteSpec(t)
+	registered := make(map[string]bool)
+	for _, route := range append(r.Routes(), adminRoutes...) {
+		registered[route.Method+" "+route.Path] = true
+	}
+	var stale []string
+	for key := range apiOperations {
+		if !registered[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	if len(stale) > 0 {
+		t.Fatalf("apiOperations entries without a route: %v", stale)
+	}
+
+	spec := servedOpenAPI(t, r)
+	paths := spec["paths"].(map[string]interface{})
+	for _, route := range r.Routes() {
+		path, _ := openapiPath(route.Path)
+		item, _ := paths[path].(map[string]interface{})
+		if item[strings.ToLower(route.Method)] == nil {
+			t.Errorf("%s %s missing from the spec", route.Method, route.Path)
+		}
+	}
+}
+
+func TestOpenAPIExamplesMatchSchemas(t *testing.T) {
+	_, adminSpec := adminRouteSpec(t)
+	validated := 0
+	for _, spec := range []map[string]interface{}{servedOpenAPI(t, newRouter()), adminSpec} {
+		for path, item := range spec["paths"].(map[string]interface{}) {
+			for method, operation := range item.(map[string]interface{}) {
+				op := operation.(map[string]interface{})
+				var contents []interface{}
+				if body, ok := op["requestBody"].(map[string]interface{}); ok {
+					contents = append(contents, body["content"])
+				}
+				for _, response := range op["responses"].(map[string]interface{}) {
+					contents = append(contents, response.(map[string]interface{})["content"])
+				}
+				for _, content := range contents {
+					media, _ := content.(map[string]interface{})["application/json"].(map[string]interface{})
+					example, ok := media["example"]
+					if !ok {
+						continue
+					}
+					validated++
+					where := strings.ToUpper(method) + " " + path
+					for _, err := range validateSchema(spec, media["schema"].(map[string]interface{}), example, where) {
+						t.Error(err)
+					}
+				}
+			}
+		}
+	}
+	if validated == 0 {
+		t.Fatal("no examples in the spec")
+	}
+}
+
+// 请求示例还需能被处理函数按原类型严格解码
+func TestOpenAPIRequestExamplesDecode(t *testing.T) {
+	for key, op := range apiOperations {
+		if op.RequestExample == nil {
+			continue
+		}
+		data, _ := json.Marshal(op.RequestExample)
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(reflect.New(reflect.TypeOf(op.Request)).Interface()); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
+
+func TestOpenAPIDescribesLiveResponses(t *testing.T) {
+	r := newRouter()
+	spec := servedOpenAPI(t, r)
+	schemaFor := func(path, status string) map[string]interface{} {
+		op := spec["paths"].(map[string]interface{})[path].(map[string]interface{})["get"].(map[string]interface{})
+		response := op["responses"].(map[string]interface{})[status].(map[string]interface{})
+		return response["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	}
+	get := func(path string) interface{} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return jsonValue(t, decodeJSON(t, w.Body.Bytes()))
+	}
+
+	for _, err := range validateSchema(spec, schemaFor("/version", "200"), get("/version"), "GET /version") {
+		t.Error(err)
+	}
+	// 错误响应符合Error封装
+	for _, err := range validateSchema(spec, schemaFor("/connections/{id}", "400"), get("/connections/missing"), "GET /connections/missing") {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 命令输出日志：log_command_output 为 off（默认）时日志中只有输出字节数，truncated 时写入前 max_bytes 字节，
+// full 时写入完整输出。只在Execute中脱敏和掩码之后的一处写入（command.output 事件），与返回和存储的内容一致。
+// 可按命名空间覆盖，例如实验环境为full、生产为off。
+// 初始值来自 LOG_COMMAND_OUTPUT、LOG_COMMAND_OUTPUT_MAX_BYTES 和
+// LOG_COMMAND_OUTPUT_NAMESPACES（格式: lab=full,edge=truncated:1024），运行时通过 PUT /admin/log-command-output 修改。
+// 审计日志始终只记录输出的SHA-256，不受此设置影响
+
+const (
+	outputLogOff       = "off"
+	outputLogTruncated = "truncated"
+	outputLogFull      = "full"
+)
+
+var errInvalidOutputLogMode = errors.New("log_command_output must be off, truncated or full")
+
+type OutputLogSetting struct {
+	Mode string `json:"log_command_output"`
+	// MaxBytes truncated模式下每个输出（stdout、stderr分别计算）最多写入的字节数
+	MaxBytes int `json:"max_bytes,omitempty"`
+}
+
+func (s *OutputLogSetting) validate(defaultMax int) error {
+	switch s.Mode {
+	case outputLogOff, outputLogFull:
+	case outputLogTruncated:
+		if s.MaxBytes <= 0 {
+			s.MaxBytes = defaultMax
+		}
+	default:
+		return errInvalidOutputLogMode
+	}
+	return nil
+}
+
+type OutputLogConfig struct {
+	OutputLogSetting
+	Namespaces map[string]OutputLogSetting `json:"namespaces"`
+}
+
+func (cfg *OutputLogConfig) validate() error {
+	if cfg.Mode == "" {
+		cfg.Mode = outputLogOff
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 4096
+	}
+	if err := cfg.OutputLogSetting.validate(cfg.MaxBytes); err != nil {
+		return err
+	}
+	if cfg.Namespaces == nil {
+		cfg.Namespaces = map[string]OutputLogSetting{}
+	}
+	for namespace, setting := range cfg.Namespaces {
+		if err := setting.validate(cfg.MaxBytes); err != nil {
+			return fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+		cfg.Namespaces[namespace] = setting
+	}
+	return nil
+}
+
+// setting 命名空间的生效设置
+func (cfg *OutputLogConfig) setting(namespace string) OutputLogSetting {
+	if setting, ok := cfg.Namespaces[namespace]; ok && namespace != "" {
+		return setting
+	}
+	return cfg.OutputLogSetting
+}
+
+func envOutputLogConfig() *OutputLogConfig {
+	cfg := &OutputLogConfig{
+		OutputLogSetting: OutputLogSetting{
+			Mode:     getEnv("LOG_COMMAND_OUTPUT", outputLogOff),
+			MaxBytes: int(envInt64("LOG_COMMAND_OUTPUT_MAX_BYTES", 4096)),
+		},
+		Namespaces: map[string]OutputLogSetting{},
+	}
+	for _, pair := range splitList(getEnv("LOG_COMMAND_OUTPUT_NAMESPACES", "")) {
+		namespace, value, _ := strings.Cut(pair, "=")
+		mode, size, _ := strings.Cut(value, ":")
+		setting := OutputLogSetting{Mode: strings.TrimSpace(mode)}
+		setting.MaxBytes, _ = strconv.Atoi(size)
+		cfg.Namespaces[strings.TrimSpace(namespace)] = setting
+	}
+	if err := cfg.validate(); err != nil {
+		logError("output_log.config_invalid", "invalid command output logging config, disabling output logging", "error", err)
+		return &OutputLogConfig{OutputLogSetting: OutputLogSetting{Mode: outputLogOff, MaxBytes: 4096}, Namespaces: map[string]OutputLogSetting{}}
+	}
+	return cfg
+}
+
+var outputLog = struct {
+	config *OutputLogConfig
+	mutex  sync.RWMutex
+}{config: envOutputLogConfig()}
+
+func currentOutputLogConfig() *OutputLogConfig {
+	outputLog.mutex.RLock()
+	defer outputLog.mutex.RUnlock()
+	return outputLog.config
+}
+
+// truncateOutput 按字节截断且不截断UTF-8字符
+func truncateOutput(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max], true
+}
+
+// logCommandOutput 在Execute完成脱敏和掩码后调用，是命令输出写入日志的唯一位置
+func logCommandOutput(req CommandRequest, info ConnectionInfo, namespace string, result *CommandResult) {
+	setting := currentOutputLogConfig().setting(namespace)
+	if setting.Mode == outputLogOff {
+		return
+	}
+	output, stderr := result.Output, result.Stderr
+	var outputTruncated, stderrTruncated bool
+	if setting.Mode == outputLogTruncated {
+		output, outputTruncated = truncateOutput(output, setting.MaxBytes)
+		stderr, stderrTruncated = truncateOutput(stderr, setting.MaxBytes)
+	}
+	fields := []interface{}{"request_id", req.requestID, "connection_id", req.ConnectionID, "namespace", namespace,
+		"protocol", info.Protocol, "host", info.Host, "result_id", result.ID, "mode", setting.Mode,
+		"output_bytes", len(result.Output), "output", output}
+	if outputTruncated {
+		fields = append(fields, "output_truncated", true)
+	}
+	if result.Stderr != "" {
+		fields = append(fields, "stderr_bytes", len(result.Stderr), "stderr", stderr)
+		if stderrTruncated {
+			fields = append(fields, "stderr_truncated", true)
+		}
+	}
+	logInfo("command.output", "command output", fields...)
+}
+
+func registerOutputLogRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/log-command-output", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentOutputLogConfig())
+	})
+
+	admin.PUT("", func(c *gin.Context) {
+		var cfg OutputLogConfig
+		if err := c.ShouldBindJSON(&cfg); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := cfg.validate(); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		outputLog.mutex.Lock()
+		outputLog.config = &cfg
+		outputLog.mutex.Unlock()
+		logInfo("audit", "command output logging changed", append(requestLogFields(c), "mode", cfg.Mode,
+			"max_bytes", cfg.MaxBytes, "namespaces", len(cfg.Namespaces))...)
+		c.JSON(http.StatusOK, &cfg)
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// 超过该大小的JSON输出不做解析
+	parseJSONMaxBytes = envInt64("PARSE_JSON_MAX_BYTES", 8<<20)
+	// 未指定parse选项时自动识别JSON输出
+	parseAutoJSON = getEnv("PARSE_AUTO_JSON", "true") == "true"
+)
+
+// ParseOptions 执行请求中的输出解析选项
+type ParseOptions struct {
+	// Mode 解析方式: template / builtin (指定template或builtin时默认) / table / json / kv / ini / grok / xml
+	Mode string `json:"mode"`
+	// Template 使用模板库中的TextFSM模板
+	Template string `json:"template"`
+	// Builtin 使用内置解析器，见 GET /parsers
+	Builtin string `json:"builtin"`
+	// Auto 按连接的device_type和命令自动选择内置解析器，没有匹配时跳过解析
+	Auto bool `json:"auto"`
+	// grok模式: 表达式，以及是否返回未匹配的行
+	Pattern          string `json:"pattern"`
+	IncludeUnmatched bool   `json:"include_unmatched"`
+	// 表格模式下跳过的前导行数及表头匹配正则
+	SkipLines   int    `json:"skip_lines"`
+	HeaderRegex string `json:"header_regex"`
+	// Scan JSON模式下跳过横幅等前导内容，从第一个{或[开始解析
+	Scan bool `json:"scan"`
+	// kv/ini模式: 分隔符(默认取行内首个:或=)、是否去除空白(默认是)、键转小写
+	Separator     string `json:"separator"`
+	Trim          *bool  `json:"trim"`
+	LowercaseKeys bool   `json:"lowercase_keys"`
+	// xml模式: XPath选取节点，属性处理 prefix/merge/ignore，命名空间处理 strip/prefix
+	XPath         string `json:"xpath"`
+	XMLAttributes string `json:"xml_attributes"`
+	XMLNamespaces string `json:"xml_namespaces"`
+}
+
+// ParsedOutput 一次解析的结果
+type ParsedOutput struct {
+	Parsed        interface{}
+	Unparsed      []string
+	UnparsedCount int
+	// Schema 内置解析器的输出结构版本，如 df/v1
+	Schema string
+}
+
+// applyParse 解析命令输出，失败时只设置parse_error
+func applyParse(result *CommandResult, opts *ParseOptions, deviceType string) {
+	if opts.Auto && opts.Mode == "" && opts.Template == "" && opts.Builtin == "" {
+		parser := parsers.Match(deviceType, result.Command)
+		if parser == nil {
+			result.ParseSkipped = fmt.Sprintf("no builtin parser for device_type %q and command %q", deviceType, result.Command)
+			return
+		}
+		auto := *opts
+		auto.Builtin = parser.Name
+		opts = &auto
+	}
+
+	out, err := parseOutput(result.Output, opts)
+	if err != nil {
+		result.ParseError = err.Error()
+		return
+	}
+	result.Parsed = out.Parsed
+	result.Unparsed = out.Unparsed
+	result.UnparsedCount = out.UnparsedCount
+	result.ParseSchema = out.Schema
+}
+
+// parseOutput 按解析选项处理文本，命令输出和SFTP下载的文件内容共用
+func parseOutput(text string, opts *ParseOptions) (*ParsedOutput, error) {
+	mode := opts.Mode
+	switch {
+	case mode != "":
+	case opts.Template != "":
+		mode = "template"
+	case opts.Builtin != "":
+		mode = "builtin"
+	}
+
+	switch mode {
+	case "template":
+		if opts.Template == "" {
+			return nil, errors.New("parse: template is required")
+		}
+		template, err := templates.Get(opts.Template)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %v", opts.Template, err)
+		}
+		records, err := template.fsm.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return &ParsedOutput{Parsed: records}, nil
+	case "builtin":
+		parser, err := parsers.Lookup(opts.Builtin)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parser.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", parser.Name, err)
+		}
+		return &ParsedOutput{Parsed: value, Schema: parser.schema()}, nil
+	case "table":
+		rows, err := ParseTable(text, opts.SkipLines, opts.HeaderRegex)
+		if err != nil {
+			return nil, fmt.Errorf("table: %v", err)
+		}
+		return &ParsedOutput{Parsed: rows}, nil
+	case "json":
+		value, err := parseJSONOutput(text, opts.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("json: %v", err)
+		}
+		return &ParsedOutput{Parsed: value}, nil
+	case "grok":
+		if opts.Pattern == "" {
+			return nil, errors.New("grok: pattern is required")
+		}
+		records, unmatched, err := ParseGrok(text, opts.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("grok: %v", err)
+		}
+		out := &ParsedOutput{Parsed: records, UnparsedCount: len(unmatched)}
+		if opts.IncludeUnmatched {
+			out.Unparsed = unmatched
+		}
+		return out, nil
+	case "kv", "ini":
+		values, unparsed := ParseKeyValue(text, opts, mode == "ini")
+		return &ParsedOutput{Parsed: values, Unparsed: unparsed, UnparsedCount: len(unparsed)}, nil
+	case "xml":
+		value, err := ParseXML(text, opts)
+		if err != nil {
+			return nil, fmt.Errorf("xml: %v", err)
+		}
+		return &ParsedOutput{Parsed: value}, nil
+	}
+	return nil, fmt.Errorf("parse: unsupported mode %q", opts.Mode)
+}
+
+// parseJSONOutput 解析JSON输出；scan时忽略首个{或[之前的内容以及文档之后的提示符
+func parseJSONOutput(output string, scan bool) (interface{}, error) {
+	if int64(len(output)) > parseJSONMaxBytes {
+		return nil, fmt.Errorf("output exceeds %d bytes", parseJSONMaxBytes)
+	}
+	data := []byte(output)
+	if scan {
+		start := bytes.IndexAny(data, "{[")
+		if start < 0 {
+			return nil, errors.New("no JSON document found")
+		}
+		data = data[start:]
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if !scan {
+		var extra interface{}
+		if err := decoder.Decode(&extra); err != io.EOF {
+			return nil, errors.New("unexpected content after JSON document")
+		}
+	}
+	return value, nil
+}
+
+// autoParseJSON 未请求解析时识别纯JSON输出，不是JSON时不设置parse_error
+func autoParseJSON(result *CommandResult) {
+	trimmed := strings.TrimSpace(result.Output)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return
+	}
+	if value, err := parseJSONOutput(trimmed, false); err == nil {
+		result.Parsed = value
+	}
+}
+
+var errInvalidExtract = errors.New("invalid extract rule")
+
+// ExtractRule 内联正则提取规则
+type ExtractRule struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+	// Group 取值的分组序号，默认有分组时取第1组，否则取整个匹配
+	Group *int `json:"group"`
+	// AllMatches 返回所有匹配组成的数组
+	AllMatches bool `json:"all_matches"`
+
+	re *regexp.Regexp
+}
+
+// compileExtractRules 在执行前校验并编译规则，错误中带出有问题的表达式
+func compileExtractRules(rules []ExtractRule) error {
+	seen := make(map[string]bool, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" || rule.Regex == "" {
+			return fmt.Errorf("%w: name and regex are required", errInvalidExtract)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("%w: duplicate name %s", errInvalidExtract, rule.Name)
+		}
+		seen[rule.Name] = true
+
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("%w: %s: pattern %q: %v", errInvalidExtract, rule.Name, rule.Regex, err)
+		}
+		if rule.Group != nil && (*rule.Group < 0 || *rule.Group > re.NumSubexp()) {
+			return fmt.Errorf("%w: %s: pattern %q has no group %d", errInvalidExtract, rule.Name, rule.Regex, *rule.Group)
+		}
+		rule.re = re
+	}
+	return nil
+}
+
+func (rule *ExtractRule) group() int {
+	if rule.Group != nil {
+		return *rule.Group
+	}
+	if rule.re.NumSubexp() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// applyExtract 对输出应用已编译的规则，未匹配的单值字段为null
+func applyExtract(rules []ExtractRule, output string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		group := rule.group()
+		if rule.AllMatches {
+			values := []string{}
+			for _, m := range rule.re.FindAllStringSubmatch(output, -1) {
+				values = append(values, m[group])
+			}
+			fields[rule.Name] = values
+			continue
+		}
+		if m := rule.re.FindStringSubmatch(output); m != nil {
+			fields[rule.Name] = m[group]
+		} else {
+			fields[rule.Name] = nil
+		}
+	}
+	return fields
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// extractRequest 构造带extract规则的执行请求，规则为JSON数组
+func extractRequest(t *testing.T, id, rules string) CommandRequest {
+	t.Helper()
+	req := CommandRequest{ConnectionID: id, Command: "ip link"}
+	if err := json.Unmarshal([]byte(rules), &req.Extract); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// 无效的正则和分组在执行前被拒绝，错误中带出有问题的表达式
+func TestExecuteRejectsInvalidExtractRules(t *testing.T) {
+	server := startTestSSHServer(t)
+	var executed int64
+	server.exec = func(command string, stdout io.Writer) int {
+		atomic.AddInt64(&executed, 1)
+		return 0
+	}
+	id := connectTestSSH(t, server)
+
+	cases := []struct {
+		name    string
+		rule    string
+		pattern string
+	}{
+		{"bad pattern", `{"name":"mtu","regex":"mtu (\\d+"}`, `mtu (\d+`},
+		{"bad group", `{"name":"mtu","regex":"mtu (\\d+)","group":2}`, `mtu (\d+)`},
+		{"negative group", `{"name":"mtu","regex":"mtu (\\d+)","group":-1}`, `mtu (\d+)`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := collector.Execute(extractRequest(t, id, "["+tc.rule+"]"))
+			if !errors.Is(err, errInvalidExtract) {
+				t.Fatalf("err = %v, want errInvalidExtract", err)
+			}
+			if !strings.Contains(err.Error(), strconv.Quote(tc.pattern)) {
+				t.Fatalf("error %q does not name the pattern %q", err, tc.pattern)
+			}
+		})
+	}
+	if n := atomic.LoadInt64(&executed); n != 0 {
+		t.Fatalf("command ran %d times with invalid extract rules", n)
+	}
+}
+
+// all_matches返回所有匹配组成的数组，无匹配时为空数组；单值规则未匹配时为null
+func TestExecuteExtractAllMatches(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.exec = func(command string, stdout io.Writer) int {
+		fmt.Fprint(stdout, "eth0 mtu 1500\neth1 mtu 9000\nlo mtu 65536\n")
+		return 0
+	}
+	id := connectTestSSH(t, server)
+
+	result, err := collector.Execute(extractRequest(t, id, `[
+		{"name":"mtus","regex":"mtu (\\d+)","all_matches":true},
+		{"name":"ifaces","regex":"(\\w+) mtu","all_matches":true},
+		{"name":"vlans","regex":"vlan (\\d+)","all_matches":true},
+		{"name":"first","regex":"mtu (\\d+)"},
+		{"name":"speed","regex":"speed (\\d+)"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"mtus":   `["1500","9000","65536"]`,
+		"ifaces": `["eth0","eth1","lo"]`,
+		"vlans":  `[]`,
+		"first":  `"1500"`,
+		"speed":  `null`,
+	}
+	for name, expected := range want {
+		got, ok := result.Fields[name]
+		if !ok {
+			t.Errorf("%s: missing from fields %v", name, result.Fields)
+			continue
+		}
+		encoded, _ := json.Marshal(got)
+		if string(encoded) != expected {
+			t.Errorf("%s = %s, want %s", name, encoded, expected)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errParserNotFound = errors.New("parser not found")
+
+// BuiltinParser 内置的命令输出解析器，输出结构随SchemaVersion演进
+type BuiltinParser struct {
+	Name        string
+	Description string
+	Command     string
+	// Platform 对应设备驱动名（device_type），用于parse.auto自动选择
+	Platform string
+	// Schema 多个厂商共用的规范化输出结构名，为空时使用Name
+	Schema        string
+	SchemaVersion int
+	// CommandPattern 匹配可自动选择该解析器的命令
+	CommandPattern *regexp.Regexp
+	Parse          func(text string) (interface{}, error)
+}
+
+func (p *BuiltinParser) schema() string {
+	name := p.Name
+	if p.Schema != "" {
+		name = p.Schema
+	}
+	return fmt.Sprintf("%s/v%d", name, p.SchemaVersion)
+}
+
+// commandPattern 由 "sh:ow ver:sion" 形式的描述生成命令匹配正则，冒号前为最短缩写，
+// 允许结尾的 | no-more
+func commandPattern(spec string) *regexp.Regexp {
+	var words []string
+	for _, word := range strings.Fields(spec) {
+		prefix, rest, _ := strings.Cut(word, ":")
+		tail := ""
+		for i := len(rest) - 1; i >= 0; i-- {
+			tail = "(?:" + regexp.QuoteMeta(rest[i:i+1]) + tail + ")?"
+		}
+		words = append(words, regexp.QuoteMeta(prefix)+tail)
+	}
+	return regexp.MustCompile(`(?i)^\s*` + strings.Join(words, `\s+`) + `(?:\s*\|\s*no-more)?\s*$`)
+}
+
+// ParserRegistry 自定义解析器可覆盖同名内置解析器，删除后恢复内置版本
+type ParserRegistry struct {
+	parsers map[string]*BuiltinParser
+	custom  map[string]*CustomParser
+	mutex   sync.RWMutex
+}
+
+var parsers = newParserRegistry()
+
+// registerParser 在init中注册内置解析器
+func registerParser(p *BuiltinParser) {
+	parsers.mutex.Lock()
+	defer parsers.mutex.Unlock()
+	parsers.parsers[p.Name] = p
+}
+
+func (r *ParserRegistry) Lookup(name string) (*BuiltinParser, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if custom, ok := r.custom[name]; ok {
+		return custom.parser, nil
+	}
+	p, ok := r.parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errParserNotFound, name)
+	}
+	return p, nil
+}
+
+// effective 返回生效的解析器，调用方需持有锁
+func (r *ParserRegistry) effective() []*BuiltinParser {
+	list := make([]*BuiltinParser, 0, len(r.parsers)+len(r.custom))
+	for name, p := range r.parsers {
+		if _, overridden := r.custom[name]; !overridden {
+			list = append(list, p)
+		}
+	}
+	for _, custom := range r.custom {
+		list = append(list, custom.parser)
+	}
+	return list
+}
+
+// Match 按设备类型和命令选择内置解析器
+func (r *ParserRegistry) Match(deviceType, command string) *BuiltinParser {
+	if deviceType == "" {
+		deviceType = "generic"
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var matched *BuiltinParser
+	for _, p := range r.effective() {
+		if p.Platform != deviceType || p.CommandPattern == nil || !p.CommandPattern.MatchString(command) {
+			continue
+		}
+		// 多个匹配时取名称最小者，保证结果稳定
+		if matched == nil || p.Name < matched.Name {
+			matched = p
+		}
+	}
+	return matched
+}
+
+func (r *ParserRegistry) List() []gin.H {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(r.parsers)+len(r.custom))
+	for _, p := range r.effective() {
+		item := gin.H{
+			"name":           p.Name,
+			"description":    p.Description,
+			"command":        p.Command,
+			"platform":       p.Platform,
+			"auto":           p.CommandPattern != nil,
+			"schema_version": p.SchemaVersion,
+			"schema":         p.schema(),
+		}
+		if custom, ok := r.custom[p.Name]; ok {
+			item["custom"] = true
+			item["type"] = custom.Config.Type
+			item["version"] = custom.Config.Version
+			item["updated_at"] = custom.Config.UpdatedAt
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["name"].(string) < list[j]["name"].(string) })
+	return list
+}
+
+var sizeUnits = map[string]float64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+	"P": 1 << 50,
+	"E": 1 << 60,
+}
+
+// parseSize 将 20G / 1.5Gi / 512 等大小转换为字节，无单位时乘以defaultUnit
+func parseSize(s string, defaultUnit int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') && s[i-1] != '.' {
+		i--
+	}
+	number, unit := s[:i], s[i:]
+	if unit != "B" {
+		unit = strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "i"))
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if unit == "" {
+		return int64(value) * defaultUnit, nil
+	}
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit %q", s)
+	}
+	return int64(math.Round(value * mult)), nil
+}
+
+// parsePercent 将 25% 转换为 25.0
+func parsePercent(s string) (float64, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func registerParserRoutes(r *gin.Engine) {
+	r.GET("/parsers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"parsers": parsers.List()})
+	})
+
+	// 用样例输出调试内置解析器
+	r.POST("/parsers/:name/parse", func(c *gin.Context) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		p, err := parsers.Lookup(c.Param("name"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		value, err := p.Parse(body.Text)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"parsed": value, "parse_schema": p.schema()})
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 自定义解析器：TextFSM模板、grok表达式或外部插件，写入时编译校验，编译结果整体替换，
+// 执行中的请求继续使用旧版本
+
+var (
+	errParserVersionConflict = errors.New("parser version conflict")
+	errBuiltinParser         = errors.New("builtin parser cannot be removed")
+)
+
+// 自定义解析器的持久化目录（每个解析器一个JSON文件），为空时仅保存在内存
+var parserDir = getEnv("PARSER_DIR", "")
+
+type CustomParserConfig struct {
+	Name string `json:"name"`
+	// Type: textfsm / grok / exec
+	Type     string `json:"type" binding:"required"`
+	Template string `json:"template,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	// exec类型: PARSER_PLUGIN_DIR下的可执行文件及参数，超时为秒
+	Executable  string   `json:"executable,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	Timeout     int      `json:"timeout,omitempty"`
+	Description string   `json:"description,omitempty"`
+	// Platform / Command 用于parse.auto选择，Command为正则
+	Platform string `json:"platform,omitempty"`
+	Command  string `json:"command,omitempty"`
+	// Version 更新时需与当前版本一致，创建时为0
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CustomParser struct {
+	Config CustomParserConfig
+	parser *BuiltinParser
+	fsm    *TextFSM
+	grok   *GrokPattern
+}
+
+// grokTraceStep grok逐行匹配结果
+type grokTraceStep struct {
+	Line    int    `json:"line"`
+	Input   string `json:"input"`
+	Matched bool   `json:"matched"`
+}
+
+// Trace 返回解析结果和调试跟踪
+func (p *CustomParser) Trace(text string) (interface{}, interface{}, error) {
+	if p.fsm != nil {
+		return p.fsm.Trace(text)
+	}
+	if p.grok == nil {
+		// 外部插件没有跟踪信息
+		value, err := p.parser.Parse(text)
+		return value, nil, err
+	}
+	records := []map[string]interface{}{}
+	steps := []grokTraceStep{}
+	for i, line := range outputLines(text) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, ok := p.grok.Match(line)
+		if ok {
+			records = append(records, record)
+		}
+		if len(steps) < fsmTraceLimit {
+			steps = append(steps, grokTraceStep{Line: i + 1, Input: line, Matched: ok})
+		}
+	}
+	return records, steps, nil
+}
+
+func compileCustomParser(config CustomParserConfig) (*CustomParser, error) {
+	if !templateNamePattern.MatchString(config.Name) {
+		return nil, fmt.Errorf("invalid parser name %q", config.Name)
+	}
+	custom := &CustomParser{Config: config}
+	parser := &BuiltinParser{
+		Name:          config.Name,
+		Description:   config.Description,
+		Platform:      config.Platform,
+		SchemaVersion: config.Version,
+	}
+	if config.Command != "" {
+		re, err := regexp.Compile(config.Command)
+		if err != nil {
+			return nil, fmt.Errorf("command: %v", err)
+		}
+		parser.CommandPattern = re
+		parser.Command = config.Command
+	}
+
+	switch config.Type {
+	case "textfsm":
+		fsm, err := ParseTextFSM(config.Template)
+		if err != nil {
+			return nil, err
+		}
+		custom.fsm = fsm
+		parser.Parse = func(text string) (interface{}, error) { return fsm.Parse(text) }
+	case "grok":
+		// 编译结果与当前模式库绑定，之后修改grok模式需重新保存解析器
+		g, err := grok.Compile(config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		custom.grok = g
+		parser.Parse = func(text string) (interface{}, error) {
+			records, _, err := custom.Trace(text)
+			return records, err
+		}
+	case "exec":
+		if _, err := resolvePlugin(config.Executable); err != nil {
+			return nil, err
+		}
+		timeout := time.Duration(config.Timeout) * time.Second
+		parser.Parse = func(text string) (interface{}, error) {
+			return runParserPlugin(config.Executable, config.Args, timeout, text)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported parser type %q", config.Type)
+	}
+	custom.parser = parser
+	return custom, nil
+}
+
+func newParserRegistry() *ParserRegistry {
+	r := &ParserRegistry{parsers: make(map[string]*BuiltinParser), custom: make(map[string]*CustomParser)}
+	if parserDir == "" {
+		return r
+	}
+	files, _ := filepath.Glob(filepath.Join(parserDir, "*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var config CustomParserConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			logWarn("parser.invalid", "invalid custom parser", "file", file, "error", err)
+			continue
+		}
+		custom, err := compileCustomParser(config)
+		if err != nil {
+			logWarn("parser.invalid", "invalid custom parser", "file", file, "error", err)
+			continue
+		}
+		r.custom[config.Name] = custom
+	}
+	return r
+}
+
+// Put 创建或更新自定义解析器，config.Version为调用方看到的当前版本
+func (r *ParserRegistry) Put(config CustomParserConfig) (*CustomParser, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current := 0
+	if existing, ok := r.custom[config.Name]; ok {
+		current = existing.Config.Version
+	}
+	if config.Version != current {
+		return nil, fmt.Errorf("%w: current version is %d", errParserVersionConflict, current)
+	}
+	config.Version = current + 1
+	config.UpdatedAt = time.Now()
+	custom, err := compileCustomParser(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if parserDir != "" {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(parserDir, 0755); err != nil {
+			return nil, err
+		}
+		// 先写临时文件再改名，避免进程中断留下半个文件
+		file := filepath.Join(parserDir, config.Name+".json")
+		if err := os.WriteFile(file+".tmp", data, 0644); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return nil, err
+		}
+	}
+	r.custom[config.Name] = custom
+	return custom, nil
+}
+
+func (r *ParserRegistry) Remove(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.custom[name]; !ok {
+		if _, builtin := r.parsers[name]; builtin {
+			return errBuiltinParser
+		}
+		return fmt.Errorf("%w: %s", errParserNotFound, name)
+	}
+	if parserDir != "" {
+		if err := os.Remove(filepath.Join(parserDir, name+".json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(r.custom, name)
+	return nil
+}
+
+func (r *ParserRegistry) Custom(name string) (*CustomParser, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	custom, ok := r.custom[name]
+	return custom, ok
+}
+
+func parserErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errParserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBuiltinParser):
+		return http.StatusForbidden
+	case errors.Is(err, errParserVersionConflict):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+func registerParserStoreRoutes(r *gin.Engine) {
+	r.GET("/parsers/:name", func(c *gin.Context) {
+		name := c.Param("name")
+		if custom, ok := parsers.Custom(name); ok {
+			c.JSON(http.StatusOK, custom.Config)
+			return
+		}
+		p, err := parsers.Lookup(name)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"name":        p.Name,
+			"description": p.Description,
+			"command":     p.Command,
+			"platform":    p.Platform,
+			"builtin":     true,
+			"schema":      p.schema(),
+		})
+	})
+
+	r.PUT("/parsers/:name", func(c *gin.Context) {
+		var config CustomParserConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		config.Name = c.Param("name")
+		custom, err := parsers.Put(config)
+		if err != nil {
+			respondError(c, parserErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, custom.Config)
+	})
+
+	r.DELETE("/parsers/:name", func(c *gin.Context) {
+		if err := parsers.Remove(c.Param("name")); err != nil {
+			respondError(c, parserErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "parser removed"})
+	})
+
+	// 对样例文本运行解析器，自定义解析器额外返回逐行跟踪
+	r.POST("/parsers/:name/test", func(c *gin.Context) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		name := c.Param("name")
+		custom, ok := parsers.Custom(name)
+		if !ok {
+			p, err := parsers.Lookup(name)
+			if err != nil {
+				respondError(c, http.StatusNotFound, err)
+				return
+			}
+			value, err := p.Parse(body.Text)
+			if err != nil {
+				respondError(c, http.StatusUnprocessableEntity, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"parsed": value, "parse_schema": p.schema()})
+			return
+		}
+		records, trace, err := custom.Trace(body.Text)
+		if err != nil {
+			respondErrorDetails(c, http.StatusUnprocessableEntity, err, gin.H{"parsed": records, "trace": trace, "version": custom.Config.Version})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"parsed": records, "trace": trace, "version": custom.Config.Version})
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var probeMaxConcurrency = int(envInt64("PROBE_MAX_CONCURRENCY", 64))
+
+type PingRequest struct {
+	Targets []string `json:"targets" binding:"required"`
+	Count   int      `json:"count"`
+	// Interval/Timeout 单位为毫秒
+	Interval    int  `json:"interval"`
+	Timeout     int  `json:"timeout"`
+	Concurrency int  `json:"concurrency"`
+	Publish     bool `json:"publish"`
+}
+
+type PingResult struct {
+	Target   string  `json:"target"`
+	Address  string  `json:"address,omitempty"`
+	Method   string  `json:"method,omitempty"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss_percent"`
+	// RTT 单位为毫秒
+	MinRTT    float64   `json:"min_rtt"`
+	AvgRTT    float64   `json:"avg_rtt"`
+	MaxRTT    float64   `json:"max_rtt"`
+	StdDevRTT float64   `json:"stddev_rtt"`
+	Up        bool      `json:"up"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// listenICMP 优先使用原始套接字，无权限时退回非特权的ICMP数据报套接字
+func listenICMP(v6 bool) (*icmp.PacketConn, string, error) {
+	network, udpNetwork, address := "ip4:icmp", "udp4", "0.0.0.0"
+	if v6 {
+		network, udpNetwork, address = "ip6:ipv6-icmp", "udp6", "::"
+	}
+	if conn, err := icmp.ListenPacket(network, address); err == nil {
+		return conn, "icmp", nil
+	}
+	conn, err := icmp.ListenPacket(udpNetwork, address)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open icmp socket: %v", err)
+	}
+	return conn, "udp", nil
+}
+
+func peerIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func ping(target string, count int, interval, timeout time.Duration) PingResult {
+	result := PingResult{Target: target}
+
+	ipAddr, err := net.ResolveIPAddr("ip", target)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to resolve: %v", err)
+		return result
+	}
+	result.Address = ipAddr.String()
+	if err := checkDestination(ipAddr.IP); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	v6 := ipAddr.IP.To4() == nil
+
+	conn, method, err := listenICMP(v6)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Method = method
+
+	var dst net.Addr = ipAddr
+	if method == "udp" {
+		// 数据报套接字的ID由内核分配，使用UDP地址发送
+		dst = &net.UDPAddr{IP: ipAddr.IP, Zone: ipAddr.Zone}
+	}
+
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	proto := 1
+	if v6 {
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		proto = 58
+	}
+
+	id := rand.Intn(0xffff)
+	var rtts []float64
+	buf := make([]byte, 1500)
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+
+		msg := icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("go-ssh-collector")},
+		}
+		data, err := msg.Marshal(nil)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		sentAt := time.Now()
+		if _, err := conn.WriteTo(data, dst); err != nil {
+			result.Error = fmt.Sprintf("failed to send: %v", err)
+			return result
+		}
+		result.Sent++
+
+		deadline := sentAt.Add(timeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			if !peerIP(peer).Equal(ipAddr.IP) {
+				continue
+			}
+			reply, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || reply.Type != replyType {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			// 原始套接字会收到所有ICMP报文，需校验ID；数据报套接字的ID被内核改写
+			if !ok || echo.Seq != seq || (method == "icmp" && echo.ID != id) {
+				continue
+			}
+			rtts = append(rtts, float64(time.Since(sentAt).Microseconds())/1000)
+			break
+		}
+	}
+
+	result.Received = len(rtts)
+	result.Up = result.Received > 0
+	if result.Sent > 0 {
+		result.Loss = float64(result.Sent-result.Received) / float64(result.Sent) * 100
+	}
+	if len(rtts) > 0 {
+		result.MinRTT, result.MaxRTT = rtts[0], rtts[0]
+		var sum float64
+		for _, rtt := range rtts {
+			sum += rtt
+			result.MinRTT = math.Min(result.MinRTT, rtt)
+			result.MaxRTT = math.Max(result.MaxRTT, rtt)
+		}
+		result.AvgRTT = sum / float64(len(rtts))
+		var variance float64
+		for _, rtt := range rtts {
+			variance += (rtt - result.AvgRTT) * (rtt - result.AvgRTT)
+		}
+		result.StdDevRTT = math.Sqrt(variance / float64(len(rtts)))
+	}
+	return result
+}
+
+// Ping 并发探测多个目标，并发数受上限约束
+func Ping(req PingRequest) []PingResult {
+	count := req.Count
+	if count <= 0 {
+		count = 4
+	}
+	if count > 100 {
+		count = 100
+	}
+	interval := time.Second
+	if req.Interval > 0 {
+		interval = time.Duration(req.Interval) * time.Millisecond
+	}
+	if interval < 200*time.Millisecond {
+		interval = 200 * time.Millisecond
+	}
+	timeout := time.Second
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Millisecond
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 || concurrency > probeMaxConcurrency {
+		concurrency = probeMaxConcurrency
+	}
+
+	results := make([]PingResult, len(req.Targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range req.Targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = ping(target, count, interval, timeout)
+			results[i].Timestamp = time.Now()
+			if req.Publish {
+				sinks.Publish("probe", results[i])
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+func registerProbeRoutes(r *gin.Engine) {
+	// ICMP可达性探测
+	r.POST("/probe/ping", func(c *gin.Context) {
+		var req PingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Targets) == 0 {
+			respondError(c, http.StatusBadRequest, errors.New("targets is required"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results":   Ping(req),
+			"timestamp": time.Now(),
+		})
+	})
+
+	// TCP/UDP端口探测
+	r.POST("/probe/port", func(c *gin.Context) {
+		var req PortCheckRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		results, summary, err := CheckPorts(req)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results":   results,
+			"summary":   summary,
+			"timestamp": time.Now(),
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 外部解析器插件：提交原始输出到stdin，从stdout读取JSON。
+// 可执行文件必须位于白名单目录内，运行时不继承采集器的环境变量
+
+var errPluginsDisabled = errors.New("external parsers are disabled (PARSER_PLUGIN_DIR not set)")
+
+var (
+	parserPluginDir     = getEnv("PARSER_PLUGIN_DIR", "")
+	parserPluginWorkDir = getEnv("PARSER_PLUGIN_WORKDIR", "")
+	parserPluginTimeout = time.Duration(envInt64("PARSER_PLUGIN_TIMEOUT", 10)) * time.Second
+	// stdout超过该大小视为失败
+	parserPluginMaxOutput = envInt64("PARSER_PLUGIN_MAX_OUTPUT", 16<<20)
+	// 大于0时通过prlimit限制插件进程的地址空间（字节）
+	parserPluginMaxMemory = envInt64("PARSER_PLUGIN_MAX_MEMORY", 0)
+)
+
+// 错误信息中附带的stderr长度上限
+const pluginStderrLimit = 4096
+
+// limitedBuffer 超过上限后丢弃后续内容并记录溢出
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(b.buf.Len()); int64(len(p)) > room {
+		b.overflow = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// resolvePlugin 返回白名单目录内的真实路径，拒绝通过 .. 或符号链接跳出目录
+func resolvePlugin(executable string) (string, error) {
+	if parserPluginDir == "" {
+		return "", errPluginsDisabled
+	}
+	if executable == "" || filepath.IsAbs(executable) {
+		return "", fmt.Errorf("executable must be a path relative to the plugin directory")
+	}
+	dir, err := filepath.EvalSymlinks(parserPluginDir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, executable))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("executable %s is outside the plugin directory", executable)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("%s is not executable", executable)
+	}
+	return path, nil
+}
+
+func runParserPlugin(executable string, args []string, timeout time.Duration, text string) (interface{}, error) {
+	path, err := resolvePlugin(executable)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = parserPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	name, argv := path, args
+	if parserPluginMaxMemory > 0 {
+		name, argv = "prlimit", append([]string{fmt.Sprintf("--as=%d", parserPluginMaxMemory), "--", path}, args...)
+	}
+	cmd := exec.Command(name, argv...)
+	setPluginProcessGroup(cmd)
+	cmd.Dir = parserPluginWorkDir
+	if cmd.Dir == "" {
+		cmd.Dir = os.TempDir()
+	}
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "LANG=C.UTF-8", "HOME=" + cmd.Dir}
+	cmd.Stdin = strings.NewReader(text)
+	stdout := &limitedBuffer{limit: parserPluginMaxOutput}
+	stderr := &limitedBuffer{limit: pluginStderrLimit}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killPlugin(cmd)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	detail := func(msg string) error {
+		if s := strings.TrimSpace(stderr.buf.String()); s != "" {
+			return fmt.Errorf("%s; stderr: %s", msg, s)
+		}
+		return errors.New(msg)
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, detail(fmt.Sprintf("plugin timed out after %s", timeout))
+	case err != nil:
+		return nil, detail(fmt.Sprintf("plugin failed: %v", err))
+	case stdout.overflow:
+		return nil, detail(fmt.Sprintf("plugin output exceeds %d bytes", parserPluginMaxOutput))
+	}
+	value, err := parseJSONOutput(stdout.buf.String(), false)
+	if err != nil {
+		if err == io.EOF {
+			return nil, detail("plugin produced no output")
+		}
+		return nil, detail(fmt.Sprintf("plugin output is not valid JSON: %v", err))
+	}
+	return value, nil
+}
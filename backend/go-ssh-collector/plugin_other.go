@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+func setPluginProcessGroup(cmd *exec.Cmd) {}
+
+func killPlugin(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
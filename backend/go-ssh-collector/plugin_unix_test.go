@@ -0,0 +1,156 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usePluginDir 测试期间的插件白名单目录，返回该目录及其上级目录
+func usePluginDir(t *testing.T) (dir, parent string) {
+	t.Helper()
+	parent = t.TempDir()
+	dir = filepath.Join(parent, "plugins")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := parserPluginDir
+	parserPluginDir = dir
+	t.Cleanup(func() { parserPluginDir = previous })
+	return dir, parent
+}
+
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolvePluginStaysInDirectory(t *testing.T) {
+	dir, parent := usePluginDir(t)
+	writePlugin(t, dir, "ok", "cat", 0755)
+	outside := writePlugin(t, parent, "outside", "cat", 0755)
+	writePlugin(t, dir, "readonly", "cat", 0644)
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "ok"), filepath.Join(dir, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		executable string
+		ok         bool
+	}{
+		{"ok", true},
+		{"alias", true},
+		{"./subdir/../ok", true},
+		{"../outside", false},
+		{"subdir/../../outside", false},
+		{"escape", false},
+		{outside, false},
+		{filepath.Join(dir, "ok"), false},
+		{"readonly", false},
+		{"subdir", false},
+		{"missing", false},
+		{"", false},
+	} {
+		path, err := resolvePlugin(tc.executable)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: path %q, err %v", tc.executable, path, err)
+		}
+		if err == nil && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			t.Errorf("%q resolved outside the plugin directory: %s", tc.executable, path)
+		}
+	}
+
+	parserPluginDir = ""
+	if _, err := resolvePlugin("ok"); !errors.Is(err, errPluginsDisabled) {
+		t.Errorf("disabled: err = %v", err)
+	}
+}
+
+func TestRunParserPlugin(t *testing.T) {
+	dir, _ := usePluginDir(t)
+	writePlugin(t, dir, "echo", "cat", 0755)
+	value, err := runParserPlugin("echo", nil, time.Second, `{"interfaces":["ge-0/0/0"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := value.(map[string]interface{}); !ok || len(m["interfaces"].([]interface{})) != 1 {
+		t.Fatalf("value = %v", value)
+	}
+}
+
+// 不继承采集器的环境变量，只有固定的PATH、LANG和HOME
+func TestParserPluginEnvironment(t *testing.T) {
+	dir, _ := usePluginDir(t)
+	t.Setenv("COLLECTOR_TEST_SECRET", "hunter2")
+	writePlugin(t, dir, "env", `printf '{"secret":"%s","path":"%s","count":%d}' "$COLLECTOR_TEST_SECRET" "$PATH" "$(env | grep -cv '^PWD=\|^SHLVL=\|^_=')"`, 0755)
+	value, err := runParserPlugin("env", nil, time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := value.(map[string]interface{})
+	if env["secret"] != "" || env["path"] != "/usr/local/bin:/usr/bin:/bin" || fmt.Sprint(env["count"]) != "3" {
+		t.Fatalf("plugin environment = %v", env)
+	}
+}
+
+// 超时时结束整个进程组：后台子进程仍持有stdout时，只结束插件本身会让Wait等到子进程退出
+func TestParserPluginTimeoutKillsProcessGroup(t *testing.T) {
+	dir, _ := usePluginDir(t)
+	writePlugin(t, dir, "hang", "sleep 30 &\nsleep 30", 0755)
+	start := time.Now()
+	_, err := runParserPlugin("hang", nil, 200*time.Millisecond, "")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("plugin returned after %s, child process survived the timeout", elapsed)
+	}
+}
+
+func TestParserPluginOutputErrors(t *testing.T) {
+	dir, _ := usePluginDir(t)
+	previous := parserPluginMaxOutput
+	parserPluginMaxOutput = 1024
+	t.Cleanup(func() { parserPluginMaxOutput = previous })
+	writePlugin(t, dir, "flood", `head -c 4096 /dev/zero | tr '\0' 'a'`, 0755)
+	writePlugin(t, dir, "garbage", "echo 'not json'\necho 'parse failed at line 3' >&2", 0755)
+	writePlugin(t, dir, "silent", "exit 0", 0755)
+	writePlugin(t, dir, "crash", "echo 'template missing' >&2\nexit 3", 0755)
+
+	for _, tc := range []struct {
+		executable string
+		want       []string
+	}{
+		{"flood", []string{"exceeds 1024 bytes"}},
+		{"garbage", []string{"not valid JSON", "stderr: parse failed at line 3"}},
+		{"silent", []string{"no output"}},
+		{"crash", []string{"exit status 3", "stderr: template missing"}},
+	} {
+		_, err := runParserPlugin(tc.executable, nil, 5*time.Second, "input")
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.executable)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: err %q missing %q", tc.executable, err, want)
+			}
+		}
+	}
+}
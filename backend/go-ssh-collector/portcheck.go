@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	portCheckMaxChecks      = 10000
+	portCheckMaxBannerBytes = 4096
+)
+
+type PortCheckRequest struct {
+	Targets []string `json:"targets" binding:"required"`
+	Ports   []int    `json:"ports" binding:"required"`
+	// Protocol: tcp(默认) / udp
+	Protocol string `json:"protocol"`
+	// Timeout 单位为毫秒
+	Timeout     int  `json:"timeout"`
+	TLS         bool `json:"tls"`
+	BannerBytes int  `json:"banner_bytes"`
+	Concurrency int  `json:"concurrency"`
+	Publish     bool `json:"publish"`
+}
+
+type CertificateInfo struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dns_names,omitempty"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Verified      bool      `json:"verified"`
+	VerifyError   string    `json:"verify_error,omitempty"`
+}
+
+type PortCheckResult struct {
+	Target   string `json:"target"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Status: open / closed / filtered / open|filtered / denied / error
+	Status      string           `json:"status"`
+	Latency     float64          `json:"latency_ms"`
+	TLSVersion  string           `json:"tls_version,omitempty"`
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+	TLSError    string           `json:"tls_error,omitempty"`
+	Banner      string           `json:"banner,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Timestamp   time.Time        `json:"timestamp"`
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+	tls.VersionTLS13: "TLS1.3",
+}
+
+// dialStatus 将连接错误映射为端口状态
+func dialStatus(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "filtered"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "filtered"
+	}
+	var destErr *destinationError
+	if errors.As(err, &destErr) {
+		return "denied"
+	}
+	return "error"
+}
+
+func certificateInfo(state tls.ConnectionState, serverName string) *CertificateInfo {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	info := &CertificateInfo{
+		Subject:       leaf.Subject.String(),
+		Issuer:        leaf.Issuer.String(),
+		DNSNames:      leaf.DNSNames,
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		DaysRemaining: int(time.Until(leaf.NotAfter).Hours() / 24),
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+	info.Verified = err == nil
+	if err != nil {
+		info.VerifyError = err.Error()
+	}
+	return info
+}
+
+func readBanner(conn net.Conn, n int, timeout time.Duration) string {
+	if n <= 0 {
+		return ""
+	}
+	if timeout > 2*time.Second {
+		timeout = 2 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, n)
+	read, _ := conn.Read(buf)
+	return string(buf[:read])
+}
+
+func checkTCPPort(target string, port int, req PortCheckRequest, timeout time.Duration) PortCheckResult {
+	result := PortCheckResult{Target: target, Port: port, Protocol: "tcp"}
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := safeDialer(timeout).DialContext(ctx, "tcp", address)
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Status = dialStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Status = "open"
+
+	if req.TLS {
+		serverName := target
+		if net.ParseIP(target) != nil {
+			serverName = ""
+		}
+		// 只采集证书信息，校验结果单独报告
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			result.TLSError = err.Error()
+			return result
+		}
+		state := tlsConn.ConnectionState()
+		result.TLSVersion = tlsVersions[state.Version]
+		result.Certificate = certificateInfo(state, serverName)
+		result.Banner = readBanner(tlsConn, req.BannerBytes, timeout)
+		return result
+	}
+
+	result.Banner = readBanner(conn, req.BannerBytes, timeout)
+	return result
+}
+
+// checkUDPPort 发送空数据报：收到响应为open，ICMP端口不可达为closed，超时无法区分
+func checkUDPPort(target string, port int, req PortCheckRequest, timeout time.Duration) PortCheckResult {
+	result := PortCheckResult{Target: target, Port: port, Protocol: "udp"}
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	start := time.Now()
+	conn, err := safeDialer(timeout).Dial("udp", address)
+	if err != nil {
+		result.Status = dialStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte{}); err != nil {
+		result.Status = dialStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	size := req.BannerBytes
+	if size <= 0 {
+		size = 1
+	}
+	buf := make([]byte, size)
+	n, err := conn.Read(buf)
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Status = dialStatus(err)
+		if result.Status == "filtered" {
+			result.Status = "open|filtered"
+		}
+		return result
+	}
+	result.Status = "open"
+	if req.BannerBytes > 0 {
+		result.Banner = string(buf[:n])
+	}
+	return result
+}
+
+type PortCheckSummary struct {
+	Total  int            `json:"total"`
+	Status map[string]int `json:"status"`
+}
+
+// CheckPorts 对目标与端口的笛卡尔积并发探测
+func CheckPorts(req PortCheckRequest) ([]PortCheckResult, PortCheckSummary, error) {
+	if req.Protocol == "" {
+		req.Protocol = "tcp"
+	}
+	if req.Protocol != "tcp" && req.Protocol != "udp" {
+		return nil, PortCheckSummary{}, errors.New("protocol must be tcp or udp")
+	}
+	if len(req.Targets)*len(req.Ports) > portCheckMaxChecks {
+		return nil, PortCheckSummary{}, errors.New("too many target/port combinations")
+	}
+	for _, port := range req.Ports {
+		if port < 1 || port > 65535 {
+			return nil, PortCheckSummary{}, errors.New("invalid port: " + strconv.Itoa(port))
+		}
+	}
+	if req.BannerBytes > portCheckMaxBannerBytes {
+		req.BannerBytes = portCheckMaxBannerBytes
+	}
+	timeout := 3 * time.Second
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Millisecond
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 || concurrency > probeMaxConcurrency {
+		concurrency = probeMaxConcurrency
+	}
+
+	results := make([]PortCheckResult, 0, len(req.Targets)*len(req.Ports))
+	for _, target := range req.Targets {
+		for _, port := range req.Ports {
+			results = append(results, PortCheckResult{Target: target, Port: port})
+		}
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			target, port := results[i].Target, results[i].Port
+			if req.Protocol == "udp" {
+				results[i] = checkUDPPort(target, port, req, timeout)
+			} else {
+				results[i] = checkTCPPort(target, port, req, timeout)
+			}
+			results[i].Timestamp = time.Now()
+			if req.Publish {
+				sinks.Publish("probe", results[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	summary := PortCheckSummary{Total: len(results), Status: map[string]int{}}
+	for _, result := range results {
+		summary.Status[result.Status]++
+	}
+	return results, summary, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求限流：令牌桶，依次检查全局、客户端IP、路由级和API Key级限制，
+// 任一耗尽即返回429，此时不消耗其它级别的令牌。认证之前只按客户端IP计数，
+// API Key级只用认证通过的身份，未校验的凭据不会产生新的桶。限制可通过 PUT /admin/ratelimits 在运行时调整
+
+// RateLimit Rate为每秒持续请求数，Burst为允许的突发量，Rate为0表示不限制
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig Routes的键为 "METHOD /path"（gin路由模板），按客户端IP分别计数
+type RateLimitConfig struct {
+	Global RateLimit            `json:"global"`
+	PerIP  RateLimit            `json:"per_ip"`
+	PerKey RateLimit            `json:"per_key"`
+	Routes map[string]RateLimit `json:"routes"`
+}
+
+var errInvalidRateLimit = errors.New("rate and burst must not be negative")
+
+// 空闲超过该时间的桶被回收
+const rateBucketIdle = 10 * time.Minute
+
+// parseRateLimit 解析 "rate:burst"，省略burst时等于rate向上取整
+func parseRateLimit(spec string) RateLimit {
+	rate, burst, _ := strings.Cut(spec, ":")
+	var limit RateLimit
+	limit.Rate, _ = strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	limit.Burst, _ = strconv.Atoi(strings.TrimSpace(burst))
+	return limit
+}
+
+// parseRouteLimits 解析 "POST /connect=1:5;POST /execute=20:40"
+func parseRouteLimits(spec string) map[string]RateLimit {
+	routes := make(map[string]RateLimit)
+	for _, item := range strings.Split(spec, ";") {
+		if route, limit, ok := strings.Cut(item, "="); ok {
+			routes[strings.TrimSpace(route)] = parseRateLimit(limit)
+		}
+	}
+	return routes
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+func (l RateLimit) validate() error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return errInvalidRateLimit
+	}
+	return nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间补充令牌
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+}
+
+// wait 令牌不足一个时返回需要等待的时间，否则返回0
+func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// take 取一个令牌，失败时返回需要等待的时间
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	b.refill(limit, now)
+	if wait := b.wait(limit); wait > 0 {
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+type RateLimiter struct {
+	config    RateLimitConfig
+	buckets   map[string]*tokenBucket
+	throttled map[string]uint64 // scope -> 被限流的请求数
+	swept     time.Time
+	mutex     sync.Mutex
+}
+
+var rateLimiter = &RateLimiter{
+	config: RateLimitConfig{
+		Global: parseRateLimit(getEnv("RATE_LIMIT_GLOBAL", "")),
+		PerIP:  parseRateLimit(getEnv("RATE_LIMIT_PER_IP", "")),
+		PerKey: parseRateLimit(getEnv("RATE_LIMIT_PER_KEY", "")),
+		// 建连和批量执行会消耗目标设备的会话，默认更严格
+		Routes: parseRouteLimits(getEnv("RATE_LIMIT_ROUTES", "POST /connect=2:5;POST /execute/bulk=1:5")),
+	},
+	buckets:   make(map[string]*tokenBucket),
+	throttled: make(map[string]uint64),
+}
+
+func (rl *RateLimiter) Config() RateLimitConfig {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	config := rl.config
+	config.Routes = make(map[string]RateLimit, len(rl.config.Routes))
+	for route, limit := range rl.config.Routes {
+		config.Routes[route] = limit
+	}
+	return config
+}
+
+// SetConfig 替换限制并清空已有的桶
+func (rl *RateLimiter) SetConfig(config RateLimitConfig) error {
+	limits := []RateLimit{config.Global, config.PerIP, config.PerKey}
+	for _, limit := range config.Routes {
+		limits = append(limits, limit)
+	}
+	for _, limit := range limits {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+	}
+	if config.Routes == nil {
+		config.Routes = make(map[string]RateLimit)
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.config = config
+	rl.buckets = make(map[string]*tokenBucket)
+	return nil
+}
+
+// rateCheck 一级限制对应的桶
+type rateCheck struct {
+	scope  string
+	bucket string
+	limit  RateLimit
+}
+
+// rateReservation 认证前各级已取走令牌的桶，认证后的API Key级拒绝请求时归还
+type rateReservation []rateCheck
+
+// clientChecks 全局、IP和路由级限制，路由级按客户端IP计数，不依赖尚未校验的凭据
+func (rl *RateLimiter) clientChecks(route, ip string) []rateCheck {
+	return []rateCheck{
+		{"global", "global", rl.config.Global},
+		{"ip", "ip:" + ip, rl.config.PerIP},
+		{"route", "route:" + route + "|ip:" + ip, rl.config.Routes[route]},
+	}
+}
+
+// keyChecks key为已认证调用方的身份（见rateLimitIdentity），为空时不检查
+func (rl *RateLimiter) keyChecks(key string) []rateCheck {
+	if key == "" {
+		return nil
+	}
+	return []rateCheck{{"key", "key:" + key, rl.config.PerKey}}
+}
+
+// take 按顺序检查各级限制，返回被限流的级别和建议的重试时间。
+// 全部放行后才从各桶取令牌，被某一级拒绝的请求不占用其它级别的额度。调用方需持有锁
+func (rl *RateLimiter) take(checks []rateCheck, now time.Time) (string, time.Duration, rateReservation) {
+	var taking []*tokenBucket
+	var taken rateReservation
+	for _, check := range checks {
+		if !check.limit.enabled() {
+			continue
+		}
+		b, ok := rl.buckets[check.bucket]
+		if !ok {
+			b = &tokenBucket{tokens: check.limit.burst(), last: now}
+			rl.buckets[check.bucket] = b
+		}
+		b.refill(check.limit, now)
+		if wait := b.wait(check.limit); wait > 0 {
+			rl.throttled[check.scope]++
+			return check.scope, wait, nil
+		}
+		taking = append(taking, b)
+		taken = append(taken, check)
+	}
+	for _, b := range taking {
+		b.tokens--
+	}
+	rl.sweep(now)
+	return "", 0, taken
+}
+
+// Allow 已完成认证时一次检查全部级别，key为空时跳过API Key级
+func (rl *RateLimiter) Allow(route, ip, key string) (string, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	scope, wait, _ := rl.take(append(rl.clientChecks(route, ip), rl.keyChecks(key)...), time.Now())
+	return scope, wait
+}
+
+// Reserve 认证之前检查全局、IP和路由级限制，无效凭据同样消耗令牌；
+// 认证通过后由AllowKey检查API Key级
+func (rl *RateLimiter) Reserve(route, ip string) (rateReservation, string, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	scope, wait, reserved := rl.take(rl.clientChecks(route, ip), time.Now())
+	return reserved, scope, wait
+}
+
+// AllowKey 检查已认证身份的限制，拒绝时归还Reserve取走的令牌
+func (rl *RateLimiter) AllowKey(reserved rateReservation, key string) (string, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	scope, wait, _ := rl.take(rl.keyChecks(key), time.Now())
+	if scope == "" {
+		return "", 0
+	}
+	for _, check := range reserved {
+		if b, ok := rl.buckets[check.bucket]; ok {
+			b.tokens = math.Min(check.limit.burst(), b.tokens+1)
+		}
+	}
+	return scope, wait
+}
+
+// sweep 每分钟回收空闲的桶，调用方需持有锁
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.swept) < time.Minute {
+		return
+	}
+	rl.swept = now
+	recordSweep("ratelimit_buckets")
+	for name, b := range rl.buckets {
+		if now.Sub(b.last) > rateBucketIdle {
+			delete(rl.buckets, name)
+		}
+	}
+}
+
+func (rl *RateLimiter) WriteMetrics(w io.Writer) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	scopes := make([]string, 0, len(rl.throttled))
+	for scope := range rl.throttled {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	out := metricWriter{w}
+	out.family("collector_rate_limited_requests_total", "counter", "Requests rejected by the rate limiter.")
+	for _, scope := range scopes {
+		out.sample("collector_rate_limited_requests_total", float64(rl.throttled[scope]), "scope", scope)
+	}
+}
+
+// rateLimitIdentity API Key级限流使用的身份，只取自认证通过的调用方；未启用认证时没有调用方
+func rateLimitIdentity(p *Principal) string {
+	switch {
+	case p == nil:
+		return ""
+	case p.KeyID != "":
+		return p.Method + ":" + p.KeyID
+	}
+	return p.Identity()
+}
+
+const rateReservationKey = "ratelimit.reservation"
+
+func respondRateLimited(c *gin.Context, scope string, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondErrorDetails(c, http.StatusTooManyRequests, errors.New("rate limit exceeded"),
+		gin.H{"scope": scope, "retry_after": math.Ceil(wait.Seconds())})
+}
+
+// rateLimitMiddleware 在认证之前注册，按客户端IP计数，无效凭据同样计入限流
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authExemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		reserved, scope, wait := rateLimiter.Reserve(c.Request.Method+" "+c.FullPath(), c.ClientIP())
+		if scope != "" {
+			respondRateLimited(c, scope, wait)
+			return
+		}
+		c.Set(rateReservationKey, reserved)
+		c.Next()
+	}
+}
+
+// keyRateLimitMiddleware 在认证之后注册，按认证通过的调用方执行API Key级限流
+func keyRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := rateLimitIdentity(currentPrincipal(c))
+		if key == "" {
+			c.Next()
+			return
+		}
+		reserved, _ := c.Get(rateReservationKey)
+		reservation, _ := reserved.(rateReservation)
+		if scope, wait := rateLimiter.AllowKey(reservation, key); scope != "" {
+			respondRateLimited(c, scope, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+func registerRateLimitRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/ratelimits", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, rateLimiter.Config())
+	})
+
+	admin.PUT("", func(c *gin.Context) {
+		var config RateLimitConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := rateLimiter.SetConfig(config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, rateLimiter.Config())
+	})
+}
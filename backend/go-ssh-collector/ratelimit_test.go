@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRateLimiterRejectedRequestTakesNoTokens(t *testing.T) {
+	rl := newTestRateLimiter(t, RateLimitConfig{
+		Global: RateLimit{Rate: 0.001, Burst: 3},
+		Routes: map[string]RateLimit{"POST /connect": {Rate: 0.001, Burst: 1}},
+	})
+
+	if scope, _ := rl.Allow("POST /connect", "10.0.0.1", ""); scope != "" {
+		t.Fatalf("first connect throttled by %s", scope)
+	}
+	// 路由级拒绝的请求不应消耗全局令牌
+	for i := 0; i < 5; i++ {
+		if scope, wait := rl.Allow("POST /connect", "10.0.0.1", ""); scope != "route" || wait <= 0 {
+			t.Fatalf("connect %d: scope %q wait %v, want route", i, scope, wait)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if scope, _ := rl.Allow("GET /connections", "10.0.0.1", ""); scope != "" {
+			t.Fatalf("request %d throttled by %s after route rejections", i, scope)
+		}
+	}
+	if scope, _ := rl.Allow("GET /connections", "10.0.0.1", ""); scope != "global" {
+		t.Fatalf("scope = %q, want global once the burst is used", scope)
+	}
+	if rl.throttled["route"] != 5 || rl.throttled["global"] != 1 {
+		t.Fatalf("throttled = %v", rl.throttled)
+	}
+}
+
+func newTestRateLimiter(t *testing.T, config RateLimitConfig) *RateLimiter {
+	t.Helper()
+	rl := &RateLimiter{buckets: make(map[string]*tokenBucket), throttled: make(map[string]uint64)}
+	if err := rl.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	return rl
+}
+
+// useRateLimits 替换全局限流配置，结束时恢复
+func useRateLimits(t *testing.T, config RateLimitConfig) {
+	t.Helper()
+	previous := rateLimiter.Config()
+	t.Cleanup(func() { rateLimiter.SetConfig(previous) })
+	if err := rateLimiter.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 路由级限制按客户端IP计数，换用不同的key不会得到新的额度
+func TestRateLimiterRouteLimitIsPerIP(t *testing.T) {
+	rl := newTestRateLimiter(t, RateLimitConfig{Routes: map[string]RateLimit{"POST /connect": {Rate: 0.001, Burst: 2}}})
+	for i := 0; i < 2; i++ {
+		if reserved, scope, _ := rl.Reserve("POST /connect", "10.0.0.1"); scope != "" || len(reserved) != 1 {
+			t.Fatalf("connect %d: scope %q reserved %v", i, scope, reserved)
+		}
+	}
+	if _, scope, _ := rl.Reserve("POST /connect", "10.0.0.1"); scope != "route" {
+		t.Fatalf("scope = %q, want route", scope)
+	}
+	if scope, _ := rl.Allow("POST /connect", "10.0.0.1", "api_key:other"); scope != "route" {
+		t.Fatalf("another key from the same IP: scope %q, want route", scope)
+	}
+	if _, scope, _ := rl.Reserve("POST /connect", "10.0.0.2"); scope != "" {
+		t.Fatalf("another IP throttled by %s", scope)
+	}
+	if _, scope, _ := rl.Reserve("GET /connections", "10.0.0.1"); scope != "" {
+		t.Fatalf("unlimited route throttled by %s", scope)
+	}
+}
+
+func TestRateLimiterPerIPLimit(t *testing.T) {
+	rl := newTestRateLimiter(t, RateLimitConfig{PerIP: RateLimit{Rate: 0.001, Burst: 3}})
+	routes := []string{"GET /connections", "POST /execute", "GET /jobs"}
+	for _, route := range routes {
+		if _, scope, _ := rl.Reserve(route, "10.0.0.1"); scope != "" {
+			t.Fatalf("%s throttled by %s", route, scope)
+		}
+	}
+	if _, scope, wait := rl.Reserve("GET /jobs", "10.0.0.1"); scope != "ip" || wait <= 0 {
+		t.Fatalf("scope %q wait %v, want ip", scope, wait)
+	}
+	if _, scope, _ := rl.Reserve("GET /jobs", "10.0.0.2"); scope != "" {
+		t.Fatalf("another IP throttled by %s", scope)
+	}
+}
+
+// API Key级拒绝时归还认证前取走的令牌
+func TestRateLimiterKeyRejectionRefundsReservation(t *testing.T) {
+	rl := newTestRateLimiter(t, RateLimitConfig{
+		PerIP:  RateLimit{Rate: 0.001, Burst: 2},
+		PerKey: RateLimit{Rate: 0.001, Burst: 1},
+	})
+	reserved, _, _ := rl.Reserve("GET /jobs", "10.0.0.1")
+	if scope, _ := rl.AllowKey(reserved, "api_key:a"); scope != "" {
+		t.Fatalf("first request throttled by %s", scope)
+	}
+	for i := 0; i < 3; i++ {
+		reserved, scope, _ := rl.Reserve("GET /jobs", "10.0.0.1")
+		if scope != "" {
+			t.Fatalf("request %d: key rejections used IP tokens (scope %s)", i, scope)
+		}
+		if scope, _ := rl.AllowKey(reserved, "api_key:a"); scope != "key" {
+			t.Fatalf("request %d: scope %q, want key", i, scope)
+		}
+	}
+	reserved, _, _ = rl.Reserve("GET /jobs", "10.0.0.1")
+	if scope, _ := rl.AllowKey(reserved, "api_key:b"); scope != "" {
+		t.Fatalf("second key throttled by %s", scope)
+	}
+	if _, scope, _ := rl.Reserve("GET /jobs", "10.0.0.1"); scope != "ip" {
+		t.Fatalf("scope = %q, want ip after two accepted requests", scope)
+	}
+}
+
+// 每次请求携带随机凭据不能绕过路由级限制：未启用认证时凭据被忽略，启用后无效凭据同样计数
+func TestRateLimitIgnoresUnverifiedCredentials(t *testing.T) {
+	useRateLimits(t, RateLimitConfig{
+		PerKey: RateLimit{Rate: 0.001, Burst: 1},
+		Routes: map[string]RateLimit{"GET /connections": {Rate: 0.001, Burst: 2}},
+	})
+	r := newRouter()
+	random := func() string { return "mpc_" + newID() }
+
+	if authEnabled() {
+		t.Fatal("auth enabled before any key was created")
+	}
+	for i := 0; i < 2; i++ {
+		if w := apiRequest(r, random(), http.MethodGet, "/connections", ""); w.Code != http.StatusOK {
+			t.Fatalf("auth disabled, request %d: status %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+	w := apiRequest(r, random(), http.MethodGet, "/connections", "")
+	if w.Code != http.StatusTooManyRequests || decodeAPIError(t, w).Details["scope"] != "route" {
+		t.Fatalf("auth disabled, random key: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// 重新设置配置会清空已有的桶
+	rateLimiter.SetConfig(rateLimiter.Config())
+	useAPIKey(t, "someone", roleViewer)
+	for i := 0; i < 2; i++ {
+		if w := apiRequest(r, random(), http.MethodGet, "/connections", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("auth enabled, invalid key %d: status %d", i, w.Code)
+		}
+	}
+	w = apiRequest(r, random(), http.MethodGet, "/connections", "")
+	if w.Code != http.StatusTooManyRequests || decodeAPIError(t, w).Details["scope"] != "route" {
+		t.Fatalf("auth enabled, invalid key flood: status %d: %s", w.Code, w.Body.String())
+	}
+	rateLimiter.mutex.Lock()
+	defer rateLimiter.mutex.Unlock()
+	for name := range rateLimiter.buckets {
+		if strings.HasPrefix(name, "key:") {
+			t.Fatalf("unverified credential created bucket %s", name)
+		}
+	}
+}
+
+// API Key级限制只计认证通过的调用方
+func TestRateLimitPerVerifiedKey(t *testing.T) {
+	useRateLimits(t, RateLimitConfig{PerKey: RateLimit{Rate: 0.001, Burst: 1}})
+	r := newRouter()
+	alice, bob := useAPIKey(t, "alice", roleViewer), useAPIKey(t, "bob", roleViewer)
+
+	if w := apiRequest(r, alice, http.MethodGet, "/connections", ""); w.Code != http.StatusOK {
+		t.Fatalf("alice: status %d", w.Code)
+	}
+	w := apiRequest(r, alice, http.MethodGet, "/connections", "")
+	if w.Code != http.StatusTooManyRequests || decodeAPIError(t, w).Details["scope"] != "key" {
+		t.Fatalf("alice again: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(r, bob, http.MethodGet, "/connections", ""); w.Code != http.StatusOK {
+		t.Fatalf("bob: status %d", w.Code)
+	}
+	if w := apiRequest(r, "mpc_invalid", http.MethodGet, "/connections", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key: status %d, want 401", w.Code)
+	}
+}
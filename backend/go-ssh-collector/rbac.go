@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 基于角色的访问控制：每个路由归入一个权限（见routePermission），
+// 角色到权限的映射见rolePermissions。未启用认证时不做检查
+
+var (
+	errNotConnectionOwner = errors.New("only the connection owner can change sharing")
+	errConnectionOwned    = errors.New("connection belongs to another principal")
+	errViaNotShared       = errors.New("via_connection_id not shared with caller")
+)
+
+const (
+	permRead      = "read"
+	permExecute   = "execute"
+	permConfigure = "configure"
+	permAdmin     = "admin"
+)
+
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+var rolePermissions = map[string][]string{
+	roleViewer:   {permRead},
+	roleOperator: {permRead, permExecute, permConfigure},
+	roleAdmin:    {permRead, permExecute, permConfigure, permAdmin},
+}
+
+// 角色由低到高，多个角色时取最高
+var roleOrder = []string{roleViewer, roleOperator, roleAdmin}
+
+var (
+	// 未指定角色的API Key沿用原来的完整访问（管理接口除外）
+	rbacDefaultKeyRole = getEnv("RBAC_DEFAULT_KEY_ROLE", roleOperator)
+	// JWT中没有可识别角色时的默认角色，为空表示拒绝
+	rbacDefaultTokenRole = getEnv("RBAC_DEFAULT_TOKEN_ROLE", roleViewer)
+	// JWT角色声明到内置角色的映射，如 "netops=operator,noc=viewer"
+	rbacRoleMap = parseRoleMap(getEnv("RBAC_ROLE_MAP", ""))
+	// 启用后连接只对创建者、共享对象和管理员可见
+	connectionACLEnabled = getEnv("RBAC_CONNECTION_ACL", "false") == "true"
+)
+
+func parseRoleMap(spec string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		if claim, role, ok := strings.Cut(strings.TrimSpace(item), "="); ok {
+			m[strings.TrimSpace(claim)] = strings.TrimSpace(role)
+		}
+	}
+	return m
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// resolveRole 把JWT的角色声明映射为最高的内置角色
+func resolveRole(claims []string) string {
+	best := -1
+	for _, claim := range claims {
+		role := claim
+		if mapped, ok := rbacRoleMap[claim]; ok {
+			role = mapped
+		} else if claim == oidcAdminRole {
+			role = roleAdmin
+		}
+		for i, name := range roleOrder {
+			if name == role && i > best {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return rbacDefaultTokenRole
+	}
+	return roleOrder[best]
+}
+
+// Can 判断调用方的角色是否拥有权限
+func (p *Principal) Can(permission string) bool {
+	for _, perm := range rolePermissions[p.Role] {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// 显式指定权限的路由，其余按routePermission的规则推断
+var routePermissions = map[string]string{
+	// 纯计算的测试接口，不访问设备
+	"POST /results/diff":          permRead,
+	"POST /transform/test":        permRead,
+	"POST /scripts/test":          permRead,
+	"POST /masking/test":          permRead,
+	"POST /grok/parse":            permRead,
+	"POST /parsers/:name/parse":   permRead,
+	"POST /parsers/:name/test":    permRead,
+	"POST /templates/:name/parse": permRead,
+
+	// 会访问设备的GET
+	"GET /connections/:id/health": permExecute,
+	"GET /execute/ws":             permExecute,
+
+	// 注册采集目标与定义
+	"POST /drivers":        permConfigure,
+	"POST /ftp/endpoints":  permConfigure,
+	"POST /mqtt/brokers":   permConfigure,
+	"POST /ipmi/bmcs":      permConfigure,
+	"POST /db/sources":     permConfigure,
+	"POST /modbus/devices": permConfigure,
+	"POST /modbus/maps":    permConfigure,
+	"POST /redfish/bmcs":   permConfigure,
+	"POST /gnmi/targets":   permConfigure,
+
+	// 停止采集或操作设备上的文件
+	"DELETE /forwards/:id":            permExecute,
+	"DELETE /scrapes/:id":             permExecute,
+	"DELETE /mqtt/subscriptions/:id":  permExecute,
+	"DELETE /gnmi/subscriptions/:id":  permExecute,
+	"DELETE /ftp/endpoints/:id/files": permExecute,
+
+	// 脱敏规则影响所有输出，只允许管理员修改
+	"PUT /masking/rules/:name":    permAdmin,
+	"DELETE /masking/rules/:name": permAdmin,
+
+	// 解除认证失败锁定可能再次触发设备的fail2ban
+	"DELETE /auth-failures": permAdmin,
+}
+
+// 这些前缀下的GET会读取设备上的数据
+var executeGETPrefixes = []string{"/connections/:id/files/", "/ftp/endpoints/:id/files", "/redfish/bmcs/:id/"}
+
+// routePermission 返回路由所需权限，path为gin注册的路由模板
+func routePermission(method, path string) string {
+	if perm, ok := routePermissions[method+" "+path]; ok {
+		return perm
+	}
+	if strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/rbac/") {
+		return permAdmin
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		for _, prefix := range executeGETPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				return permExecute
+			}
+		}
+		return permRead
+	case http.MethodPut, http.MethodDelete:
+		return permConfigure
+	}
+	return permExecute
+}
+
+// ConnectionACL 连接的属主和共享对象。Owner 和 SharedWith 中的调用方为 Principal.Identity()
+// 的形式（如 "apikey:alice"、"cert:agent-01"），SharedWith 也可以是 "namespace:<ns>"
+type ConnectionACL struct {
+	Owner      string    `json:"owner"`
+	Namespace  string    `json:"namespace,omitempty"`
+	SharedWith []string  `json:"shared_with"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ConnectionACLs struct {
+	acls  map[string]*ConnectionACL
+	mutex sync.RWMutex
+}
+
+var connectionACLs = &ConnectionACLs{acls: make(map[string]*ConnectionACL)}
+
+// Claim 建立连接前记录属主。同一目标的连接ID已有记录时：属主和管理员重新成为属主并保留共享列表；
+// 共享对象可以重连但不改变记录；无权访问的调用方在启用ACL时返回false，调用方应拒绝连接，
+// 避免替换他人的连接后沿用原属主的记录
+func (a *ConnectionACLs) Claim(id string, p *Principal) bool {
+	if p == nil {
+		return true
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	sharedWith := []string{}
+	if acl, ok := a.acls[id]; ok {
+		switch {
+		case acl.owns(p):
+			sharedWith = acl.SharedWith
+		case acl.allows(p):
+			return true
+		default:
+			return !connectionACLEnabled
+		}
+	}
+	a.acls[id] = &ConnectionACL{Owner: p.Identity(), Namespace: p.Namespace, SharedWith: sharedWith, CreatedAt: time.Now()}
+	return true
+}
+
+func (a *ConnectionACLs) Remove(id string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.acls, id)
+}
+
+func (a *ConnectionACLs) Get(id string) (ConnectionACL, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	acl, ok := a.acls[id]
+	if !ok {
+		return ConnectionACL{}, false
+	}
+	copied := *acl
+	copied.SharedWith = append([]string{}, acl.SharedWith...)
+	return copied, true
+}
+
+// Share 替换共享列表，只有属主和管理员可以修改
+func (a *ConnectionACLs) Share(id string, p *Principal, sharedWith []string) (ConnectionACL, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	acl, ok := a.acls[id]
+	if !ok {
+		return ConnectionACL{}, errConnectionNotFound
+	}
+	if p != nil && !acl.owns(p) {
+		return ConnectionACL{}, errNotConnectionOwner
+	}
+	acl.SharedWith = append([]string{}, sharedWith...)
+	return *acl, nil
+}
+
+// owns 属主或管理员
+func (acl *ConnectionACL) owns(p *Principal) bool {
+	return p.Admin || acl.Owner == p.Identity()
+}
+
+func (acl *ConnectionACL) allows(p *Principal) bool {
+	if acl.owns(p) {
+		return true
+	}
+	for _, entry := range acl.SharedWith {
+		if entry == p.Identity() || (p.Namespace != "" && entry == "namespace:"+p.Namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 未启用ACL或未认证时放行；启用ACL时没有属主记录的连接只有管理员可以访问
+func (a *ConnectionACLs) Allowed(p *Principal, id string) bool {
+	if !connectionACLEnabled || p == nil || p.Admin {
+		return true
+	}
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	acl, ok := a.acls[id]
+	return ok && acl.allows(p)
+}
+
+// aclSnapshot 连接当前的属主和共享列表，随执行结果保存；没有记录时为nil
+func (a *ConnectionACLs) aclSnapshot(id string) *ConnectionACL {
+	acl, ok := a.Get(id)
+	if !ok {
+		return nil
+	}
+	return &acl
+}
+
+// recordAllowed 按结果保存时的属主判断，连接断开或重新共享后不变；启用ACL时没有属主的结果只有管理员可见
+func recordAllowed(p *Principal, acl *ConnectionACL) bool {
+	if !connectionACLEnabled || p == nil || p.Admin {
+		return true
+	}
+	return acl != nil && acl.allows(p)
+}
+
+// resultViewer 需要按属主过滤结果时返回调用方，否则为nil
+func resultViewer(p *Principal) *Principal {
+	if !connectionACLEnabled || p == nil || p.Admin {
+		return nil
+	}
+	return p
+}
+
+// shareGrants 共享列表中授予调用方的条目：调用方标识以及 namespace:命名空间
+func shareGrants(p *Principal) []string {
+	grants := []string{p.Identity()}
+	if p.Namespace != "" {
+		grants = append(grants, "namespace:"+p.Namespace)
+	}
+	return grants
+}
+
+// jobAllowed 启用ACL时任务只对提交者和管理员可见，owner为提交者的Identity
+func jobAllowed(p *Principal, owner string) bool {
+	return !connectionACLEnabled || p == nil || p.Admin || owner == p.Identity()
+}
+
+// requestConnectionIDs 取路径参数和JSON请求体中引用的全部连接ID：
+// connection_id 以及经其它连接访问时的 via_connection_id，读取后恢复请求体
+func requestConnectionIDs(c *gin.Context) []string {
+	var ids []string
+	if strings.HasPrefix(c.FullPath(), "/connections/:id") {
+		ids = append(ids, c.Param("id"))
+	}
+	if c.Request.Body == nil || !strings.HasPrefix(c.ContentType(), "application/json") {
+		return ids
+	}
+	data, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return ids
+	}
+	var body struct {
+		ConnectionID    string `json:"connection_id"`
+		ViaConnectionID string `json:"via_connection_id"`
+	}
+	json.Unmarshal(data, &body)
+	for _, id := range []string{body.ConnectionID, body.ViaConnectionID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// auditDenied 记录被拒绝的访问，gRPC的method为完整方法名，ctx中带请求ID
+func auditDenied(ctx context.Context, p *Principal, method, path, permission, reason string) {
+	logInfo("audit", "access denied", "request_id", requestIDFromContext(ctx), "principal", p.Name, "role", p.Role,
+		"method", method, "path", path, "permission", permission, "reason", reason)
+	events.Publish(LifecycleEvent{Type: eventPolicyDenied, Namespace: p.Namespace, RequestID: requestIDFromContext(ctx), Principal: p.Identity(),
+		Data: map[string]interface{}{"method": method, "path": path, "permission": permission, "reason": reason}})
+}
+
+// denyRequest 返回403并写入审计日志
+func denyRequest(c *gin.Context, p *Principal, permission, reason string) {
+	auditDenied(c.Request.Context(), p, c.Request.Method, c.Request.URL.Path, permission, reason)
+	respondErrorDetails(c, http.StatusForbidden, errors.New(reason), gin.H{"permission": permission, "role": p.Role})
+}
+
+// rbacMiddleware 需在authMiddleware之后注册
+func rbacMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := currentPrincipal(c)
+		path := c.FullPath()
+		if p == nil || path == "" {
+			c.Next()
+			return
+		}
+		permission := routePermission(c.Request.Method, path)
+		if !p.Can(permission) {
+			denyRequest(c, p, permission, "permission denied")
+			return
+		}
+		if connectionACLEnabled {
+			for _, id := range requestConnectionIDs(c) {
+				if !connectionACLs.Allowed(p, id) {
+					denyRequest(c, p, permission, "connection not shared with caller")
+					return
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+func registerRBACRoutes(r *gin.Engine) {
+	// 当前调用方及其权限，便于排查认证映射
+	r.GET("/whoami", func(c *gin.Context) {
+		p := currentPrincipal(c)
+		if p == nil {
+			c.JSON(http.StatusOK, gin.H{"authenticated": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"authenticated": true, "principal": p, "identity": p.Identity(), "permissions": rolePermissions[p.Role]})
+	})
+
+	// 权限矩阵：角色权限和每个路由所需权限
+	r.GET("/rbac/routes", func(c *gin.Context) {
+		routes := make([]gin.H, 0)
+		for _, route := range r.Routes() {
+			routes = append(routes, gin.H{
+				"method":     route.Method,
+				"path":       route.Path,
+				"permission": routePermission(route.Method, route.Path),
+			})
+		}
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i]["path"] != routes[j]["path"] {
+				return routes[i]["path"].(string) < routes[j]["path"].(string)
+			}
+			return routes[i]["method"].(string) < routes[j]["method"].(string)
+		})
+		c.JSON(http.StatusOK, gin.H{"roles": rolePermissions, "routes": routes})
+	})
+
+	r.GET("/connections/:id/acl", func(c *gin.Context) {
+		acl, ok := connectionACLs.Get(c.Param("id"))
+		if !ok {
+			respondError(c, http.StatusNotFound, errConnectionNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, acl)
+	})
+
+	r.PUT("/connections/:id/acl", func(c *gin.Context) {
+		var body struct {
+			SharedWith []string `json:"shared_with"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		acl, err := connectionACLs.Share(c.Param("id"), currentPrincipal(c), body.SharedWith)
+		if err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, acl)
+	})
+}
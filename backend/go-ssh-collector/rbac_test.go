@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAPIKey 创建测试用API Key，创建后即启用认证，结束时删除
+func useAPIKey(t *testing.T, name, role string) string {
+	t.Helper()
+	key, plain, err := apiKeys.Create(APIKey{Name: name, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		apiKeys.mutex.Lock()
+		delete(apiKeys.keys, key.Hash)
+		apiKeys.mutex.Unlock()
+	})
+	return plain
+}
+
+func enableConnectionACL(t *testing.T) {
+	t.Helper()
+	connectionACLEnabled = true
+	t.Cleanup(func() {
+		connectionACLEnabled = false
+		connectionACLs.mutex.Lock()
+		connectionACLs.acls = make(map[string]*ConnectionACL)
+		connectionACLs.mutex.Unlock()
+	})
+}
+
+func apiRequest(r http.Handler, key, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoleRouteMatrix(t *testing.T) {
+	r := newRouter()
+	keys := map[string]string{
+		roleViewer:   useAPIKey(t, "viewer", roleViewer),
+		roleOperator: useAPIKey(t, "operator", roleOperator),
+		roleAdmin:    useAPIKey(t, "admin-user", roleAdmin),
+	}
+	routes := []struct {
+		method, path, body string
+		permission         string
+	}{
+		{http.MethodGet, "/connections", "", permRead},
+		{http.MethodGet, "/jobs", "", permRead},
+		{http.MethodPost, "/results/diff", "{}", permRead},
+		{http.MethodPost, "/execute", "{}", permExecute},
+		{http.MethodPost, "/connect", "{}", permExecute},
+		{http.MethodDelete, "/forwards/missing", "", permExecute},
+		{http.MethodPost, "/jobs/missing/cancel", "", permExecute},
+		{http.MethodGet, "/connections/missing/health", "", permExecute},
+		{http.MethodPost, "/db/sources", "{}", permConfigure},
+		{http.MethodDelete, "/db/sources/missing", "", permConfigure},
+		{http.MethodPut, "/masking/rules/x", "{}", permAdmin},
+		{http.MethodDelete, "/auth-failures", "", permAdmin},
+		{http.MethodGet, "/rbac/routes", "", permAdmin},
+	}
+	for _, route := range routes {
+		if got := routePermission(route.method, strings.Replace(strings.Replace(route.path, "missing", ":id", 1), "/x", "/:name", 1)); got != route.permission {
+			t.Errorf("%s %s: permission %s, want %s", route.method, route.path, got, route.permission)
+		}
+		for role, key := range keys {
+			w := apiRequest(r, key, route.method, route.path, route.body)
+			allowed := (&Principal{Role: role}).Can(route.permission)
+			if denied := w.Code == http.StatusForbidden; denied == allowed {
+				t.Errorf("%s %s as %s: status %d, allowed=%v: %s", route.method, route.path, role, w.Code, allowed, w.Body.String())
+			}
+		}
+		if w := apiRequest(r, "", route.method, route.path, route.body); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a key: status %d, want 401", route.method, route.path, w.Code)
+		}
+	}
+}
+
+// aclFixture 启用ACL，alice通过测试SSH服务器建立连接，bob为另一个operator
+type aclFixture struct {
+	router            http.Handler
+	server            *testSSHServer
+	connectionID      string
+	alice, bob, admin string
+}
+
+func newACLFixture(t *testing.T) *aclFixture {
+	t.Helper()
+	enableConnectionACL(t)
+	f := &aclFixture{
+		router: newRouter(),
+		server: startTestSSHServer(t),
+		alice:  useAPIKey(t, "alice", roleOperator),
+		bob:    useAPIKey(t, "bob", roleOperator),
+		admin:  useAPIKey(t, "root", roleAdmin),
+	}
+	allowLoopbackDestinations(t)
+	body, _ := json.Marshal(f.server.Config())
+	w := apiRequest(f.router, f.alice, http.MethodPost, "/connect", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("connect: status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		ConnectionID string `json:"connection_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	f.connectionID = resp.ConnectionID
+	t.Cleanup(func() { collector.Disconnect(f.connectionID) })
+	return f
+}
+
+func TestConnectionACLChecksEveryReferencedConnection(t *testing.T) {
+	f := newACLFixture(t)
+	requests := []struct{ method, path, body string }{
+		{http.MethodPost, "/execute", `{"connection_id":"` + f.connectionID + `","command":"uptime"}`},
+		{http.MethodGet, "/connections/" + f.connectionID + "/health", ""},
+		{http.MethodPost, "/db/sources", `{"name":"x","driver":"postgres","via_connection_id":"` + f.connectionID + `"}`},
+		{http.MethodPost, "/http/endpoints", `{"base_url":"http://10.0.0.1","via_connection_id":"` + f.connectionID + `"}`},
+	}
+	for _, req := range requests {
+		if w := apiRequest(f.router, f.bob, req.method, req.path, req.body); w.Code != http.StatusForbidden {
+			t.Errorf("bob %s %s: status %d, want 403", req.method, req.path, w.Code)
+		}
+		if w := apiRequest(f.router, f.alice, req.method, req.path, req.body); w.Code == http.StatusForbidden {
+			t.Errorf("alice %s %s: status 403: %s", req.method, req.path, w.Body.String())
+		}
+	}
+
+	// 共享给bob后放行
+	if w := apiRequest(f.router, f.alice, http.MethodPut, "/connections/"+f.connectionID+"/acl", `{"shared_with":["apikey:bob"]}`); w.Code != http.StatusOK {
+		t.Fatalf("share: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(f.router, f.bob, requests[0].method, requests[0].path, requests[0].body); w.Code == http.StatusForbidden {
+		t.Errorf("bob after sharing: status 403: %s", w.Body.String())
+	}
+}
+
+// YAML请求体同样检查via_connection_id，构造隧道的入口也各自检查ACL
+func TestViaConnectionACLWithYAMLBody(t *testing.T) {
+	f := newACLFixture(t)
+	req := httptest.NewRequest(http.MethodPost, "/http/endpoints", strings.NewReader("base_url: http://10.0.0.1\nvia_connection_id: "+f.connectionID+"\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set("X-API-Key", f.bob)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("bob YAML /http/endpoints: status %d, want 403: %s", w.Code, w.Body.String())
+	}
+
+	bob := &Principal{Name: "bob", Role: roleOperator, Method: "api_key"}
+	if _, err := newHTTPConnection(HTTPEndpointConfig{BaseURL: "http://10.0.0.1", ViaConnectionID: f.connectionID, principal: bob}); !errors.Is(err, errViaNotShared) {
+		t.Fatalf("newHTTPConnection: err = %v, want errViaNotShared", err)
+	}
+	err := dbSources.Add(DBConfig{Name: "via-acl", Driver: "postgres", Host: "db.internal", Username: "app", ViaConnectionID: f.connectionID, principal: bob})
+	if !errors.Is(err, errViaNotShared) {
+		t.Fatalf("dbSources.Add: err = %v, want errViaNotShared", err)
+	}
+}
+
+func TestConnectRefusesTargetOwnedByAnotherPrincipal(t *testing.T) {
+	f := newACLFixture(t)
+	body, _ := json.Marshal(f.server.Config())
+
+	if w := apiRequest(f.router, f.bob, http.MethodPost, "/connect", string(body)); w.Code != http.StatusForbidden {
+		t.Fatalf("bob connect: status %d, want 403: %s", w.Code, w.Body.String())
+	}
+	// alice的连接没有被替换，属主不变
+	if acl, ok := connectionACLs.Get(f.connectionID); !ok || acl.Owner != "apikey:alice" {
+		t.Fatalf("acl = %+v", acl)
+	}
+	if _, err := collector.Execute(CommandRequest{ConnectionID: f.connectionID, Command: "true"}); err != nil {
+		t.Fatalf("alice's connection was closed: %v", err)
+	}
+
+	config := f.server.Config()
+	config.principal = &Principal{Name: "carol", Role: roleOperator}
+	if _, err := collector.Connect(config); !errors.Is(err, errConnectionOwned) {
+		t.Fatalf("err = %v, want errConnectionOwned", err)
+	}
+
+	// 属主和管理员可以重连
+	if w := apiRequest(f.router, f.alice, http.MethodPost, "/connect", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("alice reconnect: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(f.router, f.admin, http.MethodPost, "/connect", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("admin reconnect: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// 共享对象重连同一目标不改变属主和共享列表；属主重连保留共享列表
+func TestSharedConnectionReconnectKeepsOwner(t *testing.T) {
+	f := newACLFixture(t)
+	if w := apiRequest(f.router, f.alice, http.MethodPut, "/connections/"+f.connectionID+"/acl", `{"shared_with":["apikey:bob","namespace:ops"]}`); w.Code != http.StatusOK {
+		t.Fatalf("share: status %d: %s", w.Code, w.Body.String())
+	}
+	body, _ := json.Marshal(f.server.Config())
+	want := []string{"apikey:bob", "namespace:ops"}
+
+	if w := apiRequest(f.router, f.bob, http.MethodPost, "/connect", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("bob reconnect: status %d: %s", w.Code, w.Body.String())
+	}
+	acl, _ := connectionACLs.Get(f.connectionID)
+	if acl.Owner != "apikey:alice" || !reflect.DeepEqual(acl.SharedWith, want) {
+		t.Fatalf("after sharee reconnect: acl = %+v", acl)
+	}
+	if w := apiRequest(f.router, f.bob, http.MethodPut, "/connections/"+f.connectionID+"/acl", `{"shared_with":[]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("bob changed sharing after reconnecting: status %d", w.Code)
+	}
+
+	if w := apiRequest(f.router, f.alice, http.MethodPost, "/connect", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("alice reconnect: status %d: %s", w.Code, w.Body.String())
+	}
+	if acl, _ := connectionACLs.Get(f.connectionID); acl.Owner != "apikey:alice" || !reflect.DeepEqual(acl.SharedWith, want) {
+		t.Fatalf("after owner reconnect: acl = %+v", acl)
+	}
+}
+
+// 属主按认证方式限定，同名的证书或JWT调用方不能访问API Key调用方的连接和任务
+func TestOwnershipIsQualifiedByAuthMethod(t *testing.T) {
+	enableConnectionACL(t)
+	apiKey := &Principal{Name: "alice", Role: roleOperator, Method: "api_key"}
+	cert := &Principal{Name: "alice", Role: roleOperator, Method: "mtls"}
+	token := &Principal{Name: "alice", Subject: "alice", Issuer: "https://idp.test", Role: roleOperator, Method: "jwt"}
+	otherIssuer := &Principal{Name: "alice", Subject: "alice", Issuer: "https://other.test", Role: roleOperator, Method: "jwt"}
+
+	for p, want := range map[*Principal]string{apiKey: "apikey:alice", cert: "cert:alice", token: "jwt:https://idp.test/alice"} {
+		if got := p.Identity(); got != want {
+			t.Errorf("%s identity = %q, want %q", p.Method, got, want)
+		}
+	}
+	t.Cleanup(func() { connectionACLs.Remove("conn-qualified") })
+	connectionACLs.Claim("conn-qualified", apiKey)
+	for _, p := range []*Principal{cert, token} {
+		if connectionACLs.Allowed(p, "conn-qualified") {
+			t.Errorf("%s principal named alice allowed on the api key's connection", p.Method)
+		}
+		if connectionACLs.Claim("conn-qualified", p) {
+			t.Errorf("%s principal named alice claimed the api key's connection", p.Method)
+		}
+		if jobAllowed(p, apiKey.Identity()) {
+			t.Errorf("%s principal named alice allowed on the api key's job", p.Method)
+		}
+	}
+	if jobAllowed(otherIssuer, token.Identity()) {
+		t.Error("same subject from another issuer allowed on the job")
+	}
+	if !connectionACLs.Allowed(apiKey, "conn-qualified") || !jobAllowed(apiKey, apiKey.Identity()) {
+		t.Error("owner denied")
+	}
+}
+
+func TestForwardACL(t *testing.T) {
+	f := newACLFixture(t)
+	forward := &Forward{ID: "acl-test-forward", Type: "local", ConnectionID: f.connectionID, done: make(chan struct{})}
+	forwards.add(forward)
+	t.Cleanup(func() { forwards.Remove(forward.ID) })
+
+	listed := func(key string) bool {
+		w := apiRequest(f.router, key, http.MethodGet, "/forwards", "")
+		return strings.Contains(w.Body.String(), forward.ID)
+	}
+	if listed(f.bob) {
+		t.Error("bob sees alice's forward")
+	}
+	if !listed(f.alice) || !listed(f.admin) {
+		t.Error("owner and admin should see the forward")
+	}
+	if w := apiRequest(f.router, f.bob, http.MethodDelete, "/forwards/"+forward.ID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("bob delete: status %d, want 403", w.Code)
+	}
+	if _, ok := forwards.Get(forward.ID); !ok {
+		t.Fatal("forward was removed by a denied request")
+	}
+	if w := apiRequest(f.router, f.alice, http.MethodDelete, "/forwards/"+forward.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("alice delete: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJobOwnership(t *testing.T) {
+	enableConnectionACL(t)
+	r := newRouter()
+	alice := useAPIKey(t, "alice", roleOperator)
+	bob := useAPIKey(t, "bob", roleOperator)
+	admin := useAPIKey(t, "root", roleAdmin)
+
+	// 经REST提交的任务记录提交者
+	w := apiRequest(r, alice, http.MethodPost, "/execute/bulk", `{"async":true,"entries":[{"connection_id":"missing","command":"uptime"}]}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("submit: status %d: %s", w.Code, w.Body.String())
+	}
+	var submitted struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &submitted)
+	if owner, err := jobs.Owner(submitted.JobID); err != nil || owner != "apikey:alice" {
+		t.Fatalf("owner = %q, %v", owner, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if job, _ := jobs.Get(submitted.JobID); job["status"] != JobPending && job["status"] != JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bulk job did not finish")
+		}
+	}
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	ctx := context.WithValue(context.Background(), principalContextKey{}, &Principal{Name: "alice", Role: roleOperator, Method: "api_key"})
+	id := jobs.Submit(ctx, "test", "", nil, func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, ctx.Err()
+	})
+
+	listed := func(key string) bool {
+		w := apiRequest(r, key, http.MethodGet, "/jobs", "")
+		return bytes.Contains(w.Body.Bytes(), []byte(id))
+	}
+	if listed(bob) {
+		t.Error("bob sees alice's job")
+	}
+	if !listed(alice) || !listed(admin) {
+		t.Error("owner and admin should see the job")
+	}
+	if w := apiRequest(r, bob, http.MethodGet, "/jobs/"+id, ""); w.Code != http.StatusForbidden {
+		t.Errorf("bob get: status %d, want 403", w.Code)
+	}
+	if w := apiRequest(r, bob, http.MethodPost, "/jobs/"+id+"/cancel", ""); w.Code != http.StatusForbidden {
+		t.Errorf("bob cancel: status %d, want 403", w.Code)
+	}
+	if w := apiRequest(r, admin, http.MethodGet, "/jobs/"+id, ""); w.Code != http.StatusOK {
+		t.Errorf("admin get: status %d", w.Code)
+	}
+	if w := apiRequest(r, alice, http.MethodPost, "/jobs/"+id+"/cancel", ""); w.Code != http.StatusOK {
+		t.Errorf("alice cancel: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// 启用连接ACL时只有批次的提交者和admin可以取消批次
+func TestBulkBatchCancelOwnership(t *testing.T) {
+	enableConnectionACL(t)
+	r := newRouter()
+	alice := useAPIKey(t, "alice", roleOperator)
+	bob := useAPIKey(t, "bob", roleOperator)
+	admin := useAPIKey(t, "root", roleAdmin)
+
+	register := func(id string) context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := registerBatch(id, "apikey:alice", cancel); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { unregisterBatch(id); cancel() })
+		return ctx
+	}
+	ctx := register("nightly-backup")
+	if w := apiRequest(r, bob, http.MethodPost, "/execute/bulk/nightly-backup/cancel", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("bob cancel: status %d, want 403", w.Code)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("denied cancel cancelled the batch")
+	}
+	if w := apiRequest(r, alice, http.MethodPost, "/execute/bulk/nightly-backup/cancel", ""); w.Code != http.StatusOK {
+		t.Fatalf("alice cancel: status %d: %s", w.Code, w.Body.String())
+	}
+	if ctx.Err() == nil {
+		t.Fatal("owner cancel did not cancel the batch")
+	}
+
+	ctx = register("weekly-backup")
+	if w := apiRequest(r, admin, http.MethodPost, "/execute/bulk/weekly-backup/cancel", ""); w.Code != http.StatusOK || ctx.Err() == nil {
+		t.Fatalf("admin cancel: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(r, bob, http.MethodPost, "/execute/bulk/missing/cancel", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown batch: status %d, want 404", w.Code)
+	}
+}
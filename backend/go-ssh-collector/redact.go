@@ -0,0 +1,135 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 凭据脱敏：错误信息（REST、WebSocket、gRPC）、日志字段、审计记录、span属性以及存储的命令和输出
+// 统一经redact处理。已知的凭据值（连接的密码和enable密码、按引用解析出的凭据）替换为 ***，
+// 另外按模式隐藏 password=xxx、"token":"xxx"、Authorization: Bearer xxx 等。
+// 启用内置脱敏规则（MASK_BUILTIN_RULES）时，送往存储和sinks的命令输出中的已知凭据同样替换
+
+const redactedValue = "***"
+
+// 短于该长度的值不按已知凭据替换，避免误伤普通文本
+const minSecretLength = 4
+
+// 值需要隐藏的字段名（小写，包含即匹配）
+var sensitiveKeys = []string{"password", "passphrase", "secret", "token", "private_key", "privatekey", "credential", "authorization", "api_key", "apikey", "community"}
+
+// sensitivePattern 匹配文本中的 password=xxx、"token":"xxx"、enable secret xxx 等，带引号的值替换后保留引号
+var sensitivePattern = regexp.MustCompile(`(?i)("?(?:[a-z_-]*password|passphrase|secret|token|private[-_]?key|api[-_]?key|authorization|community)"?\s*[=:]\s*)(?:(")[^"]*"|Bearer\s+\S+|\S+)`)
+
+// knownSecrets 按属主（连接ID、凭据引用）记录凭据值，values为按长度降序的去重列表
+var knownSecrets = struct {
+	owners map[string][]string
+	values []string
+	mutex  sync.RWMutex
+}{owners: make(map[string][]string)}
+
+// registerSecrets 记录属主的凭据，同一属主重复调用时替换
+func registerSecrets(owner string, values ...string) {
+	var kept []string
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			kept = append(kept, value)
+		}
+	}
+	knownSecrets.mutex.Lock()
+	defer knownSecrets.mutex.Unlock()
+	if len(kept) == 0 {
+		if _, ok := knownSecrets.owners[owner]; !ok {
+			return
+		}
+		delete(knownSecrets.owners, owner)
+	} else {
+		knownSecrets.owners[owner] = kept
+	}
+	rebuildSecrets()
+}
+
+func releaseSecrets(owner string) {
+	knownSecrets.mutex.Lock()
+	defer knownSecrets.mutex.Unlock()
+	if _, ok := knownSecrets.owners[owner]; !ok {
+		return
+	}
+	delete(knownSecrets.owners, owner)
+	rebuildSecrets()
+}
+
+// rebuildSecrets 调用方需持有锁；长的值先替换，避免一个凭据是另一个的子串时只替换一部分
+func rebuildSecrets() {
+	seen := map[string]bool{}
+	values := []string{}
+	for _, list := range knownSecrets.owners {
+		for _, value := range list {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	knownSecrets.values = values
+}
+
+// redactSecrets 只替换已知凭据值
+func redactSecrets(text string) string {
+	knownSecrets.mutex.RLock()
+	values := knownSecrets.values
+	knownSecrets.mutex.RUnlock()
+	for _, value := range values {
+		if strings.Contains(text, value) {
+			text = strings.ReplaceAll(text, value, redactedValue)
+		}
+	}
+	return text
+}
+
+// redact 替换已知凭据值并按模式隐藏敏感字段
+func redact(text string) string {
+	if text == "" {
+		return text
+	}
+	return sensitivePattern.ReplaceAllString(redactSecrets(text), "${1}${2}"+redactedValue+"${2}")
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range sensitiveKeys {
+		if strings.Contains(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedError 保留原错误用于errors.Is/As判断，只替换错误信息
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError 错误信息不含凭据时原样返回
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+// configSecrets 连接配置中的凭据
+func configSecrets(config SSHConfig) []string {
+	return []string{config.Password, config.EnablePassword}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	registerSecrets("redact-test", "Known-Pa55", "Known-Pa55-longer", "abc")
+	t.Cleanup(func() { releaseSecrets("redact-test") })
+
+	cases := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"plain text", "show version", "show version"},
+		{"key=value", "login password=hunter2 ok", "login password=*** ok"},
+		{"enable password", "enable_password: hunter2", "enable_password: ***"},
+		{"json string", `{"token":"tok abc","user":"u"}`, `{"token":"***","user":"u"}`},
+		{"bearer", "Authorization: Bearer eyJhbGciOi.x.y", "Authorization: ***"},
+		{"api key", "api-key=k123 api_key=k456", "api-key=*** api_key=***"},
+		{"snmp community", "community=public", "community=***"},
+		{"case insensitive", "PASSWORD=Hunter2", "PASSWORD=***"},
+		{"known secret", "echo Known-Pa55 | sudo -S id", "echo *** | sudo -S id"},
+		// 长的凭据先替换，不留下后缀
+		{"overlapping secrets", "pw Known-Pa55-longer", "pw ***"},
+		{"short values ignored", "abcdef", "abcdef"},
+		{"known secret inside a field", "secret=Known-Pa55", "secret=***"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := redact(tc.in); got != tc.want {
+				t.Fatalf("redact(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSensitiveKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"password": true, "Enable_Password": true, "private_key": true, "X-API-Key": false, "api_key": true,
+		"authorization": true, "snmp_community": true, "host": false, "username": false, "output": false,
+	} {
+		if got := sensitiveKey(key); got != want {
+			t.Errorf("sensitiveKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestKnownSecretsRegistry(t *testing.T) {
+	t.Cleanup(func() {
+		releaseSecrets("conn-a")
+		releaseSecrets("conn-b")
+	})
+	steps := []struct {
+		name string
+		do   func()
+		text string
+		want string
+	}{
+		{"registered", func() { registerSecrets("conn-a", "alpha-pw", "shared-pw") }, "alpha-pw shared-pw", "*** ***"},
+		{"second owner", func() { registerSecrets("conn-b", "shared-pw", "beta-pw") }, "beta-pw shared-pw", "*** ***"},
+		// 同一属主重复登记时替换原有的值
+		{"replaced", func() { registerSecrets("conn-a", "gamma-pw") }, "alpha-pw gamma-pw", "alpha-pw ***"},
+		// 其他属主仍登记的值不释放
+		{"shared value kept", func() { releaseSecrets("conn-a") }, "gamma-pw shared-pw", "gamma-pw ***"},
+		{"released", func() { releaseSecrets("conn-b") }, "beta-pw shared-pw", "beta-pw shared-pw"},
+		{"empty registration", func() { registerSecrets("conn-a", "", "xy") }, "xy", "xy"},
+	}
+	for _, step := range steps {
+		step.do()
+		if got := redactSecrets(step.text); got != step.want {
+			t.Fatalf("%s: redactSecrets(%q) = %q, want %q", step.name, step.text, got, step.want)
+		}
+	}
+	knownSecrets.mutex.RLock()
+	defer knownSecrets.mutex.RUnlock()
+	if _, ok := knownSecrets.owners["conn-a"]; ok {
+		t.Fatal("registering no usable values kept the owner")
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	if redactError(nil) != nil {
+		t.Fatal("nil error")
+	}
+	plain := errors.New("connection refused")
+	if redactError(plain) != plain {
+		t.Fatal("error without secrets was wrapped")
+	}
+	err := redactError(fmt.Errorf("%w: login password=hunter2", errConnectionNotFound))
+	if err.Error() != errConnectionNotFound.Error()+": login password=***" || !errors.Is(err, errConnectionNotFound) {
+		t.Fatalf("err = %q, errors.Is = %v", err, errors.Is(err, errConnectionNotFound))
+	}
+}
+
+// 连接登记的凭据在结果、日志和错误响应中都被替换，断开后释放
+func TestConnectionSecretsRedactedEverywhere(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.exec = func(command string, stdout io.Writer) int {
+		fmt.Fprintf(stdout, "ran %s\nconfigured secret %s\n", command, testSSHPassword)
+		return 0
+	}
+	id := connectTestSSH(t, server)
+	logs := captureLogs(t, "json")
+
+	result, err := collector.ExecuteCommand(id, "echo "+testSSHPassword+" | sudo -S id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logWarn("test.redact", "retry with "+testSSHPassword, "output", result.Output)
+	apiErr := newAPIError(http.StatusBadRequest, fmt.Errorf("device echoed %s", testSSHPassword), nil, "")
+	for name, text := range map[string]string{
+		"command": result.Command,
+		"output":  result.Output,
+		"logs":    logs.String(),
+		"error":   apiErr.Message,
+	} {
+		if strings.Contains(text, testSSHPassword) {
+			t.Errorf("%s leaked the connection password: %s", name, text)
+		}
+		if !strings.Contains(text, redactedValue) {
+			t.Errorf("%s was not redacted: %s", name, text)
+		}
+	}
+
+	collector.Disconnect(id)
+	if redact("x "+testSSHPassword) != "x "+testSSHPassword {
+		t.Fatal("password still registered after disconnect")
+	}
+}
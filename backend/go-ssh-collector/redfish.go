@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	redfishRoot         = "/redfish/v1"
+	redfishMaxDepth     = 5
+	redfishMaxBodyBytes = 16 << 20
+)
+
+var errBMCNotFound = errors.New("bmc not found")
+
+type BMCConfig struct {
+	BaseURL     string `json:"base_url" binding:"required"`
+	Username    string `json:"username" binding:"required"`
+	Password    string `json:"password"`
+	PasswordRef string `json:"password_ref"`
+	// SkipVerify 跳过证书校验；CACertFile 指定自签名CA
+	SkipVerify bool   `json:"skip_verify"`
+	CACertFile string `json:"ca_cert_file"`
+	// MinInterval 两次请求之间的最小间隔（毫秒），BMC性能较弱需要限速
+	MinInterval int `json:"min_interval"`
+	Timeout     int `json:"timeout"`
+}
+
+// BMC 已注册的Redfish端点，请求串行执行并按最小间隔限速
+type BMC struct {
+	ID        string
+	Config    BMCConfig
+	CreatedAt time.Time
+
+	baseURL     *url.URL
+	password    string
+	client      *http.Client
+	token       string
+	sessionURI  string
+	basicAuth   bool
+	minInterval time.Duration
+	lastRequest time.Time
+	mutex       sync.Mutex
+}
+
+func NewBMC(config BMCConfig) (*BMC, error) {
+	baseURL, err := url.Parse(strings.TrimRight(config.BaseURL, "/"))
+	if err != nil || (baseURL.Scheme != "https" && baseURL.Scheme != "http") || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base_url: %s", config.BaseURL)
+	}
+	password, err := credentialValue(config.Password, config.PasswordRef)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.SkipVerify}
+	if config.CACertFile != "" {
+		pem, err := os.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_cert_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_cert_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	timeout := 30 * time.Second
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+	minInterval := 200 * time.Millisecond
+	if config.MinInterval > 0 {
+		minInterval = time.Duration(config.MinInterval) * time.Millisecond
+	}
+
+	return &BMC{
+		ID:        "redfish:" + baseURL.Host,
+		Config:    config,
+		CreatedAt: time.Now(),
+		baseURL:   baseURL,
+		password:  password,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:     safeDialer(timeout).DialContext,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		minInterval: minInterval,
+	}, nil
+}
+
+// do 发送一次请求，调用方需持有mutex
+func (b *BMC) do(method, path string, body interface{}) (*http.Response, error) {
+	if wait := b.minInterval - time.Since(b.lastRequest); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { b.lastRequest = time.Now() }()
+
+	target, err := b.baseURL.Parse(path)
+	if err != nil || target.Host != b.baseURL.Host {
+		return nil, fmt.Errorf("invalid resource path: %s", path)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.token != "" {
+		req.Header.Set("X-Auth-Token", b.token)
+	} else if b.basicAuth {
+		req.SetBasicAuth(b.Config.Username, b.password)
+	}
+	return b.client.Do(req)
+}
+
+// login 创建Redfish会话；BMC不支持会话服务时退回Basic认证
+func (b *BMC) login() error {
+	resp, err := b.do(http.MethodPost, redfishRoot+"/SessionService/Sessions", map[string]string{
+		"UserName": b.Config.Username,
+		"Password": b.password,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, redfishMaxBodyBytes))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("authentication failed: %s", resp.Status)
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		b.basicAuth = true
+		return nil
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("failed to create session: %s", resp.Status)
+	}
+
+	b.token = resp.Header.Get("X-Auth-Token")
+	b.sessionURI = resp.Header.Get("Location")
+	if b.token == "" {
+		b.basicAuth = true
+	}
+	return nil
+}
+
+// Get 获取资源，令牌过期(401)时重新登录一次
+func (b *BMC) Get(path string) (map[string]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.token == "" && !b.basicAuth {
+		if err := b.login(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := b.do(http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, redfishMaxBodyBytes))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			b.token = ""
+			b.basicAuth = false
+			if err := b.login(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("resource not found: %s", path)
+		}
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("request %s failed: %s", path, resp.Status)
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %v", path, err)
+		}
+		return result, nil
+	}
+}
+
+// Logout 删除会话
+func (b *BMC) Logout() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.sessionURI != "" && b.token != "" {
+		if resp, err := b.do(http.MethodDelete, b.sessionURI, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+	b.token = ""
+}
+
+// Expand 按深度展开 @odata.id 链接，已访问的资源不会重复获取
+func (b *BMC) Expand(path string, depth int) (map[string]interface{}, error) {
+	visited := map[string]bool{}
+	return b.expand(path, depth, visited)
+}
+
+func (b *BMC) expand(path string, depth int, visited map[string]bool) (map[string]interface{}, error) {
+	visited[path] = true
+	resource, err := b.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		b.expandLinks(resource, depth, visited)
+	}
+	return resource, nil
+}
+
+func (b *BMC) expandLinks(value interface{}, depth int, visited map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// 只包含@odata.id的对象是链接
+		if link, ok := v["@odata.id"].(string); ok && len(v) == 1 {
+			if visited[link] {
+				return v
+			}
+			resource, err := b.expand(link, depth-1, visited)
+			if err != nil {
+				return map[string]interface{}{"@odata.id": link, "error": err.Error()}
+			}
+			return resource
+		}
+		for key, child := range v {
+			// Links/相关资源指向拓扑中的其他对象，不展开避免结果膨胀
+			if key == "Links" || key == "@odata.id" {
+				continue
+			}
+			v[key] = b.expandLinks(child, depth, visited)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = b.expandLinks(child, depth, visited)
+		}
+	}
+	return value
+}
+
+// members 返回集合中成员的路径
+func members(collection map[string]interface{}) []string {
+	var paths []string
+	items, _ := collection["Members"].([]interface{})
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			if link, ok := m["@odata.id"].(string); ok {
+				paths = append(paths, link)
+			}
+		}
+	}
+	return paths
+}
+
+func field(m map[string]interface{}, keys ...string) interface{} {
+	var cur interface{} = m
+	for _, key := range keys {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = obj[key]
+	}
+	return cur
+}
+
+func normalizeSystem(s map[string]interface{}) gin.H {
+	return gin.H{
+		"id":            s["Id"],
+		"name":          s["Name"],
+		"manufacturer":  s["Manufacturer"],
+		"model":         s["Model"],
+		"serial_number": s["SerialNumber"],
+		"bios_version":  s["BiosVersion"],
+		"power_state":   s["PowerState"],
+		"health":        field(s, "Status", "Health"),
+		"state":         field(s, "Status", "State"),
+		"processors": gin.H{
+			"count":  field(s, "ProcessorSummary", "Count"),
+			"model":  field(s, "ProcessorSummary", "Model"),
+			"health": field(s, "ProcessorSummary", "Status", "Health"),
+		},
+		"memory": gin.H{
+			"total_gib": field(s, "MemorySummary", "TotalSystemMemoryGiB"),
+			"health":    field(s, "MemorySummary", "Status", "Health"),
+		},
+	}
+}
+
+func normalizeChassis(c map[string]interface{}) gin.H {
+	return gin.H{
+		"id":            c["Id"],
+		"name":          c["Name"],
+		"chassis_type":  c["ChassisType"],
+		"manufacturer":  c["Manufacturer"],
+		"model":         c["Model"],
+		"serial_number": c["SerialNumber"],
+		"power_state":   c["PowerState"],
+		"health":        field(c, "Status", "Health"),
+		"state":         field(c, "Status", "State"),
+	}
+}
+
+func normalizeList(items interface{}, fn func(map[string]interface{}) gin.H) []gin.H {
+	result := []gin.H{}
+	list, _ := items.([]interface{})
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			result = append(result, fn(m))
+		}
+	}
+	return result
+}
+
+func normalizeThermal(chassisID interface{}, t map[string]interface{}) gin.H {
+	return gin.H{
+		"chassis_id": chassisID,
+		"temperatures": normalizeList(t["Temperatures"], func(m map[string]interface{}) gin.H {
+			return gin.H{
+				"name":               m["Name"],
+				"reading_celsius":    m["ReadingCelsius"],
+				"upper_critical":     m["UpperThresholdCritical"],
+				"upper_fatal":        m["UpperThresholdFatal"],
+				"physical_context":   m["PhysicalContext"],
+				"health":             field(m, "Status", "Health"),
+				"state":              field(m, "Status", "State"),
+				"upper_non_critical": m["UpperThresholdNonCritical"],
+			}
+		}),
+		"fans": normalizeList(t["Fans"], func(m map[string]interface{}) gin.H {
+			return gin.H{
+				"name":           m["Name"],
+				"reading":        m["Reading"],
+				"reading_units":  m["ReadingUnits"],
+				"lower_critical": m["LowerThresholdCritical"],
+				"health":         field(m, "Status", "Health"),
+				"state":          field(m, "Status", "State"),
+			}
+		}),
+	}
+}
+
+func normalizePower(chassisID interface{}, p map[string]interface{}) gin.H {
+	return gin.H{
+		"chassis_id": chassisID,
+		"power_control": normalizeList(p["PowerControl"], func(m map[string]interface{}) gin.H {
+			return gin.H{
+				"name":           m["Name"],
+				"consumed_watts": m["PowerConsumedWatts"],
+				"capacity_watts": m["PowerCapacityWatts"],
+				"average_watts":  field(m, "PowerMetrics", "AverageConsumedWatts"),
+				"max_watts":      field(m, "PowerMetrics", "MaxConsumedWatts"),
+			}
+		}),
+		"power_supplies": normalizeList(p["PowerSupplies"], func(m map[string]interface{}) gin.H {
+			return gin.H{
+				"name":               m["Name"],
+				"model":              m["Model"],
+				"serial_number":      m["SerialNumber"],
+				"capacity_watts":     m["PowerCapacityWatts"],
+				"output_watts":       m["LastPowerOutputWatts"],
+				"line_input_voltage": m["LineInputVoltage"],
+				"health":             field(m, "Status", "Health"),
+				"state":              field(m, "Status", "State"),
+			}
+		}),
+	}
+}
+
+// Collect 获取指定类别的资源并归一化
+func (b *BMC) Collect(resource string, depth int) ([]gin.H, []interface{}, error) {
+	collectionPath := redfishRoot + "/Systems"
+	if resource != "systems" {
+		collectionPath = redfishRoot + "/Chassis"
+	}
+	collection, err := b.Get(collectionPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	normalized := []gin.H{}
+	raw := []interface{}{}
+	memberDepth := depth
+	if resource == "thermal" || resource == "power" {
+		// 只需要机箱上的Thermal/Power链接
+		memberDepth = 0
+	}
+	for _, path := range members(collection) {
+		member, err := b.Expand(path, memberDepth)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch resource {
+		case "systems":
+			normalized = append(normalized, normalizeSystem(member))
+			raw = append(raw, member)
+		case "chassis":
+			normalized = append(normalized, normalizeChassis(member))
+			raw = append(raw, member)
+		case "thermal", "power":
+			name := "Thermal"
+			if resource == "power" {
+				name = "Power"
+			}
+			link, _ := field(member, name, "@odata.id").(string)
+			if link == "" {
+				// 部分机箱（如机柜）没有温度/电源资源
+				continue
+			}
+			sub, err := b.Expand(link, depth)
+			if err != nil {
+				return nil, nil, err
+			}
+			if resource == "thermal" {
+				normalized = append(normalized, normalizeThermal(member["Id"], sub))
+			} else {
+				normalized = append(normalized, normalizePower(member["Id"], sub))
+			}
+			raw = append(raw, sub)
+		}
+	}
+	return normalized, raw, nil
+}
+
+type BMCRegistry struct {
+	bmcs  map[string]*BMC
+	mutex sync.RWMutex
+}
+
+func (r *BMCRegistry) Add(bmc *BMC) {
+	r.mutex.Lock()
+	old, exists := r.bmcs[bmc.ID]
+	r.bmcs[bmc.ID] = bmc
+	r.mutex.Unlock()
+
+	if exists {
+		old.Logout()
+	}
+}
+
+func (r *BMCRegistry) Get(id string) (*BMC, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	bmc, ok := r.bmcs[id]
+	if !ok {
+		return nil, errBMCNotFound
+	}
+	return bmc, nil
+}
+
+func (r *BMCRegistry) Remove(id string) error {
+	r.mutex.Lock()
+	bmc, ok := r.bmcs[id]
+	delete(r.bmcs, id)
+	r.mutex.Unlock()
+
+	if !ok {
+		return errBMCNotFound
+	}
+	bmc.Logout()
+	return nil
+}
+
+var bmcs = &BMCRegistry{bmcs: make(map[string]*BMC)}
+
+func redfishDepth(c *gin.Context) int {
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+	if err != nil || depth < 0 {
+		return 1
+	}
+	if depth > redfishMaxDepth {
+		return redfishMaxDepth
+	}
+	return depth
+}
+
+func registerRedfishRoutes(r *gin.Engine) {
+	// 注册BMC
+	r.POST("/redfish/bmcs", func(c *gin.Context) {
+		var config BMCConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		bmc, err := NewBMC(config)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		bmcs.Add(bmc)
+
+		c.JSON(http.StatusOK, gin.H{
+			"bmc_id":  bmc.ID,
+			"message": "BMC registered",
+		})
+	})
+
+	r.GET("/redfish/bmcs", func(c *gin.Context) {
+		bmcs.mutex.RLock()
+		list := make([]gin.H, 0, len(bmcs.bmcs))
+		for id, bmc := range bmcs.bmcs {
+			list = append(list, gin.H{
+				"id":         id,
+				"base_url":   bmc.Config.BaseURL,
+				"username":   bmc.Config.Username,
+				"created_at": bmc.CreatedAt,
+			})
+		}
+		bmcs.mutex.RUnlock()
+
+		c.JSON(http.StatusOK, gin.H{"bmcs": list})
+	})
+
+	r.DELETE("/redfish/bmcs/:id", func(c *gin.Context) {
+		if err := bmcs.Remove(c.Param("id")); err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "BMC removed"})
+	})
+
+	// 获取任意资源路径
+	r.GET("/redfish/bmcs/:id/resource", func(c *gin.Context) {
+		bmc, err := bmcs.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		path := c.DefaultQuery("path", redfishRoot)
+		if !strings.HasPrefix(path, redfishRoot) {
+			respondError(c, http.StatusBadRequest, errors.New("path must start with "+redfishRoot))
+			return
+		}
+
+		resource, err := bmc.Expand(path, redfishDepth(c))
+		if err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, resource)
+	})
+
+	for _, resource := range []string{"systems", "chassis", "thermal", "power"} {
+		resource := resource
+		r.GET("/redfish/bmcs/:id/"+resource, func(c *gin.Context) {
+			bmc, err := bmcs.Get(c.Param("id"))
+			if err != nil {
+				respondError(c, http.StatusNotFound, err)
+				return
+			}
+
+			normalized, raw, err := bmc.Collect(resource, redfishDepth(c))
+			if err != nil {
+				respondError(c, http.StatusBadGateway, err)
+				return
+			}
+
+			response := gin.H{
+				"bmc_id":    bmc.ID,
+				resource:    normalized,
+				"timestamp": time.Now(),
+			}
+			if c.Query("raw") == "true" {
+				response["raw"] = raw
+			}
+			c.JSON(http.StatusOK, response)
+		})
+	}
+}
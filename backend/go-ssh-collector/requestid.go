@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求ID：沿用网关传入的X-Request-ID，没有时生成UUID，写入响应头、访问日志、审计日志、
+// 错误响应、CommandResult和任务记录。批量执行的每个条目使用 "<请求ID>/target-<序号>"
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// newRequestID 生成UUIDv4
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	buf[6] = buf[6]&0x0f | 0x40
+	buf[8] = buf[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:])
+}
+
+// validRequestID 只接受不含空白和引号的可打印ASCII，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' || r == '"' || r == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func requestID(c *gin.Context) string {
+	return requestIDFromContext(c.Request.Context())
+}
+
+// childRequestID 批量执行中单个目标的请求ID
+func childRequestID(parent string, index int) string {
+	if parent == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/target-%d", parent, index)
+}
+
+// requestIDMiddleware 需最先注册，之后的中间件和访问日志都能取到请求ID
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// 结果缓存：设置 RESULT_CACHE_REDIS_URL（redis:// 或 rediss://）后，每个连接上每条命令（按command_hash）
+// 的最新结果写入Redis，保留 RESULT_CACHE_TTL 秒。密码可用 RESULT_CACHE_REDIS_PASSWORD_REF 引用，
+// rediss的CA为 RESULT_CACHE_REDIS_TLS_CA。
+// POST /execute 带 cache.max_age_seconds 时，缓存中足够新的结果直接返回（from_cache: true），不访问设备；
+// 解析、提取、转换和脚本按本次请求对缓存的输出重新执行。unmasked、流式输出和指定shell的执行不使用缓存。
+// GET /results/latest 依次读取缓存、结果存储和内存中的结果，未命中缓存时回填。
+// 请求头 Cache-Control: no-cache、cache=false 参数或 cache.bypass 跳过缓存读取。
+// 连接关闭或丢失时删除该连接的缓存；Redis不可用时按未命中处理，不影响执行。
+// 每个条目保存写入时连接的属主和共享列表，启用连接ACL时读取和删除都按它判断，没有属主的条目只有管理员可以访问
+
+var (
+	resultCacheURL     = getEnv("RESULT_CACHE_REDIS_URL", "")
+	resultCacheTTL     = time.Duration(envInt64("RESULT_CACHE_TTL", 300)) * time.Second
+	resultCachePrefix  = getEnv("RESULT_CACHE_PREFIX", "collector:")
+	resultCacheTimeout = time.Duration(envInt64("RESULT_CACHE_TIMEOUT_MS", 200)) * time.Millisecond
+	resultCacheQueue   = int(envInt64("RESULT_CACHE_QUEUE", 1000))
+
+	errResultCacheDisabled = errors.New("result cache is not enabled (set RESULT_CACHE_REDIS_URL)")
+	errResultCacheDenied   = errors.New("cached results not shared with caller")
+)
+
+// CommandCacheOptions 执行请求的缓存选项，MaxAgeSeconds为可接受的结果最大年龄
+type CommandCacheOptions struct {
+	MaxAgeSeconds int  `json:"max_age_seconds"`
+	Bypass        bool `json:"bypass"`
+}
+
+// cachedResult 缓存条目，ACL为写入时连接的属主和共享列表
+type cachedResult struct {
+	ResultRecord
+	ACL *ConnectionACL `json:"acl,omitempty"`
+}
+
+// decodeCachedResult 取出记录并恢复属主
+func decodeCachedResult(data []byte) (ResultRecord, error) {
+	var entry cachedResult
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return ResultRecord{}, err
+	}
+	if entry.Result == nil {
+		return ResultRecord{}, errors.New("cached entry has no result")
+	}
+	record := entry.ResultRecord
+	record.acl = entry.ACL
+	return record, nil
+}
+
+// cacheWrite 已序列化的待写入条目
+type cacheWrite struct {
+	connectionID string
+	key          string
+	data         []byte
+}
+
+type ResultCache struct {
+	client *redis.Client
+	queue  chan cacheWrite
+
+	hits        uint64
+	misses      uint64
+	stale       uint64
+	errors      uint64
+	written     uint64
+	dropped     uint64
+	failed      uint64
+	invalidated uint64
+	lastError   atomic.Value
+}
+
+// resultCache 未启用时为nil，Store等方法可直接调用
+var resultCache *ResultCache
+
+func openResultCache(rawURL string) (*ResultCache, error) {
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		// 解析错误中可能带有URL中的密码
+		return nil, errors.New("invalid RESULT_CACHE_REDIS_URL")
+	}
+	if options.Password, err = credentialValue(options.Password, getEnv("RESULT_CACHE_REDIS_PASSWORD_REF", "")); err != nil {
+		return nil, err
+	}
+	if caFile := getEnv("RESULT_CACHE_REDIS_TLS_CA", ""); caFile != "" && options.TLSConfig != nil {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		options.TLSConfig.RootCAs = x509.NewCertPool()
+		if !options.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	options.DialTimeout = 2 * time.Second
+	return &ResultCache{client: redis.NewClient(options), queue: make(chan cacheWrite, resultCacheQueue)}, nil
+}
+
+// resultCacheKey 与结果存储的command_hash一致
+func resultCacheKey(connectionID, hash string) string {
+	return resultCachePrefix + "result:" + connectionID + ":" + hash
+}
+
+// resultCacheIndex 记录连接的全部缓存键，用于连接关闭时删除
+func resultCacheIndex(connectionID string) string {
+	return resultCachePrefix + "result-index:" + connectionID
+}
+
+// Store 在执行路径上只做序列化，写入在后台进行，队列满时丢弃；acl为结果所属连接的属主
+func (rc *ResultCache) Store(connectionID string, info ConnectionInfo, result *CommandResult, acl *ConnectionACL) {
+	if rc == nil {
+		return
+	}
+	record := ResultRecord{ConnectionID: connectionID, Protocol: info.Protocol, Host: info.Host, Result: result, Fields: result.Fields}
+	data, err := json.Marshal(cachedResult{record, acl})
+	if err != nil {
+		atomic.AddUint64(&rc.failed, 1)
+		return
+	}
+	select {
+	case rc.queue <- cacheWrite{connectionID: connectionID, key: resultCacheKey(connectionID, commandHash(result.Command)), data: data}:
+	default:
+		atomic.AddUint64(&rc.dropped, 1)
+	}
+}
+
+func (rc *ResultCache) run() {
+	for write := range rc.queue {
+		// 排队期间连接已关闭的不再写入，避免在失效之后重新出现
+		if _, err := collector.get(write.connectionID); err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*resultCacheTimeout)
+		index := resultCacheIndex(write.connectionID)
+		_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, write.key, write.data, resultCacheTTL)
+			pipe.SAdd(ctx, index, write.key)
+			pipe.Expire(ctx, index, resultCacheTTL)
+			return nil
+		})
+		cancel()
+		if err != nil {
+			rc.fail(&rc.failed, "write", err)
+			continue
+		}
+		atomic.AddUint64(&rc.written, 1)
+	}
+}
+
+// fail 每类错误只记录第一次，operation为 write、read 或 invalidate
+func (rc *ResultCache) fail(counter *uint64, operation string, err error) {
+	if atomic.AddUint64(counter, 1) == 1 {
+		logWarn("result_cache."+operation+"_failed", "result cache "+operation+" failed", "error", err)
+	}
+	rc.lastError.Store(err.Error())
+}
+
+// Latest 返回缓存中的结果，未命中时返回errResultNotFound；是否足够新由调用方判断并计数
+func (rc *ResultCache) Latest(ctx context.Context, connectionID, hash string) (ResultRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, resultCacheTimeout)
+	defer cancel()
+	data, err := rc.client.Get(ctx, resultCacheKey(connectionID, hash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		atomic.AddUint64(&rc.misses, 1)
+		return ResultRecord{}, errResultNotFound
+	}
+	var record ResultRecord
+	if err == nil {
+		record, err = decodeCachedResult(data)
+	}
+	if err != nil {
+		rc.fail(&rc.errors, "read", err)
+		return ResultRecord{}, err
+	}
+	record.Result.FromCache = true
+	return record, nil
+}
+
+// Fresh 返回不超过max_age_seconds的缓存结果，去掉解析、提取等派生字段，由调用方按本次请求重新处理
+func (rc *ResultCache) Fresh(connectionID string, req CommandRequest) *CommandResult {
+	if rc == nil || req.Cache == nil || req.Cache.Bypass || req.Cache.MaxAgeSeconds <= 0 || req.Unmasked || req.stream != nil || req.Shell != "" {
+		return nil
+	}
+	ctx := req.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	record, err := rc.Latest(ctx, connectionID, commandHash(redact(req.Command)))
+	if err != nil {
+		return nil
+	}
+	// 启用ACL时只复用连接当前属主写入的条目
+	if connectionACLEnabled {
+		current := connectionACLs.aclSnapshot(connectionID)
+		if record.acl == nil || current == nil || record.acl.Owner != current.Owner {
+			atomic.AddUint64(&rc.misses, 1)
+			return nil
+		}
+	}
+	cached := record.Result
+	if time.Since(cached.Timestamp) > time.Duration(req.Cache.MaxAgeSeconds)*time.Second {
+		atomic.AddUint64(&rc.stale, 1)
+		return nil
+	}
+	atomic.AddUint64(&rc.hits, 1)
+	return &CommandResult{
+		ID:         cached.ID,
+		Command:    cached.Command,
+		Output:     cached.Output,
+		Stderr:     cached.Stderr,
+		ExitCode:   cached.ExitCode,
+		Truncated:  cached.Truncated,
+		Error:      cached.Error,
+		ErrorClass: cached.ErrorClass,
+		Timestamp:  cached.Timestamp,
+		RequestID:  cached.RequestID,
+		FromCache:  true,
+	}
+}
+
+// Invalidate 删除连接的全部缓存结果。viewer不为nil时只删除条目属主允许它访问的结果（已过期的键一并清理），
+// 存在条目但都不允许时返回errResultCacheDenied
+func (rc *ResultCache) Invalidate(ctx context.Context, connectionID string, viewer *Principal) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*resultCacheTimeout)
+	defer cancel()
+	index := resultCacheIndex(connectionID)
+	keys, err := rc.client.SMembers(ctx, index).Result()
+	if err != nil {
+		return 0, err
+	}
+	if viewer != nil && len(keys) > 0 {
+		return rc.invalidateVisible(ctx, index, keys, viewer)
+	}
+	deleted, err := rc.client.Del(ctx, append(keys, index)...).Result()
+	if err != nil {
+		return 0, err
+	}
+	// 索引本身不计入
+	n := len(keys)
+	if int(deleted) < n {
+		n = int(deleted)
+	}
+	atomic.AddUint64(&rc.invalidated, uint64(n))
+	return n, nil
+}
+
+func (rc *ResultCache) invalidateVisible(ctx context.Context, index string, keys []string, viewer *Principal) (int, error) {
+	values, err := rc.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var remove []string
+	visible, denied := 0, 0
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			remove = append(remove, keys[i])
+			continue
+		}
+		record, err := decodeCachedResult([]byte(data))
+		if err != nil || !recordAllowed(viewer, record.acl) {
+			denied++
+			continue
+		}
+		remove = append(remove, keys[i])
+		visible++
+	}
+	if visible == 0 && denied > 0 {
+		return 0, errResultCacheDenied
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+	deleted, err := rc.client.Del(ctx, remove...).Result()
+	if err != nil {
+		return 0, err
+	}
+	if err := rc.client.SRem(ctx, index, remove).Err(); err != nil {
+		return 0, err
+	}
+	n := visible
+	if int(deleted) < n {
+		n = int(deleted)
+	}
+	atomic.AddUint64(&rc.invalidated, uint64(n))
+	return n, nil
+}
+
+// invalidateOnDisconnect 连接关闭或丢失后缓存的结果不再代表设备当前状态
+func (rc *ResultCache) invalidateOnDisconnect(sub *eventSubscriber) {
+	for event := range sub.ch {
+		if event.Type != eventConnectionClosed && event.Type != eventConnectionLost {
+			continue
+		}
+		if _, err := rc.Invalidate(context.Background(), event.ConnectionID, nil); err != nil {
+			rc.fail(&rc.errors, "invalidate", err)
+		}
+	}
+}
+
+func (rc *ResultCache) Stats() map[string]interface{} {
+	if rc == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	stats := map[string]interface{}{
+		"enabled":     true,
+		"ttl_seconds": int64(resultCacheTTL / time.Second),
+		"hits":        atomic.LoadUint64(&rc.hits),
+		"misses":      atomic.LoadUint64(&rc.misses),
+		"stale":       atomic.LoadUint64(&rc.stale),
+		"errors":      atomic.LoadUint64(&rc.errors),
+		"written":     atomic.LoadUint64(&rc.written),
+		"dropped":     atomic.LoadUint64(&rc.dropped),
+		"failed":      atomic.LoadUint64(&rc.failed),
+		"invalidated": atomic.LoadUint64(&rc.invalidated),
+		"queued":      len(rc.queue),
+	}
+	if lastError, ok := rc.lastError.Load().(string); ok {
+		stats["last_error"] = lastError
+	}
+	return stats
+}
+
+func (rc *ResultCache) WriteMetrics(w io.Writer) {
+	if rc == nil {
+		return
+	}
+	out := metricWriter{w}
+	out.family("collector_result_cache_requests_total", "counter", "Result cache lookups by outcome.")
+	out.sample("collector_result_cache_requests_total", float64(atomic.LoadUint64(&rc.hits)), "result", "hit")
+	out.sample("collector_result_cache_requests_total", float64(atomic.LoadUint64(&rc.misses)), "result", "miss")
+	out.sample("collector_result_cache_requests_total", float64(atomic.LoadUint64(&rc.stale)), "result", "stale")
+	out.sample("collector_result_cache_requests_total", float64(atomic.LoadUint64(&rc.errors)), "result", "error")
+	out.family("collector_result_cache_writes_total", "counter", "Results written to, dropped by or failed in the result cache.")
+	out.sample("collector_result_cache_writes_total", float64(atomic.LoadUint64(&rc.written)), "result", "written")
+	out.sample("collector_result_cache_writes_total", float64(atomic.LoadUint64(&rc.dropped)), "result", "dropped")
+	out.sample("collector_result_cache_writes_total", float64(atomic.LoadUint64(&rc.failed)), "result", "failed")
+}
+
+// startResultCache Redis暂时不可用时仍然启用，后续请求按未命中处理
+func startResultCache() {
+	if resultCacheURL == "" {
+		return
+	}
+	cache, err := openResultCache(resultCacheURL)
+	if err != nil {
+		logFatal("result_cache.open_failed", "open result cache failed", "error", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cache.client.Ping(ctx).Err(); err != nil {
+		logWarn("result_cache.unreachable", "redis not reachable yet", "error", err)
+	}
+	resultCache = cache
+	go cache.run()
+	go cache.invalidateOnDisconnect(events.Subscribe(eventSubscriberBuffer))
+	logInfo("result_cache.start", "caching latest command results in redis", "ttl_ms", resultCacheTTL)
+}
+
+// cacheBypassed 请求要求跳过缓存读取
+func cacheBypassed(c *gin.Context) bool {
+	return strings.Contains(strings.ToLower(c.GetHeader("Cache-Control")), "no-cache") || c.Query("cache") == "false"
+}
+
+// latestStored 从结果存储或内存中查找viewer可见的最新结果，viewer为nil时不过滤
+func latestStored(ctx context.Context, connectionID, hash string, viewer *Principal) (ResultRecord, error) {
+	if resultStore != nil {
+		values := map[string]string{"connection_id": connectionID, "command_hash": hash, "page_size": "1", "fields": "id"}
+		q, err := parseResultQuery(func(key string) string { return values[key] })
+		if err != nil {
+			return ResultRecord{}, err
+		}
+		q.Viewer = viewer
+		page, err := resultStore.Query(ctx, q)
+		if err != nil {
+			return ResultRecord{}, err
+		}
+		if len(page.Results) == 0 {
+			return ResultRecord{}, errResultNotFound
+		}
+		id, _ := page.Results[0]["id"].(string)
+		return resultStore.Get(ctx, id)
+	}
+	return results.Latest(connectionID, hash, viewer)
+}
+
+func registerResultCacheRoutes(r *gin.Engine) {
+	// command与command_hash二选一
+	r.GET("/results/latest", func(c *gin.Context) {
+		connectionID := c.Query("connection_id")
+		hash := strings.ToLower(c.Query("command_hash"))
+		if command := c.Query("command"); command != "" && hash == "" {
+			hash = commandHash(redact(command))
+		}
+		if connectionID == "" || hash == "" {
+			respondError(c, http.StatusBadRequest, errors.New("connection_id and command or command_hash are required"))
+			return
+		}
+		// 按结果保存的属主判断可见性，连接断开后也不变
+		p := currentPrincipal(c)
+		if resultCache != nil && !cacheBypassed(c) {
+			if record, err := resultCache.Latest(c.Request.Context(), connectionID, hash); err == nil && recordAllowed(p, record.acl) {
+				c.JSON(http.StatusOK, requestAPIVersion(c).ResultRecord(record))
+				return
+			}
+		}
+		viewer := resultViewer(p)
+		record, err := latestStored(c.Request.Context(), connectionID, hash, viewer)
+		if errors.Is(err, errResultNotFound) {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		// 过滤后的结果不一定是最新的，只回填未过滤的查询结果
+		if resultCache != nil && viewer == nil && time.Since(record.Result.Timestamp) < resultCacheTTL {
+			info := ConnectionInfo{Protocol: record.Protocol, Host: record.Host}
+			resultCache.Store(connectionID, info, record.Result, record.acl)
+		}
+		c.JSON(http.StatusOK, requestAPIVersion(c).ResultRecord(record))
+	})
+
+	r.DELETE("/results/cache", func(c *gin.Context) {
+		if resultCache == nil {
+			respondError(c, http.StatusNotFound, errResultCacheDisabled)
+			return
+		}
+		connectionID := c.Query("connection_id")
+		if connectionID == "" {
+			respondError(c, http.StatusBadRequest, errors.New("connection_id is required"))
+			return
+		}
+		p := currentPrincipal(c)
+		n, err := resultCache.Invalidate(c.Request.Context(), connectionID, resultViewer(p))
+		if errors.Is(err, errResultCacheDenied) {
+			denyRequest(c, p, permConfigure, "connection not shared with caller")
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusServiceUnavailable, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connection_id": connectionID, "invalidated": n, "timestamp": time.Now()})
+	})
+}
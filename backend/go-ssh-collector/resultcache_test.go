@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// useResultCache 使用内存中的Redis，条目由cacheResult直接写入
+func useResultCache(t *testing.T) (*ResultCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache, err := openResultCache("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := resultCache
+	resultCache = cache
+	t.Cleanup(func() {
+		resultCache = previous
+		cache.client.Close()
+	})
+	return cache, server
+}
+
+// cacheResult 按Store的格式同步写入一个条目
+func cacheResult(t *testing.T, rc *ResultCache, connectionID, command string, acl *ConnectionACL) {
+	t.Helper()
+	result := &CommandResult{ID: newID(), Command: command, Output: "ok", Timestamp: time.Now()}
+	data, err := json.Marshal(cachedResult{ResultRecord{ConnectionID: connectionID, Protocol: "ssh", Result: result}, acl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := resultCacheKey(connectionID, commandHash(command))
+	if err := rc.client.Set(ctx, key, data, resultCacheTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.client.SAdd(ctx, resultCacheIndex(connectionID), key).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 缓存条目按写入时保存的属主判断可见性：连接ACL记录已不存在时不放行，没有属主的条目只有管理员可见
+func TestResultCacheLatestUsesStoredOwner(t *testing.T) {
+	enableConnectionACL(t)
+	rc, _ := useResultCache(t)
+	cacheResult(t, rc, "conn-a", "show version", &ConnectionACL{Owner: "apikey:alice", SharedWith: []string{"apikey:bob"}})
+	cacheResult(t, rc, "conn-a", "show clock", nil)
+	r := newRouter()
+
+	for _, tc := range []struct {
+		name, role, command string
+		code                int
+	}{
+		{"alice", roleViewer, "show version", http.StatusOK},
+		{"bob", roleViewer, "show version", http.StatusOK},
+		{"carol", roleViewer, "show version", http.StatusNotFound},
+		{"alice", roleViewer, "show clock", http.StatusNotFound},
+		{"admin", roleAdmin, "show clock", http.StatusOK},
+	} {
+		key := useAPIKey(t, tc.name, tc.role)
+		w := apiRequest(r, key, http.MethodGet, "/results/latest?connection_id=conn-a&command_hash="+commandHash(tc.command), "")
+		if w.Code != tc.code {
+			t.Errorf("%s %q: status %d, want %d: %s", tc.name, tc.command, w.Code, tc.code, w.Body.String())
+		}
+	}
+}
+
+func TestResultCacheInvalidateUsesStoredOwner(t *testing.T) {
+	enableConnectionACL(t)
+	rc, server := useResultCache(t)
+	cacheResult(t, rc, "conn-a", "show version", &ConnectionACL{Owner: "apikey:alice", SharedWith: []string{}})
+	cacheResult(t, rc, "conn-a", "show clock", &ConnectionACL{Owner: "apikey:alice", SharedWith: []string{}})
+	r := newRouter()
+
+	carol := useAPIKey(t, "carol", roleOperator)
+	if w := apiRequest(r, carol, http.MethodDelete, "/results/cache?connection_id=conn-a", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("carol: status %d: %s", w.Code, w.Body.String())
+	}
+	if !server.Exists(resultCacheKey("conn-a", commandHash("show version"))) {
+		t.Fatal("denied invalidation deleted an entry")
+	}
+
+	alice := useAPIKey(t, "alice", roleOperator)
+	w := apiRequest(r, alice, http.MethodDelete, "/results/cache?connection_id=conn-a", "")
+	var body struct {
+		Invalidated int `json:"invalidated"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK || body.Invalidated != 2 {
+		t.Fatalf("alice: status %d: %s", w.Code, w.Body.String())
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("keys left after invalidation: %v", keys)
+	}
+	// 没有缓存时不是拒绝
+	if w := apiRequest(r, carol, http.MethodDelete, "/results/cache?connection_id=conn-a", ""); w.Code != http.StatusOK {
+		t.Fatalf("empty cache: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// 执行时只复用连接当前属主写入的条目
+func TestResultCacheFreshRequiresCurrentOwner(t *testing.T) {
+	enableConnectionACL(t)
+	rc, _ := useResultCache(t)
+	connectionACLs.Claim("conn-a", &Principal{Name: "bob", Role: roleOperator, Method: "api_key"})
+	cacheResult(t, rc, "conn-a", "show version", &ConnectionACL{Owner: "apikey:alice", SharedWith: []string{"apikey:bob"}})
+	cacheResult(t, rc, "conn-a", "show clock", &ConnectionACL{Owner: "apikey:bob", SharedWith: []string{}})
+
+	request := func(command string) CommandRequest {
+		return CommandRequest{Command: command, Cache: &CommandCacheOptions{MaxAgeSeconds: 60}}
+	}
+	if cached := rc.Fresh("conn-a", request("show version")); cached != nil {
+		t.Fatalf("reused another owner's entry: %+v", cached)
+	}
+	if cached := rc.Fresh("conn-a", request("show clock")); cached == nil || !cached.FromCache {
+		t.Fatalf("owner's entry not reused: %+v", cached)
+	}
+}
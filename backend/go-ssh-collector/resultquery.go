@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 历史结果查询：GET /results 按连接、host、命令子串或哈希、退出码、错误类别、时间范围和命名空间过滤结果存储，
+// 支持分页（page或cursor）、排序和fields选择返回的列（例如省略output、stderr）。
+// 除命令子串外的过滤条件都有对应的索引（connection_id、host、namespace、command_hash 各与 executed_at
+// 组成复合索引，见resultMigrations），命令子串需要扫描，应与时间范围或其他条件一起使用。
+// 启用连接ACL时按每条结果保存的属主和共享列表在查询中过滤，与连接当前是否存在无关；管理员不过滤。
+// PostgreSQL使用owner索引和shared_with的GIN索引，SQLite需要逐行展开shared_with
+
+const (
+	resultPageSizeDefault = 100
+	resultPageSizeMax     = 1000
+)
+
+var errResultStoreDisabled = errors.New("result store is not enabled")
+
+type resultColumnKind int
+
+const (
+	columnText resultColumnKind = iota
+	columnInt
+	columnBool
+	columnJSON
+	columnTime
+)
+
+// resultColumns 可以选择和排序的列，顺序即默认返回的顺序；internal的列只用于权限判断，不返回给调用方
+var resultColumns = []struct {
+	name     string
+	kind     resultColumnKind
+	sortable bool
+	internal bool
+}{
+	{"id", columnText, false, false},
+	{"connection_id", columnText, true, false},
+	{"namespace", columnText, true, false},
+	{"protocol", columnText, false, false},
+	{"host", columnText, true, false},
+	{"request_id", columnText, false, false},
+	{"command", columnText, false, false},
+	{"command_hash", columnText, false, false},
+	{"output", columnText, false, false},
+	{"stderr", columnText, false, false},
+	{"exit_code", columnInt, true, false},
+	{"truncated", columnBool, false, false},
+	{"error", columnText, false, false},
+	{"error_class", columnText, true, false},
+	{"parsed", columnJSON, false, false},
+	{"fields", columnJSON, false, false},
+	{"executed_at", columnTime, true, false},
+	{"owner", columnText, false, true},
+	{"shared_with", columnJSON, false, true},
+}
+
+func resultColumnInternal(name string) bool {
+	for _, column := range resultColumns {
+		if column.name == name {
+			return column.internal
+		}
+	}
+	return false
+}
+
+func resultColumnKindOf(name string) (resultColumnKind, bool) {
+	for _, column := range resultColumns {
+		if column.name == name {
+			return column.kind, true
+		}
+	}
+	return 0, false
+}
+
+type ResultQuery struct {
+	// ID 只在按ID读取时使用
+	ID           string
+	ConnectionID string
+	Host         string
+	Command      string
+	CommandHash  string
+	ExitCode     *int
+	ErrorClass   string
+	Namespace    string
+	Since        time.Time
+	Until        time.Time
+	Sort         string
+	Desc         bool
+	Columns      []string
+	Offset       int
+	PageSize     int
+	// Viewer 不为nil时只返回该调用方拥有或共享给它的结果，见resultViewer
+	Viewer *Principal
+}
+
+func parseResultQuery(query func(key string) string) (ResultQuery, error) {
+	q := ResultQuery{
+		ConnectionID: query("connection_id"),
+		Host:         query("host"),
+		Command:      query("command"),
+		CommandHash:  strings.ToLower(query("command_hash")),
+		ErrorClass:   query("error_class"),
+		Namespace:    query("namespace"),
+		Sort:         "executed_at",
+		Desc:         true,
+		PageSize:     resultPageSizeDefault,
+	}
+	if v := query("exit_code"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid exit_code: %s", v)
+		}
+		q.ExitCode = &code
+	}
+	for key, target := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := query(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %s", key, v)
+			}
+			*target = t
+		}
+	}
+	if v := query("sort"); v != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		sortable := false
+		for _, column := range resultColumns {
+			sortable = sortable || (column.name == q.Sort && column.sortable)
+		}
+		if !sortable {
+			return q, fmt.Errorf("invalid sort field: %s", q.Sort)
+		}
+	}
+	if v := query("fields"); v != "" {
+		for _, name := range splitList(v) {
+			if _, ok := resultColumnKindOf(name); !ok || resultColumnInternal(name) {
+				return q, fmt.Errorf("invalid field: %s", name)
+			}
+			if !containsString(q.Columns, name) {
+				q.Columns = append(q.Columns, name)
+			}
+		}
+	} else {
+		for _, column := range resultColumns {
+			if !column.internal {
+				q.Columns = append(q.Columns, column.name)
+			}
+		}
+	}
+	if v := query("page_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return q, fmt.Errorf("invalid page_size: %s", v)
+		}
+		if size > resultPageSizeMax {
+			size = resultPageSizeMax
+		}
+		q.PageSize = size
+	}
+	if cursor := query("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Offset = offset
+	} else if v := query("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page <= 0 {
+			return q, fmt.Errorf("invalid page: %s", v)
+		}
+		q.Offset = (page - 1) * q.PageSize
+	}
+	return q, nil
+}
+
+// likePattern 转义LIKE的通配符
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+// sql 列名和排序字段都来自resultColumns，其余条件均为参数；condition中的?依次对应args
+func (q ResultQuery) sql(driver string) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(condition string, values ...interface{}) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		where = append(where, condition)
+	}
+	if q.ID != "" {
+		add("id = ?", q.ID)
+	}
+	if q.ConnectionID != "" {
+		add("connection_id = ?", q.ConnectionID)
+	}
+	if q.Host != "" {
+		add("host = ?", q.Host)
+	}
+	if q.Namespace != "" {
+		add("namespace = ?", q.Namespace)
+	}
+	if q.CommandHash != "" {
+		add("command_hash = ?", q.CommandHash)
+	}
+	if q.Command != "" {
+		add(`command LIKE ? ESCAPE '\'`, likePattern(q.Command))
+	}
+	if q.ExitCode != nil {
+		add("exit_code = ?", *q.ExitCode)
+	}
+	if q.ErrorClass != "" {
+		add("error_class = ?", q.ErrorClass)
+	}
+	if !q.Since.IsZero() {
+		add("executed_at >= ?", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		add("executed_at < ?", q.Until.UTC())
+	}
+	if q.Viewer != nil {
+		condition, values := viewerCondition(driver, q.Viewer)
+		add(condition, values...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT %s FROM command_results", strings.Join(q.Columns, ", "))
+	if len(where) > 0 {
+		fmt.Fprintf(&b, " WHERE %s", strings.Join(where, " AND "))
+	}
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+	// id作为次序键，分页结果稳定
+	fmt.Fprintf(&b, " ORDER BY %s %s, id %s", q.Sort, direction, direction)
+	// 多取一条判断是否还有下一页
+	fmt.Fprintf(&b, " LIMIT %d OFFSET %d", q.PageSize+1, q.Offset)
+	return b.String(), args
+}
+
+// viewerCondition 属主为调用方或共享列表包含调用方的条目。PostgreSQL用@>以便使用GIN索引，
+// SQLite用json_each展开
+func viewerCondition(driver string, p *Principal) (string, []interface{}) {
+	grants := shareGrants(p)
+	args := []interface{}{p.Identity()}
+	var shared []string
+	for _, grant := range grants {
+		if driver == resultStorePostgres {
+			data, _ := json.Marshal([]string{grant})
+			args = append(args, string(data))
+			shared = append(shared, "shared_with @> ?::jsonb")
+		} else {
+			args = append(args, grant)
+			shared = append(shared, "?")
+		}
+	}
+	if driver == resultStorePostgres {
+		return "(owner = ? OR " + strings.Join(shared, " OR ") + ")", args
+	}
+	return "(owner = ? OR EXISTS (SELECT 1 FROM json_each(shared_with) WHERE value IN (" + strings.Join(shared, ", ") + ")))", args
+}
+
+type ResultPage struct {
+	Results    []map[string]interface{} `json:"results"`
+	Page       int                      `json:"page"`
+	PageSize   int                      `json:"page_size"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Timestamp  time.Time                `json:"timestamp"`
+}
+
+// scanResultRow 按列类型扫描一行，NULL的列不出现在结果中
+func scanResultRow(rows *sql.Rows, columns []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	for i, name := range columns {
+		kind, _ := resultColumnKindOf(name)
+		switch kind {
+		case columnText, columnJSON:
+			values[i] = new(sql.NullString)
+		case columnInt:
+			values[i] = new(sql.NullInt64)
+		case columnBool:
+			values[i] = new(sql.NullBool)
+		case columnTime:
+			values[i] = new(sql.NullTime)
+		}
+	}
+	if err := rows.Scan(values...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, name := range columns {
+		kind, _ := resultColumnKindOf(name)
+		switch v := values[i].(type) {
+		case *sql.NullString:
+			if !v.Valid {
+				continue
+			}
+			if kind == columnJSON {
+				row[name] = json.RawMessage(v.String)
+			} else {
+				row[name] = v.String
+			}
+		case *sql.NullInt64:
+			if v.Valid {
+				row[name] = v.Int64
+			}
+		case *sql.NullBool:
+			if v.Valid {
+				row[name] = v.Bool
+			}
+		case *sql.NullTime:
+			if v.Valid {
+				row[name] = v.Time
+			}
+		}
+	}
+	return row, nil
+}
+
+func (s *ResultStore) Query(ctx context.Context, q ResultQuery) (ResultPage, error) {
+	page := ResultPage{
+		Results:   []map[string]interface{}{},
+		Page:      q.Offset/q.PageSize + 1,
+		PageSize:  q.PageSize,
+		Timestamp: time.Now(),
+	}
+	query, args := q.sql(s.driver)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		if n++; n > q.PageSize {
+			page.NextCursor = encodeCursor(q.Offset + q.PageSize)
+			break
+		}
+		row, err := scanResultRow(rows, q.Columns)
+		if err != nil {
+			return page, err
+		}
+		page.Results = append(page.Results, row)
+	}
+	return page, rows.Err()
+}
+
+// Get 从存储中读取完整的结果，包括parsed、fields和保存的属主
+func (s *ResultStore) Get(ctx context.Context, id string) (ResultRecord, error) {
+	q := ResultQuery{ID: id, Sort: "executed_at", PageSize: 1}
+	for _, column := range resultColumns {
+		q.Columns = append(q.Columns, column.name)
+	}
+	query, args := q.sql(s.driver)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ResultRecord{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return ResultRecord{}, err
+		}
+		return ResultRecord{}, errResultNotFound
+	}
+	row, err := scanResultRow(rows, q.Columns)
+	if err != nil {
+		return ResultRecord{}, err
+	}
+	text := func(name string) string {
+		s, _ := row[name].(string)
+		return s
+	}
+	result := &CommandResult{
+		ID:         text("id"),
+		Command:    text("command"),
+		Output:     text("output"),
+		Stderr:     text("stderr"),
+		Error:      text("error"),
+		ErrorClass: text("error_class"),
+		RequestID:  text("request_id"),
+	}
+	result.Truncated, _ = row["truncated"].(bool)
+	result.Timestamp, _ = row["executed_at"].(time.Time)
+	if code, ok := row["exit_code"].(int64); ok {
+		exitCode := int(code)
+		result.ExitCode = &exitCode
+	}
+	if parsed, ok := row["parsed"].(json.RawMessage); ok {
+		if err := json.Unmarshal(parsed, &result.Parsed); err != nil {
+			return ResultRecord{}, fmt.Errorf("result %s parsed: %w", id, err)
+		}
+	}
+	record := ResultRecord{ConnectionID: text("connection_id"), Protocol: text("protocol"), Host: text("host"), Result: result}
+	if fields, ok := row["fields"].(json.RawMessage); ok {
+		if err := json.Unmarshal(fields, &record.Fields); err != nil {
+			return ResultRecord{}, fmt.Errorf("result %s fields: %w", id, err)
+		}
+	}
+	result.Fields = record.Fields
+	if owner := text("owner"); owner != "" {
+		record.acl = &ConnectionACL{Owner: owner, Namespace: text("namespace"), SharedWith: []string{}}
+		if shared, ok := row["shared_with"].(json.RawMessage); ok {
+			if err := json.Unmarshal(shared, &record.acl.SharedWith); err != nil {
+				return ResultRecord{}, fmt.Errorf("result %s shared_with: %w", id, err)
+			}
+		}
+	}
+	return record, nil
+}
+
+func registerResultQueryRoutes(r *gin.Engine) {
+	r.GET("/results", func(c *gin.Context) {
+		if resultStore == nil {
+			respondError(c, http.StatusServiceUnavailable, errResultStoreDisabled)
+			return
+		}
+		q, err := parseResultQuery(c.Query)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		q.Viewer = resultViewer(currentPrincipal(c))
+		page, err := resultStore.Query(c.Request.Context(), q)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	})
+}
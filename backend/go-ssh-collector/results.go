@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errResultNotFound = errors.New("result not found")
+
+// 内存中保留的最近执行结果数，用于按ID取回和diff，0表示不保留
+var resultHistorySize = int(envInt64("RESULT_HISTORY_SIZE", 1000))
+
+type ResultHistory struct {
+	records map[string]ResultRecord
+	order   []string
+	mutex   sync.RWMutex
+}
+
+var results = &ResultHistory{records: make(map[string]ResultRecord)}
+
+func (h *ResultHistory) Add(record ResultRecord) {
+	if resultHistorySize <= 0 {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.records[record.Result.ID] = record
+	h.order = append(h.order, record.Result.ID)
+	for len(h.order) > resultHistorySize {
+		delete(h.records, h.order[0])
+		h.order = h.order[1:]
+	}
+}
+
+func (h *ResultHistory) Get(id string) (ResultRecord, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	record, ok := h.records[id]
+	if !ok {
+		return ResultRecord{}, errResultNotFound
+	}
+	return record, nil
+}
+
+// Latest 返回连接上某条命令（按command_hash）viewer可见的最近结果，viewer为nil时不过滤
+func (h *ResultHistory) Latest(connectionID, hash string, viewer *Principal) (ResultRecord, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for i := len(h.order) - 1; i >= 0; i-- {
+		record := h.records[h.order[i]]
+		if record.ConnectionID == connectionID && commandHash(record.Result.Command) == hash && recordAllowed(viewer, record.acl) {
+			return record, nil
+		}
+	}
+	return ResultRecord{}, errResultNotFound
+}
+
+func registerResultRoutes(r *gin.Engine) {
+	r.GET("/results/:id", func(c *gin.Context) {
+		record, err := results.Get(c.Param("id"))
+		// 内存中已淘汰的结果从结果存储读取
+		if err != nil && resultStore != nil {
+			record, err = resultStore.Get(c.Request.Context(), c.Param("id"))
+		}
+		if errors.Is(err, errResultNotFound) {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if p := currentPrincipal(c); !recordAllowed(p, record.acl) {
+			denyRequest(c, p, permRead, "connection not shared with caller")
+			return
+		}
+		if wantsCSV(c) {
+			respondResultCSV(c, record.Result)
+			return
+		}
+		c.JSON(http.StatusOK, requestAPIVersion(c).ResultRecord(record))
+	})
+
+	// 对比两次结果，to与text二选一
+	r.POST("/results/diff", func(c *gin.Context) {
+		var body struct {
+			From string  `json:"from" binding:"required"`
+			To   string  `json:"to"`
+			Text *string `json:"text"`
+			DiffOptions
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if (body.To == "") == (body.Text == nil) {
+			respondError(c, http.StatusBadRequest, errors.New("exactly one of to or text is required"))
+			return
+		}
+		from, err := results.Get(body.From)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		if p := currentPrincipal(c); !recordAllowed(p, from.acl) {
+			denyRequest(c, p, permRead, "connection not shared with caller")
+			return
+		}
+		to := &CommandResult{Command: from.Result.Command}
+		toName := "text"
+		if body.Text != nil {
+			to.Output = *body.Text
+		} else {
+			record, err := results.Get(body.To)
+			if err != nil {
+				respondError(c, http.StatusNotFound, err)
+				return
+			}
+			if p := currentPrincipal(c); !recordAllowed(p, record.acl) {
+				denyRequest(c, p, permRead, "connection not shared with caller")
+				return
+			}
+			to, toName = record.Result, body.To
+		}
+		diff, err := DiffResults(from.Result, to, body.From, toName, body.DiffOptions)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, diff)
+	})
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// 结果存储：RESULT_STORE=sqlite（嵌入式，RESULT_STORE_DSN为文件路径，默认data/results.db）
+// 或 postgres（RESULT_STORE_DSN为连接串，共享部署时使用）时把每条CommandResult连同连接信息、请求ID、
+// parsed（JSON）写入command_results表，按连接、命令哈希和时间建索引。
+// 写入经有界队列（RESULT_STORE_QUEUE）异步批量提交，数据库变慢时丢弃并计数，不阻塞执行。
+// 启动时自动执行迁移，已执行的版本记录在schema_version表中。
+// DSN包含密码时可用 RESULT_STORE_DSN_REF 引用凭据（env:、file:、cred:）。
+// 每条结果保存执行时连接的属主（owner）和共享列表（shared_with），启用连接ACL时按它们过滤；
+// 增加这两列之前写入的结果没有属主，只有管理员可见。
+// idempotency_keys表保存幂等键的响应，多副本和重启后共享
+
+const (
+	resultStoreSQLite   = "sqlite"
+	resultStorePostgres = "postgres"
+)
+
+var (
+	resultStoreDriver = getEnv("RESULT_STORE", "")
+	resultStoreQueue  = int(envInt64("RESULT_STORE_QUEUE", 1000))
+	resultStoreBatch  = int(envInt64("RESULT_STORE_BATCH", 100))
+)
+
+// resultMigrations 按版本顺序执行，已发布的迁移不能修改，只能追加
+var resultMigrations = map[string][]string{
+	resultStoreSQLite: {
+		`CREATE TABLE command_results (
+			id TEXT PRIMARY KEY,
+			connection_id TEXT NOT NULL,
+			namespace TEXT NOT NULL DEFAULT '',
+			protocol TEXT NOT NULL DEFAULT '',
+			host TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			command TEXT NOT NULL,
+			command_hash TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			stderr TEXT NOT NULL DEFAULT '',
+			exit_code INTEGER,
+			truncated INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			error_class TEXT NOT NULL DEFAULT '',
+			parsed TEXT,
+			fields TEXT,
+			executed_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX command_results_connection ON command_results (connection_id, executed_at);
+		CREATE INDEX command_results_command_hash ON command_results (command_hash, executed_at);
+		CREATE INDEX command_results_executed_at ON command_results (executed_at);`,
+		`CREATE TABLE idempotency_keys (
+			key TEXT PRIMARY KEY,
+			fingerprint TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			content_type TEXT NOT NULL DEFAULT '',
+			body BLOB,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);`,
+		`CREATE INDEX command_results_host ON command_results (host, executed_at);
+		CREATE INDEX command_results_namespace ON command_results (namespace, executed_at);`,
+		`ALTER TABLE command_results ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+		ALTER TABLE command_results ADD COLUMN shared_with TEXT NOT NULL DEFAULT '[]';`,
+	},
+	resultStorePostgres: {
+		`CREATE TABLE command_results (
+			id TEXT PRIMARY KEY,
+			connection_id TEXT NOT NULL,
+			namespace TEXT NOT NULL DEFAULT '',
+			protocol TEXT NOT NULL DEFAULT '',
+			host TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			command TEXT NOT NULL,
+			command_hash TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			stderr TEXT NOT NULL DEFAULT '',
+			exit_code INTEGER,
+			truncated BOOLEAN NOT NULL DEFAULT FALSE,
+			error TEXT NOT NULL DEFAULT '',
+			error_class TEXT NOT NULL DEFAULT '',
+			parsed JSONB,
+			fields JSONB,
+			executed_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX command_results_connection ON command_results (connection_id, executed_at);
+		CREATE INDEX command_results_command_hash ON command_results (command_hash, executed_at);
+		CREATE INDEX command_results_executed_at ON command_results (executed_at);`,
+		`CREATE TABLE idempotency_keys (
+			key TEXT PRIMARY KEY,
+			fingerprint TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			content_type TEXT NOT NULL DEFAULT '',
+			body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);`,
+		`CREATE INDEX command_results_host ON command_results (host, executed_at);
+		CREATE INDEX command_results_namespace ON command_results (namespace, executed_at);`,
+		`ALTER TABLE command_results ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+		ALTER TABLE command_results ADD COLUMN shared_with JSONB NOT NULL DEFAULT '[]';
+		CREATE INDEX command_results_owner ON command_results (owner, executed_at);
+		CREATE INDEX command_results_shared_with ON command_results USING GIN (shared_with jsonb_path_ops);`,
+	},
+}
+
+// storedResult 入队时的快照，之后结果对象的修改不影响写入
+type storedResult struct {
+	ResultRecord
+	namespace string
+}
+
+type ResultStore struct {
+	driver string
+	db     *sql.DB
+	// reader 用于导出等长时间的读取；SQLite的db只有一个连接，流式导出不应阻塞写入
+	reader  *sql.DB
+	queue   chan storedResult
+	written uint64
+	dropped uint64
+	failed  uint64
+	// idempotent 幂等键的写入队列，由runIdempotent消费
+	idempotent        chan storedIdempotent
+	idempotentDropped uint64
+	idempotentFailed  uint64
+	// lastError 最近一次写入失败的原因
+	lastError atomic.Value
+}
+
+// resultStore 未启用时为nil，Enqueue可直接调用
+var resultStore *ResultStore
+
+func openResultStore(driver string) (*ResultStore, error) {
+	dsn, err := credentialValue(getEnv("RESULT_STORE_DSN", ""), getEnv("RESULT_STORE_DSN_REF", ""))
+	if err != nil {
+		return nil, err
+	}
+	var db, reader *sql.DB
+	switch driver {
+	case resultStoreSQLite:
+		if dsn == "" {
+			dsn = filepath.Join("data", "results.db")
+		}
+		if err := os.MkdirAll(filepath.Dir(dsn), 0755); err != nil {
+			return nil, err
+		}
+		db, err = sql.Open("sqlite", dsn+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+		// 单个写入goroutine，避免SQLite的写锁竞争
+		if err == nil {
+			db.SetMaxOpenConns(1)
+			// WAL模式下读取不阻塞写入
+			reader, err = sql.Open("sqlite", dsn+"?_pragma=busy_timeout(5000)&_pragma=query_only(1)")
+		}
+	case resultStorePostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("RESULT_STORE_DSN is required for postgres")
+		}
+		db, err = sql.Open("postgres", dsn)
+		reader = db
+	default:
+		return nil, fmt.Errorf("RESULT_STORE must be sqlite or postgres, got %q", driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := &ResultStore{driver: driver, db: db, reader: reader,
+		queue: make(chan storedResult, resultStoreQueue), idempotent: make(chan storedIdempotent, resultStoreQueue)}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := store.migrate(ctx); err != nil {
+		db.Close()
+		reader.Close()
+		return nil, fmt.Errorf("migrate result store: %w", err)
+	}
+	return store, nil
+}
+
+// migrate 每个版本在单独的事务中执行并记录到schema_version
+func (s *ResultStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return err
+	}
+	migrations := resultMigrations[s.driver]
+	for version := current + 1; version <= len(migrations); version++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version, applied_at) VALUES ($1, $2)`, version, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+		logInfo("result_store.migrated", "result store migrated", "schema_version", version)
+	}
+	return nil
+}
+
+// Enqueue 在publishResult中调用，队列满时丢弃
+func (s *ResultStore) Enqueue(record ResultRecord, namespace string) {
+	if s == nil {
+		return
+	}
+	result := *record.Result
+	record.Result = &result
+	select {
+	case s.queue <- storedResult{record, namespace}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// run 取出队列中已有的记录合并为一个事务，最多resultStoreBatch条
+func (s *ResultStore) run() {
+	for first := range s.queue {
+		batch := []storedResult{first}
+	fill:
+		for len(batch) < resultStoreBatch {
+			select {
+			case next := <-s.queue:
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		if err := s.write(batch); err != nil {
+			atomic.AddUint64(&s.failed, uint64(len(batch)))
+			s.lastError.Store(redact(err.Error()))
+			logWarn("result_store", "result store write failed", "results", len(batch), "error", redact(err.Error()))
+			continue
+		}
+		atomic.AddUint64(&s.written, uint64(len(batch)))
+	}
+}
+
+func commandHash(command string) string {
+	sum := sha256.Sum256([]byte(command))
+	return hex.EncodeToString(sum[:])
+}
+
+// jsonColumn nil写入NULL
+func jsonColumn(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (s *ResultStore) write(batch []storedResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO command_results
+		(id, connection_id, namespace, protocol, host, request_id, command, command_hash, output, stderr,
+		 exit_code, truncated, error, error_class, parsed, fields, executed_at, owner, shared_with)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		ON CONFLICT (id) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, item := range batch {
+		result := item.Result
+		parsed, err := jsonColumn(result.Parsed)
+		if err != nil {
+			return fmt.Errorf("result %s parsed: %w", result.ID, err)
+		}
+		var fields interface{}
+		if len(item.Fields) > 0 {
+			if fields, err = jsonColumn(item.Fields); err != nil {
+				return fmt.Errorf("result %s fields: %w", result.ID, err)
+			}
+		}
+		var exitCode interface{}
+		if result.ExitCode != nil {
+			exitCode = *result.ExitCode
+		}
+		owner, sharedWith := "", []string{}
+		if item.acl != nil {
+			owner, sharedWith = item.acl.Owner, append(sharedWith, item.acl.SharedWith...)
+		}
+		shared, err := jsonColumn(sharedWith)
+		if err != nil {
+			return fmt.Errorf("result %s shared_with: %w", result.ID, err)
+		}
+		executedAt := result.Timestamp
+		if executedAt.IsZero() {
+			executedAt = time.Now()
+		}
+		if _, err := stmt.ExecContext(ctx, result.ID, item.ConnectionID, item.namespace, item.Protocol, item.Host,
+			result.RequestID, result.Command, commandHash(result.Command), result.Output, result.Stderr,
+			exitCode, result.Truncated, result.Error, result.ErrorClass, parsed, fields, executedAt.UTC(), owner, shared); err != nil {
+			return fmt.Errorf("result %s: %w", result.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *ResultStore) Stats() map[string]interface{} {
+	if s == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	stats := map[string]interface{}{
+		"enabled": true,
+		"driver":  s.driver,
+		"queued":  len(s.queue),
+		"written": atomic.LoadUint64(&s.written),
+		"dropped": atomic.LoadUint64(&s.dropped),
+		"failed":  atomic.LoadUint64(&s.failed),
+		// 幂等键的写入与结果分开计数
+		"idempotency_dropped": atomic.LoadUint64(&s.idempotentDropped),
+		"idempotency_failed":  atomic.LoadUint64(&s.idempotentFailed),
+	}
+	if lastError, ok := s.lastError.Load().(string); ok {
+		stats["last_error"] = lastError
+	}
+	return stats
+}
+
+func (s *ResultStore) WriteMetrics(w io.Writer) {
+	if s == nil {
+		return
+	}
+	out := metricWriter{w}
+	out.family("collector_result_store_writes_total", "counter", "Results written to, dropped by or failed in the result store.")
+	out.sample("collector_result_store_writes_total", float64(atomic.LoadUint64(&s.written)), "result", "written")
+	out.sample("collector_result_store_writes_total", float64(atomic.LoadUint64(&s.dropped)), "result", "dropped")
+	out.sample("collector_result_store_writes_total", float64(atomic.LoadUint64(&s.failed)), "result", "failed")
+	out.family("collector_result_store_queue", "gauge", "Results waiting to be written to the result store.")
+	out.sample("collector_result_store_queue", float64(len(s.queue)))
+}
+
+// startResultStore 在main中接收请求前调用，迁移失败时拒绝启动
+func startResultStore() {
+	if resultStoreDriver == "" {
+		return
+	}
+	store, err := openResultStore(resultStoreDriver)
+	if err != nil {
+		logFatal("result_store.open_failed", "open result store failed", "driver", resultStoreDriver, "error", err)
+	}
+	resultStore = store
+	go store.run()
+	go store.runIdempotent()
+	registerReadinessCheck("result_store", func(ctx context.Context) error {
+		return store.db.PingContext(ctx)
+	})
+	logInfo("result_store.start", "persisting command results", "driver", store.driver)
+}
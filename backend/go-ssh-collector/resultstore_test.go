@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useResultStore 测试期间使用新的结果存储，写入由测试直接调用write完成
+func useResultStore(t *testing.T, driver, dsn string) *ResultStore {
+	t.Helper()
+	t.Setenv("RESULT_STORE_DSN", dsn)
+	store, err := openResultStore(driver)
+	if err != nil {
+		t.Fatalf("open %s result store: %v", driver, err)
+	}
+	previous := resultStore
+	resultStore = store
+	t.Cleanup(func() {
+		resultStore = previous
+		store.db.Close()
+		store.reader.Close()
+	})
+	return store
+}
+
+// storeOwnedResults 写入alice的结果（共享给bob和ops命名空间）、carol的结果和迁移前没有属主的结果
+func storeOwnedResults(t *testing.T, store *ResultStore) {
+	t.Helper()
+	now := time.Now()
+	record := func(id, connectionID string, acl *ConnectionACL, age time.Duration) storedResult {
+		return storedResult{ResultRecord{
+			ConnectionID: connectionID, Protocol: "ssh", Host: "10.0.0.1",
+			Result: &CommandResult{ID: id, Command: "show version", Output: id, Timestamp: now.Add(-age)},
+			acl:    acl,
+		}, ""}
+	}
+	batch := []storedResult{
+		record("alice-1", "conn-a", &ConnectionACL{Owner: "apikey:alice", SharedWith: []string{"apikey:bob"}}, 3*time.Second),
+		record("alice-2", "conn-a", &ConnectionACL{Owner: "apikey:alice", Namespace: "ops", SharedWith: []string{"namespace:ops"}}, 2*time.Second),
+		record("carol-1", "conn-c", &ConnectionACL{Owner: "apikey:carol", SharedWith: []string{}}, time.Second),
+		record("legacy-1", "conn-a", nil, 4*time.Second),
+	}
+	if err := store.write(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func resultIDs(t *testing.T, r http.Handler, key, path string) []string {
+	t.Helper()
+	w := apiRequest(r, key, http.MethodGet, path, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, w.Code, w.Body.String())
+	}
+	var page struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, row := range page.Results {
+		if _, ok := row["owner"]; ok {
+			t.Fatalf("internal column returned: %v", row)
+		}
+		ids = append(ids, row["id"].(string))
+	}
+	return ids
+}
+
+// 结果按写入时保存的属主过滤：连接已断开（没有ACL记录）后仍对原属主可见，没有属主的旧结果只有管理员可见
+func TestResultQueryFiltersByStoredOwner(t *testing.T) {
+	enableConnectionACL(t)
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	storeOwnedResults(t, store)
+	keys := map[string]string{
+		"alice": useAPIKey(t, "alice", roleViewer),
+		"bob":   useAPIKey(t, "bob", roleViewer),
+		"carol": useAPIKey(t, "carol", roleViewer),
+		"dave":  useAPIKey(t, "dave", roleViewer),
+		"admin": useAPIKey(t, "admin", roleAdmin),
+	}
+	r := newRouter()
+
+	want := map[string]string{
+		"alice": "alice-2,alice-1",
+		"bob":   "alice-1",
+		"carol": "carol-1",
+		"dave":  "",
+		"admin": "carol-1,alice-2,alice-1,legacy-1",
+	}
+	for name, ids := range want {
+		// 每页都是调用方可见的结果，不因过滤而变少
+		if got := strings.Join(resultIDs(t, r, keys[name], "/results?sort=-executed_at&page_size=10"), ","); got != ids {
+			t.Errorf("%s sees %q, want %q", name, got, ids)
+		}
+	}
+	if got := resultIDs(t, r, keys["alice"], "/results?sort=-executed_at&page_size=1"); len(got) != 1 || got[0] != "alice-2" {
+		t.Errorf("alice first page = %v", got)
+	}
+	if w := apiRequest(r, keys["alice"], http.MethodGet, "/results?fields=id,owner", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("selecting owner: status %d", w.Code)
+	}
+
+	for _, tc := range []struct {
+		name, id string
+		code     int
+	}{
+		{"alice", "alice-1", http.StatusOK},
+		{"bob", "alice-1", http.StatusOK},
+		{"bob", "alice-2", http.StatusForbidden},
+		{"carol", "alice-1", http.StatusForbidden},
+		{"alice", "legacy-1", http.StatusForbidden},
+		{"admin", "legacy-1", http.StatusOK},
+	} {
+		if w := apiRequest(r, keys[tc.name], http.MethodGet, "/results/"+tc.id, ""); w.Code != tc.code {
+			t.Errorf("%s GET /results/%s: status %d, want %d", tc.name, tc.id, w.Code, tc.code)
+		}
+	}
+}
+
+// 导出与查询一样按保存的属主过滤，连接ACL记录已不存在时也不放行
+func TestResultExportFiltersByStoredOwner(t *testing.T) {
+	enableConnectionACL(t)
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	storeOwnedResults(t, store)
+	r := newRouter()
+
+	for _, tc := range []struct {
+		name, role, want string
+	}{
+		{"bob", roleViewer, "alice-1"},
+		{"dave", roleViewer, ""},
+		{"admin", roleAdmin, "legacy-1,alice-1,alice-2,carol-1"},
+	} {
+		key := useAPIKey(t, tc.name, tc.role)
+		w := apiRequest(r, key, http.MethodGet, "/results/export?fields=id&sort=executed_at", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tc.name, w.Code, w.Body.String())
+		}
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var row map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("%s: %q: %v", tc.name, line, err)
+			}
+			if len(row) != 1 {
+				t.Errorf("%s: row has extra columns: %v", tc.name, row)
+			}
+			ids = append(ids, row["id"].(string))
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Errorf("%s exported %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// 命名空间共享按 namespace:<ns> 匹配
+func TestResultQueryNamespaceShare(t *testing.T) {
+	enableConnectionACL(t)
+	store := useResultStore(t, resultStoreSQLite, filepath.Join(t.TempDir(), "results.db"))
+	storeOwnedResults(t, store)
+
+	q := ResultQuery{Columns: []string{"id"}, Sort: "executed_at", PageSize: 10, Viewer: &Principal{Name: "erin", Role: roleViewer, Namespace: "ops"}}
+	page, err := store.Query(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0]["id"] != "alice-2" {
+		t.Fatalf("results = %v", page.Results)
+	}
+}
+
+// PostgreSQL中常用的过滤条件各自使用对应的索引，按属主过滤时使用owner索引和shared_with的GIN索引。
+// 需要 RESULT_STORE_TEST_POSTGRES_DSN 指向可建表的测试库，未设置时跳过
+func TestResultQueryViewerUsesPostgresIndexes(t *testing.T) {
+	dsn := os.Getenv("RESULT_STORE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("RESULT_STORE_TEST_POSTGRES_DSN not set")
+	}
+	store := useResultStore(t, resultStorePostgres, dsn)
+	ctx := context.Background()
+	conn, err := store.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 测试表中行数很少，关闭顺序扫描和普通索引扫描，确认条件本身可以由索引满足
+	for _, setting := range []string{"SET enable_seqscan = off", "SET enable_indexscan = off"} {
+		if _, err := conn.ExecContext(ctx, setting); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	cases := []struct {
+		name    string
+		query   ResultQuery
+		indexes []string
+	}{
+		{"connection", ResultQuery{ConnectionID: "conn-a"}, []string{"command_results_connection"}},
+		{"host", ResultQuery{Host: "10.0.0.1"}, []string{"command_results_host"}},
+		{"command hash", ResultQuery{CommandHash: commandHash("show version")}, []string{"command_results_command_hash"}},
+		{"time range", ResultQuery{Since: now.Add(-time.Hour), Until: now}, []string{"command_results_executed_at"}},
+		{"namespace", ResultQuery{Namespace: "ops"}, []string{"command_results_namespace"}},
+		{"viewer", ResultQuery{Viewer: &Principal{Name: "alice", Role: roleViewer, Namespace: "ops"}}, []string{"command_results_owner", "command_results_shared_with"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := tc.query
+			q.Columns, q.Sort, q.Desc, q.PageSize = []string{"id"}, "executed_at", true, 50
+			query, args := q.sql(resultStorePostgres)
+			rows, err := conn.QueryContext(ctx, "EXPLAIN "+query, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var plan []string
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, line)
+			}
+			text := strings.Join(plan, "\n")
+			for _, index := range tc.indexes {
+				// 名称后跟cost，避免前缀相同的索引名误匹配
+				if !strings.Contains(text, "Bitmap Index Scan on "+index+" ") {
+					t.Errorf("plan missing %q:\n%s", index, text)
+				}
+			}
+			if strings.Contains(text, "Seq Scan") {
+				t.Errorf("plan uses a sequential scan:\n%s", text)
+			}
+		})
+	}
+}
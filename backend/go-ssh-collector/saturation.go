@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 饱和度：goroutine数、打开的SSH会话（按用途）、每个连接上排队/执行中的命令数、任务队列长度、
+// 异步上传暂存文件占用的磁盘和内存，以 collector_saturation_* 指标输出，并记录启动以来的最高值。
+// 每秒采样一次，会话数在打开时即时更新最高值。
+// 配置了 SATURATION_MAX_* 阈值（0为不限制）时超过即不再就绪（/readyz 的 saturation 检查），
+// 让负载均衡把流量转到其他实例
+
+var saturationLimits = map[string]int64{
+	"goroutines":             envInt64("SATURATION_MAX_GOROUTINES", 0),
+	"ssh_sessions":           envInt64("SATURATION_MAX_SSH_SESSIONS", 0),
+	"connection_queue_depth": envInt64("SATURATION_MAX_CONNECTION_QUEUE", 0),
+	"job_queue":              envInt64("SATURATION_MAX_JOB_QUEUE", 0),
+	"spool_bytes":            envInt64("SATURATION_MAX_SPOOL_MB", 0) << 20,
+	"heap_inuse_bytes":       envInt64("SATURATION_MAX_HEAP_MB", 0) << 20,
+}
+
+// SSH会话的用途
+const (
+	sessionExec     = "exec"
+	sessionShell    = "shell"
+	sessionNetconf  = "netconf"
+	sessionTail     = "tail"
+	sessionChecksum = "checksum"
+)
+
+var saturation = struct {
+	sessions  map[string]int64
+	highWater map[string]int64
+	mutex     sync.Mutex
+}{sessions: make(map[string]int64), highWater: make(map[string]int64)}
+
+// spoolBytes 异步上传暂存在本地的字节数
+var spoolBytes int64
+
+func spoolAdd(n int64) {
+	observeHighWater("spool_bytes", atomic.AddInt64(&spoolBytes, n))
+}
+
+// trackSession 在SSH会话建立后调用，返回的release可重复调用，只计一次
+func trackSession(kind string) func() {
+	saturation.mutex.Lock()
+	saturation.sessions[kind]++
+	total := int64(0)
+	for _, n := range saturation.sessions {
+		total += n
+	}
+	if total > saturation.highWater["ssh_sessions"] {
+		saturation.highWater["ssh_sessions"] = total
+	}
+	saturation.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			saturation.mutex.Lock()
+			saturation.sessions[kind]--
+			saturation.mutex.Unlock()
+		})
+	}
+}
+
+func observeHighWater(resource string, value int64) {
+	saturation.mutex.Lock()
+	defer saturation.mutex.Unlock()
+	if value > saturation.highWater[resource] {
+		saturation.highWater[resource] = value
+	}
+}
+
+// connectionQueueDepths 每个连接上正在执行和等待会话的命令数
+func connectionQueueDepths() map[string]int {
+	collector.mutex.RLock()
+	defer collector.mutex.RUnlock()
+	depths := make(map[string]int, len(collector.meta))
+	for id, meta := range collector.meta {
+		if meta.Inflight > 0 {
+			depths[id] = meta.Inflight
+		}
+	}
+	return depths
+}
+
+// saturationSnapshot 当前值，内存统计会短暂停止所有goroutine，只在采样和输出时读取
+func saturationSnapshot() map[string]int64 {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	saturation.mutex.Lock()
+	sessions := int64(0)
+	for _, n := range saturation.sessions {
+		sessions += n
+	}
+	saturation.mutex.Unlock()
+
+	maxDepth := 0
+	for _, depth := range connectionQueueDepths() {
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return map[string]int64{
+		"goroutines":             int64(runtime.NumGoroutine()),
+		"ssh_sessions":           sessions,
+		"inflight_commands":      collector.Inflight(),
+		"connection_queue_depth": int64(maxDepth),
+		"job_queue":              int64(jobs.Stats()[JobPending]),
+		"spool_bytes":            atomic.LoadInt64(&spoolBytes),
+		"heap_alloc_bytes":       int64(mem.HeapAlloc),
+		"heap_inuse_bytes":       int64(mem.HeapInuse),
+		"stack_inuse_bytes":      int64(mem.StackInuse),
+		"sys_bytes":              int64(mem.Sys),
+	}
+}
+
+// sampleSaturation 更新最高值并返回当前值
+func sampleSaturation() map[string]int64 {
+	current := saturationSnapshot()
+	saturation.mutex.Lock()
+	defer saturation.mutex.Unlock()
+	for resource, value := range current {
+		if value > saturation.highWater[resource] {
+			saturation.highWater[resource] = value
+		}
+	}
+	return current
+}
+
+func saturationHighWater() map[string]int64 {
+	saturation.mutex.Lock()
+	defer saturation.mutex.Unlock()
+	highWater := make(map[string]int64, len(saturation.highWater))
+	for resource, value := range saturation.highWater {
+		highWater[resource] = value
+	}
+	return highWater
+}
+
+// checkSaturation 返回所有超过阈值的资源
+func checkSaturation(current map[string]int64) error {
+	var exceeded []string
+	for resource, limit := range saturationLimits {
+		if limit > 0 && current[resource] >= limit {
+			exceeded = append(exceeded, fmt.Sprintf("%s %d (limit %d)", resource, current[resource], limit))
+		}
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	sort.Strings(exceeded)
+	return fmt.Errorf("saturated: %s", strings.Join(exceeded, ", "))
+}
+
+// saturationSummary /health 中的饱和度
+func saturationSummary() map[string]interface{} {
+	current := sampleSaturation()
+	limits := map[string]int64{}
+	for resource, limit := range saturationLimits {
+		if limit > 0 {
+			limits[resource] = limit
+		}
+	}
+	summary := map[string]interface{}{"current": current, "high_water": saturationHighWater(), "limits": limits}
+	if err := checkSaturation(current); err != nil {
+		summary["saturated"] = err.Error()
+	}
+	return summary
+}
+
+func writeSaturationMetrics(w io.Writer) {
+	current := sampleSaturation()
+	resources := resourceNames(current)
+	out := metricWriter{w}
+	out.family("collector_saturation", "gauge", "Current resource usage: goroutines, SSH sessions, queues, spool and memory bytes.")
+	for _, resource := range resources {
+		out.sample("collector_saturation", float64(current[resource]), "resource", resource)
+	}
+	highWater := saturationHighWater()
+	out.family("collector_saturation_high_water", "gauge", "Highest resource usage since start.")
+	for _, resource := range resourceNames(highWater) {
+		out.sample("collector_saturation_high_water", float64(highWater[resource]), "resource", resource)
+	}
+
+	saturation.mutex.Lock()
+	sessions := make(map[string]int64, len(saturation.sessions))
+	for kind, n := range saturation.sessions {
+		sessions[kind] = n
+	}
+	saturation.mutex.Unlock()
+	out.family("collector_ssh_sessions", "gauge", "Open SSH sessions by purpose.")
+	for _, kind := range resourceNames(sessions) {
+		out.sample("collector_ssh_sessions", float64(sessions[kind]), "kind", kind)
+	}
+
+	// 按host汇总，避免连接ID（含用户名）成为标签
+	type queued struct {
+		protocol, host string
+		depth          int
+	}
+	var queues []queued
+	collector.mutex.RLock()
+	for id, meta := range collector.meta {
+		if conn, ok := collector.connections[id]; ok && meta.Inflight > 0 {
+			queues = append(queues, queued{conn.Info().Protocol, conn.Info().Host, meta.Inflight})
+		}
+	}
+	collector.mutex.RUnlock()
+	depths := map[[2]string]int{}
+	serviceStats.mutex.Lock()
+	for _, q := range queues {
+		depths[[2]string{q.protocol, serviceStats.hostLabel(q.host)}] += q.depth
+	}
+	serviceStats.mutex.Unlock()
+	keys := make([][2]string, 0, len(depths))
+	for key := range depths {
+		keys = append(keys, key)
+	}
+	sortedPairs(keys)
+	out.family("collector_connection_queue_depth", "gauge", "Commands executing or waiting for a session, by protocol and device host.")
+	for _, key := range keys {
+		out.sample("collector_connection_queue_depth", float64(depths[key]), "protocol", key[0], "host", key[1])
+	}
+}
+
+func resourceNames(values map[string]int64) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startSaturationSampler 在collector创建后调用
+func startSaturationSampler() {
+	go func() {
+		for range time.Tick(time.Second) {
+			sampleSaturation()
+		}
+	}()
+}
+
+func init() {
+	registerReadinessCheck("saturation", func(ctx context.Context) error {
+		return checkSaturation(saturationSnapshot())
+	})
+}
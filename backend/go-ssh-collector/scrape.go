@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scrapeDefaultTarget = "localhost:9100"
+	scrapeDefaultPath   = "/metrics"
+	scrapeMinInterval   = 5 * time.Second
+)
+
+var (
+	errScrapeNotFound  = errors.New("scrape not found")
+	errScrapeDenied    = errors.New("scrape connection not shared with caller")
+	metricPrefixRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+)
+
+// scrapeThroughSSH 通过SSH的direct-tcpip通道访问远端exporter
+func scrapeThroughSSH(ctx context.Context, conn *SSHConnection, target, path string) ([]byte, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return tunnelDial(conn.Client, "tcp", addr)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scrape failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape failed: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCommandOutput+1))
+	if err != nil {
+		return nil, err
+	}
+	if maxCommandOutput > 0 && int64(len(data)) > maxCommandOutput {
+		return nil, fmt.Errorf("scrape output exceeds %d bytes", maxCommandOutput)
+	}
+	return data, nil
+}
+
+// rewriteMetrics 为指标名加前缀并添加instance标签，已有instance标签的样本保持不变
+func rewriteMetrics(data []byte, prefix, instance string) []byte {
+	if prefix == "" && instance == "" {
+		return data
+	}
+	if prefix != "" {
+		prefix += "_"
+	}
+	label := ""
+	if instance != "" {
+		label = fmt.Sprintf(`instance=%q`, instance)
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			out.WriteString(line)
+		case strings.HasPrefix(trimmed, "# HELP ") || strings.HasPrefix(trimmed, "# TYPE "):
+			fields := strings.SplitN(trimmed, " ", 4)
+			if len(fields) >= 3 {
+				fields[2] = prefix + fields[2]
+			}
+			out.WriteString(strings.Join(fields, " "))
+		case strings.HasPrefix(trimmed, "#"):
+			out.WriteString(line)
+		default:
+			end := strings.IndexAny(trimmed, "{ \t")
+			if end < 0 {
+				end = len(trimmed)
+			}
+			name, rest := trimmed[:end], trimmed[end:]
+			out.WriteString(prefix + name)
+			switch {
+			case label == "":
+				out.WriteString(rest)
+			case strings.HasPrefix(rest, "{}"):
+				out.WriteString("{" + label + "}" + rest[2:])
+			case strings.HasPrefix(rest, "{"):
+				if strings.Contains(rest[:strings.Index(rest, "}")+1], "instance=") {
+					out.WriteString(rest)
+				} else {
+					out.WriteString("{" + label + "," + rest[1:])
+				}
+			default:
+				out.WriteString("{" + label + "}" + rest)
+			}
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+type ScrapeConfig struct {
+	ConnectionID string `json:"connection_id" binding:"required"`
+	Target       string `json:"target"`
+	Path         string `json:"path"`
+	// Prefix 重新发布到 /metrics 时的指标名前缀，必须唯一
+	Prefix string `json:"prefix" binding:"required"`
+	// Interval 单位为秒
+	Interval int  `json:"interval"`
+	Relabel  bool `json:"relabel"`
+}
+
+type Scrape struct {
+	ID         string
+	Config     ScrapeConfig
+	CreatedAt  time.Time
+	LastScrape time.Time
+	LastError  string
+	Duration   float64
+
+	output []byte
+	cancel context.CancelFunc
+}
+
+// ScrapeManager 定期经SSH隧道抓取exporter并在本服务的 /metrics 上重新发布
+type ScrapeManager struct {
+	scrapes map[string]*Scrape
+	mutex   sync.RWMutex
+}
+
+var scrapes = &ScrapeManager{scrapes: make(map[string]*Scrape)}
+
+func (sm *ScrapeManager) Add(config ScrapeConfig) (string, error) {
+	if !metricPrefixRegexp.MatchString(config.Prefix) {
+		return "", fmt.Errorf("invalid metric prefix: %s", config.Prefix)
+	}
+	if _, err := collector.getConnection(config.ConnectionID); err != nil {
+		return "", err
+	}
+	if config.Target == "" {
+		config.Target = scrapeDefaultTarget
+	}
+	if config.Path == "" {
+		config.Path = scrapeDefaultPath
+	}
+	interval := time.Duration(config.Interval) * time.Second
+	if interval < scrapeMinInterval {
+		interval = scrapeMinInterval
+		config.Interval = int(interval.Seconds())
+	}
+
+	sm.mutex.Lock()
+	for _, s := range sm.scrapes {
+		if s.Config.Prefix == config.Prefix {
+			sm.mutex.Unlock()
+			return "", fmt.Errorf("prefix %s already in use", config.Prefix)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	scrape := &Scrape{ID: newID(), Config: config, CreatedAt: time.Now(), cancel: cancel}
+	sm.scrapes[scrape.ID] = scrape
+	sm.mutex.Unlock()
+
+	go sm.run(ctx, scrape, interval)
+	return scrape.ID, nil
+}
+
+func (sm *ScrapeManager) run(ctx context.Context, scrape *Scrape, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sm.scrapeOnce(ctx, scrape, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sm *ScrapeManager) scrapeOnce(ctx context.Context, scrape *Scrape, timeout time.Duration) {
+	config := scrape.Config
+	start := time.Now()
+
+	var data []byte
+	conn, err := collector.getConnection(config.ConnectionID)
+	if err == nil {
+		scrapeCtx, cancel := context.WithTimeout(ctx, timeout)
+		data, err = scrapeThroughSSH(scrapeCtx, conn, config.Target, config.Path)
+		cancel()
+	}
+	if err == nil {
+		instance := ""
+		if config.Relabel {
+			instance = conn.Config.Host
+		}
+		data = rewriteMetrics(data, config.Prefix, instance)
+	}
+
+	sm.mutex.Lock()
+	scrape.LastScrape = time.Now()
+	scrape.Duration = time.Since(start).Seconds()
+	if err != nil {
+		// 失败时清空输出，避免重新发布过期数据
+		scrape.LastError = redact(err.Error())
+		scrape.output = nil
+	} else {
+		scrape.LastError = ""
+		scrape.output = data
+	}
+	sm.mutex.Unlock()
+}
+
+// Remove allowed为false时不删除，返回errScrapeDenied
+func (sm *ScrapeManager) Remove(id string, allowed func(connectionID string) bool) error {
+	sm.mutex.Lock()
+	scrape, ok := sm.scrapes[id]
+	if ok && !allowed(scrape.Config.ConnectionID) {
+		sm.mutex.Unlock()
+		return errScrapeDenied
+	}
+	delete(sm.scrapes, id)
+	sm.mutex.Unlock()
+
+	if !ok {
+		return errScrapeNotFound
+	}
+	scrape.cancel()
+	return nil
+}
+
+// List 只列出allowed的连接上的抓取
+func (sm *ScrapeManager) List(allowed func(connectionID string) bool) []gin.H {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(sm.scrapes))
+	for _, s := range sm.scrapes {
+		if !allowed(s.Config.ConnectionID) {
+			continue
+		}
+		list = append(list, gin.H{
+			"id":              s.ID,
+			"connection_id":   s.Config.ConnectionID,
+			"target":          s.Config.Target,
+			"path":            s.Config.Path,
+			"prefix":          s.Config.Prefix,
+			"interval":        s.Config.Interval,
+			"relabel":         s.Config.Relabel,
+			"last_scrape":     s.LastScrape,
+			"last_error":      s.LastError,
+			"duration":        s.Duration,
+			"created_at":      s.CreatedAt,
+			"published_bytes": len(s.output),
+		})
+	}
+	return list
+}
+
+// WriteMetrics 输出所有抓取结果及每个抓取的up指标
+func (sm *ScrapeManager) WriteMetrics(w io.Writer) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	list := make([]*Scrape, 0, len(sm.scrapes))
+	for _, s := range sm.scrapes {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Config.Prefix < list[j].Config.Prefix })
+
+	for _, s := range list {
+		up := 0
+		if s.output != nil {
+			up = 1
+			w.Write(s.output)
+		}
+		out := metricWriter{w}
+		out.family(s.Config.Prefix+"_scrape_up", "gauge", "Whether the last tunnelled scrape succeeded.")
+		out.sample(s.Config.Prefix+"_scrape_up", float64(up), "connection_id", s.Config.ConnectionID, "target", s.Config.Target)
+	}
+}
+
+func registerScrapeRoutes(r *gin.Engine) {
+	// 单次抓取，relabel=true时添加instance标签
+	r.POST("/connections/:id/scrape", func(c *gin.Context) {
+		conn, err := collector.getConnection(c.Param("id"))
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		data, err := scrapeThroughSSH(ctx, conn,
+			c.DefaultQuery("target", scrapeDefaultTarget), c.DefaultQuery("path", scrapeDefaultPath))
+		if err != nil {
+			respondError(c, http.StatusBadGateway, err)
+			return
+		}
+
+		instance := ""
+		if c.Query("relabel") == "true" {
+			instance = conn.Config.Host
+		}
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", rewriteMetrics(data, c.Query("prefix"), instance))
+	})
+
+	r.POST("/scrapes", func(c *gin.Context) {
+		var config ScrapeConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		id, err := scrapes.Add(config)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errConnectionNotFound) {
+				status = http.StatusNotFound
+			}
+			respondError(c, status, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"scrape_id": id, "status": "scheduled", "timestamp": time.Now()})
+	})
+
+	// 启用连接ACL时只能查看和删除可访问的连接上的抓取
+	r.GET("/scrapes", func(c *gin.Context) {
+		p := currentPrincipal(c)
+		c.JSON(http.StatusOK, gin.H{"scrapes": scrapes.List(func(id string) bool { return connectionACLs.Allowed(p, id) })})
+	})
+
+	r.DELETE("/scrapes/:id", func(c *gin.Context) {
+		p := currentPrincipal(c)
+		err := scrapes.Remove(c.Param("id"), func(id string) bool { return connectionACLs.Allowed(p, id) })
+		if errors.Is(err, errScrapeDenied) {
+			denyRequest(c, p, routePermission(c.Request.Method, c.FullPath()), err.Error())
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+
+	// 以Prometheus格式重新发布抓取结果，与服务自身的指标一起输出
+	r.GET("/metrics", gin.WrapH(metricsHandler()))
+}
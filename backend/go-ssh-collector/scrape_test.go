@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// 启用连接ACL时抓取的列表和删除按所在连接的ACL检查
+func TestScrapesFollowConnectionACL(t *testing.T) {
+	f := newACLFixture(t)
+	w := apiRequest(f.router, f.alice, http.MethodPost, "/scrapes", `{"connection_id":"`+f.connectionID+`","prefix":"acl_test","interval":60}`)
+	var created struct {
+		ScrapeID string `json:"scrape_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("add scrape: status %d: %s", w.Code, w.Body.String())
+	}
+	t.Cleanup(func() { scrapes.Remove(created.ScrapeID, func(string) bool { return true }) })
+
+	list := func(key string) int {
+		w := apiRequest(f.router, key, http.MethodGet, "/scrapes", "")
+		var resp struct {
+			Scrapes []map[string]interface{} `json:"scrapes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("list: status %d: %s", w.Code, w.Body.String())
+		}
+		return len(resp.Scrapes)
+	}
+	if n := list(f.bob); n != 0 {
+		t.Fatalf("bob sees %d scrapes", n)
+	}
+	if n := list(f.alice); n != 1 {
+		t.Fatalf("alice sees %d scrapes", n)
+	}
+	if w := apiRequest(f.router, f.bob, http.MethodDelete, "/scrapes/"+created.ScrapeID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("bob delete: status %d, want 403", w.Code)
+	}
+	if n := list(f.admin); n != 1 {
+		t.Fatalf("denied delete removed the scrape (admin sees %d)", n)
+	}
+	if w := apiRequest(f.router, f.alice, http.MethodDelete, "/scrapes/"+created.ScrapeID, ""); w.Code != http.StatusOK {
+		t.Fatalf("alice delete: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(f.router, f.alice, http.MethodDelete, "/scrapes/"+created.ScrapeID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete again: status %d, want 404", w.Code)
+	}
+}
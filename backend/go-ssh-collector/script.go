@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+)
+
+// Starlark后处理脚本：脚本定义 process(result)，返回值写入script_result，
+// 可通过 emit_metric / emit_event 产生指标和事件。解释器不提供文件、网络和load
+
+var (
+	scriptMaxSteps  = uint64(envInt64("SCRIPT_MAX_STEPS", 1000000))
+	scriptTimeout   = time.Duration(envInt64("SCRIPT_TIMEOUT_MS", 2000)) * time.Millisecond
+	scriptMaxSource = envInt64("SCRIPT_MAX_SOURCE", 64<<10)
+	// 返回值序列化后的大小上限，避免脚本构造超大对象
+	scriptMaxResult = envInt64("SCRIPT_MAX_RESULT", 1<<20)
+)
+
+// 单次执行可产生的指标和事件数上限
+const scriptMaxEmits = 1000
+
+type ScriptMetric struct {
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type ScriptEvent struct {
+	Type    string                 `json:"type"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ScriptOutput 一次脚本执行的产出
+type ScriptOutput struct {
+	Result  interface{}    `json:"result"`
+	Metrics []ScriptMetric `json:"metrics,omitempty"`
+	Events  []ScriptEvent  `json:"events,omitempty"`
+}
+
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case []interface{}:
+		items := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			converted, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, converted)
+		}
+		return starlark.NewList(items), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			converted, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(key), converted)
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", value)
+}
+
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List, starlark.Tuple:
+		iterable := v.(starlark.Indexable)
+		items := make([]interface{}, 0, iterable.Len())
+		for i := 0; i < iterable.Len(); i++ {
+			item, err := fromStarlark(iterable.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case *starlark.Dict:
+		out := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			converted, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			out[string(key)] = converted
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported return type %s", value.Type())
+}
+
+// scriptInput 传给process的结果字典，facts为连接信息
+func scriptInput(result *CommandResult, facts map[string]interface{}) (starlark.Value, error) {
+	input := map[string]interface{}{
+		"command": result.Command,
+		"output":  result.Output,
+		"error":   result.Error,
+		"parsed":  result.Parsed,
+		"fields":  result.Fields,
+		"facts":   facts,
+	}
+	if result.ExitCode != nil {
+		input["exit_code"] = *result.ExitCode
+	}
+	document, err := normalizeDocument(input)
+	if err != nil {
+		return nil, err
+	}
+	return toStarlark(document)
+}
+
+// RunScript 在步数和时间限制下执行脚本
+func RunScript(source string, input starlark.Value) (*ScriptOutput, error) {
+	if int64(len(source)) > scriptMaxSource {
+		return nil, fmt.Errorf("script exceeds %d bytes", scriptMaxSource)
+	}
+	output := &ScriptOutput{}
+	thread := &starlark.Thread{Name: "script"}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	timer := time.AfterFunc(scriptTimeout, func() { thread.Cancel("script timed out") })
+	defer timer.Stop()
+
+	emitMetric := starlark.NewBuiltin("emit_metric", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var value starlark.Value
+		var labels *starlark.Dict
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value, "labels?", &labels); err != nil {
+			return nil, err
+		}
+		if !metricPrefixRegexp.MatchString(name) {
+			return nil, fmt.Errorf("emit_metric: invalid metric name %q", name)
+		}
+		number, ok := starlark.AsFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("emit_metric: value must be a number, got %s", value.Type())
+		}
+		if len(output.Metrics) >= scriptMaxEmits {
+			return nil, fmt.Errorf("emit_metric: more than %d metrics", scriptMaxEmits)
+		}
+		metric := ScriptMetric{Name: name, Value: number, Labels: map[string]string{}}
+		if labels != nil {
+			for _, item := range labels.Items() {
+				key, ok := starlark.AsString(item[0])
+				if !ok || !metricLabelName.MatchString(key) {
+					return nil, fmt.Errorf("emit_metric: invalid label name %s", item[0])
+				}
+				if s, ok := starlark.AsString(item[1]); ok {
+					metric.Labels[key] = s
+				} else {
+					metric.Labels[key] = item[1].String()
+				}
+			}
+		}
+		output.Metrics = append(output.Metrics, metric)
+		return starlark.None, nil
+	})
+	emitEvent := starlark.NewBuiltin("emit_event", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var eventType, message string
+		var fields *starlark.Dict
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "type", &eventType, "message", &message, "fields?", &fields); err != nil {
+			return nil, err
+		}
+		if len(output.Events) >= scriptMaxEmits {
+			return nil, fmt.Errorf("emit_event: more than %d events", scriptMaxEmits)
+		}
+		event := ScriptEvent{Type: eventType, Message: message}
+		if fields != nil {
+			converted, err := fromStarlark(fields)
+			if err != nil {
+				return nil, fmt.Errorf("emit_event: %v", err)
+			}
+			event.Fields = converted.(map[string]interface{})
+		}
+		output.Events = append(output.Events, event)
+		return starlark.None, nil
+	})
+
+	predeclared := starlark.StringDict{
+		"emit_metric": emitMetric,
+		"emit_event":  emitEvent,
+		"json":        starlarkjson.Module,
+		"math":        starlarkmath.Module,
+	}
+	globals, err := starlark.ExecFile(thread, "script", source, predeclared)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	process, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return nil, errors.New("script must define process(result)")
+	}
+	value, err := starlark.Call(thread, process, starlark.Tuple{input}, nil)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	if output.Result, err = fromStarlark(value); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(output.Result)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > scriptMaxResult {
+		return nil, fmt.Errorf("script result exceeds %d bytes", scriptMaxResult)
+	}
+	return output, nil
+}
+
+// scriptError 运行时错误附带Starlark调用栈
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+// applyScript 脚本错误只记录在ScriptError中，不影响原始结果
+func applyScript(result *CommandResult, source, connectionID string, info ConnectionInfo) {
+	facts := map[string]interface{}{
+		"connection_id": connectionID,
+		"protocol":      info.Protocol,
+		"device_type":   info.DeviceType,
+		"host":          info.Host,
+		"port":          info.Port,
+	}
+	input, err := scriptInput(result, facts)
+	if err != nil {
+		result.ScriptError = err.Error()
+		return
+	}
+	output, err := RunScript(source, input)
+	if err != nil {
+		result.ScriptError = err.Error()
+		return
+	}
+	result.ScriptResult = output.Result
+	result.ScriptMetrics = output.Metrics
+	result.ScriptEvents = output.Events
+	for _, metric := range output.Metrics {
+		metricRules.ObserveScript(metric, connectionID, info.Host)
+	}
+	for _, event := range output.Events {
+		sinks.Publish("script_event", gin.H{
+			"connection_id": connectionID,
+			"host":          info.Host,
+			"command":       result.Command,
+			"event":         event,
+		})
+	}
+}
+
+func registerScriptRoutes(r *gin.Engine) {
+	// 用样例结果调试脚本，result为CommandResult形式的对象
+	r.POST("/scripts/test", func(c *gin.Context) {
+		var body struct {
+			Script string                 `json:"script" binding:"required"`
+			Result map[string]interface{} `json:"result"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		document, err := normalizeDocument(body.Result)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		input, err := toStarlark(document)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		output, err := RunScript(body.Script, input)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err)
+			return
+		}
+		c.JSON(http.StatusOK, output)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.starlark.net/starlark"
+)
+
+func runTestScript(t *testing.T, source string) (*ScriptOutput, error) {
+	t.Helper()
+	input, err := scriptInput(&CommandResult{Command: "uptime", Output: "up 3 days"}, map[string]interface{}{"host": "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RunScript(source, input)
+}
+
+func TestScriptProcessAndEmits(t *testing.T) {
+	output, err := runTestScript(t, `
+def process(result):
+    emit_metric("uptime_days", 3, {"host": result["facts"]["host"]})
+    emit_event("parsed", "uptime parsed", {"days": 3})
+    return {"days": int(result["output"].split()[1])}
+`)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	result, _ := json.Marshal(output.Result)
+	if string(result) != `{"days":3}` {
+		t.Fatalf("result = %s", result)
+	}
+	if len(output.Metrics) != 1 || output.Metrics[0].Name != "uptime_days" || output.Metrics[0].Labels["host"] != "r1" {
+		t.Fatalf("metrics = %+v", output.Metrics)
+	}
+	if len(output.Events) != 1 || output.Events[0].Fields["days"] != int64(3) {
+		t.Fatalf("events = %+v", output.Events)
+	}
+}
+
+func TestScriptStepLimit(t *testing.T) {
+	previous := scriptMaxSteps
+	scriptMaxSteps = 10000
+	t.Cleanup(func() { scriptMaxSteps = previous })
+
+	_, err := runTestScript(t, `
+def process(result):
+    n = 0
+    for i in range(1000000):
+        n += i
+    return n
+`)
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Fatalf("err = %v, want step limit error", err)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	previousSteps, previousTimeout := scriptMaxSteps, scriptTimeout
+	scriptMaxSteps, scriptTimeout = 1<<62, 50*time.Millisecond
+	t.Cleanup(func() { scriptMaxSteps, scriptTimeout = previousSteps, previousTimeout })
+
+	start := time.Now()
+	_, err := runTestScript(t, `
+def process(result):
+    n = 0
+    for i in range(1000000000):
+        n += i
+    return n
+`)
+	if err == nil || !strings.Contains(err.Error(), "script timed out") {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("script ran for %v after the timeout", elapsed)
+	}
+}
+
+func TestScriptSizeLimits(t *testing.T) {
+	previousSource, previousResult := scriptMaxSource, scriptMaxResult
+	scriptMaxSource, scriptMaxResult = 200, 100
+	t.Cleanup(func() { scriptMaxSource, scriptMaxResult = previousSource, previousResult })
+
+	if _, err := runTestScript(t, "# "+strings.Repeat("x", 200)+"\ndef process(result):\n    return 1\n"); err == nil {
+		t.Error("oversized source should be rejected")
+	}
+	if _, err := runTestScript(t, "def process(result):\n    return \"x\" * 1000\n"); err == nil || !strings.Contains(err.Error(), "script result exceeds") {
+		t.Errorf("err = %v, want result size error", err)
+	}
+}
+
+func TestScriptEmitLimits(t *testing.T) {
+	cases := map[string]string{
+		"metric count": "def process(result):\n    for i in range(1001):\n        emit_metric(\"m\", i)\n",
+		"event count":  "def process(result):\n    for i in range(1001):\n        emit_event(\"t\", \"m\")\n",
+		"metric name":  "def process(result):\n    emit_metric(\"bad name\", 1)\n",
+		"metric value": "def process(result):\n    emit_metric(\"m\", \"one\")\n",
+		"label name":   "def process(result):\n    emit_metric(\"m\", 1, {\"bad-label\": \"x\"})\n",
+	}
+	for name, source := range cases {
+		if _, err := runTestScript(t, source); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	cases := map[string]string{
+		"load":       "load(\"os.star\", \"os\")\ndef process(result):\n    return 1\n",
+		"no process": "x = 1\n",
+		"recursion":  "def f(n):\n    return f(n)\ndef process(result):\n    return f(1)\n",
+		"open":       "def process(result):\n    return open(\"/etc/passwd\")\n",
+		"bad return": "def process(result):\n    return {1: 2}\n",
+	}
+	for name, source := range cases {
+		if _, err := runTestScript(t, source); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestApplyScriptKeepsResultOnError(t *testing.T) {
+	result := &CommandResult{Command: "uptime", Output: "up 3 days"}
+	applyScript(result, "def process(result):\n    fail(\"boom\")\n", "id", ConnectionInfo{Protocol: "ssh"})
+	if !strings.Contains(result.ScriptError, "boom") || result.Output != "up 3 days" || result.ScriptResult != nil {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestScriptTestRoute(t *testing.T) {
+	r := gin.New()
+	registerScriptRoutes(r)
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/scripts/test", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"script":"def process(result):\n    return result[\"output\"].upper()\n","result":{"output":"ok"}}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"result":"OK"`) {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(`{"script":"def process(result):\n    fail(\"x\")\n"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("failing script: status %d", w.Code)
+	}
+}
+
+func TestStarlarkConversionRoundTrip(t *testing.T) {
+	document := map[string]interface{}{"n": float64(2), "f": 1.5, "s": "x", "l": []interface{}{true, nil}}
+	value, err := toStarlark(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := value.(*starlark.Dict); !ok {
+		t.Fatalf("value = %s", value.Type())
+	}
+	back, err := fromStarlark(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(back)
+	if string(data) != `{"f":1.5,"l":[true,null],"n":2,"s":"x"}` {
+		t.Fatalf("round trip = %s", data)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sink 是结果与事件的下游输出（webhook、Kafka、NATS），同时启用多个时分发同一份序列化后的消息体。
+// SINK_PAYLOAD=json 时消息体为SinkMessage本身；versioned 时包一层带schema和版本号的信封
+type Sink interface {
+	Name() string
+	Publish(msg SinkMessage) error
+}
+
+// sinkReporter 自带缓冲的sink报告健康状态和指标
+type sinkReporter interface {
+	Stats() gin.H
+	WriteMetrics(w io.Writer)
+}
+
+type SinkMessage struct {
+	// Kind 标识数据类型: result / event / trap 等
+	Kind      string      `json:"kind"`
+	Payload   interface{} `json:"payload"`
+	Timestamp time.Time   `json:"timestamp"`
+
+	// body 由分发器序列化一次，各sink共用
+	body []byte
+}
+
+const (
+	sinkPayloadJSON      = "json"
+	sinkPayloadVersioned = "versioned"
+)
+
+// KAFKA_PAYLOAD 为引入SINK_PAYLOAD之前的配置名
+var sinkPayload = getEnv("SINK_PAYLOAD", getEnv("KAFKA_PAYLOAD", sinkPayloadJSON))
+
+// sinkSchemaVersions versioned信封中各类型payload的版本，结构不兼容地变化时递增
+var sinkSchemaVersions = map[string]int{"result": 1, "event": 1, "trap": 1}
+
+var sinkHostname, _ = os.Hostname()
+
+// sinkEnvelope versioned格式的消息体
+type sinkEnvelope struct {
+	Schema        string      `json:"schema"`
+	SchemaVersion int         `json:"schema_version"`
+	Kind          string      `json:"kind"`
+	Source        string      `json:"source"`
+	Timestamp     time.Time   `json:"timestamp"`
+	Payload       interface{} `json:"payload"`
+}
+
+// sinkSchema 返回消息类型的schema名和版本
+func sinkSchema(kind string) (string, int) {
+	version := sinkSchemaVersions[kind]
+	if version == 0 {
+		version = 1
+	}
+	return "collector." + kind, version
+}
+
+func encodeSinkMessage(msg SinkMessage) ([]byte, error) {
+	if sinkPayload != sinkPayloadVersioned {
+		return json.Marshal(msg)
+	}
+	schema, version := sinkSchema(msg.Kind)
+	return json.Marshal(sinkEnvelope{Schema: schema, SchemaVersion: version, Kind: msg.Kind, Source: sinkHostname, Timestamp: msg.Timestamp, Payload: msg.Payload})
+}
+
+// Body 为序列化后的消息体，未经分发器的消息在此序列化
+func (msg SinkMessage) Body() ([]byte, error) {
+	if msg.body != nil {
+		return msg.body, nil
+	}
+	return encodeSinkMessage(msg)
+}
+
+// sinkAttributes 消息来源，用于Kafka的key和NATS的subject
+type sinkAttributes struct {
+	connectionID string
+	namespace    string
+	protocol     string
+	host         string
+}
+
+func attributesOf(payload interface{}) sinkAttributes {
+	switch p := payload.(type) {
+	case ResultRecord:
+		return sinkAttributes{connectionID: p.ConnectionID, namespace: collector.namespace(p.ConnectionID), protocol: p.Protocol, host: p.Host}
+	case LifecycleEvent:
+		return sinkAttributes{connectionID: p.ConnectionID, namespace: p.Namespace, protocol: p.Protocol, host: p.Host}
+	case TrapEvent:
+		return sinkAttributes{protocol: "snmp", host: p.Source}
+	}
+	return sinkAttributes{}
+}
+
+// SinkDispatcher 通过有界队列异步分发消息，下游变慢时丢弃而不阻塞采集
+type SinkDispatcher struct {
+	sinks   []Sink
+	queue   chan SinkMessage
+	dropped int64
+	failed  int64
+}
+
+func NewSinkDispatcher(size int, sinks ...Sink) *SinkDispatcher {
+	d := &SinkDispatcher{
+		sinks: sinks,
+		queue: make(chan SinkMessage, size),
+	}
+	go d.run()
+	return d
+}
+
+func (d *SinkDispatcher) run() {
+	for msg := range d.queue {
+		body, err := encodeSinkMessage(msg)
+		if err != nil {
+			atomic.AddInt64(&d.failed, 1)
+			logWarn("sink.encode_failed", "sink message encode failed", "kind", msg.Kind, "error", err)
+			continue
+		}
+		msg.body = body
+		for _, sink := range d.sinks {
+			if err := sink.Publish(msg); err != nil {
+				atomic.AddInt64(&d.failed, 1)
+				logWarn("sink.publish_failed", "sink publish failed", "sink", sink.Name(), "error", err)
+			}
+		}
+	}
+}
+
+func (d *SinkDispatcher) Publish(kind string, payload interface{}) {
+	if len(d.sinks) == 0 {
+		return
+	}
+	select {
+	case d.queue <- SinkMessage{Kind: kind, Payload: payload, Timestamp: time.Now()}:
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+	}
+}
+
+func (d *SinkDispatcher) Stats() map[string]interface{} {
+	names := make([]string, 0, len(d.sinks))
+	details := gin.H{}
+	for _, sink := range d.sinks {
+		names = append(names, sink.Name())
+		if reporter, ok := sink.(sinkReporter); ok {
+			details[sink.Name()] = reporter.Stats()
+		}
+	}
+	return map[string]interface{}{
+		"sinks":   names,
+		"queued":  len(d.queue),
+		"dropped": atomic.LoadInt64(&d.dropped),
+		"failed":  atomic.LoadInt64(&d.failed),
+		"details": details,
+	}
+}
+
+func (d *SinkDispatcher) WriteMetrics(w io.Writer) {
+	out := metricWriter{w}
+	out.family("collector_sink_queue", "gauge", "Messages waiting in the sink dispatcher queue.")
+	out.sample("collector_sink_queue", float64(len(d.queue)))
+	out.family("collector_sink_dropped_total", "counter", "Messages dropped because the sink dispatcher queue was full.")
+	out.sample("collector_sink_dropped_total", float64(atomic.LoadInt64(&d.dropped)))
+	for _, sink := range d.sinks {
+		if reporter, ok := sink.(sinkReporter); ok {
+			reporter.WriteMetrics(w)
+		}
+	}
+}
+
+type WebhookSink struct {
+	URL    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL: url,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: safeDialer(10 * time.Second).DialContext},
+		},
+	}
+}
+
+func (w *WebhookSink) Name() string { return "webhook" }
+
+func (w *WebhookSink) Publish(msg SinkMessage) error {
+	body, err := msg.Body()
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func newSinksFromEnv() *SinkDispatcher {
+	if sinkPayload != sinkPayloadJSON && sinkPayload != sinkPayloadVersioned {
+		logWarn("sink.config_invalid", "SINK_PAYLOAD must be json or versioned, using json", "payload", sinkPayload)
+		sinkPayload = sinkPayloadJSON
+	}
+	var sinks []Sink
+	if url := getEnv("WEBHOOK_URL", ""); url != "" {
+		sinks = append(sinks, NewWebhookSink(url))
+	}
+	if kafkaSink != nil {
+		sinks = append(sinks, kafkaSink)
+	}
+	if natsSink != nil {
+		sinks = append(sinks, natsSink)
+	}
+	return NewSinkDispatcher(int(envInt64("SINK_QUEUE_SIZE", 1000)), sinks...)
+}
+
+var sinks = newSinksFromEnv()
+
+func registerSinkRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/sinks", requireAdmin)
+	admin.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, sinks.Stats())
+	})
+}
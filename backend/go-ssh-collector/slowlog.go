@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 慢命令日志：耗时超过阈值的命令记录连接、命令（已脱敏）、耗时和各阶段耗时，
+// 保存在最近 SLOWLOG_SIZE 条的环形缓冲中（GET /slowlog），同时写一条 command.slow 日志并计入
+// collector_slow_operations_total 指标。
+// 全局阈值 SLOWLOG_THRESHOLD_MS（0为关闭），可用 PUT /slowlog/threshold 修改；
+// PUT /connections/:id/slowlog-threshold 为单个连接设置阈值，删除后恢复使用全局阈值。
+// 流式执行和异步任务按总耗时计算。启用连接ACL时只返回调用方可访问的连接的记录和阈值覆盖
+
+var (
+	slowLogSize      = int(envInt64("SLOWLOG_SIZE", 200))
+	slowLogThreshold = time.Duration(envInt64("SLOWLOG_THRESHOLD_MS", 5000)) * time.Millisecond
+)
+
+var (
+	errInvalidThreshold    = errors.New("threshold_ms must not be negative")
+	errNoThresholdOverride = errors.New("no slowlog threshold override for connection")
+)
+
+// commandTiming 执行各阶段的耗时，协议不区分阶段时只有总耗时
+type commandTiming struct {
+	SessionOpen time.Duration
+	Start       time.Duration
+	OutputRead  time.Duration
+	PostProcess time.Duration
+}
+
+func (t commandTiming) milliseconds() map[string]float64 {
+	ms := map[string]float64{}
+	for name, d := range map[string]time.Duration{
+		"session_open": t.SessionOpen,
+		"start":        t.Start,
+		"output_read":  t.OutputRead,
+		"post_process": t.PostProcess,
+	} {
+		if d > 0 {
+			ms[name] = durationMS(d)
+		}
+	}
+	return ms
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// SlowLogEntry kind为command或job，job的command为任务类型
+type SlowLogEntry struct {
+	ID           string             `json:"id"`
+	Kind         string             `json:"kind"`
+	ConnectionID string             `json:"connection_id,omitempty"`
+	Protocol     string             `json:"protocol,omitempty"`
+	Host         string             `json:"host,omitempty"`
+	Command      string             `json:"command"`
+	RequestID    string             `json:"request_id,omitempty"`
+	DurationMS   float64            `json:"duration_ms"`
+	ThresholdMS  float64            `json:"threshold_ms"`
+	TimingMS     map[string]float64 `json:"timing_ms,omitempty"`
+	Streaming    bool               `json:"streaming,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	Timestamp    time.Time          `json:"timestamp"`
+}
+
+type SlowLog struct {
+	threshold time.Duration
+	overrides map[string]time.Duration
+	entries   []SlowLogEntry
+	next      int
+	mutex     sync.RWMutex
+}
+
+var slowLog = &SlowLog{threshold: slowLogThreshold, overrides: make(map[string]time.Duration)}
+
+// Threshold 连接的生效阈值，0表示不记录
+func (s *SlowLog) Threshold(connectionID string) time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if threshold, ok := s.overrides[connectionID]; ok {
+		return threshold
+	}
+	return s.threshold
+}
+
+func (s *SlowLog) SetThreshold(threshold time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.threshold = threshold
+}
+
+func (s *SlowLog) SetOverride(connectionID string, threshold time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.overrides[connectionID] = threshold
+}
+
+func (s *SlowLog) RemoveOverride(connectionID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.overrides[connectionID]
+	delete(s.overrides, connectionID)
+	return ok
+}
+
+// Observe 超过阈值时记录，返回是否记录
+func (s *SlowLog) Observe(entry SlowLogEntry, elapsed time.Duration) bool {
+	threshold := s.Threshold(entry.ConnectionID)
+	if threshold <= 0 || elapsed < threshold {
+		return false
+	}
+	entry.ID = newID()
+	entry.Command = redact(entry.Command)
+	entry.Error = redact(entry.Error)
+	entry.DurationMS = durationMS(elapsed)
+	entry.ThresholdMS = durationMS(threshold)
+	entry.Timestamp = time.Now()
+
+	s.mutex.Lock()
+	if len(s.entries) < slowLogSize {
+		s.entries = append(s.entries, entry)
+	} else if slowLogSize > 0 {
+		s.entries[s.next] = entry
+		s.next = (s.next + 1) % len(s.entries)
+	}
+	s.mutex.Unlock()
+
+	serviceStats.ObserveSlow(entry.Kind, entry.Protocol)
+	// 命令已脱敏，但仍按命令日志的惯例不写入
+	logWarn(entry.Kind+".slow", "slow "+entry.Kind, "request_id", entry.RequestID, "connection_id", entry.ConnectionID,
+		"protocol", entry.Protocol, "host", entry.Host, "duration_ms", elapsed, "threshold_ms", threshold,
+		"timing_ms", entry.TimingMS, "streaming", entry.Streaming, "error", entry.Error)
+	return true
+}
+
+// Entries 最新的在前，只返回allowed的连接上的记录
+func (s *SlowLog) Entries(connectionID, kind string, limit int, allowed func(connectionID string) bool) []SlowLogEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := []SlowLogEntry{}
+	for i := 0; i < len(s.entries); i++ {
+		entry := s.entries[(s.next-1-i+2*len(s.entries))%len(s.entries)]
+		if (connectionID != "" && entry.ConnectionID != connectionID) || (kind != "" && entry.Kind != kind) || !allowed(entry.ConnectionID) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+	}
+	return entries
+}
+
+func (s *SlowLog) thresholds(allowed func(connectionID string) bool) gin.H {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	overrides := make(map[string]float64, len(s.overrides))
+	for id, threshold := range s.overrides {
+		if allowed(id) {
+			overrides[id] = durationMS(threshold)
+		}
+	}
+	return gin.H{"threshold_ms": durationMS(s.threshold), "overrides": overrides}
+}
+
+// observeSlowCommand 在Execute结束时调用
+func observeSlowCommand(req CommandRequest, info ConnectionInfo, elapsed time.Duration, result *CommandResult, err error) {
+	entry := SlowLogEntry{
+		Kind:         "command",
+		ConnectionID: req.ConnectionID,
+		Protocol:     info.Protocol,
+		Host:         info.Host,
+		Command:      req.Command,
+		RequestID:    req.requestID,
+		Streaming:    req.stream != nil,
+	}
+	if result != nil {
+		entry.TimingMS = result.timing.milliseconds()
+		entry.Error = result.Error
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	slowLog.Observe(entry, elapsed)
+}
+
+// observeSlowJob 在任务结束时调用，按从开始运行到结束的总耗时计算
+func observeSlowJob(job *Job, elapsed time.Duration, err error) {
+	entry := SlowLogEntry{
+		Kind:         "job",
+		ConnectionID: job.ConnectionID,
+		Command:      job.Type,
+		RequestID:    job.RequestID,
+	}
+	if conn, getErr := collector.get(job.ConnectionID); getErr == nil {
+		entry.Protocol, entry.Host = conn.Info().Protocol, conn.Info().Host
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	slowLog.Observe(entry, elapsed)
+}
+
+type thresholdRequest struct {
+	ThresholdMS *int64 `json:"threshold_ms" binding:"required"`
+}
+
+func bindThreshold(c *gin.Context) (time.Duration, bool) {
+	var req thresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return 0, false
+	}
+	if *req.ThresholdMS < 0 {
+		respondError(c, http.StatusBadRequest, errInvalidThreshold)
+		return 0, false
+	}
+	return time.Duration(*req.ThresholdMS) * time.Millisecond, true
+}
+
+func registerSlowLogRoutes(r *gin.Engine) {
+	r.GET("/slowlog", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		p := currentPrincipal(c)
+		allowed := func(id string) bool { return connectionACLs.Allowed(p, id) }
+		response := slowLog.thresholds(allowed)
+		response["entries"] = slowLog.Entries(c.Query("connection_id"), c.Query("kind"), limit, allowed)
+		c.JSON(http.StatusOK, response)
+	})
+
+	r.PUT("/slowlog/threshold", func(c *gin.Context) {
+		threshold, ok := bindThreshold(c)
+		if !ok {
+			return
+		}
+		slowLog.SetThreshold(threshold)
+		logInfo("audit", "slowlog threshold changed", append(requestLogFields(c), "threshold_ms", threshold)...)
+		p := currentPrincipal(c)
+		c.JSON(http.StatusOK, slowLog.thresholds(func(id string) bool { return connectionACLs.Allowed(p, id) }))
+	})
+
+	r.PUT("/connections/:id/slowlog-threshold", func(c *gin.Context) {
+		threshold, ok := bindThreshold(c)
+		if !ok {
+			return
+		}
+		slowLog.SetOverride(c.Param("id"), threshold)
+		logInfo("audit", "slowlog threshold changed", append(requestLogFields(c), "threshold_ms", threshold)...)
+		c.JSON(http.StatusOK, gin.H{"connection_id": c.Param("id"), "threshold_ms": durationMS(threshold)})
+	})
+
+	r.DELETE("/connections/:id/slowlog-threshold", func(c *gin.Context) {
+		if !slowLog.RemoveOverride(c.Param("id")) {
+			respondError(c, http.StatusNotFound, errNoThresholdOverride)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connection_id": c.Param("id"), "threshold_ms": durationMS(slowLog.Threshold(c.Param("id")))})
+	})
+}
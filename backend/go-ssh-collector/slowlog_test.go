@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useSlowLog 测试期间使用新的慢命令日志
+func useSlowLog(t *testing.T, threshold time.Duration) *SlowLog {
+	t.Helper()
+	previous := slowLog
+	slowLog = &SlowLog{threshold: threshold, overrides: make(map[string]time.Duration)}
+	t.Cleanup(func() { slowLog = previous })
+	return slowLog
+}
+
+type slowLogResponse struct {
+	ThresholdMS float64            `json:"threshold_ms"`
+	Overrides   map[string]float64 `json:"overrides"`
+	Entries     []SlowLogEntry     `json:"entries"`
+}
+
+func getSlowLog(t *testing.T, r http.Handler, key, query string) slowLogResponse {
+	t.Helper()
+	w := apiRequest(r, key, http.MethodGet, "/slowlog"+query, "")
+	var resp slowLogResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /slowlog: status %d: %s", w.Code, w.Body.String())
+	}
+	return resp
+}
+
+func TestSlowLogRuntimeThreshold(t *testing.T) {
+	s := useSlowLog(t, time.Second)
+	r := newRouter()
+
+	if w := apiRequest(r, "", http.MethodPut, "/slowlog/threshold", `{"threshold_ms":-1}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative threshold: status %d", w.Code)
+	}
+	if w := apiRequest(r, "", http.MethodPut, "/slowlog/threshold", `{"threshold_ms":50}`); w.Code != http.StatusOK {
+		t.Fatalf("set threshold: status %d: %s", w.Code, w.Body.String())
+	}
+	entry := SlowLogEntry{Kind: "command", ConnectionID: "conn-a", Command: "show tech password=x1"}
+	if s.Observe(entry, 40*time.Millisecond) {
+		t.Fatal("recorded a command under the new threshold")
+	}
+	if !s.Observe(entry, 60*time.Millisecond) {
+		t.Fatal("did not record a command over the new threshold")
+	}
+	resp := getSlowLog(t, r, "", "?kind=command")
+	if resp.ThresholdMS != 50 || len(resp.Entries) != 1 {
+		t.Fatalf("slowlog = %+v", resp)
+	}
+	if got := resp.Entries[0]; got.ThresholdMS != 50 || got.DurationMS != 60 || got.Command != "show tech password=***" {
+		t.Fatalf("entry = %+v", got)
+	}
+
+	// 0关闭记录
+	apiRequest(r, "", http.MethodPut, "/slowlog/threshold", `{"threshold_ms":0}`)
+	if s.Observe(entry, time.Hour) {
+		t.Fatal("recorded with the slowlog disabled")
+	}
+}
+
+func TestSlowLogConnectionOverride(t *testing.T) {
+	s := useSlowLog(t, time.Second)
+	r := newRouter()
+
+	if w := apiRequest(r, "", http.MethodPut, "/connections/conn-a/slowlog-threshold", `{"threshold_ms":10}`); w.Code != http.StatusOK {
+		t.Fatalf("set override: status %d: %s", w.Code, w.Body.String())
+	}
+	if s.Threshold("conn-a") != 10*time.Millisecond || s.Threshold("conn-b") != time.Second {
+		t.Fatalf("thresholds = %v, %v", s.Threshold("conn-a"), s.Threshold("conn-b"))
+	}
+	if !s.Observe(SlowLogEntry{Kind: "command", ConnectionID: "conn-a"}, 20*time.Millisecond) {
+		t.Fatal("override not applied")
+	}
+	if s.Observe(SlowLogEntry{Kind: "command", ConnectionID: "conn-b"}, 20*time.Millisecond) {
+		t.Fatal("override applied to another connection")
+	}
+	// 覆盖为0只关闭该连接
+	apiRequest(r, "", http.MethodPut, "/connections/conn-b/slowlog-threshold", `{"threshold_ms":0}`)
+	if s.Observe(SlowLogEntry{Kind: "command", ConnectionID: "conn-b"}, time.Hour) {
+		t.Fatal("recorded on a connection with the slowlog disabled")
+	}
+	if resp := getSlowLog(t, r, "", ""); resp.Overrides["conn-a"] != 10 || len(resp.Overrides) != 2 {
+		t.Fatalf("overrides = %v", resp.Overrides)
+	}
+
+	w := apiRequest(r, "", http.MethodDelete, "/connections/conn-a/slowlog-threshold", "")
+	if w.Code != http.StatusOK || s.Threshold("conn-a") != time.Second {
+		t.Fatalf("delete override: status %d, threshold %v", w.Code, s.Threshold("conn-a"))
+	}
+	if w := apiRequest(r, "", http.MethodDelete, "/connections/conn-a/slowlog-threshold", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete missing override: status %d", w.Code)
+	}
+}
+
+// 启用连接ACL时只返回调用方可访问的连接上的记录，没有ACL记录的只有管理员可见
+func TestSlowLogFiltersByConnectionACL(t *testing.T) {
+	enableConnectionACL(t)
+	s := useSlowLog(t, time.Millisecond)
+	connectionACLs.Claim("conn-alice", &Principal{Name: "alice", Role: roleOperator, Method: "api_key"})
+	connectionACLs.Claim("conn-bob", &Principal{Name: "bob", Role: roleOperator, Method: "api_key"})
+	for _, id := range []string{"conn-alice", "conn-bob", "conn-gone"} {
+		s.Observe(SlowLogEntry{Kind: "command", ConnectionID: id, Command: "uptime"}, time.Second)
+		s.SetOverride(id, time.Second)
+	}
+	r := newRouter()
+
+	for _, tc := range []struct {
+		name, role string
+		want       int
+	}{
+		{"alice", roleViewer, 1},
+		{"bob", roleViewer, 1},
+		{"carol", roleViewer, 0},
+		{"admin", roleAdmin, 3},
+	} {
+		resp := getSlowLog(t, r, useAPIKey(t, tc.name, tc.role), "")
+		if len(resp.Entries) != tc.want || len(resp.Overrides) != tc.want {
+			t.Errorf("%s: %d entries, %d overrides, want %d", tc.name, len(resp.Entries), len(resp.Overrides), tc.want)
+		}
+		for _, entry := range resp.Entries {
+			if tc.role != roleAdmin && entry.ConnectionID != "conn-"+tc.name {
+				t.Errorf("%s sees %s", tc.name, entry.ConnectionID)
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	defaultSNMPMaxRepetitions = 10
+	defaultSNMPMaxResults     = 10000
+)
+
+var errWalkLimit = errors.New("walk result limit reached")
+
+type SNMPRequest struct {
+	Target         string   `json:"target" binding:"required"`
+	Port           uint16   `json:"port"`
+	Community      string   `json:"community"`
+	Version        string   `json:"version"`
+	OIDs           []string `json:"oids" binding:"required,min=1"`
+	Timeout        int      `json:"timeout"`
+	Retries        int      `json:"retries"`
+	MaxRepetitions uint32   `json:"max_repetitions"`
+	MaxResults     int      `json:"max_results"`
+
+	// SNMPv3 USM，口令优先从 *_ref 引用的凭据后端解析
+	Username          string `json:"username"`
+	AuthProtocol      string `json:"auth_protocol"`
+	AuthPassphrase    string `json:"auth_passphrase"`
+	AuthPassphraseRef string `json:"auth_passphrase_ref"`
+	PrivProtocol      string `json:"priv_protocol"`
+	PrivPassphrase    string `json:"priv_passphrase"`
+	PrivPassphraseRef string `json:"priv_passphrase_ref"`
+	ContextName       string `json:"context_name"`
+}
+
+// SNMPError 带错误分类，便于区分认证口令错误与加密口令错误
+type SNMPError struct {
+	Class string
+	Err   error
+}
+
+func (e *SNMPError) Error() string { return e.Err.Error() }
+func (e *SNMPError) Unwrap() error { return e.Err }
+
+// classifySNMPError 将gosnmp的USM报告错误映射为错误分类
+func classifySNMPError(op string, err error) error {
+	class := "snmp_error"
+	switch {
+	case errors.Is(err, gosnmp.ErrWrongDigest):
+		class = "auth_failed"
+	case errors.Is(err, gosnmp.ErrDecryption):
+		class = "decryption_failed"
+	case errors.Is(err, gosnmp.ErrUnknownUsername):
+		class = "unknown_user"
+	case errors.Is(err, gosnmp.ErrUnknownSecurityLevel):
+		class = "unsupported_security_level"
+	case errors.Is(err, gosnmp.ErrNotInTimeWindow):
+		class = "not_in_time_window"
+	case strings.Contains(err.Error(), "timeout"):
+		class = "timeout"
+	}
+	return &SNMPError{Class: class, Err: fmt.Errorf("snmp %s failed: %v", op, err)}
+}
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"SHA":     gosnmp.SHA,
+	"SHA-224": gosnmp.SHA224,
+	"SHA-256": gosnmp.SHA256,
+	"SHA-384": gosnmp.SHA384,
+	"SHA-512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"AES":     gosnmp.AES,
+	"AES-128": gosnmp.AES,
+	"AES-192": gosnmp.AES192,
+	"AES-256": gosnmp.AES256,
+}
+
+// usmParameters 根据请求构造v3安全参数，引擎ID由gosnmp在首次请求时自动发现
+func usmParameters(req SNMPRequest) (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters, error) {
+	if req.Username == "" {
+		return 0, nil, fmt.Errorf("username is required for snmp v3")
+	}
+	params := &gosnmp.UsmSecurityParameters{UserName: req.Username}
+	flags := gosnmp.NoAuthNoPriv
+
+	if req.AuthProtocol != "" {
+		protocol, ok := snmpAuthProtocols[strings.ToUpper(req.AuthProtocol)]
+		if !ok {
+			return 0, nil, fmt.Errorf("unsupported auth protocol: %s", req.AuthProtocol)
+		}
+		passphrase, err := credentialValue(req.AuthPassphrase, req.AuthPassphraseRef)
+		if err != nil {
+			return 0, nil, err
+		}
+		params.AuthenticationProtocol = protocol
+		params.AuthenticationPassphrase = passphrase
+		flags = gosnmp.AuthNoPriv
+	}
+
+	if req.PrivProtocol != "" {
+		if flags == gosnmp.NoAuthNoPriv {
+			return 0, nil, fmt.Errorf("priv protocol requires an auth protocol")
+		}
+		protocol, ok := snmpPrivProtocols[strings.ToUpper(req.PrivProtocol)]
+		if !ok {
+			return 0, nil, fmt.Errorf("unsupported priv protocol: %s", req.PrivProtocol)
+		}
+		passphrase, err := credentialValue(req.PrivPassphrase, req.PrivPassphraseRef)
+		if err != nil {
+			return 0, nil, err
+		}
+		params.PrivacyProtocol = protocol
+		params.PrivacyPassphrase = passphrase
+		flags = gosnmp.AuthPriv
+	}
+
+	return flags, params, nil
+}
+
+type SNMPVarbind struct {
+	OID   string      `json:"oid"`
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+	// Hex 为true时value是不可打印OctetString的十六进制表示
+	Hex bool `json:"hex,omitempty"`
+}
+
+type SNMPResult struct {
+	Target    string        `json:"target"`
+	Operation string        `json:"operation"`
+	Varbinds  []SNMPVarbind `json:"varbinds"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Duration  float64       `json:"duration"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// 内置的常用OID名称，可通过SNMP_OID_NAMES_FILE追加（JSON: {"oid": "name"}）
+var oidNames = map[string]string{
+	"1.3.6.1.2.1.1.1":         "sysDescr",
+	"1.3.6.1.2.1.1.2":         "sysObjectID",
+	"1.3.6.1.2.1.1.3":         "sysUpTime",
+	"1.3.6.1.2.1.1.4":         "sysContact",
+	"1.3.6.1.2.1.1.5":         "sysName",
+	"1.3.6.1.2.1.1.6":         "sysLocation",
+	"1.3.6.1.2.1.2.2.1.1":     "ifIndex",
+	"1.3.6.1.2.1.2.2.1.2":     "ifDescr",
+	"1.3.6.1.2.1.2.2.1.3":     "ifType",
+	"1.3.6.1.2.1.2.2.1.4":     "ifMtu",
+	"1.3.6.1.2.1.2.2.1.5":     "ifSpeed",
+	"1.3.6.1.2.1.2.2.1.7":     "ifAdminStatus",
+	"1.3.6.1.2.1.2.2.1.8":     "ifOperStatus",
+	"1.3.6.1.2.1.2.2.1.10":    "ifInOctets",
+	"1.3.6.1.2.1.2.2.1.14":    "ifInErrors",
+	"1.3.6.1.2.1.2.2.1.16":    "ifOutOctets",
+	"1.3.6.1.2.1.2.2.1.20":    "ifOutErrors",
+	"1.3.6.1.2.1.31.1.1.1.1":  "ifName",
+	"1.3.6.1.2.1.31.1.1.1.6":  "ifHCInOctets",
+	"1.3.6.1.2.1.31.1.1.1.10": "ifHCOutOctets",
+	"1.3.6.1.2.1.31.1.1.1.15": "ifHighSpeed",
+	"1.3.6.1.2.1.31.1.1.1.18": "ifAlias",
+	"1.3.6.1.6.3.1.1.4.1":     "snmpTrapOID",
+}
+
+func init() {
+	file := os.Getenv("SNMP_OID_NAMES_FILE")
+	if file == "" {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		logError("snmp.oid_names_load_failed", "failed to read SNMP_OID_NAMES_FILE", "file", file, "error", err)
+		return
+	}
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		logError("snmp.oid_names_load_failed", "failed to parse SNMP_OID_NAMES_FILE", "file", file, "error", err)
+		return
+	}
+	for oid, name := range names {
+		oidNames[strings.TrimPrefix(oid, ".")] = name
+	}
+}
+
+// resolveOID 按最长前缀匹配名称，实例部分保留为后缀，如 ifDescr.3
+func resolveOID(oid string) string {
+	oid = strings.TrimPrefix(oid, ".")
+	for prefix := oid; prefix != ""; {
+		if name, ok := oidNames[prefix]; ok {
+			return name + strings.TrimPrefix(oid, prefix)
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return ""
+}
+
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func convertVarbind(pdu gosnmp.SnmpPDU) SNMPVarbind {
+	vb := SNMPVarbind{
+		OID:  strings.TrimPrefix(pdu.Name, "."),
+		Name: resolveOID(pdu.Name),
+		Type: pdu.Type.String(),
+	}
+
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		b, _ := pdu.Value.([]byte)
+		if isPrintable(b) {
+			vb.Value = string(b)
+		} else {
+			vb.Value = hex.EncodeToString(b)
+			vb.Hex = true
+		}
+	case gosnmp.ObjectIdentifier:
+		vb.Value = strings.TrimPrefix(fmt.Sprint(pdu.Value), ".")
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		vb.Value = nil
+	case gosnmp.Opaque:
+		if b, ok := pdu.Value.([]byte); ok {
+			vb.Value = hex.EncodeToString(b)
+			vb.Hex = true
+		} else {
+			vb.Value = pdu.Value
+		}
+	default:
+		// Integer/Counter32/Gauge32/TimeTicks/Counter64/IPAddress 等gosnmp已解码为Go基本类型
+		vb.Value = pdu.Value
+	}
+	return vb
+}
+
+func newSNMPClient(req SNMPRequest) (*gosnmp.GoSNMP, error) {
+	if req.Port == 0 {
+		req.Port = 161
+	}
+	if req.Timeout == 0 {
+		req.Timeout = 5
+	}
+	if req.Community == "" {
+		req.Community = "public"
+	}
+	if req.MaxRepetitions == 0 {
+		req.MaxRepetitions = defaultSNMPMaxRepetitions
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:         req.Target,
+		Port:           req.Port,
+		Community:      req.Community,
+		Timeout:        time.Duration(req.Timeout) * time.Second,
+		Retries:        req.Retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: req.MaxRepetitions,
+		Control:        destinationControl,
+	}
+
+	switch req.Version {
+	case "1", "v1":
+		client.Version = gosnmp.Version1
+	case "", "2c", "v2c":
+		client.Version = gosnmp.Version2c
+	case "3", "v3":
+		flags, params, err := usmParameters(req)
+		if err != nil {
+			return nil, err
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = flags
+		client.SecurityParameters = params
+		client.ContextName = req.ContextName
+	default:
+		return nil, fmt.Errorf("unsupported snmp version: %s", req.Version)
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return client, nil
+}
+
+func SNMPGet(req SNMPRequest) (*SNMPResult, error) {
+	client, err := newSNMPClient(req)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	start := time.Now()
+	result := &SNMPResult{Target: req.Target, Operation: "get", Varbinds: []SNMPVarbind{}}
+
+	// 按MaxOids分批请求
+	for i := 0; i < len(req.OIDs); i += client.MaxOids {
+		end := i + client.MaxOids
+		if end > len(req.OIDs) {
+			end = len(req.OIDs)
+		}
+		packet, err := client.Get(req.OIDs[i:end])
+		if err != nil {
+			return nil, classifySNMPError("get", err)
+		}
+		if packet.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("snmp get failed: %s (index %d)", packet.Error, packet.ErrorIndex)
+		}
+		for _, pdu := range packet.Variables {
+			result.Varbinds = append(result.Varbinds, convertVarbind(pdu))
+		}
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+func SNMPWalk(req SNMPRequest) (*SNMPResult, error) {
+	client, err := newSNMPClient(req)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	maxResults := req.MaxResults
+	if maxResults <= 0 || maxResults > defaultSNMPMaxResults {
+		maxResults = defaultSNMPMaxResults
+	}
+
+	start := time.Now()
+	result := &SNMPResult{Target: req.Target, Operation: "walk", Varbinds: []SNMPVarbind{}}
+
+	collect := func(pdu gosnmp.SnmpPDU) error {
+		if len(result.Varbinds) >= maxResults {
+			return errWalkLimit
+		}
+		result.Varbinds = append(result.Varbinds, convertVarbind(pdu))
+		return nil
+	}
+
+	for _, oid := range req.OIDs {
+		// v1不支持GetBulk
+		walk := client.BulkWalk
+		if client.Version == gosnmp.Version1 {
+			walk = client.Walk
+		}
+		if err := walk(oid, collect); err != nil {
+			if errors.Is(err, errWalkLimit) {
+				result.Truncated = true
+				break
+			}
+			return nil, classifySNMPError("walk", err)
+		}
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	result.Timestamp = time.Now()
+	return result, nil
+}
+
+func snmpErrorDetails(err error) gin.H {
+	var snmpErr *SNMPError
+	if errors.As(err, &snmpErr) {
+		return gin.H{"error_class": snmpErr.Class}
+	}
+	return nil
+}
+
+func registerSNMPRoutes(r *gin.Engine) {
+	// SNMP GET
+	r.POST("/snmp/get", func(c *gin.Context) {
+		var req SNMPRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result, err := SNMPGet(req)
+		if err != nil {
+			respondErrorDetails(c, http.StatusInternalServerError, err, snmpErrorDetails(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	// SNMP WALK
+	r.POST("/snmp/walk", func(c *gin.Context) {
+		var req SNMPRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result, err := SNMPWalk(req)
+		if err != nil {
+			respondErrorDetails(c, http.StatusInternalServerError, err, snmpErrorDetails(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
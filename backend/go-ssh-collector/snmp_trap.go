@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+	// 每trapLogSampleRate个异常报文记录一条日志
+	trapLogSampleRate = 100
+)
+
+type TrapEvent struct {
+	ID         uint64        `json:"id"`
+	Source     string        `json:"source"`
+	Version    string        `json:"version"`
+	Community  string        `json:"community,omitempty"`
+	Inform     bool          `json:"inform"`
+	Uptime     uint32        `json:"uptime"`
+	TrapOID    string        `json:"trap_oid"`
+	TrapName   string        `json:"trap_name,omitempty"`
+	Varbinds   []SNMPVarbind `json:"varbinds"`
+	ReceivedAt time.Time     `json:"received_at"`
+}
+
+// TrapStore 定长环形缓冲区，保存最近收到的trap
+type TrapStore struct {
+	events []TrapEvent
+	next   int
+	full   bool
+	seq    uint64
+	mutex  sync.RWMutex
+
+	received  int64
+	malformed int64
+}
+
+func NewTrapStore(size int) *TrapStore {
+	if size < 1 {
+		size = 1
+	}
+	return &TrapStore{events: make([]TrapEvent, size)}
+}
+
+func (ts *TrapStore) Add(event TrapEvent) TrapEvent {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.seq++
+	event.ID = ts.seq
+	ts.events[ts.next] = event
+	ts.next = (ts.next + 1) % len(ts.events)
+	if ts.next == 0 {
+		ts.full = true
+	}
+	return event
+}
+
+type TrapFilter struct {
+	Source  string
+	TrapOID string
+	Since   time.Time
+	Limit   int
+}
+
+// Query 按时间倒序返回匹配的trap
+func (ts *TrapStore) Query(filter TrapFilter) []TrapEvent {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	count := ts.next
+	if ts.full {
+		count = len(ts.events)
+	}
+
+	result := []TrapEvent{}
+	for i := 0; i < count; i++ {
+		idx := (ts.next - 1 - i + len(ts.events)) % len(ts.events)
+		event := ts.events[idx]
+		if filter.Source != "" && event.Source != filter.Source {
+			continue
+		}
+		if filter.TrapOID != "" && !strings.HasPrefix(event.TrapOID, filter.TrapOID) {
+			continue
+		}
+		if !filter.Since.IsZero() && event.ReceivedAt.Before(filter.Since) {
+			continue
+		}
+		result = append(result, event)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// trapLogger 接收gosnmp的内部日志，统计异常报文并抽样输出
+type trapLogger struct {
+	store *TrapStore
+}
+
+func (l trapLogger) Print(v ...interface{}) {
+	l.Printf("%s", fmt.Sprint(v...))
+}
+
+func (l trapLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if !strings.Contains(msg, "TrapListener") {
+		return
+	}
+	n := atomic.AddInt64(&l.store.malformed, 1)
+	if n%trapLogSampleRate == 1 {
+		logWarn("snmp_trap.malformed", strings.TrimSpace(msg), "malformed_total", n)
+	}
+}
+
+func normalizeTrap(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) TrapEvent {
+	event := TrapEvent{
+		Source:     addr.IP.String(),
+		Version:    packet.Version.String(),
+		Community:  packet.Community,
+		Inform:     packet.PDUType == gosnmp.InformRequest,
+		Varbinds:   []SNMPVarbind{},
+		ReceivedAt: time.Now(),
+	}
+
+	// v1 trap的企业OID与uptime位于PDU头部
+	if packet.Version == gosnmp.Version1 {
+		event.Uptime = uint32(packet.Timestamp)
+		event.TrapOID = strings.TrimPrefix(packet.Enterprise, ".")
+		if packet.GenericTrap == 6 {
+			event.TrapOID = fmt.Sprintf("%s.0.%d", event.TrapOID, packet.SpecificTrap)
+		}
+	}
+
+	for _, pdu := range packet.Variables {
+		vb := convertVarbind(pdu)
+		switch vb.OID {
+		case oidSysUpTime:
+			if ticks, ok := pdu.Value.(uint32); ok {
+				event.Uptime = ticks
+			}
+		case oidSnmpTrapOID:
+			event.TrapOID = fmt.Sprint(vb.Value)
+		default:
+			event.Varbinds = append(event.Varbinds, vb)
+		}
+	}
+	event.TrapName = resolveOID(event.TrapOID)
+	return event
+}
+
+var traps = NewTrapStore(int(envInt64("SNMP_TRAP_BUFFER", 1000)))
+
+// startTrapListener 按环境变量启动trap监听，SNMP_TRAP_ADDR为空时不启用
+func startTrapListener() {
+	addr := getEnv("SNMP_TRAP_ADDR", "")
+	if addr == "" {
+		return
+	}
+
+	params := &gosnmp.GoSNMP{
+		Version: gosnmp.Version2c,
+		Timeout: 5 * time.Second,
+		Logger:  gosnmp.NewLogger(trapLogger{store: traps}),
+	}
+	if user := getEnv("SNMP_TRAP_V3_USER", ""); user != "" {
+		flags, usm, err := usmParameters(SNMPRequest{
+			Username:          user,
+			AuthProtocol:      getEnv("SNMP_TRAP_V3_AUTH_PROTOCOL", ""),
+			AuthPassphraseRef: getEnv("SNMP_TRAP_V3_AUTH_PASSPHRASE_REF", ""),
+			PrivProtocol:      getEnv("SNMP_TRAP_V3_PRIV_PROTOCOL", ""),
+			PrivPassphraseRef: getEnv("SNMP_TRAP_V3_PRIV_PASSPHRASE_REF", ""),
+		})
+		if err != nil {
+			logError("snmp_trap.disabled", "snmp trap listener disabled", "error", err)
+			return
+		}
+		params.Version = gosnmp.Version3
+		params.SecurityModel = gosnmp.UserSecurityModel
+		params.MsgFlags = flags
+		params.SecurityParameters = usm
+	}
+	community := getEnv("SNMP_TRAP_COMMUNITY", "")
+
+	go func() {
+		for {
+			listener := gosnmp.NewTrapListener()
+			listener.Params = params
+			// 收到Inform后gosnmp会自动回复Response
+			listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+				defer func() {
+					if r := recover(); r != nil {
+						atomic.AddInt64(&traps.malformed, 1)
+						logError("snmp_trap.panic", "snmp trap handler panic", "source", addr, "panic", fmt.Sprint(r))
+					}
+				}()
+
+				if community != "" && packet.Version != gosnmp.Version3 && packet.Community != community {
+					atomic.AddInt64(&traps.malformed, 1)
+					return
+				}
+				atomic.AddInt64(&traps.received, 1)
+				event := traps.Add(normalizeTrap(packet, addr))
+				sinks.Publish("trap", event)
+			}
+
+			logInfo("snmp_trap.start", "starting SNMP trap listener", "address", addr)
+			err := listener.Listen(addr)
+			logWarn("snmp_trap.stopped", "snmp trap listener stopped, restarting", "address", addr, "error", err)
+			time.Sleep(5 * time.Second)
+		}
+	}()
+}
+
+func registerTrapRoutes(r *gin.Engine) {
+	// 查询最近收到的trap
+	r.GET("/traps", func(c *gin.Context) {
+		filter := TrapFilter{
+			Source:  c.Query("source"),
+			TrapOID: strings.TrimPrefix(c.Query("trap_oid"), "."),
+			Limit:   100,
+		}
+		if since := c.Query("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, errors.New("since must be RFC3339"))
+				return
+			}
+			filter.Since = t
+		}
+		if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+			filter.Limit = limit
+		}
+
+		events := traps.Query(filter)
+		c.JSON(http.StatusOK, gin.H{
+			"traps":     events,
+			"count":     len(events),
+			"received":  atomic.LoadInt64(&traps.received),
+			"malformed": atomic.LoadInt64(&traps.malformed),
+			"timestamp": time.Now(),
+		})
+	})
+}
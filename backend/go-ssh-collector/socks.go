@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SOCKS5 协议常量 (RFC 1928 / RFC 1929)
+const (
+	socksVersion      = 5
+	socksAuthUserPass = 2
+	socksNoAcceptable = 0xff
+	socksCmdConnect   = 1
+	socksAtypIPv4     = 1
+	socksAtypDomain   = 3
+	socksAtypIPv6     = 4
+
+	socksReplySucceeded       = 0
+	socksReplyNotAllowed      = 2
+	socksReplyHostUnreachable = 4
+	socksReplyCmdUnsupported  = 7
+	socksReplyAtypUnsupported = 8
+)
+
+var socksIdleTimeout = time.Duration(envInt64("SOCKS_IDLE_TIMEOUT", 900)) * time.Second
+
+// SOCKSProxy 启动SOCKS5监听，CONNECT经SSH连接建立，用户名字段须为返回的token
+func (fm *ForwardManager) SOCKSProxy(connectionID, bindAddress string, port int) (*Forward, string, error) {
+	conn, err := collector.getConnection(connectionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if bindAddress == "" {
+		bindAddress = forwardBindAddr
+	}
+	if port < 0 || port > 65535 {
+		return nil, "", errors.New("invalid port")
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to listen: %v", err)
+	}
+
+	token := newID()
+	f := newForward("socks", connectionID, conn, "*")
+	f.setListener(listener)
+	f.touch()
+	fm.add(f)
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				if !f.closed() {
+					logWarn("socks.accept_failed", "socks proxy accept failed", "forward_id", f.ID, "error", err)
+					fm.Remove(f.ID)
+				}
+				return
+			}
+			f.touch()
+			go f.serveSOCKS(client, token)
+		}
+	}()
+	go fm.expireIdle(f)
+
+	return f, token, nil
+}
+
+// expireIdle 无活跃连接且超过空闲时间后自动关闭代理
+func (fm *ForwardManager) expireIdle(f *Forward) {
+	if socksIdleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(socksIdleTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			recordSweep("socks_idle")
+			if atomic.LoadInt64(&f.activeConns) == 0 && time.Since(f.lastActivity()) > socksIdleTimeout {
+				logInfo("socks.idle_closed", "socks proxy idle, closing", "forward_id", f.ID, "connection_id", f.ConnectionID)
+				fm.Remove(f.ID)
+				return
+			}
+		}
+	}
+}
+
+func (f *Forward) serveSOCKS(client net.Conn, token string) {
+	client.SetDeadline(time.Now().Add(30 * time.Second))
+	reader := bufio.NewReader(client)
+
+	if err := socksAuthenticate(reader, client, token); err != nil {
+		f.setError(err)
+		client.Close()
+		return
+	}
+
+	target, err := socksReadRequest(reader)
+	if err != nil {
+		var reply socksReplyError
+		if errors.As(err, &reply) {
+			socksReply(client, reply.code)
+		}
+		f.setError(err)
+		client.Close()
+		return
+	}
+
+	// 每次CONNECT都按目标地址策略校验，域名在本地解析后校验
+	ip, err := resolveSOCKSTarget(target.host)
+	if err != nil {
+		socksReply(client, socksReplyHostUnreachable)
+		f.setError(err)
+		client.Close()
+		return
+	}
+	if err := checkDestination(ip); err != nil {
+		socksReply(client, socksReplyNotAllowed)
+		f.setError(err)
+		client.Close()
+		return
+	}
+
+	remote, err := f.conn.Client.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(target.port)))
+	if err != nil {
+		socksReply(client, socksReplyHostUnreachable)
+		f.setError(err)
+		client.Close()
+		return
+	}
+	if err := socksReply(client, socksReplySucceeded); err != nil {
+		remote.Close()
+		client.Close()
+		return
+	}
+	client.SetDeadline(time.Time{})
+
+	// 握手阶段已缓冲的数据需先转发
+	if n := reader.Buffered(); n > 0 {
+		buffered, _ := reader.Peek(n)
+		remote.Write(buffered)
+	}
+	f.proxy(client, remote)
+	f.touch()
+}
+
+func socksAuthenticate(reader *bufio.Reader, w io.Writer, token string) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return err
+	}
+	supported := false
+	for _, m := range methods {
+		if m == socksAuthUserPass {
+			supported = true
+		}
+	}
+	if !supported {
+		w.Write([]byte{socksVersion, socksNoAcceptable})
+		return errors.New("client does not support username/password auth")
+	}
+	if _, err := w.Write([]byte{socksVersion, socksAuthUserPass}); err != nil {
+		return err
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	ver, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	username, err := readSOCKSString(reader)
+	if err != nil {
+		return err
+	}
+	if _, err := readSOCKSString(reader); err != nil {
+		return err
+	}
+	if ver != 1 || subtle.ConstantTimeCompare([]byte(username), []byte(token)) != 1 {
+		w.Write([]byte{1, 1})
+		return errors.New("socks authentication failed")
+	}
+	_, err = w.Write([]byte{1, 0})
+	return err
+}
+
+func readSOCKSString(reader *bufio.Reader) (string, error) {
+	n, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+type socksTarget struct {
+	host string
+	port int
+}
+
+type socksReplyError struct {
+	code byte
+	msg  string
+}
+
+func (e socksReplyError) Error() string { return e.msg }
+
+func socksReadRequest(reader *bufio.Reader) (socksTarget, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return socksTarget{}, err
+	}
+	if header[1] != socksCmdConnect {
+		return socksTarget{}, socksReplyError{socksReplyCmdUnsupported, fmt.Sprintf("unsupported socks command %d", header[1])}
+	}
+
+	var host string
+	switch header[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if header[3] == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return socksTarget{}, err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		domain, err := readSOCKSString(reader)
+		if err != nil {
+			return socksTarget{}, err
+		}
+		host = domain
+	default:
+		return socksTarget{}, socksReplyError{socksReplyAtypUnsupported, fmt.Sprintf("unsupported address type %d", header[3])}
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return socksTarget{}, err
+	}
+	return socksTarget{host: host, port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+func resolveSOCKSTarget(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return ips[0], nil
+}
+
+func socksReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socksVersion, code, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func registerSOCKSRoutes(r *gin.Engine) {
+	r.POST("/connections/:id/socks", func(c *gin.Context) {
+		var req struct {
+			BindAddress string `json:"bind_address"`
+			Port        int    `json:"port"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		f, token, err := forwards.SOCKSProxy(c.Param("id"), req.BindAddress, req.Port)
+		if err != nil {
+			respondError(c, forwardErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"forward_id":    f.ID,
+			"bound_address": f.ListenAddr,
+			"token":         token,
+			"idle_timeout":  socksIdleTimeout.Seconds(),
+			"timestamp":     time.Now(),
+		})
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type SSHConnection struct {
+	Client    *ssh.Client
+	Config    SSHConfig
+	CreatedAt time.Time
+
+	sftpClient *sftp.Client
+	sftpMutex  sync.Mutex
+
+	netconf      *NetconfSession
+	netconfMutex sync.Mutex
+
+	agentEnabled bool
+	agentMutex   sync.Mutex
+
+	// closeHooks 在连接关闭时调用，用于让经由该连接的隧道失效
+	closeHooks []func()
+	hookMutex  sync.Mutex
+}
+
+var (
+	// SSH_DISABLE_AGENT_FORWARDING=true 时全局禁止agent转发
+	agentForwardingDisabled    = os.Getenv("SSH_DISABLE_AGENT_FORWARDING") == "true"
+	errAgentForwardingDisabled = errors.New("agent forwarding disabled by policy")
+	errSSHAuthFailed           = errors.New("ssh authentication failed")
+)
+
+type sshCollector struct{}
+
+func init() {
+	registerCollector(sshCollector{})
+}
+
+func (sshCollector) Protocol() string { return "ssh" }
+
+func (sshCollector) Connect(config SSHConfig) (Connection, error) {
+	// 设置默认值
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+
+	// SSH客户端配置
+	sshConfig := &ssh.ClientConfig{
+		User: config.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(config.Password),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Duration(config.Timeout) * time.Second,
+	}
+
+	// 建立连接
+	address := net.JoinHostPort(config.Host, fmt.Sprint(config.Port))
+	_, dialSpan := startSpanKind(config.trace, "ssh.dial", spanKindClient, "net.peer.name", config.Host, "net.peer.port", config.Port)
+	netConn, err := dialTimed(config.timing, config.Host, config.Port, sshConfig.Timeout)
+	dialSpan.End(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	// 密钥交换完成时调用HostKeyCallback，以此区分握手和认证两个阶段
+	_, handshakeSpan := startSpan(config.trace, "ssh.handshake")
+	handshakeStart := time.Now()
+	var authSpan *Span
+	var authStart time.Time
+	var hostKeyErr error
+	hostKeyCallback := sshConfig.HostKeyCallback
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hostKeyCallback(hostname, remote, key)
+		hostKeyErr = err
+		handshakeSpan.End(err)
+		config.timing.observe(phaseHandshake, time.Since(handshakeStart), err)
+		if err == nil {
+			_, authSpan = startSpan(config.trace, "ssh.auth")
+			authStart = time.Now()
+		}
+		return err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, address, sshConfig)
+	handshakeSpan.End(err)
+	authSpan.End(err)
+	switch {
+	case !authStart.IsZero():
+		config.timing.observe(phaseAuth, time.Since(authStart), err)
+	case hostKeyErr == nil:
+		// 密钥交换之前失败
+		config.timing.observe(phaseHandshake, time.Since(handshakeStart), err)
+	}
+	if err != nil {
+		netConn.Close()
+		// x/crypto/ssh没有导出认证失败的错误类型，只能按错误信息判断
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, fmt.Errorf("failed to connect: %w: %v", errSSHAuthFailed, err)
+		}
+		// 握手错误只保留文本，主机密钥被拒绝时由回调记录，其余视为连接中断
+		switch {
+		case hostKeyErr != nil:
+			return nil, fmt.Errorf("failed to connect: %w", withErrorClass(classHostKeyMismatch, err))
+		case strings.Contains(err.Error(), "i/o timeout"):
+			return nil, fmt.Errorf("failed to connect: %w", withErrorClass(classTCPTimeout, err))
+		}
+		return nil, fmt.Errorf("failed to connect: %w", withErrorClass(classConnectionLost, err))
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	conn := &SSHConnection{
+		Client:    client,
+		Config:    config,
+		CreatedAt: time.Now(),
+	}
+	if config.ForwardAgent {
+		if err := conn.enableAgent(); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// enableAgent 将远端的agent通道转发到本地SSH_AUTH_SOCK，每个连接只需设置一次。
+// 只有请求了转发的会话能打开agent通道，direct-tcpip隧道（via_connection_id）不受影响
+func (conn *SSHConnection) enableAgent() error {
+	if agentForwardingDisabled {
+		return errAgentForwardingDisabled
+	}
+	conn.agentMutex.Lock()
+	defer conn.agentMutex.Unlock()
+
+	if conn.agentEnabled {
+		return nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("agent forwarding requested but SSH_AUTH_SOCK is not set")
+	}
+	sock, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to local agent: %v", err)
+	}
+	if err := agent.ForwardToAgent(conn.Client, agent.NewClient(sock)); err != nil {
+		sock.Close()
+		return err
+	}
+	conn.OnClose(func() { sock.Close() })
+	conn.agentEnabled = true
+	return nil
+}
+
+func (conn *SSHConnection) Info() ConnectionInfo {
+	return ConnectionInfo{
+		Protocol:   "ssh",
+		DeviceType: conn.Config.DeviceType,
+		Host:       conn.Config.Host,
+		Port:       conn.Config.Port,
+		Username:   conn.Config.Username,
+		CreatedAt:  conn.CreatedAt,
+	}
+}
+
+func (conn *SSHConnection) Execute(shell, command string) (*CommandResult, error) {
+	return conn.execute(context.Background(), command, conn.Config.ForwardAgent, nil)
+}
+
+// execute stream非空时同时把输出实时写入stream，ctx取消时终止命令
+func (conn *SSHConnection) execute(ctx context.Context, command string, forwardAgent bool, stream io.Writer) (*CommandResult, error) {
+	if forwardAgent {
+		if err := conn.enableAgent(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 创建会话
+	_, openSpan := startSpan(ctx, "ssh.session_open")
+	opened := time.Now()
+	session, err := conn.Client.NewSession()
+	openSpan.End(err)
+	sessionOpen := time.Since(opened)
+	if id, ok := collector.Lookup(conn.Info()); ok && err == nil {
+		connStats.ObserveSessionOpen(id, sessionOpen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", withErrorClass(classSessionOpenFailed, err))
+	}
+	defer session.Close()
+	defer trackSession(sessionExec)()
+
+	var agentForwarded *bool
+	if forwardAgent {
+		// 远端拒绝时仍执行命令，结果中记录未获准
+		granted := agent.RequestAgentForwarding(session) == nil
+		agentForwarded = &granted
+	}
+
+	// 执行命令，输出超过上限时截断
+	output := newCappedBuffer(maxCommandOutput)
+	session.Stdout = output
+	session.Stderr = output
+	if stream != nil {
+		session.Stdout = io.MultiWriter(output, stream)
+		session.Stderr = session.Stdout
+	}
+	_, execSpan := startSpan(ctx, "ssh.exec")
+	started := time.Now()
+	if err := session.Start(command); err != nil {
+		execSpan.End(err)
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+	execSpan.End(nil)
+	_, readSpan := startSpan(ctx, "ssh.output_read")
+	reading := time.Now()
+	err = waitCommand(ctx, session.Wait, func() {
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+	}, commandTimeout)
+
+	result := &CommandResult{
+		Command:        command,
+		Output:         output.String(),
+		Truncated:      output.Truncated(),
+		AgentForwarded: agentForwarded,
+		Timestamp:      time.Now(),
+		timing:         commandTiming{SessionOpen: sessionOpen, Start: reading.Sub(started), OutputRead: time.Since(reading)},
+	}
+
+	var exitErr *ssh.ExitError
+	if err == nil {
+		code := 0
+		result.ExitCode = &code
+	} else if errors.As(err, &exitErr) {
+		code := exitErr.ExitStatus()
+		result.ExitCode = &code
+	}
+	readSpan.SetAttributes("output_bytes", len(result.Output), "truncated", result.Truncated)
+	if result.ExitCode != nil {
+		// 非0退出码不算span错误
+		readSpan.End(nil)
+	} else {
+		readSpan.End(err)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.ErrorClass = resultErrorClass(result, err)
+
+	return result, nil
+}
+
+// HealthCheck 发送keepalive请求确认连接可用
+func (conn *SSHConnection) HealthCheck() error {
+	_, _, err := conn.Client.SendRequest("keepalive@openssh.com", true, nil)
+	return err
+}
+
+// OnClose 注册连接关闭时的回调
+func (conn *SSHConnection) OnClose(hook func()) {
+	conn.hookMutex.Lock()
+	conn.closeHooks = append(conn.closeHooks, hook)
+	conn.hookMutex.Unlock()
+}
+
+func (conn *SSHConnection) Close() error {
+	conn.hookMutex.Lock()
+	hooks := conn.closeHooks
+	conn.closeHooks = nil
+	conn.hookMutex.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+
+	forwards.CloseConnection(conn)
+	conn.closeSFTP()
+	conn.closeNetconf()
+	return conn.Client.Close()
+}
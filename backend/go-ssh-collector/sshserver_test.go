@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// 测试用的进程内SSH服务器：密码认证、exec、sftp子系统、direct-tcpip通道和agent转发请求
+const (
+	testSSHUser     = "tester"
+	testSSHPassword = "s3cret-test-password"
+)
+
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	// exec 处理exec请求，返回退出码；为nil时按命令不存在（127）处理
+	exec func(command string, stdout io.Writer) int
+	// dial 处理direct-tcpip通道，为nil时拒绝
+	dial func(address string) (net.Conn, error)
+	// grantAgent 是否同意auth-agent-req@openssh.com
+	grantAgent bool
+
+	agentRequests int64
+	conns         sync.Map
+}
+
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{t: t}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == testSSHUser && string(password) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", meta.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	go s.serve()
+	return s
+}
+
+func (s *testSSHServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Config 连接该服务器的SSHConfig
+func (s *testSSHServer) Config() SSHConfig {
+	return SSHConfig{Host: "127.0.0.1", Port: s.Port(), Username: testSSHUser, Password: testSSHPassword, Timeout: 5}
+}
+
+// Close 停止监听并断开所有客户端，用于模拟传输中途断线
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+	s.DropConnections()
+}
+
+func (s *testSSHServer) DropConnections() {
+	s.conns.Range(func(key, _ interface{}) bool {
+		key.(net.Conn).Close()
+		s.conns.Delete(key)
+		return true
+	})
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Store(conn, struct{}{})
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHServer) handle(netConn net.Conn) {
+	defer s.conns.Delete(netConn)
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, s.config)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	defer conn.Close()
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				req.Reply(req.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.session(newChannel)
+		case "direct-tcpip":
+			go s.directTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *testSSHServer) session(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	for req := range reqs {
+		switch req.Type {
+		case "auth-agent-req@openssh.com":
+			atomic.AddInt64(&s.agentRequests, 1)
+			req.Reply(s.grantAgent, nil)
+		case "exec":
+			req.Reply(true, nil)
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			code := 127
+			if s.exec != nil {
+				code = s.exec(payload.Command, channel)
+			} else {
+				fmt.Fprintf(channel.Stderr(), "sh: %s: command not found\n", payload.Command)
+			}
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, uint32(code))
+			channel.SendRequest("exit-status", false, status)
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			if payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s *testSSHServer) directTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil || s.dial == nil {
+		newChannel.Reject(ssh.Prohibited, "direct-tcpip not allowed")
+		return
+	}
+	target, err := s.dial(net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(channel, target)
+		channel.CloseWrite()
+	}()
+	io.Copy(target, channel)
+	target.Close()
+	channel.Close()
+}
+
+// allowLoopbackDestinations 测试服务器监听在回环地址，测试期间放开127.0.0.1
+func allowLoopbackDestinations(t *testing.T) {
+	t.Helper()
+	useDestinationPolicy(t, &DestinationPolicy{Deny: append([]string{}, defaultDenyCIDRs...), Allow: []string{"127.0.0.1/32"}})
+}
+
+// useDestinationPolicy 在测试期间替换目标地址策略，不写入文件
+func useDestinationPolicy(t *testing.T, policy *DestinationPolicy) {
+	t.Helper()
+	previous := currentDestinationPolicy()
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+	destinationPolicy.mutex.Lock()
+	destinationPolicy.policy = policy
+	destinationPolicy.mutex.Unlock()
+	t.Cleanup(func() {
+		destinationPolicy.mutex.Lock()
+		destinationPolicy.policy = previous
+		destinationPolicy.mutex.Unlock()
+	})
+}
+
+// connectTestSSH 经ConnectionManager连接测试服务器，返回连接ID
+func connectTestSSH(t *testing.T, s *testSSHServer) string {
+	t.Helper()
+	allowLoopbackDestinations(t)
+	id, err := collector.Connect(s.Config())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { collector.Disconnect(id) })
+	return id
+}
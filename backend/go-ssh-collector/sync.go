@@ -0,0 +1,552 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
+)
+
+const syncMaxDepth = 32
+
+// 本地同步目录根，所有本地路径都限制在该目录下
+var syncRoot = getEnv("SYNC_ROOT", "/var/lib/go-ssh-collector/sync")
+
+func getEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+type SyncRequest struct {
+	Direction        string `json:"direction" binding:"required"`
+	LocalPath        string `json:"local_path" binding:"required"`
+	RemotePath       string `json:"remote_path" binding:"required"`
+	DeleteExtraneous bool   `json:"delete_extraneous"`
+	DryRun           bool   `json:"dry_run"`
+	Compare          string `json:"compare"`
+	Symlinks         string `json:"symlinks"`
+}
+
+type SyncReport struct {
+	Direction string      `json:"direction"`
+	DryRun    bool        `json:"dry_run"`
+	Added     []string    `json:"added"`
+	Updated   []string    `json:"updated"`
+	Deleted   []string    `json:"deleted"`
+	Skipped   []string    `json:"skipped"`
+	Errors    []SyncError `json:"errors"`
+	Duration  float64     `json:"duration"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type SyncError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type syncEntry struct {
+	size   int64
+	mtime  time.Time
+	mode   os.FileMode
+	link   string
+	isDir  bool
+	isLink bool
+}
+
+// syncFS 抽象本地文件系统与SFTP，使推送和拉取共用同一套比较逻辑
+type syncFS interface {
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadLink(name string) (string, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(dir string) error
+	Remove(name string) error
+	Symlink(target, name string) error
+	Chtimes(name string, mtime time.Time) error
+	Join(elem ...string) string
+}
+
+var errSyncOutsideRoot = errors.New("path is outside SYNC_ROOT")
+
+// localFS 本地文件系统，所有路径在解析符号链接后必须位于root之下
+type localFS struct {
+	root     string
+	resolved string
+}
+
+func newLocalFS(root string) (localFS, error) {
+	root = filepath.Clean(root)
+	resolved, err := resolveExisting(root)
+	if err != nil {
+		return localFS{}, err
+	}
+	return localFS{root: root, resolved: resolved}, nil
+}
+
+// resolveExisting 解析路径中已存在部分的符号链接，不存在的部分原样拼接（不存在的部分不可能是链接）
+func resolveExisting(name string) (string, error) {
+	existing, rest := filepath.Clean(name), ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+func withinDir(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// confine 解析name父目录的符号链接，返回解析后的路径；name本身不解析，由调用方决定是否替换链接
+func (l localFS) confine(name string) (string, error) {
+	name = filepath.Clean(name)
+	if name == l.root {
+		return l.resolved, nil
+	}
+	parent, err := resolveExisting(filepath.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(l.resolved, parent) {
+		return "", fmt.Errorf("%w: %s", errSyncOutsideRoot, name)
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// confineTarget 完全解析name（包括name本身的链接），用于读取
+func (l localFS) confineTarget(name string) error {
+	resolved, err := resolveExisting(name)
+	if err != nil {
+		return err
+	}
+	if !withinDir(l.resolved, resolved) {
+		return fmt.Errorf("%w: %s", errSyncOutsideRoot, name)
+	}
+	return nil
+}
+
+// replaceLink 目标位置已是符号链接时先删除链接，避免经由链接写到别处
+func (l localFS) replaceLink(name string) error {
+	if filepath.Clean(name) == l.root {
+		return nil
+	}
+	if info, err := os.Lstat(name); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(name)
+	}
+	return nil
+}
+
+func (localFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Stat 跟随链接，链接目标在根目录之外时按不存在处理
+func (l localFS) Stat(name string) (os.FileInfo, error) {
+	if err := l.confineTarget(name); err != nil {
+		return nil, err
+	}
+	return os.Stat(name)
+}
+
+func (localFS) ReadLink(name string) (string, error) { return os.Readlink(name) }
+
+func (l localFS) Open(name string) (io.ReadCloser, error) {
+	if err := l.confineTarget(name); err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (l localFS) Create(name string) (io.WriteCloser, error) {
+	if _, err := l.confine(name); err != nil {
+		return nil, err
+	}
+	if err := l.replaceLink(name); err != nil {
+		return nil, err
+	}
+	return os.Create(name)
+}
+
+func (l localFS) MkdirAll(dir string) error {
+	if _, err := l.confine(dir); err != nil {
+		return err
+	}
+	if err := l.replaceLink(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// Remove 删除链接本身，不影响链接目标
+func (l localFS) Remove(name string) error {
+	if _, err := l.confine(name); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Symlink 链接目标也必须位于根目录之下
+func (l localFS) Symlink(target, name string) error {
+	resolved, err := l.confine(name)
+	if err != nil {
+		return err
+	}
+	dest := target
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(resolved), dest)
+	}
+	dest = filepath.Clean(dest)
+	if !withinDir(l.resolved, dest) && !withinDir(l.root, dest) {
+		return fmt.Errorf("%w: link target %s", errSyncOutsideRoot, target)
+	}
+	return os.Symlink(target, name)
+}
+
+func (l localFS) Chtimes(name string, mtime time.Time) error {
+	if _, err := l.confine(name); err != nil {
+		return err
+	}
+	return os.Chtimes(name, mtime, mtime)
+}
+
+func (localFS) Join(elem ...string) string { return filepath.Join(elem...) }
+
+type remoteFS struct {
+	client *sftp.Client
+}
+
+func (r remoteFS) ReadDir(dir string) ([]os.FileInfo, error) { return r.client.ReadDir(dir) }
+func (r remoteFS) Stat(name string) (os.FileInfo, error)     { return r.client.Stat(name) }
+func (r remoteFS) ReadLink(name string) (string, error)      { return r.client.ReadLink(name) }
+func (r remoteFS) Open(name string) (io.ReadCloser, error)   { return r.client.Open(name) }
+func (r remoteFS) Create(name string) (io.WriteCloser, error) {
+	return r.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+func (r remoteFS) MkdirAll(dir string) error         { return r.client.MkdirAll(dir) }
+func (r remoteFS) Remove(name string) error          { return r.client.Remove(name) }
+func (r remoteFS) Symlink(target, name string) error { return r.client.Symlink(target, name) }
+func (r remoteFS) Chtimes(name string, mtime time.Time) error {
+	return r.client.Chtimes(name, mtime, mtime)
+}
+func (r remoteFS) Join(elem ...string) string { return path.Join(elem...) }
+
+// walkSyncTree 递归列出root下的条目，键为相对路径（统一使用/分隔）
+func walkSyncTree(fsys syncFS, root, symlinks string) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	if _, err := fsys.Stat(root); os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	var walk func(dir, rel string, depth int) error
+	walk = func(dir, rel string, depth int) error {
+		if depth > syncMaxDepth {
+			return fmt.Errorf("directory nesting too deep: %s", dir)
+		}
+		infos, err := fsys.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			name := info.Name()
+			full := fsys.Join(dir, name)
+			relPath := path.Join(rel, name)
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				switch symlinks {
+				case "skip":
+					continue
+				case "copy":
+					target, err := fsys.ReadLink(full)
+					if err != nil {
+						return err
+					}
+					entries[relPath] = syncEntry{isLink: true, link: target, mtime: info.ModTime()}
+					continue
+				default:
+					// follow：按链接目标处理，悬空链接忽略
+					if info, err = fsys.Stat(full); err != nil {
+						continue
+					}
+				}
+			}
+
+			if info.IsDir() {
+				entries[relPath] = syncEntry{isDir: true, mode: info.Mode()}
+				if err := walk(full, relPath, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if info.Mode().IsRegular() {
+				entries[relPath] = syncEntry{size: info.Size(), mtime: info.ModTime(), mode: info.Mode()}
+			}
+		}
+		return nil
+	}
+
+	return entries, walk(root, "", 0)
+}
+
+func fileSHA256(fsys syncFS, name string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// syncEqual 判断源与目标条目是否一致
+func syncEqual(req SyncRequest, src, dst syncFS, srcRoot, dstRoot, rel string, s, d syncEntry) (bool, error) {
+	if s.isDir || d.isDir || s.isLink || d.isLink {
+		return s.isDir == d.isDir && s.isLink == d.isLink && s.link == d.link, nil
+	}
+	if s.size != d.size {
+		return false, nil
+	}
+	if req.Compare == "checksum" {
+		srcSum, err := fileSHA256(src, src.Join(srcRoot, rel))
+		if err != nil {
+			return false, err
+		}
+		dstSum, err := fileSHA256(dst, dst.Join(dstRoot, rel))
+		if err != nil {
+			return false, err
+		}
+		return srcSum == dstSum, nil
+	}
+	// SFTP的mtime精度为秒
+	return s.mtime.Unix() == d.mtime.Unix(), nil
+}
+
+func syncCopy(src, dst syncFS, srcPath, dstPath string, entry syncEntry) error {
+	if entry.isDir {
+		return dst.MkdirAll(dstPath)
+	}
+	if entry.isLink {
+		dst.Remove(dstPath)
+		return dst.Symlink(entry.link, dstPath)
+	}
+
+	in, err := src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := dst.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// 同步修改时间，使下次按size+mtime比较时判定为一致
+	return dst.Chtimes(dstPath, entry.mtime)
+}
+
+// Sync 单向同步目录，只传输差异部分
+func (cm *ConnectionManager) Sync(connectionID string, req SyncRequest) (*SyncReport, error) {
+	conn, err := cm.getConnection(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := conn.SFTP()
+	if err != nil {
+		return nil, err
+	}
+
+	localPath := filepath.Join(syncRoot, filepath.Clean("/"+req.LocalPath))
+	local, err := newLocalFS(syncRoot)
+	if err != nil {
+		return nil, err
+	}
+	// 本地同步目录本身（含其中的链接）也必须在根目录之下
+	if err := local.confineTarget(localPath); err != nil {
+		return nil, err
+	}
+	if req.Symlinks == "" {
+		req.Symlinks = "follow"
+	}
+
+	var src, dst syncFS
+	var srcRoot, dstRoot string
+	dstSymlinks := req.Symlinks
+	switch req.Direction {
+	case "push":
+		src, srcRoot, dst, dstRoot = local, localPath, remoteFS{client}, req.RemotePath
+	case "pull":
+		src, srcRoot, dst, dstRoot = remoteFS{client}, req.RemotePath, local, localPath
+		// 本地目标树不跟随链接：链接作为条目本身比较，更新时替换链接而不是写入链接目标
+		if dstSymlinks == "follow" {
+			dstSymlinks = "copy"
+		}
+	default:
+		return nil, fmt.Errorf("direction must be push or pull")
+	}
+
+	start := time.Now()
+	srcEntries, err := walkSyncTree(src, srcRoot, req.Symlinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk source: %v", err)
+	}
+	dstEntries, err := walkSyncTree(dst, dstRoot, dstSymlinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk destination: %v", err)
+	}
+
+	report := &SyncReport{
+		Direction: req.Direction,
+		DryRun:    req.DryRun,
+		Added:     []string{},
+		Updated:   []string{},
+		Deleted:   []string{},
+		Skipped:   []string{},
+		Errors:    []SyncError{},
+	}
+
+	// 排序保证先创建父目录
+	paths := make([]string, 0, len(srcEntries))
+	for rel := range srcEntries {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	if !req.DryRun {
+		if err := dst.MkdirAll(dstRoot); err != nil {
+			return nil, fmt.Errorf("failed to create destination: %v", err)
+		}
+	}
+
+	for _, rel := range paths {
+		entry := srcEntries[rel]
+		existing, exists := dstEntries[rel]
+
+		target := &report.Added
+		if exists {
+			equal, err := syncEqual(req, src, dst, srcRoot, dstRoot, rel, entry, existing)
+			if err != nil {
+				report.Errors = append(report.Errors, SyncError{Path: rel, Error: err.Error()})
+				continue
+			}
+			if equal {
+				report.Skipped = append(report.Skipped, rel)
+				continue
+			}
+			target = &report.Updated
+		}
+
+		if !req.DryRun {
+			if err := syncCopy(src, dst, src.Join(srcRoot, rel), dst.Join(dstRoot, rel), entry); err != nil {
+				report.Errors = append(report.Errors, SyncError{Path: rel, Error: err.Error()})
+				continue
+			}
+		}
+		*target = append(*target, rel)
+	}
+
+	if req.DeleteExtraneous {
+		var extraneous []string
+		for rel := range dstEntries {
+			if _, exists := srcEntries[rel]; !exists {
+				extraneous = append(extraneous, rel)
+			}
+		}
+		// 逆序删除，先删文件再删目录
+		sort.Sort(sort.Reverse(sort.StringSlice(extraneous)))
+		for _, rel := range extraneous {
+			if !req.DryRun {
+				if err := dst.Remove(dst.Join(dstRoot, rel)); err != nil {
+					report.Errors = append(report.Errors, SyncError{Path: rel, Error: err.Error()})
+					continue
+				}
+			}
+			report.Deleted = append(report.Deleted, rel)
+		}
+		sort.Strings(report.Deleted)
+	}
+
+	report.Duration = time.Since(start).Seconds()
+	report.Timestamp = time.Now()
+	return report, nil
+}
+
+func registerSyncRoutes(r *gin.Engine) {
+	// 目录单向同步
+	r.POST("/connections/:id/files/sync", func(c *gin.Context) {
+		var req SyncRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if req.Compare != "" && req.Compare != "size_mtime" && req.Compare != "checksum" {
+			respondError(c, http.StatusBadRequest, errors.New("compare must be size_mtime or checksum"))
+			return
+		}
+		switch req.Symlinks {
+		case "", "follow", "skip", "copy":
+		default:
+			respondError(c, http.StatusBadRequest, errors.New("symlinks must be follow, skip or copy"))
+			return
+		}
+
+		report, err := collector.Sync(c.Param("id"), req)
+		if err != nil {
+			respondError(c, fileErrorStatus(err), err)
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	})
+}
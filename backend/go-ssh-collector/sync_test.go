@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupSyncTest 同步根目录、根目录外的目录和SFTP远端目录（测试SFTP服务器直接服务本地文件系统）
+func setupSyncTest(t *testing.T) (id, root, outside, remote string) {
+	t.Helper()
+	root = filepath.Join(t.TempDir(), "sync")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := syncRoot
+	syncRoot = root
+	t.Cleanup(func() { syncRoot = previous })
+	return connectTestSSH(t, startTestSSHServer(t)), root, t.TempDir(), t.TempDir()
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertNotExist(t *testing.T, name string) {
+	t.Helper()
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		t.Fatalf("%s should not exist (err=%v)", name, err)
+	}
+}
+
+func syncErrorPaths(report *SyncReport) map[string]bool {
+	paths := map[string]bool{}
+	for _, e := range report.Errors {
+		paths[e.Path] = true
+	}
+	return paths
+}
+
+func TestSyncPullDoesNotWriteThroughLocalSymlink(t *testing.T) {
+	id, root, outside, remote := setupSyncTest(t)
+	writeTestFile(t, filepath.Join(remote, "a", "passwd"), "pulled")
+	// 本地目标树里已有指向根目录外的链接
+	if err := os.Symlink(outside, filepath.Join(root, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := collector.Sync(id, SyncRequest{Direction: "pull", LocalPath: "/", RemotePath: remote})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	assertNotExist(t, filepath.Join(outside, "passwd"))
+	if len(report.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", report.Errors)
+	}
+	// 链接被替换为目录，文件写在根目录之内
+	info, err := os.Lstat(filepath.Join(root, "a"))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("a should have been replaced by a directory: %v %v", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "a", "passwd")); err != nil || string(data) != "pulled" {
+		t.Fatalf("a/passwd = %q, %v", data, err)
+	}
+}
+
+func TestSyncPullRejectsLinkTargetOutsideRoot(t *testing.T) {
+	id, root, outside, remote := setupSyncTest(t)
+	if err := os.Symlink(outside, filepath.Join(remote, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../..", filepath.Join(remote, "up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("data", filepath.Join(remote, "inside")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(remote, "data"), "data")
+
+	report, err := collector.Sync(id, SyncRequest{Direction: "pull", LocalPath: "/", RemotePath: remote, Symlinks: "copy"})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	failed := syncErrorPaths(report)
+	if !failed["escape"] || !failed["up"] || failed["inside"] {
+		t.Fatalf("errors = %+v", report.Errors)
+	}
+	assertNotExist(t, filepath.Join(root, "escape"))
+	assertNotExist(t, filepath.Join(root, "up"))
+	if target, err := os.Readlink(filepath.Join(root, "inside")); err != nil || target != "data" {
+		t.Fatalf("inside -> %q, %v", target, err)
+	}
+}
+
+func TestSyncDeleteExtraneousDoesNotFollowLocalSymlink(t *testing.T) {
+	id, root, outside, remote := setupSyncTest(t)
+	writeTestFile(t, filepath.Join(outside, "keep"), "keep")
+	if err := os.Symlink(outside, filepath.Join(root, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := collector.Sync(id, SyncRequest{Direction: "pull", LocalPath: "/", RemotePath: remote, DeleteExtraneous: true})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != "b" {
+		t.Fatalf("deleted = %v, errors = %+v", report.Deleted, report.Errors)
+	}
+	assertNotExist(t, filepath.Join(root, "b"))
+	if _, err := os.Stat(filepath.Join(outside, "keep")); err != nil {
+		t.Fatalf("file outside the root was touched: %v", err)
+	}
+}
+
+func TestSyncPushDoesNotReadOutsideRoot(t *testing.T) {
+	id, root, outside, remote := setupSyncTest(t)
+	writeTestFile(t, filepath.Join(outside, "secret"), "secret")
+	writeTestFile(t, filepath.Join(root, "normal"), "normal")
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := collector.Sync(id, SyncRequest{Direction: "push", LocalPath: "/", RemotePath: remote})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "normal" {
+		t.Fatalf("added = %v, errors = %+v", report.Added, report.Errors)
+	}
+	assertNotExist(t, filepath.Join(remote, "secret"))
+}
+
+func TestSyncRejectsLocalPathThroughSymlink(t *testing.T) {
+	id, root, outside, remote := setupSyncTest(t)
+	writeTestFile(t, filepath.Join(remote, "file"), "file")
+	if err := os.Symlink(outside, filepath.Join(root, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := collector.Sync(id, SyncRequest{Direction: "pull", LocalPath: "sub", RemotePath: remote})
+	if !errors.Is(err, errSyncOutsideRoot) {
+		t.Fatalf("err = %v, want errSyncOutsideRoot", err)
+	}
+	assertNotExist(t, filepath.Join(outside, "file"))
+	if status := fileErrorStatus(err); status != 400 {
+		t.Fatalf("status = %d", status)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const syslogMaxMessageSize = 64 << 10
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+type SyslogMessage struct {
+	ID             uint64                       `json:"id"`
+	Source         string                       `json:"source"`
+	Transport      string                       `json:"transport"`
+	Format         string                       `json:"format"`
+	Priority       int                          `json:"priority"`
+	Facility       string                       `json:"facility"`
+	Severity       string                       `json:"severity"`
+	SeverityLevel  int                          `json:"severity_level"`
+	Timestamp      *time.Time                   `json:"timestamp,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	AppName        string                       `json:"app_name,omitempty"`
+	ProcID         string                       `json:"proc_id,omitempty"`
+	MsgID          string                       `json:"msg_id,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	Message        string                       `json:"message"`
+	ReceivedAt     time.Time                    `json:"received_at"`
+}
+
+// parsePriority 解析 <PRI> 前缀
+func parsePriority(line string) (int, string, error) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, "", fmt.Errorf("missing priority")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, "", fmt.Errorf("invalid priority")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return 0, "", fmt.Errorf("invalid priority")
+	}
+	return pri, line[end+1:], nil
+}
+
+func nilDash(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseStructuredData 解析RFC5424结构化数据，返回剩余的消息部分
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, strings.TrimPrefix(s[1:], " "), nil
+	}
+
+	data := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		i := 1
+		for i < len(s) && s[i] != ' ' && s[i] != ']' {
+			i++
+		}
+		id := s[1:i]
+		params := map[string]string{}
+		for i < len(s) && s[i] == ' ' {
+			i++
+			eq := strings.IndexByte(s[i:], '=')
+			if eq < 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+				return nil, "", fmt.Errorf("malformed structured data")
+			}
+			name := s[i : i+eq]
+			i += eq + 2
+			var value strings.Builder
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, "", fmt.Errorf("unterminated structured data")
+			}
+			params[name] = value.String()
+			i++
+		}
+		if i >= len(s) || s[i] != ']' {
+			return nil, "", fmt.Errorf("unterminated structured data")
+		}
+		data[id] = params
+		s = s[i+1:]
+	}
+	return data, strings.TrimPrefix(s, " "), nil
+}
+
+func parseRFC5424(msg *SyslogMessage, rest string) error {
+	fields := strings.SplitN(rest, " ", 7)
+	if len(fields) < 7 {
+		return fmt.Errorf("truncated rfc5424 message")
+	}
+	if ts := nilDash(fields[1]); ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: %s", ts)
+		}
+		msg.Timestamp = &t
+	}
+	msg.Format = "rfc5424"
+	msg.Hostname = nilDash(fields[2])
+	msg.AppName = nilDash(fields[3])
+	msg.ProcID = nilDash(fields[4])
+	msg.MsgID = nilDash(fields[5])
+
+	sd, text, err := parseStructuredData(fields[6])
+	if err != nil {
+		return err
+	}
+	msg.StructuredData = sd
+	msg.Message = strings.TrimPrefix(text, "\ufeff")
+	return nil
+}
+
+// parseRFC3164 尽量解析BSD格式，无法识别的部分保留在消息中
+func parseRFC3164(msg *SyslogMessage, rest string) {
+	msg.Format = "rfc3164"
+	msg.Message = rest
+
+	if len(rest) >= 16 {
+		if t, err := time.ParseInLocation(time.Stamp, rest[:15], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			// 跨年时设备时间可能属于去年
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = &t
+			rest = rest[16:]
+
+			if host, tail, ok := strings.Cut(rest, " "); ok {
+				msg.Hostname = host
+				rest = tail
+			}
+		}
+	}
+
+	// TAG[pid]: message
+	if colon := strings.Index(rest, ": "); colon > 0 && !strings.ContainsAny(rest[:colon], " ") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			msg.ProcID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		msg.AppName = tag
+		rest = rest[colon+2:]
+	}
+	msg.Message = rest
+}
+
+func parseSyslog(line string) (SyslogMessage, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	pri, rest, err := parsePriority(line)
+	if err != nil {
+		return SyslogMessage{}, err
+	}
+
+	msg := SyslogMessage{
+		Priority:      pri,
+		Facility:      syslogFacilities[pri/8],
+		Severity:      syslogSeverities[pri%8],
+		SeverityLevel: pri % 8,
+	}
+	if strings.HasPrefix(rest, "1 ") {
+		if err := parseRFC5424(&msg, rest); err != nil {
+			return SyslogMessage{}, err
+		}
+		return msg, nil
+	}
+	parseRFC3164(&msg, rest)
+	return msg, nil
+}
+
+// SyslogStore 定长环形缓冲区
+type SyslogStore struct {
+	messages []SyslogMessage
+	next     int
+	full     bool
+	seq      uint64
+	mutex    sync.RWMutex
+
+	received  int64
+	dropped   int64
+	malformed int64
+	filtered  int64
+}
+
+func NewSyslogStore(size int) *SyslogStore {
+	if size < 1 {
+		size = 1
+	}
+	return &SyslogStore{messages: make([]SyslogMessage, size)}
+}
+
+func (s *SyslogStore) Add(msg SyslogMessage) SyslogMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	msg.ID = s.seq
+	s.messages[s.next] = msg
+	s.next = (s.next + 1) % len(s.messages)
+	if s.next == 0 {
+		s.full = true
+	}
+	return msg
+}
+
+type SyslogFilter struct {
+	// MaxSeverity 只返回严重程度不低于该级别的消息（数值越小越严重）
+	MaxSeverity int
+	Host        string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// Query 按时间倒序返回匹配的消息
+func (s *SyslogStore) Query(filter SyslogFilter) []SyslogMessage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.messages)
+	}
+
+	result := []SyslogMessage{}
+	for i := 0; i < count; i++ {
+		msg := s.messages[(s.next-1-i+len(s.messages))%len(s.messages)]
+		if msg.SeverityLevel > filter.MaxSeverity {
+			continue
+		}
+		if filter.Host != "" && msg.Hostname != filter.Host && msg.Source != filter.Host {
+			continue
+		}
+		if !filter.Since.IsZero() && msg.ReceivedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && msg.ReceivedAt.After(filter.Until) {
+			continue
+		}
+		result = append(result, msg)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result
+}
+
+type rawSyslog struct {
+	source    string
+	transport string
+	line      string
+}
+
+// SyslogListener 接收循环只负责入队，解析由单独的goroutine完成；队列满时丢弃并计数
+type SyslogListener struct {
+	store   *SyslogStore
+	queue   chan rawSyslog
+	allowed []*net.IPNet
+}
+
+func (l *SyslogListener) enqueue(source net.IP, transport, line string) {
+	if len(l.allowed) > 0 {
+		allowed := false
+		for _, network := range l.allowed {
+			if network.Contains(source) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			atomic.AddInt64(&l.store.filtered, 1)
+			return
+		}
+	}
+
+	select {
+	case l.queue <- rawSyslog{source: source.String(), transport: transport, line: line}:
+	default:
+		atomic.AddInt64(&l.store.dropped, 1)
+	}
+}
+
+func (l *SyslogListener) process() {
+	for raw := range l.queue {
+		msg, err := parseSyslog(raw.line)
+		if err != nil {
+			atomic.AddInt64(&l.store.malformed, 1)
+			continue
+		}
+		atomic.AddInt64(&l.store.received, 1)
+		msg.Source = raw.source
+		msg.Transport = raw.transport
+		msg.ReceivedAt = time.Now()
+		sinks.Publish("syslog", l.store.Add(msg))
+	}
+}
+
+func (l *SyslogListener) serveUDP(addr string) {
+	for {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			logWarn("syslog.listen_failed", "syslog listener failed, retrying", "network", "udp", "address", addr, "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		logInfo("syslog.start", "starting syslog listener", "network", "udp", "address", addr)
+
+		buf := make([]byte, syslogMaxMessageSize)
+		for {
+			n, remote, err := conn.ReadFrom(buf)
+			if err != nil {
+				logWarn("syslog.stopped", "syslog listener stopped, restarting", "network", "udp", "address", addr, "error", err)
+				break
+			}
+			udpAddr, ok := remote.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			l.enqueue(udpAddr.IP, "udp", string(buf[:n]))
+		}
+		conn.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+func (l *SyslogListener) serveTCP(addr string) {
+	for {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			logWarn("syslog.listen_failed", "syslog listener failed, retrying", "network", "tcp", "address", addr, "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		logInfo("syslog.start", "starting syslog listener", "network", "tcp", "address", addr)
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				logWarn("syslog.stopped", "syslog listener stopped, restarting", "network", "tcp", "address", addr, "error", err)
+				break
+			}
+			go l.handleTCP(conn)
+		}
+		listener.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+// handleTCP 同时支持RFC6587的八位组计数帧与换行分隔帧
+func (l *SyslogListener) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	source := conn.RemoteAddr().(*net.TCPAddr).IP
+	reader := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Minute))
+		first, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+
+		if first[0] >= '1' && first[0] <= '9' {
+			lengthStr, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(lengthStr))
+			if err != nil || length > syslogMaxMessageSize {
+				atomic.AddInt64(&l.store.malformed, 1)
+				return
+			}
+			frame := make([]byte, length)
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				return
+			}
+			l.enqueue(source, "tcp", string(frame))
+			continue
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// 超长行直接丢弃剩余部分
+			atomic.AddInt64(&l.store.malformed, 1)
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			continue
+		}
+		if len(strings.TrimSpace(string(line))) > 0 {
+			l.enqueue(source, "tcp", string(line))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+var syslogStore = NewSyslogStore(int(envInt64("SYSLOG_BUFFER", 1000)))
+
+// startSyslogListener 按环境变量启动UDP/TCP syslog监听，均未配置时不启用
+func startSyslogListener() {
+	udpAddr := getEnv("SYSLOG_UDP_ADDR", "")
+	tcpAddr := getEnv("SYSLOG_TCP_ADDR", "")
+	if udpAddr == "" && tcpAddr == "" {
+		return
+	}
+
+	listener := &SyslogListener{
+		store:   syslogStore,
+		queue:   make(chan rawSyslog, envInt64("SYSLOG_QUEUE_SIZE", 10000)),
+		allowed: parseCIDRs(splitList(os.Getenv("SYSLOG_ALLOWED_SOURCES"))),
+	}
+	go listener.process()
+	if udpAddr != "" {
+		go listener.serveUDP(udpAddr)
+	}
+	if tcpAddr != "" {
+		go listener.serveTCP(tcpAddr)
+	}
+}
+
+func parseSeverity(value string) (int, error) {
+	for i, name := range syslogSeverities {
+		if value == name {
+			return i, nil
+		}
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > 7 {
+		return 0, fmt.Errorf("invalid severity: %s", value)
+	}
+	return level, nil
+}
+
+func registerSyslogRoutes(r *gin.Engine) {
+	// 查询最近收到的syslog
+	r.GET("/syslog", func(c *gin.Context) {
+		filter := SyslogFilter{
+			MaxSeverity: 7,
+			Host:        c.Query("host"),
+			Limit:       100,
+		}
+		if severity := c.Query("severity"); severity != "" {
+			level, err := parseSeverity(severity)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+			filter.MaxSeverity = level
+		}
+		for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if value := c.Query(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					respondError(c, http.StatusBadRequest, errors.New(name+" must be RFC3339"))
+					return
+				}
+				*target = t
+			}
+		}
+		if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+			filter.Limit = limit
+		}
+
+		messages := syslogStore.Query(filter)
+		c.JSON(http.StatusOK, gin.H{
+			"messages":  messages,
+			"count":     len(messages),
+			"received":  atomic.LoadInt64(&syslogStore.received),
+			"dropped":   atomic.LoadInt64(&syslogStore.dropped),
+			"malformed": atomic.LoadInt64(&syslogStore.malformed),
+			"filtered":  atomic.LoadInt64(&syslogStore.filtered),
+			"timestamp": time.Now(),
+		})
+	})
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// syslog转发：设置 SYSLOG_FORWARD_ADDR 后，审计记录和安全事件（API认证失败、策略拒绝、主机密钥不匹配）
+// 以RFC5424格式（结构化数据 collector@32473）发送到SIEM。SYSLOG_FORWARD_NETWORK 为 udp（默认）、tcp 或 tls，
+// tcp/tls按RFC6587使用八位组计数分帧，tls的CA为 SYSLOG_FORWARD_TLS_CA。
+// 发送在后台进行，队列（SYSLOG_FORWARD_BUFFER）满或端点不可达时丢弃并计数，采集服务不会因此阻塞。
+// facility、各事件类型的severity和转发的事件类型可通过 PUT /admin/syslog-forward 修改；
+// 设置 SYSLOG_FORWARD_CONFIG_FILE 时以文件为准，收到SIGHUP时重新加载。
+// 目标地址由运维配置，不经过目标地址策略
+
+const (
+	syslogEventAudit           = "audit"
+	syslogEventAuthFailure     = "auth_failure"
+	syslogEventPolicyDenied    = "policy_denied"
+	syslogEventHostKeyMismatch = "host_key_mismatch"
+
+	// 32473 为RFC5612中用于示例的企业号
+	syslogForwardSDID = "collector@32473"
+)
+
+var syslogForwardEventTypes = []string{syslogEventAudit, syslogEventAuthFailure, syslogEventPolicyDenied, syslogEventHostKeyMismatch}
+
+var (
+	syslogForwardAddr       = getEnv("SYSLOG_FORWARD_ADDR", "")
+	syslogForwardNetwork    = getEnv("SYSLOG_FORWARD_NETWORK", "udp")
+	syslogForwardTLSCA      = getEnv("SYSLOG_FORWARD_TLS_CA", "")
+	syslogForwardBuffer     = int(envInt64("SYSLOG_FORWARD_BUFFER", 1000))
+	syslogForwardConfigFile = getEnv("SYSLOG_FORWARD_CONFIG_FILE", "")
+	syslogForwardAppName    = getEnv("SYSLOG_FORWARD_APP_NAME", "go-ssh-collector")
+)
+
+var (
+	errSyslogForwardDisabled = errors.New("syslog forwarding is not enabled (set SYSLOG_FORWARD_ADDR)")
+	errInvalidSyslogNetwork  = errors.New("SYSLOG_FORWARD_NETWORK must be udp, tcp or tls")
+)
+
+// SyslogForwardConfig 可在运行时修改的部分；Types为空时转发所有类型
+type SyslogForwardConfig struct {
+	Facility   string            `json:"facility"`
+	Severities map[string]string `json:"severities"`
+	Types      []string          `json:"types"`
+
+	facility   int
+	severities map[string]int
+}
+
+func defaultSyslogSeverities() map[string]string {
+	return map[string]string{
+		syslogEventAudit:           "notice",
+		syslogEventAuthFailure:     "warning",
+		syslogEventPolicyDenied:    "warning",
+		syslogEventHostKeyMismatch: "crit",
+	}
+}
+
+// compile 校验facility、severity和事件类型，未配置的事件类型使用默认severity
+func (cfg *SyslogForwardConfig) compile() error {
+	if cfg.Facility == "" {
+		cfg.Facility = "local0"
+	}
+	cfg.facility = -1
+	for i, name := range syslogFacilities {
+		if name == cfg.Facility {
+			cfg.facility = i
+		}
+	}
+	if cfg.facility < 0 {
+		return fmt.Errorf("invalid facility: %s", cfg.Facility)
+	}
+	severities := defaultSyslogSeverities()
+	for eventType, severity := range cfg.Severities {
+		if !containsString(syslogForwardEventTypes, eventType) {
+			return fmt.Errorf("unknown syslog event type: %s", eventType)
+		}
+		severities[eventType] = severity
+	}
+	cfg.Severities, cfg.severities = severities, map[string]int{}
+	for eventType, severity := range severities {
+		level, err := parseSeverity(severity)
+		if err != nil {
+			return err
+		}
+		cfg.severities[eventType] = level
+	}
+	for _, eventType := range cfg.Types {
+		if !containsString(syslogForwardEventTypes, eventType) {
+			return fmt.Errorf("unknown syslog event type: %s", eventType)
+		}
+	}
+	if cfg.Types == nil {
+		cfg.Types = []string{}
+	}
+	return nil
+}
+
+func (cfg *SyslogForwardConfig) forwards(eventType string) bool {
+	return len(cfg.Types) == 0 || containsString(cfg.Types, eventType)
+}
+
+func envSyslogForwardConfig() (*SyslogForwardConfig, error) {
+	cfg := &SyslogForwardConfig{
+		Facility:   getEnv("SYSLOG_FORWARD_FACILITY", "local0"),
+		Severities: map[string]string{},
+		Types:      splitList(os.Getenv("SYSLOG_FORWARD_TYPES")),
+	}
+	// SYSLOG_FORWARD_SEVERITIES 格式: auth_failure=warning,audit=info
+	for _, pair := range splitList(os.Getenv("SYSLOG_FORWARD_SEVERITIES")) {
+		eventType, severity, _ := strings.Cut(pair, "=")
+		cfg.Severities[strings.TrimSpace(eventType)] = strings.TrimSpace(severity)
+	}
+	return cfg, cfg.compile()
+}
+
+func readSyslogForwardConfigFile() (*SyslogForwardConfig, error) {
+	data, err := os.ReadFile(syslogForwardConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	var cfg SyslogForwardConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, cfg.compile()
+}
+
+// syslogRecord 待发送的一条记录，params为结构化数据
+type syslogRecord struct {
+	eventType string
+	severity  int
+	facility  int
+	timestamp time.Time
+	params    map[string]string
+	message   string
+}
+
+// SyslogForwarder 后台发送，连接失败后按退避重连
+type SyslogForwarder struct {
+	network string
+	addr    string
+	tls     *tls.Config
+	queue   chan syslogRecord
+
+	config *SyslogForwardConfig
+	mutex  sync.RWMutex
+
+	connected int32
+	sent      int64
+	dropped   int64
+	failures  int64
+	lastError atomic.Value
+	hostname  string
+}
+
+// syslogForwarder 未启用时为nil，Forward等方法对nil接收者无操作
+var syslogForwarder = newSyslogForwarderFromEnv()
+
+func newSyslogForwarderFromEnv() *SyslogForwarder {
+	if syslogForwardAddr == "" {
+		return nil
+	}
+	f, err := newSyslogForwarder(syslogForwardNetwork, syslogForwardAddr)
+	if err != nil {
+		logError("syslog_forward.disabled", "syslog forwarding disabled", "error", err)
+		return nil
+	}
+	return f
+}
+
+func newSyslogForwarder(network, addr string) (*SyslogForwarder, error) {
+	if network != "udp" && network != "tcp" && network != "tls" {
+		return nil, errInvalidSyslogNetwork
+	}
+	cfg, err := envSyslogForwardConfig()
+	if syslogForwardConfigFile != "" {
+		cfg, err = readSyslogForwardConfigFile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &SyslogForwarder{network: network, addr: addr, queue: make(chan syslogRecord, syslogForwardBuffer), config: cfg}
+	f.hostname, _ = os.Hostname()
+	if network == "tls" {
+		host, _, _ := net.SplitHostPort(addr)
+		f.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		if syslogForwardTLSCA != "" {
+			pem, err := os.ReadFile(syslogForwardTLSCA)
+			if err != nil {
+				return nil, err
+			}
+			f.tls.RootCAs = x509.NewCertPool()
+			if !f.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", syslogForwardTLSCA)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *SyslogForwarder) Config() SyslogForwardConfig {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return *f.config
+}
+
+func (f *SyslogForwarder) SetConfig(cfg *SyslogForwardConfig) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.config = cfg
+}
+
+// Forward 不阻塞，未在转发类型中的事件直接忽略
+func (f *SyslogForwarder) Forward(eventType, message string, params map[string]string) {
+	if f == nil {
+		return
+	}
+	f.mutex.RLock()
+	cfg := f.config
+	f.mutex.RUnlock()
+	if !cfg.forwards(eventType) {
+		return
+	}
+	record := syslogRecord{
+		eventType: eventType,
+		severity:  cfg.severities[eventType],
+		facility:  cfg.facility,
+		timestamp: time.Now(),
+		params:    params,
+		message:   redact(message),
+	}
+	select {
+	case f.queue <- record:
+	default:
+		atomic.AddInt64(&f.dropped, 1)
+	}
+}
+
+// format 生成RFC5424消息，参数值经过脱敏
+func (f *SyslogForwarder) format(record syslogRecord) string {
+	var sd strings.Builder
+	sd.WriteString("[" + syslogForwardSDID)
+	keys := make([]string, 0, len(record.params))
+	for key, value := range record.params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&sd, " %s=\"%s\"", key, escapeSDValue(redact(record.params[key])))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		record.facility*8+record.severity,
+		record.timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(f.hostname), syslogHeaderField(syslogForwardAppName), os.Getpid(),
+		record.eventType, sd.String(), record.message)
+}
+
+// escapeSDValue RFC5424 6.3.3: 参数值中的 " \ ] 需转义
+func escapeSDValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// syslogHeaderField 头部字段为不含空格的可打印ASCII，为空时为 "-"
+func syslogHeaderField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (f *SyslogForwarder) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch f.network {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", f.addr, f.tls)
+	default:
+		return dialer.Dial(f.network, f.addr)
+	}
+}
+
+// run 逐条发送；连接断开时当前记录计为丢弃，退避期间新记录在队列中等待，队列满后丢弃
+func (f *SyslogForwarder) run() {
+	var conn net.Conn
+	backoff := time.Second
+	for record := range f.queue {
+		if conn == nil {
+			var err error
+			if conn, err = f.dial(); err != nil {
+				f.fail(err)
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > time.Minute {
+					backoff = time.Minute
+				}
+				continue
+			}
+			backoff = time.Second
+			atomic.StoreInt32(&f.connected, 1)
+		}
+		msg := f.format(record)
+		if f.network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			conn.Close()
+			conn = nil
+			f.fail(err)
+			atomic.StoreInt32(&f.connected, 0)
+			continue
+		}
+		atomic.AddInt64(&f.sent, 1)
+	}
+}
+
+func (f *SyslogForwarder) fail(err error) {
+	atomic.AddInt64(&f.dropped, 1)
+	if atomic.AddInt64(&f.failures, 1) == 1 || atomic.LoadInt32(&f.connected) == 1 {
+		logWarn("syslog_forward.failed", "syslog forward failed", "address", f.addr, "error", err)
+	}
+	f.lastError.Store(err.Error())
+}
+
+func (f *SyslogForwarder) Stats() gin.H {
+	stats := gin.H{
+		"network":   f.network,
+		"addr":      f.addr,
+		"connected": atomic.LoadInt32(&f.connected) == 1 || f.network == "udp",
+		"queued":    len(f.queue),
+		"sent":      atomic.LoadInt64(&f.sent),
+		"dropped":   atomic.LoadInt64(&f.dropped),
+		"failures":  atomic.LoadInt64(&f.failures),
+	}
+	if lastError, ok := f.lastError.Load().(string); ok {
+		stats["last_error"] = lastError
+	}
+	return stats
+}
+
+// forwardPolicyEvents 策略拒绝事件取自事件总线
+func (f *SyslogForwarder) forwardPolicyEvents() {
+	sub := events.Subscribe(eventSubscriberBuffer)
+	for event := range sub.ch {
+		if event.Type != eventPolicyDenied {
+			continue
+		}
+		params := map[string]string{
+			"request_id":    event.RequestID,
+			"principal":     event.Principal,
+			"namespace":     event.Namespace,
+			"connection_id": event.ConnectionID,
+			"host":          event.Host,
+		}
+		reason := ""
+		if data, ok := event.Data.(map[string]interface{}); ok {
+			for key, value := range data {
+				params[key] = fmt.Sprint(value)
+			}
+			reason = fmt.Sprint(data["reason"])
+		}
+		f.Forward(syslogEventPolicyDenied, "policy denied: "+reason, params)
+	}
+}
+
+// watch 收到SIGHUP时重新读取配置文件，读取失败时保留原配置
+func (f *SyslogForwarder) watch() {
+	if syslogForwardConfigFile == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := readSyslogForwardConfigFile()
+		if err != nil {
+			logWarn("syslog_forward.config_reload_failed", "syslog forward config reload failed, keeping previous config", "file", syslogForwardConfigFile, "error", err)
+			continue
+		}
+		f.SetConfig(cfg)
+		logInfo("syslog_forward.config_reloaded", "syslog forward config reloaded", "file", syslogForwardConfigFile)
+	}
+}
+
+func startSyslogForwarder() {
+	if syslogForwarder == nil {
+		return
+	}
+	go syslogForwarder.run()
+	go syslogForwarder.forwardPolicyEvents()
+	go syslogForwarder.watch()
+	logInfo("syslog_forward.start", "forwarding audit and security events to syslog", "network", syslogForwarder.network, "address", syslogForwarder.addr)
+}
+
+// forwardAuditEntry 审计记录写入后调用，未启用审计文件时同样转发
+func forwardAuditEntry(entry AuditEntry) {
+	if syslogForwarder == nil {
+		return
+	}
+	params := map[string]string{
+		"request_id":    entry.RequestID,
+		"principal":     entry.Principal,
+		"role":          entry.Role,
+		"source_ip":     entry.SourceIP,
+		"interface":     entry.Interface,
+		"operation":     entry.Operation,
+		"connection_id": entry.ConnectionID,
+		"host":          entry.Host,
+		"status":        strconv.Itoa(entry.Status),
+		"error_code":    entry.ErrorCode,
+		"decision":      entry.Decision,
+		"reason":        entry.Reason,
+	}
+	if entry.Seq > 0 {
+		params["seq"], params["hash"] = strconv.FormatInt(entry.Seq, 10), entry.Hash
+	}
+	if entry.ExitCode != nil {
+		params["exit_code"] = strconv.Itoa(*entry.ExitCode)
+	}
+	message := fmt.Sprintf("%s %s by %s: %s", entry.Interface, entry.Operation, firstNonEmpty(entry.Principal, "anonymous"), entry.Decision)
+	if entry.Command != "" {
+		message += " command=" + entry.Command
+	}
+	syslogForwarder.Forward(syslogEventAudit, message, params)
+}
+
+// forwardAuthFailure API认证失败时调用
+func forwardAuthFailure(iface, sourceIP, method, path, reason string) {
+	syslogForwarder.Forward(syslogEventAuthFailure, fmt.Sprintf("authentication failed for %s %s from %s: %s", method, path, sourceIP, reason),
+		map[string]string{"interface": iface, "source_ip": sourceIP, "method": method, "path": path, "reason": reason})
+}
+
+// forwardHostKeyMismatch 连接时主机密钥与known_hosts不一致
+func forwardHostKeyMismatch(config SSHConfig, err error) {
+	syslogForwarder.Forward(syslogEventHostKeyMismatch, "host key mismatch for "+config.Host+": "+err.Error(),
+		map[string]string{
+			"request_id": config.requestID,
+			"host":       config.Host,
+			"port":       strconv.Itoa(connectPort(config)),
+			"username":   config.Username,
+			"namespace":  config.namespace,
+		})
+}
+
+func registerSyslogForwardRoutes(r *gin.Engine) {
+	admin := r.Group("/admin/syslog-forward", requireAdmin)
+
+	admin.GET("", func(c *gin.Context) {
+		if syslogForwarder == nil {
+			respondError(c, http.StatusNotFound, errSyslogForwardDisabled)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"config": syslogForwarder.Config(), "stats": syslogForwarder.Stats()})
+	})
+
+	admin.PUT("", func(c *gin.Context) {
+		if syslogForwarder == nil {
+			respondError(c, http.StatusNotFound, errSyslogForwardDisabled)
+			return
+		}
+		var cfg SyslogForwardConfig
+		if err := c.ShouldBindJSON(&cfg); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := cfg.compile(); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		syslogForwarder.SetConfig(&cfg)
+		logInfo("audit", "syslog forward config replaced", requestLogFields(c)...)
+		c.JSON(http.StatusOK, gin.H{"config": syslogForwarder.Config(), "stats": syslogForwarder.Stats()})
+	})
+}
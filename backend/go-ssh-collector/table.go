@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 表格解析：识别表头，按表头推导出的列偏移切分各行（df -h、show ip interface brief等）
+
+var (
+	tableSeparatorLine = regexp.MustCompile(`^[\s\-=+|]+$`)
+	tableNameInvalid   = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+type tableColumn struct {
+	name  string
+	start int // 列起始偏移（含）
+	end   int // 列结束偏移（不含），最后一列为-1
+	// joined 表头中与前一个单词只隔一个空格
+	joined bool
+}
+
+func expandTabs(line string) []rune {
+	var out []rune
+	for _, r := range line {
+		if r == '\t' {
+			for {
+				out = append(out, ' ')
+				if len(out)%8 == 0 {
+					break
+				}
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func normalizeColumnName(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, "%", " percent"))
+	return strings.Trim(tableNameInvalid.ReplaceAllString(name, "_"), "_")
+}
+
+type wordSpan struct {
+	text       string
+	start, end int
+}
+
+func splitWords(line []rune) []wordSpan {
+	var words []wordSpan
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, wordSpan{string(line[start:i]), start, i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, wordSpan{string(line[start:]), start, len(line)})
+	}
+	return words
+}
+
+func isSpaceAt(line []rune, i int) bool {
+	return i >= len(line) || unicode.IsSpace(line[i])
+}
+
+// tableBoundary 在两个表头单词之间选择数据行中最少被占用的位置作为分界，
+// occupied为该位置不是空白的行数
+func tableBoundary(from, to int, rows [][]rune) (boundary, occupied int) {
+	best, bestCount := to-1, -1
+	for p := to - 1; p >= from; p-- {
+		count := 0
+		for _, row := range rows {
+			if !isSpaceAt(row, p) {
+				count++
+			}
+		}
+		if bestCount < 0 || count < bestCount {
+			best, bestCount = p, count
+		}
+		if count == 0 {
+			break
+		}
+	}
+	return best, bestCount
+}
+
+// splitRow 按列起点切分一行；列起点落在单词中间时（右对齐的数值溢出、过长的描述等），
+// 整个单词归入其多数字符所在的列
+func splitRow(row []rune, columns []tableColumn) []string {
+	starts := make([]int, len(columns))
+	for i, col := range columns {
+		starts[i] = col.start
+		if i == 0 || starts[i] >= len(row) || isSpaceAt(row, starts[i]-1) || isSpaceAt(row, starts[i]) {
+			continue
+		}
+		wordStart, wordEnd := starts[i], starts[i]
+		for wordStart > 0 && !isSpaceAt(row, wordStart-1) {
+			wordStart--
+		}
+		for !isSpaceAt(row, wordEnd) {
+			wordEnd++
+		}
+		if starts[i]-wordStart >= wordEnd-starts[i] {
+			starts[i] = wordEnd
+		} else {
+			starts[i] = wordStart
+		}
+		if starts[i] < starts[i-1] {
+			starts[i] = starts[i-1]
+		}
+	}
+	cells := make([]string, len(columns))
+	for i := range columns {
+		end := -1
+		if i+1 < len(columns) {
+			end = starts[i+1]
+		}
+		cells[i] = cutCell(row, starts[i], end)
+	}
+	return cells
+}
+
+func cutCell(row []rune, start, end int) string {
+	if start >= len(row) {
+		return ""
+	}
+	if end < 0 || end > len(row) {
+		end = len(row)
+	}
+	return strings.TrimSpace(string(row[start:end]))
+}
+
+// ParseTable 按表头解析表格文本，返回以规范化列名为键的行
+func ParseTable(text string, skipLines int, headerRegex string) ([]map[string]string, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if skipLines > 0 {
+		if skipLines >= len(lines) {
+			return nil, errors.New("skip_lines exceeds output length")
+		}
+		lines = lines[skipLines:]
+	}
+
+	var headerRe *regexp.Regexp
+	if headerRegex != "" {
+		re, err := regexp.Compile(headerRegex)
+		if err != nil {
+			return nil, err
+		}
+		headerRe = re
+	}
+
+	// 定位表头
+	headerIndex := -1
+	for i, line := range lines {
+		if headerRe != nil {
+			if headerRe.MatchString(line) {
+				headerIndex = i
+				break
+			}
+			continue
+		}
+		if len(strings.Fields(line)) >= 2 && !tableSeparatorLine.MatchString(line) {
+			headerIndex = i
+			break
+		}
+	}
+	if headerIndex < 0 {
+		return nil, errors.New("table header not found")
+	}
+
+	header := expandTabs(lines[headerIndex])
+	var data []string
+	for _, line := range lines[headerIndex+1:] {
+		// 数据行之后的空行结束表格，其后的汇总行（Total ... entries）不是数据
+		if strings.TrimSpace(line) == "" && len(data) > 0 {
+			break
+		}
+		if strings.TrimSpace(line) == "" || tableSeparatorLine.MatchString(line) {
+			continue
+		}
+		data = append(data, strings.TrimRight(line, " \t"))
+	}
+
+	// 首列过长时单独成行、其余列折到下一行（df -h），先合并成一行
+	var rows [][]rune
+	wrapped := make(map[int]string)
+	for i := 0; i < len(data); i++ {
+		line := data[i]
+		if i+1 < len(data) && len(strings.Fields(line)) == 1 && !unicode.IsSpace(rune(line[0])) &&
+			unicode.IsSpace(rune(data[i+1][0])) {
+			wrapped[len(rows)] = strings.TrimSpace(line)
+			i++
+			line = data[i]
+		}
+		rows = append(rows, expandTabs(line))
+	}
+
+	words := splitWords(header)
+	var columns []tableColumn
+	for i, word := range words {
+		if i == 0 {
+			columns = append(columns, tableColumn{name: word.text, start: 0, end: -1})
+			continue
+		}
+		boundary, occupied := tableBoundary(words[i-1].end, word.start, rows)
+		// 单空格分隔且多数数据行跨越的两个单词属于同一列名（如 "Device ID"），
+		// 只有少数行跨越时按列溢出处理（ps的RSS TTY）
+		if word.start-words[i-1].end == 1 && 2*occupied > len(rows) {
+			columns[len(columns)-1].name += " " + word.text
+			continue
+		}
+		columns[len(columns)-1].end = boundary
+		columns = append(columns, tableColumn{name: word.text, start: boundary, end: -1, joined: word.start-words[i-1].end == 1})
+	}
+	// 表头缩进而数据行在缩进处有内容时（free的Mem:/Swap:），首列没有列名
+	if len(words) > 0 && words[0].start > 0 {
+		if boundary, occupied := tableBoundary(0, words[0].start, rows); occupied == 0 {
+			for _, row := range rows {
+				if cutCell(row, 0, boundary) != "" {
+					columns[0].start = boundary
+					columns = append([]tableColumn{{start: 0, end: boundary}}, columns...)
+					break
+				}
+			}
+		}
+	}
+
+	cells := make([][]string, len(rows))
+	for r, row := range rows {
+		// 行未与表头对齐但单词数与列数一致时按空白切分
+		aligned := true
+		for _, col := range columns[1:] {
+			if !isSpaceAt(row, col.start) && col.start > 0 && !isSpaceAt(row, col.start-1) {
+				aligned = false
+				break
+			}
+		}
+		if fields := strings.Fields(string(row)); !aligned && len(fields) == len(columns) {
+			cells[r] = fields
+			continue
+		}
+		cells[r] = splitRow(row, columns)
+	}
+	for r, first := range wrapped {
+		cells[r][0] = first
+	}
+
+	// 所有行均为空的列，或与上一列名只隔一个空格且多数行为空的列，视为上一列名的一部分（如 "Mounted on"），
+	// 少数行中的内容是上一列的溢出
+	for i := len(columns) - 1; i > 0 && len(cells) > 0; i-- {
+		filled := 0
+		for _, row := range cells {
+			if row[i] != "" {
+				filled++
+			}
+		}
+		if filled > 0 && !(columns[i].joined && 2*filled < len(cells)) {
+			continue
+		}
+		columns[i-1].name += " " + columns[i].name
+		columns = append(columns[:i], columns[i+1:]...)
+		for r := range cells {
+			if cell := cells[r][i]; cell != "" && cells[r][i-1] != "" {
+				cells[r][i-1] += " " + cell
+			} else if cell != "" {
+				cells[r][i-1] = cell
+			}
+			cells[r] = append(cells[r][:i], cells[r][i+1:]...)
+		}
+	}
+
+	// 首列为空的行是上一行折行的延续
+	var merged [][]string
+	for _, row := range cells {
+		if row[0] == "" && len(merged) > 0 {
+			prev := merged[len(merged)-1]
+			for i, cell := range row {
+				switch {
+				case cell == "":
+				case prev[i] == "":
+					prev[i] = cell
+				default:
+					prev[i] += " " + cell
+				}
+			}
+			continue
+		}
+		merged = append(merged, row)
+	}
+
+	names := make([]string, len(columns))
+	seen := make(map[string]int)
+	for i, col := range columns {
+		name := normalizeColumnName(col.name)
+		if name == "" {
+			name = "column_" + strconv.Itoa(i+1)
+		}
+		seen[name]++
+		if seen[name] > 1 {
+			name += "_" + strconv.Itoa(seen[name])
+		}
+		names[i] = name
+	}
+
+	result := make([]map[string]string, 0, len(merged))
+	for _, row := range merged {
+		record := make(map[string]string, len(names))
+		for i, name := range names {
+			record[name] = row[i]
+		}
+		result = append(result, record)
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/table下的设备输出(.raw)与期望行(.json)，-update 重新生成期望结果
+func TestParseTableFixtures(t *testing.T) {
+	cases := []struct {
+		name   string
+		skip   int
+		header string
+	}{
+		{name: "linux_df_h"},
+		{name: "linux_ps_aux"},
+		{name: "linux_free"},
+		{name: "cisco_ios_show_ip_interface_brief"},
+		{name: "cisco_ios_show_interfaces_status"},
+		{name: "cisco_ios_show_cdp_neighbors", header: `^Device ID`},
+		{name: "cisco_ios_show_mac_address_table", header: `^Vlan\s+Mac Address`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata", "table", tc.name+".raw"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := ParseTable(string(raw), tc.skip, tc.header)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := json.MarshalIndent(rows, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := filepath.Join("testdata", "table", tc.name+".json")
+			if *updateFixtures {
+				if err := os.WriteFile(expectedFile, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("missing expected output: %v", err)
+			}
+			assertSameJSON(t, got, expected)
+		})
+	}
+}
+
+func TestParseTableErrors(t *testing.T) {
+	if _, err := ParseTable("a b\n1 2\n", 5, ""); err == nil {
+		t.Error("skip_lines beyond the output should fail")
+	}
+	if _, err := ParseTable("single\n", 0, ""); err == nil {
+		t.Error("output without a header should fail")
+	}
+	if _, err := ParseTable("a b\n1 2\n", 0, "("); err == nil {
+		t.Error("invalid header regex should fail")
+	}
+	if _, err := ParseTable("a b\n1 2\n", 0, "^nomatch"); err == nil {
+		t.Error("unmatched header regex should fail")
+	}
+}
+
+func TestParseTableDuplicateAndEmptyColumnNames(t *testing.T) {
+	rows, err := ParseTable("Name  Count  Count  %\nx     1      2      3\n", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(rows)
+	assertSameJSON(t, got, []byte(`[{"name":"x","count":"1","count_2":"2","percent":"3"}]`))
+}
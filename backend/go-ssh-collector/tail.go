@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
+)
+
+const (
+	tailPollInterval = time.Second
+	tailMaxLineBytes = 64 * 1024
+	// SFTP轮询每次读取的块大小，初始行最多向前读取tailMaxInitialBytes
+	tailChunkSize       = 64 * 1024
+	tailMaxInitialBytes = 4 * 1024 * 1024
+)
+
+var (
+	tailMaxDuration    = time.Duration(envInt64("TAIL_MAX_DURATION", 3600)) * time.Second
+	tailMaxLinesPerSec = envInt64("TAIL_MAX_LINES_PER_SEC", 200)
+	// lines参数的上限，超出时按上限处理
+	tailMaxLines = int(envInt64("TAIL_MAX_LINES", 1000))
+)
+
+// remoteTail 在远端执行 tail -F，文件轮转后自动跟随新文件
+func remoteTail(ctx context.Context, conn *SSHConnection, remotePath string, lines int, follow bool, out chan<- string) error {
+	session, err := conn.Client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", withErrorClass(classSessionOpenFailed, err))
+	}
+	defer session.Close()
+	defer trackSession(sessionTail)()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("tail -n %d", lines)
+	if follow {
+		command += " -F"
+	}
+	if err := session.Start(command + " -- " + shellQuote(remotePath)); err != nil {
+		return fmt.Errorf("failed to start tail: %v", err)
+	}
+
+	// 客户端断开时关闭会话以结束远端tail进程
+	go func() {
+		<-ctx.Done()
+		session.Close()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 4096), tailMaxLineBytes)
+	for scanner.Scan() {
+		select {
+		case out <- scanner.Text():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return session.Wait()
+}
+
+// sftpTail 远端没有tail命令时通过SFTP轮询文件大小读取新增内容
+func sftpTail(ctx context.Context, client *sftp.Client, remotePath string, lines int, follow bool, out chan<- string) error {
+	file, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file: %v", err)
+	}
+	defer func() { file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 从文件末尾向前读取，取最后lines行
+	offset := info.Size()
+	initial, err := lastLines(file, offset, lines)
+	if err != nil {
+		return err
+	}
+	for _, line := range initial {
+		select {
+		case out <- string(line):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if !follow {
+		return nil
+	}
+
+	var partial []byte
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := client.Stat(remotePath)
+		if err != nil {
+			// 轮转过程中文件可能短暂不存在
+			continue
+		}
+		if info.Size() < offset {
+			// 文件被截断或轮转，重新打开并从头读取
+			file.Close()
+			if file, err = client.Open(remotePath); err != nil {
+				continue
+			}
+			offset, partial = 0, nil
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		// 按固定大小的块读取新增内容，日志暴增时内存占用不随增量增长
+		for offset < info.Size() {
+			n := info.Size() - offset
+			if n > tailChunkSize {
+				n = tailChunkSize
+			}
+			chunk := make([]byte, n)
+			read, err := file.ReadAt(chunk, offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if read == 0 {
+				break
+			}
+			offset += int64(read)
+
+			data := append(partial, chunk[:read]...)
+			parts := bytes.Split(data, []byte("\n"))
+			partial = parts[len(parts)-1]
+			if len(partial) > tailMaxLineBytes {
+				partial = nil
+			}
+			for _, line := range parts[:len(parts)-1] {
+				select {
+				case out <- string(line):
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// lastLines 从size处向前按块读取，直到凑够lines行、读到文件开头或读满tailMaxInitialBytes
+func lastLines(r io.ReaderAt, size int64, lines int) ([][]byte, error) {
+	if lines <= 0 {
+		return nil, nil
+	}
+	// chunks按从后向前的顺序保存
+	var chunks [][]byte
+	newlines := 0
+	pos := size
+	for pos > 0 && size-pos < tailMaxInitialBytes && newlines <= lines {
+		n := int64(tailChunkSize)
+		if n > pos {
+			n = pos
+		}
+		chunk := make([]byte, n)
+		if _, err := r.ReadAt(chunk, pos-n); err != nil && err != io.EOF {
+			return nil, err
+		}
+		pos -= n
+		chunks = append(chunks, chunk)
+		newlines += bytes.Count(chunk, []byte("\n"))
+	}
+	data := make([]byte, 0, size-pos)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		data = append(data, chunks[i]...)
+	}
+
+	trimmed := bytes.TrimRight(data, "\n")
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	result := bytes.Split(trimmed, []byte("\n"))
+	// 起点不在文件开头时第一行可能不完整
+	if pos > 0 {
+		result = result[1:]
+	}
+	if len(result) > lines {
+		result = result[len(result)-lines:]
+	}
+	return result, nil
+}
+
+func registerTailRoutes(r *gin.Engine) {
+	// 实时跟踪远端文件（SSE）
+	r.GET("/connections/:id/files/tail", func(c *gin.Context) {
+		connectionID := c.Param("id")
+		remotePath := c.Query("path")
+		if remotePath == "" {
+			respondError(c, http.StatusBadRequest, errors.New("path is required"))
+			return
+		}
+		lines, err := strconv.Atoi(c.DefaultQuery("lines", "100"))
+		if err != nil || lines < 0 {
+			respondError(c, http.StatusBadRequest, errors.New("invalid lines"))
+			return
+		}
+		if lines > tailMaxLines {
+			lines = tailMaxLines
+		}
+		follow := c.DefaultQuery("follow", "true") == "true"
+
+		// 可选的grok表达式，匹配的行附带解析出的字段
+		var grokPattern *GrokPattern
+		if expr := c.Query("grok"); expr != "" {
+			if grokPattern, err = grok.Compile(expr); err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		maxDuration := tailMaxDuration
+		if seconds, err := strconv.Atoi(c.Query("max_duration")); err == nil && seconds > 0 {
+			if d := time.Duration(seconds) * time.Second; d < maxDuration {
+				maxDuration = d
+			}
+		}
+
+		conn, err := collector.getConnection(connectionID)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+
+		// 优先使用远端tail，不可用时回退到SFTP轮询
+		method := "tail"
+		if session, err := conn.Client.NewSession(); err == nil {
+			release := trackSession(sessionExec)
+			if err := session.Run("command -v tail >/dev/null 2>&1"); err != nil {
+				method = "sftp"
+			}
+			session.Close()
+			release()
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), maxDuration)
+		defer cancel()
+
+		lineCh := make(chan string, 256)
+		errCh := make(chan error, 1)
+		go func() {
+			defer close(lineCh)
+			if method == "tail" {
+				errCh <- remoteTail(ctx, conn, remotePath, lines, follow, lineCh)
+				return
+			}
+			client, err := conn.SFTP()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			errCh <- sftpTail(ctx, client, remotePath, lines, follow, lineCh)
+		}()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		meta := gin.H{
+			"connection_id": connectionID,
+			"host":          conn.Config.Host,
+			"path":          remotePath,
+			"method":        method,
+		}
+		c.SSEvent("start", meta)
+		c.Writer.Flush()
+
+		// 按秒限制输出行数，超出部分丢弃并计数
+		var sent, dropped, windowDropped int64
+		window := time.Now()
+		for {
+			select {
+			case line, ok := <-lineCh:
+				if !ok {
+					end := gin.H{"dropped": dropped}
+					if err := <-errCh; err != nil {
+						end["error"] = err.Error()
+					}
+					c.SSEvent("end", end)
+					c.Writer.Flush()
+					return
+				}
+
+				if time.Since(window) >= time.Second {
+					if windowDropped > 0 {
+						c.SSEvent("dropped", gin.H{"dropped": windowDropped, "total_dropped": dropped})
+					}
+					window, sent, windowDropped = time.Now(), 0, 0
+				}
+				if tailMaxLinesPerSec > 0 && sent >= tailMaxLinesPerSec {
+					dropped++
+					windowDropped++
+					continue
+				}
+				sent++
+
+				line = masker.Mask(conn.Config.DeviceType, line)
+				event := gin.H{
+					"host":      conn.Config.Host,
+					"path":      remotePath,
+					"line":      line,
+					"timestamp": time.Now(),
+				}
+				if grokPattern != nil {
+					fields, matched := grokPattern.Match(line)
+					event["grok_matched"] = matched
+					if matched {
+						event["fields"] = fields
+					}
+				}
+				c.SSEvent("line", event)
+				c.Writer.Flush()
+			case <-ctx.Done():
+				// 等待读取goroutine退出，避免其阻塞在发送上
+				for range lineCh {
+				}
+				if ctx.Err() == context.DeadlineExceeded {
+					c.SSEvent("end", gin.H{"reason": "max_duration", "dropped": dropped})
+					c.Writer.Flush()
+				}
+				return
+			}
+		}
+	})
+}
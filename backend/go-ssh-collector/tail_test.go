@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingReaderAt 记录读取的字节数
+type countingReaderAt struct {
+	r    *strings.Reader
+	read int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %06d\n", i)
+	}
+	return b.String()
+}
+
+func TestLastLinesReadsOnlyTheTail(t *testing.T) {
+	content := numberedLines(1, 500000)
+	r := &countingReaderAt{r: strings.NewReader(content)}
+
+	lines, err := lastLines(r, int64(len(content)), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || string(lines[0]) != "line 499998" || string(lines[2]) != "line 500000" {
+		t.Fatalf("lines = %q", lines)
+	}
+	if r.read > tailChunkSize {
+		t.Fatalf("read %d bytes of a %d byte file for 3 lines", r.read, len(content))
+	}
+}
+
+func TestLastLinesStopsAtInitialByteCap(t *testing.T) {
+	// 没有换行的大文件最多读取tailMaxInitialBytes
+	content := strings.Repeat("x", 3*tailMaxInitialBytes)
+	r := &countingReaderAt{r: strings.NewReader(content)}
+	lines, err := lastLines(r, int64(len(content)), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.read > tailMaxInitialBytes+tailChunkSize {
+		t.Fatalf("read %d bytes", r.read)
+	}
+	if len(lines) != 0 {
+		t.Fatalf("returned a partial first line: %d lines", len(lines))
+	}
+}
+
+func TestLastLinesShortFile(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		lines   int
+		want    string
+	}{
+		{"a\nb\nc\n", 10, "a,b,c"},
+		{"a\nb\nc", 2, "b,c"},
+		{"", 5, ""},
+		{"a\nb\n", 0, ""},
+	} {
+		got, err := lastLines(strings.NewReader(tt.content), int64(len(tt.content)), tt.lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []string
+		for _, line := range got {
+			parts = append(parts, string(line))
+		}
+		if strings.Join(parts, ",") != tt.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", tt.content, tt.lines, parts, tt.want)
+		}
+	}
+}
+
+func TestSFTPTailFollowsGrowthInChunks(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	conn, _ := collector.getConnection(id)
+	client, err := conn.SFTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotePath := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(remotePath, []byte(numberedLines(1, 10)), 0600)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out := make(chan string, 16)
+	done := make(chan error, 1)
+	go func() { done <- sftpTail(ctx, client, remotePath, 2, true, out) }()
+
+	for _, want := range []string{"line 000009", "line 000010"} {
+		if got := <-out; got != want {
+			t.Fatalf("initial line = %q, want %q", got, want)
+		}
+	}
+	// 一次追加多个块的内容
+	f, _ := os.OpenFile(remotePath, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(numberedLines(11, 30000))
+	f.Close()
+	for i := 11; i <= 30000; i++ {
+		select {
+		case got := <-out:
+			if want := fmt.Sprintf("line %06d", i); got != want {
+				t.Fatalf("followed line = %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("stopped after line %d", i-1)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailRouteCapsLines(t *testing.T) {
+	defer func(n int) { tailMaxLines = n }(tailMaxLines)
+	tailMaxLines = 5
+
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(remotePath, []byte(numberedLines(1, 100)), 0600)
+
+	r := gin.New()
+	registerTailRoutes(r)
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/tail?follow=false&lines=1000000000&path="+remotePath, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	var events []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "event:") {
+			events = append(events, strings.TrimPrefix(line, "event:"))
+		}
+	}
+	if got := strings.Count(strings.Join(events, ","), "line"); got != 5 {
+		t.Fatalf("line events = %d, want 5 (%v)", got, events)
+	}
+	if !strings.Contains(body, "line 000096") || strings.Contains(body, "line 000095") {
+		t.Fatalf("unexpected lines: %s", body)
+	}
+}
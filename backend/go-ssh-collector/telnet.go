@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnet协议命令字节 (RFC 854)
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho    = 1
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+const (
+	defaultLoginPrompt    = `(?i)(login|username|user name)\s*:\s*$`
+	defaultPasswordPrompt = `(?i)password\s*:\s*$`
+	defaultPrompt         = `[#>$%\]]\s*$`
+	// 登录失败时设备通常会再次提示登录或输出错误信息
+	telnetLoginFailed = `(?i)(login incorrect|authentication failed|access denied|bad password)`
+)
+
+var errTelnetAuthRejected = errors.New("authentication rejected")
+
+// errTelnetOutputLimit 输出超过上限时停止读取，剩余输出无法与提示符对齐，会话随之关闭
+var errTelnetOutputLimit = errors.New("telnet output exceeds limit, session closed")
+
+type TelnetConnection struct {
+	Config    SSHConfig
+	CreatedAt time.Time
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	prompt  *regexp.Regexp
+	timeout time.Duration
+	// raw 为true时不解析Telnet协议字节（经SSH转发的控制台）
+	raw bool
+	// comPort 为true时接受RFC2217串口控制选项
+	comPort bool
+	// Telnet会话只能串行执行命令
+	mutex sync.Mutex
+}
+
+// readByte 读取一个数据字节，期间处理并应答选项协商
+func (tc *TelnetConnection) readByte() (byte, error) {
+	for {
+		b, err := tc.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != telnetIAC || tc.raw {
+			return b, nil
+		}
+
+		cmd, err := tc.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch cmd {
+		case telnetIAC:
+			// 转义的0xFF数据字节
+			return b, nil
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			opt, err := tc.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			tc.negotiate(cmd, opt)
+		case telnetSB:
+			// 忽略子协商内容直到 IAC SE
+			var prev byte
+			for {
+				c, err := tc.reader.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				if prev == telnetIAC && c == telnetSE {
+					break
+				}
+				prev = c
+			}
+		}
+	}
+}
+
+// negotiate 接受服务端回显与抑制继续，其余选项一律拒绝
+func (tc *TelnetConnection) negotiate(cmd, opt byte) {
+	var reply byte
+	switch cmd {
+	case telnetDO:
+		reply = telnetWONT
+		if opt == telnetOptSGA || (opt == telnetOptComPort && tc.comPort) {
+			reply = telnetWILL
+		}
+	case telnetWILL:
+		reply = telnetDONT
+		if opt == telnetOptEcho || opt == telnetOptSGA {
+			reply = telnetDO
+		}
+	default:
+		return
+	}
+	tc.conn.Write([]byte{telnetIAC, reply, opt})
+}
+
+// readUntil 读取输出直到匹配任一模式，返回读取内容和匹配的模式下标；
+// 超过maxCommandOutput时停止读取并返回errTelnetOutputLimit
+func (tc *TelnetConnection) readUntil(timeout time.Duration, patterns ...*regexp.Regexp) (string, int, error) {
+	tc.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer tc.conn.SetReadDeadline(time.Time{})
+
+	var buf bytes.Buffer
+	for {
+		b, err := tc.readByte()
+		if err != nil {
+			return buf.String(), -1, fmt.Errorf("telnet read failed: %v", err)
+		}
+		if b == 0 {
+			continue
+		}
+		buf.WriteByte(b)
+		if maxCommandOutput > 0 && int64(buf.Len()) > maxCommandOutput {
+			return buf.String(), -1, errTelnetOutputLimit
+		}
+
+		// 只在可能出现提示符的位置检查，避免每个字节都执行正则
+		if b == '\n' || tc.reader.Buffered() > 0 {
+			continue
+		}
+		data := buf.Bytes()
+		if len(data) > 512 {
+			data = data[len(data)-512:]
+		}
+		for i, pattern := range patterns {
+			if pattern.Match(data) {
+				return buf.String(), i, nil
+			}
+		}
+	}
+}
+
+func (tc *TelnetConnection) writeLine(line string) error {
+	tc.conn.SetWriteDeadline(time.Now().Add(tc.timeout))
+	defer tc.conn.SetWriteDeadline(time.Time{})
+
+	if !tc.raw {
+		line = strings.ReplaceAll(line, "\xff", "\xff\xff")
+	}
+	_, err := tc.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func compilePrompt(pattern, def string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = def
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// login 处理用户名/密码提示，直到出现命令提示符
+func (tc *TelnetConnection) login(config SSHConfig, driver *DeviceDriver) error {
+	loginPrompt, err := compilePrompt(firstNonEmpty(config.LoginPrompt, driver.LoginPrompt), defaultLoginPrompt)
+	if err != nil {
+		return err
+	}
+	passwordPrompt, err := compilePrompt(firstNonEmpty(config.PasswordPrompt, driver.PasswordPrompt), defaultPasswordPrompt)
+	if err != nil {
+		return err
+	}
+	failed := regexp.MustCompile(telnetLoginFailed)
+
+	sentUser, sentPassword := false, false
+	for {
+		output, matched, err := tc.readUntil(tc.timeout, loginPrompt, passwordPrompt, tc.prompt, failed)
+		if err != nil {
+			return fmt.Errorf("login failed: %v", err)
+		}
+		switch matched {
+		case 0:
+			if sentUser {
+				return fmt.Errorf("login failed: %w", errTelnetAuthRejected)
+			}
+			sentUser = true
+			if err := tc.writeLine(config.Username); err != nil {
+				return err
+			}
+		case 1:
+			if sentPassword {
+				return fmt.Errorf("login failed: %w", errTelnetAuthRejected)
+			}
+			sentPassword = true
+			if err := tc.writeLine(config.Password); err != nil {
+				return err
+			}
+		case 2:
+			return nil
+		case 3:
+			return fmt.Errorf("login failed: %w: %s", errTelnetAuthRejected, strings.TrimSpace(lastLine(output)))
+		}
+	}
+}
+
+// enable 按驱动的提权流程进入特权模式
+func (tc *TelnetConnection) enable(driver *DeviceDriver, password string) error {
+	enablePrompt, err := compilePrompt(driver.EnablePrompt, defaultPasswordPrompt)
+	if err != nil {
+		return err
+	}
+	if err := tc.writeLine(driver.EnableCommand); err != nil {
+		return err
+	}
+	_, matched, err := tc.readUntil(tc.timeout, enablePrompt, tc.prompt)
+	if err != nil {
+		return fmt.Errorf("enable failed: %v", err)
+	}
+	if matched == 1 {
+		// 无需密码直接进入特权模式
+		return nil
+	}
+	if err := tc.writeLine(password); err != nil {
+		return err
+	}
+	_, matched, err = tc.readUntil(tc.timeout, tc.prompt, enablePrompt)
+	if err != nil {
+		return fmt.Errorf("enable failed: %v", err)
+	}
+	if matched == 1 {
+		return fmt.Errorf("enable failed: password rejected")
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if i := strings.LastIndexAny(s, "\r\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+type telnetCollector struct{}
+
+func init() {
+	registerCollector(telnetCollector{})
+}
+
+func (telnetCollector) Protocol() string { return "telnet" }
+
+func (telnetCollector) Connect(config SSHConfig) (Connection, error) {
+	if config.Port == 0 {
+		config.Port = 23
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+
+	driver, err := drivers.Lookup(config.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := compilePrompt(firstNonEmpty(config.Prompt, driver.Prompt), defaultPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialTimed(config.timing, config.Host, config.Port, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	tc := newTelnetConnection(config, conn, prompt, timeout)
+	if err := tc.start(config, driver); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+func newTelnetConnection(config SSHConfig, conn net.Conn, prompt *regexp.Regexp, timeout time.Duration) *TelnetConnection {
+	return &TelnetConnection{
+		Config:    config,
+		CreatedAt: time.Now(),
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		prompt:    prompt,
+		timeout:   timeout,
+	}
+}
+
+// start 完成登录、提权和关闭分页
+func (tc *TelnetConnection) start(config SSHConfig, driver *DeviceDriver) error {
+	loginStart := time.Now()
+	err := tc.login(config, driver)
+	config.timing.observe(phaseAuth, time.Since(loginStart), err)
+	if err != nil {
+		return err
+	}
+
+	if config.EnablePassword != "" && driver.EnableCommand != "" {
+		if err := tc.enable(driver, config.EnablePassword); err != nil {
+			return err
+		}
+	}
+
+	// 关闭分页，避免输出被 --More-- 截断
+	if paging := firstNonEmpty(config.PagingCommand, driver.PagingCommand); paging != "" {
+		if _, err := tc.run(paging); err != nil {
+			return fmt.Errorf("failed to disable paging: %v", err)
+		}
+	}
+	return nil
+}
+
+// run 发送命令并以提示符为界截取输出，去掉回显的命令行和末尾提示符
+func (tc *TelnetConnection) run(command string) (string, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if err := tc.writeLine(command); err != nil {
+		return "", fmt.Errorf("telnet write failed: %v", err)
+	}
+	output, _, err := tc.readUntil(tc.timeout, tc.prompt)
+	if errors.Is(err, errTelnetOutputLimit) {
+		tc.conn.Close()
+	}
+
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	if i := strings.Index(output, "\n"); i >= 0 && strings.Contains(output[:i], command) {
+		output = output[i+1:]
+	}
+	if err == nil {
+		if i := strings.LastIndex(output, "\n"); i >= 0 {
+			output = output[:i+1]
+		} else {
+			output = ""
+		}
+	}
+	return output, err
+}
+
+func (tc *TelnetConnection) Info() ConnectionInfo {
+	return ConnectionInfo{
+		Protocol:   "telnet",
+		DeviceType: tc.Config.DeviceType,
+		Host:       tc.Config.Host,
+		Port:       tc.Config.Port,
+		Username:   tc.Config.Username,
+		CreatedAt:  tc.CreatedAt,
+	}
+}
+
+// Execute 在交互式会话中执行命令，Telnet不区分shell
+func (tc *TelnetConnection) Execute(shell, command string) (*CommandResult, error) {
+	output, err := tc.run(command)
+
+	result := &CommandResult{
+		Command:   command,
+		Output:    output,
+		Timestamp: time.Now(),
+	}
+	if maxCommandOutput > 0 && int64(len(output)) > maxCommandOutput {
+		result.Output = output[:maxCommandOutput]
+	}
+	if errors.Is(err, errTelnetOutputLimit) {
+		result.Truncated = true
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+// HealthCheck 发送空行并等待提示符
+func (tc *TelnetConnection) HealthCheck() error {
+	_, err := tc.run("")
+	return err
+}
+
+func (tc *TelnetConnection) Close() error {
+	return tc.conn.Close()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设备持续输出且不出现提示符时，读取在上限处停止并关闭会话
+func TestTelnetOutputStopsAtLimit(t *testing.T) {
+	old := maxCommandOutput
+	maxCommandOutput = 1024
+	t.Cleanup(func() { maxCommandOutput = old })
+
+	client, server := net.Pipe()
+	written := make(chan int, 1)
+	go func() {
+		defer server.Close()
+		if _, err := bufio.NewReader(server).ReadString('\n'); err != nil {
+			written <- 0
+			return
+		}
+		line := []byte(strings.Repeat("x", 63) + "\n")
+		total := 0
+		for {
+			n, err := server.Write(line)
+			total += n
+			if err != nil {
+				written <- total
+				return
+			}
+		}
+	}()
+
+	tc := &TelnetConnection{
+		Config:  SSHConfig{Host: "telnet-test.invalid"},
+		conn:    client,
+		reader:  bufio.NewReader(client),
+		prompt:  regexp.MustCompile(defaultPrompt),
+		timeout: 5 * time.Second,
+	}
+	result, err := tc.Execute("", "show tech-support")
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if !result.Truncated {
+		t.Fatal("result not flagged as truncated")
+	}
+	if int64(len(result.Output)) > maxCommandOutput {
+		t.Fatalf("output length = %d, want at most %d", len(result.Output), maxCommandOutput)
+	}
+	if result.Error == "" {
+		t.Fatal("expected the closed session to be reported")
+	}
+
+	select {
+	case total := <-written:
+		// 关闭后设备写入失败，读取量不会远超上限
+		if total > 4096 {
+			t.Fatalf("device wrote %d bytes before the session closed", total)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("session still reading after the output limit")
+	}
+	if err := tc.HealthCheck(); err == nil {
+		t.Fatal("health check succeeded on a closed session")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.textfsm
+var builtinTemplateFiles embed.FS
+
+var (
+	errTemplateNotFound    = errors.New("template not found")
+	errInvalidTemplateName = errors.New("invalid template name")
+	errBuiltinTemplate     = errors.New("builtin template cannot be removed")
+)
+
+// 自定义模板的持久化目录，为空时仅保存在内存
+var templateDir = getEnv("TEMPLATE_DIR", "")
+
+var templateNamePattern = regexp.MustCompile(`^[\w.\-]+$`)
+
+// ParseTemplate 一个已编译的TextFSM模板
+type ParseTemplate struct {
+	Name      string
+	Source    string
+	Builtin   bool
+	UpdatedAt time.Time
+	fsm       *TextFSM
+}
+
+func (t *ParseTemplate) summary() gin.H {
+	values := make([]string, 0, len(t.fsm.Values))
+	for _, v := range t.fsm.Values {
+		values = append(values, v.Name)
+	}
+	return gin.H{
+		"name":       t.Name,
+		"builtin":    t.Builtin,
+		"values":     values,
+		"updated_at": t.UpdatedAt,
+	}
+}
+
+// TemplateStore 内置模板只读，自定义模板可覆盖同名内置模板，删除后恢复内置版本
+type TemplateStore struct {
+	builtin map[string]*ParseTemplate
+	custom  map[string]*ParseTemplate
+	mutex   sync.RWMutex
+}
+
+var templates = newTemplateStore()
+
+func newTemplateStore() *TemplateStore {
+	store := &TemplateStore{
+		builtin: make(map[string]*ParseTemplate),
+		custom:  make(map[string]*ParseTemplate),
+	}
+
+	entries, _ := builtinTemplateFiles.ReadDir("templates")
+	for _, entry := range entries {
+		data, err := builtinTemplateFiles.ReadFile(path.Join("templates", entry.Name()))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".textfsm")
+		fsm, err := ParseTextFSM(string(data))
+		if err != nil {
+			logWarn("template.invalid", "invalid builtin template", "template", name, "error", err)
+			continue
+		}
+		store.builtin[name] = &ParseTemplate{Name: name, Source: string(data), Builtin: true, fsm: fsm}
+	}
+
+	if templateDir != "" {
+		store.load(templateDir)
+	}
+	return store
+}
+
+// load 从持久化目录加载自定义模板
+func (s *TemplateStore) load(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.textfsm"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".textfsm")
+		fsm, err := ParseTextFSM(string(data))
+		if err != nil {
+			logWarn("template.invalid", "invalid template", "file", file, "error", err)
+			continue
+		}
+		info, _ := os.Stat(file)
+		template := &ParseTemplate{Name: name, Source: string(data), fsm: fsm}
+		if info != nil {
+			template.UpdatedAt = info.ModTime()
+		}
+		s.custom[name] = template
+	}
+}
+
+func (s *TemplateStore) Get(name string) (*ParseTemplate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if t, ok := s.custom[name]; ok {
+		return t, nil
+	}
+	if t, ok := s.builtin[name]; ok {
+		return t, nil
+	}
+	return nil, errTemplateNotFound
+}
+
+// Put 编译并保存模板，编译失败时返回语法错误
+func (s *TemplateStore) Put(name, source string) (*ParseTemplate, error) {
+	if !templateNamePattern.MatchString(name) {
+		return nil, errInvalidTemplateName
+	}
+	fsm, err := ParseTextFSM(source)
+	if err != nil {
+		return nil, err
+	}
+	template := &ParseTemplate{Name: name, Source: source, UpdatedAt: time.Now(), fsm: fsm}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if templateDir != "" {
+		if err := os.MkdirAll(templateDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(templateDir, name+".textfsm"), []byte(source), 0644); err != nil {
+			return nil, err
+		}
+	}
+	s.custom[name] = template
+	return template, nil
+}
+
+func (s *TemplateStore) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.custom[name]; !ok {
+		if _, builtin := s.builtin[name]; builtin {
+			return errBuiltinTemplate
+		}
+		return errTemplateNotFound
+	}
+	if templateDir != "" {
+		if err := os.Remove(filepath.Join(templateDir, name+".textfsm")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.custom, name)
+	return nil
+}
+
+func (s *TemplateStore) List() []gin.H {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]gin.H, 0, len(s.builtin)+len(s.custom))
+	for name, t := range s.builtin {
+		if _, overridden := s.custom[name]; overridden {
+			continue
+		}
+		list = append(list, t.summary())
+	}
+	for _, t := range s.custom {
+		list = append(list, t.summary())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["name"].(string) < list[j]["name"].(string) })
+	return list
+}
+
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBuiltinTemplate):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func registerTemplateRoutes(r *gin.Engine) {
+	r.GET("/templates", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"templates": templates.List()})
+	})
+
+	r.GET("/templates/:name", func(c *gin.Context) {
+		t, err := templates.Get(c.Param("name"))
+		if err != nil {
+			respondError(c, templateErrorStatus(err), err)
+			return
+		}
+		summary := t.summary()
+		summary["template"] = t.Source
+		c.JSON(http.StatusOK, summary)
+	})
+
+	// 请求体为模板原文，或JSON: {"template": "..."}
+	r.PUT("/templates/:name", func(c *gin.Context) {
+		var source string
+		if strings.HasPrefix(c.ContentType(), "application/json") {
+			var body struct {
+				Template string `json:"template" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+			source = body.Template
+		} else {
+			data, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, err)
+				return
+			}
+			source = string(data)
+		}
+
+		if _, err := templates.Put(c.Param("name"), source); err != nil {
+			respondError(c, templateErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"template": c.Param("name"), "status": "saved"})
+	})
+
+	r.DELETE("/templates/:name", func(c *gin.Context) {
+		if err := templates.Remove(c.Param("name")); err != nil {
+			respondError(c, templateErrorStatus(err), err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "removed"})
+	})
+
+	// 用样例输出调试模板
+	r.POST("/templates/:name/parse", func(c *gin.Context) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		t, err := templates.Get(c.Param("name"))
+		if err != nil {
+			respondError(c, templateErrorStatus(err), err)
+			return
+		}
+		records, err := t.fsm.Parse(body.Text)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"parsed": records})
+	})
+}
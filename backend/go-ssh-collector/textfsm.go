@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TextFSM模板解析，语法与Google TextFSM / ntc-templates兼容：
+//
+//	Value [Filldown,Key,Required,List,Fillup] Name (regex)
+//
+//	Start
+//	  ^rule ${Name} -> Next.Record State
+type FSMValue struct {
+	Name     string
+	Regex    string
+	Filldown bool
+	Key      bool
+	Required bool
+	List     bool
+	Fillup   bool
+}
+
+type fsmRule struct {
+	regex     *regexp.Regexp
+	lineOp    string // Next / Continue
+	recordOp  string // NoRecord / Record / Clear / Clearall
+	newState  string
+	errorText string
+	isError   bool
+	line      int
+}
+
+type TextFSM struct {
+	Values []*FSMValue
+	states map[string][]fsmRule
+}
+
+var (
+	fsmValueLine  = regexp.MustCompile(`^Value\s+(?:([\w,]+)\s+)?(\w+)\s+(\(.*\))\s*$`)
+	fsmStateName  = regexp.MustCompile(`^\w+$`)
+	fsmActionLine = regexp.MustCompile(`^(.*?)\s+->\s*(.*)$`)
+	fsmVarRef     = regexp.MustCompile(`\$\$|\$\{(\w+)\}|\$(\w+)`)
+)
+
+// ParseTextFSM 编译模板
+func ParseTextFSM(template string) (*TextFSM, error) {
+	fsm := &TextFSM{states: make(map[string][]fsmRule)}
+	values := make(map[string]*FSMValue)
+
+	scanner := bufio.NewScanner(strings.NewReader(template))
+	lineNo := 0
+	inValues := true
+	current := ""
+	for scanner.Scan() {
+		lineNo++
+		raw := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(raw)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if inValues {
+			if trimmed == "" {
+				if len(fsm.Values) > 0 {
+					inValues = false
+				}
+				continue
+			}
+			if !strings.HasPrefix(trimmed, "Value ") {
+				return nil, fmt.Errorf("line %d: expected Value definition", lineNo)
+			}
+			value, err := parseFSMValue(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if _, exists := values[value.Name]; exists {
+				return nil, fmt.Errorf("line %d: duplicate value %s", lineNo, value.Name)
+			}
+			values[value.Name] = value
+			fsm.Values = append(fsm.Values, value)
+			continue
+		}
+
+		if trimmed == "" {
+			current = ""
+			continue
+		}
+		// 状态名顶格书写，规则以空白缩进并以^开头
+		if raw[0] != ' ' && raw[0] != '\t' {
+			if !fsmStateName.MatchString(trimmed) {
+				return nil, fmt.Errorf("line %d: invalid state name %q", lineNo, trimmed)
+			}
+			if _, exists := fsm.states[trimmed]; exists {
+				return nil, fmt.Errorf("line %d: duplicate state %s", lineNo, trimmed)
+			}
+			current = trimmed
+			fsm.states[current] = nil
+			continue
+		}
+		if current == "" {
+			return nil, fmt.Errorf("line %d: rule outside of state", lineNo)
+		}
+		rule, err := parseFSMRule(trimmed, values)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		rule.line = lineNo
+		fsm.states[current] = append(fsm.states[current], rule)
+	}
+
+	if len(fsm.Values) == 0 {
+		return nil, errors.New("template has no Value definitions")
+	}
+	if _, ok := fsm.states["Start"]; !ok {
+		return nil, errors.New("template has no Start state")
+	}
+	for state, rules := range fsm.states {
+		for _, rule := range rules {
+			if rule.newState == "" || rule.newState == "End" || rule.newState == "EOF" {
+				continue
+			}
+			if _, ok := fsm.states[rule.newState]; !ok {
+				return nil, fmt.Errorf("state %s line %d: undefined state %s", state, rule.line, rule.newState)
+			}
+		}
+	}
+	return fsm, nil
+}
+
+func parseFSMValue(line string) (*FSMValue, error) {
+	m := fsmValueLine.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("invalid Value definition: %s", line)
+	}
+	value := &FSMValue{Name: m[2], Regex: m[3]}
+	if m[1] != "" {
+		for _, option := range strings.Split(m[1], ",") {
+			switch option {
+			case "Filldown":
+				value.Filldown = true
+			case "Key":
+				value.Key = true
+			case "Required":
+				value.Required = true
+			case "List":
+				value.List = true
+			case "Fillup":
+				value.Fillup = true
+			default:
+				return nil, fmt.Errorf("unknown value option %s", option)
+			}
+		}
+	}
+	if _, err := regexp.Compile(value.Regex); err != nil {
+		return nil, fmt.Errorf("value %s: %v", value.Name, err)
+	}
+	return value, nil
+}
+
+func parseFSMRule(line string, values map[string]*FSMValue) (fsmRule, error) {
+	rule := fsmRule{lineOp: "Next", recordOp: "NoRecord"}
+	pattern := line
+	if m := fsmActionLine.FindStringSubmatch(line); m != nil {
+		pattern = m[1]
+		if err := parseFSMAction(strings.TrimSpace(m[2]), &rule); err != nil {
+			return rule, err
+		}
+	}
+	if !strings.HasPrefix(pattern, "^") {
+		return rule, fmt.Errorf("rule must start with ^: %s", pattern)
+	}
+
+	var missing string
+	expanded := fsmVarRef.ReplaceAllStringFunc(pattern, func(ref string) string {
+		// $$ 表示字面的 $
+		if ref == "$$" {
+			return "$"
+		}
+		name := strings.Trim(ref, "${}")
+		value, ok := values[name]
+		if !ok {
+			missing = name
+			return ref
+		}
+		// 值的正则包在命名分组中，外层括号由值定义提供
+		return "(?P<" + name + ">" + value.Regex[1:len(value.Regex)-1] + ")"
+	})
+	if missing != "" {
+		return rule, fmt.Errorf("undefined value %s", missing)
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return rule, fmt.Errorf("invalid rule regex: %v", err)
+	}
+	rule.regex = re
+	return rule, nil
+}
+
+// parseFSMAction 解析 "LineOp.RecordOp NewState" 或 "Error ["message"]"
+func parseFSMAction(action string, rule *fsmRule) error {
+	if strings.HasPrefix(action, "Error") {
+		rule.isError = true
+		rule.errorText = strings.Trim(strings.TrimSpace(strings.TrimPrefix(action, "Error")), `"`)
+		return nil
+	}
+	fields := strings.Fields(action)
+	if len(fields) == 0 {
+		return errors.New("empty action")
+	}
+
+	ops := fields[0]
+	rest := fields[1:]
+	lineOps := map[string]bool{"Next": true, "Continue": true}
+	recordOps := map[string]bool{"NoRecord": true, "Record": true, "Clear": true, "Clearall": true}
+
+	parts := strings.SplitN(ops, ".", 2)
+	switch {
+	case len(parts) == 2 && lineOps[parts[0]] && recordOps[parts[1]]:
+		rule.lineOp, rule.recordOp = parts[0], parts[1]
+	case len(parts) == 1 && lineOps[parts[0]]:
+		rule.lineOp = parts[0]
+	case len(parts) == 1 && recordOps[parts[0]]:
+		rule.recordOp = parts[0]
+	case len(parts) == 1 && len(rest) == 0:
+		// 仅指定新状态
+		rule.newState = parts[0]
+		return nil
+	default:
+		return fmt.Errorf("invalid action %q", action)
+	}
+
+	if len(rest) > 1 {
+		return fmt.Errorf("invalid action %q", action)
+	}
+	if len(rest) == 1 {
+		if rule.lineOp == "Continue" {
+			return errors.New("Continue cannot change state")
+		}
+		rule.newState = rest[0]
+	}
+	return nil
+}
+
+// fsmRun 保存一次解析的运行时状态
+type fsmRun struct {
+	fsm     *TextFSM
+	current []interface{}
+	records [][]interface{}
+}
+
+func (r *fsmRun) index(name string) int {
+	for i, v := range r.fsm.Values {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *fsmRun) assign(i int, value string) {
+	v := r.fsm.Values[i]
+	if v.List {
+		list, _ := r.current[i].([]string)
+		r.current[i] = append(list, value)
+		return
+	}
+	r.current[i] = value
+	if v.Fillup {
+		// 向上填充之前记录中为空的同名字段
+		for j := len(r.records) - 1; j >= 0; j-- {
+			if s, _ := r.records[j][i].(string); s != "" {
+				break
+			}
+			r.records[j][i] = value
+		}
+	}
+}
+
+func isEmptyFSMValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []string:
+		return len(value) == 0
+	}
+	return false
+}
+
+func (r *fsmRun) record() {
+	for i, v := range r.fsm.Values {
+		if v.Required && isEmptyFSMValue(r.current[i]) {
+			r.clear(false)
+			return
+		}
+	}
+	// 全部为空的行不输出
+	allEmpty := true
+	for _, value := range r.current {
+		if !isEmptyFSMValue(value) {
+			allEmpty = false
+			break
+		}
+	}
+	if !allEmpty {
+		row := make([]interface{}, len(r.current))
+		for i, value := range r.current {
+			if list, ok := value.([]string); ok {
+				row[i] = append([]string(nil), list...)
+			} else {
+				row[i] = value
+			}
+		}
+		r.records = append(r.records, row)
+	}
+	r.clear(false)
+}
+
+func (r *fsmRun) clear(all bool) {
+	for i, v := range r.fsm.Values {
+		if all || !v.Filldown {
+			r.current[i] = nil
+		}
+	}
+}
+
+// Parse 按模板解析文本，返回记录列表
+func (fsm *TextFSM) Parse(text string) ([]map[string]interface{}, error) {
+	return fsm.parse(text, nil)
+}
+
+// 调试跟踪最多记录的步数
+const fsmTraceLimit = 2000
+
+// FSMStep 状态机的一步：输入行在某状态下命中的规则，未命中任何规则时Rule为0
+type FSMStep struct {
+	Line     int    `json:"line"`
+	Input    string `json:"input"`
+	State    string `json:"state"`
+	Rule     int    `json:"rule,omitempty"`
+	Action   string `json:"action,omitempty"`
+	NewState string `json:"new_state,omitempty"`
+}
+
+// Trace 解析并返回状态机的执行过程
+func (fsm *TextFSM) Trace(text string) ([]map[string]interface{}, []FSMStep, error) {
+	steps := []FSMStep{}
+	records, err := fsm.parse(text, &steps)
+	return records, steps, err
+}
+
+func (fsm *TextFSM) parse(text string, trace *[]FSMStep) ([]map[string]interface{}, error) {
+	run := &fsmRun{fsm: fsm, current: make([]interface{}, len(fsm.Values))}
+	state := "Start"
+	step := func(s FSMStep) {
+		if trace != nil && len(*trace) < fsmTraceLimit {
+			*trace = append(*trace, s)
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for n, line := range lines {
+		if state == "End" || state == "EOF" {
+			break
+		}
+		matched := false
+	rules:
+		for _, rule := range fsm.states[state] {
+			m := rule.regex.FindStringSubmatchIndex(line)
+			if m == nil {
+				continue
+			}
+			matched = true
+			action := rule.lineOp + "." + rule.recordOp
+			if rule.isError {
+				action = "Error"
+			}
+			step(FSMStep{Line: n + 1, Input: line, State: state, Rule: rule.line, Action: action, NewState: rule.newState})
+			for g, name := range rule.regex.SubexpNames() {
+				if name == "" || m[2*g] < 0 {
+					continue
+				}
+				if i := run.index(name); i >= 0 {
+					run.assign(i, line[m[2*g]:m[2*g+1]])
+				}
+			}
+
+			if rule.isError {
+				msg := rule.errorText
+				if msg == "" {
+					msg = "state error"
+				}
+				return nil, fmt.Errorf("template error at rule line %d: %s (input: %q)", rule.line, msg, line)
+			}
+			switch rule.recordOp {
+			case "Record":
+				run.record()
+			case "Clear":
+				run.clear(false)
+			case "Clearall":
+				run.clear(true)
+			}
+			if rule.newState != "" {
+				state = rule.newState
+			}
+			if rule.lineOp == "Next" {
+				break rules
+			}
+		}
+		if !matched {
+			step(FSMStep{Line: n + 1, Input: line, State: state})
+		}
+	}
+
+	// 未显式定义EOF状态时，结束时隐式记录
+	if _, ok := fsm.states["EOF"]; !ok && state != "End" {
+		run.record()
+	}
+
+	records := make([]map[string]interface{}, 0, len(run.records))
+	for _, row := range run.records {
+		record := make(map[string]interface{}, len(row))
+		for i, v := range fsm.Values {
+			switch value := row[i].(type) {
+			case nil:
+				if v.List {
+					record[v.Name] = []string{}
+				} else {
+					record[v.Name] = ""
+				}
+			default:
+				record[v.Name] = value
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// go test -run TestBuiltinTemplateFixtures -update 重新生成期望结果，提交前需人工核对
+var updateFixtures = flag.Bool("update", false, "rewrite expected fixture output")
+
+// 每个内置模板在testdata/textfsm下有一份设备输出(.raw)和期望记录(.json)
+func TestBuiltinTemplateFixtures(t *testing.T) {
+	entries, err := builtinTemplateFiles.ReadDir("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".textfsm")
+		t.Run(name, func(t *testing.T) {
+			template, err := templates.Get(name)
+			if err != nil {
+				t.Fatalf("builtin template failed to load: %v", err)
+			}
+			raw, err := os.ReadFile(filepath.Join("testdata", "textfsm", name+".raw"))
+			if err != nil {
+				t.Fatalf("missing fixture: %v", err)
+			}
+			records, err := template.fsm.Parse(string(raw))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := json.MarshalIndent(records, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := filepath.Join("testdata", "textfsm", name+".json")
+			if *updateFixtures {
+				if err := os.WriteFile(expectedFile, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("missing expected output: %v", err)
+			}
+			assertSameJSON(t, got, expected)
+		})
+	}
+}
+
+func assertSameJSON(t *testing.T, got, expected []byte) {
+	t.Helper()
+	var g, e interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(expected, &e); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(g, e) {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func mustParseTextFSM(t *testing.T, template string) *TextFSM {
+	t.Helper()
+	fsm, err := ParseTextFSM(template)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return fsm
+}
+
+func TestTextFSMValueOptions(t *testing.T) {
+	fsm := mustParseTextFSM(t, `Value Filldown CHASSIS (\S+)
+Value Required SLOT (\d+)
+Value List PORTS (\S+)
+Value Fillup STATUS (\w+)
+
+Start
+  ^Chassis ${CHASSIS}
+  ^Slot ${SLOT}
+  ^\s+port ${PORTS}
+  ^End -> Record
+  ^Status ${STATUS}
+`)
+	records, err := fsm.Parse(strings.Join([]string{
+		"Chassis c1",
+		"Slot 1",
+		"  port a",
+		"  port b",
+		"End",
+		"Slot 2",
+		"  port c",
+		"End",
+		"Status ok",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(records)
+	// Filldown保留CHASSIS，Fillup向上回填STATUS，最后一条缺少Required的SLOT被丢弃
+	assertSameJSON(t, got, []byte(`[
+		{"CHASSIS":"c1","SLOT":"1","PORTS":["a","b"],"STATUS":"ok"},
+		{"CHASSIS":"c1","SLOT":"2","PORTS":["c"],"STATUS":"ok"}
+	]`))
+}
+
+func TestTextFSMStatesAndErrorAction(t *testing.T) {
+	fsm := mustParseTextFSM(t, `Value NAME (\S+)
+
+Start
+  ^BEGIN -> Body
+  ^BROKEN -> Error "unexpected marker"
+
+Body
+  ^item ${NAME} -> Record
+  ^END -> End
+`)
+	records, err := fsm.Parse("item skipped\nBEGIN\nitem a\nitem b\nEND\nitem c\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(records)
+	assertSameJSON(t, got, []byte(`[{"NAME":"a"},{"NAME":"b"}]`))
+
+	if _, err := fsm.Parse("BROKEN\n"); err == nil || !strings.Contains(err.Error(), "unexpected marker") {
+		t.Fatalf("err = %v, want the Error action message", err)
+	}
+}
+
+func TestTextFSMTraceRecordsSteps(t *testing.T) {
+	fsm := mustParseTextFSM(t, "Value NAME (\\S+)\n\nStart\n  ^name ${NAME} -> Record\n")
+	_, steps, err := fsm.Trace("name a\nnoise\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) < 2 || steps[0].Rule != 4 || steps[0].Action != "Next.Record" || steps[1].Rule != 0 {
+		t.Fatalf("steps = %+v", steps)
+	}
+}
+
+func TestParseTextFSMRejectsInvalidTemplates(t *testing.T) {
+	cases := map[string]string{
+		"no values":         "Start\n  ^x\n",
+		"no start":          "Value A (\\S+)\n\nOther\n  ^x\n",
+		"duplicate value":   "Value A (\\S+)\nValue A (\\S+)\n\nStart\n  ^x\n",
+		"unknown option":    "Value Sometimes A (\\S+)\n\nStart\n  ^x\n",
+		"undefined value":   "Value A (\\S+)\n\nStart\n  ^${B}\n",
+		"undefined state":   "Value A (\\S+)\n\nStart\n  ^x -> Missing\n",
+		"rule without ^":    "Value A (\\S+)\n\nStart\n  x\n",
+		"continue to state": "Value A (\\S+)\n\nStart\n  ^x -> Continue Start\n",
+		"invalid regex":     "Value A ([)\n\nStart\n  ^x\n",
+	}
+	for name, template := range cases {
+		if _, err := ParseTextFSM(template); err == nil {
+			t.Errorf("%s: expected a compile error", name)
+		}
+	}
+}
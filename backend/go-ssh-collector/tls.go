@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HTTPS服务：配置证书后直接提供TLS，证书文件变化（轮询或SIGHUP）时热加载。
+// 未配置证书时只有显式设置 INSECURE_HTTP=true 才允许明文HTTP
+
+var (
+	tlsCertFile = getEnv("TLS_CERT_FILE", "")
+	tlsKeyFile  = getEnv("TLS_KEY_FILE", "")
+	// 1.2 或 1.3
+	tlsMinVersion = getEnv("TLS_MIN_VERSION", "1.2")
+	// 逗号分隔的套件名（如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256），仅对TLS 1.2生效
+	tlsCipherSuites = getEnv("TLS_CIPHER_SUITES", "")
+	// 检查证书文件变化的间隔（秒），0表示只在SIGHUP时重新加载
+	tlsReloadInterval = time.Duration(envInt64("TLS_RELOAD_INTERVAL", 30)) * time.Second
+	// 非空时在该地址监听HTTP并重定向到HTTPS，如 ":8080"
+	tlsRedirectAddr = getEnv("TLS_REDIRECT_ADDR", "")
+	insecureHTTP    = getEnv("INSECURE_HTTP", "false") == "true"
+)
+
+var errTLSNotConfigured = errors.New("TLS_CERT_FILE/TLS_KEY_FILE not set; set INSECURE_HTTP=true to serve plain HTTP")
+
+// certReloader 保存当前证书，供 tls.Config.GetCertificate 使用
+type certReloader struct {
+	certFile string
+	keyFile  string
+	// interval 轮询文件变化的间隔，0表示只响应SIGHUP
+	interval time.Duration
+	cert     *tls.Certificate
+	modTime  time.Time
+	mutex    sync.RWMutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, stop: make(chan struct{})}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// load 证书与私钥不匹配时返回错误，保留旧证书
+func (cr *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate %s / key %s: %w", cr.certFile, cr.keyFile, err)
+	}
+	modTime := cr.latestModTime()
+
+	cr.mutex.Lock()
+	cr.cert, cr.modTime = &cert, modTime
+	cr.mutex.Unlock()
+	return nil
+}
+
+func (cr *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		if st, err := os.Stat(name); err == nil && st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.cert, nil
+}
+
+// changed 证书或私钥文件比当前证书加载时新
+func (cr *certReloader) changed() bool {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.latestModTime().After(cr.modTime)
+}
+
+// watch 收到SIGHUP或文件修改时间变化时重新加载，Stop后返回
+func (cr *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if cr.interval > 0 {
+		ticker := time.NewTicker(cr.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-cr.stop:
+			return
+		case <-hup:
+		case <-tick:
+			if !cr.changed() {
+				continue
+			}
+		}
+		if err := cr.load(); err != nil {
+			logWarn("tls.reload_failed", "tls certificate reload failed, keeping previous certificate", "file", cr.certFile, "error", err)
+			continue
+		}
+		logInfo("tls.reloaded", "tls certificate reloaded", "file", cr.certFile)
+	}
+}
+
+// Stop 结束watch并取消SIGHUP注册，可重复调用，未启用TLS时reloader为nil
+func (cr *certReloader) Stop() {
+	if cr == nil {
+		return
+	}
+	cr.stopOnce.Do(func() { close(cr.stop) })
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS_MIN_VERSION %q (use 1.2 or 1.3)", version)
+}
+
+func parseCipherSuites(spec string) ([]uint16, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverTLSConfig 未配置证书且允许明文时返回nil；返回的reloader已在后台热加载，退出时调用其Stop
+func serverTLSConfig() (*tls.Config, *certReloader, error) {
+	if tlsCertFile == "" && tlsKeyFile == "" {
+		if clientCertAuthEnabled() {
+			return nil, nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		if insecureHTTP {
+			return nil, nil, nil
+		}
+		return nil, nil, errTLSNotConfigured
+	}
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return nil, nil, errors.New("both TLS_CERT_FILE and TLS_KEY_FILE are required")
+	}
+	minVersion, err := parseTLSVersion(tlsMinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	suites, err := parseCipherSuites(tlsCipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := newCertReloader(tlsCertFile, tlsKeyFile, tlsReloadInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if err := configureClientAuth(config); err != nil {
+		return nil, nil, err
+	}
+	go reloader.watch()
+	return config, reloader, nil
+}
+
+// startRedirectListener 把HTTP请求重定向到HTTPS端口
+func startRedirectListener(httpsPort string) {
+	if tlsRedirectAddr == "" {
+		return
+	}
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	go func() {
+		logInfo("tls.redirect_start", "redirecting HTTP to HTTPS", "address", tlsRedirectAddr)
+		if err := http.ListenAndServe(tlsRedirectAddr, redirect); err != nil {
+			logError("tls.redirect_failed", "https redirect listener stopped", "address", tlsRedirectAddr, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert 测试用证书及其私钥，ca为nil时自签名
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64 = 1000
+
+func issueTestCert(t *testing.T, template *x509.Certificate, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	t.Helper()
+	return issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+}
+
+func newServerCert(t *testing.T, ca *testCert) *testCert {
+	t.Helper()
+	return issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "collector.test"},
+		DNSNames:    []string{"collector.test"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeTestCert 写入PEM证书和私钥，modTime用于让热加载识别文件变化
+func writeTestCert(t *testing.T, certFile, keyFile string, c *testCert, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(certFile, c.certPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// useTLSFiles 设置TLS相关配置，结束时恢复
+func useTLSFiles(t *testing.T, certFile, keyFile string, insecure bool) {
+	t.Helper()
+	cert, key, plain, minVersion, clientCA := tlsCertFile, tlsKeyFile, insecureHTTP, tlsMinVersion, tlsClientCAFile
+	tlsCertFile, tlsKeyFile, insecureHTTP = certFile, keyFile, insecure
+	t.Cleanup(func() {
+		tlsCertFile, tlsKeyFile, insecureHTTP, tlsMinVersion, tlsClientCAFile = cert, key, plain, minVersion, clientCA
+	})
+}
+
+// 证书缺失、不完整或与私钥不匹配时启动失败，只有INSECURE_HTTP=true才允许明文
+func TestServerTLSConfigFailsFast(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCert(t, certFile, keyFile, newServerCert(t, nil), time.Now())
+	other := newServerCert(t, nil)
+	otherKey := filepath.Join(dir, "other.key")
+	if err := os.WriteFile(otherKey, other.keyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		cert, key  string
+		insecure   bool
+		minVersion string
+		want       string
+	}{
+		{name: "no certificate", want: errTLSNotConfigured.Error()},
+		{name: "only certificate", cert: certFile, want: "both TLS_CERT_FILE and TLS_KEY_FILE"},
+		{name: "only certificate with insecure", cert: certFile, insecure: true, want: "both TLS_CERT_FILE and TLS_KEY_FILE"},
+		{name: "only key", key: keyFile, want: "both TLS_CERT_FILE and TLS_KEY_FILE"},
+		{name: "missing files", cert: filepath.Join(dir, "missing.crt"), key: filepath.Join(dir, "missing.key"), want: "load tls certificate"},
+		{name: "mismatched key", cert: certFile, key: otherKey, want: "private key does not match"},
+		{name: "unsupported min version", cert: certFile, key: keyFile, minVersion: "1.1", want: "TLS_MIN_VERSION"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTLSFiles(t, tc.cert, tc.key, tc.insecure)
+			if tc.minVersion != "" {
+				tlsMinVersion = tc.minVersion
+			}
+			config, reloader, err := serverTLSConfig()
+			reloader.Stop()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+			if config != nil || reloader != nil {
+				t.Fatal("config returned together with an error")
+			}
+		})
+	}
+
+	t.Run("insecure http", func(t *testing.T) {
+		useTLSFiles(t, "", "", true)
+		if config, reloader, err := serverTLSConfig(); config != nil || reloader != nil || err != nil {
+			t.Fatalf("INSECURE_HTTP=true: config %v, reloader %v, err %v", config, reloader, err)
+		}
+	})
+	t.Run("client ca without certificate", func(t *testing.T) {
+		useTLSFiles(t, "", "", true)
+		tlsClientCAFile = filepath.Join(dir, "ca.pem")
+		if _, _, err := serverTLSConfig(); err == nil || !strings.Contains(err.Error(), "TLS_CLIENT_CA_FILE") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
+
+// 服务端使用证书文件提供TLS，证书更新后的握手拿到新证书
+func TestCertReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first, second := newServerCert(t, nil), newServerCert(t, nil)
+	writeTestCert(t, certFile, keyFile, first, time.Now().Add(-time.Minute))
+	useTLSFiles(t, certFile, keyFile, false)
+
+	config, watcher, err := serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher.Stop()
+	if got := servedCertificate(t, config); got.SerialNumber.Cmp(first.cert.SerialNumber) != 0 {
+		t.Fatalf("served serial %v, want %v", got.SerialNumber, first.cert.SerialNumber)
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloader.changed() {
+		t.Fatal("unchanged files reported as changed")
+	}
+	writeTestCert(t, certFile, keyFile, second, time.Now())
+	if !reloader.changed() {
+		t.Fatal("rewritten files not detected")
+	}
+	if err := reloader.load(); err != nil {
+		t.Fatal(err)
+	}
+	config.GetCertificate = reloader.GetCertificate
+	if got := servedCertificate(t, config); got.SerialNumber.Cmp(second.cert.SerialNumber) != 0 {
+		t.Fatalf("after reload served serial %v, want %v", got.SerialNumber, second.cert.SerialNumber)
+	}
+
+	// 新文件无效时保留上一个证书
+	if err := os.WriteFile(keyFile, first.keyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.load(); err == nil {
+		t.Fatal("mismatched key reloaded")
+	}
+	if got := servedCertificate(t, config); got.SerialNumber.Cmp(second.cert.SerialNumber) != 0 {
+		t.Fatalf("failed reload replaced the certificate with serial %v", got.SerialNumber)
+	}
+}
+
+// 轮询间隔在创建时确定，watch检测到文件变化后热加载，Stop后退出
+func TestCertReloaderWatchStops(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first, second := newServerCert(t, nil), newServerCert(t, nil)
+	writeTestCert(t, certFile, keyFile, first, time.Now().Add(-time.Minute))
+	reloader, err := newCertReloader(certFile, keyFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		reloader.watch()
+	}()
+	t.Cleanup(reloader.Stop)
+
+	writeTestCert(t, certFile, keyFile, second, time.Now())
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		cert, _ := reloader.GetCertificate(nil)
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && leaf.SerialNumber.Cmp(second.cert.SerialNumber) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch did not reload the rewritten certificate")
+		}
+	}
+
+	reloader.Stop()
+	reloader.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch still running after Stop")
+	}
+}
+
+// servedCertificate 与config握手，返回服务端出示的证书
+func servedCertificate(t *testing.T, config *tls.Config) *x509.Certificate {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		tls.Server(serverConn, config).Handshake()
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil && !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("handshake: %v", err)
+	}
+	certs := client.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		t.Fatal("server presented no certificate")
+	}
+	return certs[0]
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// 链路追踪：基于OpenTelemetry SDK，设置 OTEL_EXPORTER_OTLP_TRACES_ENDPOINT（完整URL）或 OTEL_EXPORTER_OTLP_ENDPOINT
+// （如 http://otel-collector:4318，自动追加 /v1/traces）后，span经otlptracehttp批量上报。
+// 覆盖HTTP和gRPC请求、建立连接（拨号、握手、认证）、执行命令（打开会话、执行、读取输出）、SFTP传输和后台任务。
+// 请求头（gRPC为metadata）中的W3C traceparent/baggage会被沿用，响应头返回本次请求的traceparent。
+// 采样（OTEL_TRACES_SAMPLER、OTEL_TRACES_SAMPLER_ARG）、批量上报（OTEL_BSP_*）、上报请求头（OTEL_EXPORTER_OTLP_HEADERS）
+// 和服务名（OTEL_SERVICE_NAME，默认 go-ssh-collector）由SDK按标准环境变量读取。
+// span属性只有连接ID、主机、端口、协议等，不记录命令内容、用户名和凭据
+
+const (
+	spanKindInternal = trace.SpanKindInternal
+	spanKindServer   = trace.SpanKindServer
+	spanKindClient   = trace.SpanKindClient
+)
+
+var (
+	traceEndpoint   = otlpTracesEndpoint()
+	tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	// tracerProvider 未启用追踪时为nil
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+	traceExporter  *countingExporter
+)
+
+func otlpTracesEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimRight(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+func tracingEnabled() bool {
+	return tracerProvider != nil
+}
+
+// countingExporter 统计上报结果，供调试接口查看
+type countingExporter struct {
+	sdktrace.SpanExporter
+	exported int64
+	failed   int64
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		atomic.AddInt64(&e.failed, int64(len(spans)))
+		logWarn("trace.export_failed", "span export failed", "spans", len(spans), "error", err)
+		return err
+	}
+	atomic.AddInt64(&e.exported, int64(len(spans)))
+	return nil
+}
+
+func (e *countingExporter) stats() gin.H {
+	if e == nil {
+		return gin.H{"enabled": false}
+	}
+	return gin.H{"enabled": true, "exported": atomic.LoadInt64(&e.exported), "failed": atomic.LoadInt64(&e.failed)}
+}
+
+// traceResource 服务名和构建信息中的版本，OTEL_SERVICE_NAME和OTEL_RESOURCE_ATTRIBUTES优先
+func traceResource(ctx context.Context) (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{attribute.String("service.name", "go-ssh-collector")}
+	if version, ok := buildInfo["module_version"].(string); ok && version != "" {
+		attrs = append(attrs, attribute.String("service.version", version))
+	}
+	return resource.New(ctx, resource.WithAttributes(attrs...), resource.WithTelemetrySDK(), resource.WithFromEnv())
+}
+
+// setupTracing 在main开始时调用，未配置上报地址时不启用
+func setupTracing() {
+	if traceEndpoint == "" {
+		return
+	}
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		logError("trace.setup_failed", "tracing disabled", "error", err)
+		return
+	}
+	res, err := traceResource(ctx)
+	if err != nil {
+		logWarn("trace.resource_failed", "partial trace resource", "error", err)
+	}
+	traceExporter = &countingExporter{SpanExporter: exporter}
+	useTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res)))
+}
+
+func useTracerProvider(tp *sdktrace.TracerProvider) {
+	tracerProvider = tp
+	tracer = tp.Tracer("go-ssh-collector")
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(tracePropagator)
+}
+
+// flushTraces 退出前上报队列中剩余的span
+func flushTraces(ctx context.Context) {
+	if !tracingEnabled() {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logWarn("trace.flush_failed", "flush spans failed", "error", err)
+	}
+}
+
+// Span 一次操作，未启用追踪时为nil，所有方法都可在nil上调用
+type Span struct {
+	span trace.Span
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// withSpanFrom 把parent中的span带到ctx中，用于不随请求取消的后台任务
+func withSpanFrom(ctx, parent context.Context) context.Context {
+	if span := trace.SpanFromContext(parent); span.SpanContext().IsValid() {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// startSpan 创建ctx中span的子span，kv为交替的属性名和值
+func startSpan(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return startSpanKind(ctx, name, spanKindInternal, kv...)
+}
+
+func startSpanKind(ctx context.Context, name string, kind trace.SpanKind, kv ...interface{}) (context.Context, *Span) {
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(spanAttributes(kv)...))
+	return ctx, &Span{span: span}
+}
+
+// spanAttributes 空值和敏感字段不记录，字符串经redact处理
+func spanAttributes(kv []interface{}) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		if sensitiveKey(key) {
+			continue
+		}
+		switch v := kv[i+1].(type) {
+		case nil:
+		case string:
+			if v != "" {
+				attrs = append(attrs, attribute.String(key, redact(v)))
+			}
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(key, v))
+		case error:
+			attrs = append(attrs, attribute.String(key, redact(v.Error())))
+		default:
+			attrs = append(attrs, attribute.String(key, redact(fmt.Sprint(v))))
+		}
+	}
+	return attrs
+}
+
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil || !s.span.IsRecording() {
+		return
+	}
+	s.span.SetAttributes(spanAttributes(kv)...)
+}
+
+// End 结束span，err非空时状态为ERROR；成功时保持UNSET，与SDK的默认行为一致
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.SetStatus(codes.Error, redact(err.Error()))
+	}
+	s.span.End()
+}
+
+func (s *Span) Context() trace.SpanContext {
+	if s == nil {
+		return trace.SpanContext{}
+	}
+	return s.span.SpanContext()
+}
+
+// metadataCarrier 在gRPC metadata中读写传播字段
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if values := metadata.MD(m).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// tracingMiddleware 在requestIDMiddleware之后注册，为每个请求创建server span
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracingEnabled() {
+			c.Next()
+			return
+		}
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := startSpanKind(ctx, c.Request.Method+" "+route, spanKindServer,
+			"http.method", c.Request.Method,
+			"http.route", c.FullPath(),
+			"http.client_ip", c.ClientIP(),
+			"request_id", requestID(c),
+		)
+		if strings.HasPrefix(c.FullPath(), "/connections/:id") {
+			span.SetAttributes("connection_id", c.Param("id"))
+		}
+		c.Request = c.Request.WithContext(ctx)
+		// 响应头只返回traceparent，不回显调用方的baggage
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(c.Writer.Header()))
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes("http.status_code", status)
+		var err error
+		if status >= 500 {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+			if len(c.Errors) > 0 {
+				err = c.Errors.Last().Err
+			}
+		}
+		span.End(err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testTraceparent = "00-" + testTraceID + "-00f067aa0ba902b7-01"
+)
+
+// useTracing 启用追踪并把span同步写入内存，结束时恢复
+func useTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	previousProvider, previousTracer := tracerProvider, tracer
+	exporter := tracetest.NewInMemoryExporter()
+	useTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() {
+		tracerProvider.Shutdown(context.Background())
+		tracerProvider, tracer = previousProvider, previousTracer
+	})
+	return exporter
+}
+
+func spanAttribute(span tracetest.SpanStub, key string) (string, bool) {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value.Emit(), true
+		}
+	}
+	return "", false
+}
+
+func TestTracingMiddlewareContinuesIncomingTrace(t *testing.T) {
+	exporter := useTracing(t)
+	r := gin.New()
+	r.Use(requestIDMiddleware(), tracingMiddleware())
+	var child trace.SpanContext
+	r.GET("/connections/:id", func(c *gin.Context) {
+		_, span := startSpan(c.Request.Context(), "ssh.execute")
+		child = span.Context()
+		span.End(nil)
+		c.Error(errors.New("dial failed: password=hunter2"))
+		c.JSON(http.StatusBadGateway, gin.H{})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/connections/c1", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	req.Header.Set("baggage", "tenant=acme")
+	r.ServeHTTP(w, req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+	server := spans[1]
+	if server.SpanKind != trace.SpanKindServer || server.SpanContext.TraceID().String() != testTraceID || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span = %s kind %v parent %v", server.Name, server.SpanKind, server.Parent)
+	}
+	if child.TraceID().String() != testTraceID || spans[0].Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatalf("child span not parented to the request span")
+	}
+	if id, _ := spanAttribute(server, "connection_id"); id != "c1" {
+		t.Errorf("connection_id = %q", id)
+	}
+	if server.Status.Code != codes.Error || strings.Contains(server.Status.Description, "hunter2") {
+		t.Errorf("status = %+v", server.Status)
+	}
+
+	// 响应头返回本次请求的span，不回显baggage
+	if header := w.Header().Get("traceparent"); header != "00-"+testTraceID+"-"+server.SpanContext.SpanID().String()+"-01" {
+		t.Errorf("traceparent = %q", header)
+	}
+	if w.Header().Get("baggage") != "" {
+		t.Errorf("baggage echoed: %q", w.Header().Get("baggage"))
+	}
+}
+
+func TestSpanAttributesDropSecrets(t *testing.T) {
+	exporter := useTracing(t)
+	_, span := startSpan(context.Background(), "ssh.connect", "host", "r1", "port", 22, "password", "hunter2", "username", "")
+	span.SetAttributes("output", "Password: known-s3cr3t", "api_key", "key-123")
+	registerSecrets("tracing-test", "known-s3cr3t")
+	t.Cleanup(func() { releaseSecrets("tracing-test") })
+	span.SetAttributes("banner", "known-s3cr3t")
+	span.End(nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("spans = %d", len(spans))
+	}
+	for _, attr := range spans[0].Attributes {
+		value := attr.Value.Emit()
+		if strings.Contains(value, "hunter2") || strings.Contains(value, "known-s3cr3t") || strings.Contains(value, "key-123") {
+			t.Errorf("%s = %q leaked", attr.Key, value)
+		}
+	}
+	for _, key := range []string{"password", "api_key", "username"} {
+		if _, ok := spanAttribute(spans[0], key); ok {
+			t.Errorf("attribute %s recorded", key)
+		}
+	}
+	if port, _ := spanAttribute(spans[0], "port"); port != "22" {
+		t.Errorf("port = %q", port)
+	}
+	if spans[0].Status.Code != codes.Unset {
+		t.Errorf("status = %+v", spans[0].Status)
+	}
+}
+
+// headerStream 记录gRPC处理函数设置的响应头
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestGRPCSpanUsesMetadata(t *testing.T) {
+	exporter := useTracing(t)
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("traceparent", testTraceparent))
+
+	_, span := startGRPCSpan(ctx, "/collector.v1.Collector/Execute")
+	span.End(nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].SpanContext.TraceID().String() != testTraceID || spans[0].SpanKind != trace.SpanKindServer {
+		t.Fatalf("spans = %+v", spans)
+	}
+	if got := stream.header.Get("traceparent"); len(got) != 1 || !strings.Contains(got[0], spans[0].SpanContext.SpanID().String()) {
+		t.Fatalf("response traceparent = %v", got)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	previous := tracerProvider
+	tracerProvider = nil
+	t.Cleanup(func() { tracerProvider = previous })
+
+	ctx, span := startSpan(context.Background(), "noop", "host", "r1")
+	if span != nil || spanFromContext(ctx) != nil {
+		t.Fatal("span created while tracing is disabled")
+	}
+	span.SetAttributes("host", "r1")
+	span.End(errors.New("ignored"))
+	if span.Context().IsValid() {
+		t.Fatal("nil span has a valid context")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	transferBufferSize = 32 * 1024
+	// 每写出flushInterval字节刷新一次响应，避免代理层堆积
+	flushInterval = 1024 * 1024
+)
+
+var errTransferTooLarge = errors.New("transfer exceeds maximum allowed size")
+
+// 服务端传输限制，0表示不限制
+var (
+	maxTransferBytes = envInt64("MAX_TRANSFER_BYTES", 0)
+	maxTransferRate  = envInt64("MAX_TRANSFER_RATE", 0)
+)
+
+func envInt64(name string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// effectiveRate 合并请求的限速与服务端上限，取较严格者
+func effectiveRate(requested int64) int64 {
+	if maxTransferRate > 0 && (requested <= 0 || requested > maxTransferRate) {
+		return maxTransferRate
+	}
+	return requested
+}
+
+type copyOptions struct {
+	// RateLimit 每秒字节数，0表示不限速
+	RateLimit int64
+	Progress  *TransferProgress
+}
+
+// copyStream 使用固定大小缓冲区复制数据，支持限速、周期刷新和取消，
+// 内存占用与传输大小无关
+func copyStream(ctx context.Context, dst io.Writer, src io.Reader, opts copyOptions) (int64, error) {
+	buf := make([]byte, transferBufferSize)
+	flusher, _ := dst.(http.Flusher)
+	start := time.Now()
+
+	var written, unflushed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			w, err := dst.Write(buf[:n])
+			written += int64(w)
+			unflushed += int64(w)
+			if opts.Progress != nil {
+				opts.Progress.Add(int64(w))
+			}
+			if err != nil {
+				return written, err
+			}
+			if w != n {
+				return written, io.ErrShortWrite
+			}
+
+			if flusher != nil && unflushed >= flushInterval {
+				flusher.Flush()
+				unflushed = 0
+			}
+
+			if opts.RateLimit > 0 {
+				expected := time.Duration(float64(written) / float64(opts.RateLimit) * float64(time.Second))
+				if wait := expected - time.Since(start); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return written, ctx.Err()
+					case <-timer.C:
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
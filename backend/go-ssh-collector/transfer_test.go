@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fillReader 产生n字节数据，不分配内存；n<0时不结束
+type fillReader struct{ n int64 }
+
+func (r *fillReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if r.n > 0 && int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	if r.n > 0 {
+		r.n -= int64(len(p))
+	}
+	return len(p), nil
+}
+
+// flushRecorder 只计数，不保存写入的数据
+type flushRecorder struct {
+	written int64
+	flushes int
+	onWrite func(total int64)
+}
+
+func (w *flushRecorder) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.onWrite != nil {
+		w.onWrite(w.written)
+	}
+	return len(p), nil
+}
+
+func (w *flushRecorder) Flush() { w.flushes++ }
+
+func TestCopyStreamMemoryStaysFlat(t *testing.T) {
+	const size = 256 << 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	dst := &flushRecorder{}
+	written, err := copyStream(context.Background(), dst, &fillReader{n: size}, copyOptions{})
+	runtime.ReadMemStats(&after)
+
+	if err != nil || written != size || dst.written != size {
+		t.Fatalf("copied %d (%d) bytes: %v", written, dst.written, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4*transferBufferSize {
+		t.Fatalf("copying %d bytes allocated %d bytes", size, allocated)
+	}
+	if want := size / flushInterval; dst.flushes < want {
+		t.Fatalf("flushes = %d, want at least %d", dst.flushes, want)
+	}
+}
+
+func TestCopyStreamRateLimit(t *testing.T) {
+	const size, rate = 96 * 1024, 256 * 1024
+	started := time.Now()
+	if _, err := copyStream(context.Background(), io.Discard, &fillReader{n: size}, copyOptions{RateLimit: rate}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, want := time.Since(started), time.Duration(float64(size)/rate*float64(time.Second)); elapsed < want*8/10 {
+		t.Fatalf("copy took %v, rate limit implies at least %v", elapsed, want)
+	}
+}
+
+func TestEffectiveRateUsesStricterLimit(t *testing.T) {
+	defer func(rate int64) { maxTransferRate = rate }(maxTransferRate)
+	maxTransferRate = 1000
+	for requested, want := range map[int64]int64{0: 1000, 500: 500, 5000: 1000} {
+		if got := effectiveRate(requested); got != want {
+			t.Errorf("effectiveRate(%d) = %d, want %d", requested, got, want)
+		}
+	}
+}
+
+// 客户端断开时请求上下文被取消，复制在下一个缓冲区边界停止
+func TestCopyStreamStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dst := &flushRecorder{onWrite: func(total int64) {
+		if total >= 10*transferBufferSize {
+			cancel()
+		}
+	}}
+	written, err := copyStream(ctx, dst, &fillReader{n: -1}, copyOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if written > 11*transferBufferSize {
+		t.Fatalf("kept copying after cancel: %d bytes", written)
+	}
+}
+
+func TestCopyStreamStopsOnReadError(t *testing.T) {
+	src := &interruptingReader{r: nil, after: 0, interrupt: func() {}}
+	if _, err := copyStream(context.Background(), io.Discard, src, copyOptions{}); err == nil {
+		t.Fatal("copy succeeded after the source failed")
+	}
+}
+
+func downloadRequest(t *testing.T, id, remotePath string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	r := gin.New()
+	registerFileRoutes(r)
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path="+remotePath, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadEnforcesMaxTransferSize(t *testing.T) {
+	defer func(n int64) { maxTransferBytes = n }(maxTransferBytes)
+	maxTransferBytes = 4096
+
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(remotePath, testPayload(10000), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := downloadRequest(t, id, remotePath, nil); w.Code != http.StatusRequestEntityTooLarge || w.Body.Len() > 1024 {
+		t.Fatalf("status = %d with %d body bytes, want 413 before streaming", w.Code, w.Body.Len())
+	}
+	// 在上限内的区间仍可下载
+	w := downloadRequest(t, id, remotePath, http.Header{"Range": {"bytes=0-4095"}})
+	if w.Code != http.StatusPartialContent || w.Body.Len() != 4096 {
+		t.Fatalf("range within limit: status = %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestDownloadStreamsWholeFile(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "capture.pcap")
+	payload := testPayload(3*flushInterval + 999)
+	if err := os.WriteFile(remotePath, payload, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := downloadRequest(t, id, remotePath, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(len(payload)) {
+		t.Fatalf("status = %d, Content-Length = %q", w.Code, w.Header().Get("Content-Length"))
+	}
+	if w.Body.Len() != len(payload) || string(w.Body.Bytes()) != string(payload) {
+		t.Fatalf("downloaded %d bytes, want %d", w.Body.Len(), len(payload))
+	}
+}
+
+// cancellingWriter 收到一定字节后取消请求上下文，模拟客户端断开
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	after  int
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	if w.Body.Len()+len(p) >= w.after {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestDownloadAbortsWhenClientDisconnects(t *testing.T) {
+	server := startTestSSHServer(t)
+	id := connectTestSSH(t, server)
+	remotePath := filepath.Join(t.TempDir(), "capture.pcap")
+	payload := testPayload(64 * transferBufferSize)
+	if err := os.WriteFile(remotePath, payload, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	registerFileRoutes(r)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/connections/"+id+"/files/download?path="+remotePath, nil).WithContext(ctx)
+	w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), after: 4 * transferBufferSize, cancel: cancel}
+	r.ServeHTTP(w, req)
+
+	if w.Body.Len() >= len(payload) || w.Body.Len() > 6*transferBufferSize {
+		t.Fatalf("sent %d of %d bytes after the client went away", w.Body.Len(), len(payload))
+	}
+	// 中止后连接仍可继续使用
+	if w := downloadRequest(t, id, remotePath, http.Header{"Range": {"bytes=0-9"}}); w.Code != http.StatusPartialContent {
+		t.Fatalf("connection unusable after abort: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmespath/go-jmespath"
+)
+
+// JMESPath后处理：对解析结果做最终的筛选和变形，结果写入result
+
+var errNoParsedOutput = errors.New("no parsed output to transform")
+
+// 编译后的表达式缓存上限，超出时整体清空
+var transformCacheSize = int(envInt64("TRANSFORM_CACHE_SIZE", 1024))
+
+type transformCache struct {
+	compiled map[string]*jmespath.JMESPath
+	mutex    sync.Mutex
+}
+
+var transforms = &transformCache{compiled: make(map[string]*jmespath.JMESPath)}
+
+func (tc *transformCache) Compile(expression string) (*jmespath.JMESPath, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if compiled, ok := tc.compiled[expression]; ok {
+		return compiled, nil
+	}
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	if transformCacheSize > 0 && len(tc.compiled) >= transformCacheSize {
+		tc.compiled = make(map[string]*jmespath.JMESPath)
+	}
+	tc.compiled[expression] = compiled
+	return compiled, nil
+}
+
+// Transform 对任意值求值。解析器输出为Go结构体，先按JSON标签转换为通用结构，
+// 表达式中的字段名与API返回一致
+func (tc *transformCache) Transform(expression string, data interface{}) (interface{}, error) {
+	compiled, err := tc.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return compiled.Search(document)
+}
+
+// applyTransform 表达式错误记录在TransformError中，保留未变形的parsed
+func applyTransform(result *CommandResult, expression string) {
+	if result.Parsed == nil {
+		result.TransformError = errNoParsedOutput.Error()
+		return
+	}
+	value, err := transforms.Transform(expression, result.Parsed)
+	if err != nil {
+		result.TransformError = err.Error()
+		return
+	}
+	result.Result = value
+}
+
+func registerTransformRoutes(r *gin.Engine) {
+	// 用样例文档调试表达式
+	r.POST("/transform/test", func(c *gin.Context) {
+		var body struct {
+			Expression string      `json:"expression" binding:"required"`
+			Data       interface{} `json:"data"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		value, err := transforms.Transform(body.Expression, body.Data)
+		if err != nil {
+			respondError(c, http.StatusUnprocessableEntity, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"result": value})
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 版本信息：构建时通过 -ldflags 注入，例如
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.gitCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// 未注入时版本为 dev，提交和构建时间取自Go记录的VCS信息，仍没有时为 unknown。
+// GET /version、/health、collector_build_info 指标和启动日志使用相同的字段
+
+var (
+	version   = "dev"
+	gitCommit = ""
+	buildDate = ""
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	// Modified 构建时工作区有未提交的修改，只在Go记录了VCS信息时可知
+	Modified  bool     `json:"modified,omitempty"`
+	Protocols []string `json:"protocols"`
+	Features  []string `json:"features"`
+}
+
+// currentVersion 协议和功能在启动配置完成后才确定，每次调用时读取
+func currentVersion() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Protocols: registeredProtocols(),
+		Features:  enabledFeatures(),
+	}
+	if info.Commit == "" {
+		info.Commit, _ = buildInfo["revision"].(string)
+		info.Modified, _ = buildInfo["modified"].(bool)
+	}
+	if info.BuildDate == "" {
+		info.BuildDate, _ = buildInfo["build_time"].(string)
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// enabledFeatures 按当前配置启用的可选功能
+func enabledFeatures() []string {
+	features := []string{}
+	for _, feature := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"auth", authEnabled()},
+		{"tls", tlsCertFile != ""},
+		{"mtls", tlsClientCAFile != ""},
+		{"grpc", grpcPort != ""},
+		{"admin_listener", len(adminListenAddrs) > 0},
+		{"audit", auditLog != nil},
+		{"syslog_forward", syslogForwarder != nil},
+		{"result_store", resultStore != nil},
+		{"result_cache", resultCache != nil},
+		{"kafka_sink", kafkaSink != nil},
+		{"nats_sink", natsSink != nil},
+		{"syslog_listener", os.Getenv("SYSLOG_UDP_ADDR") != "" || os.Getenv("SYSLOG_TCP_ADDR") != ""},
+		{"snmp_traps", os.Getenv("SNMP_TRAP_ADDR") != ""},
+		{"tracing", otlpTracesEndpoint() != ""},
+		{"debug_endpoints", debugEndpointsEnabled},
+		{"compression", compressionEnabled},
+		{"parser_plugins", parserPluginDir != ""},
+		{"agent_forwarding", !agentForwardingDisabled},
+	} {
+		if feature.enabled {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
+
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("version=%s commit=%s build_date=%s go=%s protocols=%s features=%s",
+		v.Version, v.Commit, v.BuildDate, v.GoVersion, strings.Join(v.Protocols, ","), strings.Join(v.Features, ","))
+}
+
+// writeBuildInfoMetric 值恒为1，信息在标签中
+func writeBuildInfoMetric(w io.Writer) {
+	v := currentVersion()
+	out := metricWriter{w}
+	out.family("collector_build_info", "gauge", "Build and configuration of the running collector.")
+	out.sample("collector_build_info", 1, "version", v.Version, "commit", v.Commit, "build_date", v.BuildDate,
+		"go_version", v.GoVersion, "protocols", strings.Join(v.Protocols, ","), "features", strings.Join(v.Features, ","))
+}
+
+func registerVersionRoutes(r *gin.Engine) {
+	r.GET("/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentVersion())
+	})
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	ntlmssp "github.com/Azure/go-ntlmssp"
+)
+
+const (
+	wsmanShellURI    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	wsmanActionBase  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/"
+	wsmanCreate      = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	wsmanDelete      = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	wsmanTerminate   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	wsmanStateDone   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+	wsmanTimedOut    = "2150858793"
+	wsmanMaxEnvelope = 512000
+	// Receive轮询的服务端等待时间
+	wsmanOperationTimeout = 20 * time.Second
+)
+
+var errWinRMAuth = errors.New("winrm authentication failed")
+
+// WinRMConnection 通过WS-Management远程shell执行命令，每次执行创建独立的shell
+type WinRMConnection struct {
+	Config    SSHConfig
+	CreatedAt time.Time
+
+	endpoint string
+	client   *http.Client
+	password string
+}
+
+type wsmanFault struct {
+	Code    string `xml:"Body>Fault>Detail>WSManFault>Code,attr"`
+	Message string `xml:"Body>Fault>Detail>WSManFault>Message"`
+	Reason  string `xml:"Body>Fault>Reason>Text"`
+}
+
+type winrmCollector struct{}
+
+func init() {
+	registerCollector(winrmCollector{})
+}
+
+func (winrmCollector) Protocol() string { return "winrm" }
+
+func (winrmCollector) Connect(config SSHConfig) (Connection, error) {
+	if config.Port == 0 {
+		config.Port = 5985
+		if config.HTTPS {
+			config.Port = 5986
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+
+	scheme := "http"
+	if config.HTTPS {
+		scheme = "https"
+	}
+	var transport http.RoundTripper = &http.Transport{
+		DialContext:           safeDialer(time.Duration(config.Timeout) * time.Second).DialContext,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: config.SkipVerify},
+		ResponseHeaderTimeout: wsmanOperationTimeout + time.Duration(config.Timeout)*time.Second,
+	}
+	switch config.AuthMethod {
+	case "", "basic":
+	case "ntlm":
+		transport = ntlmssp.Negotiator{RoundTripper: transport}
+	default:
+		return nil, fmt.Errorf("unsupported auth method: %s", config.AuthMethod)
+	}
+
+	conn := &WinRMConnection{
+		Config:    config,
+		CreatedAt: time.Now(),
+		endpoint:  fmt.Sprintf("%s://%s:%d/wsman", scheme, config.Host, config.Port),
+		client:    &http.Client{Transport: transport},
+		password:  config.Password,
+	}
+
+	// 创建并删除一个shell以验证连通性与凭据
+	if err := conn.HealthCheck(); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (w *WinRMConnection) Info() ConnectionInfo {
+	return ConnectionInfo{
+		Protocol:   "winrm",
+		DeviceType: w.Config.DeviceType,
+		Host:       w.Config.Host,
+		Port:       w.Config.Port,
+		Username:   w.Config.Username,
+		CreatedAt:  w.CreatedAt,
+	}
+}
+
+// HealthCheck 创建并删除一个shell
+func (w *WinRMConnection) HealthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.Config.Timeout)*time.Second)
+	defer cancel()
+	shellID, err := w.createShell(ctx)
+	if err != nil {
+		return err
+	}
+	w.deleteShell(shellID)
+	return nil
+}
+
+// Close WinRM每次执行使用独立shell，无需关闭长连接
+func (w *WinRMConnection) Close() error {
+	return nil
+}
+
+func (w *WinRMConnection) envelope(action, shellID, options, body string) string {
+	var sb strings.Builder
+	sb.WriteString(`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"`)
+	sb.WriteString(` xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"`)
+	sb.WriteString(` xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"`)
+	sb.WriteString(` xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><env:Header>`)
+	sb.WriteString(`<a:To>` + w.endpoint + `</a:To>`)
+	sb.WriteString(`<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`)
+	sb.WriteString(fmt.Sprintf(`<w:MaxEnvelopeSize env:mustUnderstand="true">%d</w:MaxEnvelopeSize>`, wsmanMaxEnvelope))
+	sb.WriteString(`<a:MessageID>uuid:` + newID() + `</a:MessageID>`)
+	sb.WriteString(`<w:Locale xml:lang="en-US" env:mustUnderstand="false"/>`)
+	sb.WriteString(fmt.Sprintf(`<w:OperationTimeout>PT%dS</w:OperationTimeout>`, int(wsmanOperationTimeout.Seconds())))
+	sb.WriteString(`<w:ResourceURI env:mustUnderstand="true">` + wsmanShellURI + `</w:ResourceURI>`)
+	sb.WriteString(`<a:Action env:mustUnderstand="true">` + action + `</a:Action>`)
+	if shellID != "" {
+		sb.WriteString(`<w:SelectorSet><w:Selector Name="ShellId">` + shellID + `</w:Selector></w:SelectorSet>`)
+	}
+	if options != "" {
+		sb.WriteString(`<w:OptionSet>` + options + `</w:OptionSet>`)
+	}
+	sb.WriteString(`</env:Header><env:Body>` + body + `</env:Body></env:Envelope>`)
+	return sb.String()
+}
+
+// send 发送SOAP请求；返回SOAP Fault时带上设备给出的错误信息
+func (w *WinRMConnection) send(ctx context.Context, payload string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, strings.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.SetBasicAuth(w.Config.Username, w.password)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errWinRMAuth
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault wsmanFault
+		if xml.Unmarshal(data, &fault) == nil && (fault.Message != "" || fault.Reason != "") {
+			if fault.Code == wsmanTimedOut {
+				return nil, errWinRMOperationTimeout
+			}
+			msg := strings.TrimSpace(fault.Message)
+			if msg == "" {
+				msg = strings.TrimSpace(fault.Reason)
+			}
+			return nil, fmt.Errorf("winrm fault: %s", msg)
+		}
+		return nil, fmt.Errorf("winrm request failed: %s", resp.Status)
+	}
+	return data, nil
+}
+
+// 服务端在OperationTimeout内没有新输出时返回此错误，需继续轮询
+var errWinRMOperationTimeout = errors.New("winrm operation timeout")
+
+func (w *WinRMConnection) createShell(ctx context.Context) (string, error) {
+	options := `<w:Option Name="WINRS_NOPROFILE">TRUE</w:Option><w:Option Name="WINRS_CODEPAGE">65001</w:Option>`
+	body := `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`
+	data, err := w.send(ctx, w.envelope(wsmanCreate, "", options, body))
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ShellID string `xml:"Body>Shell>ShellId"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil || resp.ShellID == "" {
+		return "", fmt.Errorf("invalid create shell response")
+	}
+	return resp.ShellID, nil
+}
+
+func (w *WinRMConnection) deleteShell(shellID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w.send(ctx, w.envelope(wsmanDelete, shellID, "", ""))
+}
+
+func (w *WinRMConnection) startCommand(ctx context.Context, shellID, command string) (string, error) {
+	options := `<w:Option Name="WINRS_CONSOLEMODE_STDIN">TRUE</w:Option><w:Option Name="WINRS_SKIP_CMD_SHELL">FALSE</w:Option>`
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(command))
+	body := `<rsp:CommandLine><rsp:Command>` + escaped.String() + `</rsp:Command></rsp:CommandLine>`
+
+	data, err := w.send(ctx, w.envelope(wsmanActionBase+"Command", shellID, options, body))
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		CommandID string `xml:"Body>CommandResponse>CommandId"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil || resp.CommandID == "" {
+		return "", fmt.Errorf("invalid command response")
+	}
+	return resp.CommandID, nil
+}
+
+type wsmanReceive struct {
+	Streams []struct {
+		Name  string `xml:"Name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Body>ReceiveResponse>Stream"`
+	State struct {
+		State    string `xml:"State,attr"`
+		ExitCode int    `xml:"ExitCode"`
+	} `xml:"Body>ReceiveResponse>CommandState"`
+}
+
+// receive 拉取一次输出，返回命令是否结束及退出码
+func (w *WinRMConnection) receive(ctx context.Context, shellID, commandID string, stdout, stderr io.Writer) (bool, int, error) {
+	body := `<rsp:Receive><rsp:DesiredStream CommandId="` + commandID + `">stdout stderr</rsp:DesiredStream></rsp:Receive>`
+	data, err := w.send(ctx, w.envelope(wsmanActionBase+"Receive", shellID, "", body))
+	if errors.Is(err, errWinRMOperationTimeout) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	var resp wsmanReceive
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return false, 0, fmt.Errorf("invalid receive response: %v", err)
+	}
+	for _, stream := range resp.Streams {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Value))
+		if err != nil {
+			continue
+		}
+		if stream.Name == "stderr" {
+			stderr.Write(decoded)
+		} else {
+			stdout.Write(decoded)
+		}
+	}
+	return resp.State.State == wsmanStateDone, resp.State.ExitCode, nil
+}
+
+func (w *WinRMConnection) terminate(shellID, commandID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body := `<rsp:Signal CommandId="` + commandID + `"><rsp:Code>` + wsmanTerminate + `</rsp:Code></rsp:Signal>`
+	w.send(ctx, w.envelope(wsmanActionBase+"Signal", shellID, "", body))
+}
+
+// encodePowerShell 将脚本编码为UTF-16LE的base64，通过-EncodedCommand传递避免转义问题
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[i*2:], u)
+	}
+	return "powershell.exe -NoProfile -NonInteractive -EncodedCommand " + base64.StdEncoding.EncodeToString(buf)
+}
+
+// Execute 运行cmd或PowerShell命令，超时与输出上限与SSH执行一致
+func (w *WinRMConnection) Execute(shell, command string) (*CommandResult, error) {
+	commandLine := command
+	switch shell {
+	case "", "cmd":
+	case "powershell":
+		commandLine = encodePowerShell(command)
+	default:
+		return nil, fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shellID, err := w.createShell(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shell: %v", err)
+	}
+	defer w.deleteShell(shellID)
+
+	commandID, err := w.startCommand(ctx, shellID, commandLine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	stdout := newCappedBuffer(maxCommandOutput)
+	stderr := newCappedBuffer(maxCommandOutput)
+	exitCode := 0
+	err = waitCommand(ctx, func() error {
+		for {
+			done, code, err := w.receive(ctx, shellID, commandID, stdout, stderr)
+			if err != nil {
+				return err
+			}
+			if done {
+				exitCode = code
+				return nil
+			}
+		}
+	}, func() {
+		cancel()
+		w.terminate(shellID, commandID)
+	}, commandTimeout)
+
+	result := &CommandResult{
+		Command:   command,
+		Output:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.Truncated() || stderr.Truncated(),
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.ExitCode = &exitCode
+		if exitCode != 0 {
+			result.Error = fmt.Sprintf("exit status %d", exitCode)
+		}
+	}
+	return result, nil
+}
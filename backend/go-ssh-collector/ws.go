@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket执行通道：GET /execute/ws 升级后，客户端发送
+//   {"type":"execute","id":"1","connection_id":"...","command":"...","stream":true, ...其它/execute字段}
+//   {"type":"cancel","id":"1"}
+// 服务端按完成顺序异步返回
+//   {"type":"chunk","id":"1","output":"..."}      stream为true时的SSH输出片段（已脱敏）
+//   {"type":"result","id":"1","result":{...}}     与 POST /execute 的响应相同
+//   {"type":"error","id":"1","code":"POLICY_DENIED","error":"...","status":403}  code与REST错误响应相同
+//   {"type":"cancelled","id":"1"}
+// 认证在升级时完成，浏览器无法设置请求头时可在子协议中携带 "bearer.<token>"（同时请求 collector.v1）。
+// 连接关闭时终止所有进行中的SSH命令
+
+const wsSubprotocol = "collector.v1"
+
+var (
+	// 单个连接上同时执行的命令数，超出时返回429
+	wsMaxConcurrency = int(envInt64("WS_MAX_CONCURRENCY", 8))
+	// 超过该时间（秒）未收到pong视为断开
+	wsPongTimeout = time.Duration(envInt64("WS_PONG_TIMEOUT", 60)) * time.Second
+	wsWriteWait   = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	Subprotocols: []string{wsSubprotocol},
+	CheckOrigin:  wsCheckOrigin,
+}
+
+// wsCheckOrigin 配置了CORS来源时按其放行，否则只允许同源；非浏览器客户端不带Origin
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || containsString(corsAllowedOrigins, "*") || containsString(corsAllowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// websocketCredential 取子协议中的 bearer.<token>
+func websocketCredential(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(protocol, "bearer.") {
+			return strings.TrimPrefix(protocol, "bearer.")
+		}
+	}
+	return ""
+}
+
+type WSRequest struct {
+	Type string `json:"type"`
+	// ID 由客户端分配，用于关联响应和取消，同一连接上进行中的ID不能重复
+	ID     string `json:"id"`
+	Stream bool   `json:"stream"`
+	CommandRequest
+}
+
+type WSResponse struct {
+	Type   string      `json:"type"`
+	ID     string      `json:"id,omitempty"`
+	Output string      `json:"output,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+	Code   string      `json:"code,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// ErrorClass 与REST错误响应相同
+	ErrorClass string `json:"error_class,omitempty"`
+	// Details 与REST错误响应的details相同，限流时带retry_after
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	// Status 为REST接口在相同情况下返回的HTTP状态码
+	Status int `json:"status,omitempty"`
+}
+
+type wsSession struct {
+	conn      *websocket.Conn
+	principal *Principal
+	version   *APIVersion
+	// 限流按升级请求的客户端IP和认证通过的身份计数
+	clientIP  string
+	clientKey string
+	// 每条消息的请求ID为 "<升级请求ID>/<消息id>"
+	requestID string
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	inflight map[string]context.CancelFunc
+	mutex    sync.Mutex
+	// gorilla/websocket 不支持并发写
+	writeMutex sync.Mutex
+}
+
+func (s *wsSession) send(msg WSResponse) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *wsSession) sendError(id string, status int, err error, details map[string]interface{}) {
+	requestID := s.requestID
+	if id != "" {
+		requestID += "/" + id
+	}
+	status, details = errorDetails(status, err, details)
+	apiErr := newAPIError(status, err, details, requestID)
+	s.send(WSResponse{Type: "error", ID: id, Code: apiErr.Code, Error: apiErr.Message, ErrorClass: apiErr.ErrorClass,
+		Details: apiErr.Details, RequestID: apiErr.RequestID, Status: status})
+}
+
+// serve 读取消息直到连接关闭，返回前取消并等待所有进行中的命令
+func (s *wsSession) serve() {
+	defer func() {
+		s.cancel()
+		s.wg.Wait()
+		s.conn.Close()
+	}()
+
+	s.conn.SetReadLimit(maxRequestBody)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go s.keepalive()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var req WSRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.sendError("", http.StatusBadRequest, err, nil)
+			continue
+		}
+		switch req.Type {
+		case "", "execute":
+			s.execute(req)
+		case "cancel":
+			s.mutex.Lock()
+			if cancel, ok := s.inflight[req.ID]; ok {
+				cancel()
+			}
+			s.mutex.Unlock()
+		default:
+			s.sendError(req.ID, http.StatusBadRequest, errors.New("unknown message type: "+req.Type), nil)
+		}
+	}
+}
+
+func (s *wsSession) keepalive() {
+	ticker := time.NewTicker(wsPongTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.writeMutex.Lock()
+			s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			s.writeMutex.Unlock()
+		}
+	}
+}
+
+// execute 做与 POST /execute 相同的检查后在后台执行
+func (s *wsSession) execute(req WSRequest) {
+	if req.ID == "" {
+		s.sendError("", http.StatusBadRequest, errors.New("id is required"), nil)
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req.CommandRequest); err != nil {
+		s.sendError(req.ID, http.StatusBadRequest, err, nil)
+		return
+	}
+	if p := s.principal; p != nil {
+		if !connectionACLs.Allowed(p, req.ConnectionID) {
+			auditDenied(withRequestID(s.ctx, s.requestID+"/"+req.ID), p, "WS", "/execute/ws", permExecute, "connection not shared with caller")
+			s.audit(req, http.StatusForbidden, errors.New("connection not shared with caller"), nil, time.Now())
+			s.sendError(req.ID, http.StatusForbidden, errors.New("connection not shared with caller"), nil)
+			return
+		}
+		if req.Unmasked && !p.Can(permAdmin) {
+			auditDenied(withRequestID(s.ctx, s.requestID+"/"+req.ID), p, "WS", "/execute/ws", permAdmin, "unmasked output requires admin")
+			s.audit(req, http.StatusForbidden, errors.New("unmasked output requires admin"), nil, time.Now())
+			s.sendError(req.ID, http.StatusForbidden, errors.New("unmasked output requires admin"), nil)
+			return
+		}
+	}
+	if scope, wait := rateLimiter.Allow("POST /execute", s.clientIP, s.clientKey); scope != "" {
+		s.sendError(req.ID, http.StatusTooManyRequests, errors.New("rate limit exceeded"),
+			map[string]interface{}{"scope": scope, "retry_after": math.Ceil(wait.Seconds())})
+		return
+	}
+
+	s.mutex.Lock()
+	if _, exists := s.inflight[req.ID]; exists {
+		s.mutex.Unlock()
+		s.sendError(req.ID, http.StatusConflict, errors.New("id already in flight"), nil)
+		return
+	}
+	select {
+	case s.sem <- struct{}{}:
+	default:
+		s.mutex.Unlock()
+		s.sendError(req.ID, http.StatusTooManyRequests, fmt.Errorf("too many in-flight commands (max %d)", wsMaxConcurrency), nil)
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.inflight[req.ID] = cancel
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.inflight, req.ID)
+			s.mutex.Unlock()
+			cancel()
+			<-s.sem
+			s.wg.Done()
+		}()
+
+		cmd := req.CommandRequest
+		cmd.ctx = ctx
+		cmd.requestID = s.requestID + "/" + req.ID
+		if req.Stream {
+			cmd.stream = func(chunk string) {
+				s.send(WSResponse{Type: "chunk", ID: req.ID, Output: chunk})
+			}
+		}
+		started := time.Now()
+		result, err := collector.Execute(cmd)
+		status := http.StatusOK
+		if err != nil {
+			status = executeErrorStatus(err)
+		}
+		// 与REST相同，先写审计记录再发出结果
+		s.audit(req, status, err, result, started)
+		switch {
+		case ctx.Err() != nil:
+			// 连接已关闭时写入会失败，忽略
+			s.send(WSResponse{Type: "cancelled", ID: req.ID})
+		case err != nil:
+			s.sendError(req.ID, executeErrorStatus(err), withErrorCode(CodeCommandFailed, err), nil)
+		default:
+			s.send(WSResponse{Type: "result", ID: req.ID, Result: s.version.CommandResult(result, req.Unmasked)})
+		}
+	}()
+}
+
+// audit 每条命令写入一条审计记录
+func (s *wsSession) audit(req WSRequest, status int, err error, result *CommandResult, started time.Time) {
+	if auditLog == nil {
+		return
+	}
+	entry := AuditEntry{
+		RequestID:    s.requestID + "/" + req.ID,
+		SourceIP:     s.clientIP,
+		Interface:    "websocket",
+		Operation:    "WS /execute/ws",
+		ConnectionID: req.ConnectionID,
+		Host:         auditConnectionHost(req.ConnectionID),
+		Command:      req.Command,
+		DurationMS:   float64(time.Since(started).Microseconds()) / 1000,
+	}
+	entry.setPrincipal(s.principal)
+	entry.setError(status, err)
+	entry.setResult(result)
+	auditLog.Record(entry)
+}
+
+func registerWebSocketRoutes(r *gin.Engine) {
+	r.GET("/execute/ws", func(c *gin.Context) {
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// Upgrade已写入错误响应
+			return
+		}
+		// 会话中的命令作为升级请求span的子span
+		ctx, cancel := context.WithCancel(withSpanFrom(context.Background(), c.Request.Context()))
+		session := &wsSession{
+			conn:      conn,
+			principal: currentPrincipal(c),
+			version:   requestAPIVersion(c),
+			clientIP:  c.ClientIP(),
+			clientKey: rateLimitIdentity(currentPrincipal(c)),
+			requestID: requestID(c),
+			ctx:       ctx,
+			cancel:    cancel,
+			sem:       make(chan struct{}, wsMaxConcurrency),
+			inflight:  make(map[string]context.CancelFunc),
+		}
+		session.serve()
+	})
+}
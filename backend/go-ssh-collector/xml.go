@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// XML解析：NETCONF应答及 | display xml 输出转换为JSON友好结构，xpath时只返回匹配的节点
+
+// 解析时读取超过该大小即中止，不会先载入整个文档
+var parseXMLMaxBytes = envInt64("PARSE_XML_MAX_BYTES", 16<<20)
+
+var errXMLTooLarge = errors.New("XML output too large")
+
+// xmlLimitReader 流式解析的输入，读取超过上限时返回errXMLTooLarge
+type xmlLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newXMLLimitReader(r io.Reader) *xmlLimitReader {
+	return &xmlLimitReader{r: r, remaining: parseXMLMaxBytes}
+}
+
+func (l *xmlLimitReader) Read(p []byte) (int, error) {
+	// 多读一个字节以区分恰好达到上限和超过上限
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: exceeds %d bytes", errXMLTooLarge, parseXMLMaxBytes)
+	}
+	return n, err
+}
+
+type xmlElement struct {
+	key    string
+	fields map[string]interface{}
+	text   strings.Builder
+}
+
+// value 无属性和子元素的元素为文本，否则为对象，混合内容的文本放在 #text
+func (e *xmlElement) value() interface{} {
+	text := strings.TrimSpace(e.text.String())
+	if len(e.fields) == 0 {
+		return text
+	}
+	if text != "" {
+		e.fields["#text"] = text
+	}
+	return e.fields
+}
+
+// add 同名子元素合并为数组
+func (e *xmlElement) add(key string, value interface{}) {
+	existing, ok := e.fields[key]
+	if !ok {
+		e.fields[key] = value
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		e.fields[key] = append(list, value)
+		return
+	}
+	e.fields[key] = []interface{}{existing, value}
+}
+
+func xmlKey(name xml.Name, namespaces string) string {
+	if namespaces == "prefix" && name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// xmlDocument 去掉XML前后的横幅和提示符（如Junos的 {master}）以及NETCONF 1.0的结束标记 ]]>]]>
+func xmlDocument(text string) (string, error) {
+	text, _, _ = strings.Cut(text, "]]>]]>")
+	start, end := strings.Index(text, "<"), strings.LastIndex(text, ">")
+	if start < 0 || end < start {
+		return "", errors.New("no XML document found")
+	}
+	return text[start : end+1], nil
+}
+
+// xmlToValue 逐个读取token构建结果，不生成完整DOM，输入超过大小上限时中止。
+// attributes: prefix(默认，键为@name) / merge / ignore；namespaces: strip(默认) / prefix
+func xmlToValue(r io.Reader, attributes, namespaces string) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(newXMLLimitReader(r))
+	// RawToken保留前缀，开闭标签是否匹配由stack检查
+	root := &xmlElement{fields: make(map[string]interface{})}
+	stack := []*xmlElement{root}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &xmlElement{key: xmlKey(t.Name, namespaces), fields: make(map[string]interface{})}
+			for _, attr := range t.Attr {
+				isNamespace := attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns")
+				if attributes == "ignore" || (isNamespace && namespaces != "prefix") {
+					continue
+				}
+				key := xmlKey(attr.Name, namespaces)
+				if attributes != "merge" {
+					key = "@" + key
+				}
+				element.fields[key] = attr.Value
+			}
+			stack = append(stack, element)
+		case xml.EndElement:
+			if len(stack) == 1 || top.key != xmlKey(t.Name, namespaces) {
+				return nil, fmt.Errorf("unexpected end element </%s>", xmlKey(t.Name, namespaces))
+			}
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].add(top.key, top.value())
+		case xml.CharData:
+			if len(stack) > 1 {
+				top.text.Write(t)
+			}
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unclosed element <%s>", stack[len(stack)-1].key)
+	}
+	if len(root.fields) == 0 {
+		return nil, errors.New("no XML element found")
+	}
+	return root.fields, nil
+}
+
+// ParseXML 转换XML输出，xpath不为空时返回匹配节点的列表
+func ParseXML(text string, opts *ParseOptions) (interface{}, error) {
+	document, err := xmlDocument(text)
+	if err != nil {
+		return nil, err
+	}
+	if opts.XPath == "" {
+		return xmlToValue(strings.NewReader(document), opts.XMLAttributes, opts.XMLNamespaces)
+	}
+
+	// XPath需要DOM，同样经过大小上限，超过时在读取过程中中止
+	doc, err := xmlquery.Parse(newXMLLimitReader(strings.NewReader(document)))
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := xmlquery.QueryAll(doc, opts.XPath)
+	if err != nil {
+		return nil, fmt.Errorf("xpath: %v", err)
+	}
+	matched := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Type != xmlquery.ElementNode {
+			// 属性和文本节点取其文本
+			matched = append(matched, node.InnerText())
+			continue
+		}
+		value, err := xmlToValue(strings.NewReader(node.OutputXML(true)), opts.XMLAttributes, opts.XMLNamespaces)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range value {
+			matched = append(matched, v)
+		}
+	}
+	return matched, nil
+}
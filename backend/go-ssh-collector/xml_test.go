@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/xml下的设备输出(.raw)按不同选项解析，期望结果为 <用例名>.json，-update 重新生成
+func TestParseXMLFixtures(t *testing.T) {
+	cases := []struct {
+		name, fixture string
+		opts          ParseOptions
+	}{
+		{"junos_get_config", "junos_get_config", ParseOptions{}},
+		{"junos_get_config_prefix", "junos_get_config", ParseOptions{XMLNamespaces: "prefix", XMLAttributes: "merge"}},
+		{"junos_get_config_interfaces", "junos_get_config", ParseOptions{XPath: "//configuration/interfaces/interface"}},
+		{"junos_display_xml", "junos_display_xml", ParseOptions{XMLAttributes: "ignore"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata", "xml", tc.fixture+".raw"))
+			if err != nil {
+				t.Fatalf("missing fixture: %v", err)
+			}
+			value, err := ParseXML(string(raw), &tc.opts)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			got, err := json.MarshalIndent(value, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFile := filepath.Join("testdata", "xml", tc.name+".json")
+			if *updateFixtures {
+				if err := os.WriteFile(expectedFile, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("missing expected output: %v", err)
+			}
+			assertSameJSON(t, got, expected)
+		})
+	}
+}
+
+// Junos的默认命名空间和junos:前缀下XPath按本地名匹配，属性和文本节点返回文本
+func TestParseXMLXPath(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "xml", "junos_get_config.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		xpath string
+		want  string
+	}{
+		{"//system/host-name", `["edge-mx-01"]`},
+		{"//interfaces/interface/name/text()", `["ge-0/0/0","ge-0/0/1","lo0"]`},
+		{"//interface[@inactive='inactive']/name", `["ge-0/0/1"]`},
+		{"//interface[disable]/unit/family/inet/address/name", `["198.51.100.1/24"]`},
+		{"//name-server[2]/name", `["10.0.1.53"]`},
+		{"//configuration/@junos:changed-seconds", `["1696234512"]`},
+		{"//interface[name='lo0']/unit", `[{"family":{"inet":{"address":{"name":"203.0.113.1/32"}}},"name":"0"}]`},
+		{"//interface[name='xe-9/9/9']", `[]`},
+		{"//interface[", ``},
+	}
+	for _, tc := range cases {
+		value, err := ParseXML(string(raw), &ParseOptions{XPath: tc.xpath})
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tc.xpath, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.xpath, err)
+			continue
+		}
+		got, _ := json.Marshal(value)
+		if string(got) != tc.want {
+			t.Errorf("%s = %s, want %s", tc.xpath, got, tc.want)
+		}
+	}
+}
+
+func TestParseXMLErrors(t *testing.T) {
+	for _, text := range []string{
+		"{master}\nadmin@mx> ",
+		"<a><b></a>",
+		"<a><b>",
+		"<?xml version=\"1.0\"?>",
+	} {
+		if value, err := ParseXML(text, &ParseOptions{}); err == nil {
+			t.Errorf("%q: expected an error, got %v", text, value)
+		}
+	}
+}
+
+// xmlStream 无限生成 <item>...</item>，记录被读取的字节数
+type xmlStream struct {
+	read    int64
+	started bool
+}
+
+func (s *xmlStream) Read(p []byte) (int, error) {
+	chunk := "<item><name>ge-0/0/0</name></item>"
+	if !s.started {
+		chunk, s.started = "<rpc-reply>", true
+	}
+	n := copy(p, chunk)
+	s.read += int64(n)
+	return n, nil
+}
+
+// 超过大小上限时在读取过程中中止，读取量不超过上限加上解码缓冲
+func TestParseXMLSizeCapAbortsStreaming(t *testing.T) {
+	previous := parseXMLMaxBytes
+	parseXMLMaxBytes = 64 << 10
+	t.Cleanup(func() { parseXMLMaxBytes = previous })
+
+	stream := &xmlStream{}
+	if _, err := xmlToValue(stream, "", ""); !errors.Is(err, errXMLTooLarge) {
+		t.Fatalf("err = %v, want errXMLTooLarge", err)
+	}
+	if stream.read > parseXMLMaxBytes+4096 {
+		t.Fatalf("read %d bytes with a %d byte cap", stream.read, parseXMLMaxBytes)
+	}
+
+	// XPath同样在载入DOM的过程中中止
+	huge := "<rpc-reply>" + strings.Repeat("<item><name>ge-0/0/0</name></item>", 4096) + "</rpc-reply>"
+	for _, opts := range []ParseOptions{{}, {XPath: "//item/name"}} {
+		if _, err := ParseXML(huge, &opts); !errors.Is(err, errXMLTooLarge) {
+			t.Errorf("xpath %q: err = %v, want errXMLTooLarge", opts.XPath, err)
+		}
+	}
+
+	// 恰好达到上限的文档可以解析
+	exact := "<a>" + strings.Repeat("x", int(parseXMLMaxBytes)-len("<a></a>")) + "</a>"
+	if _, err := xmlToValue(strings.NewReader(exact), "", ""); err != nil {
+		t.Fatalf("document at the cap: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// YAML支持：下列GET接口在 Accept: application/yaml 时把JSON响应转换为YAML，字段名与JSON相同；
+// 配置类接口接受 Content-Type: application/yaml 的请求体，先转换为JSON再按原有结构绑定和校验，
+// 校验错误与JSON请求相同。未指定时仍使用JSON
+
+var yamlMediaTypes = []string{"application/yaml", "application/x-yaml", "text/yaml"}
+
+// 支持YAML响应的接口
+var yamlResponseRoutes = map[string]bool{
+	"GET /connections":            true,
+	"GET /connections/:id/acl":    true,
+	"GET /connections/:id/health": true,
+	"GET /connections/:id/stats":  true,
+	"GET /results/:id":            true,
+	"GET /jobs":                   true,
+	"GET /jobs/:id":               true,
+	"GET /parsers":                true,
+	"GET /parsers/:name":          true,
+}
+
+// 接受YAML请求体的接口
+var yamlRequestRoutes = map[string]bool{
+	"POST /connect":            true,
+	"POST /http/endpoints":     true,
+	"POST /execute/bulk":       true,
+	"PUT /parsers/:name":       true,
+	"POST /parsers/:name/test": true,
+	"POST /drivers":            true,
+}
+
+func isYAMLMediaType(mediaType string) bool {
+	return containsString(yamlMediaTypes, mediaType)
+}
+
+// wantsYAML 只有Accept中YAML优先于JSON时才返回YAML
+func wantsYAML(c *gin.Context) bool {
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if isYAMLMediaType(mediaType) {
+			return true
+		}
+		if mediaType == "application/json" || mediaType == "*/*" {
+			return false
+		}
+	}
+	return false
+}
+
+// yamlToJSON 转换请求体，YAML中的非字符串键和多文档不被支持
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("invalid yaml: %v", err)
+	}
+	return json.Marshal(value)
+}
+
+// jsonToYAML 整数不经过float64，避免大数精度丢失
+func jsonToYAML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(yamlNumbers(value)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), encoder.Close()
+}
+
+// yamlNumbers 把json.Number转换为int64或float64，否则会被编码为字符串
+func yamlNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = yamlNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlNumbers(item)
+		}
+	}
+	return value
+}
+
+// yamlWriter 缓存响应体，处理结束后再决定是否转换
+type yamlWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *yamlWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *yamlWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// yamlMiddleware 在bodyLimitMiddleware之后注册，请求体已经过大小限制
+func yamlMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+
+		if yamlRequestRoutes[route] && c.Request.Body != nil {
+			mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			if isYAMLMediaType(mediaType) {
+				data, err := io.ReadAll(c.Request.Body)
+				if err != nil {
+					respondError(c, http.StatusBadRequest, err)
+					return
+				}
+				converted, err := yamlToJSON(data)
+				if err != nil {
+					respondError(c, http.StatusBadRequest, err)
+					return
+				}
+				c.Request.Body = io.NopCloser(bytes.NewReader(converted))
+				c.Request.ContentLength = int64(len(converted))
+				c.Request.Header.Set("Content-Type", "application/json")
+			}
+		}
+
+		if !yamlResponseRoutes[route] || !wantsYAML(c) {
+			c.Next()
+			return
+		}
+		original := c.Writer
+		writer := &yamlWriter{ResponseWriter: original}
+		c.Writer = writer
+		c.Next()
+		c.Writer = original
+
+		body := writer.body.Bytes()
+		if strings.HasPrefix(original.Header().Get("Content-Type"), "application/json") {
+			if converted, err := jsonToYAML(body); err == nil {
+				original.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+				body = converted
+			}
+		}
+		original.Header().Del("Content-Length")
+		original.Write(body)
+	}
+}